@@ -0,0 +1,101 @@
+// Command contract checks that the library reads yt-dlp's output without
+// problems, so a new yt-dlp release that changes it is caught before it
+// blanks metadata in the library:
+//
+//	yt-dlp -j URL | go run ./cmd/contract
+//	go run ./cmd/contract NAME.info.json...
+//
+// Each video is decoded strictly and leniently, and both must succeed
+// without problems. It exits non-zero when a check fails. Sidecars
+// recorded in internal/library/testdata/ytdlp are checked against what
+// they should decode to by go test ./internal/library.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"noahjalex.ute/internal/library"
+)
+
+func main() {
+	flag.Parse()
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	failed, checked := 0, 0
+	for _, path := range paths {
+		docs, err := readInput(path)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for i, data := range docs {
+			name := path
+			if len(docs) > 1 {
+				name = fmt.Sprintf("%s #%d", path, i+1)
+			}
+			checked++
+			if problems := check(data); len(problems) > 0 {
+				failed++
+				fmt.Printf("--- FAIL: %s\n", name)
+				for _, p := range problems {
+					fmt.Printf("    %s\n", p)
+				}
+			}
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d videos\n", failed, checked)
+		os.Exit(1)
+	}
+	fmt.Printf("ok: %d videos\n", checked)
+}
+
+// check decodes data strictly and leniently, describing where either fails
+func check(data []byte) []string {
+	var problems []string
+	if _, _, err := library.DecodeVideoMetadata(data, false); err != nil {
+		problems = append(problems, "strict: "+err.Error())
+	}
+	_, issues, err := library.DecodeVideoMetadata(data, true)
+	if err != nil {
+		return append(problems, "lenient: "+err.Error())
+	}
+	for _, issue := range issues {
+		problems = append(problems, "lenient: "+issue)
+	}
+	return problems
+}
+
+// readInput reads the JSON documents in a file, or stdin for "-": one
+// for an .info.json, or a line per video from yt-dlp -j
+func readInput(path string) ([]json.RawMessage, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var docs []json.RawMessage
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		docs = append(docs, doc)
+	}
+}
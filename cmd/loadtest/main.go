@@ -0,0 +1,343 @@
+// Command loadtest drives a running server with many concurrent download
+// submissions, list queries and video streams, then reports each
+// operation's throughput and latency, so changes to the queue and stores
+// can be compared before and after. Point it at a test instance: the
+// downloads it submits are real jobs, canceled again when it finishes.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// operation is one kind of request the load is made of
+type operation struct {
+	name   string
+	weight int
+	run    func(ctx context.Context) error
+}
+
+// stats are one operation's results
+type stats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+	lastErr   string
+}
+
+func (s *stats) record(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, d)
+	if err != nil {
+		s.errors++
+		s.lastErr = err.Error()
+	}
+}
+
+// loadTest holds what the operations share
+type loadTest struct {
+	server   string
+	token    string
+	client   *http.Client
+	urlTmpl  string
+	priority string
+	streamKB int
+	// run makes this run's download URLs unique, so the server doesn't
+	// match them to jobs from an earlier run
+	run string
+	n   atomic.Int64
+
+	// files are the videos to stream, listed before the run starts
+	files []video
+
+	mu     sync.Mutex
+	jobIDs []string
+}
+
+// video is a library file to stream
+type video struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8591", "URL of the server to load")
+	token := flag.String("token", os.Getenv("UTE_TOKEN"), "user token to sign in with, when the server has users (default from UTE_TOKEN env)")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	concurrency := flag.Int("concurrency", 16, "number of clients sending requests at once")
+	mix := flag.String("mix", "submit=1,jobs=4,videos=4,queue=1,stream=2", "weights of the operations: submit, jobs, videos, queue and stream")
+	urlTmpl := flag.String("url", "https://example.com/loadtest/{n}.mp4", "download URL to submit, with {n} replaced by a unique number")
+	priority := flag.String("priority", "low", "priority of the submitted downloads")
+	streamKB := flag.Int("stream-kb", 1024, "kilobytes read from a video per stream request, from a random offset")
+	keep := flag.Bool("keep", false, "leave the submitted downloads queued instead of canceling them at the end")
+	flag.Parse()
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	lt := &loadTest{
+		server:   strings.TrimRight(*server, "/"),
+		token:    *token,
+		client:   &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}},
+		urlTmpl:  *urlTmpl,
+		priority: *priority,
+		streamKB: *streamKB,
+		run:      hex.EncodeToString(b),
+	}
+
+	ops, err := lt.operations(*mix)
+	if err != nil {
+		log.Fatalf("mix: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := lt.loadFiles(ctx); err != nil {
+		log.Fatalf("listing videos to stream: %v", err)
+	}
+	if len(lt.files) == 0 {
+		ops = dropOperation(ops, "stream")
+		log.Printf("The library is empty, so no videos are streamed")
+	}
+	if len(ops) == 0 {
+		log.Fatalf("mix: no operations left to run")
+	}
+
+	results := make(map[string]*stats, len(ops))
+	total := 0
+	for _, op := range ops {
+		results[op.name] = &stats{}
+		total += op.weight
+	}
+
+	log.Printf("Loading %s with %d clients for %v", lt.server, *concurrency, *duration)
+	runCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	started := time.Now()
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for runCtx.Err() == nil {
+				op := pick(ops, total)
+				t := time.Now()
+				err := op.run(runCtx)
+				if runCtx.Err() != nil {
+					// Cut off by the end of the run, not a failure
+					return
+				}
+				results[op.name].record(time.Since(t), err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	report(os.Stdout, ops, results, elapsed)
+	if !*keep {
+		lt.cancelJobs(context.Background())
+	}
+}
+
+// operations parses mix into the operations to run
+func (lt *loadTest) operations(mix string) ([]operation, error) {
+	available := map[string]func(ctx context.Context) error{
+		"submit": lt.submit,
+		"jobs":   func(ctx context.Context) error { return lt.get(ctx, "/api/jobs", nil) },
+		"videos": func(ctx context.Context) error { return lt.get(ctx, "/api/videos", nil) },
+		"queue":  func(ctx context.Context) error { return lt.get(ctx, "/api/queue", nil) },
+		"stream": lt.stream,
+	}
+	var ops []operation
+	for _, part := range strings.Split(mix, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q should be name=weight", part)
+		}
+		run, known := available[name]
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q, expected submit, jobs, videos, queue or stream", name)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("weight of %s must be a whole number of at least 0", name)
+		}
+		if w > 0 {
+			ops = append(ops, operation{name: name, weight: w, run: run})
+		}
+	}
+	return ops, nil
+}
+
+func dropOperation(ops []operation, name string) []operation {
+	var kept []operation
+	for _, op := range ops {
+		if op.name != name {
+			kept = append(kept, op)
+		}
+	}
+	return kept
+}
+
+// pick chooses an operation at random by weight
+func pick(ops []operation, total int) operation {
+	n := mathrand.IntN(total)
+	for _, op := range ops {
+		if n < op.weight {
+			return op
+		}
+		n -= op.weight
+	}
+	return ops[len(ops)-1]
+}
+
+// do sends a request, failing on error responses. The body is read to the
+// end, or into out when it isn't nil.
+func (lt *loadTest) do(ctx context.Context, method, path string, body any, header http.Header, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, lt.server+path, r)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if lt.token != "" {
+		req.Header.Set("Authorization", "Bearer "+lt.token)
+	}
+	resp, err := lt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+func (lt *loadTest) get(ctx context.Context, path string, out any) error {
+	return lt.do(ctx, "GET", path, nil, nil, out)
+}
+
+// submit queues a download of a URL no earlier request used
+func (lt *loadTest) submit(ctx context.Context) error {
+	n := strconv.FormatInt(lt.n.Add(1), 10)
+	link := strings.ReplaceAll(lt.urlTmpl, "{n}", lt.run+"-"+n)
+	var resp struct {
+		JobID string `json:"job_id"`
+	}
+	if err := lt.do(ctx, "POST", "/", map[string]string{"link": link, "priority": lt.priority}, nil, &resp); err != nil {
+		return err
+	}
+	lt.mu.Lock()
+	lt.jobIDs = append(lt.jobIDs, resp.JobID)
+	lt.mu.Unlock()
+	return nil
+}
+
+// stream reads part of a random video from a random offset, as a player
+// seeking would
+func (lt *loadTest) stream(ctx context.Context) error {
+	v := lt.files[mathrand.IntN(len(lt.files))]
+	chunk := int64(lt.streamKB) << 10
+	start := int64(0)
+	if v.Size > chunk {
+		start = mathrand.Int64N(v.Size - chunk + 1)
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+chunk-1))
+	path := "/videos/" + (&url.URL{Path: v.Filename}).EscapedPath()
+	return lt.do(ctx, "GET", path, nil, header, nil)
+}
+
+// loadFiles lists the library's videos for stream to pick from
+func (lt *loadTest) loadFiles(ctx context.Context) error {
+	return lt.get(ctx, "/api/videos", &lt.files)
+}
+
+// cancelJobs cancels the downloads the run submitted
+func (lt *loadTest) cancelJobs(ctx context.Context) {
+	if len(lt.jobIDs) == 0 {
+		return
+	}
+	log.Printf("Canceling the %d downloads submitted", len(lt.jobIDs))
+	failed := 0
+	for _, id := range lt.jobIDs {
+		if err := lt.do(ctx, "POST", "/api/jobs/"+id+"/cancel", nil, nil, nil); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d downloads couldn't be canceled, most likely as they had already finished", failed)
+	}
+}
+
+// report writes a line per operation: its request count, errors, rate
+// and latency percentiles
+func report(w io.Writer, ops []operation, results map[string]*stats, elapsed time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\trequests\terrors\treq/s\tp50\tp90\tp99\tmax\t")
+	var lastErrs []string
+	for _, op := range ops {
+		s := results[op.name]
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		n := len(s.latencies)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n", op.name, n, s.errors,
+			float64(n)/elapsed.Seconds(),
+			percentile(s.latencies, 0.50), percentile(s.latencies, 0.90),
+			percentile(s.latencies, 0.99), percentile(s.latencies, 1))
+		if s.lastErr != "" {
+			lastErrs = append(lastErrs, fmt.Sprintf("%s: %s", op.name, s.lastErr))
+		}
+	}
+	tw.Flush()
+	for _, e := range lastErrs {
+		fmt.Fprintf(w, "last error of %s\n", e)
+	}
+}
+
+// percentile is the latency below which the share p of sorted fall
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return sorted[i].Round(10 * time.Microsecond)
+}
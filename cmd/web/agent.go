@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+)
+
+const (
+	// agentReportEvery is the least time between an agent's progress
+	// reports, and agentHeartbeat the most; the server's agent timeout
+	// must be well above the latter
+	agentReportEvery = 2 * time.Second
+	agentHeartbeat   = 10 * time.Second
+	// agentRetryWait is how long an agent waits after failing to reach
+	// the server before asking again
+	agentRetryWait = 5 * time.Second
+)
+
+// errTaskGone is returned when the server no longer wants a task, such as
+// after its job was canceled
+var errTaskGone = errors.New("task no longer wanted")
+
+// agentClient talks to the server an agent pulls downloads from
+type agentClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// do sends a request to path on the server, decoding a JSON answer into
+// out when it isn't nil. It returns errTaskGone on 410 Gone and reports
+// whether the server answered 204 No Content.
+func (c *agentClient) do(ctx context.Context, method, path string, body io.Reader, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return false, errTaskGone
+	case resp.StatusCode == http.StatusNoContent:
+		return true, nil
+	case resp.StatusCode >= 300:
+		var e ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != nil {
+			return false, fmt.Errorf("%s %s: %s", method, path, e.Error.Message)
+		}
+		return false, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		return false, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return false, nil
+}
+
+func (c *agentClient) post(ctx context.Context, path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "POST", path, bytes.NewReader(data), nil)
+	return err
+}
+
+// runAgent pulls downloads from the server at serverURL until ctx ends,
+// running them with downloaders and uploading what they fetch. Tasks are
+// downloaded under the data directory's agent folder. tlsConfig holds the
+// agent's client certificate, for servers requiring one.
+func runAgent(ctx context.Context, cfg config.Config, downloaders *downloader.Registry, serverURL, token string, tlsConfig *tls.Config) {
+	if token == "" {
+		log.Fatal("agent: a token is required, from -agent-token or UTE_AGENT_TOKEN")
+	}
+	if _, err := url.ParseRequestURI(serverURL); err != nil {
+		log.Fatalf("agent: invalid server URL %q: %v", serverURL, err)
+	}
+	c := &agentClient{
+		server: strings.TrimSuffix(serverURL, "/"),
+		token:  token,
+		// Longer than the server holds a poll open
+		client: &http.Client{
+			Timeout:   agentPollTimeout + 30*time.Second,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}
+	dir := filepath.Join(cfg.DataDir, "agent")
+	// Left over from a task interrupted by a restart
+	os.RemoveAll(dir)
+
+	log.Printf("Pulling downloads from %s", c.server)
+	for ctx.Err() == nil {
+		var spec agentTaskSpec
+		none, err := c.do(ctx, "POST", "/api/agent/tasks", nil, &spec)
+		if err != nil {
+			log.Printf("Failed to ask %s for work: %v", c.server, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(agentRetryWait):
+			}
+			continue
+		}
+		if none {
+			continue
+		}
+		runAgentTask(ctx, c, downloaders, dir, spec)
+	}
+}
+
+// agentReporter sends a task's progress and log to the server, at most
+// every agentReportEvery and at least every agentHeartbeat
+type agentReporter struct {
+	mu       sync.Mutex
+	progress agentProgress
+	log      bytes.Buffer
+	changed  bool
+}
+
+func (r *agentReporter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed = true
+	return r.log.Write(p)
+}
+
+func (r *agentReporter) update(p downloader.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed = true
+	r.progress.Percent = p.Percent
+	r.progress.TotalBytes = p.TotalBytes
+	r.progress.Speed = p.Speed
+	r.progress.ETASeconds = p.ETA.Seconds()
+}
+
+// take returns the report to send, taking the log written since the last
+// one, and whether anything changed
+func (r *agentReporter) take() (agentProgress, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.progress
+	p.Log = r.log.String()
+	r.log.Reset()
+	changed := r.changed
+	r.changed = false
+	return p, changed
+}
+
+// send reports on the task until ctx ends, canceling the download when the
+// server no longer wants it
+func (r *agentReporter) send(ctx context.Context, c *agentClient, id string, cancel context.CancelFunc) {
+	tick := time.NewTicker(agentReportEvery)
+	defer tick.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		p, changed := r.take()
+		if !changed && time.Since(last) < agentHeartbeat {
+			continue
+		}
+		err := c.post(ctx, "/api/agent/tasks/"+id+"/progress", p)
+		if errors.Is(err, errTaskGone) {
+			log.Printf("Stopping task %s, which the server no longer wants", id)
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to report on task %s: %v", id, err)
+			continue
+		}
+		last = time.Now()
+	}
+}
+
+// runAgentTask downloads spec into its own folder under dir, then uploads
+// the files and tells the server how it went
+func runAgentTask(ctx context.Context, c *agentClient, downloaders *downloader.Registry, dir string, spec agentTaskSpec) {
+	log.Printf("Downloading %s for job %s", spec.URL, spec.JobID)
+	workDir := filepath.Join(dir, spec.ID)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		log.Printf("Failed to create %s: %v", workDir, err)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	req := downloader.Request{
+		URL:            spec.URL,
+		Format:         spec.Format,
+		OutputTemplate: spec.Output,
+		OutputDir:      workDir,
+		ExtraArgs:      spec.Args,
+		Proxy:          spec.Proxy,
+		PlaylistEnd:    spec.PlaylistEnd,
+		PlaylistItems:  spec.Items,
+		DateAfter:      spec.DateAfter,
+		DateBefore:     spec.DateBefore,
+		MaxFileSize:    spec.MaxFileSize,
+		Section:        spec.Section,
+		Languages:      spec.Languages,
+		Filter:         spec.Filter,
+		Site:           downloader.SiteOptions{ExtractorArgs: spec.Extractor, Impersonate: spec.Impersonate},
+	}
+	if spec.Cookies != "" {
+		// Beside the work folder, so it isn't uploaded with the download
+		req.Cookies = workDir + ".cookies"
+		if err := os.WriteFile(req.Cookies, []byte(spec.Cookies), 0600); err != nil {
+			log.Printf("Failed to write the cookies for task %s: %v", spec.ID, err)
+		}
+		defer os.Remove(req.Cookies)
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reporter := &agentReporter{}
+	req.Progress = reporter.update
+	req.Log = reporter
+	reporting, stopReporting := context.WithCancel(taskCtx)
+	done := make(chan struct{})
+	go func() {
+		reporter.send(reporting, c, spec.ID, cancel)
+		close(done)
+	}()
+
+	result, downloadErr := handleVideoDownload(taskCtx, req, downloaders)
+	stopReporting()
+	<-done
+	if taskCtx.Err() != nil {
+		return
+	}
+	// What the backend printed since the last report
+	if p, changed := reporter.take(); changed {
+		c.post(ctx, "/api/agent/tasks/"+spec.ID+"/progress", p)
+	}
+
+	res := agentResult{Error: downloadErr}
+	if downloadErr == nil {
+		res.Backend, res.Output = result.Backend, result.Output
+		files, err := uploadAgentFiles(ctx, c, spec.ID, workDir)
+		if errors.Is(err, errTaskGone) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to upload task %s: %v", spec.ID, err)
+			res.Error = &apperr.DownloadError{
+				Type:    apperr.TypeNetwork,
+				Message: "The agent failed to upload the download",
+				Details: err.Error(),
+				Code:    http.StatusBadGateway,
+			}
+		}
+		for _, file := range result.Files {
+			if rel, err := filepath.Rel(workDir, file); err == nil && files[filepath.ToSlash(rel)] {
+				res.Files = append(res.Files, filepath.ToSlash(rel))
+			}
+		}
+	}
+	if err := c.post(ctx, "/api/agent/tasks/"+spec.ID+"/result", res); err != nil {
+		log.Printf("Failed to report the result of task %s: %v", spec.ID, err)
+		return
+	}
+	if res.Error != nil {
+		log.Printf("Download for job %s failed: %s", spec.JobID, res.Error.Message)
+		return
+	}
+	log.Printf("Uploaded %s for job %s", spec.URL, spec.JobID)
+}
+
+// uploadAgentFiles sends every file the task wrote to the server, leaving
+// out partial fragments, and returns their paths relative to workDir
+func uploadAgentFiles(ctx context.Context, c *agentClient, id, workDir string) (map[string]bool, error) {
+	uploaded := make(map[string]bool)
+	err := filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || library.IsFragment(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		escaped := make([]string, 0, strings.Count(name, "/")+1)
+		for _, part := range strings.Split(name, "/") {
+			escaped = append(escaped, url.PathEscape(part))
+		}
+		// No client timeout, as large files take as long as they take
+		upload := &agentClient{server: c.server, token: c.token, client: &http.Client{Transport: c.client.Transport}}
+		if _, err := upload.do(ctx, "PUT", "/api/agent/tasks/"+id+"/files/"+strings.Join(escaped, "/"), f, nil); err != nil {
+			return err
+		}
+		uploaded[name] = true
+		return nil
+	})
+	return uploaded, err
+}
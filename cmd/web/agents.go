@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+)
+
+// agentPollTimeout is how long an agent's request for work is held open
+// when there is none
+const agentPollTimeout = 30 * time.Second
+
+// agentTaskSpec is a download attempt as sent to an agent
+type agentTaskSpec struct {
+	ID          string              `json:"id"`
+	JobID       string              `json:"job_id"`
+	URL         string              `json:"url"`
+	Format      string              `json:"format,omitempty"`
+	Output      string              `json:"output_template,omitempty"`
+	Args        []string            `json:"args,omitempty"`
+	Proxy       string              `json:"proxy,omitempty"`
+	PlaylistEnd int                 `json:"playlist_end,omitempty"`
+	Items       string              `json:"playlist_items,omitempty"`
+	DateAfter   string              `json:"date_after,omitempty"`
+	DateBefore  string              `json:"date_before,omitempty"`
+	MaxFileSize int64               `json:"max_filesize,omitempty"`
+	Section     *downloader.Section `json:"section,omitempty"`
+	Languages   []string            `json:"languages,omitempty"`
+	Filter      *downloader.Filter  `json:"filter,omitempty"`
+	Extractor   map[string]string   `json:"extractor_args,omitempty"`
+	Impersonate string              `json:"impersonate,omitempty"`
+	// Cookies is the content of the cookie file for the site, when the
+	// download needs a signed-in account
+	Cookies string `json:"cookies,omitempty"`
+}
+
+// agentProgress is an agent's report on a task it is running
+type agentProgress struct {
+	Percent    float64 `json:"percent"`
+	TotalBytes int64   `json:"total_bytes"`
+	Speed      int64   `json:"speed"`
+	ETASeconds float64 `json:"eta_seconds"`
+	// Log is what the backend printed since the last report
+	Log string `json:"log,omitempty"`
+}
+
+// agentResult is how a task ended: the files the agent uploaded, relative
+// to the task, or the error the download failed with
+type agentResult struct {
+	Backend string                `json:"backend"`
+	Files   []string              `json:"files"`
+	Output  string                `json:"output,omitempty"`
+	Error   *apperr.DownloadError `json:"error,omitempty"`
+}
+
+// agentTask is a download attempt handed to an agent
+type agentTask struct {
+	spec    agentTaskSpec
+	agent   string
+	workDir string
+	req     downloader.Request
+	// ctx is the job's, canceled when the job is
+	ctx  context.Context
+	done chan agentResult
+
+	mu   sync.Mutex
+	seen time.Time
+}
+
+func (t *agentTask) touch() {
+	t.mu.Lock()
+	t.seen = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *agentTask) lastSeen() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seen
+}
+
+// agentWaiter is an agent waiting for a task
+type agentWaiter struct {
+	name string
+	ch   chan *agentTask
+}
+
+// agentHub hands download attempts to the agents asking for work
+type agentHub struct {
+	mu sync.Mutex
+	// idle are the agents waiting for a task, longest waiting first
+	idle  []*agentWaiter
+	tasks map[string]*agentTask
+	// arrived is closed and replaced whenever an agent starts waiting
+	arrived chan struct{}
+}
+
+// wait registers an agent looking for work and returns its waiter with a
+// channel that is closed when the next agent arrives
+func (h *agentHub) wait(name string) *agentWaiter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w := &agentWaiter{name: name, ch: make(chan *agentTask, 1)}
+	h.idle = append(h.idle, w)
+	if h.arrived != nil {
+		close(h.arrived)
+		h.arrived = nil
+	}
+	return w
+}
+
+// leave stops w waiting, returning a task handed to it meanwhile
+func (h *agentHub) leave(w *agentWaiter) *agentTask {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.idle = slices.DeleteFunc(h.idle, func(o *agentWaiter) bool { return o == w })
+	select {
+	case t := <-w.ch:
+		return t
+	default:
+		return nil
+	}
+}
+
+// offer hands t to the agent that has waited longest, reporting whether
+// one was waiting. With wait set it keeps offering until an agent arrives
+// or ctx ends.
+func (h *agentHub) offer(ctx context.Context, t *agentTask, wait bool) bool {
+	for {
+		h.mu.Lock()
+		if len(h.idle) > 0 {
+			w := h.idle[0]
+			h.idle = h.idle[1:]
+			t.agent = w.name
+			t.touch()
+			if h.tasks == nil {
+				h.tasks = make(map[string]*agentTask)
+			}
+			h.tasks[t.spec.ID] = t
+			w.ch <- t
+			h.mu.Unlock()
+			return true
+		}
+		if h.arrived == nil {
+			h.arrived = make(chan struct{})
+		}
+		arrived := h.arrived
+		h.mu.Unlock()
+
+		if !wait {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-arrived:
+		}
+	}
+}
+
+// task returns the running task with id
+func (h *agentHub) task(id string) (*agentTask, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.tasks[id]
+	return t, ok
+}
+
+func (h *agentHub) remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.tasks, id)
+}
+
+// download runs one attempt of a job's download, on a remote agent when
+// one is waiting for work, or always on one if the agents are exclusive.
+// Subscription checks stay local, as they need the server's archive of
+// fetched videos.
+func (s *server) download(ctx context.Context, jobID string, req downloader.Request) (*downloader.Result, *apperr.DownloadError) {
+	if len(s.cfg.Agents.Tokens) == 0 || req.Archive != "" {
+		return handleVideoDownload(ctx, req, s.downloaders)
+	}
+	if _, err := selectBackend(req.URL, req.ExtraArgs, s.downloaders); err != nil {
+		return nil, err
+	}
+
+	t := &agentTask{
+		spec: agentTaskSpec{
+			ID:          newTaskID(),
+			JobID:       jobID,
+			URL:         req.URL,
+			Format:      req.Format,
+			Output:      req.OutputTemplate,
+			Args:        req.ExtraArgs,
+			Proxy:       req.Proxy,
+			PlaylistEnd: req.PlaylistEnd,
+			Items:       req.PlaylistItems,
+			DateAfter:   req.DateAfter,
+			DateBefore:  req.DateBefore,
+			MaxFileSize: req.MaxFileSize,
+			Section:     req.Section,
+			Languages:   req.Languages,
+			Filter:      req.Filter,
+			Extractor:   req.Site.ExtractorArgs,
+			Impersonate: req.Site.Impersonate,
+		},
+		workDir: req.OutputDir,
+		req:     req,
+		ctx:     ctx,
+		done:    make(chan agentResult, 1),
+	}
+	if req.Cookies != "" {
+		data, err := os.ReadFile(req.Cookies)
+		if err != nil {
+			log.Printf("Failed to read the cookies for %s: %v", req.URL, err)
+		}
+		t.spec.Cookies = string(data)
+	}
+	if !s.agents.offer(ctx, t, s.cfg.Agents.Exclusive) {
+		if ctx.Err() != nil {
+			return nil, &apperr.DownloadError{
+				Type:    apperr.TypeNetwork,
+				Message: "Canceled while waiting for an agent",
+				Code:    http.StatusServiceUnavailable,
+			}
+		}
+		return handleVideoDownload(ctx, req, s.downloaders)
+	}
+	defer s.agents.remove(t.spec.ID)
+	log.Printf("Agent %s is downloading %s for job %s", t.agent, req.URL, jobID)
+
+	timeout := time.Duration(s.cfg.Agents.Timeout)
+	check := time.NewTicker(timeout / 4)
+	defer check.Stop()
+	for {
+		select {
+		case res := <-t.done:
+			if res.Error != nil {
+				return nil, res.Error
+			}
+			result := &downloader.Result{Backend: res.Backend, Output: res.Output}
+			for _, name := range res.Files {
+				result.Files = append(result.Files, filepath.Join(t.workDir, filepath.FromSlash(name)))
+			}
+			log.Printf("Agent %s finished %s for job %s", t.agent, req.URL, jobID)
+			return result, nil
+		case <-ctx.Done():
+			// The agent learns of it from its next report
+			return nil, &apperr.DownloadError{
+				Type:    apperr.TypeNetwork,
+				Message: "Download canceled",
+				Code:    http.StatusServiceUnavailable,
+			}
+		case <-check.C:
+			if time.Since(t.lastSeen()) > timeout {
+				log.Printf("Agent %s stopped reporting on job %s", t.agent, jobID)
+				return nil, &apperr.DownloadError{
+					Type:    apperr.TypeNetwork,
+					Message: fmt.Sprintf("Agent %s stopped responding", t.agent),
+					Details: fmt.Sprintf("no report for %v", timeout),
+					Code:    http.StatusServiceUnavailable,
+				}
+			}
+		}
+	}
+}
+
+func newTaskID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// agentName returns the agent whose token r carries, writing an error
+// when there is none
+func (s *server) agentName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		for name, t := range s.cfg.Agents.Tokens {
+			// Constant-time so response times don't reveal how much matched
+			if subtle.ConstantTimeCompare([]byte(t), []byte(strings.TrimSpace(token))) == 1 {
+				return name, true
+			}
+		}
+	}
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypePermission,
+		Message: "An agent token is required",
+		Code:    http.StatusUnauthorized,
+	})
+	return "", false
+}
+
+// agentTask returns the task in r's path if it was handed to agent and is
+// still wanted, writing an error otherwise. A task whose job was canceled
+// is gone, which tells the agent to stop.
+func (s *server) agentTask(w http.ResponseWriter, r *http.Request, agent string) (*agentTask, bool) {
+	t, ok := s.agents.task(r.PathValue("id"))
+	if !ok || t.agent != agent || t.ctx.Err() != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "The task is no longer wanted",
+			Code:    http.StatusGone,
+		})
+		return nil, false
+	}
+	t.touch()
+	return t, true
+}
+
+// handleAgentPoll serves POST /api/agent/tasks, which an agent calls to
+// ask for a download. The request waits up to agentPollTimeout for one,
+// answering 204 No Content if none came.
+func (s *server) handleAgentPoll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	name, ok := s.agentName(w, r)
+	if !ok {
+		return
+	}
+
+	waiter := s.agents.wait(name)
+	timeout := time.NewTimer(agentPollTimeout)
+	defer timeout.Stop()
+	var t *agentTask
+	select {
+	case t = <-waiter.ch:
+	case <-timeout.C:
+	case <-r.Context().Done():
+	}
+	if t == nil {
+		t = s.agents.leave(waiter)
+	}
+	if t == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Context().Err() != nil {
+		// The agent left as the task arrived; it is retried once the
+		// agent timeout passes
+		log.Printf("Agent %s disconnected while taking job %s", name, t.spec.JobID)
+		return
+	}
+	json.NewEncoder(w).Encode(t.spec)
+}
+
+// handleAgentProgress serves POST /api/agent/tasks/{id}/progress, an
+// agent's report on a running task. Reports also keep the task alive.
+func (s *server) handleAgentProgress(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	name, ok := s.agentName(w, r)
+	if !ok {
+		return
+	}
+	t, ok := s.agentTask(w, r, name)
+	if !ok {
+		return
+	}
+
+	var p agentProgress
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	if p.Log != "" && t.req.Log != nil {
+		io.WriteString(t.req.Log, p.Log)
+	}
+	if t.req.Progress != nil && (p.Percent > 0 || p.Speed > 0) {
+		t.req.Progress(downloader.Progress{
+			Percent:    p.Percent,
+			TotalBytes: p.TotalBytes,
+			Speed:      p.Speed,
+			ETA:        time.Duration(p.ETASeconds * float64(time.Second)),
+		})
+	}
+	json.NewEncoder(w).Encode(SuccessResponse{Success: true, Message: "Progress recorded"})
+}
+
+// handleAgentFile serves PUT /api/agent/tasks/{id}/files/{name...}, a file
+// the task downloaded, written into the job's work folder
+func (s *server) handleAgentFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "PUT" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	name, ok := s.agentName(w, r)
+	if !ok {
+		return
+	}
+	t, ok := s.agentTask(w, r, name)
+	if !ok {
+		return
+	}
+
+	rel := filepath.FromSlash(r.PathValue("name"))
+	if rel == "" || filepath.IsAbs(rel) || !filepath.IsLocal(rel) {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid file name",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	path := filepath.Join(t.workDir, rel)
+	if err := writeAgentFile(path, &touchReader{r: r.Body, task: t}); err != nil {
+		log.Printf("Failed to store %s from agent %s: %v", rel, name, err)
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to store the file",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	t.touch()
+	json.NewEncoder(w).Encode(SuccessResponse{Success: true, Message: "File stored"})
+}
+
+// touchReader keeps a task alive while its files upload, however long
+// they take
+type touchReader struct {
+	r    io.Reader
+	task *agentTask
+}
+
+func (tr *touchReader) Read(p []byte) (int, error) {
+	tr.task.touch()
+	return tr.r.Read(p)
+}
+
+// writeAgentFile writes body to path through a temporary file, so an
+// interrupted upload never looks complete
+func writeAgentFile(path string, body io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".upload"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// handleAgentResult serves POST /api/agent/tasks/{id}/result, how a task
+// ended. The files listed must have been uploaded first.
+func (s *server) handleAgentResult(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	name, ok := s.agentName(w, r)
+	if !ok {
+		return
+	}
+	t, ok := s.agentTask(w, r, name)
+	if !ok {
+		return
+	}
+
+	var res agentResult
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	if res.Error == nil {
+		for _, file := range res.Files {
+			rel := filepath.FromSlash(file)
+			if !filepath.IsLocal(rel) {
+				writeError(w, &apperr.DownloadError{
+					Type:    apperr.TypeValidation,
+					Message: "Invalid file name",
+					Details: file,
+					Code:    http.StatusBadRequest,
+				})
+				return
+			}
+			if _, err := os.Stat(filepath.Join(t.workDir, rel)); err != nil {
+				writeError(w, &apperr.DownloadError{
+					Type:    apperr.TypeValidation,
+					Message: "File was not uploaded",
+					Details: file,
+					Code:    http.StatusBadRequest,
+				})
+				return
+			}
+		}
+	}
+
+	select {
+	case t.done <- res:
+	default:
+	}
+	json.NewEncoder(w).Encode(SuccessResponse{Success: true, Message: "Result recorded"})
+}
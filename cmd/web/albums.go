@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"noahjalex.ute/internal/audit"
+	apperr "noahjalex.ute/internal/errors"
+)
+
+// handleAlbums serves GET /api/albums, listing image galleries newest first
+func (s *server) handleAlbums(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.videos.Albums())
+}
+
+// handleAlbum serves GET and DELETE /api/albums/{id}. Deleting removes the
+// album's images; clips stay in the library as videos.
+func (s *server) handleAlbum(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	a, ok := s.videos.Album(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Album not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(a)
+	case "DELETE":
+		if _, err := s.videos.RemoveAlbum(a.ID); err != nil {
+			log.Printf("Failed to delete album %s: %v", a.ID, err)
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to delete album",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		s.record(r, audit.ActionAlbumDelete, a.ID, map[string]string{"title": a.Title})
+		var freed int64
+		for _, item := range a.Items {
+			if !item.Video {
+				freed += item.Size
+			}
+		}
+		if isHTMX(r) {
+			w.Header().Set("HX-Redirect", "/albums")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":         true,
+			"reclaimed_bytes": freed,
+		})
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// handleAlbumsPage serves GET /albums
+func (s *server) handleAlbumsPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	s.views.render(w, r, "albums", "album-list", s.videos.Albums())
+}
+
+// handleAlbumPage serves GET /albums/{id}, the album's images as a grid
+func (s *server) handleAlbumPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	a, ok := s.videos.Album(r.PathValue("id"))
+	if !ok {
+		s.errPages.write(w, r, http.StatusNotFound, "That album doesn't exist.")
+		return
+	}
+	s.views.render(w, r, "album", "album-items", a)
+}
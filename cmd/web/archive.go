@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+)
+
+// handleVideoArchive serves POST /api/videos/archive, streaming a zip of
+// the chosen videos and their sidecars. The body lists video IDs, or a
+// filter taking the same parameters as /api/videos to export a folder,
+// uploader or any other listing. Videos are stored uncompressed, as they
+// don't compress further.
+func (s *server) handleVideoArchive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var body struct {
+		IDs    []string          `json:"ids"`
+		Filter map[string]string `json:"filter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (len(body.IDs) == 0 && body.Filter == nil) {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Request body must contain ids or filter",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	var videos []library.Video
+	if len(body.IDs) > 0 {
+		for _, id := range body.IDs {
+			v, ok := s.videos.Get(id)
+			if !ok {
+				writeError(w, &apperr.DownloadError{
+					Type:    apperr.TypeNotFound,
+					Message: "Video not found",
+					Details: id,
+					Code:    http.StatusNotFound,
+				})
+				return
+			}
+			videos = append(videos, v)
+		}
+	} else {
+		params := url.Values{}
+		for key, value := range body.Filter {
+			params.Set(key, value)
+		}
+		query, err := s.parseQuery(r, params)
+		if err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid filter",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		videos = s.videos.Find(query)
+	}
+	if len(videos) == 0 {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "No videos match the filter",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	name := fmt.Sprintf("ute-%s.zip", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", attachment(name))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are sent, so a failure part way through can only be logged;
+	// the client sees a truncated zip
+	zw := zip.NewWriter(w)
+	seen := make(map[string]bool)
+	for _, v := range videos {
+		if err := s.archiveVideo(r.Context(), zw, v, seen); err != nil {
+			log.Printf("Archive aborted at %s: %v", v.FilePath, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed to finish archive: %v", err)
+		return
+	}
+	log.Printf("Sent archive of %d videos", len(videos))
+}
+
+// archiveVideo adds v and its sidecars to zw under their library paths,
+// from local disk or the storage backend. Paths already in the archive are
+// skipped.
+func (s *server) archiveVideo(ctx context.Context, zw *zip.Writer, v library.Video, seen map[string]bool) error {
+	if !v.IsRemote() || s.cfg.Storage.KeepLocal {
+		files, err := library.RelatedFiles(s.videos.AbsPath(v))
+		if err == nil {
+			root := s.videos.Root(v)
+			for _, file := range files {
+				rel, err := filepath.Rel(root, file)
+				if err != nil || seen[filepath.ToSlash(rel)] {
+					continue
+				}
+				seen[filepath.ToSlash(rel)] = true
+				if err := addFileToZip(zw, file, filepath.ToSlash(rel)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if !v.IsRemote() {
+			log.Printf("Skipping %s in archive: %v", v.FilePath, err)
+			return nil
+		}
+	}
+
+	if s.storage == nil || s.storage.Name() != v.Storage {
+		log.Printf("Skipping %s in archive: %s storage is not configured", v.FilePath, v.Storage)
+		return nil
+	}
+	keys := v.RemoteFiles
+	if len(keys) == 0 {
+		keys = []string{v.RemoteKey}
+	}
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		resp, err := s.storage.Get(ctx, key, nil)
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", key, err)
+		}
+		err = copyToZip(zw, resp.Body, key, v.ModTime)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFileToZip stores the file at path in zw as name
+func addFileToZip(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return copyToZip(zw, f, name, fi.ModTime())
+}
+
+// copyToZip stores r's content in zw as name, without compression
+func copyToZip(zw *zip.Writer, r io.Reader, name string, modified time.Time) error {
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"noahjalex.ute/internal/audit"
+	apperr "noahjalex.ute/internal/errors"
+)
+
+// Audit log pages default to defaultAuditLimit entries, at most maxAuditLimit
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// actor names whoever made r for the audit log: their user name, or the
+// client's address when the server has no users
+func actor(r *http.Request) string {
+	if name := userName(r); name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// record adds an audit entry for an action taken through r. The audit log
+// is optional, and a failed write doesn't undo the action.
+func (s *server) record(r *http.Request, action, target string, details map[string]string) {
+	if s.audit == nil {
+		return
+	}
+	if err := s.audit.Record(actor(r), action, target, details); err != nil {
+		log.Printf("Failed to write audit entry for %s %s: %v", action, target, err)
+	}
+}
+
+// auditPage is one page of the audit log
+type auditPage struct {
+	Entries []audit.Entry `json:"entries"`
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+}
+
+// handleAuditLog serves GET /api/audit, newest first. ?offset= and ?limit=
+// page through the log; ?actor=, ?action= and ?since= (RFC 3339) filter it.
+func (s *server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	if s.audit == nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "The audit log is unavailable",
+			Details: "Check the server log for why it couldn't be opened",
+			Code:    http.StatusServiceUnavailable,
+		})
+		return
+	}
+
+	q := r.URL.Query()
+	page := auditPage{Limit: defaultAuditLimit}
+	filter := audit.Filter{Actor: q.Get("actor"), Action: q.Get("action")}
+	var err error
+	if v := q.Get("offset"); v != "" {
+		if page.Offset, err = strconv.Atoi(v); err != nil || page.Offset < 0 {
+			writeAuditQueryError(w, "offset must be a non-negative number")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if page.Limit, err = strconv.Atoi(v); err != nil || page.Limit < 1 || page.Limit > maxAuditLimit {
+			writeAuditQueryError(w, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			return
+		}
+	}
+	if v := q.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			writeAuditQueryError(w, "since must be an RFC 3339 time like 2024-01-02T15:04:05Z")
+			return
+		}
+	}
+
+	page.Entries, page.Total, err = s.audit.Page(filter, page.Offset, page.Limit)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to read the audit log",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func writeAuditQueryError(w http.ResponseWriter, details string) {
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypeValidation,
+		Message: "Invalid audit log query",
+		Details: details,
+		Code:    http.StatusBadRequest,
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/budget"
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/throughput"
+)
+
+// budgetStatus is the data budget's use and whether it holds the queue
+type budgetStatus struct {
+	budget.Status
+	// Paused is set while queued downloads wait for the next period or a
+	// reset
+	Paused bool `json:"paused"`
+}
+
+func (s *server) budgetStatus() budgetStatus {
+	st := s.budget.Status()
+	return budgetStatus{Status: st, Paused: st.Exceeded && s.cfg.DataBudget.Pause}
+}
+
+// countData counts what a finished job downloaded against the data budget:
+// the bytes its backend reported moving, including for failed attempts, or
+// the size of its files when it reported no progress
+func (s *server) countData(job jobs.Job, rate throughput.Rate, measured bool, result *downloader.Result) {
+	bytes := rate.Bytes
+	if (!measured || bytes == 0) && result != nil {
+		for _, file := range result.Files {
+			if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+				bytes += fi.Size()
+			}
+		}
+	}
+
+	warn, exceeded, err := s.budget.Add(bytes)
+	if err != nil {
+		log.Printf("Failed to save the data budget count: %v", err)
+	}
+	limit := formatSize(s.cfg.DataBudget.MonthlyBytes)
+	switch {
+	case exceeded && s.cfg.DataBudget.Pause:
+		log.Printf("Warning: job %s used up the %s data budget for this month; queued downloads are paused", job.ID, limit)
+	case exceeded:
+		log.Printf("Warning: job %s used up the %s data budget for this month", job.ID, limit)
+	case warn > 0:
+		log.Printf("Warning: downloads have used %d%% of the %s data budget for this month", warn, limit)
+	}
+}
+
+// handleBudget serves GET /api/budget, the month's downloads against the
+// data budget
+func (s *server) handleBudget(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(s.budgetStatus())
+}
+
+// handleBudgetReset serves POST /api/budget/reset, starting the month's
+// count over and resuming paused downloads; admins only
+func (s *server) handleBudgetReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	before := s.budget.Status().Bytes
+	if err := s.budget.Reset(); err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to reset the data budget",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	log.Printf("Reset the data budget count from %s", formatSize(before))
+	s.record(r, audit.ActionBudgetReset, "", map[string]string{"bytes": strconv.FormatInt(before, 10)})
+	json.NewEncoder(w).Encode(s.budgetStatus())
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/cluster"
+	"noahjalex.ute/internal/downloader"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+// clusterMinSync is the least time between syncs with the board, however
+// often jobs change
+const clusterMinSync = 2 * time.Second
+
+// clusterKeep is how long finished jobs stay on the board
+const clusterKeep = 24 * time.Hour
+
+// clusterState is this node's part in a cluster sharing the job board
+type clusterState struct {
+	// board is nil when the server runs on its own
+	board *cluster.Board
+	role  string
+
+	mu sync.Mutex
+	// claimed are the jobs this node holds leases on
+	claimed map[string]bool
+	// origins are the nodes the jobs mirrored from the board came from
+	origins map[string]string
+	// indexed maps other nodes' completed jobs to their videos in this
+	// node's library
+	indexed map[string][]string
+}
+
+// startCluster joins the cluster in the configured shared directory,
+// syncing jobs with the other nodes until ctx ends
+func (s *server) startCluster(ctx context.Context) {
+	cfg := s.cfg.Cluster
+	if cfg.Dir == "" {
+		return
+	}
+	node := cfg.Node
+	if node == "" {
+		var err error
+		if node, err = os.Hostname(); err != nil {
+			log.Fatalf("cluster: naming this node: %v", err)
+		}
+	}
+	board, err := cluster.NewBoard(cfg.Dir, node, time.Duration(cfg.Lease))
+	if err != nil {
+		log.Fatalf("cluster: opening %s: %v", cfg.Dir, err)
+	}
+	s.cluster = clusterState{
+		board:   board,
+		role:    cfg.Role,
+		claimed: make(map[string]bool),
+		origins: make(map[string]string),
+		indexed: make(map[string][]string),
+	}
+	log.Printf("Sharing jobs in %s as node %s (%s)", cfg.Dir, node, cfg.Role)
+
+	go s.runCluster(ctx)
+}
+
+// runCluster syncs with the board every heartbeat, and soon after local
+// jobs change so other nodes see new ones quickly
+func (s *server) runCluster(ctx context.Context) {
+	sub := s.jobs.Subscribe(nil, 1, jobs.DropOldest)
+	defer sub.Close()
+	heartbeat := time.NewTicker(time.Duration(s.cfg.Cluster.Heartbeat))
+	defer heartbeat.Stop()
+
+	for {
+		s.syncCluster()
+
+		throttle := time.NewTimer(clusterMinSync)
+		select {
+		case <-ctx.Done():
+			throttle.Stop()
+			return
+		case <-throttle.C:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+		case <-sub.C:
+		}
+	}
+}
+
+// clusterClaim reports whether this node may run the queued download with
+// id, claiming it on the board when in a cluster
+func (s *server) clusterClaim(id string) bool {
+	c := &s.cluster
+	if c.board == nil {
+		return true
+	}
+	if c.role == cluster.RoleUI {
+		return false
+	}
+	ok, err := c.board.Claim(id)
+	if err != nil {
+		log.Printf("Failed to claim job %s: %v", id, err)
+		return false
+	}
+	if ok {
+		c.mu.Lock()
+		c.claimed[id] = true
+		c.mu.Unlock()
+	}
+	return ok
+}
+
+// clusterFinish publishes the outcome of a job this node ran, with the
+// files it downloaded, and gives up its claim
+func (s *server) clusterFinish(id string, result *downloader.Result) {
+	c := &s.cluster
+	if c.board == nil {
+		return
+	}
+	c.mu.Lock()
+	claimed := c.claimed[id]
+	delete(c.claimed, id)
+	c.mu.Unlock()
+	if !claimed {
+		return
+	}
+
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		return
+	}
+	e := s.clusterEntry(job, c.board.Node())
+	videosDir, err := filepath.Abs(s.cfg.VideosDir)
+	if err == nil && result != nil && job.State == jobs.StateCompleted {
+		for _, file := range result.Files {
+			abs, err := filepath.Abs(file)
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(videosDir, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				e.Files = append(e.Files, filepath.ToSlash(rel))
+			}
+		}
+	}
+	if err := c.board.Put(e); err != nil {
+		log.Printf("Failed to publish job %s: %v", id, err)
+	}
+	if err := c.board.Release(id); err != nil {
+		log.Printf("Failed to release job %s: %v", id, err)
+	}
+}
+
+// clusterEntry is job as published by this node, last run by node
+func (s *server) clusterEntry(job jobs.Job, node string) cluster.Entry {
+	c := &s.cluster
+	c.mu.Lock()
+	origin := c.origins[job.ID]
+	c.mu.Unlock()
+	if origin == "" {
+		origin = c.board.Node()
+	}
+	return cluster.Entry{Job: job, Origin: origin, Node: node}
+}
+
+// clusterCancel asks the node running the job with id to stop it,
+// reporting whether another node holds it
+func (s *server) clusterCancel(id string) bool {
+	c := &s.cluster
+	if c.board == nil {
+		return false
+	}
+	lease, held := c.board.Holder(id)
+	if !held || !lease.Live() || lease.Node == c.board.Node() {
+		return false
+	}
+	if err := c.board.RequestCancel(id); err != nil {
+		log.Printf("Failed to ask node %s to cancel job %s: %v", lease.Node, id, err)
+		return false
+	}
+	return true
+}
+
+// syncCluster renews this node's claims and exchanges jobs with the board:
+// jobs submitted or changed here are published, and the other nodes' are
+// mirrored into the local store so the API and pages show them. Queued
+// jobs, and running ones whose node stopped renewing its claim, join the
+// local queue to be claimed by whichever node gets to them first.
+func (s *server) syncCluster() {
+	c := &s.cluster
+	board, node := c.board, c.board.Node()
+
+	c.mu.Lock()
+	claimed := make([]string, 0, len(c.claimed))
+	for id := range c.claimed {
+		claimed = append(claimed, id)
+	}
+	c.mu.Unlock()
+
+	for _, id := range claimed {
+		if err := board.Renew(id); err != nil {
+			log.Printf("Stopping job %s: %v", id, err)
+			c.mu.Lock()
+			delete(c.claimed, id)
+			c.mu.Unlock()
+			s.running.cancel(id)
+			continue
+		}
+		if board.CancelRequested(id) {
+			log.Printf("Canceling job %s at another node's request", id)
+			s.running.cancel(id)
+		}
+		if job, ok := s.jobs.Get(id); ok {
+			if err := board.Put(s.clusterEntry(job, node)); err != nil {
+				log.Printf("Failed to publish job %s: %v", id, err)
+			}
+		}
+	}
+	if err := board.Beat(c.role, claimed); err != nil {
+		log.Printf("Failed to send the cluster heartbeat: %v", err)
+	}
+
+	entries, err := board.List()
+	if err != nil {
+		log.Printf("Failed to read the cluster's jobs: %v", err)
+		return
+	}
+	onBoard := make(map[string]cluster.Entry, len(entries))
+	for _, e := range entries {
+		onBoard[e.Job.ID] = e
+	}
+	isClaimed := func(id string) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.claimed[id]
+	}
+
+	// Publish jobs submitted here, and changes made here such as retries
+	// and cancellations of queued jobs
+	for _, job := range s.jobs.List() {
+		if job.Kind != jobs.KindDownload || isClaimed(job.ID) {
+			continue
+		}
+		prev, ok := onBoard[job.ID]
+		if ok && !job.UpdatedAt.After(prev.Job.UpdatedAt) {
+			continue
+		}
+		c.mu.Lock()
+		mirrored := c.origins[job.ID] != ""
+		c.mu.Unlock()
+		// Finished jobs pruned from the board stay that way
+		if !ok && (mirrored || jobFinished(job.State)) {
+			continue
+		}
+		e := s.clusterEntry(job, prev.Node)
+		e.Files = prev.Files
+		if err := board.Put(e); err != nil {
+			log.Printf("Failed to publish job %s: %v", job.ID, err)
+			continue
+		}
+		onBoard[job.ID] = e
+	}
+
+	var queue []jobs.Job
+	pending := make(map[string]bool)
+	for _, id := range s.queue.Pending() {
+		pending[id] = true
+	}
+	for id, e := range onBoard {
+		if isClaimed(id) {
+			continue
+		}
+		job := e.Job
+		lease, held := board.Holder(id)
+		elsewhere := held && lease.Live() && lease.Node != node
+
+		if jobFinished(job.State) && time.Since(job.UpdatedAt) > clusterKeep {
+			if err := board.Remove(id); err != nil {
+				log.Printf("Failed to remove job %s from the cluster: %v", id, err)
+			}
+			continue
+		}
+
+		switch {
+		case elsewhere:
+			if pending[id] {
+				s.queue.Remove(id)
+			}
+		case job.State == jobs.StateQueued || job.State == jobs.StateRunning || job.State == jobs.StateRetrying:
+			if job.State != jobs.StateQueued {
+				log.Printf("Rescheduling job %s, whose node %s stopped renewing its claim", id, e.Node)
+				job.State = jobs.StateQueued
+				job.Transfer = nil
+				job.UpdatedAt = time.Now()
+				e.Job = job
+				if err := board.Put(e); err != nil {
+					log.Printf("Failed to publish job %s: %v", id, err)
+				}
+			}
+			if c.role != cluster.RoleUI && !pending[id] {
+				queue = append(queue, job)
+			}
+		default:
+			if pending[id] {
+				s.queue.Remove(id)
+			}
+			if job.State == jobs.StateCompleted && e.Node != node && c.role != cluster.RoleWorker {
+				job.VideoIDs = s.indexClusterFiles(e)
+			}
+		}
+
+		local, known := s.jobs.Get(id)
+		if known && !job.UpdatedAt.After(local.UpdatedAt) && local.State == job.State {
+			continue
+		}
+		if e.Origin != node {
+			c.mu.Lock()
+			c.origins[id] = e.Origin
+			c.mu.Unlock()
+		}
+		s.jobs.Put(job)
+	}
+
+	// Queued after they are in the store, where workers look them up
+	for _, job := range queue {
+		s.queue.Push(job.ID, job.Priority)
+	}
+}
+
+// jobFinished reports whether a job in state will not run again unless
+// retried
+func jobFinished(state jobs.State) bool {
+	return state == jobs.StateCompleted || state == jobs.StateFailed ||
+		state == jobs.StateCanceled || state == jobs.StateNeedsAuth
+}
+
+// indexClusterFiles adds the files another node downloaded into the
+// shared videos directory to this node's library, once per job, returning
+// their videos
+func (s *server) indexClusterFiles(e cluster.Entry) []string {
+	c := &s.cluster
+	c.mu.Lock()
+	ids, done := c.indexed[e.Job.ID]
+	c.mu.Unlock()
+	if done {
+		return ids
+	}
+
+	added := false
+	for _, rel := range e.Files {
+		path := filepath.Join(s.cfg.VideosDir, filepath.FromSlash(rel))
+		if !library.IsVideoFile(path) {
+			continue
+		}
+		if v, ok := s.videos.FindByPath(rel); ok {
+			ids = append(ids, v.ID)
+			continue
+		}
+		v, err := s.videos.AddFile(path)
+		if err != nil {
+			log.Printf("Failed to index %s from node %s: %v", path, e.Node, err)
+			continue
+		}
+		ids = append(ids, v.ID)
+		added = true
+	}
+	if added {
+		if err := s.videos.SaveMetadata(); err != nil {
+			log.Printf("Failed to save library metadata: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.indexed[e.Job.ID] = ids
+	c.mu.Unlock()
+	return ids
+}
+
+// handleCluster serves GET /api/cluster, the nodes sharing the job board
+func (s *server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	c := &s.cluster
+	if c.board == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": false})
+		return
+	}
+	nodes, err := c.board.Nodes()
+	if err != nil {
+		log.Printf("Failed to read the cluster's nodes: %v", err)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": true,
+		"node":    c.board.Node(),
+		"role":    c.role,
+		"nodes":   nodes,
+	})
+}
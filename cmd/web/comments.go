@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+)
+
+// Comment pages default to defaultCommentLimit comments, at most
+// maxCommentLimit
+const (
+	defaultCommentLimit = 20
+	maxCommentLimit     = 100
+)
+
+// commentPage is one page of a video's comments
+type commentPage struct {
+	Comments []library.Comment `json:"comments"`
+	Total    int               `json:"total"`
+	Offset   int               `json:"offset"`
+	Limit    int               `json:"limit"`
+}
+
+// handleComments serves GET /api/videos/{id}/comments, the top-level
+// comments saved with a video in the site's order. ?offset= and ?limit=
+// page through them.
+func (s *server) handleComments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	v, ok := s.videos.GetFull(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	q := r.URL.Query()
+	page := commentPage{Total: len(v.Comments), Limit: defaultCommentLimit}
+	var err error
+	if raw := q.Get("offset"); raw != "" {
+		if page.Offset, err = strconv.Atoi(raw); err != nil || page.Offset < 0 {
+			writeCommentQueryError(w, "offset must be a non-negative number")
+			return
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		if page.Limit, err = strconv.Atoi(raw); err != nil || page.Limit < 1 || page.Limit > maxCommentLimit {
+			writeCommentQueryError(w, "limit must be between 1 and "+strconv.Itoa(maxCommentLimit))
+			return
+		}
+	}
+
+	start := min(page.Offset, page.Total)
+	page.Comments = v.Comments[start:min(start+page.Limit, page.Total)]
+	if page.Comments == nil {
+		page.Comments = []library.Comment{}
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func writeCommentQueryError(w http.ResponseWriter, details string) {
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypeValidation,
+		Message: "Invalid comments query",
+		Details: details,
+		Code:    http.StatusBadRequest,
+	})
+}
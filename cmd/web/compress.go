@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/zstd"
+)
+
+// gzipPools reuses writers per compression level
+var gzipPools sync.Map
+
+func getGzipWriter(w io.Writer, level int) *gzip.Writer {
+	pool, _ := gzipPools.LoadOrStore(level, &sync.Pool{
+		New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(io.Discard, level)
+			return gz
+		},
+	})
+	gz := pool.(*sync.Pool).Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer, level int) {
+	if pool, ok := gzipPools.Load(level); ok {
+		pool.(*sync.Pool).Put(gz)
+	}
+}
+
+var zstdPool = sync.Pool{
+	New: func() interface{} {
+		return zstd.NewWriter(io.Discard)
+	},
+}
+
+// encoder is a gzip or zstd writer
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func getEncoder(coding string, w io.Writer, level int) encoder {
+	if coding == "zstd" {
+		z := zstdPool.Get().(*zstd.Writer)
+		z.Reset(w)
+		return z
+	}
+	return getGzipWriter(w, level)
+}
+
+func putEncoder(enc encoder, level int) {
+	switch enc := enc.(type) {
+	case *zstd.Writer:
+		zstdPool.Put(enc)
+	case *gzip.Writer:
+		putGzipWriter(enc, level)
+	}
+}
+
+// compress encodes responses for clients that accept zstd or gzip. Range
+// requests are passed through untouched since byte offsets refer to the
+// identity body.
+func compress(cfg config.Compression) middleware {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			coding := acceptedCoding(r)
+			if r.Method == "HEAD" || r.Header.Get("Range") != "" || coding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, cfg: cfg, coding: coding}
+			next.ServeHTTP(cw, r)
+			// Skipped when the handler panics, so a half-buffered response
+			// doesn't go out ahead of the recovery error
+			cw.Close()
+		})
+	}
+}
+
+// acceptedCoding picks the encoding for a response: zstd when
+// Accept-Encoding allows it, else gzip, or "" for neither
+func acceptedCoding(r *http.Request) string {
+	for _, coding := range []string{"zstd", "gzip"} {
+		if accepts(r, coding) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// accepts reports whether Accept-Encoding allows coding with a non-zero
+// quality
+func accepts(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is large enough, and of the right type, to be worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	cfg config.Compression
+	// coding is the Content-Encoding used if the response is compressed
+	coding string
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	// Informational responses go straight through
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	// These carry no body, so there is nothing to wait for
+	if status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf.Write(p)
+		if cw.buf.Len() < cw.cfg.MinSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the underlying writer's sendfile path for bodies that
+// aren't compressed, such as video files served with http.ServeFile
+func (cw *compressWriter) ReadFrom(r io.Reader) (int64, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	var n int64
+	if !cw.decided {
+		limit := int64(cw.cfg.MinSize - cw.buf.Len())
+		m, err := cw.buf.ReadFrom(io.LimitReader(r, limit))
+		n += m
+		if err != nil || m < limit {
+			// r ran out before the threshold; Close decides
+			return n, err
+		}
+		if err := cw.decide(true); err != nil {
+			return n, err
+		}
+	}
+
+	var m int64
+	var err error
+	if cw.enc != nil {
+		m, err = io.Copy(cw.enc, r)
+	} else if rf, ok := cw.ResponseWriter.(io.ReaderFrom); ok {
+		m, err = rf.ReadFrom(r)
+	} else {
+		m, err = io.Copy(cw.ResponseWriter, r)
+	}
+	return n + m, err
+}
+
+// Flush sends whatever is buffered so streamed responses aren't held back
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(cw.buf.Len() >= cw.cfg.MinSize)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the response, sending a short body uncompressed
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// The handler wrote nothing at all
+			return nil
+		}
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	putEncoder(cw.enc, cw.cfg.Level)
+	cw.enc = nil
+	return err
+}
+
+// decide writes the header, compressing when large is set and the
+// response is eligible, then flushes the buffered body
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	if large && cw.eligible(h) {
+		h.Set("Content-Encoding", cw.coding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The encoded body differs byte for byte, so a strong validator
+		// no longer applies
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.enc = getEncoder(cw.coding, cw.ResponseWriter, cw.cfg.Level)
+		_, err := cw.enc.Write(cw.buf.Bytes())
+		cw.buf.Reset()
+		return err
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// eligible reports whether the response's type is configured for
+// compression and it isn't already encoded
+func (cw *compressWriter) eligible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		// Sniff now, as net/http would, so the type is still sent
+		contentType = http.DetectContentType(cw.buf.Bytes())
+		h.Set("Content-Type", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	// Event streams must reach the client as each event is written
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, t := range cw.cfg.Types {
+		t = strings.ToLower(t)
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
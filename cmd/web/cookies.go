@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/cookies"
+	apperr "noahjalex.ute/internal/errors"
+)
+
+// handleCookies serves GET /api/cookies, the sites with stored cookies;
+// admins only
+func (s *server) handleCookies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	sites, err := s.cookies.List()
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to list stored cookies",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(sites)
+}
+
+// handleSiteCookies serves PUT and DELETE /api/cookies/{domain}; admins
+// only. PUT takes a Netscape cookies.txt file as the body and stores it for
+// downloads from the domain and its subdomains that need a signed-in
+// account.
+func (s *server) handleSiteCookies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "PUT" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	domain, err := cookies.NormalizeDomain(r.PathValue("domain"))
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid domain",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if r.Method == "DELETE" {
+		found, err := s.cookies.Delete(domain)
+		if err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to delete the cookies",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		if !found {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeNotFound,
+				Message: "No cookies are stored for " + domain,
+				Code:    http.StatusNotFound,
+			})
+			return
+		}
+		log.Printf("Deleted the cookies for %s", domain)
+		s.record(r, audit.ActionCookiesDelete, domain, nil)
+		json.NewEncoder(w).Encode(SuccessResponse{
+			Success: true,
+			Message: "Cookies deleted",
+		})
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cookies.MaxSize))
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Failed to read the cookie file",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	site, err := s.cookies.Put(domain, data)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid cookie file",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	log.Printf("Stored %d cookies for %s", site.Cookies, domain)
+	s.record(r, audit.ActionCookiesUpdate, domain, map[string]string{"cookies": strconv.Itoa(site.Cookies)})
+	json.NewEncoder(w).Encode(site)
+}
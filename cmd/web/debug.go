@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// registerDebug serves net/http/pprof under /debug/pprof/ and a runtime
+// summary at /debug/runtime, to admins only
+func (s *server) registerDebug(mux *http.ServeMux) {
+	s.handleAdmin(mux, "/debug/pprof/", pprof.Index)
+	s.handleAdmin(mux, "/debug/pprof/cmdline", pprof.Cmdline)
+	s.handleAdmin(mux, "/debug/pprof/profile", pprof.Profile)
+	s.handleAdmin(mux, "/debug/pprof/symbol", pprof.Symbol)
+	s.handleAdmin(mux, "/debug/pprof/trace", pprof.Trace)
+	s.handleAdmin(mux, "/debug/runtime", s.handleDebugRuntime)
+}
+
+// goroutineGroup counts the goroutines started from one place
+type goroutineGroup struct {
+	CreatedBy string `json:"created_by"`
+	Count     int    `json:"count"`
+}
+
+// debugRuntime is the response of GET /debug/runtime
+type debugRuntime struct {
+	runtimeInfo
+	HeapInuse   uint64 `json:"heap_inuse"`
+	HeapObjects uint64 `json:"heap_objects"`
+	StackInuse  uint64 `json:"stack_inuse"`
+	// NextGC is the heap size the next collection starts at
+	NextGC uint64     `json:"next_gc"`
+	LastGC *time.Time `json:"last_gc,omitempty"`
+	// GoroutineGroups groups the running goroutines by the function that
+	// started them, most first; a count that keeps growing is a leak
+	GoroutineGroups []goroutineGroup `json:"goroutine_groups"`
+	// JobSubscribers are the open subscriptions to job updates: one per
+	// WebSocket, event stream and log follower besides the server's own
+	JobSubscribers int `json:"job_subscribers"`
+	RunningJobs    int `json:"running_jobs"`
+}
+
+// handleDebugRuntime serves GET /debug/runtime: memory and goroutine
+// stats for diagnosing growth in production without taking a profile
+func (s *server) handleDebugRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info := debugRuntime{
+		runtimeInfo:     readRuntime(),
+		HeapInuse:       mem.HeapInuse,
+		HeapObjects:     mem.HeapObjects,
+		StackInuse:      mem.StackInuse,
+		NextGC:          mem.NextGC,
+		GoroutineGroups: goroutineGroups(),
+		JobSubscribers:  s.jobs.Subscribers(),
+	}
+	if mem.LastGC > 0 {
+		t := time.Unix(0, int64(mem.LastGC))
+		info.LastGC = &t
+	}
+	s.running.mu.Lock()
+	info.RunningJobs = len(s.running.cancels)
+	s.running.mu.Unlock()
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// goroutineGroups counts the goroutines by the function that started
+// them, read from a dump of every goroutine's stack
+func goroutineGroups() []goroutineGroup {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	counts := make(map[string]int)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		creator := "main"
+		for _, line := range strings.Split(stack, "\n") {
+			if name, ok := strings.CutPrefix(line, "created by "); ok {
+				// "created by main.(*server).runRedis in goroutine 1"
+				name, _, _ = strings.Cut(name, " in goroutine ")
+				creator = name
+				break
+			}
+		}
+		counts[creator]++
+	}
+
+	groups := make([]goroutineGroup, 0, len(counts))
+	for creator, n := range counts {
+		groups = append(groups, goroutineGroup{CreatedBy: creator, Count: n})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].CreatedBy < groups[j].CreatedBy
+	})
+	return groups
+}
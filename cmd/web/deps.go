@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Optional programs yt-dlp and the server use when they're installed.
+// Without them some features are unavailable, and downloads asking for
+// those features go ahead without them.
+const (
+	depFFmpeg        = "ffmpeg"
+	depFFprobe       = "ffprobe"
+	depAtomicParsley = "AtomicParsley"
+	depMutagen       = "mutagen"
+)
+
+// dependencies is the status of each optional program, and of the
+// features that depend on them
+type dependencies struct {
+	FFmpeg        toolInfo `json:"ffmpeg"`
+	FFprobe       toolInfo `json:"ffprobe"`
+	AtomicParsley toolInfo `json:"atomicparsley"`
+	// Mutagen is the Python library yt-dlp embeds MP4 thumbnails with,
+	// as yt-dlp reports it
+	Mutagen  toolInfo           `json:"mutagen"`
+	Features map[string]feature `json:"features"`
+}
+
+// feature is whether an optional feature works with the programs found
+type feature struct {
+	Available bool `json:"available"`
+	// Needs says what to install when it isn't available
+	Needs string `json:"needs,omitempty"`
+}
+
+// Features reported by /api/system
+const (
+	featureMerge          = "merge_formats"
+	featureEmbedThumbnail = "embed_thumbnail"
+	featurePostprocess    = "postprocessing"
+	featureProbe          = "probe"
+)
+
+var (
+	toolsMu sync.Mutex
+	// tools holds the programs found so far; missing ones are looked up
+	// again each time so installing them shows up
+	tools = map[string]toolInfo{}
+)
+
+// findTool looks up a program on PATH and its version, the first line of
+// running it with versionArgs, picked out by parse
+func findTool(ctx context.Context, name string, versionArgs []string, parse func(first string) string) toolInfo {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if info, ok := tools[name]; ok {
+		return info
+	}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return toolInfo{Error: name + " not found"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, versionArgs...).Output()
+	if err != nil {
+		return toolInfo{Path: path, Error: err.Error()}
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	info := toolInfo{Path: path, Version: parse(first)}
+	tools[name] = info
+	return info
+}
+
+// ffmpegVersion finds ffmpeg and its version
+func ffmpegVersion(ctx context.Context) toolInfo {
+	return findTool(ctx, depFFmpeg, []string{"-version"}, ffVersion)
+}
+
+// ffVersion reads "ffmpeg version 6.1.1 Copyright (c) 2000-2023 ...",
+// and ffprobe's line of the same shape
+func ffVersion(first string) string {
+	fields := strings.Fields(first)
+	if len(fields) >= 3 && fields[1] == "version" {
+		return fields[2]
+	}
+	return ""
+}
+
+var (
+	mutagenMu sync.Mutex
+	// mutagenFor is the result for the yt-dlp binary it was found with
+	mutagenFor  string
+	mutagenInfo toolInfo
+)
+
+// mutagenVersion asks the yt-dlp at ytdlpPath whether it can import
+// mutagen, from the optional libraries its verbose header lists. The
+// release binaries bundle it; pip installs only have it when installed
+// alongside.
+func mutagenVersion(ctx context.Context, ytdlpPath string) toolInfo {
+	if _, err := exec.LookPath(ytdlpPath); ytdlpPath == "" || err != nil {
+		return toolInfo{Error: "yt-dlp not found"}
+	}
+	mutagenMu.Lock()
+	defer mutagenMu.Unlock()
+	if mutagenFor == ytdlpPath {
+		return mutagenInfo
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	// Without a URL yt-dlp prints its header, then fails on the usage
+	out, _ := exec.CommandContext(ctx, ytdlpPath, "--ignore-config", "--verbose").CombinedOutput()
+	for _, line := range strings.Split(string(out), "\n") {
+		// "[debug] Optional libraries: Cryptodome-3.20.0, brotli-1.1.0, mutagen-1.47.0, ..."
+		_, libs, ok := strings.Cut(line, "Optional libraries:")
+		if !ok {
+			continue
+		}
+		for _, lib := range strings.Split(libs, ",") {
+			if version, ok := strings.CutPrefix(strings.TrimSpace(lib), "mutagen-"); ok {
+				mutagenFor, mutagenInfo = ytdlpPath, toolInfo{Path: ytdlpPath, Version: version}
+				return mutagenInfo
+			}
+		}
+		// Known to be missing, but looked up again in case it's installed
+		return toolInfo{Error: "mutagen not available to yt-dlp"}
+	}
+	return toolInfo{Error: "yt-dlp didn't list its optional libraries"}
+}
+
+// checkDependencies looks up every optional program
+func (s *server) checkDependencies(ctx context.Context) dependencies {
+	d := dependencies{
+		FFmpeg:        ffmpegVersion(ctx),
+		FFprobe:       findTool(ctx, depFFprobe, []string{"-version"}, ffVersion),
+		AtomicParsley: findTool(ctx, depAtomicParsley, []string{"--version"}, atomicParsleyVersion),
+		Mutagen:       mutagenVersion(ctx, s.ytdlp.Path()),
+	}
+	ffmpeg, ffprobe := d.FFmpeg.Error == "", d.FFprobe.Error == ""
+	d.Features = map[string]feature{
+		featureMerge:       needs(ffmpeg, "ffmpeg"),
+		featurePostprocess: needs(ffmpeg, "ffmpeg"),
+		featureProbe:       needs(ffprobe, "ffprobe"),
+		// yt-dlp converts thumbnails with ffmpeg, and embeds them in MP4s
+		// with mutagen, AtomicParsley or, failing those, ffmpeg and ffprobe
+		featureEmbedThumbnail: needs(ffmpeg && (ffprobe || d.Mutagen.Error == "" || d.AtomicParsley.Error == ""),
+			"ffmpeg, and ffprobe, mutagen or AtomicParsley"),
+	}
+	return d
+}
+
+func needs(available bool, what string) feature {
+	if available {
+		return feature{Available: true}
+	}
+	return feature{Needs: what}
+}
+
+// atomicParsleyVersion reads "AtomicParsley version: 20210715.151551.0 ..."
+func atomicParsleyVersion(first string) string {
+	_, version, _ := strings.Cut(first, "version:")
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// logDependencies warns at startup about the features missing programs
+// leave unavailable
+func (s *server) logDependencies(ctx context.Context) {
+	d := s.checkDependencies(ctx)
+	for _, name := range []string{featureMerge, featureEmbedThumbnail, featurePostprocess, featureProbe} {
+		if f := d.Features[name]; !f.Available {
+			log.Printf("Warning: %s unavailable: install %s", featureDescriptions[name], f.Needs)
+		}
+	}
+}
+
+var featureDescriptions = map[string]string{
+	featureMerge:          "merging separate video and audio formats",
+	featureEmbedThumbnail: "embedding thumbnails (--embed-thumbnail)",
+	featurePostprocess:    "converting and embedding with yt-dlp (--remux-video, --embed-subs, ...)",
+	featureProbe:          "probing downloaded files for stream details",
+}
+
+// ffmpegArgs are the yt-dlp flags that run ffmpeg after the download
+var ffmpegArgs = map[string]bool{
+	"--merge-output-format": true,
+	"--remux-video":         true,
+	"--recode-video":        true,
+	"-x":                    true,
+	"--extract-audio":       true,
+	"--embed-subs":          true,
+	"--embed-chapters":      true,
+	"--embed-metadata":      true,
+	"--sponsorblock-remove": true,
+	"--download-sections":   true,
+}
+
+// adaptToDependencies drops the yt-dlp flags in args that need programs
+// which aren't installed, and the merging alternatives of format selectors
+// without ffmpeg, writing a warning for each to out, so the download goes
+// ahead with what can be done rather than failing at the end. argCounts
+// says how many values each flag takes.
+func (s *server) adaptToDependencies(ctx context.Context, args []string, format string, argCounts map[string]int, out io.Writer) ([]string, string) {
+	type flag struct {
+		name  string
+		words []string
+	}
+	var flags []flag
+	needed := strings.Contains(format, "+")
+	for i := 0; i < len(args); i++ {
+		name, value, inline := strings.Cut(args[i], "=")
+		n := 0
+		if !inline {
+			n = min(argCounts[name], len(args)-1-i)
+			if n > 0 {
+				value = args[i+1]
+			}
+		}
+		flags = append(flags, flag{name, args[i : i+1+n]})
+		i += n
+		if ffmpegArgs[name] || name == "--embed-thumbnail" || isFormatFlag(name) && strings.Contains(value, "+") {
+			needed = true
+		}
+	}
+	if !needed {
+		return args, format
+	}
+
+	d := s.checkDependencies(ctx)
+	warn := func(msg string, a ...any) {
+		if out != nil {
+			fmt.Fprintf(out, "WARNING: "+msg+"\n", a...)
+		}
+	}
+	merge := d.Features[featureMerge]
+	single := func(format string) string {
+		if merge.Available || !strings.Contains(format, "+") {
+			return format
+		}
+		f := singleFormat(format)
+		warn("formats can't be merged without %s: downloading %q instead of %q", merge.Needs, f, format)
+		return f
+	}
+	format = single(format)
+
+	var kept []string
+	for _, f := range flags {
+		missing := feature{Available: true}
+		switch {
+		case f.name == "--embed-thumbnail":
+			missing = d.Features[featureEmbedThumbnail]
+		case ffmpegArgs[f.name]:
+			missing = d.Features[featurePostprocess]
+		case isFormatFlag(f.name) && len(f.words) == 2:
+			f.words = []string{f.words[0], single(f.words[1])}
+		case isFormatFlag(f.name) && strings.Contains(f.words[0], "="):
+			name, value, _ := strings.Cut(f.words[0], "=")
+			f.words = []string{name + "=" + single(value)}
+		}
+		if !missing.Available {
+			warn("%s skipped: it needs %s", strings.Join(f.words, " "), missing.Needs)
+			continue
+		}
+		kept = append(kept, f.words...)
+	}
+	return kept, format
+}
+
+func isFormatFlag(name string) bool {
+	return name == "-f" || name == "--format"
+}
+
+// singleFormat is the format selector without the alternatives that merge
+// separate streams, or "b", the best single file, when nothing is left
+func singleFormat(format string) string {
+	var alts []string
+	for _, alt := range strings.Split(format, "/") {
+		if !strings.Contains(alt, "+") {
+			alts = append(alts, alt)
+		}
+	}
+	if len(alts) == 0 {
+		return "b"
+	}
+	return strings.Join(alts, "/")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/lists"
+	"noahjalex.ute/internal/media"
+	"noahjalex.ute/internal/transcribe"
+)
+
+// relatedVideos is how many other videos by the same uploader are shown
+const relatedVideos = 12
+
+// videoDetail is a library entry with everything the detail page shows.
+// The embedded video keeps the JSON a superset of the plain record.
+type videoDetail struct {
+	library.Video
+	Folder       string                `json:"folder"`
+	Sidecars     []library.SidecarFile `json:"sidecars"`
+	SameUploader []library.Video       `json:"same_uploader"`
+	// TranscodeJob is the running transcode, if any
+	TranscodeJob string `json:"transcode_job,omitempty"`
+	// Favorite and WatchLater are the caller's lists
+	Favorite   bool `json:"favorite"`
+	WatchLater bool `json:"watch_later"`
+	// Position is where the caller stopped playing, in seconds
+	Position float64 `json:"position"`
+	// Subtitles is the library path of the transcript's WebVTT file, if any
+	Subtitles string `json:"subtitles,omitempty"`
+	// TranscribeJob is the running transcription, if any
+	TranscribeJob string `json:"transcribe_job,omitempty"`
+	// CanTranscribe is set when transcription is configured
+	CanTranscribe bool `json:"-"`
+	// CanSummarize is set when enrichment is configured
+	CanSummarize bool `json:"-"`
+}
+
+func (s *server) videoDetail(r *http.Request, v library.Video) videoDetail {
+	user := userName(r)
+	d := videoDetail{
+		Video:         v,
+		Folder:        v.Folder(),
+		Sidecars:      s.videos.Sidecars(v),
+		SameUploader:  s.videos.ByUploader(v.Uploader, v.ID, relatedVideos),
+		Favorite:      s.lists.Has(user, lists.Favorites, v.ID),
+		WatchLater:    s.lists.Has(user, lists.WatchLater, v.ID),
+		Position:      s.progress(user, v.ID).Position,
+		CanTranscribe: s.transcriber != nil && !v.IsRemote(),
+		CanSummarize:  s.enricher != nil,
+	}
+	if d.Sidecars == nil {
+		d.Sidecars = []library.SidecarFile{}
+	}
+	if d.SameUploader == nil {
+		d.SameUploader = []library.Video{}
+	}
+	stem := strings.TrimSuffix(v.FilePath, path.Ext(v.FilePath))
+	for _, f := range d.Sidecars {
+		if f.Name == path.Base(stem)+transcribe.Suffix {
+			d.Subtitles = stem + transcribe.Suffix
+		}
+	}
+
+	s.maint.mu.Lock()
+	d.TranscodeJob = s.maint.transcodes[v.ID]
+	d.TranscribeJob = s.maint.transcribes[v.ID]
+	s.maint.mu.Unlock()
+	return d
+}
+
+// handleVideoPage serves GET /videos/{id}, the detail page for a video.
+// The route shares its prefix with file downloads, so anything that isn't
+// a video ID, or is also the name of a file, is served as a file.
+func (s *server) handleVideoPage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	v, ok := s.videos.GetFull(id)
+	if !ok || r.Method != "GET" {
+		s.handleVideoFile(w, r)
+		return
+	}
+	// Only IDs that are safe as paths are looked up on disk; backslashes
+	// would be separators on Windows
+	if _, err := library.CleanRelPath(id); err == nil {
+		if _, err := os.Stat(filepath.Join(s.cfg.VideosDir, id)); err == nil {
+			s.handleVideoFile(w, r)
+			return
+		}
+	}
+
+	s.views.render(w, r, "video", "video-detail", s.videoDetail(r, v))
+}
+
+// handleRedownload serves POST /api/videos/{id}/redownload, replacing the
+// local file with a fresh copy from the video's source page
+func (s *server) handleRedownload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	jobID, err := s.repairVideo(v, jobs.PriorityNormal)
+	if err == errNoSourceURL {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Video has no source URL to re-download from",
+			Code:    http.StatusConflict,
+		})
+		return
+	} else if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to remove the old file",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+jobID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Re-download queued",
+		JobID:   jobID,
+	})
+}
+
+// handleTranscode serves POST /api/videos/{id}/transcode, re-encoding the
+// video to H.264/AAC MP4 in the background
+func (s *server) handleTranscode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	if v.IsRemote() {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Videos on remote storage can't be transcoded",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	job := s.startTranscode(v)
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+job.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Transcode started",
+		JobID:   job.ID,
+	})
+}
+
+// startTranscode re-encodes v as a background job. A video already being
+// transcoded returns the running job.
+func (s *server) startTranscode(v library.Video) jobs.Job {
+	s.maint.mu.Lock()
+	defer s.maint.mu.Unlock()
+
+	if id, ok := s.maint.transcodes[v.ID]; ok {
+		if job, ok := s.jobs.Get(id); ok {
+			return job
+		}
+	}
+	if s.maint.transcodes == nil {
+		s.maint.transcodes = make(map[string]string)
+	}
+
+	job := s.jobs.CreateTask(jobs.KindTranscode)
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.VideoIDs = []string{v.ID} })
+	s.maint.transcodes[v.ID] = job.ID
+
+	go func() {
+		err := s.transcodeVideo(context.Background(), job.ID, v)
+		s.finishTask(job.ID, nil, err)
+		if err != nil {
+			log.Printf("Transcode of %s failed: %v", v.FilePath, err)
+		}
+
+		s.maint.mu.Lock()
+		delete(s.maint.transcodes, v.ID)
+		s.maint.mu.Unlock()
+	}()
+	return job
+}
+
+// transcodeVideo writes an MP4 beside the original, swaps the library
+// record over to it and removes the original
+func (s *server) transcodeVideo(ctx context.Context, jobID string, v library.Video) error {
+	src := s.videos.AbsPath(v)
+	stem := strings.TrimSuffix(src, filepath.Ext(src))
+	target := stem + ".mp4"
+	if target != src {
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("%s already exists", filepath.Base(target))
+		}
+	}
+
+	// The .part suffix keeps the half-written file out of rescans
+	tmp := stem + ".transcode.mp4.part"
+	defer os.Remove(tmp)
+
+	total := int(v.Duration)
+	err := media.Transcode(ctx, src, tmp, func(seconds float64) {
+		s.jobs.Update(jobID, func(j *jobs.Job) {
+			j.Progress = &jobs.Progress{Done: min(int(seconds), total), Total: total}
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	updated, err := s.videos.ReplaceFile(v.ID, target, library.ReplacedByTranscode)
+	if err != nil {
+		return err
+	}
+	if target != src {
+		if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s after transcoding: %v", src, err)
+		}
+	}
+
+	s.enrichVideo(ctx, updated)
+	log.Printf("Transcoded %s to %s", v.FilePath, updated.FilePath)
+	return nil
+}
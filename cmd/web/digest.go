@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/hooks"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/scheduler"
+)
+
+// digestCron is when each digest mode sends its digest, unless schedules
+// has one for the digest task: 08:00 every day, or every Monday
+var digestCron = map[string]string{
+	hooks.DigestDaily:  "0 8 * * *",
+	hooks.DigestWeekly: "0 8 * * 1",
+}
+
+// digestSchedule is when the digest task runs
+func (s *server) digestSchedule() scheduler.Schedule {
+	spec, ok := digestCron[s.cfg.Notifications.Digest]
+	if _, override := s.cfg.Schedules["digest"]; override || !ok {
+		return s.taskSchedule("digest", 0)
+	}
+	schedule, _ := scheduler.ParseCron(spec)
+	return schedule
+}
+
+// addToDigest counts a finished download in the digest: each video it
+// added against its uploader, or the job against its channel when it
+// failed
+func (s *server) addToDigest(e events.Event) {
+	job := *e.Job
+	var err error
+	if e.Type == events.DownloadFailed {
+		err = s.digest.AddFailure(s.jobChannel(job), job, e.Error)
+	} else {
+		var channels []string
+		for _, id := range job.VideoIDs {
+			name := s.jobChannel(job)
+			if v, ok := s.videos.Get(id); ok && v.Uploader != "" {
+				name = v.Uploader
+			}
+			channels = append(channels, name)
+		}
+		err = s.digest.AddVideos(channels)
+	}
+	if err != nil {
+		log.Printf("Failed to save the notification digest: %v", err)
+	}
+}
+
+// jobChannel names where a job's download came from: its subscription,
+// or the site
+func (s *server) jobChannel(job jobs.Job) string {
+	if sub, ok := s.subs.Get(job.Subscription); ok {
+		return sub.Name
+	}
+	if domain := jobs.Domain(job.URL); domain != "" {
+		return domain
+	}
+	return job.URL
+}
+
+// sendDigest notifies about the digest so far and starts the next one. Periods where nothing finished send nothing.
+func (s *server) sendDigest(ctx context.Context) error {
+	digest, err := s.digest.Take()
+	if err != nil {
+		log.Printf("Failed to save the notification digest: %v", err)
+	}
+	if digest.Empty() {
+		return nil
+	}
+	log.Printf("Sending notification digest: %s", digest.Summary())
+	return s.notify(ctx, hooks.Notification{Event: hooks.EventDigest, Digest: &digest})
+}
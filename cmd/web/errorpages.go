@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/i18n"
+)
+
+// fallbackErrorPage is used when templates/error.html is missing or broken
+const fallbackErrorPage = `<!DOCTYPE html>
+<html lang="{{lang}}">
+<head><meta charset="UTF-8"><title>{{.Status}} {{.StatusText}} - Ute</title></head>
+<body>
+<h1>{{.Status}} {{.StatusText}}</h1>
+<p>{{t .Message}}</p>
+{{if .RequestID}}<p>{{t "Request ID"}}: {{.RequestID}}</p>{{end}}
+<p><a href="/">{{t "Back to the library"}}</a></p>
+</body>
+</html>
+`
+
+// errorPages renders error responses as HTML for browsers and JSON for API
+// clients
+type errorPages struct {
+	// tmpls are by interface language
+	tmpls map[string]*template.Template
+}
+
+// loadErrorPages parses dir/error.html. A bad or missing template is logged
+// and replaced by a built-in page rather than stopping the server.
+func loadErrorPages(dir string) *errorPages {
+	p := &errorPages{tmpls: make(map[string]*template.Template)}
+	for _, lang := range i18n.Languages() {
+		tmpl, err := template.New("error.html").Funcs(translateFuncs(lang)).ParseFiles(filepath.Join(dir, "error.html"))
+		if err != nil {
+			if lang == i18n.Default {
+				log.Printf("Warning: using built-in error page: %v", err)
+			}
+			tmpl = template.Must(template.New("error").Funcs(translateFuncs(lang)).Parse(fallbackErrorPage))
+		}
+		p.tmpls[lang] = tmpl
+	}
+	return p
+}
+
+// wantsJSON reports whether the client expects an API error rather than a
+// page: all /api/ routes, and anything asking for JSON
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// write sends an error with status and message in the form the client
+// expects
+func (p *errorPages) write(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) {
+		errType := apperr.TypeUnknown
+		switch {
+		case status == http.StatusNotFound:
+			errType = apperr.TypeNotFound
+		case status == http.StatusForbidden || status == http.StatusUnauthorized:
+			errType = apperr.TypePermission
+		case status < 500:
+			errType = apperr.TypeValidation
+		}
+		writeError(w, &apperr.DownloadError{
+			Type:    errType,
+			Message: message,
+			Code:    status,
+		})
+		return
+	}
+
+	// Render into a buffer first so a failing template can still fall back
+	// to a plain response
+	var buf bytes.Buffer
+	err := p.tmpls[responseLanguage(w)].Execute(&buf, map[string]interface{}{
+		"Status":     status,
+		"StatusText": http.StatusText(status),
+		"Message":    message,
+		"RequestID":  requestID(r.Context()),
+	})
+	if err != nil {
+		log.Printf("Error rendering error page: %v", err)
+		http.Error(w, i18n.Translate(responseLanguage(w), message), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// notFound sends a 404 page or JSON error
+func (p *errorPages) notFound(w http.ResponseWriter, r *http.Request) {
+	p.write(w, r, http.StatusNotFound, "The page or file you asked for doesn't exist.")
+}
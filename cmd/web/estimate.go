@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"noahjalex.ute/internal/downloader"
+	"noahjalex.ute/internal/jobs"
+)
+
+// speedSamples is how many recent downloads the expected speed is taken
+// from
+const speedSamples = 20
+
+// typicalSpeed returns the median average speed of the most recent
+// completed downloads, in bytes per second, or 0 before there are any
+func (s *server) typicalSpeed() int64 {
+	var speeds []int64
+	// List is newest first
+	for _, job := range s.jobs.List() {
+		if job.Kind == jobs.KindDownload && job.State == jobs.StateCompleted && job.AverageSpeed > 0 {
+			speeds = append(speeds, job.AverageSpeed)
+			if len(speeds) == speedSamples {
+				break
+			}
+		}
+	}
+	if len(speeds) == 0 {
+		return 0
+	}
+	slices.Sort(speeds)
+	return speeds[len(speeds)/2]
+}
+
+// estimateProbe works out the size and transfer time of the download a
+// probe describes. It returns nil for playlists, live streams and links
+// whose size the site doesn't report.
+func (s *server) estimateProbe(p *downloader.Probe) *jobs.Estimate {
+	if p == nil || p.EstimatedSize <= 0 {
+		return nil
+	}
+	est := &jobs.Estimate{Size: p.EstimatedSize}
+	if speed := s.typicalSpeed(); speed > 0 {
+		est.Seconds = float64(p.EstimatedSize) / float64(speed)
+	}
+	return est
+}
+
+// estimateJob probes a newly queued download's URL and stores the
+// estimate on the job, holding upcoming premieres until they have ended.
+// Links checked in the UI before they were submitted are answered from
+// the probe cache. Downloads with their own arguments aren't estimated,
+// as the arguments may pick other formats than the probe does.
+func (s *server) estimateJob(job jobs.Job) {
+	if len(job.Args) > 0 {
+		return
+	}
+	go func() {
+		probe, err := s.probeLink(context.Background(), job.URL)
+		if err != nil || !probe.Supported {
+			return
+		}
+		if at := premiereEnd(probe.Probe); at != nil && at.After(time.Now()) {
+			s.jobs.Update(job.ID, func(j *jobs.Job) {
+				if j.State == jobs.StateQueued {
+					j.ScheduledFor = at
+				}
+			})
+		}
+		est := s.estimateProbe(probe.Probe)
+		if est == nil {
+			return
+		}
+		s.jobs.Update(job.ID, func(j *jobs.Job) {
+			j.Estimate = est
+		})
+	}()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"noahjalex.ute/internal/alerts"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/hooks"
+	"noahjalex.ute/internal/library"
+)
+
+// hookEvents maps finished downloads to the hook and webhook event names
+var hookEvents = map[events.Type]string{
+	events.DownloadCompleted: hooks.EventComplete,
+	events.DownloadFailed:    hooks.EventFailure,
+}
+
+// subscribeEvents attaches the server's components to the event bus
+func (s *server) subscribeEvents(ctx context.Context) {
+	s.events.Subscribe("hooks", func(e events.Event) {
+		if err := s.hooks.Run(ctx, hookEvents[e.Type], *e.Job, e.Error); err != nil {
+			log.Printf("Hook error for job %s: %v", e.Job.ID, err)
+		}
+	}, events.DownloadCompleted, events.DownloadFailed)
+
+	// With a digest, finished jobs are only counted until it's sent
+	if s.cfg.Notifications.Digest != "" {
+		s.events.Subscribe("digest", s.addToDigest, events.DownloadCompleted, events.DownloadFailed)
+	} else {
+		s.events.Subscribe("notifications", func(e events.Event) {
+			s.notify(ctx, hooks.Notification{Event: hookEvents[e.Type], Job: e.Job, Error: e.Error})
+		}, events.DownloadCompleted, events.DownloadFailed)
+	}
+
+	s.events.Subscribe("websub", func(e events.Event) {
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("Failed to encode %s event for WebSub: %v", e.Type, err)
+			return
+		}
+		s.websub.Publish(ctx, "application/json", body)
+	}, events.VideoAdded, events.VideoDeleted)
+
+	s.events.Subscribe("alerts", s.alerts.Handle, events.DownloadCompleted, events.DownloadFailed)
+	s.events.Subscribe("metrics", s.metrics.Handle)
+	s.events.Subscribe("history", s.addToHistory, events.DownloadCompleted, events.DownloadFailed)
+	s.startMQTT(ctx)
+}
+
+// publishJob announces a download event with a snapshot of the job
+func (s *server) publishJob(t events.Type, id, errMsg string) {
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		return
+	}
+	s.events.Publish(events.Event{Type: t, Job: &job, Error: errMsg})
+}
+
+// publishVideo announces a video event
+func (s *server) publishVideo(t events.Type, v library.Video) {
+	s.events.Publish(events.Event{Type: t, Video: &v})
+}
+
+// notify passes n to every notifier, returning the first failure. Each
+// failure is logged.
+func (s *server) notify(ctx context.Context, n hooks.Notification) error {
+	var firstErr error
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(ctx, n); err != nil {
+			log.Printf("Notification error (%s) for %s: %v", notifier.Name(), n.Title(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// sendAlert logs an alert and notifies about it straight away, even when
+// finished jobs go in a digest
+func (s *server) sendAlert(ctx context.Context, a alerts.Alert) {
+	log.Printf("Alert: %s", a.Message)
+	s.notify(ctx, hooks.Notification{Event: hooks.EventAlert, Alert: &a})
+}
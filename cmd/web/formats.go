@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"noahjalex.ute/internal/jobs"
+)
+
+// startFormatAnalysis probes every local video without stream details with
+// ffprobe, then records the library's format report as the job's result. A
+// running analysis is returned instead of starting another.
+func (s *server) startFormatAnalysis() jobs.Job {
+	s.maint.mu.Lock()
+	defer s.maint.mu.Unlock()
+
+	if s.maint.analyzeJob != "" {
+		if job, ok := s.jobs.Get(s.maint.analyzeJob); ok {
+			return job
+		}
+	}
+
+	job := s.jobs.CreateTask(jobs.KindAnalyze)
+	s.maint.analyzeJob = job.ID
+
+	go func() {
+		ctx := context.Background()
+		videos := s.videos.List()
+		probed, failed := 0, 0
+		for i, v := range videos {
+			if v.Codec == "" && !v.IsRemote() {
+				if _, err := s.probeVideo(ctx, v); err != nil {
+					failed++
+				} else {
+					probed++
+				}
+			}
+			s.jobs.Update(job.ID, func(j *jobs.Job) {
+				j.Progress = &jobs.Progress{Done: i + 1, Total: len(videos)}
+			})
+		}
+
+		report := s.videos.FormatReport()
+		s.finishTask(job.ID, report, nil)
+		log.Printf("Format analysis: probed %d videos (%d failed), %d candidates", probed, failed, len(report.Candidates))
+
+		s.maint.mu.Lock()
+		s.maint.analyzeJob = ""
+		s.maint.mu.Unlock()
+	}()
+	return job
+}
+
+// handleFormats serves /api/maintenance/formats. GET reports on the stream
+// details already recorded; POST starts a job that probes the videos
+// missing them first and leaves the report as its result.
+func (s *server) handleFormats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.videos.FormatReport())
+
+	case "POST":
+		job := s.startFormatAnalysis()
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{
+			Success: true,
+			Message: "Format analysis started",
+			JobID:   job.ID,
+		})
+
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/history"
+	"noahjalex.ute/internal/jobs"
+)
+
+// grafanaMetric is a series the Grafana JSON datasource can query
+type grafanaMetric struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+var grafanaMetrics = []grafanaMetric{
+	{Label: "Downloads per day", Value: "downloads_per_day"},
+	{Label: "Videos per day", Value: "videos_per_day"},
+	{Label: "Bytes per day", Value: "bytes_per_day"},
+	{Label: "Failures per domain", Value: "failures_per_domain"},
+}
+
+// grafanaQuery is the body of a query from Grafana
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		RefID  string `json:"refId"`
+		Target string `json:"target"`
+		// Type is "table" for a table, and a time series otherwise
+		Type string `json:"type"`
+		Hide bool   `json:"hide"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is a time series in Grafana's format, its datapoints
+// [value, milliseconds since the epoch]
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+// addToHistory counts a finished download in the daily totals
+func (s *server) addToHistory(e events.Event) {
+	var err error
+	if e.Type == events.DownloadCompleted {
+		var bytes int64
+		for _, id := range e.Job.VideoIDs {
+			if v, ok := s.videos.Get(id); ok {
+				bytes += v.Size
+			}
+		}
+		err = s.history.AddDownload(e.Time, len(e.Job.VideoIDs), bytes)
+	} else {
+		err = s.history.AddFailure(e.Time, jobs.Domain(e.Job.URL))
+	}
+	if err != nil {
+		log.Printf("Failed to save the download history: %v", err)
+	}
+}
+
+// handleGrafana serves GET /api/grafana, which Grafana's JSON datasource
+// calls to test the connection
+func (s *server) handleGrafana(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// handleGrafanaMetrics serves POST /api/grafana/metrics, the series the
+// datasource offers
+func (s *server) handleGrafanaMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(grafanaMetrics)
+}
+
+// handleGrafanaSearch serves POST /api/grafana/search, the series names
+// for the older SimpleJSON datasource
+func (s *server) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	names := make([]string, len(grafanaMetrics))
+	for i, m := range grafanaMetrics {
+		names[i] = m.Value
+	}
+	json.NewEncoder(w).Encode(names)
+}
+
+// handleGrafanaQuery serves POST /api/grafana/query with the daily totals
+// over the query's range. failures_per_domain is a series per site, or as
+// a table the site's failures over the whole range.
+func (s *server) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var q grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	if q.Range.To.IsZero() {
+		q.Range.To = time.Now()
+	}
+	if q.Range.From.IsZero() {
+		q.Range.From = q.Range.To.AddDate(0, 0, -30)
+	}
+	days := s.history.Days(q.Range.From, q.Range.To)
+
+	results := []any{}
+	for _, target := range q.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		switch target.Target {
+		case "downloads_per_day":
+			results = append(results, daySeries(target.Target, days, func(d history.Day) float64 { return float64(d.Downloads) }))
+		case "videos_per_day":
+			results = append(results, daySeries(target.Target, days, func(d history.Day) float64 { return float64(d.Videos) }))
+		case "bytes_per_day":
+			results = append(results, daySeries(target.Target, days, func(d history.Day) float64 { return float64(d.Bytes) }))
+		case "failures_per_domain":
+			if target.Type == "table" {
+				results = append(results, failuresTable(days))
+				continue
+			}
+			for _, site := range failedSites(days) {
+				results = append(results, daySeries(site, days, func(d history.Day) float64 { return float64(d.Failures[site]) }))
+			}
+		default:
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Unknown metric",
+				Details: target.Target + " isn't one of downloads_per_day, videos_per_day, bytes_per_day or failures_per_domain",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// daySeries is a point per day, at the start of the day
+func daySeries(target string, days []history.Day, value func(history.Day) float64) grafanaSeries {
+	series := grafanaSeries{Target: target, Datapoints: make([][2]float64, len(days))}
+	for i, d := range days {
+		series.Datapoints[i] = [2]float64{value(d), float64(d.Time().UnixMilli())}
+	}
+	return series
+}
+
+// failedSites are the sites with failures on any of days, by name
+func failedSites(days []history.Day) []string {
+	seen := map[string]bool{}
+	var sites []string
+	for _, d := range days {
+		for site := range d.Failures {
+			if !seen[site] {
+				seen[site] = true
+				sites = append(sites, site)
+			}
+		}
+	}
+	sort.Strings(sites)
+	return sites
+}
+
+// failuresTable totals each site's failures over days, most first
+func failuresTable(days []history.Day) grafanaTable {
+	totals := map[string]int{}
+	for _, d := range days {
+		for site, n := range d.Failures {
+			totals[site] += n
+		}
+	}
+	table := grafanaTable{
+		Type:    "table",
+		Columns: []grafanaColumn{{Text: "Domain", Type: "string"}, {Text: "Failures", Type: "number"}},
+		Rows:    [][]any{},
+	}
+	for _, site := range failedSites(days) {
+		table.Rows = append(table.Rows, []any{site, totals[site]})
+	}
+	sort.SliceStable(table.Rows, func(i, j int) bool { return table.Rows[i][1].(int) > table.Rows[j][1].(int) })
+	return table
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/mqtt"
+)
+
+// haSensor is a library stat announced to Home Assistant, read from the
+// stats message by key
+type haSensor struct {
+	key, name   string
+	unit        string
+	deviceClass string
+	icon        string
+}
+
+var haSensors = []haSensor{
+	{key: "videos", name: "Videos", icon: "mdi:video"},
+	{key: "library_bytes", name: "Library size", unit: "B", deviceClass: "data_size"},
+	{key: "free_bytes", name: "Free disk space", unit: "B", deviceClass: "data_size"},
+	{key: "queued", name: "Queued downloads", icon: "mdi:tray-arrow-down"},
+	{key: "running", name: "Running downloads", icon: "mdi:download"},
+	{key: "failed", name: "Failed downloads", icon: "mdi:alert-circle"},
+}
+
+// mqttStats is published to {topic}/stats
+type mqttStats struct {
+	Videos       int    `json:"videos"`
+	LibraryBytes int64  `json:"library_bytes"`
+	FreeBytes    uint64 `json:"free_bytes"`
+	Queued       int    `json:"queued"`
+	Running      int    `json:"running"`
+	Failed       int    `json:"failed"`
+}
+
+// mqttJob is published to {topic}/job as a download starts and finishes,
+// and the latest completed one kept on {topic}/last_download
+type mqttJob struct {
+	Event string     `json:"event"`
+	JobID string     `json:"job_id"`
+	URL   string     `json:"url"`
+	State jobs.State `json:"state"`
+	Error string     `json:"error,omitempty"`
+	// Title and Uploader are the first video's the download added
+	Title    string    `json:"title,omitempty"`
+	Uploader string    `json:"uploader,omitempty"`
+	Videos   int       `json:"videos"`
+	Time     time.Time `json:"time"`
+}
+
+// openMQTT connects the server to the MQTT broker, when one is
+// configured, leaving "offline" on {topic}/status for when it drops
+func (s *server) openMQTT() error {
+	cfg := s.cfg.Notifications.MQTT
+	if cfg.URL == "" {
+		return nil
+	}
+	client, err := mqtt.Open(cfg.URL, cfg.ClientID)
+	if err != nil {
+		return err
+	}
+	status := cfg.Topic + "/status"
+	client.Will = &mqtt.Message{Topic: status, Payload: []byte("offline")}
+	client.Birth = &mqtt.Message{Topic: status, Payload: []byte("online")}
+	s.mqtt = client
+	return nil
+}
+
+// startMQTT announces the sensors to Home Assistant and publishes the
+// stats, then follows downloads
+func (s *server) startMQTT(ctx context.Context) {
+	if s.mqtt == nil {
+		return
+	}
+	s.events.Subscribe("mqtt", func(e events.Event) {
+		s.publishJobEvent(ctx, e)
+		if e.Type != events.DownloadStarted {
+			s.publishStats(ctx)
+		}
+	}, events.DownloadStarted, events.DownloadCompleted, events.DownloadFailed)
+
+	// An unreachable broker mustn't hold up startup
+	go func() {
+		if s.cfg.Notifications.MQTT.Discovery != "off" {
+			s.announceSensors(ctx)
+		}
+		s.publishStats(ctx)
+	}()
+}
+
+// announceSensors publishes Home Assistant discovery configs for the stats
+// and the last download, retained so Home Assistant finds them whenever it
+// starts
+func (s *server) announceSensors(ctx context.Context) {
+	cfg := s.cfg.Notifications.MQTT
+	device := map[string]any{"identifiers": []string{cfg.ClientID}, "name": "Ute", "manufacturer": "ute"}
+	announce := func(id string, sensor map[string]any) {
+		sensor["unique_id"] = cfg.ClientID + "_" + id
+		sensor["object_id"] = cfg.ClientID + "_" + id
+		sensor["availability_topic"] = cfg.Topic + "/status"
+		sensor["device"] = device
+		s.publishMQTT(ctx, cfg.Discovery+"/sensor/"+cfg.ClientID+"/"+id+"/config", sensor, true)
+	}
+
+	for _, sensor := range haSensors {
+		config := map[string]any{
+			"name":           sensor.name,
+			"state_topic":    cfg.Topic + "/stats",
+			"value_template": "{{ value_json." + sensor.key + " }}",
+			"state_class":    "measurement",
+		}
+		if sensor.unit != "" {
+			config["unit_of_measurement"] = sensor.unit
+		}
+		if sensor.deviceClass != "" {
+			config["device_class"] = sensor.deviceClass
+		}
+		if sensor.icon != "" {
+			config["icon"] = sensor.icon
+		}
+		announce(sensor.key, config)
+	}
+	announce("last_download", map[string]any{
+		"name":                  "Last download",
+		"state_topic":           cfg.Topic + "/last_download",
+		"value_template":        "{{ value_json.title or value_json.url }}",
+		"json_attributes_topic": cfg.Topic + "/last_download",
+		"icon":                  "mdi:video-check",
+	})
+}
+
+// publishStats publishes the library and queue totals, retained
+func (s *server) publishStats(ctx context.Context) {
+	totals := s.videos.Stats()
+	stats := mqttStats{Videos: totals.Videos, LibraryBytes: totals.Bytes}
+	for _, job := range s.jobs.List() {
+		switch job.State {
+		case jobs.StateQueued, jobs.StateRetrying:
+			stats.Queued++
+		case jobs.StateRunning:
+			stats.Running++
+		case jobs.StateFailed:
+			stats.Failed++
+		}
+	}
+	if free, _, err := library.DiskUsage(s.cfg.VideosDir); err == nil {
+		stats.FreeBytes = free
+	}
+	s.publishMQTT(ctx, s.cfg.Notifications.MQTT.Topic+"/stats", stats, true)
+}
+
+// publishJobEvent publishes a download starting or finishing, keeping
+// the latest completed one as the last download
+func (s *server) publishJobEvent(ctx context.Context, e events.Event) {
+	msg := mqttJob{
+		Event:  string(e.Type),
+		JobID:  e.Job.ID,
+		URL:    e.Job.URL,
+		State:  e.Job.State,
+		Error:  e.Error,
+		Videos: len(e.Job.VideoIDs),
+		Time:   e.Time,
+	}
+	if len(e.Job.VideoIDs) > 0 {
+		if v, ok := s.videos.Get(e.Job.VideoIDs[0]); ok {
+			msg.Title, msg.Uploader = v.Title, v.Uploader
+		}
+	}
+	topic := s.cfg.Notifications.MQTT.Topic
+	s.publishMQTT(ctx, topic+"/job", msg, false)
+	if e.Type == events.DownloadCompleted && msg.Videos > 0 {
+		s.publishMQTT(ctx, topic+"/last_download", msg, true)
+	}
+}
+
+// publishMQTT publishes v as JSON, logging failures
+func (s *server) publishMQTT(ctx context.Context, topic string, v any, retain bool) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode the MQTT message for %s: %v", topic, err)
+		return
+	}
+	if err := s.mqtt.Publish(ctx, topic, payload, retain); err != nil {
+		log.Printf("Failed to publish to MQTT topic %s: %v", topic, err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/testutil"
+)
+
+// jobTimeout bounds how long a test waits for a fake download
+const jobTimeout = 20 * time.Second
+
+// startServer serves a fresh server with the harness's config and
+// overrides merged over it, stopping it again when the test ends
+func startServer(t *testing.T, overrides map[string]any) *testutil.Server {
+	t.Helper()
+	return testutil.Start(t, testutil.Options{Config: overrides}, func(cfg config.Config, configPath string) http.Handler {
+		ctx, cancel := context.WithCancel(context.Background())
+		ytdlpManager, downloaders := newDownloaders(ctx, cfg)
+		srv, err := newServer(ctx, cfg, configPath, ytdlpManager, downloaders)
+		if err != nil {
+			cancel()
+			t.Fatalf("starting the server: %v", err)
+		}
+		// Workers, event handlers and the startup rescan write into the
+		// temporary directory, so they must be done before it is removed
+		t.Cleanup(func() {
+			cancel()
+			srv.pool.running.Wait()
+			srv.events.Close()
+			waitRescan(srv)
+		})
+		mux := srv.routes()
+		return chain(mux, srv.middlewares(mux)...)
+	})
+}
+
+// waitRescan waits for the rescan the server starts with to finish
+func waitRescan(s *server) {
+	for {
+		s.maint.mu.Lock()
+		running := s.maint.rescanJob != ""
+		s.maint.mu.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	tests := []struct {
+		name string
+		// config is merged over the harness's
+		config map[string]any
+		run    func(t *testing.T, s *testutil.Server)
+	}{
+		// Submission
+		{
+			name: "submit queues a download",
+			run: func(t *testing.T, s *testutil.Server) {
+				id := s.Submit("https://example.com/watch/1")
+				if job := s.Job(id); job.URL != "https://example.com/watch/1" || job.Kind != jobs.KindDownload {
+					t.Fatalf("job is %s of %s, expected a download of the submitted URL", job.Kind, job.URL)
+				}
+			},
+		},
+		{
+			name: "repeated submission returns the first job",
+			run: func(t *testing.T, s *testutil.Server) {
+				first := s.Submit("https://example.com/watch/1?duration=5s")
+				if again := s.Submit("https://example.com/watch/1?duration=5s"); again != first {
+					t.Fatalf("second submission made job %s, expected %s", again, first)
+				}
+			},
+		},
+		{
+			name: "submission without a link is rejected",
+			run: func(t *testing.T, s *testutil.Server) {
+				expectStatus(t, s.Do("POST", "/", map[string]string{"link": ""}, nil), http.StatusBadRequest)
+			},
+		},
+		{
+			name: "submission with invalid JSON is rejected",
+			run: func(t *testing.T, s *testutil.Server) {
+				expectStatus(t, s.Do("POST", "/", "{", nil), http.StatusBadRequest)
+			},
+		},
+
+		// Progress
+		{
+			name: "progress is reported while downloading",
+			run: func(t *testing.T, s *testutil.Server) {
+				waitTransfer(t, s, s.Submit("https://example.com/watch/1?duration=4s"))
+			},
+		},
+		{
+			name: "failed download is classified",
+			run: func(t *testing.T, s *testutil.Server) {
+				job := s.WaitJob(s.Submit("https://example.com/watch/1?fail=geo"), jobTimeout, jobs.StateFailed, jobs.StateCompleted)
+				if job.State != jobs.StateFailed {
+					t.Fatalf("job is %s, expected failed", job.State)
+				}
+				if last := job.Attempts[len(job.Attempts)-1]; last.ErrorType != apperr.TypeGeo {
+					t.Fatalf("failure is %q, expected %q", last.ErrorType, apperr.TypeGeo)
+				}
+			},
+		},
+		{
+			name: "network failure is retried",
+			run: func(t *testing.T, s *testutil.Server) {
+				job := s.WaitJob(s.Submit("https://example.com/watch/1?fail_attempts=1"), jobTimeout, jobs.StateFailed, jobs.StateCompleted)
+				if job.State != jobs.StateCompleted || len(job.Attempts) != 2 {
+					t.Fatalf("job is %s after %d attempts, expected completed after 2", job.State, len(job.Attempts))
+				}
+			},
+		},
+		{
+			name: "download can be canceled",
+			run: func(t *testing.T, s *testutil.Server) {
+				id := s.Submit("https://example.com/watch/1?duration=10s")
+				waitTransfer(t, s, id)
+				expectStatus(t, s.Do("POST", "/api/jobs/"+id+"/cancel", nil, nil), http.StatusAccepted)
+				s.WaitJob(id, jobTimeout, jobs.StateCanceled)
+			},
+		},
+		{
+			name: "unknown job is not found",
+			run: func(t *testing.T, s *testutil.Server) {
+				expectStatus(t, s.Get("/api/jobs/nonexistent"), http.StatusNotFound)
+			},
+		},
+		{
+			name: "missing dependencies are reported and skipped",
+			run: func(t *testing.T, s *testutil.Server) {
+				var system struct {
+					Dependencies struct {
+						Features map[string]struct {
+							Available bool   `json:"available"`
+							Needs     string `json:"needs"`
+						} `json:"features"`
+					} `json:"dependencies"`
+				}
+				s.Get("/api/system").JSON(t, &system)
+				embed, ok := system.Dependencies.Features["embed_thumbnail"]
+				if !ok {
+					t.Fatalf("/api/system doesn't report embed_thumbnail")
+				}
+				body := map[string]any{"link": "https://example.com/watch/1", "args": []string{"--embed-thumbnail"}}
+				var submitted struct {
+					JobID string `json:"job_id"`
+				}
+				s.Do("POST", "/", body, nil).JSON(t, &submitted)
+				job := s.WaitJob(submitted.JobID, jobTimeout, jobs.StateCompleted, jobs.StateFailed)
+				if job.State != jobs.StateCompleted {
+					t.Fatalf("job is %s, expected completed", job.State)
+				}
+				// Without the programs it needs, the flag is dropped with a warning
+				warned := strings.Contains(string(s.Get("/api/jobs/"+job.ID+"/log").Body), "--embed-thumbnail skipped")
+				if warned == embed.Available {
+					t.Fatalf("embed_thumbnail available is %v, but the job log warned: %v", embed.Available, warned)
+				}
+			},
+		},
+
+		// Listing
+		{
+			name: "finished download is listed",
+			run: func(t *testing.T, s *testutil.Server) {
+				job := s.WaitJob(s.Submit("https://example.com/watch/1?videos=2"), jobTimeout, jobs.StateCompleted)
+				if len(job.VideoIDs) != 2 {
+					t.Fatalf("job added %d videos, expected 2", len(job.VideoIDs))
+				}
+				videos := s.Videos()
+				if len(videos) != 2 {
+					t.Fatalf("library lists %d videos, expected 2", len(videos))
+				}
+				for _, v := range videos {
+					if !strings.HasPrefix(v.Title, "Fake video") {
+						t.Fatalf("video %s has title %q, expected the .info.json's", v.ID, v.Title)
+					}
+				}
+			},
+		},
+		{
+			name: "listing filters by search",
+			run: func(t *testing.T, s *testutil.Server) {
+				s.WaitJob(s.Submit("https://example.com/watch/1"), jobTimeout, jobs.StateCompleted)
+				s.WaitJob(s.Submit("https://example.org/watch/2"), jobTimeout, jobs.StateCompleted)
+				var videos []testutil.Video
+				s.Get("/api/videos?q="+url.QueryEscape("example.org")).JSON(t, &videos)
+				if len(videos) != 1 {
+					t.Fatalf("search found %d videos, expected 1", len(videos))
+				}
+			},
+		},
+		{
+			name: "invalid listing format is rejected",
+			run: func(t *testing.T, s *testutil.Server) {
+				expectStatus(t, s.Get("/api/videos?format=xml"), http.StatusBadRequest)
+			},
+		},
+
+		// Deletion
+		{
+			name: "deleting a video removes its file",
+			run: func(t *testing.T, s *testutil.Server) {
+				s.WaitJob(s.Submit("https://example.com/watch/1"), jobTimeout, jobs.StateCompleted)
+				v := s.Videos()[0]
+				expectStatus(t, s.Do("DELETE", "/api/videos/"+v.ID, nil, nil), http.StatusOK)
+				expectStatus(t, s.Get("/api/videos/"+v.ID), http.StatusNotFound)
+				if _, err := os.Stat(filepath.Join(s.VideosDir, v.Filename)); !os.IsNotExist(err) {
+					t.Fatalf("file %s is still there", v.Filename)
+				}
+			},
+		},
+		{
+			name: "deleting an unknown video is not found",
+			run: func(t *testing.T, s *testutil.Server) {
+				expectStatus(t, s.Do("DELETE", "/api/videos/nonexistent", nil, nil), http.StatusNotFound)
+			},
+		},
+
+		// Streaming
+		{
+			name: "video is streamed whole",
+			run: func(t *testing.T, s *testutil.Server) {
+				s.WaitJob(s.Submit("https://example.com/watch/1?size=5000"), jobTimeout, jobs.StateCompleted)
+				v := s.Videos()[0]
+				resp := s.Get(videoPath(v))
+				expectStatus(t, resp, http.StatusOK)
+				expectBytes(t, resp.Body, readFile(t, s, v), 0, 5000)
+			},
+		},
+		{
+			name: "video is streamed by range",
+			run: func(t *testing.T, s *testutil.Server) {
+				s.WaitJob(s.Submit("https://example.com/watch/1?size=5000"), jobTimeout, jobs.StateCompleted)
+				v := s.Videos()[0]
+				resp := s.Do("GET", videoPath(v), nil, http.Header{"Range": {"bytes=100-199"}})
+				expectStatus(t, resp, http.StatusPartialContent)
+				if got := resp.Header.Get("Content-Range"); got != "bytes 100-199/5000" {
+					t.Fatalf("Content-Range is %q", got)
+				}
+				expectBytes(t, resp.Body, readFile(t, s, v), 100, 200)
+			},
+		},
+		{
+			name: "missing video file is not found",
+			run: func(t *testing.T, s *testutil.Server) {
+				expectStatus(t, s.Get("/videos/missing.mp4"), http.StatusNotFound)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tt.run(t, startServer(t, tt.config))
+		})
+	}
+}
+
+// waitTransfer waits for a running download to report progress
+func waitTransfer(t *testing.T, s *testutil.Server, id string) {
+	t.Helper()
+	deadline := time.Now().Add(jobTimeout)
+	for {
+		job := s.Job(id)
+		if job.State == jobs.StateRunning && job.Transfer != nil && job.Transfer.Percent > 0 {
+			return
+		}
+		if job.State == jobs.StateCompleted || time.Now().After(deadline) {
+			t.Fatalf("no progress seen, job is %s", job.State)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func expectStatus(t *testing.T, resp *testutil.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("status %d, expected %d: %s", resp.StatusCode, want, resp.Body)
+	}
+}
+
+// expectBytes checks got is file[from:to]
+func expectBytes(t *testing.T, got, file []byte, from, to int) {
+	t.Helper()
+	if len(file) < to || !bytes.Equal(got, file[from:to]) {
+		t.Fatalf("body of %d bytes doesn't match bytes %d-%d of the %d-byte file", len(got), from, to, len(file))
+	}
+}
+
+func videoPath(v testutil.Video) string {
+	return "/videos/" + (&url.URL{Path: v.Filename}).EscapedPath()
+}
+
+func readFile(t *testing.T, s *testutil.Server, v testutil.Video) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(s.VideosDir, v.Filename))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return data
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+)
+
+// jobLogPoll is how often a followed log is checked for new output
+const jobLogPoll = 500 * time.Millisecond
+
+// jobLogPruneInterval is how often logs past job_logs.max_age are deleted
+const jobLogPruneInterval = 24 * time.Hour
+
+// pruneJobLogs deletes the logs of jobs that stopped writing them longer
+// ago than the config keeps them
+func (s *server) pruneJobLogs() error {
+	if s.cfg.JobLogs.MaxAge <= 0 {
+		return nil
+	}
+	n, err := s.jobLogs.Prune(time.Duration(s.cfg.JobLogs.MaxAge))
+	if n > 0 {
+		log.Printf("Deleted %d job logs older than %v", n, s.cfg.JobLogs.MaxAge)
+	}
+	return err
+}
+
+// handleJobLog serves GET /api/jobs/{id}/log, everything the job's backend
+// printed, as plain text. ?tail=N sends only the last N lines. ?follow=true
+// keeps the response open and sends output as it is printed until the job
+// completes, fails, is canceled or needs authentication.
+func (s *server) handleJobLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	tail := 0
+	if v := query.Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid tail",
+				Details: "tail must be a number of lines",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		tail = n
+	}
+	follow := false
+	if v := query.Get("follow"); v != "" {
+		var err error
+		if follow, err = strconv.ParseBool(v); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid follow",
+				Details: "follow must be true or false",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+
+	id := r.PathValue("id")
+	// Logs are kept after the server forgets their job, and queued jobs
+	// have none yet
+	data, offset, err := s.jobLogs.Read(id, 0)
+	if os.IsNotExist(err) {
+		if _, ok := s.jobs.Get(id); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeNotFound,
+				Message: "Job not found",
+				Code:    http.StatusNotFound,
+			})
+			return
+		}
+	} else if err != nil {
+		log.Printf("Failed to read the log of job %s: %v", id, err)
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to read the job log",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if tail > 0 {
+		data = lastLines(data, tail)
+	}
+	w.Write(data)
+	if !follow {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	ticker := time.NewTicker(jobLogPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Checking first means a finished job's last output is read below
+		job, ok := s.jobs.Get(id)
+		finished := !ok || job.State == jobs.StateCompleted || job.State == jobs.StateFailed ||
+			job.State == jobs.StateCanceled || job.State == jobs.StateNeedsAuth
+
+		data, offset, err = s.jobLogs.Read(id, offset)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to read the log of job %s: %v", id, err)
+			return
+		}
+		if len(data) > 0 {
+			w.Write(data)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+// lastLines returns the last n lines of data
+func lastLines(data []byte, n int) []byte {
+	end := len(data)
+	// A final newline ends the last line rather than starting another
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
@@ -0,0 +1,728 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/postprocess"
+	"noahjalex.ute/internal/tracing"
+)
+
+// workerPool tracks the goroutines running queued jobs so the pool can be
+// resized while the server runs
+type workerPool struct {
+	mu  sync.Mutex
+	ctx context.Context
+	// stops holds one function per worker; calling it retires the worker
+	// once its current job is done
+	stops []context.CancelFunc
+	// running counts the workers that haven't returned yet
+	running sync.WaitGroup
+}
+
+// startWorkers launches n goroutines running queued jobs until ctx is done
+func (s *server) startWorkers(ctx context.Context, n int) {
+	s.pool.mu.Lock()
+	s.pool.ctx = ctx
+	s.pool.mu.Unlock()
+	s.resizeWorkers(n)
+}
+
+// resizeWorkers grows or shrinks the pool to n workers. Retired workers
+// finish the job they're running first.
+func (s *server) resizeWorkers(n int) {
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+
+	for len(s.pool.stops) < n {
+		stopCtx, stop := context.WithCancel(s.pool.ctx)
+		s.pool.stops = append(s.pool.stops, stop)
+		s.pool.running.Add(1)
+		go s.runWorker(s.pool.ctx, stopCtx)
+	}
+	for len(s.pool.stops) > n {
+		last := len(s.pool.stops) - 1
+		s.pool.stops[last]()
+		s.pool.stops = s.pool.stops[:last]
+	}
+}
+
+// runWorker runs queued jobs until ctx is done or the worker is retired
+// through stopCtx
+func (s *server) runWorker(ctx, stopCtx context.Context) {
+	defer s.pool.running.Done()
+	for stopCtx.Err() == nil {
+		id, ok := s.queue.PopReady(stopCtx, s.jobReady)
+		if !ok {
+			return
+		}
+		s.runJob(ctx, id)
+	}
+}
+
+// jobReady reports whether the queued job with id may start, holding
+// downloads from sites that are cooling off after refusing them, and all
+// downloads once the data budget is used up if it pauses them. In a
+// cluster the download must also be claimed from the other nodes.
+func (s *server) jobReady(id string) bool {
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		// Pushed by another process sharing the Redis queue
+		if job, ok = s.redisJob(id); !ok {
+			return s.redis.queue == nil
+		}
+	}
+	if job.Kind != jobs.KindDownload || job.URL == "" {
+		return true
+	}
+	if job.ScheduledFor != nil && time.Now().Before(*job.ScheduledFor) {
+		return false
+	}
+	if s.cfg.DataBudget.Pause && s.budget.Exceeded() {
+		return false
+	}
+	domain := jobs.Domain(job.URL)
+	if !s.breakers.Allow(domain) {
+		return false
+	}
+	if !s.clusterClaim(id) {
+		s.breakers.Abandoned(domain)
+		return false
+	}
+	return true
+}
+
+// runJob downloads a queued job and adds the result to the library,
+// publishing its start and outcome for hooks and notifications
+func (s *server) runJob(ctx context.Context, id string) {
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		return
+	}
+	s.redisClaim(ctx, job)
+	defer s.redisFinish(ctx, id)
+
+	if err := s.jobs.Transition(id, jobs.StateRunning, nil); err != nil {
+		log.Printf("Not running job %s: %v", id, err)
+		return
+	}
+	s.publishJob(events.DownloadStarted, id, "")
+
+	// The job's spans continue the trace of the request that queued it
+	ctx = tracing.WithTraceparent(ctx, job.Trace)
+	if len(job.Attempts) == 0 {
+		_, wait := s.tracer.Start(ctx, "queue wait", tracing.KindConsumer)
+		wait.SetStart(job.CreatedAt)
+		wait.End()
+	}
+	ctx, span := s.tracer.Start(ctx, "download", tracing.KindConsumer)
+	defer span.End()
+	span.SetAttr("job.id", id)
+	span.SetAttr("url.domain", jobs.Domain(job.URL))
+	span.SetAttr("job.priority", string(job.Priority))
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	s.running.add(id, cancel)
+	result, downloadErr := s.runDownloadJob(jobCtx, job)
+	defer s.clusterFinish(id, result)
+	s.running.remove(id)
+	canceled := jobCtx.Err() != nil && ctx.Err() == nil
+	domain := jobs.Domain(job.URL)
+	switch {
+	case jobCtx.Err() != nil:
+		s.breakers.Abandoned(domain)
+	case downloadErr != nil && errors.Is(downloadErr, apperr.ErrRateLimit):
+		s.breakers.Refused(domain, downloadErr.Message)
+		log.Printf("%s refused job %s: %s", domain, id, downloadErr.Message)
+	default:
+		s.breakers.Served(domain)
+	}
+	cancel()
+	rate, measured := s.bandwidth.Finish(id)
+	s.countData(job, rate, measured, result)
+
+	s.jobs.Update(id, func(j *jobs.Job) {
+		j.Transfer = nil
+		if measured {
+			j.AverageSpeed = rate.Average
+		}
+	})
+	if canceled {
+		span.Fail("canceled")
+		if err := s.jobs.Transition(id, jobs.StateCanceled, nil); err != nil {
+			log.Printf("Failed to mark job %s canceled: %v", id, err)
+		}
+		log.Printf("Job %s canceled for URL %s", id, job.URL)
+		return
+	}
+
+	if downloadErr != nil && errors.Is(downloadErr, apperr.ErrUpcoming) {
+		// runDownloadJob queued it again for after the premiere
+		return
+	}
+	if downloadErr != nil {
+		span.SetAttr("error.type", downloadErr.Type)
+		span.Fail(downloadErr.Message)
+		log.Printf("Job %s failed for URL %s: %s", id, job.URL, downloadErr.Message)
+		s.publishJob(events.DownloadFailed, id, downloadErr.Message)
+		return
+	}
+	// Upgrades have already replaced their video's file
+	if job.Upgrade == "" {
+		libraryCtx, librarySpan := tracing.Start(ctx, "library add")
+		s.addToLibrary(libraryCtx, job, result)
+		librarySpan.End()
+	}
+	if err := s.jobs.Transition(id, jobs.StateCompleted, nil); err != nil {
+		log.Printf("Failed to mark job %s completed: %v", id, err)
+	}
+	log.Printf("Job %s completed for URL %s", id, job.URL)
+	s.publishJob(events.DownloadCompleted, id, "")
+}
+
+// finishTask ends the maintenance job with id, completed with its result
+// or failed with err
+func (s *server) finishTask(id string, result interface{}, err error) {
+	state := jobs.StateCompleted
+	if err != nil {
+		state = jobs.StateFailed
+	}
+	terr := s.jobs.Transition(id, state, func(j *jobs.Job) error {
+		if result != nil {
+			j.Result = result
+		}
+		if err != nil {
+			j.Attempts = append(j.Attempts, jobs.Attempt{Number: 1, Error: err.Error()})
+		}
+		return nil
+	})
+	if terr != nil {
+		log.Printf("Failed to finish job %s: %v", id, terr)
+	}
+}
+
+// addToLibrary indexes the files a job produced and runs the
+// post-processors its profile enables, such as uploading to remote storage
+func (s *server) addToLibrary(ctx context.Context, job jobs.Job, result *downloader.Result) {
+	var added []library.Video
+	for _, file := range result.Files {
+		if !library.IsVideoFile(file) {
+			continue
+		}
+		v, err := s.videos.AddFile(file)
+		if err != nil {
+			log.Printf("Failed to index %s: %v", file, err)
+			continue
+		}
+		if _, err := s.probeVideo(ctx, v); err != nil {
+			log.Printf("Skipping media probe for %s: %v", v.FilePath, err)
+		}
+		added = append(added, v)
+	}
+
+	if err := s.videos.SaveMetadata(); err != nil {
+		log.Printf("Failed to save library metadata: %v", err)
+	}
+
+	// Galleries are kept as albums, with any clips also indexed as videos
+	var albums []library.Album
+	if result.Backend == (&downloader.GalleryDL{}).Name() {
+		var err error
+		if albums, err = s.videos.AddAlbum(job.URL, job.User, result.Files); err != nil {
+			log.Printf("Failed to record album for %s: %v", job.URL, err)
+		}
+	}
+
+	s.jobs.Update(job.ID, func(j *jobs.Job) {
+		for _, v := range added {
+			j.VideoIDs = append(j.VideoIDs, v.ID)
+		}
+		for _, a := range albums {
+			j.AlbumIDs = append(j.AlbumIDs, a.ID)
+		}
+	})
+
+	var ids []string
+	for _, v := range added {
+		ids = append(ids, v.ID)
+	}
+	s.accountDownload(job, result.Files, ids)
+	if job.Subscription != "" {
+		s.tagSubscription(job.Subscription, ids)
+	}
+
+	item := &postprocess.Item{Job: job, Files: result.Files, Videos: added}
+	steps := s.postProcessors.Run(ctx, item, s.profile(job.Profile))
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.Steps = steps })
+
+	if sub, ok := s.subs.Get(job.Subscription); ok {
+		s.trimSubscription(ctx, sub)
+	}
+}
+
+// runDownloadJob downloads the job's URL, retrying transient failures with
+// exponential backoff and recording every attempt on the job. Geo-blocked
+// downloads are retried through each configured proxy in turn. Files are
+// downloaded to the job's work folder and moved into the library once the
+// download succeeds. Clips are downloaded as the part of their source
+// video they cover, and premieres that haven't begun are queued again for
+// after they end.
+func (s *server) runDownloadJob(ctx context.Context, job jobs.Job) (*downloader.Result, *apperr.DownloadError) {
+	job = s.resolveClip(ctx, job)
+	jobID, link := job.ID, job.URL
+	var section *downloader.Section
+	if job.Clip != nil {
+		link, section = job.Clip.Source, &downloader.Section{Start: job.Clip.Start, End: job.Clip.End}
+	}
+	outputDir := filepath.Join(s.cfg.VideosDir, filepath.FromSlash(job.Folder))
+	workDir, err := filepath.Abs(s.workDir(jobID))
+	if err != nil {
+		workDir = s.workDir(jobID)
+	}
+	// Retries reuse the folder so yt-dlp can resume partial files
+	defer os.RemoveAll(workDir)
+	// The log outlives the work folder so failures can be looked into
+	var output io.Writer
+	logw, err := s.jobLogs.Open(jobID)
+	if err != nil {
+		log.Printf("Failed to open the log of job %s: %v", jobID, err)
+	} else {
+		defer logw.Close()
+		output = logw
+	}
+	policy := s.cfg.Retry
+	proxy := ""
+	nextProxy := 0
+	retries := 0
+
+	// Sites that need a signed-in account get the cookies stored for them
+	cookies := ""
+	defer func() {
+		if cookies != "" {
+			os.Remove(cookies)
+		}
+	}()
+	signIn := func() bool {
+		stored, ok := s.cookies.For(hostname(link))
+		if !ok {
+			return false
+		}
+		path, err := copyCookies(stored, workDir)
+		if err != nil {
+			log.Printf("Failed to copy the cookies for %s: %v", link, err)
+			return false
+		}
+		cookies = path
+		return true
+	}
+	// A job retried after asking for a sign-in starts with the cookies
+	if n := len(job.Attempts); n > 0 && job.Attempts[n-1].ErrorType == apperr.TypeAuth {
+		signIn()
+	}
+
+	reportTransfer := func(p downloader.Progress) {
+		// A late report after the download finished is ignored
+		s.jobs.SetStage(jobID, jobs.StageDownloading)
+		rate := s.bandwidth.Report(jobID, p.Speed)
+		s.jobs.Update(jobID, func(j *jobs.Job) {
+			j.Transfer = &jobs.Transfer{
+				Percent:         p.Percent,
+				TotalBytes:      p.TotalBytes,
+				Speed:           p.Speed,
+				ETASeconds:      p.ETA.Seconds(),
+				AverageSpeed:    rate.Average,
+				RecentSpeed:     rate.Last10s,
+				DownloadedBytes: rate.Bytes,
+			}
+		})
+	}
+
+	format := s.settings.Get().DefaultFormat
+	site := s.siteOptions(job.Profile)
+
+	// Subscription checks skip videos they've already fetched, and
+	// download and name them as the subscription says
+	archive, playlistEnd, outputTemplate := "", 0, ""
+	var languages []string
+	var filter *downloader.Filter
+	if job.Subscription != "" {
+		archive = s.archivePath()
+		// Backfills are bounded by their own limits instead
+		if !job.Backfill {
+			playlistEnd = s.cfg.Subscriptions.MaxItems
+		}
+		if sub, ok := s.subs.Get(job.Subscription); ok {
+			languages, filter, outputTemplate = sub.Languages, sub.Filter, sub.OutputTemplate
+			if sub.Format != "" {
+				format = sub.Format
+			}
+		}
+	}
+	// The submitter's limits narrow a playlist further
+	items, after, before, maxSize := "", "", "", int64(0)
+	if l := job.Limits; l != nil {
+		if l.MaxItems > 0 && (playlistEnd == 0 || l.MaxItems < playlistEnd) {
+			playlistEnd = l.MaxItems
+		}
+		items, after, before, maxSize = l.Items, l.After, l.Before, l.MaxFileSize
+	}
+
+	// What needs a program that isn't installed is left out, with a
+	// warning in the log, rather than failing the download at the end
+	args, format := s.adaptToDependencies(ctx, job.Args, format, s.cfg.YtDlp.AllowedArgs, output)
+
+	for attempt := 1; ; attempt++ {
+		record := jobs.Attempt{Number: attempt, StartedAt: time.Now(), Proxy: redactProxy(proxy), Authenticated: cookies != ""}
+		if logw != nil {
+			logw.StartAttempt(attempt)
+		}
+		attemptCtx, attemptSpan := tracing.Start(ctx, "download attempt")
+		attemptSpan.SetAttr("attempt", attempt)
+		result, downloadErr := s.download(attemptCtx, job.ID, downloader.Request{
+			URL:            link,
+			Format:         format,
+			OutputDir:      workDir,
+			OutputTemplate: outputTemplate,
+			ExtraArgs:      args,
+			Proxy:          proxy,
+			Archive:        archive,
+			Backfill:       job.Backfill,
+			PlaylistEnd:    playlistEnd,
+			PlaylistItems:  items,
+			DateAfter:      after,
+			DateBefore:     before,
+			MaxFileSize:    maxSize,
+			Section:        section,
+			Languages:      languages,
+			Filter:         filter,
+			Site:           site,
+			Cookies:        cookies,
+			Progress:       reportTransfer,
+			Log:            output,
+		})
+		record.FinishedAt = time.Now()
+		if downloadErr != nil {
+			attemptSpan.SetAttr("error.type", downloadErr.Type)
+			attemptSpan.Fail(downloadErr.Message)
+		}
+		attemptSpan.End()
+		if downloadErr != nil && logw != nil {
+			record.Log = logw.Excerpt()
+		}
+
+		if downloadErr == nil {
+			s.jobs.SetStage(jobID, jobs.StagePostprocessing)
+		}
+		if downloadErr == nil && job.Upgrade != "" {
+			downloadErr = s.applyUpgrade(ctx, job, result.Files)
+		} else if downloadErr == nil {
+			if result.Files, err = moveIntoLibrary(workDir, outputDir, result.Files); err != nil {
+				downloadErr = &apperr.DownloadError{
+					Type:    apperr.TypeFileSystem,
+					Message: "Failed to move the download into the library",
+					Details: err.Error(),
+					Code:    http.StatusInternalServerError,
+				}
+			}
+		}
+		if downloadErr == nil {
+			s.jobs.Update(jobID, func(j *jobs.Job) {
+				j.Attempts = append(j.Attempts, record)
+			})
+			return result, nil
+		}
+
+		record.ErrorType = downloadErr.Type
+		record.Error = downloadErr.Message
+
+		// Proxy fallbacks don't count against the retry budget
+		if errors.Is(downloadErr, apperr.ErrGeo) && nextProxy < len(s.cfg.Geo.Proxies) && ctx.Err() == nil {
+			proxy = s.cfg.Geo.Proxies[nextProxy]
+			nextProxy++
+			s.jobs.Update(jobID, func(j *jobs.Job) {
+				j.Attempts = append(j.Attempts, record)
+			})
+			log.Printf("%s is geo-restricted, retrying through proxy %s", link, redactProxy(proxy))
+			continue
+		}
+
+		// Neither does signing in
+		if errors.Is(downloadErr, apperr.ErrAuth) && cookies == "" && ctx.Err() == nil && signIn() {
+			s.jobs.Update(jobID, func(j *jobs.Job) {
+				j.Attempts = append(j.Attempts, record)
+			})
+			log.Printf("%s needs a signed-in account, retrying with the stored cookies", link)
+			continue
+		}
+
+		// Premieres wait in the queue rather than taking a worker
+		if errors.Is(downloadErr, apperr.ErrUpcoming) && ctx.Err() == nil {
+			s.schedulePremiere(ctx, job, record)
+			return nil, downloadErr
+		}
+
+		retries++
+		if !apperr.IsRetryable(downloadErr) || retries >= policy.MaxAttempts || ctx.Err() != nil {
+			if ctx.Err() != nil {
+				// runJob records the cancellation
+				s.jobs.Update(jobID, func(j *jobs.Job) { j.Attempts = append(j.Attempts, record) })
+				return nil, downloadErr
+			}
+			state := jobs.StateFailed
+			if errors.Is(downloadErr, apperr.ErrAuth) {
+				state = jobs.StateNeedsAuth
+			}
+			err := s.jobs.Transition(jobID, state, func(j *jobs.Job) error {
+				j.Attempts = append(j.Attempts, record)
+				return nil
+			})
+			if err != nil {
+				log.Printf("Failed to mark job %s %s: %v", jobID, state, err)
+			}
+			return nil, downloadErr
+		}
+
+		delay := jobs.Backoff(retries, time.Duration(policy.BaseDelay), time.Duration(policy.MaxDelay))
+		record.RetryDelay = delay.String()
+		s.jobs.Transition(jobID, jobs.StateRetrying, func(j *jobs.Job) error {
+			j.Attempts = append(j.Attempts, record)
+			return nil
+		})
+		log.Printf("Attempt %d/%d for %s failed (%s), retrying in %v", retries, policy.MaxAttempts, link, downloadErr.Message, delay)
+
+		select {
+		case <-ctx.Done():
+			// runJob records the cancellation
+			return nil, downloadErr
+		case <-time.After(delay):
+		}
+
+		s.jobs.Transition(jobID, jobs.StateRunning, nil)
+	}
+}
+
+// hostname is the host name of link without its port
+func hostname(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// redactProxy hides proxy credentials for logs and job history
+func redactProxy(proxy string) string {
+	if proxy == "" {
+		return ""
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return "(invalid proxy)"
+	}
+	return u.Redacted()
+}
+
+// handleJobList serves GET /api/jobs
+func handleJobList(store *jobs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "GET" {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(store.List())
+	}
+}
+
+// handleJob serves GET /api/jobs/{id}
+func handleJob(store *jobs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "GET" {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		job, ok := store.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeNotFound,
+				Message: "Job not found",
+				Code:    http.StatusNotFound,
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// handleQueue serves GET /api/queue, listing queued jobs in dispatch order
+func handleQueue(queue jobs.Queue, store *jobs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "GET" {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		pending := []jobs.Job{}
+		for _, id := range queue.Pending() {
+			if job, ok := store.Get(id); ok {
+				pending = append(pending, job)
+			}
+		}
+		json.NewEncoder(w).Encode(pending)
+	}
+}
+
+// handleQueueDomains serves GET /api/queue/domains, the sites that have
+// refused downloads and whether their jobs are being held
+func (s *server) handleQueueDomains(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(s.breakers.States())
+}
+
+// handleQueueDomain serves DELETE /api/queue/domains/{domain}, closing the
+// site's circuit so its held jobs start again before the cooldown ends
+func (s *server) handleQueueDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	domain := jobs.Domain("https://" + r.PathValue("domain"))
+	if !s.breakers.Reset(domain) {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "No downloads from " + domain + " are being held",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	log.Printf("Resumed downloads from %s", domain)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Downloads resumed",
+	})
+}
+
+// handleJobPriority serves POST /api/jobs/{id}/priority
+func handleJobPriority(queue jobs.Queue, store *jobs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "POST" {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		var body struct {
+			Priority string `json:"priority"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid JSON in request body",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		priority, err := jobs.ParsePriority(body.Priority)
+		if err != nil || body.Priority == "" {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Priority must be high, normal or low",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		id := r.PathValue("id")
+		if err := queue.SetPriority(id, priority); err != nil {
+			writeQueueError(w, store, id)
+			return
+		}
+		store.Update(id, func(j *jobs.Job) { j.Priority = priority })
+
+		job, _ := store.Get(id)
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// handleJobMove serves POST /api/jobs/{id}/move, placing a queued job at a
+// position in the queue (0 runs next) for drag-and-drop reordering
+func handleJobMove(queue jobs.Queue, store *jobs.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "POST" {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		var body struct {
+			Position *int `json:"position"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Position == nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Request body must contain a position",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		id := r.PathValue("id")
+		if err := queue.Move(id, *body.Position); err != nil {
+			writeQueueError(w, store, id)
+			return
+		}
+
+		json.NewEncoder(w).Encode(queue.Pending())
+	}
+}
+
+// writeQueueError reports why a job couldn't be reordered
+func writeQueueError(w http.ResponseWriter, store *jobs.Store, id string) {
+	if _, ok := store.Get(id); !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Job not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypeValidation,
+		Message: "Only queued jobs can be reordered",
+		Code:    http.StatusConflict,
+	})
+}
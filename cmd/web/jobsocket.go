@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/websocket"
+)
+
+// jobSocketBuffer is how many job updates wait for a WebSocket client
+// before its drop policy applies
+const jobSocketBuffer = 64
+
+// handleJobSocket serves GET /api/jobs/ws, a WebSocket that sends a job as
+// a JSON message each time one is created or changes. ?ids= takes
+// comma-separated job IDs to follow instead of every job; their current
+// states are sent first. ?drop= is what happens to updates a slow client
+// hasn't room for: "oldest" (default) drops the oldest waiting, "newest"
+// the one that didn't fit, and "disconnect" closes the socket.
+func (s *server) handleJobSocket(w http.ResponseWriter, r *http.Request) {
+	policy, err := jobs.ParseDropPolicy(r.URL.Query().Get("drop"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid drop policy",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	// Subscribing before the upgrade means no update falls between the
+	// current states and the stream
+	sub := s.jobs.Subscribe(ids, jobSocketBuffer, policy)
+	defer sub.Close()
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		var he *websocket.HandshakeError
+		if errors.As(err, &he) {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: he.Message,
+				Code:    he.Status,
+			})
+			return
+		}
+		log.Printf("Failed to open job socket: %v", err)
+		return
+	}
+
+	send := func(job jobs.Job) bool {
+		data, err := json.Marshal(job)
+		if err != nil {
+			log.Printf("Failed to encode job %s: %v", job.ID, err)
+			return true
+		}
+		return conn.WriteText(data) == nil
+	}
+
+	// Updates already waiting may predate the states sent here
+	sent := make(map[string]time.Time)
+	for _, id := range ids {
+		if job, ok := s.jobs.Get(id); ok {
+			if !send(job) {
+				return
+			}
+			sent[id] = job.UpdatedAt
+		}
+	}
+
+	for {
+		select {
+		case <-conn.Done():
+			return
+		case job, ok := <-sub.C:
+			if !ok {
+				conn.Close(websocket.ClosePolicy, fmt.Sprintf("fell behind, %d updates dropped", sub.Dropped()))
+				return
+			}
+			if job.UpdatedAt.Before(sent[job.ID]) {
+				continue
+			}
+			if !send(job) {
+				return
+			}
+		}
+	}
+}
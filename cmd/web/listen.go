@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// unixSocketMode lets a reverse proxy in the server's group connect to its
+// socket
+const unixSocketMode = 0660
+
+// listenAll opens a listener for each of the comma-separated addresses in
+// addrs, such as "0.0.0.0:8591,[::]:8591", returning them with where each
+// can be reached
+func listenAll(addrs string) ([]net.Listener, []string, error) {
+	var listeners []net.Listener
+	var wheres []string
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		ls, where, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		listeners = append(listeners, ls...)
+		wheres = append(wheres, where...)
+	}
+	if len(listeners) == 0 {
+		return nil, nil, errors.New("no address to listen on")
+	}
+	return listeners, wheres, nil
+}
+
+// listen opens the listeners addr names: "unix:/path/to/ute.sock" for a
+// Unix socket, "systemd" for the sockets systemd passed in ("systemd:name"
+// for those named name by FileDescriptorName=), or a TCP address such as
+// ":8591" or "[::1]:8591". It also returns where the server can be
+// reached, for the startup message.
+func listen(addr string) ([]net.Listener, []string, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return nil, nil, errors.New("unix: needs a socket path")
+		}
+		// A socket left behind by a server that didn't shut down cleanly
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := os.Chmod(path, unixSocketMode); err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		return []net.Listener{l}, []string{"unix:" + path}, nil
+	}
+
+	if addr == "systemd" || strings.HasPrefix(addr, "systemd:") {
+		name, _ := strings.CutPrefix(addr, "systemd:")
+		if addr == "systemd" {
+			name = ""
+		}
+		ls, err := systemdListeners(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		var wheres []string
+		for _, l := range ls {
+			where := "unix:" + l.Addr().String()
+			if l.Addr().Network() == "tcp" {
+				where = "http://" + l.Addr().String()
+			}
+			wheres = append(wheres, where+" (from systemd)")
+		}
+		return ls, wheres, nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	host := addr
+	if strings.HasPrefix(host, ":") {
+		host = "0.0.0.0" + host
+	}
+	return []net.Listener{l}, []string{"http://" + host}, nil
+}
+
+// systemdSocket is a socket passed in by systemd
+type systemdSocket struct {
+	name     string
+	listener net.Listener
+	// taken is set once an address has used the socket
+	taken bool
+}
+
+var (
+	systemdOnce    sync.Once
+	systemdSockets []*systemdSocket
+	systemdErr     error
+)
+
+// systemdListeners returns the sockets passed in by systemd socket
+// activation with name, or all not yet used for an empty name. They are
+// read once, following sd_listen_fds(3): the sockets start at file
+// descriptor 3, LISTEN_PID names the process they are meant for and
+// LISTEN_FDNAMES names them.
+func systemdListeners(name string) ([]net.Listener, error) {
+	systemdOnce.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			systemdErr = errors.New("no socket passed in by systemd (LISTEN_PID is not this process)")
+			return
+		}
+		fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || fds < 1 {
+			systemdErr = errors.New("no socket passed in by systemd (LISTEN_FDS is not set)")
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		// Not passed on to the download processes the server starts
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		for i := 0; i < fds; i++ {
+			f := os.NewFile(uintptr(3+i), "systemd-socket")
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				systemdErr = fmt.Errorf("using socket %d passed in by systemd: %w", i+1, err)
+				return
+			}
+			s := &systemdSocket{listener: l}
+			if i < len(names) {
+				s.name = names[i]
+			}
+			systemdSockets = append(systemdSockets, s)
+		}
+	})
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+
+	var ls []net.Listener
+	for _, s := range systemdSockets {
+		if s.taken || (name != "" && s.name != name) {
+			continue
+		}
+		s.taken = true
+		ls = append(ls, s.listener)
+	}
+	if len(ls) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("no socket named %q passed in by systemd", name)
+		}
+		return nil, errors.New("no socket left from those passed in by systemd")
+	}
+	return ls, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/lists"
+)
+
+// parseQuery reads a library query from params, resolving the favorite,
+// watch_later and in_progress filters for the user who made r
+func (s *server) parseQuery(r *http.Request, params url.Values) (library.Query, error) {
+	q, err := library.ParseQuery(params)
+	user := userName(r)
+	q.Marked = func(id string) library.Marks {
+		_, playing := s.playback.Get(user, id)
+		return library.Marks{
+			Favorite:   s.lists.Has(user, lists.Favorites, id),
+			WatchLater: s.lists.Has(user, lists.WatchLater, id),
+			InProgress: playing,
+		}
+	}
+	return q, err
+}
+
+// filtersMarks reports whether q filters on the caller's own lists or
+// playback positions, which change without the library changing
+func filtersMarks(q library.Query) bool {
+	return q.Favorite != nil || q.WatchLater != nil || q.InProgress != nil
+}
+
+// handleListToggle serves POST and DELETE /api/videos/{id}/favorite and
+// /api/videos/{id}/watch-later, putting a video on the caller's list or
+// taking it off. field names the flag in the response.
+func (s *server) handleListToggle(list lists.List, field string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "POST" && r.Method != "DELETE" {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+		on := r.Method == "POST"
+
+		v, ok := s.videos.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeNotFound,
+				Message: "Video not found",
+				Code:    http.StatusNotFound,
+			})
+			return
+		}
+
+		if err := s.lists.Set(userName(r), list, v.ID, on); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to save the list",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		if isHTMX(r) {
+			w.Header().Set("HX-Refresh", "true")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":  v.ID,
+			field: on,
+		})
+	}
+}
+
+// listedVideo is a video on one of a user's lists
+type listedVideo struct {
+	library.Video
+	ListedAt time.Time `json:"listed_at"`
+}
+
+// listVideos returns the videos on user's list in the order they were
+// added, skipping any no longer in the library
+func (s *server) listVideos(user string, list lists.List) []listedVideo {
+	entries := s.lists.Entries(user, list)
+	videos := make([]listedVideo, 0, len(entries))
+	for _, e := range entries {
+		if v, ok := s.videos.Get(e.VideoID); ok {
+			videos = append(videos, listedVideo{Video: v, ListedAt: e.AddedAt})
+		}
+	}
+	return videos
+}
+
+// handleFavorites serves GET /api/favorites, the caller's favorites,
+// most recently added first
+func (s *server) handleFavorites(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	videos := s.listVideos(userName(r), lists.Favorites)
+	for i, j := 0, len(videos)-1; i < j; i, j = i+1, j-1 {
+		videos[i], videos[j] = videos[j], videos[i]
+	}
+	json.NewEncoder(w).Encode(videos)
+}
+
+// handleWatchLater serves GET /api/watch-later, the caller's watch-later
+// queue in the order it was added to
+func (s *server) handleWatchLater(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.listVideos(userName(r), lists.WatchLater))
+}
+
+// rss is an RSS 2.0 document
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Description string       `xml:"description,omitempty"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+}
+
+// rssGUID identifies an item; video IDs aren't links
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// baseURL is the scheme and host r was made to, for absolute feed links
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handleWatchLaterFeed serves GET /feeds/watch-later.rss, the caller's
+// watch-later queue as a podcast-style RSS feed whose enclosures are the
+// video files
+func (s *server) handleWatchLaterFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This feed only supports GET.")
+		return
+	}
+
+	base := baseURL(r)
+	feed := rss{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "ute: watch later",
+			Link:        base + "/library?watch_later=true",
+			Description: "Videos queued to watch later",
+		},
+	}
+	if name := userName(r); name != "" {
+		feed.Channel.Title += " (" + name + ")"
+	}
+
+	for _, v := range s.listVideos(userName(r), lists.WatchLater) {
+		// Listings leave descriptions out
+		if full, ok := s.videos.GetFull(v.ID); ok {
+			v.Video = full
+		}
+		contentType := mime.TypeByExtension(path.Ext(v.FilePath))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       v.Title,
+			Link:        base + "/videos/" + url.PathEscape(v.ID),
+			GUID:        rssGUID{Value: v.ID},
+			PubDate:     v.ListedAt.UTC().Format(time.RFC1123Z),
+			Description: v.Description,
+			Enclosure: rssEnclosure{
+				URL:    base + "/videos/" + escapePath(v.FilePath),
+				Length: v.Size,
+				Type:   contentType,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Failed to write watch-later feed: %v", err)
+	}
+}
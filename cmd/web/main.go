@@ -1,66 +1,103 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
-)
-
-type VideoInfo struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Uploader    string `json:"uploader"`
-	UploadDate  string `json:"upload_date"`
-	Description string `json:"description"`
-	ViewCount   int    `json:"view_count"`
-	WebpageURL  string `json:"webpage_url"`
-}
 
-// DownloadError represents a structured error response
-type DownloadError struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
-	Details string `json:"details,omitempty"`
-	Code    int    `json:"code"`
-}
-
-// Error types
-const (
-	ErrorTypeValidation = "validation_error"
-	ErrorTypeNetwork    = "network_error"
-	ErrorTypeNotFound   = "not_found_error"
-	ErrorTypeBinary     = "binary_error"
-	ErrorTypePermission = "permission_error"
-	ErrorTypeFileSystem = "filesystem_error"
-	ErrorTypeUnknown    = "unknown_error"
+	"noahjalex.ute/internal/accesslog"
+	"noahjalex.ute/internal/alerts"
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/budget"
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/cookies"
+	"noahjalex.ute/internal/downloader"
+	"noahjalex.ute/internal/enrich"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/history"
+	"noahjalex.ute/internal/hooks"
+	"noahjalex.ute/internal/i18n"
+	"noahjalex.ute/internal/joblog"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/lists"
+	"noahjalex.ute/internal/playback"
+	"noahjalex.ute/internal/postprocess"
+	"noahjalex.ute/internal/rclone"
+	"noahjalex.ute/internal/redis"
+	"noahjalex.ute/internal/scheduler"
+	"noahjalex.ute/internal/settings"
+	"noahjalex.ute/internal/storage"
+	"noahjalex.ute/internal/subscriptions"
+	"noahjalex.ute/internal/throughput"
+	"noahjalex.ute/internal/timefmt"
+	"noahjalex.ute/internal/tracing"
+	"noahjalex.ute/internal/transcribe"
+	"noahjalex.ute/internal/usage"
+	"noahjalex.ute/internal/websub"
+	"noahjalex.ute/internal/ytdlp"
 )
 
 // Response structures
 type SuccessResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	JobID   string `json:"job_id,omitempty"`
+	// Quota is the user's usage before the request, when there are users
+	Quota *quotaStatus `json:"quota,omitempty"`
 }
 
 type ErrorResponse struct {
-	Success bool           `json:"success"`
-	Error   *DownloadError `json:"error"`
+	Success bool                  `json:"success"`
+	Error   *apperr.DownloadError `json:"error"`
+	JobID   string                `json:"job_id,omitempty"`
+}
+
+// writeError sends err as a JSON ErrorResponse with its status code
+func writeError(w http.ResponseWriter, err *apperr.DownloadError) {
+	// The message is translated for the client; details are left as the
+	// underlying error wrote them
+	translated := *err
+	translated.Message = i18n.Translate(responseLanguage(w), err.Message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Code)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Success: false,
+		Error:   &translated,
+	})
+}
+
+// writeMethodNotAllowed rejects a request made with an unsupported method
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Invalid method %s for %s endpoint", r.Method, r.URL.Path)
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypeValidation,
+		Message: "Method not supported",
+		Details: fmt.Sprintf("Method %s is not allowed for this endpoint", r.Method),
+		Code:    http.StatusMethodNotAllowed,
+	})
 }
 
 // validateURL performs basic URL validation
-func validateURL(urlStr string) *DownloadError {
+func validateURL(urlStr string) *apperr.DownloadError {
 	if strings.TrimSpace(urlStr) == "" {
-		return &DownloadError{
-			Type:    ErrorTypeValidation,
+		return &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
 			Message: "URL cannot be empty",
 			Code:    http.StatusBadRequest,
 		}
@@ -69,8 +106,8 @@ func validateURL(urlStr string) *DownloadError {
 	// Parse URL
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
-		return &DownloadError{
-			Type:    ErrorTypeValidation,
+		return &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
 			Message: "Invalid URL format",
 			Details: err.Error(),
 			Code:    http.StatusBadRequest,
@@ -79,8 +116,8 @@ func validateURL(urlStr string) *DownloadError {
 
 	// Check if it has a valid scheme
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return &DownloadError{
-			Type:    ErrorTypeValidation,
+		return &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
 			Message: "URL must use http or https protocol",
 			Code:    http.StatusBadRequest,
 		}
@@ -88,8 +125,8 @@ func validateURL(urlStr string) *DownloadError {
 
 	// Check if it has a host
 	if parsedURL.Host == "" {
-		return &DownloadError{
-			Type:    ErrorTypeValidation,
+		return &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
 			Message: "URL must have a valid host",
 			Code:    http.StatusBadRequest,
 		}
@@ -121,23 +158,21 @@ func validateURL(urlStr string) *DownloadError {
 }
 
 // ensureVideosDirectory creates the videos directory if it doesn't exist
-func ensureVideosDirectory() *DownloadError {
-	videosDir := "./videos"
-
+func ensureVideosDirectory(videosDir string) *apperr.DownloadError {
 	// Check if directory exists
 	if _, err := os.Stat(videosDir); os.IsNotExist(err) {
 		log.Printf("Creating videos directory: %s", videosDir)
 		if err := os.MkdirAll(videosDir, 0755); err != nil {
-			return &DownloadError{
-				Type:    ErrorTypeFileSystem,
+			return &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
 				Message: "Failed to create videos directory",
 				Details: err.Error(),
 				Code:    http.StatusInternalServerError,
 			}
 		}
 	} else if err != nil {
-		return &DownloadError{
-			Type:    ErrorTypeFileSystem,
+		return &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
 			Message: "Failed to check videos directory",
 			Details: err.Error(),
 			Code:    http.StatusInternalServerError,
@@ -147,8 +182,8 @@ func ensureVideosDirectory() *DownloadError {
 	// Test write permissions
 	testFile := filepath.Join(videosDir, ".write_test")
 	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
-		return &DownloadError{
-			Type:    ErrorTypePermission,
+		return &apperr.DownloadError{
+			Type:    apperr.TypePermission,
 			Message: "No write permission to videos directory",
 			Details: err.Error(),
 			Code:    http.StatusInternalServerError,
@@ -159,183 +194,107 @@ func ensureVideosDirectory() *DownloadError {
 	return nil
 }
 
-// checkYtDlpBinary verifies that yt-dlp is available
-func checkYtDlpBinary() *DownloadError {
-	cmd := exec.Command("yt-dlp", "--version")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return &DownloadError{
-			Type:    ErrorTypeBinary,
-			Message: "yt-dlp binary not found or not executable",
-			Details: fmt.Sprintf("Error: %v, Stderr: %s", err, stderr.String()),
+// checkDownloader verifies that the backend's binaries are available
+func checkDownloader(ctx context.Context, d downloader.Downloader) *apperr.DownloadError {
+	if err := d.Check(ctx); err != nil {
+		return &apperr.DownloadError{
+			Type:    apperr.TypeBinary,
+			Message: fmt.Sprintf("%s binary not found or not executable", d.Name()),
+			Details: err.Error(),
 			Code:    http.StatusInternalServerError,
 		}
 	}
-
-	log.Printf("yt-dlp version: %s", strings.TrimSpace(stdout.String()))
 	return nil
 }
 
-// parseYtDlpError analyzes stderr output to categorize the error
-func parseYtDlpError(stderr string) *DownloadError {
-	stderrLower := strings.ToLower(stderr)
-
-	// Network-related errors
-	if strings.Contains(stderrLower, "network") ||
-		strings.Contains(stderrLower, "connection") ||
-		strings.Contains(stderrLower, "timeout") ||
-		strings.Contains(stderrLower, "dns") {
-		return &DownloadError{
-			Type:    ErrorTypeNetwork,
-			Message: "Network error occurred during download",
-			Details: stderr,
-			Code:    http.StatusBadGateway,
-		}
-	}
-
-	// Video not found or unavailable
-	if strings.Contains(stderrLower, "video unavailable") ||
-		strings.Contains(stderrLower, "not available") ||
-		strings.Contains(stderrLower, "private video") ||
-		strings.Contains(stderrLower, "removed") ||
-		strings.Contains(stderrLower, "deleted") ||
-		strings.Contains(stderrLower, "404") {
-		return &DownloadError{
-			Type:    ErrorTypeNotFound,
-			Message: "Video not found or unavailable",
-			Details: stderr,
-			Code:    http.StatusNotFound,
-		}
-	}
-
-	// Permission/access errors
-	if strings.Contains(stderrLower, "permission") ||
-		strings.Contains(stderrLower, "access denied") ||
-		strings.Contains(stderrLower, "forbidden") ||
-		strings.Contains(stderrLower, "401") ||
-		strings.Contains(stderrLower, "403") {
-		return &DownloadError{
-			Type:    ErrorTypePermission,
-			Message: "Access denied or permission error",
-			Details: stderr,
-			Code:    http.StatusForbidden,
-		}
-	}
-
-	// Unsupported URL
-	if strings.Contains(stderrLower, "unsupported url") ||
-		strings.Contains(stderrLower, "no video formats") ||
-		strings.Contains(stderrLower, "extractor") {
-		return &DownloadError{
-			Type:    ErrorTypeValidation,
-			Message: "Unsupported URL or no extractors available",
-			Details: stderr,
-			Code:    http.StatusBadRequest,
-		}
+// selectBackend validates a download request and picks the backend for it
+func selectBackend(link string, extraArgs []string, downloaders *downloader.Registry) (downloader.Downloader, *apperr.DownloadError) {
+	// Validate URL
+	if err := validateURL(link); err != nil {
+		log.Printf("URL validation failed: %s", err.Message)
+		return nil, err
 	}
 
-	// Default to unknown error
-	return &DownloadError{
-		Type:    ErrorTypeUnknown,
-		Message: "Unknown error occurred during download",
-		Details: stderr,
-		Code:    http.StatusInternalServerError,
+	// validateURL has already parsed the link successfully
+	parsedURL, _ := url.Parse(link)
+	backend := downloaders.For(parsedURL)
+
+	if len(extraArgs) > 0 {
+		validator, ok := backend.(downloader.ArgValidator)
+		if !ok {
+			return nil, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: fmt.Sprintf("Extra arguments are not supported by the %s backend", backend.Name()),
+				Code:    http.StatusBadRequest,
+			}
+		}
+		if err := validator.ValidateArgs(extraArgs); err != nil {
+			log.Printf("Rejected extra arguments for %s: %v", link, err)
+			return nil, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid extra arguments",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			}
+		}
 	}
+
+	return backend, nil
 }
 
 // handleVideoDownload performs the video download with enhanced error handling
-func handleVideoDownload(link string) *DownloadError {
+func handleVideoDownload(ctx context.Context, req downloader.Request, downloaders *downloader.Registry) (*downloader.Result, *apperr.DownloadError) {
+	link := req.URL
 	log.Printf("Starting download for URL: %s", link)
 
-	// Validate URL
-	if err := validateURL(link); err != nil {
-		log.Printf("URL validation failed: %s", err.Message)
-		return err
+	backend, downloadErr := selectBackend(link, req.ExtraArgs, downloaders)
+	if downloadErr != nil {
+		return nil, downloadErr
 	}
+	log.Printf("Using %s backend for %s", backend.Name(), link)
 
 	// Ensure videos directory exists
-	if err := ensureVideosDirectory(); err != nil {
+	if err := ensureVideosDirectory(req.OutputDir); err != nil {
 		log.Printf("Directory setup failed: %s", err.Message)
-		return err
+		return nil, err
 	}
 
-	// Check yt-dlp binary
-	if err := checkYtDlpBinary(); err != nil {
-		log.Printf("Binary check failed: %s", err.Message)
-		return err
-	}
-
-	// Prepare command with enhanced options
-	cmd := exec.Command("yt-dlp",
-		link,
-		"--output", "videos/%(id)s.%(ext)s",
-		"--write-info-json", // Saves full metadata
-		"--embed-metadata",  // Basic info in media file
-		"--embed-thumbnail", // Optional: cover art
-		"--no-mtime",        // Don't modify timestamps
-		"--no-warnings",     // Reduce noise in stderr
-		"--newline",         // Progress on new lines
-	)
-
-	// Capture both stdout and stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	// Set timeout for the command (30 minutes)
+	// Set timeout for the download (30 minutes)
 	timeout := 30 * time.Minute
-	done := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	go func() {
-		done <- cmd.Run()
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			log.Printf("yt-dlp command failed: %v", err)
-			log.Printf("Stderr: %s", stderr.String())
-			log.Printf("Stdout: %s", stdout.String())
+	if err := checkDownloader(ctx, backend); err != nil {
+		log.Printf("Binary check failed: %s", err.Message)
+		return nil, err
+	}
 
-			// Parse the error to provide better context
-			return parseYtDlpError(stderr.String())
+	result, err := backend.Download(ctx, req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &apperr.DownloadError{
+				Type:    apperr.TypeNetwork,
+				Message: "Download timeout exceeded",
+				Details: fmt.Sprintf("Download took longer than %v", timeout),
+				Code:    http.StatusRequestTimeout,
+			}
 		}
 
-		log.Printf("Download completed successfully for: %s", link)
-		log.Printf("Output: %s", stdout.String())
-		return nil
-
-	case <-time.After(timeout):
-		// Kill the process if it's still running
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
+		log.Printf("%s download failed: %v", backend.Name(), err)
 
-		return &DownloadError{
-			Type:    ErrorTypeNetwork,
-			Message: "Download timeout exceeded",
-			Details: fmt.Sprintf("Download took longer than %v", timeout),
-			Code:    http.StatusRequestTimeout,
+		var execErr *downloader.ExecError
+		if errors.As(err, &execErr) {
+			log.Printf("Stderr: %s", execErr.Stderr)
+			log.Printf("Stdout: %s", execErr.Stdout)
 		}
-	}
-}
-
-func loadVideoInfo(videoPath string) (*VideoInfo, error) {
-	jsonPath := strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ".info.json"
-	data, err := os.ReadFile(jsonPath)
-	if err != nil {
-		return nil, err
-	}
 
-	var info VideoInfo
-	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, err
+		// Parse the error to provide better context
+		return nil, apperr.Classify(err)
 	}
 
-	return &info, nil
+	log.Printf("Download completed successfully for: %s", link)
+	log.Printf("Output: %s", result.Output)
+	return result, nil
 }
 
 func main() {
@@ -344,255 +303,630 @@ func main() {
 	if defaultPort == "" {
 		defaultPort = "8591"
 	}
-	if !strings.HasPrefix(defaultPort, ":") {
+	// A bare port number; addresses such as "unix:/run/ute.sock" are kept
+	if _, err := strconv.Atoi(defaultPort); err == nil {
 		defaultPort = ":" + defaultPort
 	}
 
-	addr := flag.String("addr", defaultPort, "port to host on (default from PORT env or ':8591')")
+	defaultConfig := os.Getenv("UTE_CONFIG")
+	if defaultConfig == "" {
+		defaultConfig = "ute.json"
+	}
+
+	addr := flag.String("addr", defaultPort, "address to listen on: a port such as ':8591', 'unix:/path/to/ute.sock' or 'systemd' for socket activation (default from PORT env or ':8591')")
+	configPath := flag.String("config", defaultConfig, "path to JSON config file (default from UTE_CONFIG env or 'ute.json')")
+	adminAddr := flag.String("admin-addr", "", "addresses, separated by commas, that alone serve the settings and other management endpoints, e.g. '127.0.0.1:8592'")
+	agentURL := flag.String("agent", "", "run as a download agent for the server at this URL instead of serving")
+	agentToken := flag.String("agent-token", os.Getenv("UTE_AGENT_TOKEN"), "token the agent signs in with (default from UTE_AGENT_TOKEN env)")
+	agentCert := flag.String("agent-cert", "", "client certificate the agent presents to a server requiring one (PEM)")
+	agentKey := flag.String("agent-key", "", "key of the agent's client certificate (PEM)")
+	agentCA := flag.String("agent-ca", "", "CA certificate the agent trusts the server's certificate from, besides the system's (PEM)")
+	fake := flag.Bool("fake-downloader", false, "pretend to download, writing generated files, for tests and demos without network access or yt-dlp")
 	flag.Parse()
 
-	mux := http.NewServeMux()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
 
-	fs := http.FileServer(http.Dir("./static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	ctx := context.Background()
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "" || r.Method == "GET" {
-			http.ServeFile(w, r, "./static/index.html")
-			return
-		}
+	if *fake {
+		cfg.FakeDownloader.Enabled = true
+	}
+	ytdlpManager, downloaders := newDownloaders(ctx, cfg)
 
-		if r.Method == "POST" {
-			// Set content type for JSON responses
-			w.Header().Set("Content-Type", "application/json")
-
-			// Parse request body
-			d := json.NewDecoder(r.Body)
-			linkBod := struct {
-				Link string `json:"link"`
-			}{}
-
-			if err := d.Decode(&linkBod); err != nil {
-				log.Printf("Failed to decode request body: %v", err)
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(ErrorResponse{
-					Success: false,
-					Error: &DownloadError{
-						Type:    ErrorTypeValidation,
-						Message: "Invalid JSON in request body",
-						Details: err.Error(),
-						Code:    http.StatusBadRequest,
-					},
-				})
-				return
-			}
+	if *agentURL != "" {
+		agentTLS, err := clientTLS(*agentCert, *agentKey, *agentCA)
+		if err != nil {
+			log.Fatalf("agent TLS error: %v", err)
+		}
+		runAgent(ctx, cfg, downloaders, *agentURL, *agentToken, agentTLS)
+		return
+	}
 
-			// Validate that link is provided
-			if strings.TrimSpace(linkBod.Link) == "" {
-				log.Printf("Empty link provided in request")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(ErrorResponse{
-					Success: false,
-					Error: &DownloadError{
-						Type:    ErrorTypeValidation,
-						Message: "Link field is required and cannot be empty",
-						Code:    http.StatusBadRequest,
-					},
-				})
-				return
-			}
+	if err := cfg.ValidateListeners(*adminAddr); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	srv, err := newServer(ctx, cfg, *configPath, ytdlpManager, downloaders)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	srv.reloadOnHangup()
+	// Asking yt-dlp about mutagen takes a moment, so it doesn't hold up
+	// the start
+	go srv.logDependencies(ctx)
+
+	mux := srv.routes()
+	middlewares := srv.middlewares(mux)
+	handler := chain(mux, middlewares...)
+
+	// Management endpoints move to their own listeners when there are any;
+	// systemd sockets are handed out in order, so those named first
+	var public, admin []net.Listener
+	var publicAt, adminAt []string
+	if *adminAddr != "" {
+		if admin, adminAt, err = listenAll(*adminAddr); err != nil {
+			log.Fatalf("listening on %s: %v", *adminAddr, err)
+		}
+	}
+	if public, publicAt, err = listenAll(*addr); err != nil {
+		log.Fatalf("listening on %s: %v", *addr, err)
+	}
+	tlsConfig, err := serverTLS(cfg.TLS)
+	if err != nil {
+		log.Fatalf("TLS config error: %v", err)
+	}
+	if tlsConfig != nil {
+		secure(public, publicAt, tlsConfig)
+		secure(admin, adminAt, tlsConfig)
+	}
+	publicHandler := handler
+	if len(admin) > 0 {
+		publicHandler = chain(withoutManagement(mux, srv.managementRoutes, srv.errPages), middlewares...)
+	}
 
-			link := strings.TrimSpace(linkBod.Link)
-			log.Printf("Processing download request for URL: %s", link)
-
-			// Attempt video download
-			if downloadErr := handleVideoDownload(link); downloadErr != nil {
-				log.Printf("Download failed for URL %s: %s", link, downloadErr.Message)
-				w.WriteHeader(downloadErr.Code)
-				json.NewEncoder(w).Encode(ErrorResponse{
-					Success: false,
-					Error:   downloadErr,
-				})
-				return
-			}
+	errs := make(chan error, len(public)+len(admin))
+	serve := func(l net.Listener, h http.Handler) {
+		errs <- http.Serve(l, h)
+	}
+	for i, l := range public {
+		fmt.Printf("Listening on %s\n", publicAt[i])
+		go serve(l, publicHandler)
+	}
+	for i, l := range admin {
+		fmt.Printf("Listening on %s for management\n", adminAt[i])
+		go serve(l, handler)
+	}
+	log.Fatalf("server error: %v", <-errs)
+}
 
-			// Success response
-			log.Printf("Download completed successfully for URL: %s", link)
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(SuccessResponse{
-				Success: true,
-				Message: "Video download completed successfully",
-			})
-			return
+// newDownloaders resolves the yt-dlp binary and lists the download
+// backends, or just the fake one when cfg enables it
+func newDownloaders(ctx context.Context, cfg config.Config) (*ytdlp.Manager, *downloader.Registry) {
+	// Resolve the yt-dlp binary; a failure here is reported per request so
+	// the library stays browsable without it
+	ytdlpManager := ytdlp.NewManager(cfg.DataDir, cfg.YtDlp)
+	if !cfg.FakeDownloader.Enabled {
+		if err := ytdlpManager.Ensure(ctx); err != nil {
+			log.Printf("Warning: yt-dlp unavailable: %v", err)
 		}
+	}
 
-		// Method not allowed
-		log.Printf("Unsupported HTTP method: %s", r.Method)
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ErrorResponse{
-			Success: false,
-			Error: &DownloadError{
-				Type:    ErrorTypeValidation,
-				Message: "Method not supported",
-				Details: fmt.Sprintf("Method %s is not allowed for this endpoint", r.Method),
-				Code:    http.StatusMethodNotAllowed,
-			},
+	// Backends are tried in order; anything unmatched goes to yt-dlp
+	downloaders := downloader.NewRegistry(
+		&downloader.YtDlp{
+			Binary:         ytdlpManager.Path,
+			AllowedArgs:    cfg.YtDlp.AllowedArgs,
+			OutputTemplate: cfg.YtDlp.OutputTemplate,
+			Comments:       cfg.YtDlp.Comments,
+		},
+		&downloader.HTTP{},
+		&downloader.GalleryDL{},
+	)
+	if cfg.FakeDownloader.Enabled {
+		log.Printf("Using the fake downloader: nothing is really downloaded")
+		downloaders = downloader.NewRegistry(&downloader.Fake{
+			Duration: time.Duration(cfg.FakeDownloader.Duration),
+			Size:     cfg.FakeDownloader.Size,
 		})
-	})
+	}
+	return ytdlpManager, downloaders
+}
 
-	// API endpoint to list videos
-	mux.HandleFunc("/api/videos", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
+// newServer loads the server's state from cfg and starts its workers and
+// background tasks, which run until ctx is done
+func newServer(ctx context.Context, cfg config.Config, configPath string, ytdlpManager *ytdlp.Manager, downloaders *downloader.Registry) (*server, error) {
+	backend, err := storage.New(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("storage config error: %w", err)
+	}
 
-		if r.Method != "GET" {
-			log.Printf("Invalid method %s for /api/videos endpoint", r.Method)
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			json.NewEncoder(w).Encode(ErrorResponse{
-				Success: false,
-				Error: &DownloadError{
-					Type:    ErrorTypeValidation,
-					Message: "Method not supported",
-					Details: fmt.Sprintf("Method %s is not allowed for this endpoint", r.Method),
-					Code:    http.StatusMethodNotAllowed,
-				},
-			})
-			return
-		}
+	transcriber, err := transcribe.New(cfg.Transcription)
+	if err != nil {
+		return nil, fmt.Errorf("transcription config error: %w", err)
+	}
+	enricher, err := enrich.New(cfg.Enrichment)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment config error: %w", err)
+	}
+
+	videos := library.NewVideoService(cfg.VideosDir, cfg.DataDir)
+	registerPools(videos, cfg.Pools.Dirs)
+	videos.SetLenientMetadata(cfg.YtDlp.LenientMetadata)
+	if err := videos.LoadMetadata(); err != nil {
+		log.Printf("Warning: failed to load library index: %v", err)
+	}
+	if err := videos.LoadAlbums(); err != nil {
+		log.Printf("Warning: failed to load albums: %v", err)
+	}
 
-		baseDir := "./videos"
-		log.Printf("Listing videos from directory: %s", baseDir)
+	runtimeSettings := settings.NewStore(cfg.DataDir, settings.FromConfig(cfg))
+	if err := runtimeSettings.Load(); err != nil {
+		log.Printf("Warning: ignoring saved settings: %v", err)
+	}
 
-		// Check if shared directory exists
-		if _, err := os.Stat(baseDir); os.IsNotExist(err) {
-			log.Printf("Videos directory does not exist, returning empty list")
-			// Return empty list if directory doesn't exist
-			json.NewEncoder(w).Encode([]map[string]interface{}{})
-			return
+	subs := subscriptions.NewStore(cfg.DataDir)
+	if err := subs.Load(); err != nil {
+		log.Printf("Warning: failed to load subscriptions: %v", err)
+	}
+
+	usageStore := usage.NewStore(cfg.DataDir)
+	if err := usageStore.Load(); err != nil {
+		log.Printf("Warning: failed to load usage counts: %v", err)
+	}
+
+	historyStore := history.NewStore(cfg.DataDir)
+	if err := historyStore.Load(); err != nil {
+		log.Printf("Warning: failed to load the download history: %v", err)
+	}
+
+	dataBudget := budget.NewCounter(cfg.DataDir, cfg.DataBudget.MonthlyBytes, cfg.DataBudget.WarnAt, cfg.DataBudget.ResetDay)
+	if err := dataBudget.Load(); err != nil {
+		log.Printf("Warning: failed to load the data budget count: %v", err)
+	}
+
+	email, err := hooks.NewEmail(cfg.Notifications.Email)
+	if err != nil {
+		return nil, fmt.Errorf("notifications email config error: %w", err)
+	}
+
+	digest := hooks.NewDigester(cfg.DataDir)
+	if err := digest.Load(); err != nil {
+		log.Printf("Warning: failed to load the notification digest: %v", err)
+	}
+
+	userLists := lists.NewStore(cfg.DataDir)
+	if err := userLists.Load(); err != nil {
+		log.Printf("Warning: failed to load favorites and watch-later lists: %v", err)
+	}
+
+	positions := playback.NewStore(cfg.DataDir)
+	if err := positions.Load(); err != nil {
+		log.Printf("Warning: failed to load playback positions: %v", err)
+	}
+
+	webSubHub := websub.NewHub(cfg.DataDir, webSubHubURL)
+	if err := webSubHub.Load(); err != nil {
+		log.Printf("Warning: failed to load WebSub subscriptions: %v", err)
+	}
+
+	tasks := scheduler.New(cfg.DataDir)
+	if err := tasks.Load(); err != nil {
+		log.Printf("Warning: failed to load paused tasks: %v", err)
+	}
+
+	auditLog, err := audit.Open(cfg.DataDir)
+	if err != nil {
+		log.Printf("Warning: audit log disabled: %v", err)
+	}
+
+	// Jobs are queued in memory unless Redis is configured
+	var queue jobs.Queue = jobs.NewMemoryQueue()
+	var redisQueue *jobs.RedisQueue
+	if cfg.Queue.RedisURL != "" {
+		client, err := redis.Open(cfg.Queue.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("queue config error: %w", err)
+		}
+		redisQueue = jobs.NewRedisQueue(client, cfg.Queue.RedisPrefix)
+		if err := redisQueue.Ping(ctx); err != nil {
+			return nil, fmt.Errorf("Failed to connect to Redis: %w", err)
 		}
+		queue = redisQueue
+	}
 
-		entries, err := os.ReadDir(baseDir)
+	// Jobs outlive restarts in data_dir/jobs.json, unless Redis or the
+	// cluster directory keeps them for every process sharing them
+	jobStore := jobs.NewStore()
+	if cfg.Queue.RedisURL == "" && cfg.Cluster.Dir == "" {
+		jobStore, err = jobs.Open(filepath.Join(cfg.DataDir, "jobs.json"))
 		if err != nil {
-			log.Printf("Failed to read videos directory: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(ErrorResponse{
-				Success: false,
-				Error: &DownloadError{
-					Type:    ErrorTypeFileSystem,
-					Message: "Failed to read videos directory",
-					Details: err.Error(),
-					Code:    http.StatusInternalServerError,
-				},
-			})
-			return
+			return nil, fmt.Errorf("Failed to load jobs: %w", err)
 		}
+	}
+	clearWorkDirs(cfg.TempDir, func(id string) bool {
+		job, ok := jobStore.Get(id)
+		return ok && job.State == jobs.StateQueued
+	})
+
+	// The config was checked when loaded
+	dates, err := timefmt.New(cfg.Display.Timezone, cfg.Display.Locale)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid display settings: %w", err)
+	}
 
-		var videos []map[string]interface{}
-		videoExtensions := map[string]bool{
-			".mp4":  true,
-			".mkv":  true,
-			".webm": true,
-			".mov":  true,
-			".flv":  true,
-			".avi":  true,
+	var accessLog *accesslog.Log
+	if cfg.AccessLog.Path != "" {
+		al := cfg.AccessLog
+		accessLog, err = accesslog.Open(al.Path, al.Format, al.MaxSize, time.Duration(al.MaxAge), al.MaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("access log error: %w", err)
 		}
+	}
 
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
+	srv := &server{
+		cfg:            cfg,
+		settings:       runtimeSettings,
+		ytdlp:          ytdlpManager,
+		downloaders:    downloaders,
+		jobs:           jobStore,
+		queue:          queue,
+		breakers:       jobs.NewBreakers(cfg.Backoff.Failures, time.Duration(cfg.Backoff.Cooldown), time.Duration(cfg.Backoff.MaxCooldown)),
+		budget:         dataBudget,
+		hooks:          hooks.NewRunner(cfg.Hooks, cfg.VideosDir),
+		digest:         digest,
+		videos:         videos,
+		subs:           subs,
+		usage:          usageStore,
+		history:        historyStore,
+		tracer:         tracing.New(cfg.Tracing),
+		lists:          userLists,
+		playback:       positions,
+		websub:         webSubHub,
+		storage:        backend,
+		rclone:         &rclone.Client{Binary: cfg.Rclone.Binary, ConfigFile: cfg.Rclone.ConfigFile},
+		audit:          auditLog,
+		transcriber:    transcriber,
+		enricher:       enricher,
+		tasks:          tasks,
+		events:         events.NewBus(),
+		metrics:        events.NewCounter(),
+		bandwidth:      throughput.New(bandwidthHistory),
+		jobLogs:        joblog.NewStore(cfg.DataDir, cfg.JobLogs.MaxSize, cfg.JobLogs.MaxFiles),
+		cookies:        cookies.NewStore(cfg.DataDir),
+		postProcessors: postprocess.NewRegistry(),
+		errPages:       loadErrorPages("./templates"),
+		reload:         configReload{path: configPath, last: cfg},
+		started:        time.Now(),
+		dates:          dates,
+		accessLog:      accessLog,
+	}
+	srv.views = loadViews("./templates", srv.errPages, dates)
+	// The webhooks are read each time so settings changes take effect
+	// without a restart
+	srv.notifiers = []hooks.Notifier{hooks.Webhooks(func() []string { return srv.settings.Get().Webhooks })}
+	if email != nil {
+		srv.notifiers = append(srv.notifiers, email)
+	}
+	if apprise := hooks.NewApprise(cfg.Notifications.Apprise); apprise != nil {
+		if external := apprise.External(); len(external) > 0 {
+			if _, err := exec.LookPath(cfg.Notifications.Apprise.Binary); err != nil {
+				log.Printf("Warning: %d apprise URLs need the %s command, which wasn't found: %v", len(external), cfg.Notifications.Apprise.Binary, err)
 			}
+		}
+		srv.notifiers = append(srv.notifiers, apprise)
+	}
+	srv.alerts = alerts.NewMonitor(cfg.Notifications.Alerts, func(a alerts.Alert) { srv.sendAlert(ctx, a) })
+	if err := srv.openMQTT(); err != nil {
+		return nil, fmt.Errorf("notifications mqtt config error: %w", err)
+	}
+	srv.subscribeEvents(ctx)
+	go srv.tracer.Run(ctx)
+	srv.registerPostProcessors()
+	for name, profile := range cfg.PostProcess.Profiles {
+		if err := srv.postProcessors.Check(profile); err != nil {
+			return nil, fmt.Errorf("postprocess profile %s: %w", name, err)
+		}
+	}
+	// Downloads queued or interrupted before a restart, before the
+	// cluster and Redis add those of other processes
+	for _, job := range srv.jobs.Queued() {
+		srv.queue.Push(job.ID, job.Priority)
+	}
+	srv.startCluster(ctx)
+	srv.startRedis(ctx, redisQueue)
+	srv.startWorkers(ctx, runtimeSettings.Get().Workers)
+	srv.startRescan()
+	srv.registerTasks()
+	srv.tasks.Start(ctx)
+	srv.startWatcher(ctx)
+	return srv, nil
+}
 
-			ext := strings.ToLower(filepath.Ext(entry.Name()))
-			if !videoExtensions[ext] {
-				continue
-			}
+// routes registers every endpoint on a new mux
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
 
-			videoPath := filepath.Join(baseDir, entry.Name())
+	mux.Handle("/static/", staticHandler("./static", s.cfg.Cache.Static))
 
-			info, err := entry.Info()
-			if err != nil {
-				log.Printf("Failed to get file info for %s: %v", entry.Name(), err)
-				continue
-			}
+	mux.HandleFunc("/", s.handleIndex)
 
-			metadata, err := loadVideoInfo(videoPath)
-			if err != nil {
-				log.Printf("Failed to load metadata for %s: %v", entry.Name(), err)
-				// Fallback if .info.json is missing
-				metadata = &VideoInfo{
-					Title: entry.Name(),
-				}
-			}
+	// API endpoint to list videos
+	mux.HandleFunc("/api/videos", s.handleVideos)
+	mux.HandleFunc("/api/videos/{id}", s.handleVideo)
+	mux.HandleFunc("/api/videos/archive", s.handleVideoArchive)
+	mux.HandleFunc("/api/videos/{id}/move", s.handleMoveVideo)
+	mux.HandleFunc("/api/videos/{id}/thumb", s.handleThumbnail)
+	mux.HandleFunc("/api/videos/{id}/redownload", s.handleRedownload)
+	mux.HandleFunc("/api/videos/{id}/upgrade", s.handleUpgrade)
+	mux.HandleFunc("/api/videos/{id}/transcode", s.handleTranscode)
+	mux.HandleFunc("/api/videos/{id}/transcribe", s.handleTranscribe)
+	mux.HandleFunc("/api/videos/{id}/summarize", s.handleSummarize)
+	mux.HandleFunc("/api/videos/{id}/watched", s.handleWatched)
+	mux.HandleFunc("/api/videos/{id}/favorite", s.handleListToggle(lists.Favorites, "favorite"))
+	mux.HandleFunc("/api/videos/{id}/watch-later", s.handleListToggle(lists.WatchLater, "watch_later"))
+	mux.HandleFunc("/api/videos/{id}/progress", s.handleProgress)
+	mux.HandleFunc("/api/videos/{id}/comments", s.handleComments)
+	mux.HandleFunc("/api/continue-watching", s.handleContinueWatching)
+	mux.HandleFunc("/api/favorites", s.handleFavorites)
+	mux.HandleFunc("/api/watch-later", s.handleWatchLater)
+	mux.HandleFunc("/feeds/watch-later.rss", s.handleWatchLaterFeed)
+	mux.HandleFunc("/api/albums", s.handleAlbums)
+	mux.HandleFunc("/api/albums/{id}", s.handleAlbum)
+	mux.HandleFunc("/api/uploaders", s.handleUploaders)
+	mux.HandleFunc("/api/uploaders/{name}", s.handleUploader)
+	mux.HandleFunc("/api/uploaders/{name}/subscribe", s.handleSubscribeUploader)
+	mux.HandleFunc("/api/subscriptions", s.handleSubscriptions)
+	mux.HandleFunc("/api/subscriptions/{id}", s.handleSubscription)
+	mux.HandleFunc("/api/subscriptions/{id}/check", s.handleCheckSubscription)
+	mux.HandleFunc("/api/subscriptions/{id}/backfill", s.handleBackfillSubscription)
+	mux.HandleFunc("/api/folders", s.handleFolders)
+	mux.HandleFunc("/api/stats", s.handleStats)
+	mux.HandleFunc("/api/bandwidth", s.handleBandwidth)
+	mux.HandleFunc("/api/grafana", s.handleGrafana)
+	mux.HandleFunc("/api/grafana/metrics", s.handleGrafanaMetrics)
+	mux.HandleFunc("/api/grafana/search", s.handleGrafanaSearch)
+	mux.HandleFunc("/api/grafana/query", s.handleGrafanaQuery)
+	mux.HandleFunc("/api/duplicates", s.handleDuplicates)
+	s.handleAdmin(mux, "/api/duplicates/merge", s.handleMergeDuplicates)
+	s.handleAdmin(mux, "/api/library/rescan", s.handleRescan)
+	mux.HandleFunc("/api/pools", s.handlePools)
+	s.handleAdmin(mux, "/api/pools/migrate", s.handleMigrate)
+	s.handleAdmin(mux, "/api/maintenance/verify", s.handleVerify)
+	s.handleAdmin(mux, "/api/maintenance/cleanup", s.handleCleanup)
+	s.handleAdmin(mux, "/api/maintenance/formats", s.handleFormats)
+	mux.HandleFunc("/api/probe", s.handleProbe)
+	mux.HandleFunc("/api/preview", s.handlePreview)
+	mux.HandleFunc("/api/postprocessors", s.handlePostProcessors)
+	s.handleAdmin(mux, "/api/tasks", s.handleTasks)
+	s.handleAdmin(mux, "/api/tasks/{name}/run", s.handleTaskRun)
+	s.handleAdmin(mux, "/api/tasks/{name}/pause", s.handleTaskPause)
+	s.handleAdmin(mux, "/api/settings", s.handleSettings)
+	s.handleAdmin(mux, "/api/audit", s.handleAuditLog)
+	s.handleAdmin(mux, "/api/cookies", s.handleCookies)
+	s.handleAdmin(mux, "/api/cookies/{domain}", s.handleSiteCookies)
+	mux.HandleFunc("/api/quota", s.handleQuota)
+	s.handleAdmin(mux, "/api/users", s.handleUsers)
+
+	s.handleAdmin(mux, "/api/system", s.handleSystem)
+	s.handleAdmin(mux, "/api/system/reload", s.handleReload)
+	s.handleAdmin(mux, "/api/websub", s.handleWebSub)
+	mux.HandleFunc("/api/quick-add", s.handleQuickAdd)
+	if s.cfg.Debug.Pprof {
+		s.registerDebug(mux)
+	}
 
-			videos = append(videos, map[string]interface{}{
-				"filename":    entry.Name(),
-				"size":        info.Size(),
-				"modified":    info.ModTime().Format("2006-01-02 15:04:05"),
-				"title":       metadata.Title,
-				"uploader":    metadata.Uploader,
-				"uploadDate":  metadata.UploadDate,
-				"views":       metadata.ViewCount,
-				"url":         metadata.WebpageURL,
-				"description": metadata.Description,
+	mux.HandleFunc("/api/jobs", handleJobList(s.jobs))
+	mux.HandleFunc("/api/jobs/ws", s.handleJobSocket)
+	mux.HandleFunc("/api/jobs/{id}", handleJob(s.jobs))
+	mux.HandleFunc("/api/jobs/{id}/priority", handleJobPriority(s.queue, s.jobs))
+	mux.HandleFunc("/api/jobs/{id}/move", handleJobMove(s.queue, s.jobs))
+	mux.HandleFunc("/api/jobs/{id}/cancel", s.handleCancelJob)
+	mux.HandleFunc("/api/jobs/{id}/log", s.handleJobLog)
+	mux.HandleFunc("/api/jobs/{id}/retry", s.handleRetryJob)
+	mux.HandleFunc("/api/queue", handleQueue(s.queue, s.jobs))
+	mux.HandleFunc("/api/queue/domains", s.handleQueueDomains)
+	s.handleAdmin(mux, "/api/queue/domains/{domain}", s.handleQueueDomain)
+	mux.HandleFunc("/api/budget", s.handleBudget)
+	s.handleAdmin(mux, "/api/cluster", s.handleCluster)
+	mux.HandleFunc("/api/agent/tasks", s.handleAgentPoll)
+	mux.HandleFunc("/api/agent/tasks/{id}/progress", s.handleAgentProgress)
+	mux.HandleFunc("/api/agent/tasks/{id}/files/{name...}", s.handleAgentFile)
+	mux.HandleFunc("/api/agent/tasks/{id}/result", s.handleAgentResult)
+	s.handleAdmin(mux, "/api/budget/reset", s.handleBudgetReset)
+
+	// Server-rendered pages; each also serves its HTMX fragment
+	mux.HandleFunc("/library", s.handleLibraryPage)
+	mux.HandleFunc("/queue", s.handleQueuePage)
+	mux.HandleFunc("/queue/events", s.handleQueueEvents)
+	mux.HandleFunc("/jobs/{id}", s.handleJobPage)
+	s.handleAdmin(mux, "/settings", s.handleSettingsPage)
+	mux.HandleFunc("/albums", s.handleAlbumsPage)
+	mux.HandleFunc("/albums/{id}", s.handleAlbumPage)
+	mux.HandleFunc("/uploaders", s.handleUploadersPage)
+	mux.HandleFunc("/uploaders/{name}", s.handleUploaderPage)
+
+	mux.HandleFunc("/videos/", s.handleVideoFile)
+	mux.HandleFunc("/videos/{id}", s.handleVideoPage)
+	return mux
+}
+
+// middlewares wrap every request to mux, outermost first
+func (s *server) middlewares(mux *http.ServeMux) []middleware {
+	return []middleware{
+		withRequestID,
+		negotiateLanguage,
+		logRequests(s.accessLog),
+		traceRequests(s.tracer, mux),
+		recoverPanics(s.errPages),
+		authenticate(s.cfg.Users, s.errPages),
+		compress(s.cfg.Compression),
+	}
+}
+
+// handleIndex serves the web app at / and queues downloads POSTed to it
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	// Every unmatched path lands here
+	if r.URL.Path != "/" {
+		s.errPages.notFound(w, r)
+		return
+	}
+
+	if r.Method == "" || r.Method == "GET" {
+		if fi, err := os.Stat("./static/index.html"); err == nil {
+			w.Header().Set("ETag", fileETag(fi))
+			setCacheControl(w, s.cfg.Cache.Static)
+		}
+		http.ServeFile(w, r, "./static/index.html")
+		return
+	}
+
+	if r.Method == "POST" {
+		// Set content type for JSON responses
+		w.Header().Set("Content-Type", "application/json")
+
+		// Parse request body
+		d := json.NewDecoder(r.Body)
+		linkBod := struct {
+			Link     string       `json:"link"`
+			Args     []string     `json:"args"`
+			Priority string       `json:"priority"`
+			Folder   string       `json:"folder"`
+			Profile  string       `json:"profile"`
+			Limits   *jobs.Limits `json:"limits"`
+		}{}
+
+		if err := d.Decode(&linkBod); err != nil {
+			log.Printf("Failed to decode request body: %v", err)
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid JSON in request body",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
 			})
+			return
 		}
 
-		log.Printf("Found %d video files", len(videos))
-		json.NewEncoder(w).Encode(videos)
-	})
+		// Validate that link is provided
+		if strings.TrimSpace(linkBod.Link) == "" {
+			log.Printf("Empty link provided in request")
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Link field is required and cannot be empty",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
 
-	mux.HandleFunc("/videos/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			log.Printf("Invalid method %s for /videos/ endpoint", r.Method)
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		link := strings.TrimSpace(linkBod.Link)
+		log.Printf("Processing download request for URL: %s", link)
+
+		priority, err := jobs.ParsePriority(linkBod.Priority)
+		if err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid priority",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
 			return
 		}
 
-		// Base directory to serve from
-		baseDir := "./videos"
+		folder, err := library.CleanRelPath(linkBod.Folder)
+		if err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid folder",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
 
-		// Clean the path and join with baseDir
-		relPath := strings.TrimPrefix(r.URL.Path, "/videos/")
+		if _, ok := s.cfg.PostProcess.Profiles[linkBod.Profile]; linkBod.Profile != "" && !ok {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Unknown post-processing profile",
+				Details: fmt.Sprintf("%q is not in postprocess.profiles", linkBod.Profile),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
 
-		// Security check: prevent directory traversal
-		if strings.Contains(relPath, "..") || strings.Contains(relPath, "/") {
-			log.Printf("Potential directory traversal attempt: %s", relPath)
-			http.Error(w, "Invalid file path", http.StatusBadRequest)
+		if err := linkBod.Limits.Validate(); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid playlist limits",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
 			return
 		}
+		if linkBod.Limits.Empty() {
+			linkBod.Limits = nil
+		}
 
-		targetPath := filepath.Join(baseDir, relPath)
-		log.Printf("Serving file: %s", targetPath)
+		// Reject bad links and arguments now rather than when the job runs
+		if _, downloadErr := selectBackend(link, linkBod.Args, s.downloaders); downloadErr != nil {
+			writeError(w, downloadErr)
+			return
+		}
 
-		fi, err := os.Stat(targetPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Printf("File not found: %s", targetPath)
-				http.NotFound(w, r)
-			} else {
-				log.Printf("Error accessing file %s: %v", targetPath, err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-			}
+		key := r.Header.Get("Idempotency-Key")
+		if !validIdempotencyKey(key) {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid Idempotency-Key header",
+				Details: "the key must be 1 to 255 printable ASCII characters",
+				Code:    http.StatusBadRequest,
+			})
 			return
 		}
+		sub := jobs.Submission{
+			URL:            link,
+			Args:           linkBod.Args,
+			Folder:         folder,
+			Priority:       priority,
+			User:           userName(r),
+			Profile:        linkBod.Profile,
+			Limits:         linkBod.Limits,
+			IdempotencyKey: key,
+		}
 
-		// If it's a directory, return error
-		if fi.IsDir() {
-			log.Printf("Attempted to access directory as file: %s", targetPath)
-			http.Error(w, "Cannot serve directories", http.StatusBadRequest)
+		job, created, quota, ok := s.submitDownload(w, r, sub)
+		if !ok {
+			return
+		}
+		if !created {
+			writeExistingJob(w, job)
 			return
 		}
 
-		// Serve file for download
-		w.Header().Set("Content-Disposition", "attachment; filename="+fi.Name())
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", fi.Size()))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{
+			Success: true,
+			Message: "Video download queued",
+			JobID:   job.ID,
+			Quota:   quota,
+		})
+		return
+	}
+
+	// Method not allowed
+	writeMethodNotAllowed(w, r)
+}
 
-		log.Printf("Serving file %s (%d bytes)", fi.Name(), fi.Size())
-		http.ServeFile(w, r, targetPath)
+// withoutManagement serves mux's routes except the management routes,
+// which are not found
+func withoutManagement(mux http.Handler, routes []string, pages *errorPages) http.Handler {
+	public := http.NewServeMux()
+	public.Handle("/", mux)
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages.write(w, r, http.StatusNotFound, "This page is only available on the management address.")
 	})
-
-	fmt.Printf("Listening on http://0.0.0.0%s\n", *addr)
-	if err := http.ListenAndServe(*addr, mux); err != nil {
-		log.Fatalf("server error: %w", err)
+	for _, pattern := range routes {
+		public.Handle(pattern, notFound)
 	}
+	return public
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+var errNoSourceURL = errors.New("video has no source URL to re-download from")
+
+// maintenance tracks the background library upkeep tasks
+type maintenance struct {
+	mu     sync.Mutex
+	verify verifyReport
+	// rescanJob is the ID of the running rescan, if any
+	rescanJob string
+	// transcodes maps videos being transcoded to their job IDs
+	transcodes map[string]string
+	// migrateJob is the ID of the running pool migration, if any
+	migrateJob string
+	// transcribes maps videos being transcribed to their job IDs
+	transcribes map[string]string
+	// transcribeMu runs one transcription at a time
+	transcribeMu sync.Mutex
+	// analyzeJob is the ID of the running format analysis, if any
+	analyzeJob string
+}
+
+// verifyReport is the outcome of the latest integrity check
+type verifyReport struct {
+	Running    bool            `json:"running"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Checked    int             `json:"checked"`
+	Problems   []verifyProblem `json:"problems"`
+}
+
+type verifyProblem struct {
+	VideoID  string `json:"video_id"`
+	FilePath string `json:"file_path"`
+	Problem  string `json:"problem"`
+	// RepairJobID is the re-download job, when one was queued
+	RepairJobID string `json:"repair_job_id,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// verifyLibrary checks every video's file against its recorded size and
+// hash, flagging problems and optionally queueing re-downloads. It returns
+// false without doing anything when a check is already running.
+func (s *server) verifyLibrary(ctx context.Context, repair bool) bool {
+	s.maint.mu.Lock()
+	if s.maint.verify.Running {
+		s.maint.mu.Unlock()
+		return false
+	}
+	started := time.Now()
+	s.maint.verify = verifyReport{Running: true, StartedAt: &started, Problems: []verifyProblem{}}
+	s.maint.mu.Unlock()
+
+	log.Printf("Verifying library integrity")
+	var problems []verifyProblem
+	videos := s.videos.List()
+	for _, v := range videos {
+		if ctx.Err() != nil {
+			break
+		}
+
+		problem := s.verifyVideo(ctx, v)
+		s.videos.MarkVerified(v.ID, problem)
+		if problem == "" {
+			continue
+		}
+
+		log.Printf("Video %s (%s) needs repair: %s", v.ID, v.FilePath, problem)
+		p := verifyProblem{VideoID: v.ID, FilePath: v.FilePath, Problem: problem}
+		if repair {
+			jobID, err := s.repairVideo(v, jobs.PriorityLow)
+			if err != nil {
+				p.RepairError = err.Error()
+			}
+			p.RepairJobID = jobID
+		}
+		problems = append(problems, p)
+	}
+
+	if err := s.videos.SaveMetadata(); err != nil {
+		log.Printf("Failed to save library metadata: %v", err)
+	}
+	log.Printf("Verified %d videos, %d need repair", len(videos), len(problems))
+
+	s.maint.mu.Lock()
+	s.maint.verify.Running = false
+	finished := time.Now()
+	s.maint.verify.FinishedAt = &finished
+	s.maint.verify.Checked = len(videos)
+	if problems != nil {
+		s.maint.verify.Problems = problems
+	}
+	s.maint.mu.Unlock()
+	return true
+}
+
+// verifyVideo checks local files on disk and remote files for existence
+func (s *server) verifyVideo(ctx context.Context, v library.Video) string {
+	if !v.IsRemote() {
+		return s.videos.Verify(v)
+	}
+	if s.storage == nil || s.storage.Name() != v.Storage {
+		// Can't reach the backend, so leave the video's state alone
+		return v.Problem
+	}
+
+	resp, err := s.storage.Get(ctx, v.RemoteKey, http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return library.ProblemMissing
+	}
+	resp.Body.Close()
+	return ""
+}
+
+// repairVideo removes a damaged local file and queues a re-download of
+// the video's source page at priority, returning the job ID
+func (s *server) repairVideo(v library.Video, priority jobs.Priority) (string, error) {
+	if v.WebpageURL == "" {
+		return "", errNoSourceURL
+	}
+
+	if !v.IsRemote() {
+		if err := os.Remove(s.videos.AbsPath(v)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	job := s.jobs.Create(v.WebpageURL, nil, v.Folder(), priority)
+	s.queue.Push(job.ID, priority)
+	log.Printf("Queued repair job %s for %s", job.ID, v.FilePath)
+	return job.ID, nil
+}
+
+// handleVerify serves /api/maintenance/verify. GET returns the latest
+// report; POST starts a check in the background, re-downloading damaged
+// files when "repair" is set or auto_repair is configured.
+func (s *server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		s.maint.mu.Lock()
+		report := s.maint.verify
+		s.maint.mu.Unlock()
+		json.NewEncoder(w).Encode(report)
+
+	case "POST":
+		var body struct {
+			Repair *bool `json:"repair"`
+		}
+		// An empty body uses the configured default
+		json.NewDecoder(r.Body).Decode(&body)
+		repair := s.cfg.Maintenance.AutoRepair
+		if body.Repair != nil {
+			repair = *body.Repair
+		}
+
+		s.maint.mu.Lock()
+		running := s.maint.verify.Running
+		s.maint.mu.Unlock()
+		if running {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Verification is already running",
+				Code:    http.StatusConflict,
+			})
+			return
+		}
+
+		// The request context ends with the response, so run detached
+		go s.verifyLibrary(context.Background(), repair)
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{Success: true, Message: "Verification started"})
+
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// handleCleanup serves /api/maintenance/cleanup. GET reports orphaned and
+// stale files without touching them; POST deletes the listed categories
+// (e.g. {"delete": ["stale_fragments"]}) and returns what was found.
+func (s *server) handleCleanup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var body struct {
+		Delete []string `json:"delete"`
+	}
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Delete) == 0 {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Request body must list the categories to delete",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		for _, category := range body.Delete {
+			switch category {
+			case library.CleanupUntracked, library.CleanupMissing, library.CleanupFragments, library.CleanupUnreferenced:
+			default:
+				writeError(w, &apperr.DownloadError{
+					Type:    apperr.TypeValidation,
+					Message: "Unknown cleanup category",
+					Details: category,
+					Code:    http.StatusBadRequest,
+				})
+				return
+			}
+		}
+	}
+
+	report, err := s.videos.FindOrphans(time.Duration(s.cfg.Maintenance.StaleAfter))
+	if err != nil {
+		log.Printf("Cleanup scan failed: %v", err)
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to scan videos directory",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	var freed int64
+	if len(body.Delete) > 0 {
+		freed = s.videos.DeleteOrphans(report, body.Delete)
+		log.Printf("Cleanup removed %d bytes (%v)", freed, body.Delete)
+	} else {
+		body.Delete = []string{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"report":          report,
+		"deleted":         body.Delete,
+		"reclaimed_bytes": freed,
+	})
+}
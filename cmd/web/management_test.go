@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"noahjalex.ute/internal/config"
+)
+
+func TestManagementRoutes(t *testing.T) {
+	users := []config.User{
+		{Name: "admin", Token: "admin-token", Admin: true},
+		{Name: "sam", Token: "sam-token"},
+	}
+	s := &server{cfg: config.Config{Users: users}}
+	pages := loadErrorPages(t.TempDir())
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/videos", ok)
+	s.handleAdmin(mux, "/api/duplicates/merge", ok)
+	s.handleAdmin(mux, "/api/tasks/{name}/run", ok)
+	management := authenticate(users, pages)(mux)
+	public := authenticate(users, pages)(withoutManagement(mux, s.managementRoutes, pages))
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		path    string
+		token   string
+		want    int
+	}{
+		{"public route on the public address", public, "/api/videos", "sam-token", http.StatusNoContent},
+		{"admin route on the public address", public, "/api/duplicates/merge", "admin-token", http.StatusNotFound},
+		{"admin pattern with a wildcard on the public address", public, "/api/tasks/cleanup/run", "admin-token", http.StatusNotFound},
+		{"admin route for an admin", management, "/api/duplicates/merge", "admin-token", http.StatusNoContent},
+		{"admin route for a user", management, "/api/tasks/cleanup/run", "sam-token", http.StatusForbidden},
+		{"public route on the management address", management, "/api/videos", "sam-token", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+// Without users the admin routes are open, but still only on the
+// management address
+func TestManagementRoutesWithoutUsers(t *testing.T) {
+	s := &server{}
+	mux := http.NewServeMux()
+	s.handleAdmin(mux, "/api/library/rescan", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for handler, want := range map[http.Handler]int{
+		mux: http.StatusNoContent,
+		withoutManagement(mux, s.managementRoutes, loadErrorPages(t.TempDir())): http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/library/rescan", nil))
+		if rec.Code != want {
+			t.Errorf("status %d, want %d", rec.Code, want)
+		}
+	}
+}
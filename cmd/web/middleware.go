@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"noahjalex.ute/internal/accesslog"
+	"noahjalex.ute/internal/i18n"
+	"noahjalex.ute/internal/tracing"
+)
+
+// middleware wraps a handler with cross-cutting behaviour
+type middleware func(http.Handler) http.Handler
+
+// chain applies mws to h so the first one listed sees the request first
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type requestIDKey struct{}
+
+// validRequestID limits client-supplied IDs to something safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags each request with the caller's X-Request-ID, or a new
+// one, and echoes it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID withRequestID attached to ctx, if any
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// negotiateLanguage picks the interface language from the request's
+// Accept-Language header, announcing it as the response's
+// Content-Language for the pages and error messages written later
+func negotiateLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Language", i18n.Negotiate(r.Header.Get("Accept-Language")))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r)
+	})
+}
+
+type accessInfoKey struct{}
+
+// accessInfo carries what inner middleware learn about a request out to
+// logRequests, which sees the request before they do
+type accessInfo struct {
+	user string
+}
+
+// noteUser records the signed-in user's name for the access log
+func noteUser(ctx context.Context, name string) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.user = name
+	}
+}
+
+// logRequests writes one line per request with its status, size and
+// latency: to access when set, otherwise to the application log
+func logRequests(access *accesslog.Log) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			info := &accessInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info))
+			defer func() {
+				status := rec.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				if access == nil {
+					log.Printf("%s %s %s %d %dB %v id=%s", r.RemoteAddr, r.Method, r.URL.RequestURI(),
+						status, rec.bytes, time.Since(started).Round(time.Microsecond), requestID(r.Context()))
+					return
+				}
+				remote, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					// Unix sockets have no client address
+					remote = ""
+				}
+				access.Write(accesslog.Entry{
+					Time:      started,
+					Remote:    remote,
+					User:      info.user,
+					Method:    r.Method,
+					URI:       r.URL.RequestURI(),
+					Proto:     r.Proto,
+					Status:    status,
+					Bytes:     rec.bytes,
+					Duration:  time.Since(started),
+					Referer:   r.Referer(),
+					UserAgent: r.UserAgent(),
+					RequestID: requestID(r.Context()),
+				})
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// traceRequests records a span for each request, continuing the caller's
+// trace when it sends a traceparent header. Spans are named after the
+// route mux picks, so requests for different videos share one name.
+func traceRequests(tracer *tracing.Tracer, mux *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		if tracer == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			name := r.Method
+			if route != "" {
+				name += " " + route
+			}
+			ctx := tracing.WithTraceparent(r.Context(), r.Header.Get("traceparent"))
+			ctx, span := tracer.Start(ctx, name, tracing.KindServer)
+			defer span.End()
+			span.SetAttr("http.request.method", r.Method)
+			span.SetAttr("url.path", r.URL.Path)
+			if route != "" {
+				span.SetAttr("http.route", route)
+			}
+			span.SetAttr("user_agent.original", r.UserAgent())
+			span.SetAttr("request.id", requestID(r.Context()))
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttr("http.response.status_code", status)
+			if status >= 500 {
+				span.Fail(http.StatusText(status))
+			}
+		})
+	}
+}
+
+// recoverPanics turns a handler panic into a 500 error page, or JSON for API
+// routes, instead of letting it take the connection down with it
+func recoverPanics(pages *errorPages) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("panic serving %s %s id=%s: %v\n%s", r.Method, r.URL.Path, requestID(r.Context()), p, debug.Stack())
+
+				if rec, ok := w.(*responseRecorder); ok && rec.status != 0 {
+					// Too late for an error response; drop the connection so the
+					// client sees a truncated body rather than a complete one
+					panic(http.ErrAbortHandler)
+				}
+				pages.write(w, r, http.StatusInternalServerError, "Something went wrong on the server. The error has been logged.")
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// responseRecorder notes the status and body size written through it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 && status >= 200 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom passes through to the underlying writer so file responses keep
+// using sendfile
+func (rec *responseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := rec.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(rec.ResponseWriter, r)
+	}
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
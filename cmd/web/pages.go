@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/throughput"
+)
+
+// recentJobs is how many finished jobs the queue page lists
+const recentJobs = 10
+
+// The queue page graphs the download speed over queueGraphWindow in
+// queueGraphStep averages, in an SVG queueGraphWidth by queueGraphHeight
+const (
+	queueGraphWindow = 5 * time.Minute
+	queueGraphStep   = 5 * time.Second
+	queueGraphWidth  = 120
+	queueGraphHeight = 30
+)
+
+// queuePage is the data for the queue page
+type queuePage struct {
+	Running  []jobs.Job
+	Queued   []jobs.Job
+	Failed   []jobs.Job
+	Finished []jobs.Job
+	// Speed is the combined download rate of the running jobs, bytes/s
+	Speed int64
+	// CompletedLastHour counts downloads finished in the past hour
+	CompletedLastHour int
+	// Bandwidth is the speed graph's polyline points, empty when nothing
+	// was downloaded in its window
+	Bandwidth string
+	// Paused are the sites whose downloads are held after they refused some
+	Paused []jobs.SiteState
+	// Budget is the data budget's use, nil when there is no budget
+	Budget *budgetStatus
+}
+
+// queuePage collects the queue's current state. Failed and canceled jobs
+// are listed apart from completed ones so they can be retried.
+func (s *server) queuePage() queuePage {
+	var data queuePage
+	for _, id := range s.queue.Pending() {
+		if job, ok := s.jobs.Get(id); ok {
+			data.Queued = append(data.Queued, job)
+		}
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	for _, job := range s.jobs.List() {
+		switch job.State {
+		case jobs.StateRunning, jobs.StateRetrying:
+			data.Running = append(data.Running, job)
+			if job.Transfer != nil {
+				data.Speed += job.Transfer.Speed
+			}
+		case jobs.StateFailed, jobs.StateCanceled, jobs.StateNeedsAuth:
+			if len(data.Failed) < recentJobs {
+				data.Failed = append(data.Failed, job)
+			}
+		case jobs.StateCompleted:
+			if len(data.Finished) < recentJobs {
+				data.Finished = append(data.Finished, job)
+			}
+			if job.Kind == jobs.KindDownload && job.UpdatedAt.After(hourAgo) {
+				data.CompletedLastHour++
+			}
+		}
+	}
+	data.Bandwidth = graphPoints(s.bandwidth.History(queueGraphWindow, queueGraphStep))
+	if s.cfg.DataBudget.MonthlyBytes > 0 {
+		st := s.budgetStatus()
+		data.Budget = &st
+	}
+	for _, site := range s.breakers.States() {
+		if site.State != jobs.CircuitClosed {
+			data.Paused = append(data.Paused, site)
+		}
+	}
+	return data
+}
+
+// graphPoints plots samples as SVG polyline points scaled to the queue
+// graph, the fastest at the top
+func graphPoints(samples []throughput.Sample) string {
+	var peak int64
+	for _, sample := range samples {
+		peak = max(peak, sample.Speed)
+	}
+	if peak == 0 || len(samples) < 2 {
+		return ""
+	}
+
+	points := make([]string, len(samples))
+	for i, sample := range samples {
+		x := float64(i) * queueGraphWidth / float64(len(samples)-1)
+		y := queueGraphHeight * (1 - float64(sample.Speed)/float64(peak))
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
+
+// handleQueuePage serves GET /queue. The page keeps its "queue-jobs"
+// fragment current from the /queue/events stream.
+func (s *server) handleQueuePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	s.views.render(w, r, "queue", "queue-jobs", s.queuePage())
+}
+
+// jobPage is the data for a job's progress page
+type jobPage struct {
+	Job jobs.Job
+	// Active is set while the job, or one of its uploads, is still going,
+	// which keeps the fragment polling
+	Active bool
+	Videos []library.Video
+	Albums []library.Album
+}
+
+// handleJobPage serves GET /jobs/{id}. The "job-progress" fragment re-polls
+// itself until the job finishes.
+func (s *server) handleJobPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		s.errPages.write(w, r, http.StatusNotFound, "That job doesn't exist. Jobs are only kept until the server restarts.")
+		return
+	}
+
+	data := jobPage{Job: job}
+	switch job.State {
+	case jobs.StateQueued, jobs.StateRunning, jobs.StateRetrying:
+		data.Active = true
+	}
+	for _, u := range job.Uploads {
+		if u.State == "pending" || u.State == "uploading" {
+			data.Active = true
+		}
+	}
+	for _, id := range job.VideoIDs {
+		if v, ok := s.videos.Get(id); ok {
+			data.Videos = append(data.Videos, v)
+		}
+	}
+	for _, id := range job.AlbumIDs {
+		if a, ok := s.videos.Album(id); ok {
+			data.Albums = append(data.Albums, a)
+		}
+	}
+
+	s.views.render(w, r, "job", "job-progress", data)
+}
+
+// sortPreset is a named sort order for the library page's dropdown
+type sortPreset struct {
+	Value string
+	Label string
+}
+
+// sortPresets are the orders offered by the library page
+var sortPresets = []sortPreset{
+	{"-modified", "Newest"},
+	{"modified", "Oldest"},
+	{"-added", "Recently added"},
+	{"-downloaded", "Recently downloaded"},
+	{"-upload_date", "Upload date"},
+	{"title", "Title"},
+	{"uploader,-upload_date", "Uploader"},
+	{"-size", "Largest"},
+	{"-views", "Most viewed"},
+	{"-likes", "Most liked"},
+	{"-duration", "Longest"},
+}
+
+// libraryPage is the data for the library page
+type libraryPage struct {
+	// Params are the request's filters, echoed back into the form
+	Params url.Values
+	Sorts  []sortPreset
+	// Languages are the language codes found in the library
+	Languages []string
+	Videos    []library.Video
+}
+
+// libraryLanguages lists the known languages in the library, most used first
+func (s *server) libraryLanguages() []string {
+	var codes []string
+	for _, b := range s.videos.Stats().ByLanguage {
+		if b.Key != "unknown" {
+			codes = append(codes, b.Key)
+		}
+	}
+	return codes
+}
+
+// handleLibraryPage serves GET /library, taking the same filter and sort
+// parameters as /api/videos. Changing the filters swaps in the
+// "video-list" fragment.
+func (s *server) handleLibraryPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	query, err := s.parseQuery(r, r.URL.Query())
+	if err != nil {
+		s.errPages.write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Refreshing the grid gets a 304 while the library is unchanged
+	if !filtersMarks(query) && notModifiedSince(w, r, s.videos.LastChange()) {
+		return
+	}
+
+	s.views.render(w, r, "library", "video-list", libraryPage{
+		Params:    r.URL.Query(),
+		Sorts:     sortPresets,
+		Languages: s.libraryLanguages(),
+		Videos:    s.videos.Find(query),
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/playback"
+)
+
+// progressResponse is a user's playback position in a video
+type progressResponse struct {
+	ID string `json:"id"`
+	// Position is in seconds, 0 when the video hasn't been started or was
+	// played to the end
+	Position  float64    `json:"position"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Finished is set when the reported position completed the video
+	Finished bool `json:"finished,omitempty"`
+}
+
+// handleProgress serves GET and POST /api/videos/{id}/progress. The player
+// POSTs {"position": seconds} as it plays, with "duration" when the library
+// doesn't know the video's length. Reaching the end clears the position and
+// marks the video as watched.
+func (s *server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	user := userName(r)
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.progress(user, v.ID))
+
+	case "POST":
+		var body struct {
+			Position *float64 `json:"position"`
+			Duration float64  `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Position == nil || *body.Position < 0 {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Request body must contain a position in seconds",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		duration := v.Duration
+		if duration <= 0 {
+			duration = body.Duration
+		}
+
+		if err := s.playback.Set(user, v.ID, *body.Position, duration); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to save the playback position",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		resp := s.progress(user, v.ID)
+		if playback.Finished(*body.Position, duration) {
+			resp.Finished = true
+			if v.WatchedAt == nil {
+				if err := s.videos.Update(v.ID, func(v *library.Video) {
+					now := time.Now()
+					v.WatchedAt = &now
+				}); err != nil {
+					log.Printf("Failed to mark %s as watched: %v", v.ID, err)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+func (s *server) progress(user, id string) progressResponse {
+	resp := progressResponse{ID: id}
+	if p, ok := s.playback.Get(user, id); ok {
+		resp.Position, resp.UpdatedAt = p.Seconds, &p.UpdatedAt
+	}
+	return resp
+}
+
+// continueWatching is a video the caller stopped partway through
+type continueWatching struct {
+	library.Video
+	Position float64   `json:"position"`
+	PlayedAt time.Time `json:"played_at"`
+}
+
+// handleContinueWatching serves GET /api/continue-watching, the videos the
+// caller stopped partway through, most recently played first
+func (s *server) handleContinueWatching(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	positions := s.playback.InProgress(userName(r))
+	list := make([]continueWatching, 0, len(positions))
+	for _, p := range positions {
+		if v, ok := s.videos.Get(p.VideoID); ok {
+			list = append(list, continueWatching{Video: v, Position: p.Seconds, PlayedAt: p.UpdatedAt})
+		}
+	}
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+// migrateResult counts what a pool migration did
+type migrateResult struct {
+	Moved  int `json:"moved"`
+	Failed int `json:"failed"`
+}
+
+// placementFor returns the pool the placement rules want v in, "" for the
+// videos directory. ok is false when no rule matches.
+func (s *server) placementFor(v library.Video) (pool string, ok bool) {
+	for _, rule := range s.cfg.Pools.Rules {
+		if rule.MinSize > 0 && v.Size < rule.MinSize {
+			continue
+		}
+		if len(rule.Sites) > 0 && !slices.ContainsFunc(rule.Sites, func(site string) bool {
+			return strings.EqualFold(site, v.Extractor)
+		}) {
+			continue
+		}
+		if rule.OlderThan > 0 && time.Since(v.AddedAt) < time.Duration(rule.OlderThan) {
+			continue
+		}
+		if rule.Pool == config.MainPool {
+			return "", true
+		}
+		return rule.Pool, true
+	}
+	return "", false
+}
+
+// startMigration moves every local video to the pool its placement rule
+// picks as a background job. If a migration is already running its job is
+// returned instead.
+func (s *server) startMigration() jobs.Job {
+	s.maint.mu.Lock()
+	defer s.maint.mu.Unlock()
+
+	if s.maint.migrateJob != "" {
+		if job, ok := s.jobs.Get(s.maint.migrateJob); ok {
+			return job
+		}
+	}
+
+	job := s.jobs.CreateTask(jobs.KindMigrate)
+	s.maint.migrateJob = job.ID
+
+	go func() {
+		var moves []library.Video
+		var pools []string
+		var result migrateResult
+		for _, v := range s.videos.List() {
+			pool, ok := s.placementFor(v)
+			if !ok || pool == v.Pool || v.IsRemote() || v.State == library.StateNeedsRepair {
+				continue
+			}
+			moves = append(moves, v)
+			pools = append(pools, pool)
+		}
+
+		for i, v := range moves {
+			if _, err := s.videos.MoveToPool(v.ID, pools[i]); err != nil {
+				log.Printf("Failed to move %s to pool %s: %v", v.FilePath, pools[i], err)
+				result.Failed++
+			} else {
+				result.Moved++
+			}
+			s.jobs.Update(job.ID, func(j *jobs.Job) {
+				j.Progress = &jobs.Progress{Done: i + 1, Total: len(moves)}
+			})
+		}
+
+		s.finishTask(job.ID, result, nil)
+		if len(moves) > 0 {
+			log.Printf("Pool migration: %d moved, %d failed", result.Moved, result.Failed)
+		}
+
+		s.maint.mu.Lock()
+		s.maint.migrateJob = ""
+		s.maint.mu.Unlock()
+	}()
+	return job
+}
+
+// poolSummary describes a storage pool for GET /api/pools and
+// GET /api/system
+type poolSummary struct {
+	Name       string `json:"name"`
+	Dir        string `json:"dir"`
+	VideoCount int    `json:"video_count"`
+	TotalSize  int64  `json:"total_size"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// handlePools serves GET /api/pools, listing the videos directory and every
+// storage pool with the videos each holds and the space left on its disk
+func (s *server) handlePools(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.poolSummaries())
+}
+
+// poolSummaries describes the videos directory and every storage pool
+func (s *server) poolSummaries() []poolSummary {
+	summaries := []poolSummary{{Name: config.MainPool, Dir: s.cfg.VideosDir}}
+	index := map[string]int{"": 0}
+	for _, pool := range s.cfg.Pools.Dirs {
+		index[pool.Name] = len(summaries)
+		summaries = append(summaries, poolSummary{Name: pool.Name, Dir: pool.Dir})
+	}
+	for _, v := range s.videos.List() {
+		if i, ok := index[v.Pool]; ok && !v.IsRemote() {
+			summaries[i].VideoCount++
+			summaries[i].TotalSize += v.Size
+		}
+	}
+	for i := range summaries {
+		free, total, err := library.DiskUsage(summaries[i].Dir)
+		if err != nil {
+			log.Printf("Failed to read disk usage for %s: %v", summaries[i].Dir, err)
+			continue
+		}
+		summaries[i].FreeBytes, summaries[i].TotalBytes = free, total
+	}
+	return summaries
+}
+
+// handleMigrate serves POST /api/pools/migrate, moving videos to the pools
+// their placement rules pick and returning the job that tracks it
+func (s *server) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	job := s.startMigration()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Pool migration started",
+		JobID:   job.ID,
+	})
+}
+
+// registerPools adds the configured storage pools to the library, creating
+// their directories
+func registerPools(videos *library.VideoService, pools []config.Pool) {
+	for _, pool := range pools {
+		if err := os.MkdirAll(pool.Dir, 0755); err != nil {
+			log.Printf("Warning: failed to create pool %s at %s: %v", pool.Name, pool.Dir, err)
+		}
+		videos.AddPool(pool.Name, pool.Dir)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/downloader"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/media"
+	"noahjalex.ute/internal/postprocess"
+)
+
+// registerPostProcessors adds the built-in steps run after each download.
+// Transcoding and metadata embedding rewrite files, so they're off unless
+// a profile enables them.
+func (s *server) registerPostProcessors() {
+	s.postProcessors.Register(postprocess.Func("thumbnail", s.thumbnailStep), 10, true)
+	s.postProcessors.Register(postprocess.Func("transcribe", s.transcribeStep), 20, true)
+	s.postProcessors.Register(postprocess.Func("summarize", s.summarizeStep), 30, true)
+	s.postProcessors.Register(postprocess.Func("embed-metadata", s.embedMetadataStep), 40, false)
+	s.postProcessors.Register(postprocess.Func("transcode", s.transcodeStep), 50, false)
+	// Uploads come after everything that reads or rewrites the local files
+	s.postProcessors.Register(postprocess.Func("upload", s.uploadStep), 60, true)
+	s.postProcessors.Register(postprocess.Func("notify", s.notifyStep), 70, true)
+}
+
+// profile returns the post-processing profile called name, or the default
+// one for an empty name
+func (s *server) profile(name string) config.Profile {
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	return s.cfg.PostProcess.Profiles[name]
+}
+
+// siteOptions are the yt-dlp site settings of the profile called name
+func (s *server) siteOptions(name string) downloader.SiteOptions {
+	p := s.profile(name)
+	return downloader.SiteOptions{ExtractorArgs: p.ExtractorArgs, Impersonate: p.Impersonate}
+}
+
+// eachVideo calls fn with the current record of every video in item,
+// details included, joining the errors
+func (s *server) eachVideo(item *postprocess.Item, fn func(v library.Video) error) error {
+	var errs []error
+	for _, v := range item.Videos {
+		if current, ok := s.videos.GetFull(v.ID); ok {
+			v = current
+		}
+		if err := fn(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.FilePath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// thumbnailStep renders a thumbnail for videos without one. Videos ffprobe
+// couldn't read are skipped, as ffmpeg won't manage either.
+func (s *server) thumbnailStep(ctx context.Context, item *postprocess.Item) error {
+	return s.eachVideo(item, func(v library.Video) error {
+		if v.Codec == "" || v.IsRemote() {
+			return nil
+		}
+		return s.generateThumbnail(ctx, v, v.Duration)
+	})
+}
+
+func (s *server) transcribeStep(ctx context.Context, item *postprocess.Item) error {
+	if s.transcriber == nil {
+		return nil
+	}
+	return s.eachVideo(item, func(v library.Video) error {
+		return s.transcribeVideo(ctx, v)
+	})
+}
+
+func (s *server) summarizeStep(ctx context.Context, item *postprocess.Item) error {
+	if s.enricher == nil {
+		return nil
+	}
+	return s.eachVideo(item, func(v library.Video) error {
+		_, err := s.summarizeVideo(ctx, v)
+		return err
+	})
+}
+
+// embedMetadataStep writes the title, uploader, date, description and
+// source page into each video file, so players and other libraries show
+// them
+func (s *server) embedMetadataStep(ctx context.Context, item *postprocess.Item) error {
+	err := s.eachVideo(item, func(v library.Video) error {
+		if v.IsRemote() {
+			return errRemoteVideo
+		}
+		tags := map[string]string{}
+		for key, value := range map[string]string{
+			"title":       v.Title,
+			"artist":      v.Uploader,
+			"date":        v.UploadDate,
+			"description": v.Description,
+			"comment":     v.WebpageURL,
+		} {
+			if value != "" {
+				tags[key] = value
+			}
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+
+		path := s.videos.AbsPath(v)
+		// The .part suffix keeps the half-written file out of rescans
+		tmp := path + ".metadata.part"
+		defer os.Remove(tmp)
+		if err := media.EmbedMetadata(ctx, path, tmp, tags); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		// Re-adding records the new size and hash
+		_, err := s.videos.AddFile(path)
+		return err
+	})
+	if saveErr := s.videos.SaveMetadata(); saveErr != nil {
+		log.Printf("Failed to save library metadata: %v", saveErr)
+	}
+	return err
+}
+
+// transcodeStep re-encodes videos browsers can't play, swapping item's
+// files for the new ones
+func (s *server) transcodeStep(ctx context.Context, item *postprocess.Item) error {
+	return s.eachVideo(item, func(v library.Video) error {
+		if !library.NeedsTranscode(v) || v.IsRemote() {
+			return nil
+		}
+		old := s.videos.AbsPath(v)
+		if err := s.transcodeVideo(ctx, item.Job.ID, v); err != nil {
+			return err
+		}
+		if updated, ok := s.videos.Get(v.ID); ok {
+			if i := slices.Index(item.Files, old); i >= 0 {
+				item.Files[i] = s.videos.AbsPath(updated)
+			}
+		}
+		return nil
+	})
+}
+
+// uploadStep pushes the files to any matching rclone remote, then moves
+// the videos to remote storage when it's configured
+func (s *server) uploadStep(ctx context.Context, item *postprocess.Item) error {
+	// Push before moving to storage, which may delete the local copies
+	s.pushToRemote(ctx, item.Job, item.Files)
+
+	if s.storage == nil {
+		return nil
+	}
+	return s.eachVideo(item, func(v library.Video) error {
+		return s.uploadToStorage(ctx, v)
+	})
+}
+
+// notifyStep announces the new videos to the event bus's subscribers
+func (s *server) notifyStep(ctx context.Context, item *postprocess.Item) error {
+	return s.eachVideo(item, func(v library.Video) error {
+		s.publishVideo(events.VideoAdded, v)
+		return nil
+	})
+}
+
+// handlePostProcessors serves GET /api/postprocessors, the steps run after
+// downloads in order and the configured profiles
+func (s *server) handlePostProcessors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	profiles := s.cfg.PostProcess.Profiles
+	if profiles == nil {
+		profiles = map[string]config.Profile{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"processors": s.postProcessors.List(),
+		"profiles":   profiles,
+	})
+}
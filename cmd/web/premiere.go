@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"noahjalex.ute/internal/downloader"
+	"noahjalex.ute/internal/jobs"
+)
+
+const (
+	// premiereGrace is how long after a premiere ends its download starts,
+	// giving the site time to publish the finished video
+	premiereGrace = 10 * time.Minute
+	// premiereRecheck is how long a download that reached a premiere
+	// waits when the premiere's start time can't be found
+	premiereRecheck = time.Hour
+)
+
+// errNoProber is returned for links whose backend can't inspect them
+var errNoProber = errors.New("the backend can't look up links")
+
+// premiereEnd returns when the upcoming premiere or live stream a probe
+// found should be downloaded, or nil when it isn't one
+func premiereEnd(p *downloader.Probe) *time.Time {
+	if p == nil || p.StartsAt == nil {
+		return nil
+	}
+	at := p.StartsAt.Add(time.Duration(p.Duration*float64(time.Second)) + premiereGrace)
+	return &at
+}
+
+// probeJob looks up the job's URL with the backend that would download it
+func (s *server) probeJob(ctx context.Context, job jobs.Job) (*downloader.Probe, error) {
+	parsed, err := url.Parse(job.URL)
+	if err != nil {
+		return nil, err
+	}
+	prober, ok := s.downloaders.For(parsed).(downloader.Prober)
+	if !ok {
+		return nil, errNoProber
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return prober.Probe(ctx, job.URL, "", s.siteOptions(job.Profile))
+}
+
+// resolveClip finds the video a clip link was cut from and the part it
+// covers, saving them on the job. Links that aren't clips, and clips
+// resolved by an earlier run, are returned as they are. A clip that can't
+// be resolved is handed to the backend as it is.
+func (s *server) resolveClip(ctx context.Context, job jobs.Job) jobs.Job {
+	if job.Clip != nil || !downloader.IsClipURL(job.URL) {
+		return job
+	}
+	p, err := s.probeJob(ctx, job)
+	if err == nil && (p.ClipOf == "" || p.Section == nil) {
+		err = errors.New("no source video reported")
+	}
+	if err != nil {
+		log.Printf("Failed to resolve clip %s, downloading the link as it is: %v", job.URL, err)
+		return job
+	}
+
+	clip := &jobs.Clip{Source: p.ClipOf, Start: p.Section.Start, End: p.Section.End}
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.Clip = clip })
+	job.Clip = clip
+	log.Printf("Clip %s is %gs-%gs of %s", job.URL, clip.Start, clip.End, clip.Source)
+	return job
+}
+
+// schedulePremiere puts a download that reached a premiere or live
+// stream before it began back in the queue, held until it has ended
+func (s *server) schedulePremiere(ctx context.Context, job jobs.Job, record jobs.Attempt) {
+	at := time.Now().Add(premiereRecheck)
+	if p, err := s.probeJob(ctx, job); err != nil {
+		log.Printf("Failed to look up the premiere of %s: %v", job.URL, err)
+	} else if end := premiereEnd(p); end != nil && end.After(time.Now()) {
+		at = *end
+	}
+
+	record.RetryDelay = time.Until(at).Round(time.Second).String()
+	err := s.jobs.Transition(job.ID, jobs.StateQueued, func(j *jobs.Job) error {
+		j.Attempts = append(j.Attempts, record)
+		j.ScheduledFor = &at
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to reschedule job %s: %v", job.ID, err)
+		return
+	}
+	s.queue.Push(job.ID, job.Priority)
+	log.Printf("%s hasn't started yet, job %s waits until %s", job.URL, job.ID, at.Format(time.RFC3339))
+}
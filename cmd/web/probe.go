@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+)
+
+const (
+	// probeTimeout bounds a probe, which the UI runs as the link is typed
+	probeTimeout = 30 * time.Second
+	// Probes are cached for probeCacheTTL, keeping at most
+	// probeCacheSize
+	probeCacheTTL  = 10 * time.Minute
+	probeCacheSize = 256
+)
+
+// probeResponse is what GET /api/probe found out about a URL
+type probeResponse struct {
+	URL     string `json:"url"`
+	Backend string `json:"backend"`
+	// Supported is false when the backend doesn't recognise the URL
+	Supported bool `json:"supported"`
+	*downloader.Probe
+	// Error explains why an unsupported URL isn't
+	Error string `json:"error,omitempty"`
+}
+
+// probeCache remembers recent probes so the preview shown after a probe,
+// or a link pasted twice, doesn't run yt-dlp again
+type probeCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedProbe
+}
+
+type cachedProbe struct {
+	resp probeResponse
+	at   time.Time
+}
+
+func (c *probeCache) get(key string) (probeResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.at) > probeCacheTTL {
+		return probeResponse{}, false
+	}
+	return e.resp, true
+}
+
+func (c *probeCache) put(key string, resp probeResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedProbe)
+	}
+	if len(c.entries) >= probeCacheSize {
+		// Drop expired entries, then the oldest if that wasn't enough
+		oldest := ""
+		for k, e := range c.entries {
+			if time.Since(e.at) > probeCacheTTL {
+				delete(c.entries, k)
+			} else if oldest == "" || e.at.Before(c.entries[oldest].at) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= probeCacheSize {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = cachedProbe{resp: resp, at: time.Now()}
+}
+
+// probeLink looks up what link points at, from the cache when it was
+// probed recently. Failed lookups aren't cached.
+func (s *server) probeLink(ctx context.Context, link string) (probeResponse, *apperr.DownloadError) {
+	if err := validateURL(link); err != nil {
+		return probeResponse{}, err
+	}
+	format := s.settings.Get().DefaultFormat
+	key := format + " " + link
+	if resp, ok := s.probes.get(key); ok {
+		return resp, nil
+	}
+
+	parsed, _ := url.Parse(link)
+	backend := s.downloaders.For(parsed)
+	resp := probeResponse{URL: link, Backend: backend.Name(), Supported: true}
+
+	prober, ok := backend.(downloader.Prober)
+	if !ok {
+		// Backends picked by URL pattern are sure to take it
+		return resp, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	if err := checkDownloader(ctx, backend); err != nil {
+		return probeResponse{}, err
+	}
+
+	// Previews assume the default profile, as most downloads use it
+	probe, err := prober.Probe(ctx, link, format, s.siteOptions(""))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return probeResponse{}, &apperr.DownloadError{
+				Type:    apperr.TypeNetwork,
+				Message: "Probing the URL took too long",
+				Code:    http.StatusGatewayTimeout,
+			}
+		}
+		downloadErr := apperr.Classify(err)
+		var execErr *downloader.ExecError
+		if !errors.As(err, &execErr) || !strings.Contains(strings.ToLower(execErr.Stderr), "unsupported url") {
+			log.Printf("Probe of %s failed: %v", link, err)
+			return probeResponse{}, downloadErr
+		}
+		resp.Supported, resp.Error = false, downloadErr.Message
+	} else {
+		resp.Probe = probe
+	}
+	s.probes.put(key, resp)
+	return resp, nil
+}
+
+// handleProbe serves GET /api/probe?url=..., reporting which backend and
+// extractor would download a URL and whether it's a single video, a
+// playlist or a live stream, with its estimated size
+func (s *server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	resp, err := s.probeLink(r.Context(), strings.TrimSpace(r.URL.Query().Get("url")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// previewResponse is what a confirmation card shows about a link
+type previewResponse struct {
+	URL       string `json:"url"`
+	Kind      string `json:"kind,omitempty"`
+	Title     string `json:"title"`
+	Uploader  string `json:"uploader,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// Duration is in seconds, 0 when unknown
+	Duration float64 `json:"duration,omitempty"`
+	// Estimate is the download's expected size and transfer time
+	Estimate *jobs.Estimate `json:"estimate,omitempty"`
+}
+
+// handlePreview serves GET /api/preview?url=..., the title, uploader,
+// thumbnail, duration and expected download size and time of a link, for
+// confirming it before downloading. It shares the probe cache.
+func (s *server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	link := strings.TrimSpace(r.URL.Query().Get("url"))
+	probe, err := s.probeLink(r.Context(), link)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !probe.Supported {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Unsupported URL",
+			Details: probe.Error,
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	preview := previewResponse{URL: link}
+	if p := probe.Probe; p != nil {
+		preview.Kind, preview.Title, preview.Uploader = p.Kind, p.Title, p.Uploader
+		preview.Thumbnail, preview.Duration = p.Thumbnail, p.Duration
+		preview.Estimate = s.estimateProbe(p)
+	}
+	if preview.Title == "" {
+		parsed, _ := url.Parse(link)
+		preview.Title = path.Base(parsed.Path)
+	}
+	json.NewEncoder(w).Encode(preview)
+}
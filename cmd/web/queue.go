@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+)
+
+const (
+	// queueEventInterval is the least time between the queue stream's
+	// updates, however often jobs change
+	queueEventInterval = time.Second
+	// queueKeepAlive is how often a quiet queue stream looks for changes
+	// no job reported, such as reordering, and otherwise sends a comment
+	// to keep proxies from closing it
+	queueKeepAlive = 15 * time.Second
+)
+
+// runningJobs holds the cancel functions of downloads in progress
+type runningJobs struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func (r *runningJobs) add(id string, cancel context.CancelFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancels == nil {
+		r.cancels = make(map[string]context.CancelFunc)
+	}
+	r.cancels[id] = cancel
+}
+
+func (r *runningJobs) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, id)
+}
+
+// cancel stops the download with id, reporting whether it was running
+func (r *runningJobs) cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.cancels[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// handleCancelJob serves POST /api/jobs/{id}/cancel. A queued job is
+// dropped from the queue; a running download is stopped.
+func (s *server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, ok := s.jobs.Get(id); !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Job not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	switch {
+	case s.queue.Remove(id):
+		if err := s.jobs.Transition(id, jobs.StateCanceled, nil); err != nil {
+			log.Printf("Failed to mark job %s canceled: %v", id, err)
+		}
+		log.Printf("Job %s removed from the queue", id)
+	case s.running.cancel(id):
+		// The worker records the canceled state once the download stops
+		log.Printf("Canceling job %s", id)
+	case s.clusterCancel(id):
+		log.Printf("Asked another node to cancel job %s", id)
+	case s.redisCancel(id):
+		log.Printf("Asked another process to cancel job %s", id)
+	default:
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Only queued or running downloads can be canceled",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Job canceled",
+		JobID:   id,
+	})
+}
+
+// handleRetryJob serves POST /api/jobs/{id}/retry, queueing a failed or
+// canceled download, or one that needs authentication, again. The job
+// keeps its attempt history.
+func (s *server) handleRetryJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	if quota, err := s.checkQuota(r); err != nil {
+		writeQuotaError(w, quota, err)
+		return
+	}
+
+	id := r.PathValue("id")
+	var priority jobs.Priority
+	var link string
+	err := s.jobs.Transition(id, jobs.StateQueued, func(j *jobs.Job) error {
+		// Running jobs only go back to the queue when their process stops
+		if j.Kind != jobs.KindDownload || (j.State != jobs.StateFailed && j.State != jobs.StateCanceled && j.State != jobs.StateNeedsAuth) {
+			return jobs.ErrInvalidTransition
+		}
+		priority, link = j.Priority, j.URL
+		return nil
+	})
+	if errors.Is(err, jobs.ErrNoJob) {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Job not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Only failed, canceled or needs_auth downloads can be retried",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	s.queue.Push(id, priority)
+	log.Printf("Job %s queued again for URL %s", id, link)
+
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+id)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Job queued again",
+		JobID:   id,
+	})
+}
+
+// handleQueueEvents serves GET /queue/events, a server-sent event stream
+// that sends the queue page's "queue-jobs" fragment whenever it changes.
+// Job updates from the store wake it rather than each connection polling.
+func (s *server) handleQueueEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This stream only supports GET.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+
+	// Only the fact that something changed matters, so one waiting
+	// update is enough
+	sub := s.jobs.Subscribe(nil, 1, jobs.DropOldest)
+	defer sub.Close()
+	keepAlive := time.NewTicker(queueKeepAlive)
+	defer keepAlive.Stop()
+
+	var last []byte
+	quiet := false
+	for {
+		fragment, err := s.views.fragment(responseLanguage(w), "queue", "queue-jobs", s.queuePage())
+		if err != nil {
+			log.Printf("Error rendering queue events: %v", err)
+			return
+		}
+
+		var event bytes.Buffer
+		if !bytes.Equal(fragment, last) {
+			// Every line of the payload needs its own data field
+			event.WriteString("event: queue\n")
+			for _, line := range bytes.Split(bytes.TrimRight(fragment, "\n"), []byte("\n")) {
+				event.WriteString("data: ")
+				event.Write(line)
+				event.WriteString("\n")
+			}
+			event.WriteString("\n")
+			last = fragment
+		} else if quiet {
+			event.WriteString(": keep-alive\n\n")
+		}
+
+		if event.Len() > 0 {
+			if _, err := w.Write(event.Bytes()); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		// Progress reports arrive many times a second; updates that come
+		// in while waiting out the interval are sent together
+		throttle := time.NewTimer(queueEventInterval)
+		select {
+		case <-r.Context().Done():
+			throttle.Stop()
+			return
+		case <-throttle.C:
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.C:
+			quiet = false
+		case <-keepAlive.C:
+			quiet = true
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+)
+
+// maxQuickAddBody bounds the shared text read by /api/quick-add
+const maxQuickAddBody = 64 << 10
+
+// quickAddPage confirms a quick-add to a browser
+var quickAddPage = template.Must(template.New("quick-add").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><meta name="viewport" content="width=device-width, initial-scale=1.0"><title>{{.Message}} - Ute</title></head>
+<body>
+<p>{{.Message}}: <a href="{{.URL}}" rel="noopener noreferrer">{{.URL}}</a></p>
+<p><a href="/jobs/{{.JobID}}">View the job</a> &middot; <a href="/">Back to the library</a></p>
+</body>
+</html>
+`))
+
+// firstURL returns the first http or https link in text, which is often
+// surrounded by a title or a message, without any punctuation that
+// follows it
+func firstURL(text string) string {
+	lower := strings.ToLower(text)
+	for start := 0; start < len(text); {
+		i := strings.Index(lower[start:], "http")
+		if i < 0 {
+			return ""
+		}
+		i += start
+		start = i + 4
+		if !strings.HasPrefix(lower[i:], "http://") && !strings.HasPrefix(lower[i:], "https://") {
+			continue
+		}
+
+		end := strings.IndexFunc(text[i:], func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune("<>\"`", r)
+		})
+		link := text[i:]
+		if end >= 0 {
+			link = text[i : i+end]
+		}
+		link = trimLinkEnd(link)
+
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			return link
+		}
+	}
+	return ""
+}
+
+// trimLinkEnd drops sentence punctuation and closing brackets that have
+// no opening match in the link
+func trimLinkEnd(link string) string {
+	for link != "" {
+		last := link[len(link)-1]
+		switch last {
+		case '.', ',', ';', ':', '!', '?', '\'', '*':
+			link = link[:len(link)-1]
+			continue
+		case ')', ']', '}':
+			open := map[byte]string{')': "(", ']': "[", '}': "{"}[last]
+			if strings.Count(link, open) < strings.Count(link, string(last)) {
+				link = link[:len(link)-1]
+				continue
+			}
+		}
+		return link
+	}
+	return link
+}
+
+// quickAddText returns the text a share sent: the url, text and title
+// form fields in that order, or a plain text body
+func quickAddText(r *http.Request) (string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxQuickAddBody)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(maxQuickAddBody); err != nil && err != http.ErrNotMultipart {
+			return "", err
+		}
+		return strings.Join([]string{r.PostForm.Get("url"), r.PostForm.Get("text"), r.PostForm.Get("title")}, " "), nil
+	}
+	body, err := io.ReadAll(r.Body)
+	return string(body), err
+}
+
+// handleQuickAdd serves POST /api/quick-add, queueing the first link in a
+// form or plain text body the way phone share menus send them. Browsers
+// get a short page back, everything else JSON.
+func (s *server) handleQuickAdd(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	text, err := quickAddText(r)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	link := firstURL(text)
+	if link == "" {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "No link found in the shared text",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if _, downloadErr := selectBackend(link, nil, s.downloaders); downloadErr != nil {
+		writeError(w, downloadErr)
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	if !validIdempotencyKey(key) {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid Idempotency-Key header",
+			Details: "the key must be 1 to 255 printable ASCII characters",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	job, created, quota, ok := s.submitDownload(w, r, jobs.Submission{
+		URL:            link,
+		Priority:       jobs.PriorityNormal,
+		User:           userName(r),
+		IdempotencyKey: key,
+	})
+	if !ok {
+		return
+	}
+
+	status, message := http.StatusAccepted, "Video download queued"
+	if !created {
+		status, message = http.StatusOK, "Video download already queued"
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		quickAddPage.Execute(w, struct{ Message, URL, JobID string }{message, link, job.ID})
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: message,
+		JobID:   job.ID,
+		Quota:   quota,
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+// rcloneRule returns the first rclone rule matching link
+func (s *server) rcloneRule(link string) (config.RcloneRule, bool) {
+	for _, rule := range s.cfg.Rclone.Rules {
+		// Patterns are checked when the config is loaded
+		if regexp.MustCompile(rule.Match).MatchString(link) {
+			return rule, true
+		}
+	}
+	return config.RcloneRule{}, false
+}
+
+// pushToRemote copies a job's files, including video sidecars, to the
+// remote chosen by the rclone rules, recording progress on the job
+func (s *server) pushToRemote(ctx context.Context, job jobs.Job, files []string) {
+	rule, ok := s.rcloneRule(job.URL)
+	if !ok {
+		return
+	}
+
+	videosDir, err := filepath.Abs(s.cfg.VideosDir)
+	if err != nil {
+		videosDir = s.cfg.VideosDir
+	}
+
+	var uploads []jobs.Upload
+	seen := make(map[string]bool)
+	for _, file := range files {
+		related := []string{file}
+		if library.IsVideoFile(file) {
+			if found, err := library.RelatedFiles(file); err == nil {
+				related = found
+			}
+		}
+		for _, f := range related {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+
+			rel, err := filepath.Rel(videosDir, f)
+			if err != nil {
+				rel = filepath.Base(f)
+			}
+			uploads = append(uploads, jobs.Upload{
+				File:        f,
+				Destination: strings.TrimSuffix(rule.Remote, "/") + "/" + filepath.ToSlash(rel),
+				State:       "pending",
+			})
+		}
+	}
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.Uploads = append([]jobs.Upload(nil), uploads...) })
+
+	for i, upload := range uploads {
+		setUpload := func(fn func(*jobs.Upload)) {
+			s.jobs.Update(job.ID, func(j *jobs.Job) {
+				if i < len(j.Uploads) {
+					fn(&j.Uploads[i])
+				}
+			})
+		}
+
+		setUpload(func(u *jobs.Upload) { u.State = "uploading" })
+		err := s.rclone.CopyTo(ctx, upload.File, upload.Destination, func(bytes, total int64) {
+			setUpload(func(u *jobs.Upload) { u.Bytes, u.TotalBytes = bytes, total })
+		})
+		if err != nil {
+			log.Printf("Failed to push %s to %s: %v", upload.File, upload.Destination, err)
+			setUpload(func(u *jobs.Upload) { u.State, u.Error = "failed", err.Error() })
+			continue
+		}
+		setUpload(func(u *jobs.Upload) {
+			u.State = "completed"
+			if u.TotalBytes > 0 {
+				u.Bytes = u.TotalBytes
+			}
+		})
+		log.Printf("Pushed %s to %s", upload.File, upload.Destination)
+	}
+}
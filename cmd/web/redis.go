@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+)
+
+const (
+	// redisLease is how long a process's claim on a running job lasts
+	// without being renewed, and redisHeartbeat how often it is renewed
+	redisLease     = time.Minute
+	redisHeartbeat = 15 * time.Second
+	// redisKeep is how long finished jobs stay in Redis
+	redisKeep = 24 * time.Hour
+)
+
+// redisState is this process's part in sharing download jobs through a
+// queue kept in Redis
+type redisState struct {
+	// queue is nil when the queue is kept in memory
+	queue *jobs.RedisQueue
+	// owner names this process in its claims
+	owner string
+
+	mu sync.Mutex
+	// claimed are the jobs this process is running
+	claimed map[string]bool
+	// seen are the jobs whose records this process has read or written
+	seen map[string]bool
+}
+
+// startRedis loads the jobs kept in q, from before a restart or pushed by
+// other processes, and keeps them in sync with the local store until ctx
+// ends. It must run before the workers start, so they find the jobs they
+// take from the queue.
+func (s *server) startRedis(ctx context.Context, q *jobs.RedisQueue) {
+	if q == nil {
+		return
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "ute"
+	}
+	s.redis = redisState{
+		queue:   q,
+		owner:   fmt.Sprintf("%s:%d", host, os.Getpid()),
+		claimed: make(map[string]bool),
+		seen:    make(map[string]bool),
+	}
+	s.syncRedis(ctx)
+	log.Printf("Queueing jobs in Redis as %s", s.redis.owner)
+
+	go s.runRedis(ctx)
+}
+
+// runRedis syncs with Redis every heartbeat, and soon after local jobs
+// change so other processes see them quickly
+func (s *server) runRedis(ctx context.Context) {
+	sub := s.jobs.Subscribe(nil, 1, jobs.DropOldest)
+	defer sub.Close()
+	heartbeat := time.NewTicker(redisHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		throttle := time.NewTimer(clusterMinSync)
+		select {
+		case <-ctx.Done():
+			throttle.Stop()
+			return
+		case <-throttle.C:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+		case <-sub.C:
+		}
+		s.syncRedis(ctx)
+	}
+}
+
+func (r *redisState) isClaimed(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.claimed[id]
+}
+
+// redisClaim marks a download this process took from the queue as running
+// here, until redisFinish
+func (s *server) redisClaim(ctx context.Context, job jobs.Job) {
+	rs := &s.redis
+	if rs.queue == nil || job.Kind != jobs.KindDownload {
+		return
+	}
+	if err := rs.queue.Hold(ctx, job.ID, rs.owner, redisLease); err != nil {
+		log.Printf("Failed to claim job %s in Redis: %v", job.ID, err)
+	}
+	rs.mu.Lock()
+	rs.claimed[job.ID] = true
+	rs.mu.Unlock()
+}
+
+// redisFinish stores the outcome of a download this process ran and gives
+// up its claim
+func (s *server) redisFinish(ctx context.Context, id string) {
+	rs := &s.redis
+	if rs.queue == nil || !rs.isClaimed(id) {
+		return
+	}
+	if job, ok := s.jobs.Get(id); ok {
+		if err := rs.queue.SaveJob(ctx, job); err != nil {
+			log.Printf("Failed to store job %s in Redis: %v", id, err)
+		}
+	}
+	if err := rs.queue.Release(ctx, id); err != nil {
+		log.Printf("Failed to release job %s in Redis: %v", id, err)
+	}
+	rs.mu.Lock()
+	delete(rs.claimed, id)
+	rs.mu.Unlock()
+}
+
+// redisJob looks up a job another process pushed to the queue before this
+// one synced it, adding it to the local store
+func (s *server) redisJob(id string) (jobs.Job, bool) {
+	rs := &s.redis
+	if rs.queue == nil {
+		return jobs.Job{}, false
+	}
+	job, ok, err := rs.queue.Job(context.Background(), id)
+	if err != nil {
+		log.Printf("Failed to read job %s from Redis: %v", id, err)
+	}
+	if !ok {
+		return jobs.Job{}, false
+	}
+	s.jobs.Put(job)
+	rs.mu.Lock()
+	rs.seen[id] = true
+	rs.mu.Unlock()
+	return job, true
+}
+
+// redisCancel asks the process running the job with id to stop it,
+// reporting whether another process holds it
+func (s *server) redisCancel(id string) bool {
+	rs := &s.redis
+	if rs.queue == nil {
+		return false
+	}
+	ctx := context.Background()
+	owner, held, err := rs.queue.Holder(ctx, id)
+	if err != nil || !held || owner == rs.owner {
+		return false
+	}
+	if err := rs.queue.RequestCancel(ctx, id); err != nil {
+		log.Printf("Failed to ask %s to cancel job %s: %v", owner, id, err)
+		return false
+	}
+	return true
+}
+
+// syncRedis renews this process's claims and exchanges download jobs with
+// Redis, the newest version of each winning. Jobs whose process stopped
+// renewing its claim, such as after crashing, are queued again.
+func (s *server) syncRedis(ctx context.Context) {
+	rs := &s.redis
+	q := rs.queue
+
+	rs.mu.Lock()
+	claimed := make([]string, 0, len(rs.claimed))
+	for id := range rs.claimed {
+		claimed = append(claimed, id)
+	}
+	rs.mu.Unlock()
+	for _, id := range claimed {
+		err := q.Renew(ctx, id, rs.owner, redisLease)
+		if errors.Is(err, jobs.ErrNotHeld) {
+			log.Printf("Stopping job %s: %v", id, err)
+			rs.mu.Lock()
+			delete(rs.claimed, id)
+			rs.mu.Unlock()
+			s.running.cancel(id)
+			continue
+		} else if err != nil {
+			log.Printf("Failed to renew the claim on job %s: %v", id, err)
+		}
+		if cancel, err := q.CancelRequested(ctx, id); err == nil && cancel {
+			log.Printf("Canceling job %s at another process's request", id)
+			s.running.cancel(id)
+		}
+	}
+
+	records, err := q.Jobs(ctx)
+	if err != nil {
+		log.Printf("Failed to read jobs from Redis: %v", err)
+		return
+	}
+	stored := make(map[string]jobs.Job, len(records))
+	for _, job := range records {
+		stored[job.ID] = job
+	}
+
+	// Store jobs submitted or changed here
+	for _, job := range s.jobs.List() {
+		if job.Kind != jobs.KindDownload {
+			continue
+		}
+		prev, ok := stored[job.ID]
+		if ok && !job.UpdatedAt.After(prev.UpdatedAt) {
+			continue
+		}
+		rs.mu.Lock()
+		seen := rs.seen[job.ID]
+		rs.seen[job.ID] = true
+		rs.mu.Unlock()
+		// Finished jobs pruned from Redis stay that way
+		if !ok && seen {
+			continue
+		}
+		if err := q.SaveJob(ctx, job); err != nil {
+			log.Printf("Failed to store job %s in Redis: %v", job.ID, err)
+			continue
+		}
+		stored[job.ID] = job
+	}
+
+	pending := make(map[string]bool)
+	for _, id := range q.Pending() {
+		pending[id] = true
+	}
+	var requeue []jobs.Job
+	for id, job := range stored {
+		if rs.isClaimed(id) {
+			continue
+		}
+		if jobFinished(job.State) && time.Since(job.UpdatedAt) > redisKeep {
+			if err := q.DeleteJob(ctx, id); err != nil {
+				log.Printf("Failed to remove job %s from Redis: %v", id, err)
+			}
+			continue
+		}
+
+		// A queued job missing from the queue was taken by a process that
+		// stopped before claiming it
+		orphan := job.State == jobs.StateRunning || job.State == jobs.StateRetrying ||
+			(job.State == jobs.StateQueued && !pending[id] && time.Since(job.UpdatedAt) > redisLease)
+		if orphan {
+			if _, held, err := q.Holder(ctx, id); err == nil && !held {
+				if ok, err := q.Claim(ctx, id, rs.owner, redisLease); err == nil && ok {
+					log.Printf("Rescheduling job %s, whose process stopped renewing its claim", id)
+					job.State = jobs.StateQueued
+					job.Transfer = nil
+					job.UpdatedAt = time.Now()
+					if err := q.SaveJob(ctx, job); err != nil {
+						log.Printf("Failed to store job %s in Redis: %v", id, err)
+					} else {
+						requeue = append(requeue, job)
+					}
+					q.Release(ctx, id)
+				}
+			}
+		}
+
+		local, known := s.jobs.Get(id)
+		if known && !job.UpdatedAt.After(local.UpdatedAt) {
+			continue
+		}
+		rs.mu.Lock()
+		rs.seen[id] = true
+		rs.mu.Unlock()
+		s.jobs.Put(job)
+	}
+
+	// Queued after they are in the store, where workers look them up
+	for _, job := range requeue {
+		q.Push(job.ID, job.Priority)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/config"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/settings"
+)
+
+// configReload reads the config file again while the server runs
+type configReload struct {
+	path string
+
+	mu sync.Mutex
+	// last is the config as last read. s.cfg keeps the one the server
+	// started with, as it is read without locking.
+	last config.Config
+}
+
+// reloadResult is what a reload changed
+type reloadResult struct {
+	// Applied are the changed settings now in effect
+	Applied []string `json:"applied"`
+	// Overridden are changed settings that stay as saved through
+	// /api/settings, which take precedence over the config file
+	Overridden []string `json:"overridden"`
+	// Restart are the changed config sections that only take effect once
+	// the server restarts
+	Restart []string `json:"restart"`
+}
+
+// reloadConfig reads the config file again and applies the settings that
+// can change while the server runs: the worker count, the default format,
+// retention, webhooks and the per-site backoff. Running downloads carry on;
+// workers beyond a lowered count retire once their download finishes.
+func (s *server) reloadConfig() (reloadResult, error) {
+	rl := &s.reload
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := config.Load(rl.path)
+	if err != nil {
+		return reloadResult{}, err
+	}
+	prev := rl.last
+
+	if err := s.settings.Reload(settings.FromConfig(next)); err != nil {
+		return reloadResult{}, err
+	}
+	current := s.settings.Get()
+	s.resizeWorkers(current.Workers)
+	s.breakers.Configure(next.Backoff.Failures, time.Duration(next.Backoff.Cooldown), time.Duration(next.Backoff.MaxCooldown))
+
+	result := reloadResult{Applied: []string{}, Overridden: []string{}}
+	note := func(name string, changed, effective bool) {
+		switch {
+		case !changed:
+		case effective:
+			result.Applied = append(result.Applied, name)
+		default:
+			result.Overridden = append(result.Overridden, name)
+		}
+	}
+	note("queue.workers", prev.Queue.Workers != next.Queue.Workers,
+		current.Workers == next.Queue.Workers)
+	note("ytdlp.default_format", prev.YtDlp.DefaultFormat != next.YtDlp.DefaultFormat,
+		current.DefaultFormat == next.YtDlp.DefaultFormat)
+	note("retention", prev.Retention != next.Retention,
+		current.Retention == next.Retention)
+	note("notifications.webhooks", !slices.Equal(prev.Notifications.Webhooks, next.Notifications.Webhooks),
+		slices.Equal(current.Webhooks, next.Notifications.Webhooks))
+	note("backoff", prev.Backoff != next.Backoff, true)
+
+	// Whatever else differs from what the server started with waits for a
+	// restart
+	rest := s.cfg
+	rest.Queue.Workers = next.Queue.Workers
+	rest.YtDlp.DefaultFormat = next.YtDlp.DefaultFormat
+	rest.Retention = next.Retention
+	rest.Notifications.Webhooks = next.Notifications.Webhooks
+	rest.Backoff = next.Backoff
+	result.Restart = changedSections(rest, next)
+
+	rl.last = next
+	log.Printf("Reloaded %s: applied [%s], overridden by saved settings [%s], restart needed for [%s]",
+		rl.path, strings.Join(result.Applied, ", "), strings.Join(result.Overridden, ", "), strings.Join(result.Restart, ", "))
+	return result, nil
+}
+
+// changedSections lists the top-level config keys whose values differ
+// between prev and next
+func changedSections(prev, next config.Config) []string {
+	var before, after map[string]json.RawMessage
+	a, _ := json.Marshal(prev)
+	b, _ := json.Marshal(next)
+	json.Unmarshal(a, &before)
+	json.Unmarshal(b, &after)
+
+	changed := []string{}
+	for key, value := range after {
+		if !bytes.Equal(before[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// reloadOnHangup reloads the config each time the process gets SIGHUP
+func (s *server) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := s.reloadConfig(); err != nil {
+				log.Printf("Failed to reload %s: %v", s.reload.path, err)
+			}
+		}
+	}()
+}
+
+// handleReload serves POST /api/system/reload, reloading the config file
+func (s *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	result, err := s.reloadConfig()
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Failed to reload the config file",
+			Details: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		})
+		return
+	}
+	s.record(r, audit.ActionConfigReload, "", map[string]string{
+		"applied":    strings.Join(result.Applied, ","),
+		"overridden": strings.Join(result.Overridden, ","),
+		"restart":    strings.Join(result.Restart, ","),
+	})
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/i18n"
+	"noahjalex.ute/internal/language"
+	"noahjalex.ute/internal/timefmt"
+)
+
+// views holds the server-rendered pages. Each page is parsed together with
+// layout.html, which wraps the page's "content" block, so a page can also
+// be rendered as just one of its fragments for HTMX requests. Pages are
+// parsed once per interface language, with their "t" function translating
+// into it.
+type views struct {
+	// pages are by language, then name
+	pages map[string]map[string]*template.Template
+	errs  *errorPages
+}
+
+// templateFuncs are available to every page
+var templateFuncs = template.FuncMap{
+	"formatSize":     formatSize,
+	"formatDuration": formatDuration,
+	"percent":        percent,
+	"pathEscape":     escapePath,
+	"pathSegment":    url.PathEscape,
+	"ago":            ago,
+	"languageName":   language.Name,
+}
+
+// dateFuncs write times in the configured zone and locale
+func dateFuncs(dates *timefmt.Formatter) template.FuncMap {
+	return template.FuncMap{
+		"formatDate":      dates.Date,
+		"formatShortDate": dates.ShortDate,
+		"formatClock":     dates.Clock,
+		"formatDateTime":  dates.DateTime,
+	}
+}
+
+// translateFuncs translate page text into lang
+func translateFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t":    func(text string) string { return i18n.Translate(lang, text) },
+		"lang": func() string { return lang },
+	}
+}
+
+// loadViews parses every page in dir, writing dates with dates. Pages that
+// fail to parse are logged and answered with a 500 page, leaving the rest
+// of the server running.
+func loadViews(dir string, errs *errorPages, dates *timefmt.Formatter) *views {
+	v := &views{pages: make(map[string]map[string]*template.Template), errs: errs}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		log.Printf("Warning: HTML pages disabled: %v", err)
+		return v
+	}
+	for _, lang := range i18n.Languages() {
+		base, err := template.New("layout.html").Funcs(templateFuncs).Funcs(dateFuncs(dates)).
+			Funcs(translateFuncs(lang)).ParseFiles(filepath.Join(dir, "layout.html"))
+		if err != nil {
+			log.Printf("Warning: HTML pages disabled: %v", err)
+			return v
+		}
+
+		pages := make(map[string]*template.Template)
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".html")
+			if name == "layout" || name == "error" {
+				continue
+			}
+			page, err := base.Clone()
+			if err == nil {
+				_, err = page.ParseFiles(file)
+			}
+			if err != nil {
+				// Every language fails alike, so only the first says so
+				if lang == i18n.Default {
+					log.Printf("Warning: page %s disabled: %v", name, err)
+				}
+				continue
+			}
+			pages[name] = page
+		}
+		v.pages[lang] = pages
+	}
+	return v
+}
+
+// responseLanguage is the interface language negotiateLanguage chose for
+// the response being written to w
+func responseLanguage(w http.ResponseWriter) string {
+	if lang := w.Header().Get("Content-Language"); i18n.Supported(lang) {
+		return lang
+	}
+	return i18n.Default
+}
+
+// isHTMX reports whether r was made by htmx to swap part of the page.
+// Boosted requests replace the whole body, so they get the full page.
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-Boosted") != "true"
+}
+
+// render writes page with data. HTMX requests get only the named fragment,
+// everything else the page inside the layout.
+func (v *views) render(w http.ResponseWriter, r *http.Request, page, fragment string, data interface{}) {
+	tmpl, ok := v.pages[responseLanguage(w)][page]
+	if !ok {
+		v.errs.write(w, r, http.StatusInternalServerError, "This page is unavailable. Check the server log for template errors.")
+		return
+	}
+
+	name := "layout"
+	if isHTMX(r) {
+		name = fragment
+	}
+
+	// Render into a buffer so a template error still gets a clean error page
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("Error rendering %s/%s: %v", page, name, err)
+		v.errs.write(w, r, http.StatusInternalServerError, "This page failed to render.")
+		return
+	}
+
+	// Caches must not hand a fragment to a full page load, or the reverse
+	w.Header().Add("Vary", "HX-Request")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// fragment renders just the named template of page in lang, for responses
+// that aren't a plain page load such as event streams
+func (v *views) fragment(lang, page, name string, data interface{}) ([]byte, error) {
+	tmpl, ok := v.pages[lang][page]
+	if !ok {
+		return nil, fmt.Errorf("page %s is unavailable", page)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatSize renders a byte count with a binary unit
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDuration renders seconds as h:mm:ss or m:ss
+func formatDuration(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	d := time.Duration(seconds) * time.Second
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// percent returns done as a whole percentage of total, 0 when total is
+// unknown. Both may be any integer type.
+func percent(done, total interface{}) int {
+	d, t := toInt64(done), toInt64(total)
+	if t <= 0 {
+		return 0
+	}
+	return int(min(100, d*100/t))
+}
+
+func toInt64(n interface{}) int64 {
+	switch n := n.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	}
+	return 0
+}
+
+// escapePath escapes each segment of a slash-separated path for a URL
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// ago renders how long ago t was, to the coarsest sensible unit
+func ago(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"noahjalex.ute/internal/jobs"
+)
+
+// startRescan runs an incremental library rescan as a background job. If a
+// rescan is already running its job is returned instead.
+func (s *server) startRescan() jobs.Job {
+	s.maint.mu.Lock()
+	defer s.maint.mu.Unlock()
+
+	if s.maint.rescanJob != "" {
+		if job, ok := s.jobs.Get(s.maint.rescanJob); ok {
+			return job
+		}
+	}
+
+	job := s.jobs.CreateTask(jobs.KindRescan)
+	s.maint.rescanJob = job.ID
+
+	go func() {
+		result, err := s.videos.Rescan(func(done, total int) {
+			s.jobs.Update(job.ID, func(j *jobs.Job) {
+				j.Progress = &jobs.Progress{Done: done, Total: total}
+			})
+		})
+
+		s.finishTask(job.ID, result, err)
+		if err != nil {
+			log.Printf("Library rescan failed: %v", err)
+		} else {
+			log.Printf("Library rescan: %d added, %d updated, %d unchanged, %d missing",
+				result.Added, result.Updated, result.Unchanged, result.Missing)
+		}
+
+		s.maint.mu.Lock()
+		s.maint.rescanJob = ""
+		s.maint.mu.Unlock()
+	}()
+	return job
+}
+
+// handleRescan serves POST /api/library/rescan, returning the job that
+// tracks the rescan's progress
+func (s *server) handleRescan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	job := s.startRescan()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Library rescan started",
+		JobID:   job.ID,
+	})
+}
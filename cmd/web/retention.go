@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// retentionInterval is how often the retention policy is applied
+const retentionInterval = time.Hour
+
+// applyRetention deletes videos added longer ago than the retention
+// policy's max age, only those marked watched when it says so, and each
+// subscription's videos beyond its keep_latest
+func (s *server) applyRetention(ctx context.Context) {
+	for _, sub := range s.subs.List() {
+		s.trimSubscription(ctx, sub)
+	}
+
+	policy := s.settings.Get().Retention
+	if policy.MaxAge <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(policy.MaxAge))
+	deleted, freed := 0, int64(0)
+	for _, v := range s.videos.List() {
+		if v.AddedAt.After(cutoff) || (policy.WatchedOnly && v.WatchedAt == nil) {
+			continue
+		}
+		n, err := s.deleteVideo(ctx, v)
+		if err != nil {
+			log.Printf("Retention: failed to delete %s: %v", v.FilePath, err)
+			continue
+		}
+		deleted++
+		freed += n
+	}
+	if deleted > 0 {
+		log.Printf("Retention: deleted %d videos older than %v, freeing %s", deleted, policy.MaxAge, formatSize(freed))
+	}
+}
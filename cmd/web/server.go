@@ -0,0 +1,119 @@
+package main
+
+import (
+	"time"
+
+	"noahjalex.ute/internal/accesslog"
+	"noahjalex.ute/internal/alerts"
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/budget"
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/cookies"
+	"noahjalex.ute/internal/downloader"
+	"noahjalex.ute/internal/enrich"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/history"
+	"noahjalex.ute/internal/hooks"
+	"noahjalex.ute/internal/joblog"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/lists"
+	"noahjalex.ute/internal/mqtt"
+	"noahjalex.ute/internal/playback"
+	"noahjalex.ute/internal/postprocess"
+	"noahjalex.ute/internal/rclone"
+	"noahjalex.ute/internal/scheduler"
+	"noahjalex.ute/internal/settings"
+	"noahjalex.ute/internal/storage"
+	"noahjalex.ute/internal/subscriptions"
+	"noahjalex.ute/internal/throughput"
+	"noahjalex.ute/internal/timefmt"
+	"noahjalex.ute/internal/tracing"
+	"noahjalex.ute/internal/transcribe"
+	"noahjalex.ute/internal/usage"
+	"noahjalex.ute/internal/websub"
+	"noahjalex.ute/internal/ytdlp"
+)
+
+// server holds the state shared by HTTP handlers and download workers
+type server struct {
+	cfg config.Config
+	// settings are the parts of cfg that can change at runtime
+	settings    *settings.Store
+	ytdlp       *ytdlp.Manager
+	downloaders *downloader.Registry
+	// managementRoutes are the patterns registered with handleAdmin
+	managementRoutes []string
+	jobs             *jobs.Store
+	queue            jobs.Queue
+	hooks            *hooks.Runner
+	// notifiers pass finished jobs, digests and alerts on
+	notifiers []hooks.Notifier
+	// digest collects finished downloads for the notification digest
+	digest *hooks.Digester
+	videos *library.VideoService
+	subs   *subscriptions.Store
+	// usage counts what each user downloads, for their quotas
+	usage *usage.Store
+	// history keeps daily download totals for trend graphs
+	history *history.Store
+	// tracer is nil when tracing is off
+	tracer *tracing.Tracer
+	// lists are each user's favorites and watch-later queue
+	lists *lists.Store
+	// playback holds where each user stopped in each video
+	playback *playback.Store
+	// storage is nil when files are kept locally
+	storage storage.Backend
+	rclone  *rclone.Client
+	// transcriber is nil when transcription is off
+	transcriber transcribe.Backend
+	// enricher is nil when enrichment is off
+	enricher enrich.Enricher
+	// events carries download and library events to hooks, webhooks and
+	// metrics
+	events  *events.Bus
+	metrics *events.Counter
+	// mqtt publishes downloads and stats to a broker; nil when off
+	mqtt *mqtt.Client
+	// alerts watch for failing downloads and low disk space
+	alerts *alerts.Monitor
+	// bandwidth measures download speeds
+	bandwidth *throughput.Tracker
+	// jobLogs keep what each job's backend printed
+	jobLogs *joblog.Store
+	// cookies sign downloads in to sites that need an account
+	cookies *cookies.Store
+	// breakers hold downloads from sites that are refusing them
+	breakers *jobs.Breakers
+	// budget counts the month's downloads against the data budget
+	budget *budget.Counter
+	// cluster shares jobs with other servers when configured
+	cluster clusterState
+	// redis shares jobs with other processes when the queue is in Redis
+	redis redisState
+	// agents hands downloads to remote agents
+	agents agentHub
+	// postProcessors run after each download
+	postProcessors *postprocess.Registry
+	// tasks runs the scheduled background tasks
+	tasks *scheduler.Scheduler
+	// audit is nil when the audit log couldn't be opened
+	audit    *audit.Log
+	maint    maintenance
+	running  runningJobs
+	probes   probeCache
+	pool     workerPool
+	errPages *errorPages
+	views    *views
+	// reload reads the config file again on request
+	reload configReload
+	// started is when the server started, for its uptime
+	started time.Time
+	// dates writes times in the configured zone and locale
+	dates *timefmt.Formatter
+	// websub pushes library changes to subscribed callback URLs
+	websub *websub.Hub
+	// accessLog is nil unless access_log.path is set
+	accessLog *accesslog.Log
+}
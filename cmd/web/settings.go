@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/config"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/settings"
+)
+
+// applySettings saves next and puts it into effect, recording the change
+// made through r
+func (s *server) applySettings(r *http.Request, next settings.Settings) error {
+	prev := s.settings.Get()
+	if err := s.settings.Set(next); err != nil {
+		return err
+	}
+	if changed := changedSettings(prev, next); len(changed) > 0 {
+		s.record(r, audit.ActionSettings, "", changed)
+	}
+	s.resizeWorkers(next.Workers)
+	log.Printf("Settings updated: %d workers, default format %q, retention %v, %d webhooks",
+		next.Workers, next.DefaultFormat, next.Retention.MaxAge, len(next.Webhooks))
+	return nil
+}
+
+// handleSettings serves GET and PUT /api/settings. A PUT body may hold
+// only the settings being changed; the rest keep their current values.
+func (s *server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.settings.Get())
+
+	case "PUT":
+		next := s.settings.Get()
+		if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid JSON in request body",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		if err := next.Validate(); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid settings",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		if err := s.applySettings(r, next); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to save settings",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(s.settings.Get())
+
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// changedSettings lists the top-level settings that differ between prev
+// and next with their new values, as JSON. Webhook URLs can carry tokens,
+// so only their count is kept.
+func changedSettings(prev, next settings.Settings) map[string]string {
+	var before, after map[string]json.RawMessage
+	a, _ := json.Marshal(prev)
+	b, _ := json.Marshal(next)
+	json.Unmarshal(a, &before)
+	json.Unmarshal(b, &after)
+
+	changed := make(map[string]string)
+	for key, value := range after {
+		if !bytes.Equal(before[key], value) {
+			changed[key] = string(value)
+		}
+	}
+	if _, ok := changed["webhooks"]; ok {
+		changed["webhooks"] = fmt.Sprintf("%d URLs", len(next.Webhooks))
+	}
+	return changed
+}
+
+// settingsPage is the data for the settings page
+type settingsPage struct {
+	Settings   settings.Settings
+	MaxWorkers int
+	Saved      bool
+	Error      string
+}
+
+// handleSettingsPage serves GET /settings and saves the form POSTed to it,
+// answering HTMX with the re-rendered "settings-form" fragment
+func (s *server) handleSettingsPage(w http.ResponseWriter, r *http.Request) {
+	data := settingsPage{Settings: s.settings.Get(), MaxWorkers: settings.MaxWorkers}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		next, err := settingsFromForm(r, data.Settings)
+		if err == nil {
+			err = s.applySettings(r, next)
+		}
+		if err != nil {
+			// Show what was submitted so it can be corrected. The status
+			// stays 200 as htmx only swaps in successful responses.
+			data.Settings, data.Error = next, err.Error()
+		} else {
+			data.Settings, data.Saved = s.settings.Get(), true
+		}
+	default:
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET and POST.")
+		return
+	}
+
+	s.views.render(w, r, "settings", "settings-form", data)
+}
+
+// settingsFromForm reads the settings form over current
+func settingsFromForm(r *http.Request, current settings.Settings) (settings.Settings, error) {
+	next := current
+	if err := r.ParseForm(); err != nil {
+		return next, err
+	}
+
+	next.DefaultFormat = strings.TrimSpace(r.PostFormValue("default_format"))
+	next.Retention.WatchedOnly = r.PostFormValue("retention_watched_only") != ""
+	next.Webhooks = nil
+	for _, line := range strings.Split(r.PostFormValue("webhooks"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			next.Webhooks = append(next.Webhooks, line)
+		}
+	}
+
+	workers, err := strconv.Atoi(strings.TrimSpace(r.PostFormValue("workers")))
+	if err != nil {
+		return next, errors.New("workers must be a number")
+	}
+	next.Workers = workers
+
+	next.Retention.MaxAge = 0
+	if maxAge := strings.TrimSpace(r.PostFormValue("retention_max_age")); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return next, errors.New("retention must be a duration like 720h")
+		}
+		next.Retention.MaxAge = config.Duration(d)
+	}
+	return next, next.Validate()
+}
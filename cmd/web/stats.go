@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+// handleStats serves GET /api/stats with library totals and breakdowns,
+// download outcomes, event counts and download speeds since startup and
+// free disk space
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	byState := map[jobs.State]int{}
+	for _, job := range s.jobs.List() {
+		byState[job.State]++
+	}
+	downloads := map[string]interface{}{
+		"by_state":     byState,
+		"success_rate": nil,
+	}
+	if finished := byState[jobs.StateCompleted] + byState[jobs.StateFailed] + byState[jobs.StateNeedsAuth]; finished > 0 {
+		downloads["success_rate"] = float64(byState[jobs.StateCompleted]) / float64(finished)
+	}
+
+	disk := map[string]interface{}{}
+	if free, total, err := library.DiskUsage(s.cfg.VideosDir); err != nil {
+		log.Printf("Failed to read disk usage for %s: %v", s.cfg.VideosDir, err)
+	} else {
+		disk["free_bytes"] = free
+		disk["total_bytes"] = total
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"library":   s.videos.Stats(),
+		"downloads": downloads,
+		"events":    s.metrics.Counts(),
+		"bandwidth": s.bandwidth.Total(),
+		"disk":      disk,
+	})
+}
+
+const (
+	// bandwidthHistory is how far back GET /api/bandwidth can look
+	bandwidthHistory = time.Hour
+	// bandwidthWindow and bandwidthStep are its defaults
+	bandwidthWindow = 15 * time.Minute
+	bandwidthStep   = 10 * time.Second
+)
+
+// handleBandwidth serves GET /api/bandwidth, the server's download speed
+// over time for graphs: ?window= (default 15m, at most an hour) split into
+// ?step= (default 10s) averages, oldest first, with the current totals
+func (s *server) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	window, step := bandwidthWindow, bandwidthStep
+	for name, dst := range map[string]*time.Duration{"window": &window, "step": &step} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid " + name,
+				Details: name + " must be a duration of at least 1s, such as 30s or 5m",
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		*dst = d
+	}
+	if step > window {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "step must not be longer than window",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	window = min(window, bandwidthHistory)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"step_seconds": int(step / time.Second),
+		"samples":      s.bandwidth.History(window, step),
+		"total":        s.bandwidth.Total(),
+	})
+}
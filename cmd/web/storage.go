@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/storage"
+)
+
+// uploadToStorage moves a video and its sidecar files to the remote
+// backend and records the remote location in the library
+func (s *server) uploadToStorage(ctx context.Context, v library.Video) error {
+	files, err := library.RelatedFiles(s.videos.AbsPath(v))
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, file := range files {
+		rel, err := filepath.Rel(s.videos.Root(v), file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if err := putFile(ctx, s.storage, file, key); err != nil {
+			return fmt.Errorf("uploading %s: %w", rel, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := s.videos.Update(v.ID, func(video *library.Video) {
+		video.Storage = s.storage.Name()
+		video.RemoteKey = v.FilePath
+		video.RemoteFiles = keys
+	}); err != nil {
+		return err
+	}
+	log.Printf("Uploaded %s to %s storage", v.FilePath, s.storage.Name())
+
+	if s.cfg.Storage.KeepLocal {
+		return nil
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			log.Printf("Failed to remove local copy %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func putFile(ctx context.Context, backend storage.Backend, path, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return backend.Put(ctx, key, f, fi.Size())
+}
+
+// serveRemote streams or redirects to a video held on the storage backend
+func (s *server) serveRemote(w http.ResponseWriter, r *http.Request, v library.Video) {
+	if s.storage == nil || s.storage.Name() != v.Storage {
+		log.Printf("Video %s is on %s storage which is not configured", v.ID, v.Storage)
+		s.errPages.write(w, r, http.StatusServiceUnavailable, "The storage backend holding this file is unavailable.")
+		return
+	}
+
+	if s.cfg.Storage.Serve == "redirect" {
+		target, err := s.storage.PresignGet(v.RemoteKey, time.Duration(s.cfg.Storage.PresignTTL))
+		if err == nil {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		if !errors.Is(err, storage.ErrPresignUnsupported) {
+			log.Printf("Failed to presign %s: %v", v.RemoteKey, err)
+			s.errPages.write(w, r, http.StatusInternalServerError, "Something went wrong while serving this file.")
+			return
+		}
+		// Fall through to proxying for backends without presigned URLs
+	}
+
+	resp, err := s.storage.Get(r.Context(), v.RemoteKey, r.Header)
+	if err != nil {
+		log.Printf("Failed to fetch %s from %s: %v", v.RemoteKey, v.Storage, err)
+		s.errPages.write(w, r, http.StatusBadGateway, "The file couldn't be fetched from storage.")
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"} {
+		if value := resp.Header.Get(h); value != "" {
+			w.Header().Set(h, value)
+		}
+	}
+	w.Header().Set("Content-Disposition", attachment(v.Filename()))
+	setCacheControl(w, s.cfg.Cache.Videos)
+	w.WriteHeader(resp.StatusCode)
+
+	log.Printf("Proxying %s from %s storage", v.RemoteKey, v.Storage)
+	io.Copy(w, resp.Body)
+}
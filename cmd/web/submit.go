@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/tracing"
+)
+
+// validIdempotencyKey reports whether key may be sent as the
+// Idempotency-Key header of a download submission. An empty key is valid
+// and means the request has none.
+func validIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// writeExistingJob answers a repeated download submission with the job
+// the first one created
+func writeExistingJob(w http.ResponseWriter, job jobs.Job) {
+	log.Printf("Returning existing job %s for repeated submission of %s", job.ID, job.URL)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Video download already queued",
+		JobID:   job.ID,
+	})
+}
+
+// writeSubmitError reports why the jobs store refused a submission
+func writeSubmitError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, jobs.ErrKeyReused) {
+		code = http.StatusUnprocessableEntity
+	}
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypeValidation,
+		Message: "Download could not be queued",
+		Details: err.Error(),
+		Code:    code,
+	})
+}
+
+// submitDownload creates and queues a job for sub, writing an error and
+// returning ok false when it can't. created is false when a repeated
+// request matched the job the first one created. Shorts and other
+// alternative links to a video are queued as its canonical URL.
+func (s *server) submitDownload(w http.ResponseWriter, r *http.Request, sub jobs.Submission) (job jobs.Job, created bool, quota *quotaStatus, ok bool) {
+	sub.URL = downloader.CanonicalURL(sub.URL)
+
+	// A repeated request gets the job the first one created, without
+	// counting against the quota again
+	existing, found, err := s.jobs.Existing(sub)
+	if err != nil {
+		writeSubmitError(w, err)
+		return jobs.Job{}, false, nil, false
+	}
+	if found {
+		return existing, false, nil, true
+	}
+
+	quota, quotaErr := s.checkQuota(r)
+	if quotaErr != nil {
+		writeQuotaError(w, quota, quotaErr)
+		return jobs.Job{}, false, nil, false
+	}
+
+	// The download's spans join the request's trace
+	ctx, span := s.tracer.Start(r.Context(), "queue push", tracing.KindProducer)
+	defer span.End()
+	sub.Trace = tracing.Traceparent(ctx)
+	job, created, err = s.jobs.Submit(sub)
+	if err != nil {
+		span.SetError(err)
+		writeSubmitError(w, err)
+		return jobs.Job{}, false, nil, false
+	}
+	span.SetAttr("job.id", job.ID)
+	if !created {
+		return job, false, quota, true
+	}
+	s.queue.Push(job.ID, sub.Priority)
+	s.estimateJob(job)
+	log.Printf("Queued job %s for URL %s with %s priority", job.ID, sub.URL, sub.Priority)
+	s.record(r, audit.ActionDownload, sub.URL, map[string]string{"job_id": job.ID})
+	return job, true, quota, true
+}
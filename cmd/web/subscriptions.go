@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/language"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/subscriptions"
+)
+
+// archivePath is yt-dlp's record of videos fetched by subscription checks
+func (s *server) archivePath() string {
+	return filepath.Join(s.cfg.DataDir, "archive.txt")
+}
+
+// checkSubscription queues a low priority download of the subscription's
+// newest uploads. A check still queued or running is returned instead of
+// starting another.
+func (s *server) checkSubscription(sub subscriptions.Subscription) jobs.Job {
+	if job, ok := s.jobs.Get(sub.LastJob); ok {
+		switch job.State {
+		case jobs.StateQueued, jobs.StateRunning, jobs.StateRetrying:
+			return job
+		}
+	}
+
+	job := s.jobs.Create(sub.URL, nil, sub.Folder, jobs.PriorityLow)
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.Subscription, j.Profile = sub.ID, sub.Profile })
+	job.Subscription, job.Profile = sub.ID, sub.Profile
+	s.queue.Push(job.ID, jobs.PriorityLow)
+
+	now := time.Now()
+	err := s.subs.Update(sub.ID, func(sub *subscriptions.Subscription) {
+		sub.LastChecked = &now
+		sub.LastJob = job.ID
+	})
+	if err != nil {
+		log.Printf("Failed to save subscription %s: %v", sub.ID, err)
+	}
+	log.Printf("Queued job %s checking subscription %s", job.ID, sub.Name)
+	return job
+}
+
+// backfillSubscription queues a low priority download of the
+// subscription's existing uploads within limits, separate from its checks
+// for new ones. Uploads already fetched are skipped, and those fetched
+// are recorded so checks don't fetch them again. A backfill still queued
+// or running is returned instead of starting another.
+func (s *server) backfillSubscription(sub subscriptions.Subscription, limits *jobs.Limits) jobs.Job {
+	if job, ok := s.jobs.Get(sub.BackfillJob); ok {
+		switch job.State {
+		case jobs.StateQueued, jobs.StateRunning, jobs.StateRetrying:
+			return job
+		}
+	}
+	if limits.Empty() {
+		limits = nil
+	}
+
+	job := s.jobs.Create(sub.URL, nil, sub.Folder, jobs.PriorityLow)
+	s.jobs.Update(job.ID, func(j *jobs.Job) {
+		j.Subscription, j.Profile, j.Backfill, j.Limits = sub.ID, sub.Profile, true, limits
+	})
+	job.Subscription, job.Profile, job.Backfill, job.Limits = sub.ID, sub.Profile, true, limits
+	s.queue.Push(job.ID, jobs.PriorityLow)
+
+	err := s.subs.Update(sub.ID, func(sub *subscriptions.Subscription) { sub.BackfillJob = job.ID })
+	if err != nil {
+		log.Printf("Failed to save subscription %s: %v", sub.ID, err)
+	}
+	log.Printf("Queued job %s backfilling subscription %s", job.ID, sub.Name)
+	return job
+}
+
+// tagSubscription marks the videos with ids as downloaded for the
+// subscription with subID, for its retention
+func (s *server) tagSubscription(subID string, ids []string) {
+	for _, id := range ids {
+		if err := s.videos.Update(id, func(v *library.Video) { v.Subscription = subID }); err != nil {
+			log.Printf("Failed to record the subscription of %s: %v", id, err)
+		}
+	}
+}
+
+// trimSubscription deletes the subscription's oldest videos beyond its
+// keep_latest, by upload date
+func (s *server) trimSubscription(ctx context.Context, sub subscriptions.Subscription) {
+	if sub.KeepLatest <= 0 {
+		return
+	}
+	var videos []library.Video
+	for _, v := range s.videos.List() {
+		if v.Subscription == sub.ID {
+			videos = append(videos, v)
+		}
+	}
+	if len(videos) <= sub.KeepLatest {
+		return
+	}
+	// Newest first; videos without an upload date go by when they were
+	// downloaded
+	released := func(v library.Video) time.Time {
+		if v.UploadedAt != nil {
+			return *v.UploadedAt
+		}
+		return v.DownloadedAt
+	}
+	sort.SliceStable(videos, func(i, j int) bool { return released(videos[i]).After(released(videos[j])) })
+
+	deleted, freed := 0, int64(0)
+	for _, v := range videos[sub.KeepLatest:] {
+		n, err := s.deleteVideo(ctx, v)
+		if err != nil {
+			log.Printf("Retention: failed to delete %s: %v", v.FilePath, err)
+			continue
+		}
+		deleted++
+		freed += n
+	}
+	if deleted > 0 {
+		log.Printf("Retention: deleted %d videos of subscription %s beyond the latest %d, freeing %s", deleted, sub.Name, sub.KeepLatest, formatSize(freed))
+	}
+}
+
+// validateSubscriptionSettings checks the settings a subscription's
+// downloads use, each left out when nil
+func (s *server) validateSubscriptionSettings(outputTemplate, profile *string, keepLatest *int) error {
+	if outputTemplate != nil && *outputTemplate != "" {
+		tmpl := filepath.ToSlash(*outputTemplate)
+		if filepath.IsAbs(*outputTemplate) || strings.HasPrefix(tmpl, "/") ||
+			slices.Contains(strings.Split(tmpl, "/"), "..") {
+			return fmt.Errorf("output_template must stay inside the videos directory")
+		}
+		if !strings.Contains(tmpl, "%(ext)s") {
+			return fmt.Errorf("output_template must end the file name with %%(ext)s")
+		}
+	}
+	if profile != nil && *profile != "" {
+		if _, ok := s.cfg.PostProcess.Profiles[*profile]; !ok {
+			return fmt.Errorf("%q is not in postprocess.profiles", *profile)
+		}
+	}
+	if keepLatest != nil && *keepLatest < 0 {
+		return fmt.Errorf("keep_latest can't be negative")
+	}
+	return nil
+}
+
+// decodeLimits reads the limits of a backfill, writing an error and
+// returning ok false when they're invalid. An empty body has none.
+func decodeLimits(w http.ResponseWriter, data []byte) (limits *jobs.Limits, ok bool) {
+	var err error
+	if len(strings.TrimSpace(string(data))) > 0 {
+		err = json.Unmarshal(data, &limits)
+	}
+	if err == nil {
+		err = limits.Validate()
+	}
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid backfill limits",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return nil, false
+	}
+	return limits, true
+}
+
+// handleSubscriptions serves GET /api/subscriptions
+func (s *server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.subs.List())
+}
+
+// handleSubscription serves GET, PATCH and DELETE /api/subscriptions/{id}.
+// PATCH takes {"languages": ["en", "de"]} to only download uploads in those
+// languages, or an empty list for all of them, and a "filter" choosing
+// uploads by title, duration, date and kind, or null for none. Fields left
+// out are kept. Unsubscribing keeps the videos already downloaded.
+func (s *server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case "GET":
+		sub, ok := s.subs.Get(id)
+		if !ok {
+			writeSubscriptionNotFound(w)
+			return
+		}
+		json.NewEncoder(w).Encode(sub)
+
+	case "PATCH":
+		var body struct {
+			Languages      *[]string       `json:"languages"`
+			Filter         json.RawMessage `json:"filter"`
+			OutputTemplate *string         `json:"output_template"`
+			Format         *string         `json:"format"`
+			Profile        *string         `json:"profile"`
+			KeepLatest     *int            `json:"keep_latest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid JSON in request body",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		var filter *downloader.Filter
+		if body.Filter != nil {
+			var ok bool
+			if filter, ok = decodeFilter(w, body.Filter); !ok {
+				return
+			}
+		}
+		if err := s.validateSubscriptionSettings(body.OutputTemplate, body.Profile, body.KeepLatest); err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid subscription settings",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		details := map[string]string{}
+		err := s.subs.Update(id, func(sub *subscriptions.Subscription) {
+			if body.Languages != nil {
+				sub.Languages = normalizeLanguages(*body.Languages)
+				details["languages"] = strings.Join(sub.Languages, ",")
+			}
+			if body.Filter != nil {
+				sub.Filter = filter
+				details["filter"] = string(body.Filter)
+			}
+			if body.OutputTemplate != nil {
+				sub.OutputTemplate = *body.OutputTemplate
+				details["output_template"] = sub.OutputTemplate
+			}
+			if body.Format != nil {
+				sub.Format = *body.Format
+				details["format"] = sub.Format
+			}
+			if body.Profile != nil {
+				sub.Profile = *body.Profile
+				details["profile"] = sub.Profile
+			}
+			if body.KeepLatest != nil {
+				sub.KeepLatest = *body.KeepLatest
+				details["keep_latest"] = strconv.Itoa(sub.KeepLatest)
+			}
+		})
+		if err != nil {
+			if _, ok := s.subs.Get(id); !ok {
+				writeSubscriptionNotFound(w)
+				return
+			}
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to save subscriptions",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		sub, _ := s.subs.Get(id)
+		s.record(r, audit.ActionSubscriptionUpdate, sub.ID, details)
+		json.NewEncoder(w).Encode(sub)
+
+	case "DELETE":
+		if _, ok := s.subs.Get(id); !ok {
+			writeSubscriptionNotFound(w)
+			return
+		}
+		sub, err := s.subs.Remove(id)
+		if err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to save subscriptions",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		log.Printf("Unsubscribed from %s (%s)", sub.Name, sub.URL)
+		s.record(r, audit.ActionUnsubscribe, sub.ID, map[string]string{"url": sub.URL})
+		if isHTMX(r) {
+			w.Header().Set("HX-Refresh", "true")
+		}
+		json.NewEncoder(w).Encode(SuccessResponse{
+			Success: true,
+			Message: "Unsubscribed",
+		})
+
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// handleCheckSubscription serves POST /api/subscriptions/{id}/check,
+// looking for new uploads straight away
+func (s *server) handleCheckSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	sub, ok := s.subs.Get(r.PathValue("id"))
+	if !ok {
+		writeSubscriptionNotFound(w)
+		return
+	}
+
+	job := s.checkSubscription(sub)
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+job.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Subscription check queued",
+		JobID:   job.ID,
+	})
+}
+
+// handleBackfillSubscription serves POST /api/subscriptions/{id}/backfill,
+// downloading the subscription's existing uploads within the limits in
+// the body: {"max_items": 100, "after": "2023-01-01", "max_filesize":
+// 2000000000}
+func (s *server) handleBackfillSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	limits, ok := decodeLimits(w, data)
+	if !ok {
+		return
+	}
+	sub, ok := s.subs.Get(r.PathValue("id"))
+	if !ok {
+		writeSubscriptionNotFound(w)
+		return
+	}
+
+	job := s.backfillSubscription(sub, limits)
+	s.record(r, audit.ActionSubscriptionBackfill, sub.ID, map[string]string{"job_id": job.ID})
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+job.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Subscription backfill queued",
+		JobID:   job.ID,
+	})
+}
+
+func writeSubscriptionNotFound(w http.ResponseWriter) {
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypeNotFound,
+		Message: "Subscription not found",
+		Code:    http.StatusNotFound,
+	})
+}
+
+// decodeFilter reads a subscription filter, writing an error and returning
+// ok false when it's invalid. null and an empty filter decode to nil.
+func decodeFilter(w http.ResponseWriter, data json.RawMessage) (filter *downloader.Filter, ok bool) {
+	err := json.Unmarshal(data, &filter)
+	if err == nil {
+		err = filter.Validate()
+	}
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid filter",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return nil, false
+	}
+	if filter.Empty() {
+		return nil, true
+	}
+	return filter, true
+}
+
+// normalizeLanguages reduces language tags to their codes, dropping blanks
+// and repeats
+func normalizeLanguages(tags []string) []string {
+	var codes []string
+	for _, tag := range tags {
+		if code := language.Normalize(tag); code != "" && !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
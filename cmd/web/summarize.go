@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"noahjalex.ute/internal/enrich"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+)
+
+// summarizeVideo asks the enrichment model for a summary and tags for v,
+// using its transcript when it has one
+func (s *server) summarizeVideo(ctx context.Context, v library.Video) (library.Video, error) {
+	if full, ok := s.videos.GetFull(v.ID); ok {
+		v = full
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.Enrichment.Timeout))
+	defer cancel()
+
+	res, err := s.enricher.Enrich(ctx, enrich.Input{
+		Title:       v.Title,
+		Uploader:    v.Uploader,
+		Description: v.Description,
+		Transcript:  v.Transcript,
+		Tags:        v.Tags,
+	})
+	if err != nil {
+		return library.Video{}, err
+	}
+	if err := s.videos.Update(v.ID, func(video *library.Video) {
+		video.Summary, video.SuggestedTags = res.Summary, res.Tags
+	}); err != nil {
+		return library.Video{}, err
+	}
+	log.Printf("Summarized %s with %s (%d tags)", v.FilePath, s.enricher.Name(), len(res.Tags))
+	v.Summary, v.SuggestedTags = res.Summary, res.Tags
+	return v, nil
+}
+
+// handleSummarize serves POST /api/videos/{id}/summarize, asking the
+// enrichment model for the video's summary and tags again
+func (s *server) handleSummarize(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	if s.enricher == nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Enrichment is not configured",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	v, err := s.summarizeVideo(r.Context(), v)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNetwork,
+			Message: "The enrichment model failed",
+			Details: err.Error(),
+			Code:    http.StatusBadGateway,
+		})
+		return
+	}
+	if isHTMX(r) {
+		w.Header().Set("HX-Refresh", "true")
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             v.ID,
+		"summary":        v.Summary,
+		"suggested_tags": v.SuggestedTags,
+	})
+}
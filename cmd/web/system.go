@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/ytdlp"
+)
+
+// version is the server's release, set when building with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// serverVersion returns version, or for development builds the module
+// version and commit Go recorded in the binary
+func serverVersion() string {
+	if version != "dev" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return version + "+" + setting.Value[:12]
+		}
+	}
+	return version
+}
+
+// toolInfo is an external program the server runs
+type toolInfo struct {
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runtimeInfo is the Go runtime's view of the process
+type runtimeInfo struct {
+	GoVersion  string `json:"go_version"`
+	Goroutines int    `json:"goroutines"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	NumCPU     int    `json:"num_cpu"`
+	// HeapAlloc is the bytes of live and not yet collected heap objects,
+	// and Sys the bytes obtained from the OS in all
+	HeapAlloc uint64 `json:"heap_alloc"`
+	HeapSys   uint64 `json:"heap_sys"`
+	Sys       uint64 `json:"sys"`
+	NumGC     uint32 `json:"num_gc"`
+	// GCPauseTotal is the seconds the program was stopped for collections
+	GCPauseTotal float64 `json:"gc_pause_total"`
+}
+
+func readRuntime() runtimeInfo {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return runtimeInfo{
+		GoVersion:    runtime.Version(),
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapSys:      mem.HeapSys,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		GCPauseTotal: time.Duration(mem.PauseTotalNs).Seconds(),
+	}
+}
+
+// queueSummary counts the jobs waiting and running
+type queueSummary struct {
+	Workers int `json:"workers"`
+	// Pending are the jobs queued to run, held ones included
+	Pending int                `json:"pending"`
+	Running int                `json:"running"`
+	ByState map[jobs.State]int `json:"by_state"`
+}
+
+// systemInfo is the response of GET /api/system
+type systemInfo struct {
+	Version   string       `json:"version"`
+	StartedAt time.Time    `json:"started_at"`
+	Uptime    float64      `json:"uptime"`
+	Runtime   runtimeInfo  `json:"runtime"`
+	YtDlp     ytdlp.Status `json:"ytdlp"`
+	FFmpeg    toolInfo     `json:"ffmpeg"`
+	// Dependencies are the optional programs found, ffmpeg among them,
+	// and the features that work with them
+	Dependencies dependencies  `json:"dependencies"`
+	Pools        []poolSummary `json:"pools"`
+	Queue        queueSummary  `json:"queue"`
+}
+
+// handleSystem serves GET /api/system: the server's version and uptime,
+// the versions of yt-dlp and the optional programs it uses, Go runtime stats, disk usage per
+// storage pool and a summary of the queue, for troubleshooting and
+// dashboards
+func (s *server) handleSystem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	queue := queueSummary{
+		Workers: s.settings.Get().Workers,
+		Pending: len(s.queue.Pending()),
+		ByState: map[jobs.State]int{},
+	}
+	for _, job := range s.jobs.List() {
+		queue.ByState[job.State]++
+	}
+	queue.Running = queue.ByState[jobs.StateRunning]
+
+	deps := s.checkDependencies(r.Context())
+	json.NewEncoder(w).Encode(systemInfo{
+		Version:      serverVersion(),
+		StartedAt:    s.started,
+		Uptime:       time.Since(s.started).Round(time.Second).Seconds(),
+		Runtime:      readRuntime(),
+		YtDlp:        s.ytdlp.Status(),
+		FFmpeg:       deps.FFmpeg,
+		Dependencies: deps,
+		Pools:        s.poolSummaries(),
+		Queue:        queue,
+	})
+}
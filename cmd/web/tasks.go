@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"noahjalex.ute/internal/audit"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/scheduler"
+)
+
+// taskSchedule is when the task called name runs: the config's override,
+// or every interval by default. Tasks with neither only run when
+// triggered.
+func (s *server) taskSchedule(name string, interval time.Duration) scheduler.Schedule {
+	if spec, ok := s.cfg.Schedules[name]; ok {
+		if spec == "off" {
+			return nil
+		}
+		// The config was validated when it was loaded
+		schedule, _ := scheduler.Parse(spec)
+		return schedule
+	}
+	if interval <= 0 {
+		return nil
+	}
+	return scheduler.Every(interval)
+}
+
+// registerTasks adds the server's background tasks to the scheduler
+func (s *server) registerTasks() {
+	add := func(name, description string, interval time.Duration, run scheduler.Func) {
+		s.tasks.Add(name, description, s.taskSchedule(name, interval), run)
+	}
+
+	add("rescan", "Rescan the videos directory for changes made outside ute",
+		time.Duration(s.cfg.Maintenance.RescanInterval),
+		func(ctx context.Context) error {
+			s.startRescan()
+			return nil
+		})
+	add("verify", "Check downloaded files against their recorded size and hash",
+		time.Duration(s.cfg.Maintenance.VerifyInterval),
+		func(ctx context.Context) error {
+			s.verifyLibrary(ctx, s.cfg.Maintenance.AutoRepair)
+			return nil
+		})
+	add("subscriptions", "Check every subscription for new uploads",
+		time.Duration(s.cfg.Subscriptions.Interval),
+		func(ctx context.Context) error {
+			for _, sub := range s.subs.List() {
+				s.checkSubscription(sub)
+			}
+			return nil
+		})
+	// The policy is read each time so settings changes take effect without
+	// a restart
+	add("retention", "Delete videos older than the retention policy allows",
+		retentionInterval,
+		func(ctx context.Context) error {
+			s.applyRetention(ctx)
+			return nil
+		})
+	if len(s.cfg.Pools.Rules) > 0 {
+		add("migrate", "Move videos to the storage pool their placement rule picks",
+			time.Duration(s.cfg.Pools.Interval),
+			func(ctx context.Context) error {
+				s.startMigration()
+				return nil
+			})
+	}
+
+	var pruneInterval time.Duration
+	if s.cfg.JobLogs.MaxAge > 0 {
+		pruneInterval = jobLogPruneInterval
+	}
+	add("job-logs", "Delete job logs older than job_logs.max_age",
+		pruneInterval,
+		func(ctx context.Context) error {
+			return s.pruneJobLogs()
+		})
+
+	var diskInterval time.Duration
+	if s.cfg.Notifications.Alerts.MinFreeBytes > 0 {
+		diskInterval = time.Duration(s.cfg.Notifications.Alerts.DiskInterval)
+	}
+	add("disk-space", "Alert when a library disk runs low on free space",
+		diskInterval,
+		func(ctx context.Context) error {
+			dirs := []string{s.cfg.VideosDir}
+			for _, pool := range s.cfg.Pools.Dirs {
+				dirs = append(dirs, pool.Dir)
+			}
+			return s.alerts.CheckDisk(dirs)
+		})
+	var statsInterval time.Duration
+	if s.mqtt != nil {
+		statsInterval = time.Duration(s.cfg.Notifications.MQTT.StatsInterval)
+	}
+	add("mqtt-stats", "Publish the library stats to MQTT",
+		statsInterval,
+		func(ctx context.Context) error {
+			if s.mqtt != nil {
+				s.publishStats(ctx)
+			}
+			return nil
+		})
+	s.tasks.Add("digest", "Send the notification digest of downloads finished since the last one",
+		s.digestSchedule(), s.sendDigest)
+
+	var updateInterval time.Duration
+	// The fake downloader has no use for yt-dlp, so it isn't fetched
+	if s.cfg.YtDlp.AutoUpdate && !s.cfg.FakeDownloader.Enabled {
+		updateInterval = time.Duration(s.cfg.YtDlp.UpdateInterval)
+	}
+	add("ytdlp-update", "Check for and install yt-dlp updates",
+		updateInterval, s.ytdlp.CheckForUpdate)
+
+	for name := range s.cfg.Schedules {
+		if _, ok := s.tasks.Get(name); !ok {
+			log.Printf("Warning: ignoring schedule for unknown task %q", name)
+		}
+	}
+}
+
+// handleTasks serves GET /api/tasks, every background task with its
+// schedule and how its last run went
+func (s *server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.tasks.List())
+}
+
+// handleTaskRun serves POST /api/tasks/{name}/run, running a task now
+// whether or not it's paused
+func (s *server) handleTaskRun(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := s.tasks.Trigger(name); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	s.record(r, audit.ActionTaskRun, name, nil)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Task " + name + " started",
+	})
+}
+
+// handleTaskPause serves POST and DELETE /api/tasks/{name}/pause, stopping
+// a task's scheduled runs or resuming them. Pauses survive restarts.
+func (s *server) handleTaskPause(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	paused := r.Method == "POST"
+
+	name := r.PathValue("name")
+	if err := s.tasks.Pause(name, paused); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	action := audit.ActionTaskResume
+	if paused {
+		action = audit.ActionTaskPause
+	}
+	s.record(r, action, name, nil)
+
+	if isHTMX(r) {
+		w.Header().Set("HX-Refresh", "true")
+	}
+	status, _ := s.tasks.Get(name)
+	json.NewEncoder(w).Encode(status)
+}
+
+func writeTaskError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Task not found",
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, scheduler.ErrRunning):
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "The task is already running",
+			Code:    http.StatusConflict,
+		})
+	default:
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to save the task's state",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/media"
+)
+
+// thumbWidths are the sizes thumbnails are rendered at; requests are
+// rounded up to the next one so the cache stays small
+var thumbWidths = []int{160, 320, 480, 640, 960, 1280}
+
+// handleThumbnail serves GET /api/videos/{id}/thumb. Without ?w the
+// original image is sent; with it the image is letterboxed to 16:9 at that
+// width and cached under the data directory.
+func (s *server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	source := ""
+	if ok {
+		source = library.ThumbnailPath(s.videos.AbsPath(v))
+	}
+	if source == "" {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Thumbnail not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		s.errPages.notFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("w") == "" {
+		serveCached(w, r, source, sourceInfo, s.cfg.Cache.Thumbnails)
+		return
+	}
+
+	requested, err := strconv.Atoi(r.URL.Query().Get("w"))
+	if err != nil || requested <= 0 {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Width must be a positive number",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	width := thumbWidths[len(thumbWidths)-1]
+	for _, tw := range thumbWidths {
+		if tw >= requested {
+			width = tw
+			break
+		}
+	}
+
+	// The source's modification time is part of the name so a replaced
+	// thumbnail is re-rendered
+	cacheDir := filepath.Join(s.cfg.DataDir, "thumbs")
+	cached := filepath.Join(cacheDir, fmt.Sprintf("%s-%d-%x.jpg", v.ID, width, sourceInfo.ModTime().UnixNano()))
+
+	fi, err := os.Stat(cached)
+	if err != nil {
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			log.Printf("Failed to create thumbnail cache: %v", err)
+			s.errPages.write(w, r, http.StatusInternalServerError, "The thumbnail couldn't be rendered.")
+			return
+		}
+		if err := media.ResizeImage(r.Context(), source, cached, width, width*9/16); err != nil {
+			log.Printf("Failed to resize thumbnail for %s: %v", v.ID, err)
+			// Fall back to the original rather than showing nothing
+			serveCached(w, r, source, sourceInfo, s.cfg.Cache.Thumbnails)
+			return
+		}
+		if fi, err = os.Stat(cached); err != nil {
+			s.errPages.write(w, r, http.StatusInternalServerError, "The thumbnail couldn't be rendered.")
+			return
+		}
+	}
+
+	serveCached(w, r, cached, fi, s.cfg.Cache.Thumbnails)
+}
+
+// removeCachedThumbnails drops every resized thumbnail of a video
+func (s *server) removeCachedThumbnails(id string) {
+	matches, _ := filepath.Glob(filepath.Join(s.cfg.DataDir, "thumbs", id+"-*.jpg"))
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
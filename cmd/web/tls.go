@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+// reloadingCert serves a certificate from files, loading them again when
+// they change, such as after a renewal
+type reloadingCert struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *reloadingCert) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fi, err := os.Stat(c.certFile)
+	if err == nil && c.cert != nil && !fi.ModTime().After(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// Keep serving the old one while a renewal is half written
+			log.Printf("Failed to reload the TLS certificate: %v", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	c.cert = &cert
+	if fi != nil {
+		c.modTime = fi.ModTime()
+	}
+	return c.cert, nil
+}
+
+// serverTLS returns the listeners' TLS settings, or nil to serve plain
+// HTTP
+func serverTLS(cfg config.TLS) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	cert := &reloadingCert{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	// Fail at startup rather than on the first connection
+	if _, err := cert.get(nil); err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.get,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// secure serves the TCP listeners in ls over TLS with conf, updating where
+// they can be reached. Unix sockets are left alone, as they are only
+// reachable on the machine itself.
+func secure(ls []net.Listener, wheres []string, conf *tls.Config) {
+	for i, l := range ls {
+		if l.Addr().Network() != "tcp" {
+			continue
+		}
+		ls[i] = tls.NewListener(l, conf)
+		wheres[i] = strings.Replace(wheres[i], "http://", "https://", 1)
+	}
+}
+
+// clientTLS returns the settings an agent connects to its server with:
+// certFile and keyFile are its client certificate for a server requiring
+// one, and caFile the CA to trust the server's certificate from, besides
+// the system's. Each may be empty.
+func clientTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate needs both its certificate and key files")
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/language"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/transcribe"
+)
+
+// errRemoteVideo is returned for work that needs a local copy of the file
+var errRemoteVideo = errors.New("the video is on remote storage")
+
+// transcribeVideo writes subtitles for v beside it and stores what was said
+// on the library entry for search. Transcriptions run one at a time as each
+// can keep every core busy.
+func (s *server) transcribeVideo(ctx context.Context, v library.Video) error {
+	if v.IsRemote() {
+		return errRemoteVideo
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.Transcription.Timeout))
+	defer cancel()
+
+	s.maint.transcribeMu.Lock()
+	defer s.maint.transcribeMu.Unlock()
+
+	path := s.videos.AbsPath(v)
+	vtt, err := transcribe.Video(ctx, s.transcriber, path, s.cfg.TempDir)
+	if err != nil {
+		return err
+	}
+	subtitles := strings.TrimSuffix(path, filepath.Ext(path)) + transcribe.Suffix
+	if err := os.WriteFile(subtitles, vtt, 0644); err != nil {
+		return err
+	}
+	text := transcribe.Text(vtt)
+	// The site's language wins; then the one Whisper was told to expect
+	lang := language.Normalize(s.cfg.Transcription.Language)
+	if lang == "" {
+		lang = language.Detect(text)
+	}
+	if err := s.videos.Update(v.ID, func(video *library.Video) {
+		video.Transcript = text
+		if video.Language == "" {
+			video.Language = lang
+		}
+	}); err != nil {
+		return err
+	}
+	log.Printf("Transcribed %s with %s (%d characters)", v.FilePath, s.transcriber.Name(), len(text))
+	return nil
+}
+
+// startTranscription transcribes v as a background job. A video already
+// being transcribed returns the running job.
+func (s *server) startTranscription(v library.Video) jobs.Job {
+	s.maint.mu.Lock()
+	defer s.maint.mu.Unlock()
+
+	if id, ok := s.maint.transcribes[v.ID]; ok {
+		if job, ok := s.jobs.Get(id); ok {
+			return job
+		}
+	}
+	if s.maint.transcribes == nil {
+		s.maint.transcribes = make(map[string]string)
+	}
+
+	job := s.jobs.CreateTask(jobs.KindTranscribe)
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.VideoIDs = []string{v.ID} })
+	s.maint.transcribes[v.ID] = job.ID
+
+	go func() {
+		err := s.transcribeVideo(context.Background(), v)
+		s.finishTask(job.ID, nil, err)
+		if err != nil {
+			log.Printf("Transcription of %s failed: %v", v.FilePath, err)
+		}
+
+		s.maint.mu.Lock()
+		delete(s.maint.transcribes, v.ID)
+		s.maint.mu.Unlock()
+	}()
+	return job
+}
+
+// handleTranscribe serves POST /api/videos/{id}/transcribe, transcribing
+// the video in the background, or again if it already has a transcript
+func (s *server) handleTranscribe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	if s.transcriber == nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Transcription is not configured",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+	if v.IsRemote() {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Videos on remote storage can't be transcribed",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	job := s.startTranscription(v)
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+job.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Transcription started",
+		JobID:   job.ID,
+	})
+}
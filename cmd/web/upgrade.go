@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"noahjalex.ute/internal/audit"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/media"
+)
+
+// defaultUpgradeFormat asks yt-dlp for the best video and audio it can get
+const defaultUpgradeFormat = "bv*+ba/b"
+
+// handleUpgrade serves POST /api/videos/{id}/upgrade, downloading the video
+// again at a better quality. The body may choose the yt-dlp format:
+// {"format": "bv*[height<=1080]+ba/b"}. The library entry keeps its ID,
+// watch state and tags, and only takes the new file if its resolution is
+// higher.
+func (s *server) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var body struct {
+		Format string `json:"format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	format := strings.TrimSpace(body.Format)
+	if format == "" {
+		format = defaultUpgradeFormat
+	}
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	switch {
+	case v.WebpageURL == "":
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Video has no source URL to upgrade from",
+			Code:    http.StatusConflict,
+		})
+		return
+	case v.IsRemote():
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Videos on remote storage can't be upgraded",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	quota, quotaErr := s.checkQuota(r)
+	if quotaErr != nil {
+		writeQuotaError(w, quota, quotaErr)
+		return
+	}
+
+	job, started := s.startUpgrade(v, format, userName(r))
+	if started {
+		s.record(r, audit.ActionVideoUpgrade, v.ID, map[string]string{"job_id": job.ID, "format": format})
+	}
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/jobs/"+job.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Success: true,
+		Message: "Upgrade queued",
+		JobID:   job.ID,
+		Quota:   quota,
+	})
+}
+
+// startUpgrade queues a download of v's source in format that replaces v's
+// file when it's better. An upgrade of v already queued or running is
+// returned instead of starting another.
+func (s *server) startUpgrade(v library.Video, format, user string) (jobs.Job, bool) {
+	for _, job := range s.jobs.List() {
+		if job.Upgrade != v.ID {
+			continue
+		}
+		switch job.State {
+		case jobs.StateQueued, jobs.StateRunning, jobs.StateRetrying:
+			return job, false
+		}
+	}
+
+	job := s.jobs.Create(v.WebpageURL, []string{"-f", format}, v.Folder(), jobs.PriorityNormal)
+	s.jobs.Update(job.ID, func(j *jobs.Job) {
+		j.Upgrade = v.ID
+		j.User = user
+	})
+	job.Upgrade, job.User = v.ID, user
+	s.queue.Push(job.ID, jobs.PriorityNormal)
+	log.Printf("Queued upgrade job %s for %s", job.ID, v.FilePath)
+	return job, true
+}
+
+// applyUpgrade swaps the video an upgrade job is for over to the file it
+// downloaded, if that has a higher resolution. The new file is renamed over
+// the old one, so the video is never missing; a different extension is
+// written beside it and the old file removed afterwards.
+func (s *server) applyUpgrade(ctx context.Context, job jobs.Job, files []string) *apperr.DownloadError {
+	v, ok := s.videos.Get(job.Upgrade)
+	if !ok {
+		return &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "The video being upgraded is no longer in the library",
+			Code:    http.StatusNotFound,
+		}
+	}
+	download := ""
+	for _, file := range files {
+		if library.IsVideoFile(file) {
+			download = file
+			break
+		}
+	}
+	if download == "" {
+		return &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "The download produced no video file",
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	old := s.videos.AbsPath(v)
+	if better, details := s.betterQuality(ctx, download, old, v); !better {
+		return &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "No better quality is available",
+			Details: details,
+			Code:    http.StatusConflict,
+		}
+	}
+
+	target := strings.TrimSuffix(old, filepath.Ext(old)) + filepath.Ext(download)
+	if target != old {
+		if _, err := os.Lstat(target); err == nil {
+			return upgradeFileError(fmt.Errorf("%s already exists", filepath.Base(target)))
+		}
+	}
+	// Stage the file beside its destination so the final rename is atomic;
+	// the .part suffix keeps it out of rescans
+	tmp := filepath.Join(filepath.Dir(old), "."+filepath.Base(target)+".upgrade.part")
+	if err := library.MoveFile(download, tmp); err != nil {
+		return upgradeFileError(err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return upgradeFileError(err)
+	}
+
+	updated, err := s.videos.ReplaceFile(v.ID, target, library.ReplacedByUpgrade)
+	if err != nil {
+		return upgradeFileError(err)
+	}
+	if target != old {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s after upgrading: %v", old, err)
+		}
+	}
+	s.enrichVideo(ctx, updated)
+
+	s.jobs.Update(job.ID, func(j *jobs.Job) { j.VideoIDs = []string{v.ID} })
+	s.accountDownload(job, []string{target}, nil)
+	log.Printf("Upgraded %s to %s", v.FilePath, updated.FilePath)
+	return nil
+}
+
+// betterQuality reports whether the downloaded file has a higher resolution
+// than the library's, with a description of both when it doesn't. Without
+// ffprobe the larger file wins.
+func (s *server) betterQuality(ctx context.Context, download, old string, v library.Video) (bool, string) {
+	oldHeight := v.Height
+	if oldHeight == 0 {
+		if info, err := media.Probe(ctx, old); err == nil {
+			oldHeight = info.Height
+		}
+	}
+	if info, err := media.Probe(ctx, download); err == nil && info.Height > 0 && oldHeight > 0 {
+		return info.Height > oldHeight, fmt.Sprintf("The source's best copy is %dp and the library's is %dp", info.Height, oldHeight)
+	}
+
+	fi, err := os.Stat(download)
+	if err != nil {
+		return false, err.Error()
+	}
+	return fi.Size() > v.Size, fmt.Sprintf("The source's best copy is %s and the library's is %s", formatSize(fi.Size()), formatSize(v.Size))
+}
+
+func upgradeFileError(err error) *apperr.DownloadError {
+	return &apperr.DownloadError{
+		Type:    apperr.TypeFileSystem,
+		Message: "Failed to replace the video with the upgraded copy",
+		Details: err.Error(),
+		Code:    http.StatusInternalServerError,
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"noahjalex.ute/internal/audit"
+	"noahjalex.ute/internal/downloader"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/subscriptions"
+)
+
+// uploaderSummary is an uploader with their subscription, if any
+type uploaderSummary struct {
+	library.Uploader
+	Subscription string `json:"subscription,omitempty"`
+}
+
+// uploaderDetail adds the uploader's videos, newest first
+type uploaderDetail struct {
+	uploaderSummary
+	Videos []library.Video `json:"videos"`
+}
+
+func (s *server) uploaderSummary(u library.Uploader) uploaderSummary {
+	summary := uploaderSummary{Uploader: u}
+	if u.ChannelURL != "" {
+		if sub, ok := s.subs.FindByURL(u.ChannelURL); ok {
+			summary.Subscription = sub.ID
+		}
+	}
+	return summary
+}
+
+// uploaders lists every uploader in the library
+func (s *server) uploaders() []uploaderSummary {
+	list := []uploaderSummary{}
+	for _, u := range s.videos.Uploaders() {
+		list = append(list, s.uploaderSummary(u))
+	}
+	return list
+}
+
+// uploaderDetail looks up the uploader named in the request path
+func (s *server) uploaderDetail(r *http.Request) (uploaderDetail, bool) {
+	u, videos, ok := s.videos.Uploader(r.PathValue("name"))
+	if !ok {
+		return uploaderDetail{}, false
+	}
+	return uploaderDetail{uploaderSummary: s.uploaderSummary(u), Videos: videos}, true
+}
+
+// handleUploaders serves GET /api/uploaders
+func (s *server) handleUploaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.uploaders())
+}
+
+// handleUploader serves GET /api/uploaders/{name}
+func (s *server) handleUploader(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	detail, ok := s.uploaderDetail(r)
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Uploader not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(detail)
+}
+
+// handleSubscribeUploader serves POST /api/uploaders/{name}/subscribe,
+// subscribing to the uploader's channel. The body may name a folder to
+// download into, the languages to download, a filter choosing uploads
+// and limits for a one-time backfill of the existing ones: {"folder":
+// "channels/example", "languages": ["en"], "filter": {"skip_shorts":
+// true}, "backfill": {"max_items": 50}}. Subscribing again with languages
+// or a filter replaces them.
+func (s *server) handleSubscribeUploader(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	body := struct {
+		Folder    string          `json:"folder"`
+		Languages []string        `json:"languages"`
+		Filter    json.RawMessage `json:"filter"`
+		Backfill  json.RawMessage `json:"backfill"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	folder, err := library.CleanRelPath(body.Folder)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid folder",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	var filter *downloader.Filter
+	if body.Filter != nil {
+		var valid bool
+		if filter, valid = decodeFilter(w, body.Filter); !valid {
+			return
+		}
+	}
+
+	var limits *jobs.Limits
+	if body.Backfill != nil {
+		var valid bool
+		if limits, valid = decodeLimits(w, body.Backfill); !valid {
+			return
+		}
+	}
+
+	u, _, ok := s.videos.Uploader(r.PathValue("name"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Uploader not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	if u.ChannelURL == "" {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "None of this uploader's videos list a channel URL",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	sub, created, err := s.subs.Add(u.Name, u.ChannelURL, folder)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to save subscription",
+			Details: err.Error(),
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if body.Languages != nil {
+		languages := normalizeLanguages(body.Languages)
+		if err := s.subs.Update(sub.ID, func(sub *subscriptions.Subscription) { sub.Languages = languages }); err != nil {
+			log.Printf("Failed to save subscription %s: %v", sub.ID, err)
+		}
+		sub.Languages = languages
+	}
+	if body.Filter != nil {
+		if err := s.subs.Update(sub.ID, func(sub *subscriptions.Subscription) { sub.Filter = filter }); err != nil {
+			log.Printf("Failed to save subscription %s: %v", sub.ID, err)
+		}
+		sub.Filter = filter
+	}
+
+	status := http.StatusOK
+	if created {
+		log.Printf("Subscribed to %s (%s)", sub.Name, sub.URL)
+		s.record(r, audit.ActionSubscribe, sub.ID, map[string]string{"url": sub.URL})
+		status = http.StatusCreated
+	}
+	// Only a new subscription's catalogue is backfilled here; later ones
+	// go through /api/subscriptions/{id}/backfill
+	if created && body.Backfill != nil {
+		job := s.backfillSubscription(sub, limits)
+		sub.BackfillJob = job.ID
+		s.record(r, audit.ActionSubscriptionBackfill, sub.ID, map[string]string{"job_id": job.ID})
+	}
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", "/uploaders/"+url.PathEscape(u.Name))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(sub)
+}
+
+// handleUploadersPage serves GET /uploaders
+func (s *server) handleUploadersPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	s.views.render(w, r, "uploaders", "uploader-list", s.uploaders())
+}
+
+// handleUploaderPage serves GET /uploaders/{name}
+func (s *server) handleUploaderPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "This page only supports GET.")
+		return
+	}
+
+	detail, ok := s.uploaderDetail(r)
+	if !ok {
+		s.errPages.write(w, r, http.StatusNotFound, "No videos in the library are by that uploader.")
+		return
+	}
+	s.views.render(w, r, "uploader", "uploader-detail", detail)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"noahjalex.ute/internal/config"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/usage"
+)
+
+type userKey struct{}
+
+// authenticate requires a configured user's token on every request. The
+// token is sent as "Authorization: Bearer <token>", or as the password of
+// HTTP basic auth with the user's name so browsers can log in. Without
+// users the server stays open and requests carry no user.
+func authenticate(users []config.User, pages *errorPages) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(users) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Agents sign in with their own tokens, checked by the handlers
+			if strings.HasPrefix(r.URL.Path, "/api/agent/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user := findUser(users, r)
+			if user == nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="ute", charset="UTF-8"`)
+				pages.write(w, r, http.StatusUnauthorized, "Sign in with your user name and token.")
+				return
+			}
+			noteUser(r.Context(), user.Name)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+		})
+	}
+}
+
+// findUser returns the user whose token r carries, if any
+func findUser(users []config.User, r *http.Request) *config.User {
+	name, token, basic := r.BasicAuth()
+	if !basic {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return nil
+		}
+		token = strings.TrimSpace(bearer)
+	}
+	for i := range users {
+		u := &users[i]
+		// Constant-time so response times don't reveal how much matched
+		match := subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1
+		if match && (!basic || name == u.Name) {
+			return u
+		}
+	}
+	return nil
+}
+
+// currentUser returns the user who made r, nil when the server has no users
+func currentUser(r *http.Request) *config.User {
+	u, _ := r.Context().Value(userKey{}).(*config.User)
+	return u
+}
+
+// userName returns the name of the user who made r, or ""
+func userName(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.Name
+	}
+	return ""
+}
+
+// requireAdmin rejects r unless it was made by an admin. Servers without
+// users have no admins, so everything is allowed.
+func (s *server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.cfg.Users) == 0 {
+		return true
+	}
+	if u := currentUser(r); u != nil && u.Admin {
+		return true
+	}
+	writeError(w, &apperr.DownloadError{
+		Type:    apperr.TypePermission,
+		Message: "Only admins can do this",
+		Code:    http.StatusForbidden,
+	})
+	return false
+}
+
+// handleAdmin registers h on mux for admins only. Its pattern becomes a
+// management route, which the public listeners don't serve when there are
+// -admin-addr ones.
+func (s *server) handleAdmin(mux *http.ServeMux, pattern string, h http.HandlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		h(w, r)
+	})
+	s.managementRoutes = append(s.managementRoutes, pattern)
+}
+
+// quotaStatus is a user's usage against their quota
+type quotaStatus struct {
+	User  string       `json:"user"`
+	Admin bool         `json:"admin,omitempty"`
+	Quota config.Quota `json:"quota"`
+	// DownloadedToday is the bytes downloaded since midnight UTC
+	DownloadedToday int64 `json:"downloaded_today"`
+	StorageUsed     int64 `json:"storage_used"`
+	// ActiveJobs counts the user's queued and running downloads
+	ActiveJobs int `json:"active_jobs"`
+}
+
+func (s *server) quotaStatus(u *config.User) quotaStatus {
+	status := quotaStatus{
+		User:            u.Name,
+		Admin:           u.Admin,
+		Quota:           u.Quota,
+		DownloadedToday: s.usage.Today(u.Name),
+		StorageUsed:     s.videos.UsedBy(u.Name),
+	}
+	for _, job := range s.jobs.List() {
+		if job.User != u.Name || job.Kind != jobs.KindDownload {
+			continue
+		}
+		switch job.State {
+		case jobs.StateQueued, jobs.StateRunning, jobs.StateRetrying:
+			status.ActiveJobs++
+		}
+	}
+	return status
+}
+
+// checkQuota returns the status of the user who made r, and an error if
+// they can't start another download. Servers without users have no quotas.
+func (s *server) checkQuota(r *http.Request) (*quotaStatus, *apperr.DownloadError) {
+	u := currentUser(r)
+	if u == nil {
+		return nil, nil
+	}
+	status := s.quotaStatus(u)
+	q := status.Quota
+
+	var reason string
+	switch {
+	case q.Jobs > 0 && status.ActiveJobs >= q.Jobs:
+		reason = fmt.Sprintf("%d downloads are already queued or running, the most allowed at once", status.ActiveJobs)
+	case q.DailyBytes > 0 && status.DownloadedToday >= q.DailyBytes:
+		reason = fmt.Sprintf("%s downloaded today of a daily %s", formatSize(status.DownloadedToday), formatSize(q.DailyBytes))
+	case q.Storage > 0 && status.StorageUsed >= q.Storage:
+		reason = fmt.Sprintf("%s stored of %s; delete videos to make room", formatSize(status.StorageUsed), formatSize(q.Storage))
+	}
+	if reason != "" {
+		return &status, &apperr.DownloadError{
+			Type:    apperr.TypeQuota,
+			Message: "Download quota exceeded",
+			Details: reason,
+			Code:    http.StatusTooManyRequests,
+		}
+	}
+	return &status, nil
+}
+
+// writeQuotaError rejects a download, including the user's quota status
+func writeQuotaError(w http.ResponseWriter, status *quotaStatus, err *apperr.DownloadError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Code)
+	json.NewEncoder(w).Encode(struct {
+		ErrorResponse
+		Quota *quotaStatus `json:"quota"`
+	}{ErrorResponse{Success: false, Error: err}, status})
+}
+
+// accountDownload records the files a job wrote against its user: their
+// size counts towards today's usage, and the videos are marked as theirs so
+// they count towards storage
+func (s *server) accountDownload(job jobs.Job, files []string, videoIDs []string) {
+	if job.User == "" {
+		return
+	}
+	var bytes int64
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+			bytes += fi.Size()
+		}
+	}
+	if err := s.usage.Add(job.User, bytes); err != nil {
+		log.Printf("Failed to save usage for %s: %v", job.User, err)
+	}
+	for _, id := range videoIDs {
+		if err := s.videos.Update(id, func(v *library.Video) {
+			if v.AddedBy == "" {
+				v.AddedBy = job.User
+			}
+		}); err != nil {
+			log.Printf("Failed to record who added %s: %v", id, err)
+		}
+	}
+}
+
+// handleQuota serves GET /api/quota, the caller's usage and limits
+func (s *server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	u := currentUser(r)
+	if u == nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "No users are configured, so there are no quotas",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(s.quotaStatus(u))
+}
+
+// userUsage is a user's quota status with their recent daily downloads
+type userUsage struct {
+	quotaStatus
+	History []usage.Day `json:"history"`
+}
+
+// handleUsers serves GET /api/users, every user's usage for admins
+func (s *server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	list := make([]userUsage, 0, len(s.cfg.Users))
+	for i := range s.cfg.Users {
+		u := &s.cfg.Users[i]
+		list = append(list, userUsage{quotaStatus: s.quotaStatus(u), History: s.usage.History(u.Name)})
+	}
+	json.NewEncoder(w).Encode(list)
+}
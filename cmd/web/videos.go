@@ -0,0 +1,702 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/audit"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/timefmt"
+)
+
+// deleteVideo removes a video's files, local or remote, and its library
+// record, returning the bytes freed
+func (s *server) deleteVideo(ctx context.Context, v library.Video) (int64, error) {
+	if v.IsRemote() {
+		if s.storage == nil || s.storage.Name() != v.Storage {
+			return 0, fmt.Errorf("video %s is on %s storage which is not configured", v.ID, v.Storage)
+		}
+		keys := v.RemoteFiles
+		if len(keys) == 0 {
+			keys = []string{v.RemoteKey}
+		}
+		for _, key := range keys {
+			if err := s.storage.Delete(ctx, key); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// Local copies may exist alongside remote ones when keep_local is set
+	if files, err := library.RelatedFiles(s.videos.AbsPath(v)); err == nil {
+		for _, file := range files {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+		}
+	}
+
+	if _, err := s.videos.Remove(v.ID); err != nil {
+		return 0, err
+	}
+	s.removeCachedThumbnails(v.ID)
+	if err := s.lists.Forget(v.ID); err != nil {
+		log.Printf("Failed to take %s off favorites and watch-later lists: %v", v.ID, err)
+	}
+	if err := s.playback.Forget(v.ID); err != nil {
+		log.Printf("Failed to drop playback positions in %s: %v", v.ID, err)
+	}
+	log.Printf("Deleted video %s (%s)", v.ID, v.FilePath)
+	s.publishVideo(events.VideoDeleted, v)
+	return v.Size, nil
+}
+
+// videoListItem is a video as GET /api/videos lists it
+type videoListItem struct {
+	ID         string `json:"id"`
+	Filename   string `json:"filename"`
+	Folder     string `json:"folder"`
+	Size       int64  `json:"size"`
+	Modified   string `json:"modified"`
+	Title      string `json:"title"`
+	Uploader   string `json:"uploader"`
+	UploadDate string `json:"uploadDate"`
+	// UploadedAt and DownloadedAt are RFC 3339 times, UploadedAt empty
+	// when the site gave no date
+	UploadedAt   string   `json:"uploaded_at,omitempty"`
+	DownloadedAt string   `json:"downloaded_at"`
+	Views        int      `json:"views"`
+	Likes        int      `json:"likes"`
+	URL          string   `json:"url"`
+	Storage      string   `json:"storage"`
+	Duration     float64  `json:"duration"`
+	Tags         []string `json:"tags"`
+	Categories   []string `json:"categories"`
+	ChannelID    string   `json:"channel_id"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	FPS          float64  `json:"fps"`
+	VCodec       string   `json:"vcodec"`
+	ACodec       string   `json:"acodec"`
+	Site         string   `json:"site"`
+	Language     string   `json:"language"`
+	Watched      bool     `json:"watched"`
+	Position     float64  `json:"position"`
+	Updated      string   `json:"updated,omitempty"`
+}
+
+// videoRemoval is how a listing of changes reports a video that was
+// removed, or no longer matches the query
+type videoRemoval struct {
+	ID      string `json:"id"`
+	Removed bool   `json:"removed"`
+}
+
+// Wire formats of GET /api/videos
+const (
+	listFormatJSON   = "json"
+	listFormatNDJSON = "ndjson"
+)
+
+// handleVideos serves GET /api/videos, the videos matching the query's
+// filters in its sort order. Entries are encoded and sent one at a time
+// rather than built up in memory first: as a JSON array by default, or
+// with ?format=ndjson as one JSON object per line.
+//
+// The X-Library-Cursor header marks the library's latest change. Sent
+// back as ?updated_since=, it lists only the videos changed after it,
+// followed by removal entries for those removed or no longer matching.
+// If-Modified-Since gets a 304 while nothing has changed.
+func (s *server) handleVideos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = listFormatJSON
+	}
+	if format != listFormatJSON && format != listFormatNDJSON {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid format",
+			Details: "format must be json or ndjson",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	query, err := s.parseQuery(r, r.URL.Query())
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid query",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	// The cursor is read before listing, so a change made meanwhile is
+	// sent again next time rather than missed
+	cursor := s.videos.LastChange()
+	perUser := filtersMarks(query)
+
+	var since time.Time
+	if raw := r.URL.Query().Get("updated_since"); raw != "" {
+		since, err = time.Parse(time.RFC3339Nano, raw)
+		if err == nil && perUser {
+			err = fmt.Errorf("updated_since can't be combined with favorite, watch_later or in_progress")
+		}
+		if err != nil {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Invalid updated_since",
+				Details: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+
+	// Lists filtered on the caller's own marks change without the library
+	// changing, so they are never answered as unchanged
+	if !perUser && notModifiedSince(w, r, cursor) {
+		return
+	}
+	w.Header().Set("X-Library-Cursor", cursor.UTC().Format(time.RFC3339Nano))
+	advertiseWebSub(w, r)
+
+	var list []library.Video
+	var removed []string
+	if since.IsZero() {
+		list = s.videos.Find(query)
+	} else if changes, ok := s.videos.ChangesSince(since, query); ok {
+		list, removed = changes.Videos, changes.Removed
+	} else {
+		// The cursor is too old to know what was removed since; the full
+		// listing tells the client to start over
+		w.Header().Set("X-Library-Full", "true")
+		list = s.videos.Find(query)
+	}
+	user := userName(r)
+
+	if format == listFormatNDJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	// Encode writes a newline after each entry, which is all NDJSON needs
+	// and harmless between array elements
+	enc := json.NewEncoder(w)
+	if format == listFormatJSON {
+		io.WriteString(w, "[")
+	}
+	for i, v := range list {
+		if format == listFormatJSON && i > 0 {
+			io.WriteString(w, ",")
+		}
+		err := enc.Encode(videoListItem{
+			ID:           v.ID,
+			Filename:     v.FilePath,
+			Folder:       v.Folder(),
+			Size:         v.Size,
+			Modified:     s.dates.API(v.ModTime),
+			Title:        v.Title,
+			Uploader:     v.Uploader,
+			UploadDate:   v.UploadDate,
+			UploadedAt:   formatTime(s.dates, v.UploadedAt),
+			DownloadedAt: s.dates.API(v.DownloadedAt),
+			Views:        v.ViewCount,
+			Likes:        v.LikeCount,
+			URL:          v.WebpageURL,
+			Storage:      v.Storage,
+			Duration:     v.Duration,
+			Tags:         v.Tags,
+			Categories:   v.Categories,
+			ChannelID:    v.ChannelID,
+			Width:        v.Width,
+			Height:       v.Height,
+			FPS:          v.FPS,
+			VCodec:       v.VCodec,
+			ACodec:       v.ACodec,
+			Site:         v.Extractor,
+			Language:     v.Language,
+			Watched:      v.WatchedAt != nil,
+			Position:     s.progress(user, v.ID).Position,
+			Updated:      formatUpdated(v.UpdatedAt),
+		})
+		if err != nil {
+			// The client went away; the status is already sent
+			log.Printf("Stopped listing videos after %d of %d: %v", i, len(list), err)
+			return
+		}
+	}
+	for i, id := range removed {
+		if format == listFormatJSON && len(list)+i > 0 {
+			io.WriteString(w, ",")
+		}
+		if err := enc.Encode(videoRemoval{ID: id, Removed: true}); err != nil {
+			log.Printf("Stopped listing removed videos after %d of %d: %v", i, len(removed), err)
+			return
+		}
+	}
+	if format == listFormatJSON {
+		io.WriteString(w, "]\n")
+	}
+
+	log.Printf("Found %d video files", len(list))
+}
+
+// formatUpdated writes a video's last change time for listings, empty for
+// videos indexed before changes were recorded
+func formatUpdated(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatTime writes a time that may be unknown for the API, empty when it is
+func formatTime(dates *timefmt.Formatter, t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return dates.API(*t)
+}
+
+// handleVideo serves GET and DELETE /api/videos/{id}. Unlike listings,
+// the video comes with its description, chapters, transcript and summary.
+func (s *server) handleVideo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	v, ok := s.videos.GetFull(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.videoDetail(r, v))
+	case "DELETE":
+		freed, err := s.deleteVideo(r.Context(), v)
+		if err != nil {
+			log.Printf("Failed to delete video %s: %v", v.ID, err)
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to delete video",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		s.record(r, audit.ActionVideoDelete, v.ID, map[string]string{"title": v.Title})
+		if isHTMX(r) {
+			w.Header().Set("HX-Redirect", "/library")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":         true,
+			"reclaimed_bytes": freed,
+		})
+	default:
+		writeMethodNotAllowed(w, r)
+	}
+}
+
+// handleWatched serves POST /api/videos/{id}/watched, marking a video as
+// watched or not ({"watched": false})
+func (s *server) handleWatched(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	body := struct {
+		Watched *bool `json:"watched"`
+	}{}
+	// An empty body marks the video as watched
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	watched := body.Watched == nil || *body.Watched
+
+	err := s.videos.Update(r.PathValue("id"), func(v *library.Video) {
+		if !watched {
+			v.WatchedAt = nil
+		} else if v.WatchedAt == nil {
+			now := time.Now()
+			v.WatchedAt = &now
+		}
+	})
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	v, _ := s.videos.Get(r.PathValue("id"))
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleVideoFile serves GET /videos/{path}, downloading a library file
+// from the videos directory, a storage pool or the storage backend
+func (s *server) handleVideoFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		log.Printf("Invalid method %s for /videos/ endpoint", r.Method)
+		s.errPages.write(w, r, http.StatusMethodNotAllowed, "Videos can only be downloaded with GET.")
+		return
+	}
+
+	// Base directory to serve from
+	baseDir := s.cfg.VideosDir
+
+	// Clean the path and join with baseDir
+	relPath := strings.TrimPrefix(r.URL.Path, "/videos/")
+
+	// Security check: prevent directory traversal
+	relPath, err := library.CleanRelPath(relPath)
+	if err != nil || relPath == "" {
+		log.Printf("Potential directory traversal attempt: %s", r.URL.Path)
+		s.errPages.write(w, r, http.StatusBadRequest, "That file path isn't valid.")
+		return
+	}
+
+	// Files moved to remote storage are streamed or redirected
+	if v, ok := s.videos.FindByPath(relPath); ok && v.IsRemote() {
+		s.serveRemote(w, r, v)
+		return
+	}
+
+	// Files missing from the videos directory may have been moved to a
+	// storage pool under the same path
+	targetPath := filepath.Join(baseDir, filepath.FromSlash(relPath))
+	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
+		for _, pool := range s.videos.Pools() {
+			dir, _ := s.videos.PoolDir(pool)
+			candidate := filepath.Join(dir, filepath.FromSlash(relPath))
+			if _, err := os.Stat(candidate); err == nil {
+				targetPath = candidate
+				break
+			}
+		}
+	}
+	log.Printf("Serving file: %s", targetPath)
+
+	fi, err := os.Stat(targetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("File not found: %s", targetPath)
+			s.errPages.notFound(w, r)
+		} else {
+			log.Printf("Error accessing file %s: %v", targetPath, err)
+			s.errPages.write(w, r, http.StatusInternalServerError, "Something went wrong while serving this file.")
+		}
+		return
+	}
+
+	// If it's a directory, return error
+	if fi.IsDir() {
+		log.Printf("Attempted to access directory as file: %s", targetPath)
+		s.errPages.write(w, r, http.StatusBadRequest, "Folders can't be downloaded.")
+		return
+	}
+
+	// Serve file for download; ServeFile handles ranges and conditional
+	// requests and sets Content-Length itself
+	w.Header().Set("Content-Disposition", attachment(fi.Name()))
+
+	log.Printf("Serving file %s (%d bytes)", fi.Name(), fi.Size())
+	serveCached(w, r, targetPath, fi, s.cfg.Cache.Videos)
+}
+
+// handleDuplicates serves GET /api/duplicates
+func (s *server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	groups := s.videos.Duplicates()
+	if groups == nil {
+		groups = []library.DuplicateGroup{}
+	}
+	json.NewEncoder(w).Encode(groups)
+}
+
+// handleMergeDuplicates serves POST /api/duplicates/merge. The kept video
+// inherits metadata it is missing from the removed copies, which are then
+// deleted.
+func (s *server) handleMergeDuplicates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	var body struct {
+		Keep   string   `json:"keep"`
+		Remove []string `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Keep == "" || len(body.Remove) == 0 {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Request body must contain keep and remove",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	keep, ok := s.videos.Get(body.Keep)
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Details: body.Keep,
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	var remove []library.Video
+	for _, id := range body.Remove {
+		// Their details may fill in the kept video's
+		v, ok := s.videos.GetFull(id)
+		if !ok {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeNotFound,
+				Message: "Video not found",
+				Details: id,
+				Code:    http.StatusNotFound,
+			})
+			return
+		}
+		if v.ID == keep.ID || !isDuplicate(keep, v) {
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeValidation,
+				Message: "Video is not a duplicate of the kept video",
+				Details: id,
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+		remove = append(remove, v)
+	}
+
+	s.videos.Update(keep.ID, func(k *library.Video) {
+		for _, v := range remove {
+			mergeMetadata(k, v)
+		}
+	})
+
+	var reclaimed int64
+	for _, v := range remove {
+		freed, err := s.deleteVideo(r.Context(), v)
+		if err != nil {
+			log.Printf("Failed to delete duplicate %s: %v", v.ID, err)
+			writeError(w, &apperr.DownloadError{
+				Type:    apperr.TypeFileSystem,
+				Message: "Failed to delete duplicate",
+				Details: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		reclaimed += freed
+	}
+
+	s.record(r, audit.ActionDuplicateMerge, keep.ID, map[string]string{"removed": strings.Join(body.Remove, ",")})
+
+	kept, _ := s.videos.GetFull(keep.ID)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"kept":            kept,
+		"reclaimed_bytes": reclaimed,
+	})
+}
+
+func isDuplicate(a, b library.Video) bool {
+	if a.SHA256 != "" && a.SHA256 == b.SHA256 {
+		return true
+	}
+	return a.ExtractorID != "" && a.Extractor == b.Extractor && a.ExtractorID == b.ExtractorID
+}
+
+// mergeMetadata fills empty fields of dst from src
+func mergeMetadata(dst *library.Video, src library.Video) {
+	// Videos without an .info.json are titled after their file
+	if dst.Title == dst.Filename() && src.Title != src.Filename() {
+		dst.Title = src.Title
+	}
+	if dst.Uploader == "" {
+		dst.Uploader = src.Uploader
+	}
+	if dst.UploadDate == "" {
+		dst.UploadDate, dst.UploadedAt = src.UploadDate, src.UploadedAt
+	}
+	if dst.Description == "" {
+		dst.Description = src.Description
+	}
+	if dst.WebpageURL == "" {
+		dst.WebpageURL = src.WebpageURL
+	}
+	if dst.ExtractorID == "" {
+		dst.Extractor, dst.ExtractorID = src.Extractor, src.ExtractorID
+	}
+	dst.ViewCount = max(dst.ViewCount, src.ViewCount)
+}
+
+// handleFolders serves GET /api/folders?path=..., listing the subfolders of
+// a folder in the videos directory along with the videos directly inside it
+func (s *server) handleFolders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	folder, err := library.CleanRelPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid folder",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	videos := s.videos.InFolder(folder)
+	if videos == nil {
+		videos = []library.Video{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":    folder,
+		"folders": s.videos.Folders(folder),
+		"videos":  videos,
+	})
+}
+
+// handleMoveVideo serves POST /api/videos/{id}/move. The body either gives
+// a template ({"template": "{uploader}/{title}"}) or a folder and/or name
+// ({"folder": "music", "name": "intro"}); unset parts keep their current
+// value and the file extension never changes.
+func (s *server) handleMoveVideo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	v, ok := s.videos.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeNotFound,
+			Message: "Video not found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	var body struct {
+		Template string  `json:"template"`
+		Folder   *string `json:"folder"`
+		Name     string  `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid JSON in request body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	target, err := moveTarget(v, body.Template, body.Folder, body.Name)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid move target",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	moved, err := s.videos.Move(v.ID, target)
+	if err != nil {
+		log.Printf("Failed to move video %s: %v", v.ID, err)
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeFileSystem,
+			Message: "Failed to move video",
+			Details: err.Error(),
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(moved)
+}
+
+// moveTarget works out the new extensionless path for a move request
+func moveTarget(v library.Video, template string, folder *string, name string) (string, error) {
+	if template != "" {
+		return library.ExpandTemplate(template, v)
+	}
+
+	dir := v.Folder()
+	if folder != nil {
+		clean, err := library.CleanRelPath(*folder)
+		if err != nil {
+			return "", err
+		}
+		dir = clean
+	}
+
+	stem := strings.TrimSuffix(v.Filename(), path.Ext(v.Filename()))
+	if name != "" {
+		if strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+			return "", fmt.Errorf("name %q must not contain path separators", name)
+		}
+		stem = strings.TrimSuffix(name, path.Ext(v.Filename()))
+	}
+	return library.CleanRelPath(path.Join(dir, stem))
+}
+
+// attachment builds a Content-Disposition header offering name as the
+// download's file name, quoted or RFC 2231 encoded as name requires
+func attachment(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
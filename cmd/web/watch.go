@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/media"
+	"noahjalex.ute/internal/watch"
+)
+
+// thumbnailWidth is the width of generated thumbnails
+const thumbnailWidth = 640
+
+// startWatcher imports video files dropped into the configured watch folders
+func (s *server) startWatcher(ctx context.Context) {
+	if len(s.cfg.Watch.Dirs) == 0 {
+		return
+	}
+	log.Printf("Watching %s for new videos", strings.Join(s.cfg.Watch.Dirs, ", "))
+
+	w := watch.New(s.cfg.Watch.Dirs, time.Duration(s.cfg.Watch.Interval), func(path string) {
+		if err := s.ingestFile(ctx, path); err != nil {
+			log.Printf("Failed to import %s: %v", path, err)
+		}
+	})
+	go w.Run(ctx)
+}
+
+// ingestFile indexes a video found in a watch folder, first moving it and
+// its sidecars into the videos directory when it lives elsewhere
+func (s *server) ingestFile(ctx context.Context, path string) error {
+	if !library.IsVideoFile(path) {
+		return nil
+	}
+
+	videosDir, err := filepath.Abs(s.cfg.VideosDir)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if rel, err := filepath.Rel(videosDir, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		if _, ok := s.videos.FindByPath(filepath.ToSlash(rel)); ok {
+			return nil
+		}
+	} else {
+		if abs, err = importFiles(abs, videosDir); err != nil {
+			return err
+		}
+	}
+
+	v, err := s.videos.AddFile(abs)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %s into the library", v.FilePath)
+
+	s.enrichVideo(ctx, v)
+	s.publishVideo(events.VideoAdded, v)
+	return s.videos.SaveMetadata()
+}
+
+// importFiles moves a video and its sidecars into videosDir, renaming them
+// when the name is taken, and returns the video's new path
+func importFiles(videoPath, videosDir string) (string, error) {
+	files, err := library.RelatedFiles(videoPath)
+	if err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(videoPath)
+	stem := strings.TrimSuffix(filepath.Base(videoPath), ext)
+	target := stem
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(videosDir, target+ext)); os.IsNotExist(err) {
+			break
+		}
+		target = fmt.Sprintf("%s-%d", stem, i)
+	}
+
+	for _, file := range files {
+		suffix := strings.TrimPrefix(filepath.Base(file), stem)
+		if err := library.MoveFile(file, filepath.Join(videosDir, target+suffix)); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(videosDir, target+ext), nil
+}
+
+// enrichVideo fills in stream details with ffprobe and renders a thumbnail
+// when the video has none. Both are skipped quietly if ffmpeg is missing.
+func (s *server) enrichVideo(ctx context.Context, v library.Video) {
+	info, err := s.probeVideo(ctx, v)
+	if err != nil {
+		log.Printf("Skipping media probe for %s: %v", v.FilePath, err)
+		return
+	}
+
+	if err := s.generateThumbnail(ctx, v, info.Duration); err != nil {
+		log.Printf("Failed to generate thumbnail for %s: %v", v.FilePath, err)
+	}
+}
+
+// generateThumbnail grabs a frame of v as its thumbnail, unless it has
+// one. duration is the video's length in seconds.
+func (s *server) generateThumbnail(ctx context.Context, v library.Video, duration float64) error {
+	path := s.videos.AbsPath(v)
+	if library.ThumbnailPath(path) != "" {
+		return nil
+	}
+	// Grab a frame a little way in to skip black intro frames
+	at := min(duration/10, 30)
+	thumb := strings.TrimSuffix(path, filepath.Ext(path)) + ".jpg"
+	return media.Thumbnail(ctx, path, thumb, at, thumbnailWidth)
+}
+
+// probeVideo records v's stream details from ffprobe
+func (s *server) probeVideo(ctx context.Context, v library.Video) (*media.Info, error) {
+	info, err := media.Probe(ctx, s.videos.AbsPath(v))
+	if err != nil {
+		return nil, err
+	}
+	s.videos.Update(v.ID, func(video *library.Video) {
+		video.Width, video.Height, video.Codec = info.Width, info.Height, info.Codec
+		if video.Duration == 0 {
+			video.Duration = info.Duration
+		}
+	})
+	return info, nil
+}
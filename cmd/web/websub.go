@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"noahjalex.ute/internal/audit"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/websub"
+)
+
+// The WebSub topic is the video list, whose changes are pushed from the
+// hub
+const (
+	webSubTopicPath = "/api/videos"
+	webSubHubPath   = "/api/websub"
+)
+
+// webSubTopic reports whether topic is the video list on some host the
+// server answers to
+func webSubTopic(topic string) bool {
+	u, err := url.Parse(topic)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == webSubTopicPath
+}
+
+// webSubHubURL returns the hub's URL on the topic's host
+func webSubHubURL(topic string) string {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return webSubHubPath
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: webSubHubPath}).String()
+}
+
+// advertiseWebSub sends the Link headers WebSub subscribers discover the
+// hub and topic from
+func advertiseWebSub(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	w.Header().Add("Link", "<"+base+webSubHubPath+`>; rel="hub"`)
+	w.Header().Add("Link", "<"+base+webSubTopicPath+`>; rel="self"`)
+}
+
+// handleWebSub serves the WebSub hub: POST /api/websub takes the hub.mode,
+// hub.callback, hub.topic, hub.secret and hub.lease_seconds form fields of
+// a subscription request, and GET lists the subscriptions. Subscribing
+// makes the server send requests to any URL, so both are for admins only.
+func (s *server) handleWebSub(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, r)
+		return
+	}
+
+	if r.Method == "GET" {
+		json.NewEncoder(w).Encode(s.websub.List())
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid form body",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	req, err := websub.ParseRequest(r.PostForm, webSubTopic)
+	if err != nil {
+		writeError(w, &apperr.DownloadError{
+			Type:    apperr.TypeValidation,
+			Message: "Invalid WebSub request",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	// The spec has the hub answer at once and check with the subscriber
+	// afterwards
+	go func() {
+		if err := s.websub.Verify(context.Background(), req); err != nil {
+			log.Printf("WebSub %s request for %s not verified: %v", req.Mode, req.Callback, err)
+			return
+		}
+		log.Printf("WebSub %s of %s verified", req.Mode, req.Callback)
+	}()
+
+	action := audit.ActionWebSubSubscribe
+	if req.Mode == websub.ModeUnsubscribe {
+		action = audit.ActionWebSubUnsubscribe
+	}
+	s.record(r, action, req.Callback, map[string]string{"topic": req.Topic})
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Request accepted; the callback will be asked to confirm it",
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/pathutil"
+)
+
+// workDirPrefix names the per-job folders inside temp_dir
+const workDirPrefix = "job-"
+
+// workDir is where a job's download is written until it finishes. Keeping
+// it outside the library means half-written files never show up in
+// listings or rescans.
+func (s *server) workDir(jobID string) string {
+	return filepath.Join(s.cfg.TempDir, workDirPrefix+jobID)
+}
+
+// cookiesSuffix names a job's copy of the cookies it signs in with, kept
+// beside its work folder
+const cookiesSuffix = ".cookies.txt"
+
+// copyCookies gives a job its own copy of a stored cookie file, beside its
+// work folder: yt-dlp writes the cookies back when it exits, and anything
+// inside the folder is moved into the library
+func copyCookies(stored, workDir string) (string, error) {
+	data, err := os.ReadFile(stored)
+	if err != nil {
+		return "", err
+	}
+	path := workDir + cookiesSuffix
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0600)
+}
+
+// clearWorkDirs removes job folders and cookies left in tempDir by
+// downloads that were interrupted by a restart. The folders of jobs keep
+// reports true are kept, as those jobs are queued again and resume their
+// partial files.
+func clearWorkDirs(tempDir string, keep func(jobID string) bool) {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), workDirPrefix) || !entry.IsDir() && !strings.HasSuffix(entry.Name(), cookiesSuffix) {
+			continue
+		}
+		if entry.IsDir() && keep(strings.TrimPrefix(entry.Name(), workDirPrefix)) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(tempDir, entry.Name())); err != nil {
+			log.Printf("Failed to remove stale download folder %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// moveIntoLibrary moves everything a download wrote to workDir into the
+// same place under outputDir, returning where each of files ended up.
+// Each video's sidecars go before it so a video never appears without its
+// metadata, and leftover fragments stay behind.
+func moveIntoLibrary(workDir, outputDir string, files []string) ([]string, error) {
+	var videos, others []string
+	err := filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || library.IsFragment(d.Name()) {
+			return nil
+		}
+		if library.IsVideoFile(d.Name()) {
+			videos = append(videos, path)
+		} else {
+			others = append(others, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	moved := make(map[string]string)
+	for _, video := range videos {
+		if _, done := moved[video]; done {
+			continue
+		}
+		if err := moveVideo(workDir, outputDir, video, moved); err != nil {
+			return nil, err
+		}
+	}
+	// Whatever isn't a video's sidecar, such as gallery-dl images
+	for _, src := range others {
+		if _, done := moved[src]; done {
+			continue
+		}
+		rel, err := filepath.Rel(workDir, src)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(outputDir, rel)
+		if _, err := os.Lstat(dst); err == nil {
+			log.Printf("%s already exists, keeping the library's copy", dst)
+		} else if err := library.MoveFile(src, dst); err != nil {
+			return nil, err
+		}
+		moved[src] = dst
+	}
+
+	var result []string
+	for _, file := range files {
+		if dst, ok := moved[file]; ok {
+			if abs, err := filepath.Abs(dst); err == nil {
+				dst = abs
+			}
+			result = append(result, dst)
+		}
+	}
+	return result, nil
+}
+
+// moveVideo moves a downloaded video and its sidecars into outputDir,
+// recording each file's destination in moved. A video already in the
+// library is kept rather than replaced. When a different video has the
+// same name, as happens with titles in the output template, the download
+// is renamed to "name [id]" instead of overwriting or being mistaken for it.
+func moveVideo(workDir, outputDir, video string, moved map[string]string) error {
+	rel, err := filepath.Rel(workDir, video)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(rel)
+	stem := strings.TrimSuffix(rel, ext)
+	oldStem := strings.TrimSuffix(video, filepath.Ext(video))
+
+	id := ""
+	if info, err := library.LoadVideoMetadata(video); err == nil {
+		id = info.ID
+	}
+
+	target := filepath.Join(outputDir, stem)
+	for n := 1; ; n++ {
+		existing := target + ext
+		if _, err := os.Lstat(existing); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if id != "" && existingID(existing) == id {
+			log.Printf("%s already exists, keeping the library's copy", existing)
+			files, err := library.RelatedFiles(video)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				moved[file] = target + strings.TrimPrefix(file, oldStem)
+			}
+			return nil
+		}
+
+		// Another video has this name
+		if id != "" && n == 1 {
+			target = filepath.Join(outputDir, stem) + " [" + pathutil.SanitizeFilename(id) + "]"
+		} else {
+			target = fmt.Sprintf("%s-%d", filepath.Join(outputDir, stem), n)
+		}
+	}
+	if target != filepath.Join(outputDir, stem) {
+		log.Printf("%s is taken by another video, saving as %s", filepath.Join(outputDir, rel), target+ext)
+	}
+
+	files, err := library.RelatedFiles(video)
+	if err != nil {
+		return err
+	}
+	var sidecars []string
+	for _, file := range files {
+		if file != video && !library.IsFragment(file) {
+			sidecars = append(sidecars, file)
+		}
+	}
+	for _, file := range append(sidecars, video) {
+		dst := target + strings.TrimPrefix(file, oldStem)
+		if err := library.MoveFile(file, dst); err != nil {
+			return err
+		}
+		moved[file] = dst
+	}
+	return nil
+}
+
+// existingID returns the source ID recorded beside a library video, "" if
+// it has none
+func existingID(video string) string {
+	info, err := library.LoadVideoMetadata(video)
+	if err != nil {
+		return ""
+	}
+	return info.ID
+}
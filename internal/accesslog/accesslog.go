@@ -0,0 +1,224 @@
+// Package accesslog writes one line per HTTP request to a file of its own,
+// in the Common or Combined Log Format that tools such as fail2ban and
+// GoAccess read, or as JSON. The file is rotated once it grows too large
+// or gets too old, keeping a few of the previous files beside it.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// Entry is a request served
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Remote is the client's address without its port
+	Remote string `json:"remote"`
+	// User is the signed-in user's name, if any
+	User      string        `json:"user,omitempty"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// Log appends entries to a file. It is safe for concurrent use.
+type Log struct {
+	path   string
+	format string
+	// maxSize and maxAge rotate the file; 0 disables each
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// written is when the file was last written to
+	written time.Time
+	failed  bool
+}
+
+// Open appends to the log at path in format, keeping maxFiles rotated
+// files. The file is rotated once it would grow past maxSize bytes, and
+// when a request comes in a new period of maxAge, counted from midnight
+// UTC, so a maxAge of 24h starts a file each day.
+func Open(path, format string, maxSize int64, maxAge time.Duration, maxFiles int) (*Log, error) {
+	l := &Log{path: path, format: format, maxSize: maxSize, maxAge: maxAge, maxFiles: maxFiles}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openFile opens the current file. The caller holds l.mu or has the log
+// to itself.
+func (l *Log) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.written = f, fi.Size(), fi.ModTime()
+	return nil
+}
+
+// Write records e. Failures are logged once rather than returned, so a
+// full disk never fails the request being logged.
+func (l *Log) Write(e Entry) {
+	line := l.line(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.due(int64(len(line))) {
+		if err := l.rotate(); err != nil {
+			l.fail(err)
+		}
+	}
+	if l.f == nil {
+		return
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	l.written = e.Time
+	if err != nil {
+		l.fail(err)
+	}
+}
+
+func (l *Log) fail(err error) {
+	if !l.failed {
+		log.Printf("Failed to write the access log %s: %v", l.path, err)
+		l.failed = true
+	}
+}
+
+// due reports whether the file must be rotated before n more bytes. The
+// caller holds l.mu.
+func (l *Log) due(n int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxSize > 0 && l.size+n > l.maxSize {
+		return true
+	}
+	return l.maxAge > 0 && !time.Now().Truncate(l.maxAge).Equal(l.written.Truncate(l.maxAge))
+}
+
+// rotate moves the current file to path.1, shifting older ones up and
+// dropping those past maxFiles. The caller holds l.mu.
+func (l *Log) rotate() error {
+	l.f.Close()
+	l.f = nil
+	if l.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+		for n := l.maxFiles - 1; n >= 1; n-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, n), fmt.Sprintf("%s.%d", l.path, n+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.openFile()
+}
+
+// Close closes the file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// line renders e in the log's format
+func (l *Log) line(e Entry) []byte {
+	if l.format == FormatJSON {
+		line, err := json.Marshal(struct {
+			Entry
+			DurationMS float64 `json:"duration_ms"`
+		}{e, float64(e.Duration.Microseconds()) / 1000})
+		if err != nil {
+			return nil
+		}
+		return append(line, '\n')
+	}
+
+	// host ident authuser [date] "request" status bytes
+	s := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		dash(e.Remote), dash(quoteless(e.User)), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, quoteless(e.URI), e.Proto, e.Status, size(e.Bytes))
+	if l.format == FormatCombined {
+		s += fmt.Sprintf(" \"%s\" \"%s\"", dash(quoteless(e.Referer)), dash(quoteless(e.UserAgent)))
+	}
+	return []byte(s + "\n")
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// size is a response size as CLF writes it, "-" for none
+func size(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+// quoteless escapes quotes, backslashes and control characters so a
+// client can't forge fields or lines
+func quoteless(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r == '"' || r == '\\' || r < 0x20 || r == 0x7f }) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+// Package alerts watches downloads and disk space for trouble someone
+// should hear about: many downloads failing, a disk running out of space,
+// or yt-dlp failing every download from a site.
+package alerts
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/events"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+// Kind names what an alert is about
+type Kind string
+
+const (
+	KindFailureRate Kind = "failure_rate"
+	KindDiskSpace   Kind = "disk_space"
+	KindSiteFailing Kind = "site_failing"
+)
+
+// Alert is a problem found by a Monitor
+type Alert struct {
+	Kind Kind `json:"kind"`
+	// Subject tells alerts of one kind apart, such as the site or folder
+	Subject string    `json:"subject,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// outcome is a finished download
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// Monitor raises alerts as the thresholds in its config are crossed,
+// passing them to send. It is safe for concurrent use.
+type Monitor struct {
+	cfg  config.Alerts
+	send func(Alert)
+
+	mu       sync.Mutex
+	outcomes []outcome
+	// streaks count each site's unexplained failures in a row
+	streaks map[string]int
+	// sent is when each kind and subject was last alerted
+	sent map[string]time.Time
+}
+
+// NewMonitor creates a monitor passing alerts to send
+func NewMonitor(cfg config.Alerts, send func(Alert)) *Monitor {
+	return &Monitor{
+		cfg:     cfg,
+		send:    send,
+		streaks: make(map[string]int),
+		sent:    make(map[string]time.Time),
+	}
+}
+
+// Handle counts a finished download; subscribe it to the event bus for
+// completed and failed downloads
+func (m *Monitor) Handle(e events.Event) {
+	if e.Job == nil || (e.Type != events.DownloadCompleted && e.Type != events.DownloadFailed) {
+		return
+	}
+	failed := e.Type == events.DownloadFailed
+	var alerts []Alert
+
+	m.mu.Lock()
+	if a, ok := m.failureRate(e.Time, failed); ok {
+		alerts = append(alerts, a)
+	}
+	if a, ok := m.siteStreak(e.Time, *e.Job, failed); ok {
+		alerts = append(alerts, a)
+	}
+	m.mu.Unlock()
+
+	for _, a := range alerts {
+		m.send(a)
+	}
+}
+
+// failureRate records the outcome and checks the share of failures within
+// the window. The caller holds m.mu.
+func (m *Monitor) failureRate(now time.Time, failed bool) (Alert, bool) {
+	if m.cfg.FailureRate <= 0 {
+		return Alert{}, false
+	}
+	cutoff := now.Add(-time.Duration(m.cfg.Window))
+	kept := m.outcomes[:0]
+	for _, o := range m.outcomes {
+		if o.at.After(cutoff) {
+			kept = append(kept, o)
+		}
+	}
+	m.outcomes = append(kept, outcome{at: now, failed: failed})
+
+	failures := 0
+	for _, o := range m.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	total := len(m.outcomes)
+	if !failed || total < m.cfg.MinDownloads || float64(failures) < m.cfg.FailureRate*float64(total) {
+		return Alert{}, false
+	}
+	return m.raise(now, KindFailureRate, "", fmt.Sprintf("%d of the last %d downloads failed within %s",
+		failures, total, time.Duration(m.cfg.Window)))
+}
+
+// siteStreak counts the job's site's failures in a row that yt-dlp gave
+// no known reason for; a download that works ends the streak. The caller
+// holds m.mu.
+func (m *Monitor) siteStreak(now time.Time, job jobs.Job, failed bool) (Alert, bool) {
+	site := jobs.Domain(job.URL)
+	if m.cfg.SiteFailures <= 0 || site == "" {
+		return Alert{}, false
+	}
+	if !failed {
+		delete(m.streaks, site)
+		return Alert{}, false
+	}
+	// Missing videos, geo blocks and the like say nothing about yt-dlp
+	if n := len(job.Attempts); n == 0 || job.Attempts[n-1].ErrorType != apperr.TypeUnknown {
+		return Alert{}, false
+	}
+	m.streaks[site]++
+	if m.streaks[site] < m.cfg.SiteFailures {
+		return Alert{}, false
+	}
+	return m.raise(now, KindSiteFailing, site, fmt.Sprintf("The last %d downloads from %s failed; yt-dlp may need updating",
+		m.streaks[site], site))
+}
+
+// CheckDisk alerts when the filesystem of any of dirs has less free space
+// than the config allows
+func (m *Monitor) CheckDisk(dirs []string) error {
+	if m.cfg.MinFreeBytes <= 0 {
+		return nil
+	}
+	var firstErr error
+	for _, dir := range dirs {
+		free, _, err := library.DiskUsage(dir)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("checking free space of %s: %w", dir, err)
+			}
+			continue
+		}
+		if free >= uint64(m.cfg.MinFreeBytes) {
+			continue
+		}
+		m.mu.Lock()
+		a, ok := m.raise(time.Now(), KindDiskSpace, dir, fmt.Sprintf("Only %d MiB free on the disk holding %s", free>>20, dir))
+		m.mu.Unlock()
+		if ok {
+			m.send(a)
+		}
+	}
+	return firstErr
+}
+
+// raise returns the alert unless the same one was sent less than the
+// repeat interval ago. The caller holds m.mu.
+func (m *Monitor) raise(now time.Time, kind Kind, subject, message string) (Alert, bool) {
+	key := string(kind) + "\x00" + subject
+	if last, ok := m.sent[key]; ok && now.Sub(last) < time.Duration(m.cfg.Repeat) {
+		return Alert{}, false
+	}
+	m.sent[key] = now
+	return Alert{Kind: kind, Subject: subject, Message: message, Time: now}, true
+}
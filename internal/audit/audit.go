@@ -0,0 +1,162 @@
+// Package audit records who did what to the library in an append-only log,
+// one JSON entry per line in the data directory.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the log
+const (
+	ActionDownload             = "download.submit"
+	ActionVideoDelete          = "video.delete"
+	ActionVideoUpgrade         = "video.upgrade"
+	ActionAlbumDelete          = "album.delete"
+	ActionDuplicateMerge       = "duplicates.merge"
+	ActionSettings             = "settings.update"
+	ActionSubscribe            = "subscription.create"
+	ActionSubscriptionUpdate   = "subscription.update"
+	ActionUnsubscribe          = "subscription.delete"
+	ActionSubscriptionBackfill = "subscription.backfill"
+	ActionTaskRun              = "task.run"
+	ActionTaskPause            = "task.pause"
+	ActionTaskResume           = "task.resume"
+	ActionCookiesUpdate        = "cookies.update"
+	ActionCookiesDelete        = "cookies.delete"
+	ActionBudgetReset          = "budget.reset"
+	ActionConfigReload         = "config.reload"
+	ActionWebSubSubscribe      = "websub.subscribe"
+	ActionWebSubUnsubscribe    = "websub.unsubscribe"
+)
+
+// Entry is one recorded action
+type Entry struct {
+	// Seq numbers entries from 1 in the order they were written
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	// Target is what was acted on: a URL, video ID, subscription ID, ...
+	Target  string            `json:"target,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Log appends entries to audit.log. Entries are never rewritten, so the
+// file can be shipped or rotated by outside tools.
+type Log struct {
+	path string
+
+	mu   sync.Mutex
+	seq  int64
+	file *os.File
+}
+
+// Open opens the log in dataDir, continuing the numbering of any entries
+// already in it
+func Open(dataDir string) (*Log, error) {
+	l := &Log{path: filepath.Join(dataDir, "audit.log")}
+	if err := l.scan(func(e Entry) { l.seq = max(l.seq, e.Seq) }); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+// Record appends an entry for action. Failures are returned for logging;
+// the action itself has already happened.
+func (l *Log) Record(actor, action, target string, details map[string]string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Entry{
+		Seq:     l.seq + 1,
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// One write per entry keeps lines whole under O_APPEND
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing %s: %w", l.path, err)
+	}
+	l.seq = e.Seq
+	return nil
+}
+
+// Filter narrows the entries returned by Page. Empty fields match anything.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since))
+}
+
+// Page returns up to limit entries matching f, newest first, skipping the
+// first offset of them, along with how many match in total
+func (l *Log) Page(f Filter, offset, limit int) ([]Entry, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matched []Entry
+	err := l.scan(func(e Entry) {
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(matched)
+	page := make([]Entry, 0, limit)
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, matched[i])
+	}
+	return page, total, nil
+}
+
+// scan calls fn for each entry in the file, oldest first. A torn last line
+// from a crash is skipped.
+func (l *Log) scan(fn func(Entry)) error {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,196 @@
+// Package budget counts the bytes downloaded each month against a data
+// budget, for servers on a metered connection. The count is persisted in
+// the data directory so it survives restarts, and starts over on the
+// configured day of each month.
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the current period's use of the budget
+type Status struct {
+	// PeriodStart and PeriodEnd bound the month being counted, in local
+	// time
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Bytes       int64     `json:"bytes"`
+	// Limit is the budget in bytes; 0 when there is none
+	Limit int64 `json:"limit"`
+	// Percent is Bytes as a percentage of Limit
+	Percent float64 `json:"percent"`
+	// Warned is the highest warning threshold reached, a percentage
+	Warned   int  `json:"warned,omitempty"`
+	Exceeded bool `json:"exceeded"`
+	// ResetAt is when the count was last reset by hand this period
+	ResetAt *time.Time `json:"reset_at,omitempty"`
+}
+
+// saved is budget.json
+type saved struct {
+	PeriodStart time.Time  `json:"period_start"`
+	Bytes       int64      `json:"bytes"`
+	Warned      int        `json:"warned,omitempty"`
+	ResetAt     *time.Time `json:"reset_at,omitempty"`
+}
+
+// Counter tracks the bytes downloaded in the current period. It is safe
+// for concurrent use.
+type Counter struct {
+	path  string
+	limit int64
+	// warnAt are the thresholds, as percentages of limit, lowest first
+	warnAt   []int
+	resetDay int
+
+	mu    sync.Mutex
+	state saved
+}
+
+// NewCounter counts against limit bytes a month, starting over on
+// resetDay, and persists to dataDir. A zero limit only counts.
+func NewCounter(dataDir string, limit int64, warnAt []int, resetDay int) *Counter {
+	thresholds := append([]int(nil), warnAt...)
+	sort.Ints(thresholds)
+	return &Counter{
+		path:     filepath.Join(dataDir, "budget.json"),
+		limit:    limit,
+		warnAt:   thresholds,
+		resetDay: min(max(resetDay, 1), 28),
+	}
+}
+
+// Load reads the persisted count, if any
+func (c *Counter) Load() error {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var state saved
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing %s: %w", c.path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	return nil
+}
+
+// Add counts n bytes downloaded now. It returns the highest warning
+// threshold the bytes crossed, or 0, and whether they used up the budget;
+// each is reported once a period.
+func (c *Counter) Add(n int64) (warn int, exceeded bool, err error) {
+	if n <= 0 {
+		return 0, false, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(time.Now())
+	before := c.state.Bytes
+	c.state.Bytes += n
+
+	if c.limit > 0 {
+		percent := c.state.Bytes * 100 / c.limit
+		for _, t := range c.warnAt {
+			if int64(t) <= percent && t > c.state.Warned {
+				warn = t
+			}
+		}
+		if warn > 0 {
+			c.state.Warned = warn
+		}
+		exceeded = before < c.limit && c.state.Bytes >= c.limit
+	}
+	return warn, exceeded, c.save()
+}
+
+// Exceeded reports whether the budget is used up for this period
+func (c *Counter) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(time.Now())
+	return c.limit > 0 && c.state.Bytes >= c.limit
+}
+
+// Status returns the current period's use
+func (c *Counter) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.roll(now)
+
+	st := Status{
+		PeriodStart: c.state.PeriodStart,
+		PeriodEnd:   c.state.PeriodStart.AddDate(0, 1, 0),
+		Bytes:       c.state.Bytes,
+		Limit:       c.limit,
+		Warned:      c.state.Warned,
+		ResetAt:     c.state.ResetAt,
+	}
+	if c.limit > 0 {
+		st.Percent = float64(c.state.Bytes) * 100 / float64(c.limit)
+		st.Exceeded = c.state.Bytes >= c.limit
+	}
+	return st
+}
+
+// Reset starts the current period's count over, such as after buying more
+// data
+func (c *Counter) Reset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.roll(now)
+	c.state.Bytes = 0
+	c.state.Warned = 0
+	c.state.ResetAt = &now
+	return c.save()
+}
+
+// roll starts a new period once the current one has ended. The caller
+// holds c.mu.
+func (c *Counter) roll(now time.Time) {
+	start := c.periodStart(now)
+	if c.state.PeriodStart.Equal(start) {
+		return
+	}
+	c.state = saved{PeriodStart: start}
+}
+
+// periodStart is the start of the period now falls in: midnight on the
+// reset day of this month, or of last month before the reset day
+func (c *Counter) periodStart(now time.Time) time.Time {
+	year, month, day := now.Date()
+	if day < c.resetDay {
+		month--
+	}
+	return time.Date(year, month, c.resetDay, 0, 0, 0, 0, now.Location())
+}
+
+// save writes the count. The caller holds c.mu.
+func (c *Counter) save() error {
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
@@ -0,0 +1,311 @@
+// Package cluster lets several servers share their download jobs through a
+// directory on shared storage, such as an NFS mount. Each job is a file on
+// the board that every node reads. A node runs a job only after claiming
+// a lease on it, renews the lease with its heartbeat while the download
+// runs, and releases it when the job finishes. When a node stops renewing,
+// such as after crashing, its leases expire and another node takes the
+// jobs over.
+//
+// Leases compare wall-clock times written by different machines, so the
+// nodes' clocks must be kept in sync, e.g. with NTP.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+)
+
+// Node roles
+const (
+	// RoleAll takes downloads and runs them
+	RoleAll = "all"
+	// RoleUI takes downloads and leaves running them to the others
+	RoleUI = "ui"
+	// RoleWorker runs downloads without adding other nodes' to its library
+	RoleWorker = "worker"
+)
+
+// ErrLeaseLost is returned when renewing a lease another node has taken
+// over, after this one let it expire
+var ErrLeaseLost = errors.New("lease taken over by another node")
+
+// Entry is a job on the board
+type Entry struct {
+	Job jobs.Job `json:"job"`
+	// Origin is the node the job was submitted to
+	Origin string `json:"origin"`
+	// Node is the node running or last to run the job
+	Node string `json:"node,omitempty"`
+	// Files are what a completed job downloaded, relative to the videos
+	// directory the nodes share
+	Files []string `json:"files,omitempty"`
+}
+
+// Lease is a node's claim on a job. Each claim takes the next generation,
+// so only one node can win a job however many try at once.
+type Lease struct {
+	Node       string    `json:"node"`
+	Generation int       `json:"generation"`
+	Expires    time.Time `json:"expires"`
+}
+
+// Node is a server's last heartbeat
+type Node struct {
+	Name string    `json:"name"`
+	Role string    `json:"role"`
+	Seen time.Time `json:"seen"`
+	// Running are the jobs the node holds leases on
+	Running []string `json:"running"`
+	// Alive is set while the node's heartbeats keep coming
+	Alive bool `json:"alive"`
+}
+
+// Board is the shared directory as seen by one node
+type Board struct {
+	dir  string
+	node string
+	// lease is how long a claim lasts without being renewed
+	lease time.Duration
+}
+
+// NewBoard opens the board in dir for the node named node, whose claims
+// last lease between renewals
+func NewBoard(dir, node string, lease time.Duration) (*Board, error) {
+	b := &Board{dir: dir, node: node, lease: lease}
+	for _, sub := range []string{"jobs", "leases", "nodes", "cancel"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Node is the name of this node
+func (b *Board) Node() string {
+	return b.node
+}
+
+func (b *Board) entryPath(id string) string {
+	return filepath.Join(b.dir, "jobs", id+".json")
+}
+
+func (b *Board) leaseDir(id string) string {
+	return filepath.Join(b.dir, "leases", id)
+}
+
+func (b *Board) cancelPath(id string) string {
+	return filepath.Join(b.dir, "cancel", id)
+}
+
+// writeFile replaces path with data through a temporary file, so readers
+// on other nodes never see half of it
+func (b *Board) writeFile(path string, data []byte) error {
+	tmp := fmt.Sprintf("%s.%s.tmp", path, b.node)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Put writes e to the board, replacing any earlier version of its job
+func (b *Board) Put(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(b.entryPath(e.Job.ID), data)
+}
+
+// List returns every job on the board. Entries that can't be read, such
+// as one being replaced over NFS, are skipped until the next call.
+func (b *Board) List() ([]Entry, error) {
+	files, err := os.ReadDir(filepath.Join(b.dir, "jobs"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(b.dir, "jobs", f.Name()))
+		if err != nil {
+			continue
+		}
+		var e Entry
+		if json.Unmarshal(data, &e) != nil || e.Job.ID == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Holder returns the newest lease on the job with id, expired or not
+func (b *Board) Holder(id string) (Lease, bool) {
+	files, err := os.ReadDir(b.leaseDir(id))
+	if err != nil {
+		return Lease{}, false
+	}
+	gen := 0
+	for _, f := range files {
+		if n, err := strconv.Atoi(f.Name()); err == nil && n > gen {
+			gen = n
+		}
+	}
+	if gen == 0 {
+		return Lease{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(b.leaseDir(id), strconv.Itoa(gen)))
+	if err != nil {
+		return Lease{}, false
+	}
+	var l Lease
+	if err := json.Unmarshal(data, &l); err != nil {
+		return Lease{}, false
+	}
+	return l, true
+}
+
+// Claim takes the job with id for this node unless another node holds an
+// unexpired lease on it, reporting whether this node now holds it
+func (b *Board) Claim(id string) (bool, error) {
+	current, held := b.Holder(id)
+	now := time.Now()
+	if held && current.Node != b.node && now.Before(current.Expires) {
+		return false, nil
+	}
+	if err := os.MkdirAll(b.leaseDir(id), 0755); err != nil {
+		return false, err
+	}
+
+	l := Lease{Node: b.node, Generation: current.Generation + 1, Expires: now.Add(b.lease)}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+	// Linking the written file into place fails if another node created
+	// the generation first, which makes the claim atomic
+	path := filepath.Join(b.leaseDir(id), strconv.Itoa(l.Generation))
+	tmp := fmt.Sprintf("%s.%s.tmp", path, b.node)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// Older generations stay until the job leaves the board, so a node
+	// acting on a stale read can't reuse one
+	return true, nil
+}
+
+// Renew extends this node's lease on the job with id
+func (b *Board) Renew(id string) error {
+	current, held := b.Holder(id)
+	if !held || current.Node != b.node {
+		return ErrLeaseLost
+	}
+	current.Expires = time.Now().Add(b.lease)
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(filepath.Join(b.leaseDir(id), strconv.Itoa(current.Generation)), data)
+}
+
+// Release gives up this node's lease on the job with id, leaving it
+// expired so the next claim still takes a new generation
+func (b *Board) Release(id string) error {
+	current, held := b.Holder(id)
+	if !held || current.Node != b.node {
+		return nil
+	}
+	current.Expires = time.Time{}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(filepath.Join(b.leaseDir(id), strconv.Itoa(current.Generation)), data)
+}
+
+// Live reports whether l is unexpired
+func (l Lease) Live() bool {
+	return time.Now().Before(l.Expires)
+}
+
+// RequestCancel asks the node running the job with id to stop it
+func (b *Board) RequestCancel(id string) error {
+	return b.writeFile(b.cancelPath(id), []byte(b.node))
+}
+
+// CancelRequested reports whether a node asked for the job with id to be
+// stopped, clearing the request
+func (b *Board) CancelRequested(id string) bool {
+	return os.Remove(b.cancelPath(id)) == nil
+}
+
+// Beat records this node's heartbeat with the jobs it is running
+func (b *Board) Beat(role string, running []string) error {
+	sort.Strings(running)
+	data, err := json.Marshal(Node{Name: b.node, Role: role, Seen: time.Now(), Running: running})
+	if err != nil {
+		return err
+	}
+	return b.writeFile(filepath.Join(b.dir, "nodes", b.node+".json"), data)
+}
+
+// Nodes returns every node that has sent a heartbeat, by name. Nodes
+// silent for longer than a lease are not alive.
+func (b *Board) Nodes() ([]Node, error) {
+	files, err := os.ReadDir(filepath.Join(b.dir, "nodes"))
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(b.dir, "nodes", f.Name()))
+		if err != nil {
+			continue
+		}
+		var n Node
+		if json.Unmarshal(data, &n) != nil {
+			continue
+		}
+		n.Alive = time.Since(n.Seen) < b.lease
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+// Remove deletes the job with id from the board with its lease
+func (b *Board) Remove(id string) error {
+	os.RemoveAll(b.leaseDir(id))
+	os.Remove(b.cancelPath(id))
+	err := os.Remove(b.entryPath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
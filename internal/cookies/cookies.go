@@ -0,0 +1,198 @@
+// Package cookies keeps browser cookies exported for sites that only serve
+// some videos to a signed-in account, such as age-restricted ones. Each
+// site's cookies are a Netscape cookie file in the data directory, the
+// format yt-dlp and gallery-dl read with --cookies.
+package cookies
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxSize bounds a cookie file, which is a few kilobytes for any one site
+const MaxSize = 1 << 20
+
+// Site describes the cookies stored for a domain. The cookies themselves
+// are never returned, as they sign in to someone's account.
+type Site struct {
+	Domain    string    `json:"domain"`
+	Cookies   int       `json:"cookies"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store holds cookie files in a directory
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewStore keeps cookie files in dataDir/cookies
+func NewStore(dataDir string) *Store {
+	return &Store{dir: filepath.Join(dataDir, "cookies")}
+}
+
+func (s *Store) path(domain string) string {
+	return filepath.Join(s.dir, domain+".txt")
+}
+
+// NormalizeDomain lowercases domain and strips a leading "." or "www.",
+// rejecting anything that isn't a host name
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, ".")
+	domain = strings.TrimPrefix(domain, "www.")
+	if domain == "" || !strings.Contains(domain, ".") || strings.Contains(domain, "..") {
+		return "", fmt.Errorf("%q is not a domain such as youtube.com", domain)
+	}
+	for _, c := range domain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return "", fmt.Errorf("%q is not a domain such as youtube.com", domain)
+		}
+	}
+	return domain, nil
+}
+
+// List returns the sites with stored cookies, by domain
+func (s *Store) List() ([]Site, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []Site{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sites := []Site{}
+	for _, e := range entries {
+		domain, ok := strings.CutSuffix(e.Name(), ".txt")
+		if !ok || e.IsDir() {
+			continue
+		}
+		site, err := s.site(domain)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].Domain < sites[j].Domain })
+	return sites, nil
+}
+
+// site describes the stored file of domain. The caller holds s.mu.
+func (s *Store) site(domain string) (Site, error) {
+	data, err := os.ReadFile(s.path(domain))
+	if err != nil {
+		return Site{}, err
+	}
+	fi, err := os.Stat(s.path(domain))
+	if err != nil {
+		return Site{}, err
+	}
+	n, _ := parse(data)
+	return Site{Domain: domain, Cookies: n, UpdatedAt: fi.ModTime()}, nil
+}
+
+// Put stores data, a Netscape cookie file, as the cookies of domain,
+// replacing any stored before
+func (s *Store) Put(domain string, data []byte) (Site, error) {
+	domain, err := NormalizeDomain(domain)
+	if err != nil {
+		return Site{}, err
+	}
+	if len(data) > MaxSize {
+		return Site{}, fmt.Errorf("cookie file is larger than %d bytes", MaxSize)
+	}
+	if _, err := parse(data); err != nil {
+		return Site{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the server should read someone's sign-in
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return Site{}, err
+	}
+	tmp := s.path(domain) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return Site{}, err
+	}
+	if err := os.Rename(tmp, s.path(domain)); err != nil {
+		os.Remove(tmp)
+		return Site{}, err
+	}
+	return s.site(domain)
+}
+
+// Delete removes the cookies of domain, reporting whether there were any
+func (s *Store) Delete(domain string) (bool, error) {
+	domain, err := NormalizeDomain(domain)
+	if err != nil {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.Remove(s.path(domain))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// For returns the cookie file for host, a host name without a port, from
+// the most specific domain stored that host is or is under, e.g.
+// youtube.com for m.youtube.com
+func (s *Store) For(host string) (string, bool) {
+	host = strings.ToLower(host)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for domain := host; strings.Contains(domain, "."); {
+		if _, err := os.Stat(s.path(domain)); err == nil {
+			return s.path(domain), true
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return "", false
+}
+
+// parse counts the cookies in a Netscape cookie file, rejecting files in
+// any other format so a mistaken upload is caught before a download uses
+// it
+func parse(data []byte) (int, error) {
+	n := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), MaxSize)
+	for line := 1; scanner.Scan(); line++ {
+		// Cookies with empty values end in a tab, so only line endings
+		// are trimmed
+		text := strings.TrimRight(scanner.Text(), "\r")
+		// Browser exports mark HttpOnly cookies with this prefix
+		text = strings.TrimPrefix(text, "#HttpOnly_")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if fields := strings.Split(text, "\t"); len(fields) != 7 {
+			return 0, fmt.Errorf("line %d: expected 7 tab-separated fields of a Netscape cookie file, got %d", line, len(fields))
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no cookies found; export them in the Netscape cookies.txt format")
+	}
+	return n, nil
+}
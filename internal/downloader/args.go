@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+
+	apperr "noahjalex.ute/internal/errors"
+)
+
+// ArgValidator is implemented by backends that accept extra per-request
+// command-line arguments
+type ArgValidator interface {
+	ValidateArgs(args []string) error
+}
+
+// ArgError describes a rejected extra argument
+type ArgError struct {
+	Arg    string
+	Reason string
+}
+
+func (e *ArgError) Error() string {
+	return fmt.Sprintf("argument %q %s", e.Arg, e.Reason)
+}
+
+// Is classifies argument errors as validation failures
+func (e *ArgError) Is(target error) bool {
+	return target == apperr.ErrValidation
+}
+
+// forbiddenArgs can write to arbitrary paths or run commands and are
+// rejected even if an operator adds them to the safelist
+var forbiddenArgs = map[string]bool{
+	"-o":                         true,
+	"--output":                   true,
+	"-P":                         true,
+	"--paths":                    true,
+	"--exec":                     true,
+	"--exec-before-download":     true,
+	"--config-location":          true,
+	"--config-locations":         true,
+	"-a":                         true,
+	"--batch-file":               true,
+	"--netrc-cmd":                true,
+	"--external-downloader":      true,
+	"--downloader":               true,
+	"--downloader-args":          true,
+	"--external-downloader-args": true,
+	"--postprocessor-args":       true,
+	"--ppa":                      true,
+	"--use-postprocessor":        true,
+	"--plugin-dirs":              true,
+	"--cache-dir":                true,
+	"--load-info-json":           true,
+	"--cookies":                  true,
+}
+
+// validateArgs checks args against a safelist mapping each allowed flag to
+// the number of values it takes. Flags may also be written as --flag=value.
+func validateArgs(args []string, allowed map[string]int) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return &ArgError{Arg: arg, Reason: "is not a flag"}
+		}
+
+		name, _, hasValue := strings.Cut(arg, "=")
+		if forbiddenArgs[name] {
+			return &ArgError{Arg: name, Reason: "is not allowed"}
+		}
+
+		arity, ok := allowed[name]
+		if !ok {
+			return &ArgError{Arg: name, Reason: "is not in the allowed argument list"}
+		}
+
+		if hasValue {
+			if arity != 1 {
+				return &ArgError{Arg: name, Reason: "does not take an inline value"}
+			}
+			continue
+		}
+
+		if i+arity > len(args)-1 {
+			return &ArgError{Arg: name, Reason: fmt.Sprintf("expects %d value(s)", arity)}
+		}
+		// Values are consumed verbatim, as yt-dlp's option parser does
+		i += arity
+	}
+	return nil
+}
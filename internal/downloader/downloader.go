@@ -0,0 +1,140 @@
+// Package downloader fetches media for a URL using one of several backends
+// (yt-dlp, gallery-dl, plain HTTP), chosen by matching the URL.
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Request describes a single download
+type Request struct {
+	URL       string
+	OutputDir string
+	// ExtraArgs are user-supplied flags, only accepted by backends
+	// implementing ArgValidator
+	ExtraArgs []string
+	// Format is the format selector used unless ExtraArgs choose one
+	Format string
+	// OutputTemplate names the files inside OutputDir, in place of the
+	// backend's own template, when set
+	OutputTemplate string
+	// Proxy routes the download through a proxy URL when set
+	Proxy string
+	// Archive is a file recording every video downloaded. Backends that
+	// support it skip videos already listed and stop walking a playlist at
+	// the first one, unless Backfill is set.
+	Archive string
+	// Backfill walks the whole playlist, skipping archived videos rather
+	// than stopping at the first
+	Backfill bool
+	// PlaylistEnd limits a playlist to its first entries when set
+	PlaylistEnd int
+	// PlaylistItems picks a playlist's entries by position, such as
+	// "1-20" or "-5:", when set
+	PlaylistItems string
+	// DateAfter and DateBefore skip entries uploaded before or after them,
+	// as YYYY-MM-DD, when set
+	DateAfter  string
+	DateBefore string
+	// MaxFileSize skips entries larger than it, in bytes, when set
+	MaxFileSize int64
+	// Section limits the download to part of the video when set
+	Section *Section
+	// Languages, when set, skips videos the site says are in other
+	// languages. Videos without a language are kept.
+	Languages []string
+	// Filter, when set, skips playlist entries it doesn't match
+	Filter *Filter
+	// Site holds settings the site needs before it will serve the video
+	Site SiteOptions
+	// Cookies is a Netscape cookie file sent to the site, for videos only
+	// served to a signed-in account. yt-dlp writes it back when done.
+	Cookies string
+	// Progress, if set, is called as the backend reports progress
+	Progress func(Progress)
+	// Log, if set, receives everything the backend's process prints to
+	// stdout and stderr as it is printed
+	Log io.Writer
+}
+
+// Section is part of a video, in seconds from its start
+type Section struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// SiteOptions are yt-dlp settings some sites need before they will serve
+// a video at all
+type SiteOptions struct {
+	// ExtractorArgs are passed as --extractor-args, by extractor, e.g.
+	// "youtube": "player_client=web_safari,android"
+	ExtractorArgs map[string]string
+	// Impersonate is the browser whose requests yt-dlp imitates with
+	// --impersonate, e.g. "chrome" (needs curl_cffi)
+	Impersonate string
+}
+
+// Result describes what a backend produced
+type Result struct {
+	Backend string
+	// Files lists absolute paths of written files when the backend knows them
+	Files []string
+	// Output is the backend's stdout, kept for logging
+	Output string
+}
+
+// Downloader is a download backend
+type Downloader interface {
+	// Name identifies the backend in logs and API responses
+	Name() string
+	// Match reports whether the backend should handle u
+	Match(u *url.URL) bool
+	// Check verifies the backend's dependencies are available
+	Check(ctx context.Context) error
+	Download(ctx context.Context, req Request) (*Result, error)
+}
+
+// ExecError is returned when a backend's external process fails
+type ExecError struct {
+	Backend string
+	Err     error
+	Stderr  string
+	Stdout  string
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Backend, e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessStderr exposes the process output for error classification
+func (e *ExecError) ProcessStderr() string {
+	return e.Stderr
+}
+
+// Registry picks the first matching backend, falling back to a default
+type Registry struct {
+	backends []Downloader
+	fallback Downloader
+}
+
+// NewRegistry creates a registry trying backends in order before fallback
+func NewRegistry(fallback Downloader, backends ...Downloader) *Registry {
+	return &Registry{backends: backends, fallback: fallback}
+}
+
+// For returns the backend that should handle u
+func (r *Registry) For(u *url.URL) Downloader {
+	for _, d := range r.backends {
+		if d.Match(u) {
+			return d
+		}
+	}
+	return r.fallback
+}
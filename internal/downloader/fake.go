@@ -0,0 +1,360 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+)
+
+// fakeFailures are the errors the fake backend can be asked for, as the
+// messages yt-dlp prints for them, so they're classified the same way
+var fakeFailures = map[string]string{
+	"unavailable": "ERROR: [Fake] %s: Video unavailable. This video has been removed by the uploader",
+	"private":     "ERROR: [Fake] %s: Private video. Sign in if you've been granted access to this video",
+	"geo":         "ERROR: [Fake] %s: The uploader has not made this video available in your country",
+	"rate_limit":  "ERROR: [Fake] %s: Unable to download webpage: HTTP Error 429: Too Many Requests",
+	"auth":        "ERROR: [Fake] %s: Sign in to confirm your age. This video may be inappropriate for some users.",
+	"network":     "ERROR: [Fake] %s: Unable to download webpage: <urlopen error [Errno 111] Connection refused>",
+	"unknown":     "ERROR: [Fake] %s: Something unexpected went wrong",
+}
+
+// Fake pretends to download, for end-to-end tests and demos without
+// network access or yt-dlp. It reports progress for Duration, then writes
+// generated files of Size bytes with .info.json sidecars, as yt-dlp would.
+// The URL's query steers it:
+//
+//	videos=N         writes N videos, as a playlist would
+//	fail=KIND        fails as yt-dlp does for unavailable, private, geo,
+//	                 rate_limit, auth, network or unknown errors
+//	fail_attempts=N  fails only the first N attempts at the URL
+//	duration=D       takes D, such as "500ms", instead of Duration
+//	size=N           writes N-byte files instead of Size
+type Fake struct {
+	Duration time.Duration
+	Size     int64
+
+	mu sync.Mutex
+	// attempts counts the downloads of each URL, for fail_attempts
+	attempts map[string]int
+}
+
+func (d *Fake) Name() string { return "fake" }
+
+// Match claims every URL, so nothing reaches a real backend
+func (d *Fake) Match(u *url.URL) bool { return true }
+
+func (d *Fake) Check(ctx context.Context) error { return nil }
+
+// ValidateArgs accepts any extra arguments, which are ignored
+func (d *Fake) ValidateArgs(args []string) error { return nil }
+
+// fakeOptions is what a URL's query asks of the fake backend
+type fakeOptions struct {
+	videos       int
+	fail         string
+	failAttempts int
+	duration     time.Duration
+	size         int64
+}
+
+func (d *Fake) options(u *url.URL) (fakeOptions, error) {
+	q := u.Query()
+	o := fakeOptions{videos: 1, duration: d.Duration, size: d.Size, fail: q.Get("fail")}
+	if o.fail != "" {
+		if _, ok := fakeFailures[o.fail]; !ok {
+			return o, fmt.Errorf("%w: unknown fake failure %q", apperr.ErrValidation, o.fail)
+		}
+		o.failAttempts = -1
+	}
+	if v := q.Get("videos"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return o, fmt.Errorf("%w: videos must be a whole number of at least 1", apperr.ErrValidation)
+		}
+		o.videos = n
+	}
+	if v := q.Get("fail_attempts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("%w: fail_attempts must be a whole number", apperr.ErrValidation)
+		}
+		if o.fail == "" {
+			o.fail = "network"
+		}
+		o.failAttempts = n
+	}
+	if v := q.Get("duration"); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur < 0 {
+			return o, fmt.Errorf("%w: duration must be a duration such as 2s", apperr.ErrValidation)
+		}
+		o.duration = dur
+	}
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("%w: size must be a number of bytes", apperr.ErrValidation)
+		}
+		o.size = n
+	}
+	return o, nil
+}
+
+// attempt counts a download of rawURL, returning how many came before it
+func (d *Fake) attempt(rawURL string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.attempts == nil {
+		d.attempts = make(map[string]int)
+	}
+	n := d.attempts[rawURL]
+	d.attempts[rawURL]++
+	return n
+}
+
+// fakeID is the ID of a URL's nth video, the same on every download
+func fakeID(rawURL string, n int) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	id := hex.EncodeToString(sum[:6])
+	if n > 0 {
+		id += "-" + strconv.Itoa(n+1)
+	}
+	return id
+}
+
+func (d *Fake) Download(ctx context.Context, req Request) (*Result, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apperr.ErrValidation, err)
+	}
+	o, err := d.options(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.fail != "" && (o.failAttempts < 0 || d.attempt(req.URL) < o.failAttempts) {
+		// Fail part way through, as a real download usually would
+		if err := sleepCtx(ctx, o.duration/4); err != nil {
+			return nil, err
+		}
+		stderr := fmt.Sprintf(fakeFailures[o.fail], fakeID(req.URL, 0))
+		fakeLog(req.Log, "%s", stderr)
+		return nil, &ExecError{Backend: d.Name(), Err: errors.New("exit status 1"), Stderr: stderr}
+	}
+
+	archived, err := readFakeArchive(req.Archive)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Backend: d.Name()}
+	for i := range o.videos {
+		id := fakeID(req.URL, i)
+		if archived[id] {
+			fakeLog(req.Log, "[download] %s has already been recorded in the archive", id)
+			if !req.Backfill {
+				break
+			}
+			continue
+		}
+		file, err := d.writeVideo(ctx, req, o, u, id, i)
+		if err != nil {
+			return nil, err
+		}
+		fakeLog(req.Log, "%s", file)
+		result.Files = append(result.Files, file)
+		result.Output += file + "\n"
+		if err := appendFakeArchive(req.Archive, id); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// writeVideo reports progress over o.duration shared between the videos,
+// then writes the video and its .info.json into req.OutputDir
+func (d *Fake) writeVideo(ctx context.Context, req Request, o fakeOptions, u *url.URL, id string, n int) (string, error) {
+	took := o.duration / time.Duration(o.videos)
+	const steps = 10
+	for step := 1; step <= steps; step++ {
+		if err := sleepCtx(ctx, took/steps); err != nil {
+			return "", err
+		}
+		p := Progress{Percent: float64(step) * 100 / steps, TotalBytes: o.size}
+		if took > 0 {
+			p.Speed = int64(float64(o.size) / took.Seconds())
+			p.ETA = took * time.Duration(steps-step) / steps
+		}
+		if req.Progress != nil {
+			req.Progress(p)
+		}
+		fakeLog(req.Log, "[download] %5.1f%% of %d bytes", p.Percent, o.size)
+	}
+
+	if err := os.MkdirAll(req.OutputDir, 0755); err != nil {
+		return "", err
+	}
+	path, err := filepath.Abs(filepath.Join(req.OutputDir, id+".mp4"))
+	if err != nil {
+		return "", err
+	}
+	if err := writeFakeFile(path, id, o.size); err != nil {
+		return "", err
+	}
+
+	webpage := req.URL
+	if n > 0 {
+		webpage += "#" + strconv.Itoa(n+1)
+	}
+	now := time.Now()
+	info := map[string]any{
+		"id":            id,
+		"title":         fmt.Sprintf("Fake video %d from %s", n+1, u.Host),
+		"uploader":      "Fake uploader",
+		"uploader_id":   "fake",
+		"upload_date":   now.Format("20060102"),
+		"timestamp":     now.Unix(),
+		"webpage_url":   webpage,
+		"extractor":     "fake",
+		"extractor_key": "Fake",
+		"duration":      60 * (n + 1),
+		"width":         1280,
+		"height":        720,
+		"fps":           30,
+		"ext":           "mp4",
+		"vcodec":        "avc1.64001f",
+		"acodec":        "mp4a.40.2",
+		"filesize":      o.size,
+		"description":   "Generated by the fake download backend",
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(req.OutputDir, id+".info.json"), data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeFakeFile fills path with size bytes generated from id, so every
+// fake video has its own checksum
+func writeFakeFile(path, id string, size int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(id))
+	rng := mathrand.NewPCG(binary.LittleEndian.Uint64(sum[:8]), binary.LittleEndian.Uint64(sum[8:16]))
+	w := bufio.NewWriter(f)
+	var word [8]byte
+	for written := int64(0); written < size; written += 8 {
+		binary.LittleEndian.PutUint64(word[:], rng.Uint64())
+		w.Write(word[:min(8, size-written)])
+	}
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readFakeArchive lists the fake videos recorded in a download archive,
+// in yt-dlp's "extractor id" format
+func readFakeArchive(path string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	if path == "" {
+		return ids, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var extractor, id string
+		if n, _ := fmt.Sscan(scanner.Text(), &extractor, &id); n == 2 && extractor == "fake" {
+			ids[id] = true
+		}
+	}
+	return ids, scanner.Err()
+}
+
+func appendFakeArchive(path, id string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "fake "+id+"\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fakeLog writes a line to the job's log, as the real process would
+func fakeLog(w io.Writer, format string, args ...any) {
+	if w != nil {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+}
+
+// sleepCtx waits for d, returning early with ctx's error when it's done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Probe describes the URL without fetching anything: a playlist when it
+// asks for several videos, otherwise a video of the size it would write
+func (d *Fake) Probe(ctx context.Context, rawURL, format string, site SiteOptions) (*Probe, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apperr.ErrValidation, err)
+	}
+	o, err := d.options(u)
+	if err != nil {
+		return nil, err
+	}
+	p := &Probe{
+		Kind:      KindVideo,
+		Extractor: "Fake",
+		ID:        fakeID(rawURL, 0),
+		Title:     "Fake video 1 from " + u.Host,
+		Uploader:  "Fake uploader",
+		Duration:  60,
+	}
+	if o.videos > 1 {
+		p.Kind, p.Entries, p.Title = KindPlaylist, o.videos, "Fake playlist from "+u.Host
+		return p, nil
+	}
+	p.EstimatedSize = o.size
+	return p, nil
+}
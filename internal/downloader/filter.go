@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateLayout is how Filter dates are written
+const dateLayout = "2006-01-02"
+
+// Filter picks which entries of a playlist or channel are downloaded.
+// Zero fields don't filter, and entries the site reports nothing for
+// pass the duration and date checks.
+type Filter struct {
+	// TitleMatch and TitleExclude are regular expressions the title must
+	// and must not contain. yt-dlp runs them, so they use Python's syntax;
+	// start one with (?i) to ignore case.
+	TitleMatch   string `json:"title_match,omitempty"`
+	TitleExclude string `json:"title_exclude,omitempty"`
+	// MinDuration and MaxDuration are in seconds
+	MinDuration int `json:"min_duration,omitempty"`
+	MaxDuration int `json:"max_duration,omitempty"`
+	// After and Before bound the upload date, as YYYY-MM-DD, both included
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+	// SkipShorts skips entries listed as YouTube Shorts
+	SkipShorts bool `json:"skip_shorts,omitempty"`
+	// SkipLive skips live streams, whether live now, upcoming or recorded
+	SkipLive bool `json:"skip_live,omitempty"`
+}
+
+// Validate checks the filter's numbers and dates
+func (f *Filter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if f.MinDuration < 0 || f.MaxDuration < 0 {
+		return fmt.Errorf("durations can't be negative")
+	}
+	if f.MaxDuration > 0 && f.MinDuration > f.MaxDuration {
+		return fmt.Errorf("min_duration is more than max_duration")
+	}
+	var after, before time.Time
+	var err error
+	if f.After != "" {
+		if after, err = time.Parse(dateLayout, f.After); err != nil {
+			return fmt.Errorf("after must be a date such as 2023-01-31")
+		}
+	}
+	if f.Before != "" {
+		if before, err = time.Parse(dateLayout, f.Before); err != nil {
+			return fmt.Errorf("before must be a date such as 2023-12-31")
+		}
+	}
+	if f.After != "" && f.Before != "" && before.Before(after) {
+		return fmt.Errorf("before is earlier than after")
+	}
+	return nil
+}
+
+// Empty reports whether the filter lets every entry through
+func (f *Filter) Empty() bool {
+	return f == nil || *f == Filter{}
+}
+
+// matchFilter turns the filter into a yt-dlp --match-filters expression,
+// "" when it lets everything through
+func (f *Filter) matchFilter() string {
+	if f.Empty() {
+		return ""
+	}
+	var conds []string
+	if f.TitleMatch != "" {
+		conds = append(conds, "title~="+quoteFilterValue(f.TitleMatch))
+	}
+	if f.TitleExclude != "" {
+		conds = append(conds, "title!~="+quoteFilterValue(f.TitleExclude))
+	}
+	if f.MinDuration > 0 {
+		conds = append(conds, fmt.Sprintf("duration>=?%d", f.MinDuration))
+	}
+	if f.MaxDuration > 0 {
+		conds = append(conds, fmt.Sprintf("duration<=?%d", f.MaxDuration))
+	}
+	if f.After != "" {
+		conds = append(conds, "upload_date>=?"+strings.ReplaceAll(f.After, "-", ""))
+	}
+	if f.Before != "" {
+		conds = append(conds, "upload_date<=?"+strings.ReplaceAll(f.Before, "-", ""))
+	}
+	if f.SkipShorts {
+		// Channel listings link Shorts by their /shorts/ page
+		conds = append(conds, "original_url!*=?/shorts/", "url!*=?/shorts/")
+	}
+	if f.SkipLive {
+		conds = append(conds, "!is_live", "live_status!=?is_upcoming", "live_status!=?was_live")
+	}
+	return strings.Join(conds, " & ")
+}
+
+// quoteFilterValue quotes s for a --match-filters expression, which
+// splits on unescaped "&" even inside quotes. Other backslashes are kept
+// for the regular expression.
+func quoteFilterValue(s string) string {
+	s = strings.NewReplacer(`'`, `\'`, `&`, `\&`).Replace(s)
+	return "'" + s + "'"
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// galleryHosts are sites better served by gallery-dl than yt-dlp
+var galleryHosts = []string{
+	"imgur.com",
+	"flickr.com",
+	"deviantart.com",
+	"artstation.com",
+	"pixiv.net",
+	"danbooru.donmai.us",
+	"pinterest.com",
+}
+
+// GalleryDL downloads image galleries with gallery-dl
+type GalleryDL struct {
+	// Binary is the gallery-dl executable, "gallery-dl" when empty
+	Binary string
+}
+
+func (d *GalleryDL) Name() string { return "gallery-dl" }
+
+func (d *GalleryDL) Match(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	// Reddit posts are mostly videos, left to yt-dlp; galleries aren't
+	if (host == "reddit.com" || strings.HasSuffix(host, ".reddit.com")) && strings.HasPrefix(u.Path, "/gallery/") {
+		return true
+	}
+	for _, h := range galleryHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *GalleryDL) Check(ctx context.Context) error {
+	return checkVersion(ctx, d.binary())
+}
+
+func (d *GalleryDL) Download(ctx context.Context, req Request) (*Result, error) {
+	args := []string{
+		"--destination", req.OutputDir,
+		"--write-metadata",
+	}
+	if req.Proxy != "" {
+		args = append(args, "--proxy", req.Proxy)
+	}
+	if req.Cookies != "" {
+		args = append(args, "--cookies", req.Cookies)
+	}
+	args = append(args, req.URL)
+
+	cmd := exec.CommandContext(ctx, d.binary(), args...)
+	result, err := run(ctx, cmd, d.Name(), nil, req.Log)
+	if err != nil {
+		return nil, err
+	}
+	// gallery-dl prints each downloaded path; skipped files start with "# "
+	result.Files = outputFiles(result.Output, req.OutputDir)
+	return result, nil
+}
+
+func (d *GalleryDL) binary() string {
+	if d.Binary == "" {
+		return "gallery-dl"
+	}
+	return d.Binary
+}
@@ -0,0 +1,310 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	apperr "noahjalex.ute/internal/errors"
+	"noahjalex.ute/internal/pathutil"
+)
+
+// directExtensions are file types fetched as-is instead of via an extractor
+var directExtensions = map[string]bool{
+	".mp4":  true,
+	".mkv":  true,
+	".webm": true,
+	".mov":  true,
+	".avi":  true,
+	".mp3":  true,
+	".m4a":  true,
+	".ogg":  true,
+	".flac": true,
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// HTTPStatusError is returned when a direct download gets a non-2xx response
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("server responded %s", e.Status)
+}
+
+// HTTPStatus exposes the status code for error classification
+func (e *HTTPStatusError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// HTTP downloads URLs that point straight at a media file. Interrupted
+// transfers are resumed on the next attempt with a range request, and a
+// checksum in the URL fragment (#sha256=<hex>) is verified before the file
+// is kept.
+type HTTP struct {
+	Client *http.Client
+
+	mu sync.Mutex
+	// validators remembers the ETag or Last-Modified of each partial
+	// download, so a resumed range is only accepted from the same file
+	validators map[string]string
+}
+
+func (d *HTTP) Name() string { return "http" }
+
+func (d *HTTP) Match(u *url.URL) bool {
+	return directExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+func (d *HTTP) Check(ctx context.Context) error { return nil }
+
+func (d *HTTP) Download(ctx context.Context, req Request) (*Result, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := fragmentChecksum(u)
+	if err != nil {
+		return nil, err
+	}
+	u.Fragment = ""
+
+	name := path.Base(path.Clean("/" + u.Path))
+	if name == "/" || name == "." {
+		return nil, fmt.Errorf("cannot derive a file name from %s", req.URL)
+	}
+	name = pathutil.SanitizeFilename(name)
+	target := filepath.Join(req.OutputDir, name)
+	// A fixed name lets a later attempt pick up where this one stopped
+	partial := target + ".part"
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var offset int64
+	if fi, err := os.Stat(partial); err == nil && fi.Size() > 0 {
+		if validator := d.validator(partial); validator != "" {
+			offset = fi.Size()
+			httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			httpReq.Header.Set("If-Range", validator)
+		}
+	}
+
+	client := d.client()
+	if req.Proxy != "" {
+		proxyURL, err := url.Parse(req.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		client = &http.Client{Transport: transport, Timeout: client.Timeout}
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && resumesAt(resp, offset):
+		log.Printf("Resuming %s at %d bytes", name, offset)
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file can't be continued; the next attempt starts over
+		os.Remove(partial)
+		d.setValidator(partial, "")
+		return nil, fmt.Errorf("server can't resume %s: %s %s", name, resp.Status, resp.Header.Get("Content-Range"))
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	default:
+		// The whole file was sent, because the file changed or ranges
+		// aren't supported
+		offset = 0
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if validator := resp.Header.Get("ETag"); validator != "" {
+		d.setValidator(partial, validator)
+	} else {
+		d.setValidator(partial, resp.Header.Get("Last-Modified"))
+	}
+
+	// Hash what an earlier attempt already wrote, then the rest as it arrives
+	hash := sha256.New()
+	if offset > 0 {
+		if err := hashPrefix(hash, partial, offset); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	counter := &progressCounter{done: offset, total: total, start: time.Now(), startBytes: offset, report: req.Progress}
+	written, err := io.Copy(io.MultiWriter(f, hash, counter), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// The partial file is kept for the next attempt to resume
+		return nil, err
+	}
+	counter.finish()
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && sum != checksum {
+		os.Remove(partial)
+		d.setValidator(partial, "")
+		return nil, &apperr.DownloadError{
+			Type:    apperr.TypeNetwork,
+			Message: "Downloaded file doesn't match its checksum",
+			Details: fmt.Sprintf("expected sha256 %s, got %s", checksum, sum),
+			Code:    http.StatusBadGateway,
+		}
+	}
+
+	if err := os.Rename(partial, target); err != nil {
+		return nil, err
+	}
+	d.setValidator(partial, "")
+
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		abs = target
+	}
+
+	return &Result{
+		Backend: d.Name(),
+		Files:   []string{abs},
+		Output:  fmt.Sprintf("saved %s (%d bytes, sha256 %s)", target, offset+written, sum),
+	}, nil
+}
+
+// fragmentChecksum reads an expected SHA-256 from a "#sha256=<hex>" URL
+// fragment, "" when there is none
+func fragmentChecksum(u *url.URL) (string, error) {
+	value, ok := strings.CutPrefix(u.Fragment, "sha256=")
+	if !ok {
+		return "", nil
+	}
+	value = strings.ToLower(value)
+	if _, err := hex.DecodeString(value); err != nil || len(value) != sha256.Size*2 {
+		return "", fmt.Errorf("%w: invalid sha256 checksum %q", apperr.ErrValidation, value)
+	}
+	return value, nil
+}
+
+// resumesAt reports whether a 206 response starts at offset
+func resumesAt(resp *http.Response, offset int64) bool {
+	var start, end int64
+	_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/", &start, &end)
+	return err == nil && start == offset
+}
+
+// hashPrefix feeds the first n bytes of the file at path to h
+func hashPrefix(h io.Writer, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(h, f, n)
+	return err
+}
+
+func (d *HTTP) validator(partial string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.validators[partial]
+}
+
+// setValidator records the validator for a partial download; "" forgets it
+func (d *HTTP) setValidator(partial, validator string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if validator == "" {
+		delete(d.validators, partial)
+		return
+	}
+	if d.validators == nil {
+		d.validators = make(map[string]string)
+	}
+	d.validators[partial] = validator
+}
+
+// progressReportInterval limits how often a direct download reports
+const progressReportInterval = 500 * time.Millisecond
+
+// progressCounter reports a transfer's progress as bytes are written to it
+type progressCounter struct {
+	done, total int64
+	start       time.Time
+	startBytes  int64
+	last        time.Time
+	report      func(Progress)
+}
+
+func (c *progressCounter) Write(p []byte) (int, error) {
+	c.done += int64(len(p))
+	if time.Since(c.last) >= progressReportInterval {
+		c.send()
+	}
+	return len(p), nil
+}
+
+// finish reports the completed transfer
+func (c *progressCounter) finish() {
+	c.send()
+}
+
+func (c *progressCounter) send() {
+	c.last = time.Now()
+	if c.report == nil {
+		return
+	}
+	var p Progress
+	if elapsed := time.Since(c.start).Seconds(); elapsed > 0 {
+		p.Speed = int64(float64(c.done-c.startBytes) / elapsed)
+	}
+	if c.total > 0 {
+		p.TotalBytes = c.total
+		p.Percent = float64(c.done) * 100 / float64(c.total)
+		if p.Speed > 0 {
+			p.ETA = time.Duration(float64(c.total-c.done)/float64(p.Speed)) * time.Second
+		}
+	}
+	c.report(p)
+}
+
+func (d *HTTP) client() *http.Client {
+	if d.Client == nil {
+		return http.DefaultClient
+	}
+	return d.Client
+}
@@ -0,0 +1,205 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/jsonnum"
+)
+
+// Kinds of thing a URL can point at
+const (
+	KindVideo    = "video"
+	KindPlaylist = "playlist"
+	KindLive     = "live"
+	KindFile     = "file"
+)
+
+// Probe describes what a URL points at, found without downloading it
+type Probe struct {
+	// Kind is KindVideo, KindPlaylist, KindLive or KindFile
+	Kind      string `json:"kind"`
+	Extractor string `json:"extractor,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Uploader  string `json:"uploader,omitempty"`
+	// Thumbnail is the site's thumbnail URL
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// Duration is in seconds, 0 when unknown
+	Duration float64 `json:"duration,omitempty"`
+	// Entries counts a playlist's videos, when the site says
+	Entries int `json:"entries,omitempty"`
+	// EstimatedSize is in bytes, 0 when unknown. Playlists aren't sized.
+	EstimatedSize int64 `json:"estimated_size,omitempty"`
+	// StartsAt is when an upcoming premiere or live stream begins
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	// ClipOf is the full video a clip was cut from, and Section the part
+	// of it the clip covers
+	ClipOf  string   `json:"clip_of,omitempty"`
+	Section *Section `json:"section,omitempty"`
+}
+
+// Prober is implemented by backends that can inspect a URL before
+// downloading it
+type Prober interface {
+	// Probe looks up rawURL. format is the format selector a download
+	// would use, for the size estimate, and site the settings it would
+	// reach the site with.
+	Probe(ctx context.Context, rawURL, format string, site SiteOptions) (*Probe, error)
+}
+
+// ytdlpInfo is the subset of yt-dlp's JSON a probe reads
+type ytdlpInfo struct {
+	Type       string `json:"_type"`
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Uploader   string `json:"uploader"`
+	Channel    string `json:"channel"`
+	Thumbnail  string `json:"thumbnail"`
+	Thumbnails []struct {
+		URL string `json:"url"`
+	} `json:"thumbnails"`
+	DisplayID    string        `json:"display_id"`
+	WebpageURL   string        `json:"webpage_url"`
+	ExtractorKey string        `json:"extractor_key"`
+	Duration     jsonnum.Float `json:"duration"`
+	IsLive       bool          `json:"is_live"`
+	LiveStatus   string        `json:"live_status"`
+	// ReleaseTimestamp is when a premiere or live stream starts
+	ReleaseTimestamp jsonnum.Int64 `json:"release_timestamp"`
+	// SectionStart and SectionEnd are set for clips, in seconds
+	SectionStart   jsonnum.Float     `json:"section_start"`
+	SectionEnd     jsonnum.Float     `json:"section_end"`
+	PlaylistCount  jsonnum.Int       `json:"playlist_count"`
+	Entries        []json.RawMessage `json:"entries"`
+	Filesize       jsonnum.Int64     `json:"filesize"`
+	FilesizeApprox jsonnum.Int64     `json:"filesize_approx"`
+	// TBR is the total bitrate in kbit/s
+	TBR              jsonnum.Float `json:"tbr"`
+	RequestedFormats []struct {
+		Filesize       jsonnum.Int64 `json:"filesize"`
+		FilesizeApprox jsonnum.Int64 `json:"filesize_approx"`
+		TBR            jsonnum.Float `json:"tbr"`
+	} `json:"requested_formats"`
+}
+
+// Probe asks yt-dlp for the URL's metadata. Playlists are listed without
+// visiting each entry, so probing them stays quick.
+func (d *YtDlp) Probe(ctx context.Context, rawURL, format string, site SiteOptions) (*Probe, error) {
+	args := []string{"--dump-single-json", "--flat-playlist", "--skip-download", "--no-warnings"}
+	if format != "" {
+		args = append(args, "--format", format)
+	}
+	args = append(args, site.args(nil)...)
+	args = append(args, "--", rawURL)
+
+	result, err := run(ctx, exec.CommandContext(ctx, d.Binary(), args...), d.Name(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info ytdlpInfo
+	if err := json.Unmarshal([]byte(result.Output), &info); err != nil {
+		return nil, fmt.Errorf("reading yt-dlp metadata: %w", err)
+	}
+
+	p := &Probe{
+		Kind:      KindVideo,
+		Extractor: info.ExtractorKey,
+		ID:        info.ID,
+		Title:     info.Title,
+		Uploader:  info.Uploader,
+		Thumbnail: info.Thumbnail,
+		Duration:  float64(info.Duration),
+	}
+	if p.Uploader == "" {
+		p.Uploader = info.Channel
+	}
+	// Flat playlists list thumbnails without picking one; the last is the
+	// largest
+	if p.Thumbnail == "" && len(info.Thumbnails) > 0 {
+		p.Thumbnail = info.Thumbnails[len(info.Thumbnails)-1].URL
+	}
+	switch {
+	case info.Type == "playlist" || info.Type == "multi_video":
+		p.Kind, p.Entries = KindPlaylist, int(info.PlaylistCount)
+		if p.Entries == 0 {
+			p.Entries = len(info.Entries)
+		}
+	case info.IsLive || info.LiveStatus == "is_live" || info.LiveStatus == "is_upcoming":
+		p.Kind = KindLive
+		if info.LiveStatus == "is_upcoming" && info.ReleaseTimestamp > 0 {
+			at := time.Unix(int64(info.ReleaseTimestamp), 0).UTC()
+			p.StartsAt = &at
+		}
+	default:
+		p.EstimatedSize = info.estimatedSize()
+	}
+	if info.SectionEnd > info.SectionStart && IsClipURL(rawURL) {
+		p.ClipOf, p.Section = info.clipSource(), &Section{Start: float64(info.SectionStart), End: float64(info.SectionEnd)}
+	}
+	return p, nil
+}
+
+// clipSource is the watch page of the video a YouTube clip was cut from.
+// The clip keeps its own ID, so the source's comes from its display ID
+// when yt-dlp reports the clip's page.
+func (info ytdlpInfo) clipSource() string {
+	if info.WebpageURL != "" && !IsClipURL(info.WebpageURL) {
+		return info.WebpageURL
+	}
+	if info.DisplayID == "" || info.DisplayID == info.ID {
+		return ""
+	}
+	return "https://www.youtube.com/watch?v=" + url.QueryEscape(info.DisplayID)
+}
+
+// estimatedSize adds up the sizes of the formats yt-dlp picked, falling
+// back to their bitrate over the video's length
+func (info ytdlpInfo) estimatedSize() int64 {
+	sizeOf := func(exact, approx jsonnum.Int64, tbr jsonnum.Float) int64 {
+		switch {
+		case exact > 0:
+			return int64(exact)
+		case approx > 0:
+			return int64(approx)
+		}
+		return int64(tbr * 1000 / 8 * info.Duration)
+	}
+	if len(info.RequestedFormats) == 0 {
+		return sizeOf(info.Filesize, info.FilesizeApprox, info.TBR)
+	}
+	var total int64
+	for _, f := range info.RequestedFormats {
+		total += sizeOf(f.Filesize, f.FilesizeApprox, f.TBR)
+	}
+	return total
+}
+
+// Probe sends a HEAD request for the file's size
+func (d *HTTP) Probe(ctx context.Context, rawURL, format string) (*Probe, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	p := &Probe{Kind: KindFile, Title: path.Base(strings.TrimSuffix(req.URL.Path, "/"))}
+	if resp.ContentLength > 0 {
+		p.EstimatedSize = resp.ContentLength
+	}
+	return p, nil
+}
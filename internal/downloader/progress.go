@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is a backend's report on the file it is downloading
+type Progress struct {
+	Percent    float64
+	TotalBytes int64
+	// Speed is in bytes per second, 0 when unknown
+	Speed int64
+	ETA   time.Duration
+}
+
+// ytdlpProgress matches yt-dlp's --newline progress lines, e.g.
+// "[download]  42.0% of ~ 12.34MiB at  1.50MiB/s ETA 00:07 (frag 3/20)"
+var ytdlpProgress = regexp.MustCompile(`^\[download\]\s+([\d.]+)%\s+of\s+~?\s*([\d.]+)([KMGT]?i?B)(?:\s+at\s+([\d.]+)([KMGT]?i?B)/s)?(?:\s+ETA\s+([\d:]+))?`)
+
+// parseYtDlpProgress reads a yt-dlp progress line
+func parseYtDlpProgress(line string) (Progress, bool) {
+	m := ytdlpProgress.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return Progress{}, false
+	}
+
+	var p Progress
+	p.Percent, _ = strconv.ParseFloat(m[1], 64)
+	p.TotalBytes = parseSize(m[2], m[3])
+	if m[4] != "" {
+		p.Speed = parseSize(m[4], m[5])
+	}
+	if m[6] != "" {
+		var secs int
+		for _, part := range strings.Split(m[6], ":") {
+			n, _ := strconv.Atoi(part)
+			secs = secs*60 + n
+		}
+		p.ETA = time.Duration(secs) * time.Second
+	}
+	return p, true
+}
+
+// parseSize converts a number and a unit such as "MiB" or "MB" to bytes
+func parseSize(number, unit string) int64 {
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0
+	}
+	base := 1000.0
+	if strings.Contains(unit, "i") {
+		base = 1024
+	}
+	if i := strings.IndexByte("KMGT", unit[0]); i >= 0 {
+		for ; i >= 0; i-- {
+			n *= base
+		}
+	}
+	return int64(n)
+}
+
+// lineWriter calls fn with each complete line written to it
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.fn(string(bytes.TrimRight(lw.buf[:i], "\r")))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(p), nil
+}
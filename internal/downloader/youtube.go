@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"net/url"
+	"strings"
+)
+
+// isYouTube reports whether host is one of YouTube's web hosts
+func isYouTube(host string) bool {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	return host == "youtube.com" || host == "m.youtube.com" || host == "music.youtube.com"
+}
+
+// CanonicalURL rewrites YouTube Shorts and live links to the video's
+// watch page, so they match downloads and library videos of the same
+// video. Other links are returned unchanged.
+func CanonicalURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || !isYouTube(u.Host) {
+		return link
+	}
+	for _, prefix := range []string{"/shorts/", "/live/"} {
+		id, ok := strings.CutPrefix(u.Path, prefix)
+		id = strings.TrimSuffix(id, "/")
+		if !ok || id == "" || strings.Contains(id, "/") {
+			continue
+		}
+		canonical := "https://www.youtube.com/watch?v=" + url.QueryEscape(id)
+		if t := u.Query().Get("t"); t != "" {
+			canonical += "&t=" + url.QueryEscape(t)
+		}
+		return canonical
+	}
+	return link
+}
+
+// IsClipURL reports whether link is a YouTube clip, a section of another
+// video
+func IsClipURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && isYouTube(u.Host) && strings.HasPrefix(u.Path, "/clip/")
+}
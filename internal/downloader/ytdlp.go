@@ -0,0 +1,276 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/tracing"
+)
+
+// YtDlp downloads videos with yt-dlp. It matches every URL and is normally
+// used as the registry fallback.
+type YtDlp struct {
+	// Binary returns the yt-dlp executable to run
+	Binary func() string
+	// AllowedArgs is the safelist for per-request extra arguments, mapping
+	// each flag to the number of values it takes
+	AllowedArgs map[string]int
+	// OutputTemplate is the yt-dlp output template relative to the output
+	// directory, "%(id)s.%(ext)s" when empty
+	OutputTemplate string
+	// Comments is how many top-level comments to save in the .info.json,
+	// 0 for none
+	Comments int
+}
+
+func (d *YtDlp) Name() string { return "yt-dlp" }
+
+func (d *YtDlp) Match(u *url.URL) bool { return true }
+
+func (d *YtDlp) Check(ctx context.Context) error {
+	return checkVersion(ctx, d.Binary())
+}
+
+func (d *YtDlp) ValidateArgs(args []string) error {
+	return validateArgs(args, d.AllowedArgs)
+}
+
+func (d *YtDlp) Download(ctx context.Context, req Request) (*Result, error) {
+	if err := d.ValidateArgs(req.ExtraArgs); err != nil {
+		return nil, err
+	}
+
+	tmpl := req.OutputTemplate
+	if tmpl == "" {
+		tmpl = d.outputTemplate()
+	}
+	if req.Section != nil {
+		// Clips of the same video get their own files
+		if base, ok := strings.CutSuffix(tmpl, ".%(ext)s"); ok {
+			tmpl = base + ".%(section_start)d-%(section_end)d.%(ext)s"
+		}
+	}
+	args := []string{
+		"--output", filepath.Join(req.OutputDir, tmpl),
+		"--write-info-json", // Saves full metadata
+		"--embed-metadata",  // Basic info in media file
+		"--embed-thumbnail", // Optional: cover art
+		"--no-mtime",        // Don't modify timestamps
+		"--no-warnings",     // Reduce noise in stderr
+		"--newline",         // Progress on new lines
+		// Report final file paths; --progress keeps progress output that
+		// --print would otherwise silence
+		"--print", "after_move:filepath",
+		"--progress",
+	}
+	if req.Proxy != "" {
+		args = append(args, "--proxy", req.Proxy)
+	}
+	if req.Format != "" && !hasFlag(req.ExtraArgs, "-f", "--format") {
+		args = append(args, "--format", req.Format)
+	}
+	if req.Archive != "" {
+		args = append(args, "--download-archive", req.Archive)
+		if !req.Backfill {
+			args = append(args, "--break-on-existing")
+		}
+	}
+	if req.Cookies != "" {
+		args = append(args, "--cookies", req.Cookies)
+	}
+	if d.Comments > 0 {
+		// Only YouTube can be told to stop early; the site options and
+		// the request's own args come later and win
+		args = append(args, "--write-comments",
+			"--extractor-args", fmt.Sprintf("youtube:max_comments=%d,%d,0,0", d.Comments, d.Comments))
+	}
+	if req.PlaylistEnd > 0 {
+		args = append(args, "--playlist-end", strconv.Itoa(req.PlaylistEnd))
+	}
+	if req.PlaylistItems != "" && !hasFlag(req.ExtraArgs, "-I", "--playlist-items") {
+		args = append(args, "--playlist-items", req.PlaylistItems)
+	}
+	if req.DateAfter != "" {
+		args = append(args, "--dateafter", strings.ReplaceAll(req.DateAfter, "-", ""))
+	}
+	if req.DateBefore != "" {
+		args = append(args, "--datebefore", strings.ReplaceAll(req.DateBefore, "-", ""))
+	}
+	if req.MaxFileSize > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(req.MaxFileSize, 10))
+	}
+	if req.Section != nil {
+		args = append(args, "--download-sections", fmt.Sprintf("*%g-%g", req.Section.Start, req.Section.End))
+	}
+	// Repeated filters match when any does, so the request's filter is
+	// part of each language's; "?" lets missing values through
+	filter := req.Filter.matchFilter()
+	for _, lang := range req.Languages {
+		match := "language^=?" + lang
+		if filter != "" {
+			match = filter + " & " + match
+		}
+		args = append(args, "--match-filters", match)
+	}
+	if len(req.Languages) == 0 && filter != "" {
+		args = append(args, "--match-filters", filter)
+	}
+	args = append(args, req.Site.args(req.ExtraArgs)...)
+	args = append(args, req.ExtraArgs...)
+	// End option parsing so the URL can never be read as a flag
+	args = append(args, "--", req.URL)
+
+	cmd := exec.CommandContext(ctx, d.Binary(), args...)
+	var progress io.Writer
+	if req.Progress != nil {
+		progress = &lineWriter{fn: func(line string) {
+			if p, ok := parseYtDlpProgress(line); ok {
+				req.Progress(p)
+			}
+		}}
+	}
+	result, err := run(ctx, cmd, d.Name(), progress, req.Log)
+	// yt-dlp exits with 101 when --break-on-existing stops it, which means
+	// everything new was downloaded
+	var execErr *ExecError
+	var exitErr *exec.ExitError
+	if req.Archive != "" && errors.As(err, &execErr) && errors.As(err, &exitErr) && exitErr.ExitCode() == 101 {
+		result, err = &Result{Backend: d.Name(), Output: execErr.Stdout}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Files = outputFiles(result.Output, req.OutputDir)
+	return result, nil
+}
+
+// args turns o into yt-dlp flags. An impersonation target chosen in extra
+// wins; extractor args in extra follow these and override them.
+func (o SiteOptions) args(extra []string) []string {
+	var args []string
+	extractors := make([]string, 0, len(o.ExtractorArgs))
+	for extractor := range o.ExtractorArgs {
+		extractors = append(extractors, extractor)
+	}
+	sort.Strings(extractors)
+	for _, extractor := range extractors {
+		args = append(args, "--extractor-args", extractor+":"+o.ExtractorArgs[extractor])
+	}
+	if o.Impersonate != "" && !hasFlag(extra, "--impersonate") {
+		args = append(args, "--impersonate", o.Impersonate)
+	}
+	return args
+}
+
+// hasFlag reports whether args contain any of flags
+func hasFlag(args []string, flags ...string) bool {
+	for _, arg := range args {
+		for _, flag := range flags {
+			if arg == flag || strings.HasPrefix(arg, flag+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// run executes cmd capturing output, wrapping failures in an ExecError.
+// Stdout is also copied to progress as it is written, and both stdout and
+// stderr to log, when set. The process is traced as a span of ctx's.
+func run(ctx context.Context, cmd *exec.Cmd, backend string, progress, log io.Writer) (*Result, error) {
+	_, span := tracing.Start(ctx, backend)
+	defer span.End()
+	span.SetAttr("process.executable.name", filepath.Base(cmd.Path))
+
+	var stdout, stderr bytes.Buffer
+	stdoutWriters := []io.Writer{&stdout}
+	stderrWriters := []io.Writer{&stderr}
+	if progress != nil {
+		stdoutWriters = append(stdoutWriters, progress)
+	}
+	if log != nil {
+		stdoutWriters = append(stdoutWriters, log)
+		stderrWriters = append(stderrWriters, log)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+	// Children such as ffmpeg can hold the output pipes open after a
+	// canceled process is killed; don't wait on them for long
+	cmd.WaitDelay = 2 * time.Second
+
+	err := cmd.Run()
+	if cmd.ProcessState != nil {
+		span.SetAttr("process.exit.code", cmd.ProcessState.ExitCode())
+	}
+	if err != nil {
+		span.SetError(err)
+		return nil, &ExecError{
+			Backend: backend,
+			Err:     err,
+			Stderr:  stderr.String(),
+			Stdout:  stdout.String(),
+		}
+	}
+
+	return &Result{Backend: backend, Output: stdout.String()}, nil
+}
+
+func (d *YtDlp) outputTemplate() string {
+	if d.OutputTemplate == "" {
+		return "%(id)s.%(ext)s"
+	}
+	return d.OutputTemplate
+}
+
+// outputFiles picks the lines of a backend's stdout that name files it
+// wrote inside dir
+func outputFiles(stdout, dir string) []string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		abs, err := filepath.Abs(line)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(absDir, abs); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if fi, err := os.Stat(abs); err == nil && !fi.IsDir() {
+			files = append(files, abs)
+		}
+	}
+	return files
+}
+
+// checkVersion verifies binary runs by asking for its version
+func checkVersion(ctx context.Context, binary string) error {
+	ctx, span := tracing.Start(ctx, "check "+filepath.Base(binary))
+	defer span.End()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "--version")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		span.SetError(err)
+		return fmt.Errorf("%s not found or not executable: %v: %s", binary, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Chat calls an OpenAI-compatible /chat/completions endpoint, such as
+// Ollama, llama.cpp's server, LM Studio or OpenAI itself
+type Chat struct {
+	// URL is the base URL up to and including /v1
+	URL    string
+	APIKey string
+	Model  string
+	// MaxTags caps the suggested tags
+	MaxTags int
+	// MaxInput caps the characters of description and transcript sent
+	MaxInput int
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+func (c *Chat) Name() string { return "openai" }
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (c *Chat) Enrich(ctx context.Context, in Input) (Result, error) {
+	body, err := json.Marshal(chatRequest{
+		Model: c.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: fmt.Sprintf(systemPrompt, c.MaxTags)},
+			{Role: "user", Content: prompt(in, c.MaxInput)},
+		},
+		Temperature: 0.2,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	url := strings.TrimSuffix(c.URL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 1024 {
+			data = data[:1024]
+		}
+		return Result{}, fmt.Errorf("enrichment API: %s: %s", resp.Status, data)
+	}
+
+	var chat chatResponse
+	if err := json.Unmarshal(data, &chat); err != nil {
+		return Result{}, fmt.Errorf("parsing enrichment API response: %w", err)
+	}
+	if len(chat.Choices) == 0 {
+		return Result{}, fmt.Errorf("enrichment API returned no choices")
+	}
+	return parseResult(chat.Choices[0].Message.Content, c.MaxTags)
+}
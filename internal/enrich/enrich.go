@@ -0,0 +1,119 @@
+// Package enrich asks a language model to summarise a video and suggest tags
+// for it from its title, description and transcript.
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"noahjalex.ute/internal/config"
+)
+
+// Input is what the model is told about a video
+type Input struct {
+	Title       string
+	Uploader    string
+	Description string
+	Transcript  string
+	// Tags are the ones the site gave the video
+	Tags []string
+}
+
+// Result is the model's answer
+type Result struct {
+	Summary string   `json:"summary"`
+	Tags    []string `json:"tags"`
+}
+
+// Enricher produces a summary and tags for a video
+type Enricher interface {
+	// Name identifies the backend in logs
+	Name() string
+	Enrich(ctx context.Context, in Input) (Result, error)
+}
+
+// New creates the configured enricher, or nil when enrichment is off
+func New(cfg config.Enrichment) (Enricher, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "openai":
+		return &Chat{
+			URL:      cfg.URL,
+			APIKey:   cfg.APIKey,
+			Model:    cfg.Model,
+			MaxTags:  cfg.MaxTags,
+			MaxInput: cfg.MaxInput,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown enrichment backend %q", cfg.Backend)
+}
+
+// systemPrompt asks for an answer parseResult can read
+const systemPrompt = `You catalogue videos in a personal library. Reply with only a JSON object of the form {"summary": "...", "tags": ["...", ...]}. The summary is two or three plain sentences about what the video covers. Tags are short lowercase topics, at most %d, most relevant first.`
+
+// prompt describes in to the model, with the description and transcript
+// sharing maxInput characters
+func prompt(in Input, maxInput int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Title: %s\n", in.Title)
+	if in.Uploader != "" {
+		fmt.Fprintf(&b, "Uploader: %s\n", in.Uploader)
+	}
+	if len(in.Tags) > 0 {
+		fmt.Fprintf(&b, "Site tags: %s\n", strings.Join(in.Tags, ", "))
+	}
+	description := truncate(in.Description, maxInput)
+	if description != "" {
+		fmt.Fprintf(&b, "\nDescription:\n%s\n", description)
+	}
+	if transcript := truncate(in.Transcript, maxInput-utf8.RuneCountInString(description)); transcript != "" {
+		fmt.Fprintf(&b, "\nTranscript:\n%s\n", transcript)
+	}
+	return b.String()
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+// parseResult reads the JSON object in a model's reply, tolerating text or
+// code fences around it, and tidies the tags
+func parseResult(reply string, maxTags int) (Result, error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return Result{}, fmt.Errorf("no JSON object in the model's reply: %.200q", reply)
+	}
+	var res Result
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &res); err != nil {
+		return Result{}, fmt.Errorf("parsing the model's reply: %w", err)
+	}
+	res.Summary = strings.TrimSpace(res.Summary)
+
+	seen := map[string]bool{}
+	tags := make([]string, 0, len(res.Tags))
+	for _, tag := range res.Tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		tag = strings.TrimPrefix(tag, "#")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxTags {
+			break
+		}
+	}
+	res.Tags = tags
+	return res, nil
+}
@@ -0,0 +1,288 @@
+// Package errors holds the error taxonomy shared by the download pipeline
+// and the HTTP handlers, so API responses and retry decisions classify
+// failures the same way.
+package errors
+
+import (
+	stderrors "errors"
+	"net/http"
+	"strings"
+)
+
+// Error types reported in API responses
+const (
+	TypeValidation = "validation_error"
+	TypeNetwork    = "network_error"
+	TypeNotFound   = "not_found_error"
+	TypeBinary     = "binary_error"
+	TypePermission = "permission_error"
+	TypeFileSystem = "filesystem_error"
+	TypeGeo        = "geo_restricted_error"
+	TypeQuota      = "quota_exceeded_error"
+	TypeAuth       = "auth_required_error"
+	TypeRateLimit  = "rate_limited_error"
+	TypeUpcoming   = "upcoming_error"
+	TypeUnknown    = "unknown_error"
+)
+
+// Sentinel errors matching each type with errors.Is
+var (
+	ErrValidation = stderrors.New(TypeValidation)
+	ErrNetwork    = stderrors.New(TypeNetwork)
+	ErrNotFound   = stderrors.New(TypeNotFound)
+	ErrBinary     = stderrors.New(TypeBinary)
+	ErrPermission = stderrors.New(TypePermission)
+	ErrFileSystem = stderrors.New(TypeFileSystem)
+	ErrGeo        = stderrors.New(TypeGeo)
+	ErrQuota      = stderrors.New(TypeQuota)
+	ErrAuth       = stderrors.New(TypeAuth)
+	ErrRateLimit  = stderrors.New(TypeRateLimit)
+	ErrUpcoming   = stderrors.New(TypeUpcoming)
+	ErrUnknown    = stderrors.New(TypeUnknown)
+)
+
+var sentinels = map[string]error{
+	TypeValidation: ErrValidation,
+	TypeNetwork:    ErrNetwork,
+	TypeNotFound:   ErrNotFound,
+	TypeBinary:     ErrBinary,
+	TypePermission: ErrPermission,
+	TypeFileSystem: ErrFileSystem,
+	TypeGeo:        ErrGeo,
+	TypeQuota:      ErrQuota,
+	TypeAuth:       ErrAuth,
+	TypeRateLimit:  ErrRateLimit,
+	TypeUpcoming:   ErrUpcoming,
+	TypeUnknown:    ErrUnknown,
+}
+
+// DownloadError represents a structured error response
+type DownloadError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Details string `json:"details,omitempty"`
+	Code    int    `json:"code"`
+}
+
+func (e *DownloadError) Error() string {
+	if e.Details != "" {
+		return e.Message + ": " + e.Details
+	}
+	return e.Message
+}
+
+// Is lets errors.Is(err, ErrNetwork) match a DownloadError of that type
+func (e *DownloadError) Is(target error) bool {
+	return sentinels[e.Type] == target
+}
+
+// ProcessError is implemented by failures of an external process
+type ProcessError interface {
+	error
+	ProcessStderr() string
+}
+
+// StatusError is implemented by failures carrying an HTTP status code
+type StatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// Classify converts any download failure into a DownloadError
+func Classify(err error) *DownloadError {
+	if err == nil {
+		return nil
+	}
+
+	var downloadErr *DownloadError
+	if stderrors.As(err, &downloadErr) {
+		return downloadErr
+	}
+
+	if stderrors.Is(err, ErrValidation) {
+		return &DownloadError{
+			Type:    TypeValidation,
+			Message: "Invalid download request",
+			Details: err.Error(),
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	var procErr ProcessError
+	if stderrors.As(err, &procErr) {
+		return ParseYtDlpError(procErr.ProcessStderr())
+	}
+
+	var statusErr StatusError
+	if stderrors.As(err, &statusErr) {
+		switch status := statusErr.HTTPStatus(); {
+		case status == http.StatusNotFound || status == http.StatusGone:
+			return &DownloadError{
+				Type:    TypeNotFound,
+				Message: "File not found at URL",
+				Details: err.Error(),
+				Code:    http.StatusNotFound,
+			}
+		case status == http.StatusTooManyRequests:
+			return &DownloadError{
+				Type:    TypeRateLimit,
+				Message: "The site is limiting downloads",
+				Details: err.Error(),
+				Code:    http.StatusTooManyRequests,
+			}
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return &DownloadError{
+				Type:    TypePermission,
+				Message: "Access denied or permission error",
+				Details: err.Error(),
+				Code:    http.StatusForbidden,
+			}
+		}
+	}
+
+	return &DownloadError{
+		Type:    TypeNetwork,
+		Message: "Network error occurred during download",
+		Details: err.Error(),
+		Code:    http.StatusBadGateway,
+	}
+}
+
+// IsRetryable reports whether a failed download may succeed if tried again
+func IsRetryable(err error) bool {
+	var downloadErr *DownloadError
+	if !stderrors.As(err, &downloadErr) {
+		return false
+	}
+	// A timed-out download already ran for the full timeout
+	if downloadErr.Code == http.StatusRequestTimeout {
+		return false
+	}
+	return stderrors.Is(err, ErrNetwork) ||
+		(stderrors.Is(err, ErrUnknown) && downloadErr.Code >= http.StatusInternalServerError)
+}
+
+// ParseYtDlpError analyzes stderr output to categorize the error
+func ParseYtDlpError(stderr string) *DownloadError {
+	stderrLower := strings.ToLower(stderr)
+
+	// Geo-blocked content, checked before the generic "not available" match
+	if strings.Contains(stderrLower, "geo restrict") ||
+		strings.Contains(stderrLower, "geo-restrict") ||
+		strings.Contains(stderrLower, "in your country") ||
+		strings.Contains(stderrLower, "from your location") ||
+		strings.Contains(stderrLower, "in your region") {
+		return &DownloadError{
+			Type:    TypeGeo,
+			Message: "Video is not available in this region",
+			Details: stderr,
+			Code:    http.StatusUnavailableForLegalReasons,
+		}
+	}
+
+	// The site is throttling or checking for bots; both ask to sign in
+	// too, so this goes first
+	if strings.Contains(stderrLower, "http error 429") ||
+		strings.Contains(stderrLower, "too many requests") ||
+		strings.Contains(stderrLower, "rate-limit") ||
+		strings.Contains(stderrLower, "rate limit") ||
+		strings.Contains(stderrLower, "not a bot") ||
+		strings.Contains(stderrLower, "captcha") {
+		return &DownloadError{
+			Type:    TypeRateLimit,
+			Message: "The site is limiting downloads or checking for bots",
+			Details: stderr,
+			Code:    http.StatusTooManyRequests,
+		}
+	}
+
+	// Age-restricted and sign-in-only videos, which may mention "not
+	// available" too
+	if strings.Contains(stderrLower, "confirm your age") ||
+		strings.Contains(stderrLower, "age-restricted") ||
+		strings.Contains(stderrLower, "age restricted") ||
+		strings.Contains(stderrLower, "inappropriate for some users") ||
+		strings.Contains(stderrLower, "provide account credentials") ||
+		strings.Contains(stderrLower, "login required") {
+		return &DownloadError{
+			Type:    TypeAuth,
+			Message: "Video needs a signed-in account, such as for age-restricted content",
+			Details: stderr,
+			Code:    http.StatusUnauthorized,
+		}
+	}
+
+	// Premieres and live streams that haven't started yet
+	if strings.Contains(stderrLower, "premieres in") ||
+		strings.Contains(stderrLower, "premiere will begin") ||
+		strings.Contains(stderrLower, "live event will begin") {
+		return &DownloadError{
+			Type:    TypeUpcoming,
+			Message: "The premiere or live stream hasn't started yet",
+			Details: stderr,
+			Code:    http.StatusTooEarly,
+		}
+	}
+
+	// Network-related errors
+	if strings.Contains(stderrLower, "network") ||
+		strings.Contains(stderrLower, "connection") ||
+		strings.Contains(stderrLower, "timeout") ||
+		strings.Contains(stderrLower, "dns") {
+		return &DownloadError{
+			Type:    TypeNetwork,
+			Message: "Network error occurred during download",
+			Details: stderr,
+			Code:    http.StatusBadGateway,
+		}
+	}
+
+	// Video not found or unavailable
+	if strings.Contains(stderrLower, "video unavailable") ||
+		strings.Contains(stderrLower, "not available") ||
+		strings.Contains(stderrLower, "private video") ||
+		strings.Contains(stderrLower, "removed") ||
+		strings.Contains(stderrLower, "deleted") ||
+		strings.Contains(stderrLower, "404") {
+		return &DownloadError{
+			Type:    TypeNotFound,
+			Message: "Video not found or unavailable",
+			Details: stderr,
+			Code:    http.StatusNotFound,
+		}
+	}
+
+	// Permission/access errors
+	if strings.Contains(stderrLower, "permission") ||
+		strings.Contains(stderrLower, "access denied") ||
+		strings.Contains(stderrLower, "forbidden") ||
+		strings.Contains(stderrLower, "401") ||
+		strings.Contains(stderrLower, "403") {
+		return &DownloadError{
+			Type:    TypePermission,
+			Message: "Access denied or permission error",
+			Details: stderr,
+			Code:    http.StatusForbidden,
+		}
+	}
+
+	// Unsupported URL
+	if strings.Contains(stderrLower, "unsupported url") ||
+		strings.Contains(stderrLower, "no video formats") ||
+		strings.Contains(stderrLower, "extractor") {
+		return &DownloadError{
+			Type:    TypeValidation,
+			Message: "Unsupported URL or no extractors available",
+			Details: stderr,
+			Code:    http.StatusBadRequest,
+		}
+	}
+
+	// Default to unknown error
+	return &DownloadError{
+		Type:    TypeUnknown,
+		Message: "Unknown error occurred during download",
+		Details: stderr,
+		Code:    http.StatusInternalServerError,
+	}
+}
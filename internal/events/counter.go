@@ -0,0 +1,36 @@
+package events
+
+import "sync"
+
+// Counter tallies events by type, for metrics
+type Counter struct {
+	mu     sync.Mutex
+	counts map[Type]int
+}
+
+// NewCounter creates a counter with every type at zero
+func NewCounter() *Counter {
+	c := &Counter{counts: make(map[Type]int, len(Types))}
+	for _, t := range Types {
+		c.counts[t] = 0
+	}
+	return c
+}
+
+// Handle counts e; subscribe it to a bus
+func (c *Counter) Handle(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[e.Type]++
+}
+
+// Counts returns how many events of each type were seen
+func (c *Counter) Counts() map[Type]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[Type]int, len(c.counts))
+	for t, n := range c.counts {
+		counts[t] = n
+	}
+	return counts
+}
@@ -0,0 +1,180 @@
+// Package events is an in-process publish/subscribe bus. Downloads and
+// library changes are published as events, and components such as hooks,
+// webhooks and metrics subscribe to the ones they care about instead of
+// being called directly.
+package events
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+)
+
+// Type names what happened
+type Type string
+
+const (
+	DownloadStarted   Type = "download.started"
+	DownloadCompleted Type = "download.completed"
+	DownloadFailed    Type = "download.failed"
+	VideoAdded        Type = "video.added"
+	VideoDeleted      Type = "video.deleted"
+)
+
+// Types lists every event type
+var Types = []Type{DownloadStarted, DownloadCompleted, DownloadFailed, VideoAdded, VideoDeleted}
+
+// Event is something that happened. Download events carry the job and
+// video events the video.
+type Event struct {
+	Type  Type           `json:"type"`
+	Time  time.Time      `json:"time"`
+	Job   *jobs.Job      `json:"job,omitempty"`
+	Video *library.Video `json:"video,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// Handler receives the events a subscriber asked for
+type Handler func(Event)
+
+// subscriber delivers events to one handler in the order they were
+// published, without holding up the publisher or other subscribers
+type subscriber struct {
+	name    string
+	types   []Type
+	handler Handler
+
+	mu      sync.Mutex
+	pending []Event
+	closed  bool
+	wake    chan struct{}
+}
+
+// Bus fans published events out to subscribers
+type Bus struct {
+	mu   sync.Mutex
+	subs []*subscriber
+	// running counts the subscribers' goroutines
+	running sync.WaitGroup
+}
+
+// NewBus creates a bus with no subscribers
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe calls handler with every event of the given types, or of every
+// type when none are given. Each subscriber's events arrive one at a time
+// on a goroutine of its own, so a slow handler only delays itself. name
+// identifies the subscriber in logs. The returned function unsubscribes.
+func (b *Bus) Subscribe(name string, handler Handler, types ...Type) func() {
+	sub := &subscriber{
+		name:    name,
+		types:   types,
+		handler: handler,
+		wake:    make(chan struct{}, 1),
+	}
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		sub.run()
+	}()
+
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		b.subs = slices.DeleteFunc(b.subs, func(s *subscriber) bool { return s == sub })
+		b.mu.Unlock()
+		sub.close()
+	}
+}
+
+// Publish sends e to its subscribers, stamping its time if unset
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs {
+		if len(sub.types) == 0 || slices.Contains(sub.types, e.Type) {
+			sub.push(e)
+		}
+	}
+}
+
+// Close unsubscribes everyone, dropping events not yet delivered, and
+// waits for handlers that are running to return
+func (b *Bus) Close() {
+	b.mu.Lock()
+	for _, sub := range b.subs {
+		sub.close()
+	}
+	b.subs = nil
+	b.mu.Unlock()
+	b.running.Wait()
+}
+
+func (s *subscriber) push(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.pending = append(s.pending, e)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers pending events until the subscriber is closed
+func (s *subscriber) run() {
+	for range s.wake {
+		for {
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				return
+			}
+			if len(s.pending) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			e := s.pending[0]
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+
+			s.deliver(e)
+		}
+	}
+}
+
+// deliver calls the handler, keeping a panicking one from taking the
+// server down
+func (s *subscriber) deliver(e Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Event subscriber %s panicked on %s: %v", s.name, e.Type, err)
+		}
+	}()
+	s.handler(e)
+}
@@ -0,0 +1,173 @@
+// Package history keeps daily download totals, persisted in the data
+// directory, so trends outlast the week finished jobs are kept for.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keepDays is how many days of totals are kept
+const keepDays = 2 * 366
+
+// dayFormat keys the per-day totals
+const dayFormat = "2006-01-02"
+
+// Day is what was downloaded on one UTC day
+type Day struct {
+	Date string `json:"date"`
+	// Downloads counts the download jobs that completed and Videos the
+	// videos they added
+	Downloads int   `json:"downloads"`
+	Videos    int   `json:"videos"`
+	Bytes     int64 `json:"bytes"`
+	// Failures counts failed downloads by site
+	Failures map[string]int `json:"failures,omitempty"`
+}
+
+// Store holds the daily totals in memory and in history.json
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	days map[string]*Day
+}
+
+// NewStore creates a store persisting to dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{
+		path: filepath.Join(dataDir, "history.json"),
+		days: make(map[string]*Day),
+	}
+}
+
+// Load reads the persisted totals, if any
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved []*Day
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range saved {
+		s.days[d.Date] = d
+	}
+	return nil
+}
+
+// AddDownload counts a download that completed at t, adding videos
+// totalling bytes
+func (s *Store) AddDownload(t time.Time, videos int, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.day(t)
+	d.Downloads++
+	d.Videos += videos
+	d.Bytes += bytes
+	return s.save()
+}
+
+// AddFailure counts a download from site that failed at t
+func (s *Store) AddFailure(t time.Time, site string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.day(t)
+	if d.Failures == nil {
+		d.Failures = make(map[string]int)
+	}
+	d.Failures[site]++
+	return s.save()
+}
+
+// Days returns the totals for every day from from to to, oldest first,
+// with days nothing happened on as zeros
+func (s *Store) Days(from, to time.Time) []Day {
+	now := time.Now()
+	from = truncateDay(from)
+	if oldest := truncateDay(now).AddDate(0, 0, -keepDays); from.Before(oldest) {
+		from = oldest
+	}
+	if to.After(now) {
+		to = now
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var days []Day
+	for t := from; !t.After(to); t = t.AddDate(0, 0, 1) {
+		date := t.Format(dayFormat)
+		d := Day{Date: date}
+		if saved, ok := s.days[date]; ok {
+			d = *saved
+			d.Failures = make(map[string]int, len(saved.Failures))
+			for site, n := range saved.Failures {
+				d.Failures[site] = n
+			}
+		}
+		days = append(days, d)
+	}
+	return days
+}
+
+// Time is the start of the day, in UTC
+func (d Day) Time() time.Time {
+	t, _ := time.Parse(dayFormat, d.Date)
+	return t
+}
+
+// day returns t's totals, creating them. The caller holds s.mu.
+func (s *Store) day(t time.Time) *Day {
+	date := t.UTC().Format(dayFormat)
+	d, ok := s.days[date]
+	if !ok {
+		d = &Day{Date: date}
+		s.days[date] = d
+	}
+	return d
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// save drops days older than keepDays and writes the rest. The caller
+// holds s.mu.
+func (s *Store) save() error {
+	cutoff := time.Now().UTC().AddDate(0, 0, -keepDays).Format(dayFormat)
+	saved := make([]*Day, 0, len(s.days))
+	for date, d := range s.days {
+		if date < cutoff {
+			delete(s.days, date)
+			continue
+		}
+		saved = append(saved, d)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Date < saved[j].Date })
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,257 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"noahjalex.ute/internal/config"
+)
+
+// discordMaxContent is the most a Discord message can hold
+const discordMaxContent = 2000
+
+// appriseSender sends a notification to one Apprise URL without the
+// apprise command
+type appriseSender func(ctx context.Context, u *url.URL, title, body string) error
+
+// appriseSenders are the schemes sent natively, by scheme
+var appriseSenders = map[string]appriseSender{
+	"ntfy":    sendNtfy,
+	"ntfys":   sendNtfy,
+	"gotify":  sendGotify,
+	"gotifys": sendGotify,
+	"discord": sendDiscord,
+	"tgram":   sendTelegram,
+	"slack":   sendSlack,
+	"json":    sendAppriseJSON,
+	"jsons":   sendAppriseJSON,
+}
+
+// Apprise sends notifications to Apprise URLs. The most common services
+// are sent to directly; the rest go through the apprise command.
+type Apprise struct {
+	urls   []string
+	binary string
+	events []string
+}
+
+// NewApprise creates the Apprise notifier, or returns nil when no URLs
+// are configured
+func NewApprise(cfg config.Apprise) *Apprise {
+	if len(cfg.URLs) == 0 {
+		return nil
+	}
+	events := cfg.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	return &Apprise{urls: cfg.URLs, binary: cfg.Binary, events: events}
+}
+
+// External returns the URLs that need the apprise command
+func (a *Apprise) External() []string {
+	var external []string
+	for _, raw := range a.urls {
+		if u, err := url.Parse(raw); err != nil || appriseSenders[u.Scheme] == nil {
+			external = append(external, raw)
+		}
+	}
+	return external
+}
+
+func (a *Apprise) Name() string { return "apprise" }
+
+// Notify sends n to every URL if its event is one the config lists. Every
+// URL is tried; the first failure is returned.
+func (a *Apprise) Notify(ctx context.Context, n Notification) error {
+	if !slices.Contains(a.events, n.Event) {
+		return nil
+	}
+	title, body := n.Title(), n.Text()
+
+	var firstErr error
+	var external []string
+	for _, raw := range a.urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		send := appriseSenders[u.Scheme]
+		if send == nil {
+			external = append(external, raw)
+			continue
+		}
+		// URLs carry tokens, so only the scheme names them in errors
+		if err := send(ctx, u, title, body); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", u.Scheme, err)
+		}
+	}
+	if len(external) > 0 {
+		if err := a.runApprise(ctx, external, title, body); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// runApprise sends the notification to urls with the apprise command
+func (a *Apprise) runApprise(ctx context.Context, urls []string, title, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout*3)
+	defer cancel()
+	args := append([]string{"--title", title, "--body", body}, urls...)
+	out, err := exec.CommandContext(ctx, a.binary, args...).CombinedOutput()
+	if err != nil && len(bytes.TrimSpace(out)) > 0 {
+		return fmt.Errorf("%s failed: %v: %s", a.binary, err, bytes.TrimSpace(out))
+	} else if err != nil {
+		return fmt.Errorf("%s failed: %v", a.binary, err)
+	}
+	return nil
+}
+
+// appriseHTTPScheme is https for the secure variant of a scheme, such as
+// ntfys
+func appriseHTTPScheme(u *url.URL) string {
+	if strings.HasSuffix(u.Scheme, "s") {
+		return "https"
+	}
+	return "http"
+}
+
+// pathParts are the non-empty segments of the URL's host and path, as
+// Apprise URLs often put a token where the host would be
+func pathParts(u *url.URL) []string {
+	var parts []string
+	for _, p := range strings.Split(u.Host+u.Path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// sendNtfy posts to ntfy://[user:pass@]host/topic, or ntfy://topic on
+// ntfy.sh
+func sendNtfy(ctx context.Context, u *url.URL, title, body string) error {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	host, topic := u.Host, parts[len(parts)-1]
+	scheme := appriseHTTPScheme(u)
+	if topic == "" {
+		host, topic, scheme = "ntfy.sh", u.Host, "https"
+	}
+	if topic == "" {
+		return fmt.Errorf("no topic")
+	}
+	target := scheme + "://" + host + "/" + url.PathEscape(topic)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), password)
+	}
+	return doNotify(req)
+}
+
+// sendGotify posts to gotify://host[/path]/token
+func sendGotify(ctx context.Context, u *url.URL, title, body string) error {
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	token := path[len(path)-1]
+	if token == "" {
+		return fmt.Errorf("no application token")
+	}
+	prefix := strings.Join(path[:len(path)-1], "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	target := appriseHTTPScheme(u) + "://" + u.Host + prefix + "/message?token=" + url.QueryEscape(token)
+	return postJSON(ctx, target, map[string]any{"title": title, "message": body, "priority": 5})
+}
+
+// sendDiscord posts to discord://webhook_id/webhook_token
+func sendDiscord(ctx context.Context, u *url.URL, title, body string) error {
+	parts := pathParts(u)
+	if len(parts) < 2 {
+		return fmt.Errorf("expected discord://webhook_id/webhook_token")
+	}
+	content := "**" + title + "**\n" + body
+	if len(content) > discordMaxContent {
+		content = content[:discordMaxContent]
+	}
+	target := "https://discord.com/api/webhooks/" + url.PathEscape(parts[0]) + "/" + url.PathEscape(parts[1])
+	return postJSON(ctx, target, map[string]any{"content": content})
+}
+
+// sendTelegram posts to tgram://bot_token/chat_id[/chat_id...]
+func sendTelegram(ctx context.Context, u *url.URL, title, body string) error {
+	parts := pathParts(u)
+	if len(parts) < 2 {
+		return fmt.Errorf("expected tgram://bot_token/chat_id")
+	}
+	target := "https://api.telegram.org/bot" + parts[0] + "/sendMessage"
+	for _, chat := range parts[1:] {
+		if err := postJSON(ctx, target, map[string]any{"chat_id": chat, "text": title + "\n" + body}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendSlack posts to slack://tokenA/tokenB/tokenC, an incoming webhook
+func sendSlack(ctx context.Context, u *url.URL, title, body string) error {
+	parts := pathParts(u)
+	if len(parts) < 3 {
+		return fmt.Errorf("expected slack://tokenA/tokenB/tokenC")
+	}
+	target := "https://hooks.slack.com/services/" + strings.Join(parts[:3], "/")
+	return postJSON(ctx, target, map[string]any{"text": "*" + title + "*\n" + body})
+}
+
+// sendAppriseJSON posts Apprise's JSON message to json://host/path
+func sendAppriseJSON(ctx context.Context, u *url.URL, title, body string) error {
+	target := *u
+	target.Scheme = appriseHTTPScheme(u)
+	return postJSON(ctx, target.String(), map[string]any{"version": "1.0", "title": title, "message": body, "type": "info"})
+}
+
+func postJSON(ctx context.Context, target string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotify(req)
+}
+
+// doNotify sends req, failing on error responses. Errors leave out the
+// URL, which holds the service's token.
+func doNotify(req *http.Request) error {
+	req.Header.Set("User-Agent", "ute")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+)
+
+// Digest modes for notifications.digest
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// EventDigest is the webhook event of a digest
+const EventDigest = "digest"
+
+// maxDigestFailures bounds the failed jobs a digest lists
+const maxDigestFailures = 20
+
+// Digest sums up the downloads that finished over a period
+type Digest struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Videos int       `json:"videos"`
+	Failed int       `json:"failed"`
+	// Channels break the counts down by the uploader, subscription or site
+	// the downloads came from, busiest first
+	Channels []DigestChannel `json:"channels"`
+	// Failures are the latest failed jobs, at most maxDigestFailures
+	Failures []DigestFailure `json:"failures,omitempty"`
+}
+
+// DigestChannel is one channel's share of a digest
+type DigestChannel struct {
+	Name   string `json:"name"`
+	Videos int    `json:"videos"`
+	Failed int    `json:"failed"`
+}
+
+// DigestFailure is a failed job listed in a digest
+type DigestFailure struct {
+	JobID string `json:"job_id"`
+	URL   string `json:"url"`
+	Error string `json:"error,omitempty"`
+}
+
+// Empty reports whether nothing finished over the digest's period
+func (d Digest) Empty() bool {
+	return d.Videos == 0 && d.Failed == 0
+}
+
+// Summary is the digest in a line, e.g. "12 new videos downloaded, 2
+// failures"
+func (d Digest) Summary() string {
+	return fmt.Sprintf("%s downloaded, %s", plural(d.Videos, "new video"), plural(d.Failed, "failure"))
+}
+
+// Text is the summary followed by a line for each channel, for chat
+// services that show a webhook's text as it is
+func (d Digest) Text() string {
+	var b strings.Builder
+	b.WriteString(d.Summary())
+	for _, c := range d.Channels {
+		fmt.Fprintf(&b, "\n%s: %s", c.Name, plural(c.Videos, "new video"))
+		if c.Failed > 0 {
+			fmt.Fprintf(&b, ", %s", plural(c.Failed, "failure"))
+		}
+	}
+	return b.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// channel returns the digest's entry for name, adding it if needed
+func (d *Digest) channel(name string) *DigestChannel {
+	for i := range d.Channels {
+		if d.Channels[i].Name == name {
+			return &d.Channels[i]
+		}
+	}
+	d.Channels = append(d.Channels, DigestChannel{Name: name})
+	return &d.Channels[len(d.Channels)-1]
+}
+
+// Digester collects finished downloads until their digest is sent. The
+// digest so far is kept in the data directory so a restart doesn't lose
+// it. It is safe for concurrent use.
+type Digester struct {
+	path string
+
+	mu    sync.Mutex
+	state Digest
+}
+
+// NewDigester creates a digester persisting to dataDir
+func NewDigester(dataDir string) *Digester {
+	return &Digester{
+		path:  filepath.Join(dataDir, "digest.json"),
+		state: Digest{Since: time.Now()},
+	}
+}
+
+// Load reads the digest saved before a restart, if any
+func (d *Digester) Load() error {
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var state Digest
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing %s: %w", d.path, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state = state
+	return nil
+}
+
+// AddVideos counts downloaded videos, one for each channel listed
+func (d *Digester) AddVideos(channels []string) error {
+	if len(channels) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, name := range channels {
+		d.state.Videos++
+		d.state.channel(name).Videos++
+	}
+	return d.save()
+}
+
+// AddFailure counts a failed job against channel
+func (d *Digester) AddFailure(channel string, job jobs.Job, errMsg string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.Failed++
+	d.state.channel(channel).Failed++
+	d.state.Failures = append(d.state.Failures, DigestFailure{JobID: job.ID, URL: job.URL, Error: errMsg})
+	if n := len(d.state.Failures); n > maxDigestFailures {
+		d.state.Failures = d.state.Failures[n-maxDigestFailures:]
+	}
+	return d.save()
+}
+
+// Take returns the digest so far and starts the next one
+func (d *Digester) Take() (Digest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	digest := d.state
+	digest.Until = now
+	sort.SliceStable(digest.Channels, func(i, j int) bool {
+		a, b := digest.Channels[i], digest.Channels[j]
+		return a.Videos+a.Failed > b.Videos+b.Failed
+	})
+	d.state = Digest{Since: now}
+	return digest, d.save()
+}
+
+// save writes the digest so far. The caller holds d.mu.
+func (d *Digester) save() error {
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+// digestPayload is the JSON body POSTed to webhooks for a digest
+type digestPayload struct {
+	Event  string `json:"event"`
+	Text   string `json:"text"`
+	Digest Digest `json:"digest"`
+}
+
+// PostDigest sends the digest to each URL. Every URL is tried; the first
+// failure is returned.
+func PostDigest(ctx context.Context, urls []string, digest Digest) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(digestPayload{Event: EventDigest, Text: digest.Text(), Digest: digest})
+	if err != nil {
+		return err
+	}
+	return postAll(ctx, urls, payload)
+}
@@ -0,0 +1,223 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+// emailTimeout bounds each email delivery
+const emailTimeout = 30 * time.Second
+
+// Email sends notifications by SMTP
+type Email struct {
+	cfg    config.Email
+	events map[string]emailEvent
+}
+
+// emailEvent is how one event is emailed
+type emailEvent struct {
+	to      []string
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewEmail creates the email notifier, or returns nil when no SMTP host is
+// configured
+func NewEmail(cfg config.Email) (*Email, error) {
+	if cfg.Host == "" {
+		return nil, nil
+	}
+	events := cfg.Events
+	if len(events) == 0 {
+		events = make(map[string]config.EmailEvent)
+		for _, event := range defaultEvents {
+			events[event] = config.EmailEvent{}
+		}
+	}
+
+	e := &Email{cfg: cfg, events: make(map[string]emailEvent, len(events))}
+	for event, ec := range events {
+		subject, body := ec.Subject, ec.Body
+		if subject == "" {
+			subject = "[ute] {{.Title}}"
+		}
+		if body == "" {
+			body = "{{.Text}}\n"
+		}
+		st, err := template.New(event + " subject").Parse(subject)
+		if err != nil {
+			return nil, err
+		}
+		bt, err := template.New(event + " body").Parse(body)
+		if err != nil {
+			return nil, err
+		}
+		to := ec.To
+		if len(to) == 0 {
+			to = cfg.To
+		}
+		if len(to) == 0 {
+			return nil, fmt.Errorf("%s emails have no recipients", event)
+		}
+		e.events[event] = emailEvent{to: to, subject: st, body: bt}
+	}
+	return e, nil
+}
+
+func (e *Email) Name() string { return "email" }
+
+// Notify emails n if its event is one the config lists
+func (e *Email) Notify(ctx context.Context, n Notification) error {
+	ev, ok := e.events[n.Event]
+	if !ok {
+		return nil
+	}
+	var subject, body bytes.Buffer
+	if err := ev.subject.Execute(&subject, n); err != nil {
+		return fmt.Errorf("email subject: %w", err)
+	}
+	if err := ev.body.Execute(&body, n); err != nil {
+		return fmt.Errorf("email body: %w", err)
+	}
+	msg, err := e.message(ev.to, strings.TrimSpace(subject.String()), body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+	return e.send(ctx, ev.to, msg)
+}
+
+// message builds the email, its body quoted-printable UTF-8 text
+func (e *Email) message(to []string, subject string, body []byte) ([]byte, error) {
+	var b bytes.Buffer
+	header := func(name, value string) { fmt.Fprintf(&b, "%s: %s\r\n", name, value) }
+	header("From", e.cfg.From)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(e.cfg.From))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	if _, err := w.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// messageID makes a unique Message-ID at the sender's domain
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := envelopeAddress(from); err == nil {
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			domain = addr[i+1:]
+		}
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// envelopeAddress is the bare address of s, which may carry a display
+// name such as "Ute <ute@example.com>"
+func envelopeAddress(s string) (string, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", fmt.Errorf("email address %q: %w", s, err)
+	}
+	return addr.Address, nil
+}
+
+// send delivers msg over a connection secured as the config says
+func (e *Email) send(ctx context.Context, to []string, msg []byte) error {
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: e.cfg.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if e.cfg.TLS == config.EmailTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp %s: %w", addr, err)
+	}
+	defer c.Close()
+
+	if e.cfg.TLS == config.EmailStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp %s doesn't offer STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp %s: %w", addr, err)
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)); err != nil {
+			return fmt.Errorf("smtp %s: %w", addr, err)
+		}
+	}
+
+	from, err := envelopeAddress(e.cfg.From)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("smtp %s: %w", addr, err)
+	}
+	for _, rcpt := range to {
+		addr, err := envelopeAddress(rcpt)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(addr); err != nil {
+			return fmt.Errorf("smtp recipient %s: %w", addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp %s: %w", addr, err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp %s: %w", addr, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp %s: %w", addr, err)
+	}
+	return c.Quit()
+}
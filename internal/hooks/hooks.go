@@ -0,0 +1,153 @@
+// Package hooks runs user-configured shell commands when jobs finish.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/jobs"
+)
+
+// Events a hook can be attached to
+const (
+	EventComplete = "complete"
+	EventFailure  = "failure"
+)
+
+const (
+	// maxOutput caps how much of a hook's output is kept for the log
+	maxOutput = 64 << 10
+	// waitDelay bounds how long a hook's output is read after it is killed
+	// or exits, in case a process it started still holds the pipe open
+	waitDelay = 5 * time.Second
+)
+
+// Runner executes hook commands
+type Runner struct {
+	cfg       config.Hooks
+	videosDir string
+}
+
+// NewRunner creates a runner; it does nothing for events without a command
+func NewRunner(cfg config.Hooks, videosDir string) *Runner {
+	return &Runner{cfg: cfg, videosDir: videosDir}
+}
+
+// Run executes the command configured for event, passing job details as
+// UTE_* environment variables and the job as JSON on stdin. Each run gets
+// a fresh empty working directory that is removed afterwards.
+func (r *Runner) Run(ctx context.Context, event string, job jobs.Job, errMsg string) error {
+	command := r.command(event)
+	if command == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.cfg.WorkDir, 0755); err != nil {
+		return fmt.Errorf("creating hook work dir: %w", err)
+	}
+	workDir, err := os.MkdirTemp(r.cfg.WorkDir, "hook-")
+	if err != nil {
+		return fmt.Errorf("creating hook sandbox: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	videosDir, err := filepath.Abs(r.videosDir)
+	if err != nil {
+		videosDir = r.videosDir
+	}
+
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.cfg.Timeout))
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = workDir
+	cmd.Stdin = bytes.NewReader(payload)
+	// Only a minimal environment is passed so server secrets don't leak
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + workDir,
+		"UTE_EVENT=" + event,
+		"UTE_JOB_ID=" + job.ID,
+		"UTE_JOB_URL=" + job.URL,
+		"UTE_JOB_STATE=" + string(job.State),
+		"UTE_JOB_ATTEMPTS=" + fmt.Sprint(len(job.Attempts)),
+		"UTE_ERROR=" + errMsg,
+		"UTE_VIDEOS_DIR=" + videosDir,
+	}
+
+	cmd.WaitDelay = waitDelay
+	// Processes the command starts are killed with it on timeout
+	killProcessGroup(cmd)
+
+	output := &cappedBuffer{limit: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	started := time.Now()
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook timed out after %v: %s", event, r.cfg.Timeout, output.String())
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v: %s", event, err, output.String())
+	}
+
+	log.Printf("%s hook for job %s finished in %v: %s", event, job.ID, time.Since(started).Round(time.Millisecond), output.String())
+	return nil
+}
+
+func (r *Runner) command(event string) string {
+	switch event {
+	case EventComplete:
+		return r.cfg.OnComplete
+	case EventFailure:
+		return r.cfg.OnFailure
+	}
+	return ""
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a hook that writes without end can't exhaust memory
+type cappedBuffer struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "... (truncated)"
+	}
+	return b.buf.String()
+}
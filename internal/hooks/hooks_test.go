@@ -0,0 +1,67 @@
+package hooks
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/jobs"
+)
+
+func runHook(t *testing.T, command string, timeout time.Duration) (time.Duration, error) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks in these tests are POSIX shell")
+	}
+	r := NewRunner(config.Hooks{
+		OnComplete: command,
+		Timeout:    config.Duration(timeout),
+		WorkDir:    t.TempDir(),
+	}, t.TempDir())
+	started := time.Now()
+	err := r.Run(context.Background(), EventComplete, jobs.Job{ID: "job"}, "")
+	return time.Since(started), err
+}
+
+func TestRunTimeoutKillsChildren(t *testing.T) {
+	// The background sleep holds the output pipe after the shell is killed
+	took, err := runHook(t, "sleep 30 & sleep 30", 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("err = %v, expected a timeout", err)
+	}
+	if took >= waitDelay {
+		t.Fatalf("Run took %v, expected the process group killed at the timeout", took)
+	}
+}
+
+func TestRunCapsOutput(t *testing.T) {
+	_, err := runHook(t, "yes; exit 1", time.Second)
+	if err == nil {
+		t.Fatal("expected the hook to time out")
+	}
+	if len(err.Error()) > maxOutput+200 || !strings.HasSuffix(err.Error(), "(truncated)") {
+		t.Fatalf("error of %d bytes, expected the output capped at %d", len(err.Error()), maxOutput)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	_, err := runHook(t, "echo broken >&2; exit 3", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("err = %v, expected the failure with its output", err)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{limit: 5}
+	for _, p := range []string{"abc", "def", "ghi"} {
+		if n, err := b.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", p, n, err)
+		}
+	}
+	if got := b.String(); got != "abcde... (truncated)" {
+		t.Fatalf("String() = %q", got)
+	}
+}
@@ -0,0 +1,80 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+
+	"noahjalex.ute/internal/alerts"
+	"noahjalex.ute/internal/jobs"
+)
+
+// defaultEvents are sent by notifiers whose config doesn't list any
+var defaultEvents = []string{EventFailure, EventDigest, EventAlert}
+
+// Notification is something the notifiers pass on: a finished job, a
+// digest or an alert, as Event says
+type Notification struct {
+	// Event is EventComplete, EventFailure, EventDigest or EventAlert
+	Event  string
+	Job    *jobs.Job
+	Error  string
+	Digest *Digest
+	Alert  *alerts.Alert
+}
+
+// Title is the notification in a line
+func (n Notification) Title() string {
+	switch n.Event {
+	case EventComplete:
+		return "Downloaded " + n.Job.URL
+	case EventFailure:
+		return "Download failed: " + n.Job.URL
+	case EventDigest:
+		return n.Digest.Summary()
+	case EventAlert:
+		return n.Alert.Message
+	}
+	return n.Event
+}
+
+// Text is the notification written out in full
+func (n Notification) Text() string {
+	switch n.Event {
+	case EventComplete:
+		return fmt.Sprintf("Job %s downloaded %s, adding %d videos to the library.", n.Job.ID, n.Job.URL, len(n.Job.VideoIDs))
+	case EventFailure:
+		return fmt.Sprintf("Job %s failed to download %s after %d attempts: %s", n.Job.ID, n.Job.URL, len(n.Job.Attempts), n.Error)
+	case EventDigest:
+		return n.Digest.Text()
+	case EventAlert:
+		return n.Alert.Message
+	}
+	return n.Title()
+}
+
+// Notifier passes notifications on
+type Notifier interface {
+	// Name identifies the notifier in logs
+	Name() string
+	// Notify sends n, doing nothing for events the notifier isn't set up
+	// to send
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Webhooks POSTs notifications as JSON to the URLs it returns, which are
+// read for each notification so settings changes take effect straight
+// away
+type Webhooks func() []string
+
+func (w Webhooks) Name() string { return "webhooks" }
+
+func (w Webhooks) Notify(ctx context.Context, n Notification) error {
+	urls := w()
+	switch n.Event {
+	case EventDigest:
+		return PostDigest(ctx, urls, *n.Digest)
+	case EventAlert:
+		return PostAlert(ctx, urls, *n.Alert)
+	}
+	return PostWebhooks(ctx, urls, n.Event, *n.Job, n.Error)
+}
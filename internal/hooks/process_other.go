@@ -0,0 +1,9 @@
+//go:build !unix
+
+package hooks
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is: only the shell is killed on
+// cancellation, and WaitDelay stops waiting for what it started
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,91 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"noahjalex.ute/internal/alerts"
+	"noahjalex.ute/internal/jobs"
+)
+
+// EventAlert is the webhook event of an alert
+const EventAlert = "alert"
+
+// webhookTimeout bounds each webhook delivery
+const webhookTimeout = 10 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookPayload is the JSON body POSTed to webhooks
+type webhookPayload struct {
+	Event string   `json:"event"`
+	Job   jobs.Job `json:"job"`
+	Error string   `json:"error,omitempty"`
+}
+
+// PostWebhooks sends the finished job to each URL. Every URL is tried; the
+// first failure is returned.
+func PostWebhooks(ctx context.Context, urls []string, event string, job jobs.Job, errMsg string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(webhookPayload{Event: event, Job: job, Error: errMsg})
+	if err != nil {
+		return err
+	}
+	return postAll(ctx, urls, payload)
+}
+
+// alertPayload is the JSON body POSTed to webhooks for an alert
+type alertPayload struct {
+	Event string       `json:"event"`
+	Text  string       `json:"text"`
+	Alert alerts.Alert `json:"alert"`
+}
+
+// PostAlert sends the alert to each URL. Every URL is tried; the first
+// failure is returned.
+func PostAlert(ctx context.Context, urls []string, alert alerts.Alert) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(alertPayload{Event: EventAlert, Text: alert.Message, Alert: alert})
+	if err != nil {
+		return err
+	}
+	return postAll(ctx, urls, payload)
+}
+
+// postAll posts payload to every URL, returning the first failure
+func postAll(ctx context.Context, urls []string, payload []byte) error {
+	var firstErr error
+	for _, url := range urls {
+		if err := postWebhook(ctx, url, payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func postWebhook(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ute")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %w", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package i18n translates the web interface and API messages. Each
+// catalog maps English text, as written in the templates and handlers, to
+// its translation in one language; text a catalog lacks stays in English.
+// The language is negotiated from the request's Accept-Language header.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language the interface is written in
+const Default = "en"
+
+//go:embed catalogs/*.json
+var catalogFiles embed.FS
+
+// catalogs are the translations by ISO 639-1 code
+var catalogs = mustLoad()
+
+func mustLoad() map[string]map[string]string {
+	files, err := catalogFiles.ReadDir("catalogs")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]map[string]string)
+	for _, f := range files {
+		data, err := catalogFiles.ReadFile(path.Join("catalogs", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: parsing %s: %v", f.Name(), err))
+		}
+		loaded[strings.TrimSuffix(f.Name(), ".json")] = catalog
+	}
+	return loaded
+}
+
+// Languages lists the languages the interface can be shown in, Default
+// first and the rest sorted
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return append([]string{Default}, langs...)
+}
+
+// Supported reports whether lang has a catalog, or is Default
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok || lang == Default
+}
+
+// Translate returns text in lang, or text itself when there is no
+// translation
+func Translate(lang, text string) string {
+	if t, ok := catalogs[lang][text]; ok && t != "" {
+		return t
+	}
+	return text
+}
+
+// Negotiate picks the supported language the client prefers from an
+// Accept-Language header such as "de-CH, de;q=0.9, en;q=0.8". Regional
+// tags match their language, and clients asking for nothing supported get
+// Default.
+func Negotiate(header string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		lang := strings.ToLower(strings.TrimSpace(tag))
+		if i := strings.IndexAny(lang, "-_"); i >= 0 {
+			lang = lang[:i]
+		}
+		// Earlier entries win ties, as clients list them by preference
+		if q > bestQ && Supported(lang) {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
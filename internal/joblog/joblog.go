@@ -0,0 +1,275 @@
+// Package joblog keeps everything a download job's backend process prints,
+// one log file per job under the data directory. A job's log is rotated
+// when it grows too large, keeping a few of the previous files beside it.
+package joblog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentBytes is how much of an attempt's output is kept in memory for
+// its excerpt
+const recentBytes = 8 << 10
+
+// excerptLines and excerptBytes bound an attempt's excerpt
+const (
+	excerptLines = 20
+	excerptBytes = 2000
+)
+
+// Store holds the job logs in a directory
+type Store struct {
+	dir string
+	// maxSize is the bytes a log reaches before it is rotated
+	maxSize int64
+	// maxFiles is how many rotated files each job keeps
+	maxFiles int
+
+	mu sync.Mutex
+	// open are the logs being written, which pruning leaves alone
+	open map[string]*Writer
+}
+
+// NewStore keeps logs in dataDir/logs
+func NewStore(dataDir string, maxSize int64, maxFiles int) *Store {
+	return &Store{
+		dir:      filepath.Join(dataDir, "logs"),
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		open:     make(map[string]*Writer),
+	}
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".log")
+}
+
+// rotatedPath is the nth most recent rotated file of the job with id
+func (s *Store) rotatedPath(id string, n int) string {
+	return fmt.Sprintf("%s.%d", s.path(id), n)
+}
+
+// Open returns a writer appending to the log of the job with id. Retries
+// of a job continue the same log.
+func (s *Store) Open(id string) (*Writer, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{store: s, id: id}
+	if err := w.openFile(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.open[id] = w
+	s.mu.Unlock()
+	return w, nil
+}
+
+// Read returns the current log of the job with id from offset onwards and
+// the offset to continue from. A log rotated since the offset was taken is
+// read from its start. The error satisfies os.IsNotExist when the job has
+// no log.
+func (s *Store) Read(id string, offset int64) ([]byte, int64, error) {
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	if fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+	data, err := io.ReadAll(f)
+	return data, offset + int64(len(data)), err
+}
+
+// Prune deletes the logs of jobs not written to for maxAge, returning how
+// many files were removed
+func (s *Store) Prune(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, e := range entries {
+		name := e.Name()
+		id, _, _ := strings.Cut(name, ".log")
+		if _, running := s.open[id]; running || e.IsDir() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Writer appends to a job's log. It is safe for concurrent use, so a
+// process's stdout and stderr can share it.
+type Writer struct {
+	store *Store
+	id    string
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// failed stops writing after the first error
+	failed bool
+	// recent is the end of the current attempt's output
+	recent []byte
+}
+
+func (w *Writer) openFile() error {
+	f, err := os.OpenFile(w.store.path(w.id), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, fi.Size()
+	return nil
+}
+
+// Write appends p to the log, rotating it first when p would take it past
+// the size limit. It always succeeds so a log that can't be written never
+// fails the download feeding it; the first failure is logged.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.recent = append(w.recent, p...)
+	if over := len(w.recent) - recentBytes; over > 0 {
+		w.recent = append(w.recent[:0], w.recent[over:]...)
+	}
+
+	if w.failed || w.file == nil {
+		return len(p), nil
+	}
+	var err error
+	if w.store.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.store.maxSize {
+		err = w.rotate()
+	}
+	if err == nil {
+		var n int
+		n, err = w.file.Write(p)
+		w.size += int64(n)
+	}
+	if err != nil {
+		log.Printf("Failed to write the log of job %s: %v", w.id, err)
+		w.failed = true
+	}
+	return len(p), nil
+}
+
+// rotate moves the current file aside, dropping the oldest rotated file.
+// The caller holds w.mu.
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	s := w.store
+	if s.maxFiles < 1 {
+		os.Remove(s.path(w.id))
+	} else {
+		os.Remove(s.rotatedPath(w.id, s.maxFiles))
+		for n := s.maxFiles - 1; n >= 1; n-- {
+			os.Rename(s.rotatedPath(w.id, n), s.rotatedPath(w.id, n+1))
+		}
+		if err := os.Rename(s.path(w.id), s.rotatedPath(w.id, 1)); err != nil {
+			return err
+		}
+	}
+	return w.openFile()
+}
+
+// StartAttempt marks the start of a download attempt in the log and
+// restarts the excerpt
+func (w *Writer) StartAttempt(number int) {
+	fmt.Fprintf(w, "=== attempt %d at %s ===\n", number, time.Now().Format(time.RFC3339))
+
+	w.mu.Lock()
+	w.recent = w.recent[:0]
+	w.mu.Unlock()
+}
+
+// Excerpt returns the last lines the current attempt wrote, for showing
+// why it failed
+func (w *Writer) Excerpt() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return excerpt(w.recent)
+}
+
+// excerpt returns the end of output, cut to excerptLines lines and
+// excerptBytes bytes, marking a cut with "…"
+func excerpt(output []byte) string {
+	output = bytes.TrimRight(output, "\n")
+	cut := false
+	if i := len(output) - excerptBytes; i > 0 {
+		output = output[i:]
+		// Drop the partial first line
+		if nl := bytes.IndexByte(output, '\n'); nl >= 0 {
+			output = output[nl+1:]
+		}
+		cut = true
+	}
+	lines := bytes.Split(output, []byte("\n"))
+	if len(lines) > excerptLines {
+		lines = lines[len(lines)-excerptLines:]
+		cut = true
+	}
+	text := string(bytes.Join(lines, []byte("\n")))
+	if cut && text != "" {
+		text = "…\n" + text
+	}
+	return text
+}
+
+// Close stops writing the log
+func (w *Writer) Close() error {
+	w.store.mu.Lock()
+	if w.store.open[w.id] == w {
+		delete(w.store.open, w.id)
+	}
+	w.store.mu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+var priorities = []Priority{PriorityLow, PriorityNormal, PriorityHigh}
+
+// BenchmarkQueuePushPop pushes and pops one job on a queue already
+// holding size others
+func BenchmarkQueuePushPop(b *testing.B) {
+	for _, size := range []int{0, 100, 10000} {
+		b.Run(fmt.Sprintf("queued=%d", size), func(b *testing.B) {
+			q := NewMemoryQueue()
+			for i := 0; i < size; i++ {
+				q.Push(fmt.Sprint("queued-", i), priorities[i%len(priorities)])
+			}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.Push("job", PriorityHigh)
+				if _, ok := q.Pop(ctx); !ok {
+					b.Fatal("nothing to pop")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkQueueParallel has several goroutines pushing and popping at
+// once, as submissions and workers do
+func BenchmarkQueueParallel(b *testing.B) {
+	q := NewMemoryQueue()
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			q.Push(fmt.Sprint("job-", i), priorities[i%len(priorities)])
+			if _, ok := q.Pop(ctx); !ok {
+				b.Error("nothing to pop")
+				return
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkQueuePending lists a queue of 1000 jobs
+func BenchmarkQueuePending(b *testing.B) {
+	q := NewMemoryQueue()
+	for i := 0; i < 1000; i++ {
+		q.Push(fmt.Sprint("job-", i), priorities[i%len(priorities)])
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Pending()
+	}
+}
+
+func filledStore(b *testing.B, s *Store, n int) []string {
+	b.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = s.Create(fmt.Sprint("https://example.com/watch/", i), nil, "", PriorityNormal).ID
+	}
+	return ids
+}
+
+// BenchmarkStoreCreate adds jobs to a store in memory, and to one saving
+// them to a file as the server's does
+func BenchmarkStoreCreate(b *testing.B) {
+	b.Run("memory", func(b *testing.B) {
+		s := NewStore()
+		for i := 0; i < b.N; i++ {
+			s.Create("https://example.com/watch/1", nil, "", PriorityNormal)
+		}
+	})
+	b.Run("file", func(b *testing.B) {
+		s, err := Open(filepath.Join(b.TempDir(), "jobs.json"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		// Each save writes every job, so the store is kept at 100
+		ids := filledStore(b, s, 100)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.Put(Job{ID: ids[i%len(ids)], Kind: KindDownload, State: StateQueued})
+		}
+	})
+}
+
+// BenchmarkStoreGet looks up jobs in a store of 1000, from several
+// goroutines at once
+func BenchmarkStoreGet(b *testing.B) {
+	s := NewStore()
+	ids := filledStore(b, s, 1000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Get(ids[i%len(ids)])
+			i++
+		}
+	})
+}
+
+// BenchmarkStoreUpdate reports progress on jobs in a store of 1000, as
+// running downloads do, with and without a subscriber listening
+func BenchmarkStoreUpdate(b *testing.B) {
+	for _, subscribed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("subscribed=%v", subscribed), func(b *testing.B) {
+			s := NewStore()
+			ids := filledStore(b, s, 1000)
+			if subscribed {
+				sub := s.Subscribe(nil, 64, DropOldest)
+				defer sub.Close()
+				go func() {
+					for range sub.C {
+					}
+				}()
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					s.Update(ids[i%len(ids)], func(j *Job) {
+						j.Transfer = &Transfer{Percent: float64(i % 100)}
+					})
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStoreList lists stores of several sizes, as /api/jobs does
+func BenchmarkStoreList(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("jobs=%d", size), func(b *testing.B) {
+			s := NewStore()
+			filledStore(b, s, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.List()
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package jobs
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit states of a site
+const (
+	// CircuitClosed lets downloads from the site run
+	CircuitClosed = "closed"
+	// CircuitOpen holds them until the cooldown ends
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets one download through to test whether the site
+	// has stopped refusing them
+	CircuitHalfOpen = "half_open"
+)
+
+// SiteState is how a site is treating downloads
+type SiteState struct {
+	Domain string `json:"domain"`
+	// State is CircuitClosed, CircuitOpen or CircuitHalfOpen
+	State string `json:"state"`
+	// Failures counts refusals since the last download that went through
+	Failures int `json:"failures"`
+	// Trips counts how often the circuit opened in a row; each doubles
+	// the cooldown
+	Trips     int        `json:"trips"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type circuit struct {
+	failures  int
+	trips     int
+	openUntil time.Time
+	// probing is set while the half-open test download runs
+	probing   bool
+	lastError string
+}
+
+// Breakers pause downloads from a site once it starts refusing them, such
+// as with 429 responses or bot checks, so more requests don't prolong the
+// block. It is safe for concurrent use.
+type Breakers struct {
+	mu sync.Mutex
+	// threshold is how many refusals in a row open a site's circuit
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+	sites       map[string]*circuit
+}
+
+// NewBreakers opens a site's circuit after threshold refusals in a row,
+// for cooldown doubled each time it opens again up to maxCooldown. A zero
+// cooldown never holds downloads.
+func NewBreakers(threshold int, cooldown, maxCooldown time.Duration) *Breakers {
+	b := &Breakers{sites: make(map[string]*circuit)}
+	b.Configure(threshold, cooldown, maxCooldown)
+	return b
+}
+
+// Configure changes the settings NewBreakers took. Sites cooling off keep
+// their current pause; the new cooldown applies from their next refusal.
+func (b *Breakers) Configure(threshold int, cooldown, maxCooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = max(threshold, 1)
+	b.cooldown = cooldown
+	b.maxCooldown = max(maxCooldown, cooldown)
+}
+
+// Domain is the site a URL belongs to for its circuit: its host name
+// without a "www." or "m." prefix, so a site's variants share one
+func Domain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, prefix := range []string{"www.", "m."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	return host
+}
+
+// Allow reports whether a download from domain may start now. Once a
+// cooldown ends one download is let through, and the rest wait for it.
+func (b *Breakers) Allow(domain string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.sites[domain]
+	if !ok || c.openUntil.IsZero() {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// Refused records that domain refused a download, opening its circuit
+// when that makes too many in a row or the test download was refused
+func (b *Breakers) Refused(domain, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if domain == "" || b.cooldown <= 0 {
+		return
+	}
+
+	c, ok := b.sites[domain]
+	if !ok {
+		c = &circuit{}
+		b.sites[domain] = c
+	}
+	c.failures++
+	c.lastError = reason
+	if c.probing || c.failures >= b.threshold {
+		cooldown := b.cooldown << min(c.trips, 16)
+		if cooldown > b.maxCooldown || cooldown <= 0 {
+			cooldown = b.maxCooldown
+		}
+		c.openUntil = time.Now().Add(cooldown)
+		c.trips++
+		c.probing = false
+	}
+}
+
+// Served records that domain answered a download without refusing it,
+// closing its circuit
+func (b *Breakers) Served(domain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sites, domain)
+}
+
+// Abandoned records that a download from domain stopped without an
+// answer, such as by being canceled, letting another one test the site
+func (b *Breakers) Abandoned(domain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.sites[domain]; ok {
+		c.probing = false
+	}
+}
+
+// Reset closes domain's circuit, reporting whether it had one
+func (b *Breakers) Reset(domain string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.sites[domain]
+	delete(b.sites, domain)
+	return ok
+}
+
+// States returns every site that has refused downloads since it last
+// served one, by domain
+func (b *Breakers) States() []SiteState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	states := make([]SiteState, 0, len(b.sites))
+	for domain, c := range b.sites {
+		st := SiteState{
+			Domain:    domain,
+			State:     CircuitClosed,
+			Failures:  c.failures,
+			Trips:     c.trips,
+			LastError: c.lastError,
+		}
+		if !c.openUntil.IsZero() {
+			until := c.openUntil
+			st.OpenUntil = &until
+			st.State = CircuitOpen
+			if c.probing || !now.Before(until) {
+				st.State = CircuitHalfOpen
+			}
+		}
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Domain < states[j].Domain })
+	return states
+}
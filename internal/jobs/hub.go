@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy is what a subscription does with a job update when its buffer
+// is full because the subscriber is reading too slowly
+type DropPolicy string
+
+const (
+	// DropOldest discards the oldest buffered update, so the latest state
+	// of every job still arrives
+	DropOldest DropPolicy = "oldest"
+	// DropNewest discards the update that didn't fit
+	DropNewest DropPolicy = "newest"
+	// DropSubscriber closes the subscription
+	DropSubscriber DropPolicy = "disconnect"
+)
+
+// ParseDropPolicy reads a drop policy, DropOldest for ""
+func ParseDropPolicy(s string) (DropPolicy, error) {
+	switch p := DropPolicy(s); p {
+	case "":
+		return DropOldest, nil
+	case DropOldest, DropNewest, DropSubscriber:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown drop policy %q, expected oldest, newest or disconnect", s)
+}
+
+// Subscription receives a copy of a job each time it is created or
+// changes. C is closed when the subscription is, including when
+// DropSubscriber closes it for falling behind.
+type Subscription struct {
+	C <-chan Job
+
+	hub    *hub
+	ch     chan Job
+	ids    map[string]bool
+	policy DropPolicy
+	// closed is guarded by hub.mu
+	closed  bool
+	dropped atomic.Int64
+}
+
+// Dropped is how many updates didn't reach the subscriber
+func (sub *Subscription) Dropped() int64 {
+	return sub.dropped.Load()
+}
+
+// Close stops the subscription
+func (sub *Subscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+	sub.hub.remove(sub)
+}
+
+// hub fans job updates out to subscriptions without ever waiting on one
+type hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+	// active lets publishers skip copying jobs nobody is watching
+	active atomic.Int64
+}
+
+// Subscribe follows the jobs with the given IDs, or every job when none
+// are given. Up to buffer updates wait for the subscriber before policy
+// decides what to drop.
+func (s *Store) Subscribe(ids []string, buffer int, policy DropPolicy) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan Job, buffer)
+	sub := &Subscription{C: ch, hub: &s.hub, ch: ch, policy: policy}
+	if len(ids) > 0 {
+		sub.ids = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			sub.ids[id] = true
+		}
+	}
+
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if s.hub.subs == nil {
+		s.hub.subs = make(map[*Subscription]struct{})
+	}
+	s.hub.subs[sub] = struct{}{}
+	s.hub.active.Add(1)
+	return sub
+}
+
+// Subscribers returns how many subscriptions are open, for spotting ones
+// that are never closed
+func (s *Store) Subscribers() int {
+	return int(s.hub.active.Load())
+}
+
+// publish delivers job to the subscriptions following it
+func (h *hub) publish(job Job) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if sub.ids != nil && !sub.ids[job.ID] {
+			continue
+		}
+		select {
+		case sub.ch <- job:
+			continue
+		default:
+		}
+
+		sub.dropped.Add(1)
+		switch sub.policy {
+		case DropNewest:
+		case DropSubscriber:
+			h.remove(sub)
+		default:
+			// Only publish sends, so the slot freed stays free
+			select {
+			case <-sub.ch:
+			default:
+			}
+			sub.ch <- job
+		}
+	}
+}
+
+// remove closes sub. The caller holds h.mu.
+func (h *hub) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(h.subs, sub)
+	h.active.Add(-1)
+	close(sub.ch)
+}
@@ -0,0 +1,351 @@
+// Package jobs tracks download jobs and their attempt history.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mathrand "math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is a job's lifecycle stage
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateRetrying  State = "retrying"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+	// StateNeedsAuth is a download the site only serves to a signed-in
+	// account. Retrying it once cookies are stored for the site uses them.
+	StateNeedsAuth State = "needs_auth"
+)
+
+// Kind distinguishes downloads from library maintenance jobs
+type Kind string
+
+const (
+	KindDownload   Kind = "download"
+	KindRescan     Kind = "rescan"
+	KindTranscode  Kind = "transcode"
+	KindMigrate    Kind = "migrate"
+	KindTranscribe Kind = "transcribe"
+	KindAnalyze    Kind = "analyze"
+)
+
+// Progress counts the items a job has processed
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Transfer is a running download's progress on its current file
+type Transfer struct {
+	Percent    float64 `json:"percent"`
+	TotalBytes int64   `json:"total_bytes"`
+	// Speed is the backend's latest report, in bytes per second
+	Speed      int64   `json:"speed"`
+	ETASeconds float64 `json:"eta_seconds"`
+	// AverageSpeed is since the download started and RecentSpeed over
+	// the last 10 seconds, in bytes per second
+	AverageSpeed int64 `json:"average_speed"`
+	RecentSpeed  int64 `json:"recent_speed"`
+	// DownloadedBytes counts every file of the download so far
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+}
+
+// Estimate is what a download is expected to take, worked out from the
+// site's format metadata before it starts
+type Estimate struct {
+	// Size is in bytes
+	Size int64 `json:"size"`
+	// Seconds is how long the transfer should take at the speed recent
+	// downloads reached, 0 when there are none to go by
+	Seconds float64 `json:"seconds,omitempty"`
+}
+
+// Attempt records a single try at downloading a job's URL
+type Attempt struct {
+	Number     int       `json:"number"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Proxy is the fallback proxy used, with credentials redacted
+	Proxy     string `json:"proxy,omitempty"`
+	ErrorType string `json:"error_type,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Authenticated is set when the attempt sent the cookies stored for
+	// the site
+	Authenticated bool `json:"authenticated,omitempty"`
+	// Log is the end of what the backend printed, when the attempt failed
+	Log string `json:"log,omitempty"`
+	// RetryDelay is how long the job waited before the next attempt
+	RetryDelay string `json:"retry_delay,omitempty"`
+}
+
+// Clip is a section of a source video
+type Clip struct {
+	// Source is the full video's URL
+	Source string `json:"source"`
+	// Start and End are offsets into the source in seconds
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Upload tracks pushing one of a job's files to an rclone remote
+type Upload struct {
+	File        string `json:"file"`
+	Destination string `json:"destination"`
+	// State is "pending", "uploading", "completed" or "failed"
+	State      string `json:"state"`
+	Bytes      int64  `json:"bytes"`
+	TotalBytes int64  `json:"total_bytes"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Step is a post-processor run on a finished download
+type Step struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+	// Seconds is how long it took
+	Seconds float64 `json:"seconds"`
+}
+
+// Job is a download request and its history
+type Job struct {
+	ID   string   `json:"id"`
+	Kind Kind     `json:"kind"`
+	URL  string   `json:"url,omitempty"`
+	Args []string `json:"args,omitempty"`
+	// Folder is the subfolder of the videos directory to download into
+	Folder   string   `json:"folder,omitempty"`
+	Priority Priority `json:"priority,omitempty"`
+	// User is who submitted the job, empty when the server has no users
+	// or the server queued it itself
+	User string `json:"user,omitempty"`
+	// Subscription is the subscription whose check queued the job
+	Subscription string `json:"subscription,omitempty"`
+	// Backfill is set when the job fetches the subscription's existing
+	// uploads rather than checking for new ones
+	Backfill bool `json:"backfill,omitempty"`
+	// Upgrade is the library video the download replaces with a better
+	// copy, when the job is an upgrade
+	Upgrade string `json:"upgrade,omitempty"`
+	// Profile names the post-processing profile, empty for the default
+	Profile string `json:"profile,omitempty"`
+	// Limits narrows a playlist download to some of its entries
+	Limits *Limits `json:"limits,omitempty"`
+	// IdempotencyKey is the Idempotency-Key header the job was submitted
+	// with
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Trace is the W3C traceparent of the request that queued the job, so
+	// the download's spans join its trace
+	Trace string `json:"trace,omitempty"`
+	// State changes through Store.Transition, which checks the lifecycle
+	State State `json:"state"`
+	// Stage is how far a running download has got
+	Stage     Stage     `json:"stage,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Attempts  []Attempt `json:"attempts"`
+	// VideoIDs are the library entries the job produced
+	VideoIDs []string `json:"video_ids,omitempty"`
+	// AlbumIDs are the image galleries the job produced
+	AlbumIDs []string `json:"album_ids,omitempty"`
+	Uploads  []Upload `json:"uploads,omitempty"`
+	// Steps are the post-processors run once the download finished
+	Steps []Step `json:"steps,omitempty"`
+	// Estimate is the expected size and transfer time, when the site
+	// reports enough to work them out
+	Estimate *Estimate `json:"estimate,omitempty"`
+	// ScheduledFor holds a queued download until then, such as the end of
+	// a premiere
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	// Clip is the part of a longer video a clip link pointed at, once
+	// the link has been resolved
+	Clip *Clip `json:"clip,omitempty"`
+	// Transfer is reported while a download is running
+	Transfer *Transfer `json:"transfer,omitempty"`
+	// AverageSpeed is the finished download's, in bytes per second
+	AverageSpeed int64 `json:"average_speed,omitempty"`
+	// Progress and Result are reported by maintenance jobs
+	Progress *Progress   `json:"progress,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// Store holds jobs in memory and tells subscribers when they change.
+// Stores created by Open also keep them in a file.
+type Store struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	hub  hub
+	// file is nil for stores kept only in memory
+	file *persister
+}
+
+// NewStore creates an empty job store
+func NewStore() *Store {
+	return &Store{jobs: make(map[string]*Job)}
+}
+
+// Create registers a new queued download job for url
+func (s *Store) Create(url string, args []string, folder string, priority Priority) Job {
+	now := time.Now()
+	job := &Job{
+		ID:        newID(),
+		Kind:      KindDownload,
+		URL:       url,
+		Args:      args,
+		Folder:    folder,
+		Priority:  priority,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return s.add(job)
+}
+
+// CreateTask registers a running maintenance job of kind
+func (s *Store) CreateTask(kind Kind) Job {
+	now := time.Now()
+	job := &Job{
+		ID:        newID(),
+		Kind:      kind,
+		State:     StateRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return s.add(job)
+}
+
+func (s *Store) add(job *Job) Job {
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.changed(job)
+	c := job.clone()
+	s.mu.Unlock()
+
+	s.save()
+	return c
+}
+
+// changed tells subscribers about job. Publishing never blocks, so it's
+// done under the store lock to keep each job's updates in order.
+func (s *Store) changed(job *Job) {
+	if s.hub.active.Load() > 0 {
+		s.hub.publish(job.clone())
+	}
+}
+
+// Get returns a copy of the job with id
+func (s *Store) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.clone(), true
+}
+
+// List returns copies of all jobs, newest first
+func (s *Store) List() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Put adds job, or replaces the job with its ID, keeping its UpdatedAt,
+// such as to mirror a job another server is running
+func (s *Store) Put(job Job) {
+	s.mu.Lock()
+	c := job.clone()
+	s.jobs[c.ID] = &c
+	s.changed(&c)
+	s.mu.Unlock()
+
+	s.save()
+}
+
+// Update applies fn to the job with id under the store lock and passes
+// the result on to subscribers. It is for progress and results: state
+// changes go through Transition, and the change is only saved to the
+// store's file with the next one.
+func (s *Store) Update(id string, fn func(*Job)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	fn(job)
+	job.UpdatedAt = time.Now()
+	s.changed(job)
+	return true
+}
+
+func (j *Job) clone() Job {
+	c := *j
+	c.Args = append([]string(nil), j.Args...)
+	c.Attempts = append([]Attempt(nil), j.Attempts...)
+	c.VideoIDs = append([]string(nil), j.VideoIDs...)
+	c.AlbumIDs = append([]string(nil), j.AlbumIDs...)
+	c.Uploads = append([]Upload(nil), j.Uploads...)
+	c.Steps = append([]Step(nil), j.Steps...)
+	if j.Progress != nil {
+		p := *j.Progress
+		c.Progress = &p
+	}
+	if j.Transfer != nil {
+		t := *j.Transfer
+		c.Transfer = &t
+	}
+	if j.Estimate != nil {
+		e := *j.Estimate
+		c.Estimate = &e
+	}
+	if j.ScheduledFor != nil {
+		t := *j.ScheduledFor
+		c.ScheduledFor = &t
+	}
+	if j.Clip != nil {
+		clip := *j.Clip
+		c.Clip = &clip
+	}
+	if j.Limits != nil {
+		l := *j.Limits
+		c.Limits = &l
+	}
+	return c
+}
+
+// Backoff returns the delay before retry number attempt (1-based):
+// base doubled per attempt, capped at max, with jitter in [d/2, d].
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := time.Duration(float64(base) * math.Pow(2, float64(attempt-1)))
+	if d > max || d <= 0 {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(mathrand.Int64N(int64(half)+1))
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
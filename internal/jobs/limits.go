@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits narrows a playlist or channel download to some of its entries.
+// Zero fields don't limit it.
+type Limits struct {
+	// Items picks entries by position, as yt-dlp's --playlist-items:
+	// "1-20", "1,3,5-7" or "-5:" for the last five
+	Items string `json:"items,omitempty"`
+	// MaxItems downloads only the first entries; channels list their
+	// newest uploads first
+	MaxItems int `json:"max_items,omitempty"`
+	// After and Before bound the upload date, as YYYY-MM-DD, both included
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+	// MaxFileSize skips entries larger than it, in bytes
+	MaxFileSize int64 `json:"max_filesize,omitempty"`
+}
+
+// Validate checks the limits' syntax
+func (l *Limits) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if strings.Trim(l.Items, "0123456789,:-") != "" {
+		return fmt.Errorf("items may only hold numbers, ranges such as 1-20 and slices such as -5:")
+	}
+	if l.MaxItems < 0 || l.MaxFileSize < 0 {
+		return fmt.Errorf("max_items and max_filesize can't be negative")
+	}
+	var after, before time.Time
+	var err error
+	if l.After != "" {
+		if after, err = time.Parse(time.DateOnly, l.After); err != nil {
+			return fmt.Errorf("after must be a date such as 2023-01-31")
+		}
+	}
+	if l.Before != "" {
+		if before, err = time.Parse(time.DateOnly, l.Before); err != nil {
+			return fmt.Errorf("before must be a date such as 2023-12-31")
+		}
+	}
+	if l.After != "" && l.Before != "" && before.Before(after) {
+		return fmt.Errorf("before is earlier than after")
+	}
+	return nil
+}
+
+// Empty reports whether the limits keep every entry
+func (l *Limits) Empty() bool {
+	return l == nil || *l == Limits{}
+}
+
+// sameLimits reports whether a and b keep the same entries
+func sameLimits(a, b *Limits) bool {
+	if a.Empty() || b.Empty() {
+		return a.Empty() == b.Empty()
+	}
+	return *a == *b
+}
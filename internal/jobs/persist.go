@@ -0,0 +1,145 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keepFinished is how long finished jobs are kept in the file across
+// restarts
+const keepFinished = 7 * 24 * time.Hour
+
+// persister saves a store's jobs to a file
+type persister struct {
+	path string
+	// mu orders saves, so an older snapshot never replaces a newer one
+	mu sync.Mutex
+}
+
+// Open creates a store that saves its jobs to the file at path on every
+// state change, loading the jobs saved before a restart. Downloads that
+// were running when the server stopped are queued again, and maintenance
+// jobs that were running are marked failed, as nothing finishes them.
+func Open(path string) (*Store, error) {
+	s := NewStore()
+	s.file = &persister{path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved []Job
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	now := time.Now()
+	for _, job := range saved {
+		if job.ID == "" {
+			continue
+		}
+		finished := job.State == StateCompleted || job.State == StateFailed ||
+			job.State == StateCanceled || job.State == StateNeedsAuth
+		if finished && now.Sub(job.UpdatedAt) > keepFinished {
+			continue
+		}
+		if job.State == StateRunning || job.State == StateRetrying {
+			job.State = StateQueued
+			if job.Kind != KindDownload {
+				job.State = StateFailed
+			}
+			job.Stage = ""
+			job.Transfer = nil
+			job.UpdatedAt = now
+		}
+		c := job
+		s.jobs[c.ID] = &c
+	}
+	return s, nil
+}
+
+// Queued returns the queued downloads in the order they were created, such
+// as to queue them again after a restart
+func (s *Store) Queued() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []Job
+	for _, job := range s.jobs {
+		if job.State == StateQueued && job.Kind == KindDownload {
+			list = append(list, job.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// save writes every job to the store's file, if it has one. Failures are
+// logged, as the jobs carry on in memory either way.
+func (s *Store) save() {
+	p := s.file
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s.mu.RLock()
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	data, err := json.Marshal(list)
+	s.mu.RUnlock()
+	if err != nil {
+		log.Printf("Failed to encode jobs: %v", err)
+		return
+	}
+	if err := writeFileAtomic(p.path, data, 0644); err != nil {
+		log.Printf("Failed to save jobs to %s: %v", p.path, err)
+	}
+}
+
+// writeFileAtomic replaces path with data so a crash leaves either the old
+// or the new contents but never a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,225 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority orders queued jobs; higher priorities are dispatched first
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+func (p Priority) rank() int {
+	switch p {
+	case PriorityHigh:
+		return 2
+	case PriorityLow:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// ParsePriority validates a priority name, defaulting empty to normal
+func ParsePriority(s string) (Priority, error) {
+	switch Priority(s) {
+	case "":
+		return PriorityNormal, nil
+	case PriorityHigh, PriorityNormal, PriorityLow:
+		return Priority(s), nil
+	}
+	return "", fmt.Errorf("unknown priority %q, expected high, normal or low", s)
+}
+
+// ErrNotQueued is returned when reordering a job that isn't waiting
+var ErrNotQueued = fmt.Errorf("job is not queued")
+
+type entry struct {
+	id       string
+	priority Priority
+}
+
+// Queue holds pending job IDs in dispatch order. New jobs go behind every
+// job of the same or higher priority; explicit moves override that order.
+type Queue interface {
+	// Push enqueues a job
+	Push(id string, priority Priority)
+	// PopReady blocks until a job ready reports true for is available,
+	// removing it from the queue, or ctx is done. Jobs held back keep
+	// their place and are checked again shortly. ready may be nil.
+	PopReady(ctx context.Context, ready func(id string) bool) (string, bool)
+	// SetPriority changes a queued job's priority and repositions it
+	SetPriority(id string, priority Priority) error
+	// Move places a queued job at position (0 is next to run), clamped to
+	// the queue length
+	Move(id string, position int) error
+	// Remove drops a job from the queue, reporting whether it was queued
+	Remove(id string) bool
+	// Pending returns queued job IDs in dispatch order
+	Pending() []string
+}
+
+// MemoryQueue is a Queue held in memory, lost when the server stops
+type MemoryQueue struct {
+	mu      sync.Mutex
+	pending []entry
+	notify  chan struct{}
+}
+
+// NewMemoryQueue creates an empty queue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{notify: make(chan struct{}, 1)}
+}
+
+// Push enqueues a job
+func (q *MemoryQueue) Push(id string, priority Priority) {
+	q.mu.Lock()
+	q.insert(entry{id: id, priority: priority})
+	q.mu.Unlock()
+	q.wake()
+}
+
+// holdRecheck is how often jobs held back by PopReady are checked again
+const holdRecheck = time.Second
+
+// Pop blocks until a job is available or ctx is done
+func (q *MemoryQueue) Pop(ctx context.Context) (string, bool) {
+	return q.PopReady(ctx, nil)
+}
+
+// PopReady is Pop skipping the jobs ready reports false for. ready is
+// called with the queue locked, so it must not use the queue.
+func (q *MemoryQueue) PopReady(ctx context.Context, ready func(id string) bool) (string, bool) {
+	for {
+		q.mu.Lock()
+		held := false
+		for i, next := range q.pending {
+			if ready != nil && !ready(next.id) {
+				held = true
+				continue
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			more := len(q.pending) > 0
+			q.mu.Unlock()
+			// Pass the wakeup on so another idle worker picks up the rest
+			if more {
+				q.wake()
+			}
+			return next.id, true
+		}
+		q.mu.Unlock()
+
+		var recheck <-chan time.Time
+		var timer *time.Timer
+		if held {
+			timer = time.NewTimer(holdRecheck)
+			recheck = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-q.notify:
+		case <-recheck:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// SetPriority changes a queued job's priority and repositions it
+func (q *MemoryQueue) SetPriority(id string, priority Priority) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.index(id)
+	if i < 0 {
+		return ErrNotQueued
+	}
+	q.pending = append(q.pending[:i], q.pending[i+1:]...)
+	q.insert(entry{id: id, priority: priority})
+	return nil
+}
+
+// Move places a queued job at position (0 is next to run), clamped to the
+// queue length
+func (q *MemoryQueue) Move(id string, position int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.index(id)
+	if i < 0 {
+		return ErrNotQueued
+	}
+	e := q.pending[i]
+	q.pending = append(q.pending[:i], q.pending[i+1:]...)
+
+	position = max(0, min(position, len(q.pending)))
+	q.pending = append(q.pending, entry{})
+	copy(q.pending[position+1:], q.pending[position:])
+	q.pending[position] = e
+	return nil
+}
+
+// Remove drops a job from the queue, reporting whether it was queued
+func (q *MemoryQueue) Remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.index(id)
+	if i < 0 {
+		return false
+	}
+	q.pending = append(q.pending[:i], q.pending[i+1:]...)
+	return true
+}
+
+// Pending returns queued job IDs in dispatch order
+func (q *MemoryQueue) Pending() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ids := make([]string, len(q.pending))
+	for i, e := range q.pending {
+		ids[i] = e.id
+	}
+	return ids
+}
+
+// insert places e after the last entry of equal or higher priority.
+// Callers hold q.mu.
+func (q *MemoryQueue) insert(e entry) {
+	pos := len(q.pending)
+	for i, other := range q.pending {
+		if other.priority.rank() < e.priority.rank() {
+			pos = i
+			break
+		}
+	}
+	q.pending = append(q.pending, entry{})
+	copy(q.pending[pos+1:], q.pending[pos:])
+	q.pending[pos] = e
+}
+
+func (q *MemoryQueue) index(id string) int {
+	for i, e := range q.pending {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *MemoryQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
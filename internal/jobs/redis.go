@@ -0,0 +1,333 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/redis"
+)
+
+// Keys under the RedisQueue's prefix
+const (
+	// redisQueueKey is a list of "<id> <priority>" entries in dispatch order
+	redisQueueKey = "queue"
+	// redisJobsKey is a hash of job IDs to their JSON
+	redisJobsKey = "jobs"
+	// redisRunningKey, followed by a job ID, names the process running the
+	// job until the key expires
+	redisRunningKey = "running:"
+	// redisCancelKey, followed by a job ID, asks the process running the
+	// job to stop it
+	redisCancelKey = "cancel:"
+)
+
+// Lua scripts run atomically by Redis, so processes sharing the queue
+// never interleave halfway through a reorder
+const (
+	// redisFind defines find, returning a job's entry in the queue
+	redisFind = `
+local function find(id)
+  for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+    if string.sub(item, 1, #id + 1) == id .. ' ' then return item end
+  end
+end
+local ranks = {high = 2, normal = 1, low = 0}
+local function insert(entry, priority)
+  local rank = ranks[priority] or 1
+  for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+    local other = string.match(item, ' (%a+)$')
+    if (ranks[other] or 1) < rank then
+      redis.call('LINSERT', KEYS[1], 'BEFORE', item, entry)
+      return
+    end
+  end
+  redis.call('RPUSH', KEYS[1], entry)
+end
+`
+	// redisPushScript inserts ARGV[1] with priority ARGV[2]
+	redisPushScript = redisFind + `
+insert(ARGV[1] .. ' ' .. ARGV[2], ARGV[2])
+return 1
+`
+	// redisPriorityScript moves ARGV[1] to where priority ARGV[2] puts it
+	redisPriorityScript = redisFind + `
+local item = find(ARGV[1])
+if not item then return 0 end
+redis.call('LREM', KEYS[1], 1, item)
+insert(ARGV[1] .. ' ' .. ARGV[2], ARGV[2])
+return 1
+`
+	// redisMoveScript places ARGV[1] at index ARGV[2]
+	redisMoveScript = redisFind + `
+local item = find(ARGV[1])
+if not item then return 0 end
+redis.call('LREM', KEYS[1], 1, item)
+local pivot = redis.call('LINDEX', KEYS[1], tonumber(ARGV[2]))
+if pivot then
+  redis.call('LINSERT', KEYS[1], 'BEFORE', pivot, item)
+else
+  redis.call('RPUSH', KEYS[1], item)
+end
+return 1
+`
+	// redisRemoveScript drops ARGV[1]
+	redisRemoveScript = redisFind + `
+local item = find(ARGV[1])
+if not item then return 0 end
+return redis.call('LREM', KEYS[1], 1, item)
+`
+	// redisRenewScript extends KEYS[1] to ARGV[2] milliseconds if ARGV[1]
+	// still holds it
+	redisRenewScript = `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then return 0 end
+return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+`
+)
+
+// ErrNotHeld is returned when renewing a claim on a job that has expired
+// or been taken over
+var ErrNotHeld = errors.New("job no longer claimed by this process")
+
+// RedisQueue is a Queue kept in Redis, so it outlives the server and can
+// be inspected and shared by several processes. It also stores job records
+// and claims on running jobs, for processes sharing the jobs to coordinate
+// through.
+type RedisQueue struct {
+	client *redis.Client
+	prefix string
+	notify chan struct{}
+}
+
+// NewRedisQueue keeps the queue in Redis under keys starting with prefix
+func NewRedisQueue(client *redis.Client, prefix string) *RedisQueue {
+	return &RedisQueue{client: client, prefix: prefix, notify: make(chan struct{}, 1)}
+}
+
+func (q *RedisQueue) key(name string) string {
+	return q.prefix + name
+}
+
+func (q *RedisQueue) eval(script string, args ...string) (int64, error) {
+	cmd := append([]string{"EVAL", script, "1", q.key(redisQueueKey)}, args...)
+	return redis.Int(q.client.Do(context.Background(), cmd...))
+}
+
+// Ping checks the connection to Redis
+func (q *RedisQueue) Ping(ctx context.Context) error {
+	_, err := q.client.Do(ctx, "PING")
+	return err
+}
+
+// Push enqueues a job. Failures are logged, as the queue has no way to
+// return them.
+func (q *RedisQueue) Push(id string, priority Priority) {
+	if _, err := q.eval(redisPushScript, id, string(priority)); err != nil {
+		log.Printf("Failed to queue job %s in Redis: %v", id, err)
+		return
+	}
+	q.wake()
+}
+
+// PopReady takes the first job ready reports true for. Other processes
+// push jobs too, so the queue is checked every holdRecheck as well as
+// when this one pushes.
+func (q *RedisQueue) PopReady(ctx context.Context, ready func(id string) bool) (string, bool) {
+	for {
+		items, err := redis.Strings(q.client.Do(ctx, "LRANGE", q.key(redisQueueKey), "0", "-1"))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to read the Redis queue: %v", err)
+		}
+		for _, item := range items {
+			id, _, _ := strings.Cut(item, " ")
+			if ready != nil && !ready(id) {
+				continue
+			}
+			// Whichever process removes the entry runs the job
+			removed, err := redis.Int(q.client.Do(ctx, "LREM", q.key(redisQueueKey), "1", item))
+			if err != nil {
+				log.Printf("Failed to take job %s from the Redis queue: %v", id, err)
+				break
+			}
+			if removed == 1 {
+				return id, true
+			}
+		}
+
+		timer := time.NewTimer(holdRecheck)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", false
+		case <-q.notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// SetPriority changes a queued job's priority and repositions it
+func (q *RedisQueue) SetPriority(id string, priority Priority) error {
+	moved, err := q.eval(redisPriorityScript, id, string(priority))
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return ErrNotQueued
+	}
+	return nil
+}
+
+// Move places a queued job at position
+func (q *RedisQueue) Move(id string, position int) error {
+	moved, err := q.eval(redisMoveScript, id, strconv.Itoa(max(position, 0)))
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return ErrNotQueued
+	}
+	return nil
+}
+
+// Remove drops a job from the queue, reporting whether it was queued
+func (q *RedisQueue) Remove(id string) bool {
+	removed, err := q.eval(redisRemoveScript, id)
+	if err != nil {
+		log.Printf("Failed to remove job %s from the Redis queue: %v", id, err)
+	}
+	return removed > 0
+}
+
+// Pending returns queued job IDs in dispatch order
+func (q *RedisQueue) Pending() []string {
+	items, err := redis.Strings(q.client.Do(context.Background(), "LRANGE", q.key(redisQueueKey), "0", "-1"))
+	if err != nil {
+		log.Printf("Failed to read the Redis queue: %v", err)
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i], _, _ = strings.Cut(item, " ")
+	}
+	return ids
+}
+
+func (q *RedisQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// SaveJob stores job's record
+func (q *RedisQueue) SaveJob(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.Do(ctx, "HSET", q.key(redisJobsKey), job.ID, string(data))
+	return err
+}
+
+// Job returns the record of the job with id
+func (q *RedisQueue) Job(ctx context.Context, id string) (Job, bool, error) {
+	data, err := redis.String(q.client.Do(ctx, "HGET", q.key(redisJobsKey), id))
+	if errors.Is(err, redis.ErrNil) {
+		return Job{}, false, nil
+	} else if err != nil {
+		return Job{}, false, err
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// Jobs returns every stored job record. Records that can't be decoded are
+// skipped.
+func (q *RedisQueue) Jobs(ctx context.Context) ([]Job, error) {
+	fields, err := redis.Strings(q.client.Do(ctx, "HGETALL", q.key(redisJobsKey)))
+	if err != nil {
+		return nil, err
+	}
+	var list []Job
+	for i := 0; i+1 < len(fields); i += 2 {
+		var job Job
+		if json.Unmarshal([]byte(fields[i+1]), &job) != nil || job.ID == "" {
+			continue
+		}
+		list = append(list, job)
+	}
+	return list, nil
+}
+
+// DeleteJob removes the record of the job with id
+func (q *RedisQueue) DeleteJob(ctx context.Context, id string) error {
+	_, err := q.client.Do(ctx, "HDEL", q.key(redisJobsKey), id)
+	return err
+}
+
+// Claim marks the job with id as run by owner for ttl unless another
+// process holds it, reporting whether owner now does
+func (q *RedisQueue) Claim(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	_, err := q.client.Do(ctx, "SET", q.key(redisRunningKey+id), owner, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if errors.Is(err, redis.ErrNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Hold marks the job with id as run by owner for ttl, whoever held it,
+// such as after owner took it from the queue
+func (q *RedisQueue) Hold(ctx context.Context, id, owner string, ttl time.Duration) error {
+	_, err := q.client.Do(ctx, "SET", q.key(redisRunningKey+id), owner, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Renew extends owner's claim on the job with id by ttl
+func (q *RedisQueue) Renew(ctx context.Context, id, owner string, ttl time.Duration) error {
+	renewed, err := redis.Int(q.client.Do(ctx, "EVAL", redisRenewScript, "1",
+		q.key(redisRunningKey+id), owner, strconv.FormatInt(ttl.Milliseconds(), 10)))
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Release gives up a claim
+func (q *RedisQueue) Release(ctx context.Context, id string) error {
+	_, err := q.client.Do(ctx, "DEL", q.key(redisRunningKey+id))
+	return err
+}
+
+// Holder returns the process that has claimed the job with id, if any
+func (q *RedisQueue) Holder(ctx context.Context, id string) (string, bool, error) {
+	owner, err := redis.String(q.client.Do(ctx, "GET", q.key(redisRunningKey+id)))
+	if errors.Is(err, redis.ErrNil) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return owner, true, nil
+}
+
+// RequestCancel asks the process running the job with id to stop it
+func (q *RedisQueue) RequestCancel(ctx context.Context, id string) error {
+	_, err := q.client.Do(ctx, "SET", q.key(redisCancelKey+id), "1", "EX", "3600")
+	return err
+}
+
+// CancelRequested reports whether a process asked for the job with id to
+// be stopped, clearing the request
+func (q *RedisQueue) CancelRequested(ctx context.Context, id string) (bool, error) {
+	n, err := redis.Int(q.client.Do(ctx, "DEL", q.key(redisCancelKey+id)))
+	return n > 0, err
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Stage is how far a running download has got
+type Stage string
+
+const (
+	// StageFetching is while the backend reads the page and picks formats,
+	// before any bytes arrive
+	StageFetching Stage = "fetching_metadata"
+	// StageDownloading is while the files are transferred
+	StageDownloading Stage = "downloading"
+	// StagePostprocessing is while the files are moved into the library
+	// and the post-processors run
+	StagePostprocessing Stage = "postprocessing"
+)
+
+// stageOrder is the order a download passes through the stages in
+var stageOrder = []Stage{StageFetching, StageDownloading, StagePostprocessing}
+
+// transitions are the states each state may move to. A download runs from
+// queued through running, and retrying between attempts, to completed,
+// failed, needs_auth or canceled; retrying it queues it again. Running
+// and retrying jobs go back to queued when the process running them stops.
+var transitions = map[State][]State{
+	StateQueued:    {StateRunning, StateCanceled},
+	StateRunning:   {StateRetrying, StateCompleted, StateFailed, StateNeedsAuth, StateCanceled, StateQueued},
+	StateRetrying:  {StateRunning, StateFailed, StateCanceled, StateQueued},
+	StateFailed:    {StateQueued},
+	StateCanceled:  {StateQueued},
+	StateNeedsAuth: {StateQueued},
+	StateCompleted: {},
+}
+
+var (
+	// ErrInvalidTransition is returned for a state change the lifecycle
+	// doesn't allow, such as retrying a completed job
+	ErrInvalidTransition = errors.New("invalid job state transition")
+	// ErrNoJob is returned for a job the store doesn't hold
+	ErrNoJob = errors.New("no such job")
+)
+
+// CanTransition reports whether a job may move from one state to another
+func CanTransition(from, to State) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// Transition moves the job with id to state to, applying fn to it first
+// when fn isn't nil. Nothing changes if the lifecycle doesn't allow the
+// move, or fn returns an error. A download entering the running state
+// starts at StageFetching; leaving it clears the stage. Stores opened from
+// a file save the change before returning.
+func (s *Store) Transition(id string, to State, fn func(*Job) error) error {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNoJob
+	}
+	if !CanTransition(job.State, to) {
+		from := job.State
+		s.mu.Unlock()
+		return fmt.Errorf("%w: job %s from %s to %s", ErrInvalidTransition, id, from, to)
+	}
+	if fn != nil {
+		// fn works on a copy so a change it makes before failing is dropped
+		next := job.clone()
+		if err := fn(&next); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		*job = next
+	}
+	job.State = to
+	job.Stage = ""
+	if to == StateRunning && job.Kind == KindDownload {
+		job.Stage = StageFetching
+	}
+	job.UpdatedAt = time.Now()
+	s.changed(job)
+	s.mu.Unlock()
+
+	s.save()
+	return nil
+}
+
+// SetStage moves the running download with id on to stage. Stages only
+// move forward, so a late progress report can't undo post-processing.
+func (s *Store) SetStage(id string, stage Stage) error {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNoJob
+	}
+	if job.Stage == stage {
+		s.mu.Unlock()
+		return nil
+	}
+	if job.State != StateRunning || slices.Index(stageOrder, stage) < slices.Index(stageOrder, job.Stage) {
+		from := job.Stage
+		s.mu.Unlock()
+		return fmt.Errorf("%w: job %s from stage %q to %q", ErrInvalidTransition, id, from, stage)
+	}
+	job.Stage = stage
+	job.UpdatedAt = time.Now()
+	s.changed(job)
+	s.mu.Unlock()
+
+	s.save()
+	return nil
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestTransitionFailedFn checks that a job is left as it was when the
+// function applied by Transition fails partway
+func TestTransitionFailedFn(t *testing.T) {
+	s := NewStore()
+	job := s.Create("https://example.com/v", []string{"-f", "best"}, "", PriorityNormal)
+
+	errStop := errors.New("stop")
+	err := s.Transition(job.ID, StateRunning, func(j *Job) error {
+		j.Folder = "changed"
+		j.Args[1] = "worst"
+		j.Attempts = append(j.Attempts, Attempt{})
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Transition = %v, want %v", err, errStop)
+	}
+
+	got, _ := s.Get(job.ID)
+	if got.State != job.State || got.Folder != "" || len(got.Attempts) != 0 {
+		t.Errorf("failed transition left state %s, folder %q, %d attempts", got.State, got.Folder, len(got.Attempts))
+	}
+	if !slices.Equal(got.Args, []string{"-f", "best"}) {
+		t.Errorf("failed transition left args %q", got.Args)
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// keyWindow is how long an idempotency key keeps returning the job it
+// created
+const keyWindow = 24 * time.Hour
+
+// ErrKeyReused is returned when an idempotency key that created one
+// download is sent again for a different one
+var ErrKeyReused = errors.New("idempotency key was already used for a different download")
+
+// Submission is a download asked for by a client
+type Submission struct {
+	URL      string
+	Args     []string
+	Folder   string
+	Priority Priority
+	User     string
+	Profile  string
+	// Limits narrows a playlist download to some of its entries
+	Limits *Limits
+	// IdempotencyKey is chosen by the client, so that sending the same
+	// request again returns the job the first one created
+	IdempotencyKey string
+	// Trace is the traceparent of the submitting request's span
+	Trace string
+}
+
+// same reports whether job downloads what sub asks for
+func (sub Submission) same(job *Job) bool {
+	return job.URL == sub.URL && slices.Equal(job.Args, sub.Args) &&
+		job.Folder == sub.Folder && job.Profile == sub.Profile &&
+		sameLimits(job.Limits, sub.Limits)
+}
+
+// Existing returns the job a repeated submission refers to: the job the
+// user created with the same idempotency key in the last day, or else
+// their queued, running or retrying download of the same URL with the
+// same arguments, folder and profile. It returns ErrKeyReused when the key
+// created a different download.
+func (s *Store) Existing(sub Submission) (Job, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, err := s.existing(sub)
+	if job == nil {
+		return Job{}, false, err
+	}
+	return job.clone(), true, nil
+}
+
+func (s *Store) existing(sub Submission) (*Job, error) {
+	var pending *Job
+	for _, job := range s.jobs {
+		if job.Kind != KindDownload || job.User != sub.User {
+			continue
+		}
+		if sub.IdempotencyKey != "" && job.IdempotencyKey == sub.IdempotencyKey &&
+			time.Since(job.CreatedAt) < keyWindow {
+			if !sub.same(job) {
+				return nil, ErrKeyReused
+			}
+			return job, nil
+		}
+		pendingState := job.State == StateQueued || job.State == StateRunning || job.State == StateRetrying
+		if pendingState && sub.same(job) && (pending == nil || job.CreatedAt.Before(pending.CreatedAt)) {
+			pending = job
+		}
+	}
+	return pending, nil
+}
+
+// Submit registers a new queued download for sub, unless Existing finds
+// one, checking and creating under one lock so two identical requests
+// arriving together still make one job. created reports which happened.
+func (s *Store) Submit(sub Submission) (job Job, created bool, err error) {
+	s.mu.Lock()
+	found, err := s.existing(sub)
+	if err != nil {
+		s.mu.Unlock()
+		return Job{}, false, err
+	}
+	if found != nil {
+		c := found.clone()
+		s.mu.Unlock()
+		return c, false, nil
+	}
+
+	now := time.Now()
+	j := &Job{
+		ID:             newID(),
+		Kind:           KindDownload,
+		URL:            sub.URL,
+		Args:           sub.Args,
+		Folder:         sub.Folder,
+		Priority:       sub.Priority,
+		User:           sub.User,
+		Profile:        sub.Profile,
+		Limits:         sub.Limits,
+		IdempotencyKey: sub.IdempotencyKey,
+		Trace:          sub.Trace,
+		State:          StateQueued,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	s.jobs[j.ID] = j
+	s.changed(j)
+	c := j.clone()
+	s.mu.Unlock()
+
+	s.save()
+	return c, true, nil
+}
@@ -0,0 +1,99 @@
+// Package jsonnum decodes numbers the way yt-dlp actually writes them.
+// Counts and sizes turn up as floats (1234.0, 1.5e6), as strings ("1,234"),
+// or as null, depending on the site and the yt-dlp version; these types
+// read all of them where a plain int or float64 field would fail the
+// whole document. They encode as ordinary JSON numbers.
+package jsonnum
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Int is an int that also reads floats, dropping the fraction, numeric
+// strings and null
+type Int int
+
+func (n *Int) UnmarshalJSON(data []byte) error {
+	i, ok, err := parseInt(data, strconv.IntSize)
+	if ok {
+		*n = Int(i)
+	}
+	return err
+}
+
+// Int64 is an int64 that also reads floats, dropping the fraction,
+// numeric strings and null, such as Unix timestamps written as
+// 1638993661.512
+type Int64 int64
+
+func (n *Int64) UnmarshalJSON(data []byte) error {
+	i, ok, err := parseInt(data, 64)
+	if ok {
+		*n = Int64(i)
+	}
+	return err
+}
+
+// Float is a float64 that also reads numeric strings and null
+type Float float64
+
+func (n *Float) UnmarshalJSON(data []byte) error {
+	s, ok, err := number(data)
+	if !ok {
+		return err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("jsonnum: %s is not a number", data)
+	}
+	*n = Float(f)
+	return nil
+}
+
+// parseInt reads a whole number of the given bit size, exactly when it's
+// written as one and otherwise through a float, with ok false when data
+// leaves the value as it was
+func parseInt(data []byte, bits int) (int64, bool, error) {
+	s, ok, err := number(data)
+	if !ok {
+		return 0, false, err
+	}
+	if i, err := strconv.ParseInt(s, 10, bits); err == nil {
+		return i, true, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false, fmt.Errorf("jsonnum: %s is not a number", data)
+	}
+	f = math.Trunc(f)
+	if f >= math.Ldexp(1, bits-1) || f < -math.Ldexp(1, bits-1) {
+		return 0, false, fmt.Errorf("jsonnum: %s is out of range for an int%d", data, bits)
+	}
+	return int64(f), true, nil
+}
+
+// number is the number data holds, as a JSON number or a string, with
+// thousands separators removed. ok is false for null and empty strings.
+func number(data []byte) (s string, ok bool, err error) {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return "", false, nil
+	}
+	s = string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return "", false, err
+		}
+		// As in "1,234" or "1 234"
+		s = strings.NewReplacer(",", "", "_", "", " ", "", "\u00a0", "").Replace(s)
+		if s == "" {
+			return "", false, nil
+		}
+	}
+	return s, true, nil
+}
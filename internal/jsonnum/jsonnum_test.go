@@ -0,0 +1,185 @@
+package jsonnum
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Int
+		wantErr bool
+	}{
+		{"integer", `1234`, 1234, false},
+		{"negative", `-5`, -5, false},
+		{"float", `1234.0`, 1234, false},
+		{"fraction is dropped", `12.9`, 12, false},
+		{"negative fraction is dropped", `-12.9`, -12, false},
+		{"exponent", `1.5e6`, 1500000, false},
+		{"string", `"1234"`, 1234, false},
+		{"string float", `"12.5"`, 12, false},
+		{"comma separators", `"21,234,567"`, 21234567, false},
+		{"underscore separators", `"1_000"`, 1000, false},
+		{"space separators", `"64 113"`, 64113, false},
+		{"no-break space separators", "\"64\u00a0113\"", 64113, false},
+		{"surrounding space", ` 42 `, 42, false},
+		{"null keeps the value", `null`, 7, false},
+		{"empty string keeps the value", `""`, 7, false},
+		{"separators only keep the value", `","`, 7, false},
+		{"word", `"many"`, 7, true},
+		{"mixed", `"12 views"`, 7, true},
+		{"two points", `"1.2.3"`, 7, true},
+		{"NaN", `"NaN"`, 7, true},
+		{"infinity", `"Inf"`, 7, true},
+		{"overflowing float", `"1e400"`, 7, true},
+		{"out of range", `1e30`, 7, true},
+		{"boolean", `true`, 7, true},
+		{"object", `{}`, 7, true},
+		{"array", `[1]`, 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Int(7)
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInt64(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Int64
+		wantErr bool
+	}{
+		{"timestamp", `1734900123`, 1734900123, false},
+		{"fractional timestamp", `1734900123.512`, 1734900123, false},
+		{"largest", `9223372036854775807`, 9223372036854775807, false},
+		{"smallest", `-9223372036854775808`, -9223372036854775808, false},
+		{"largest as a string", `"9223372036854775807"`, 9223372036854775807, false},
+		{"beyond int32", `"4,294,967,296"`, 4294967296, false},
+		{"exponent", `2.5E9`, 2500000000, false},
+		{"null keeps the value", `null`, 7, false},
+		{"empty string keeps the value", `""`, 7, false},
+		{"out of range", `1e19`, 7, true},
+		{"out of range negative", `-1e19`, 7, true},
+		{"out of range string", `"92233720368547758070"`, 7, true},
+		{"word", `"unknown"`, 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Int64(7)
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFloat(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Float
+		wantErr bool
+	}{
+		{"float", `14.533`, 14.533, false},
+		{"integer", `320`, 320, false},
+		{"exponent", `1.5e-3`, 0.0015, false},
+		{"string", `"29.97"`, 29.97, false},
+		{"string with separators", `"1,234.5"`, 1234.5, false},
+		{"null keeps the value", `null`, 7, false},
+		{"empty string keeps the value", `""`, 7, false},
+		{"word", `"fast"`, 7, true},
+		{"NaN", `"NaN"`, 7, true},
+		{"infinity", `"+Inf"`, 7, true},
+		{"overflowing", `"1e400"`, 7, true},
+		{"boolean", `false`, 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Float(7)
+			err := json.Unmarshal([]byte(tt.in), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %g, want %g", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	v := struct {
+		I Int
+		L Int64
+		F Float
+	}{1234, 1734900123, 29.97}
+	got, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"I":1234,"L":1734900123,"F":29.97}`; string(got) != want {
+		t.Errorf("Marshal = %s, want %s", got, want)
+	}
+}
+
+// recorded are the numbers of a yt-dlp .info.json that sites write in the
+// most varied ways
+type recorded struct {
+	Duration         Float `json:"duration"`
+	Timestamp        Int64 `json:"timestamp"`
+	ReleaseTimestamp Int64 `json:"release_timestamp"`
+	ViewCount        Int64 `json:"view_count"`
+	LikeCount        Int   `json:"like_count"`
+	CommentCount     Int   `json:"comment_count"`
+	Width            Int   `json:"width"`
+	Height           Int   `json:"height"`
+	FPS              Float `json:"fps"`
+}
+
+// TestRecorded decodes sidecars recorded from sites that write numbers as
+// floats, as strings with separators and as null
+func TestRecorded(t *testing.T) {
+	tests := []struct {
+		file string
+		want recorded
+	}{
+		// Floats for whole numbers, and null counts
+		{"instagram-2023.07.06", recorded{Duration: 14.533, Timestamp: 1688600001, CommentCount: 1204, Width: 1080, Height: 1920, FPS: 30}},
+		// Counts as strings with comma and space separators
+		{"niconico-2023.03.04", recorded{Duration: 320, Timestamp: 1173108780, ViewCount: 21234567, LikeCount: 64113, CommentCount: 5012311, Width: 512, Height: 384, FPS: 29.97}},
+		// A fractional timestamp, a float count and a null release timestamp
+		{"twitch-2024.12.23", recorded{Duration: 11422, Timestamp: 1734900123, ViewCount: 48211, Width: 1920, Height: 1080, FPS: 59.94}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("..", "library", "testdata", "ytdlp", tt.file+".info.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got recorded
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package language identifies the language of a video from its metadata or
+// the text of its transcript.
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+// names are the languages Detect can recognise, by ISO 639-1 code
+var names = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"sv": "Swedish",
+	"th": "Thai",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"zh": "Chinese",
+}
+
+// Name returns the English name of the language with code, or the code
+// itself for languages it doesn't know
+func Name(code string) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return code
+}
+
+// Normalize reduces a language tag such as "en-US" or "pt_BR" to its
+// lowercase primary subtag, "en" or "pt"
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// stopwords are frequent short words of the languages written in Latin
+// script, which tell them apart in running text
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "it", "you", "was", "for", "on", "are", "with", "this", "have", "be", "not", "but", "what", "they"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "las", "por", "una", "para", "con", "no", "es", "se", "del", "pero", "como", "más", "muy"},
+	"fr": {"le", "la", "les", "de", "des", "et", "est", "une", "que", "pour", "pas", "qui", "dans", "sur", "avec", "ce", "il", "je", "vous", "nous"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "sie", "es", "ein", "eine", "zu", "den", "mit", "auf", "für", "sich", "auch", "wir", "aber"},
+	"it": {"il", "di", "che", "è", "la", "per", "non", "una", "sono", "un", "del", "della", "con", "ma", "anche", "questo", "gli", "lo", "ho", "mi"},
+	"pt": {"de", "que", "não", "o", "a", "e", "do", "da", "em", "um", "uma", "para", "com", "os", "as", "é", "mas", "você", "isso", "muito"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "dat", "op", "te", "ik", "je", "zijn", "met", "voor", "maar", "ook", "wat", "er", "naar"},
+	"sv": {"och", "att", "det", "som", "en", "är", "på", "för", "med", "inte", "jag", "till", "har", "av", "den", "vi", "om", "så", "men", "ett"},
+	"pl": {"i", "nie", "się", "w", "na", "że", "to", "jest", "z", "do", "jak", "ale", "co", "tak", "czy", "już", "mnie", "tylko", "jestem", "go"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ne", "çok", "ile", "ama", "gibi", "daha", "var", "mi", "ben", "sen", "o", "değil", "olarak", "kadar"},
+}
+
+// minHits is how many stopwords text needs before Detect trusts a guess
+const minHits = 5
+
+// Detect guesses the language text is written in, returning "" when it
+// can't tell. Scripts used by one language decide it outright; text in
+// Latin script is scored by stopwords.
+func Detect(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	cyrillicUK := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			scripts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+			cyrillicUK = cyrillicUK || strings.ContainsRune("іїєґІЇЄҐ", r)
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	for code, n := range scripts {
+		if code != "ja" && n > letters/2 {
+			if code == "ru" && cyrillicUK {
+				return "uk"
+			}
+			return code
+		}
+	}
+	return detectLatin(text)
+}
+
+// detectLatin scores text against each language's stopwords
+func detectLatin(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	counts := make(map[string]int, len(words))
+	for _, w := range words {
+		counts[w]++
+	}
+
+	best, bestHits, runnerUp := "", 0, 0
+	for code, list := range stopwords {
+		hits := 0
+		for _, w := range list {
+			hits += counts[w]
+		}
+		switch {
+		case hits > bestHits:
+			best, bestHits, runnerUp = code, hits, bestHits
+		case hits > runnerUp:
+			runnerUp = hits
+		}
+	}
+	// A tie, or too little text, is no answer
+	if bestHits < minHits || bestHits == runnerUp {
+		return ""
+	}
+	return best
+}
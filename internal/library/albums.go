@@ -0,0 +1,294 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageExtensions are the image types kept in albums
+var ImageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+	".avif": true,
+	".bmp":  true,
+}
+
+// Album is an image gallery, such as an Imgur album or a Reddit gallery,
+// downloaded into one folder. Albums are kept apart from videos.
+type Album struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Site      string `json:"site,omitempty"`
+	Uploader  string `json:"uploader,omitempty"`
+	SourceURL string `json:"source_url,omitempty"`
+	// Folder holds the album's files, relative to the videos directory
+	Folder  string      `json:"folder"`
+	Items   []AlbumItem `json:"items"`
+	Size    int64       `json:"size"`
+	AddedAt time.Time   `json:"added_at"`
+	// AddedBy is the user whose download added the album
+	AddedBy string `json:"added_by,omitempty"`
+}
+
+// AlbumItem is one image or clip in an album
+type AlbumItem struct {
+	// Path is relative to the videos directory, slash-separated
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Video is set for clips, which are also indexed as library videos
+	Video bool `json:"video,omitempty"`
+}
+
+// Cover returns the path of the album's first image, or "" if it has none
+func (a Album) Cover() string {
+	for _, item := range a.Items {
+		if !item.Video {
+			return item.Path
+		}
+	}
+	return ""
+}
+
+func (a *Album) clone() Album {
+	c := *a
+	c.Items = append([]AlbumItem(nil), a.Items...)
+	return c
+}
+
+// albumIndex holds the albums and their albums.json
+type albumIndex struct {
+	path string
+
+	mu     sync.Mutex
+	albums map[string]*Album
+}
+
+// LoadAlbums reads the persisted albums, if any
+func (s *VideoService) LoadAlbums() error {
+	data, err := os.ReadFile(s.albums.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var list []*Album
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.albums.path, err)
+	}
+
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+	for _, a := range list {
+		s.albums.albums[a.ID] = a
+	}
+	return nil
+}
+
+// saveAlbums writes albums.json; the caller holds albums.mu
+func (s *VideoService) saveAlbums() error {
+	list := make([]*Album, 0, len(s.albums.albums))
+	for _, a := range s.albums.albums {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.albums.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.albums.path, data, 0644)
+}
+
+// Albums returns every album, most recently added first
+func (s *VideoService) Albums() []Album {
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+
+	list := make([]Album, 0, len(s.albums.albums))
+	for _, a := range s.albums.albums {
+		list = append(list, a.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].AddedAt.After(list[j].AddedAt) })
+	return list
+}
+
+// Album returns the album with id
+func (s *VideoService) Album(id string) (Album, bool) {
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+
+	a, ok := s.albums.albums[id]
+	if !ok {
+		return Album{}, false
+	}
+	return a.clone(), true
+}
+
+// AddAlbum records the files a gallery download wrote, grouped into one
+// album per folder, and returns the albums. Downloading a gallery again
+// adds its new files to the existing album. Title, site and uploader come
+// from the metadata gallery-dl writes beside each file as <file>.json.
+// addedBy is recorded on albums the call creates.
+func (s *VideoService) AddAlbum(sourceURL, addedBy string, files []string) ([]Album, error) {
+	byFolder := make(map[string][]AlbumItem)
+	var folders []string
+	meta := make(map[string]galleryMetadata)
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file))
+		if !ImageExtensions[ext] && !VideoExtensions[ext] {
+			continue
+		}
+		rel, err := relPath(s.dir, file)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		folder := path.Dir(rel)
+		if folder == "." {
+			folder = ""
+		}
+		if _, ok := byFolder[folder]; !ok {
+			folders = append(folders, folder)
+			meta[folder] = loadGalleryMetadata(file + ".json")
+		}
+		byFolder[folder] = append(byFolder[folder], AlbumItem{Path: rel, Size: fi.Size(), Video: VideoExtensions[ext]})
+	}
+
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+
+	var added []Album
+	for _, folder := range folders {
+		id := pathID("album:" + folder)
+		a, ok := s.albums.albums[id]
+		if !ok {
+			m := meta[folder]
+			a = &Album{
+				ID:        id,
+				Title:     m.Title,
+				Site:      m.Category,
+				Uploader:  m.Uploader,
+				SourceURL: sourceURL,
+				Folder:    folder,
+				AddedAt:   time.Now(),
+				AddedBy:   addedBy,
+			}
+			if a.Title == "" {
+				a.Title = path.Base("/" + folder)
+			}
+			s.albums.albums[id] = a
+		}
+
+		known := make(map[string]bool)
+		for _, item := range a.Items {
+			known[item.Path] = true
+		}
+		for _, item := range byFolder[folder] {
+			if !known[item.Path] {
+				a.Items = append(a.Items, item)
+				a.Size += item.Size
+			}
+		}
+		sort.Slice(a.Items, func(i, j int) bool { return a.Items[i].Path < a.Items[j].Path })
+		added = append(added, a.clone())
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	return added, s.saveAlbums()
+}
+
+// RemoveAlbum deletes an album's files, with their metadata, and its
+// record. Clips are left to the caller, as they are also library videos.
+func (s *VideoService) RemoveAlbum(id string) (Album, error) {
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+
+	a, ok := s.albums.albums[id]
+	if !ok {
+		return Album{}, fmt.Errorf("album %s not found", id)
+	}
+	for _, item := range a.Items {
+		if item.Video {
+			continue
+		}
+		file := filepath.Join(s.dir, filepath.FromSlash(item.Path))
+		for _, f := range []string{file, file + ".json"} {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return Album{}, err
+			}
+		}
+	}
+	// Only removes the folder once it is empty
+	if a.Folder != "" {
+		os.Remove(filepath.Join(s.dir, filepath.FromSlash(a.Folder)))
+	}
+
+	delete(s.albums.albums, id)
+	log.Printf("Deleted album %s (%s)", a.ID, a.Folder)
+	return a.clone(), s.saveAlbums()
+}
+
+// galleryMetadata is the part of gallery-dl's per-file metadata used to
+// describe an album. Field names vary by site, so several are tried.
+type galleryMetadata struct {
+	Title    string
+	Category string
+	Uploader string
+}
+
+func loadGalleryMetadata(path string) galleryMetadata {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return galleryMetadata{}
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return galleryMetadata{}
+	}
+
+	m := galleryMetadata{Category: stringField(fields, "category")}
+	for _, key := range []string{"album", "gallery", "post"} {
+		if nested, ok := fields[key].(map[string]interface{}); ok && m.Title == "" {
+			m.Title = stringField(nested, "title")
+		}
+	}
+	if m.Title == "" {
+		m.Title = stringField(fields, "gallery_title", "title")
+	}
+	m.Uploader = stringField(fields, "uploader", "username", "author", "user")
+	for _, key := range []string{"user", "author", "owner"} {
+		if nested, ok := fields[key].(map[string]interface{}); ok && m.Uploader == "" {
+			m.Uploader = stringField(nested, "name", "username", "nick")
+		}
+	}
+	return m
+}
+
+// stringField returns the first of keys holding a non-empty string
+func stringField(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := fields[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package library
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// benchService is a library of n videos, indexed without files so large
+// ones are quick to set up
+func benchService(b *testing.B, n int) *VideoService {
+	b.Helper()
+	s := newTestService(b)
+	uploaders := []string{"Alice", "Bob", "Carol", "Dave"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("video-%06d", i)
+		s.videos[id] = &Video{
+			ID:         id,
+			FilePath:   fmt.Sprintf("folder-%d/%s.mp4", i%10, id),
+			Title:      fmt.Sprintf("Video number %d about topic %d", i, i%50),
+			Uploader:   uploaders[i%len(uploaders)],
+			Extractor:  "Youtube",
+			Duration:   float64(60 + i%3600),
+			Size:       int64(1<<20 + i),
+			Tags:       []string{"tag", fmt.Sprint("tag-", i%20)},
+			ModTime:    start.Add(time.Duration(i) * time.Minute),
+			UploadDate: start.AddDate(0, 0, i%365).Format("20060102"),
+		}
+	}
+	s.mu.Unlock()
+	return s
+}
+
+var benchSizes = []int{100, 1000, 10000}
+
+// BenchmarkList copies out the whole index, as every listing does
+func BenchmarkList(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("videos=%d", n), func(b *testing.B) {
+			s := benchService(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.List()
+			}
+		})
+	}
+}
+
+// BenchmarkFind runs the queries /api/videos gets most
+func BenchmarkFind(b *testing.B) {
+	folder := "folder-3"
+	queries := []struct {
+		name string
+		q    Query
+	}{
+		{"all", Query{}},
+		{"uploader", Query{Uploader: "bob"}},
+		{"folder", Query{Folder: &folder}},
+		{"duration", Query{MinDuration: 600, MaxDuration: 1200}},
+		{"sorted", Query{Sort: []SortKey{{Field: "title"}}}},
+		{"text", Query{Text: "topic 7"}},
+	}
+	for _, n := range benchSizes {
+		s := benchService(b, n)
+		for _, query := range queries {
+			b.Run(fmt.Sprintf("videos=%d/%s", n, query.name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					s.Find(query.q)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkGet looks up videos from several goroutines at once
+func BenchmarkGet(b *testing.B) {
+	s := benchService(b, 10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Get(fmt.Sprintf("video-%06d", i%10000))
+			i++
+		}
+	})
+}
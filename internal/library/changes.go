@@ -0,0 +1,117 @@
+package library
+
+import (
+	"sort"
+	"time"
+)
+
+// removedKeep is how long removals are remembered for clients syncing
+// changes. A cursor older than that gets the whole library again.
+const removedKeep = 7 * 24 * time.Hour
+
+// changeLog tracks when the index last changed and which videos were
+// removed, so clients can fetch only what changed since their last sync.
+// It is guarded by the service's mu.
+type changeLog struct {
+	// last is the time of the latest change. Each change gets a later
+	// time than the one before, even when the clock steps back.
+	last time.Time
+	// floor is the earliest cursor changes can be answered from: removals
+	// before it, such as before the server started, aren't known
+	floor time.Time
+	// removed maps the IDs of removed videos to when they were removed
+	removed map[string]time.Time
+}
+
+// stamp marks v as changed now. The caller holds s.mu.
+func (s *VideoService) stamp(v *Video) {
+	v.UpdatedAt = s.changes.next()
+	delete(s.changes.removed, v.ID)
+}
+
+// next returns the time of a new change
+func (c *changeLog) next() time.Time {
+	now := time.Now().Round(0)
+	if !now.After(c.last) {
+		now = c.last.Add(time.Nanosecond)
+	}
+	c.last = now
+	return now
+}
+
+// forget records that the video with id was removed. The caller holds
+// s.mu.
+func (s *VideoService) forget(id string) {
+	c := &s.changes
+	if c.removed == nil {
+		c.removed = make(map[string]time.Time)
+	}
+	now := c.next()
+	c.removed[id] = now
+	for removedID, at := range c.removed {
+		if now.Sub(at) > removedKeep {
+			delete(c.removed, removedID)
+			if at.After(c.floor) {
+				c.floor = at
+			}
+		}
+	}
+}
+
+// Changes are the index's changes since a cursor
+type Changes struct {
+	// Videos are the videos added or changed that match the query, in its
+	// sort order
+	Videos []Video
+	// Removed are the IDs of the videos removed, and of those changed so
+	// that they no longer match the query
+	Removed []string
+	// Cursor is the time of the latest change, to ask for the changes
+	// after it next time
+	Cursor time.Time
+}
+
+// LastChange returns the time the index last changed
+func (s *VideoService) LastChange() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.changes.last
+}
+
+// ChangesSince returns what changed after cursor in the listing of the
+// videos matching q. It returns false when cursor is from before the
+// changes the service knows of, such as one from before a restart, and the
+// caller has to list the videos in full instead.
+func (s *VideoService) ChangesSince(cursor time.Time, q Query) (Changes, bool) {
+	s.mu.RLock()
+	if cursor.Before(s.changes.floor) {
+		s.mu.RUnlock()
+		return Changes{}, false
+	}
+	changes := Changes{Cursor: s.changes.last}
+	var changed []Video
+	for _, v := range s.videos {
+		if v.UpdatedAt.After(cursor) {
+			changed = append(changed, v.clone())
+		}
+	}
+	for id, at := range s.changes.removed {
+		if at.After(cursor) {
+			changes.Removed = append(changes.Removed, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Matching may read details, so it's done outside the lock
+	q = s.searching(q)
+	for _, v := range changed {
+		if q.Match(v) {
+			changes.Videos = append(changes.Videos, v)
+		} else {
+			changes.Removed = append(changes.Removed, v.ID)
+		}
+	}
+	SortVideos(changes.Videos, q.Sort)
+	sort.Strings(changes.Removed)
+	return changes, true
+}
@@ -0,0 +1,186 @@
+package library
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Cleanup categories
+const (
+	CleanupUntracked    = "untracked_files"
+	CleanupMissing      = "missing_files"
+	CleanupFragments    = "stale_fragments"
+	CleanupUnreferenced = "unreferenced_sidecars"
+)
+
+// fragmentPattern matches partial downloads left by yt-dlp and the HTTP
+// backend
+var fragmentPattern = regexp.MustCompile(`\.(part|ytdl|temp)$|\.part-Frag\d+(\.part)?$`)
+
+// IsFragment reports whether name is a partial download rather than a
+// finished file
+func IsFragment(name string) bool {
+	return fragmentPattern.MatchString(name)
+}
+
+// galleryDir is where gallery-dl puts its downloads
+const galleryDir = "gallery-dl"
+
+// OrphanFile is a file found by a cleanup scan
+type OrphanFile struct {
+	// Path is relative to the videos directory, slash-separated
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// CleanupReport lists what a cleanup scan found
+type CleanupReport struct {
+	// UntrackedFiles are video files with no library record
+	UntrackedFiles []OrphanFile `json:"untracked_files"`
+	// MissingFiles are library records whose local file is gone
+	MissingFiles []Video `json:"missing_files"`
+	// StaleFragments are partial downloads older than the stale age
+	StaleFragments []OrphanFile `json:"stale_fragments"`
+	// UnreferencedSidecars are thumbnails, subtitles and .info.json files
+	// whose video no longer exists
+	UnreferencedSidecars []OrphanFile `json:"unreferenced_sidecars"`
+	// Reclaimable is the total size of the orphaned files
+	Reclaimable int64 `json:"reclaimable_bytes"`
+}
+
+// FindOrphans scans the videos directory for files the library doesn't
+// account for. Fragments are only reported once older than staleAfter so
+// running downloads aren't touched.
+func (s *VideoService) FindOrphans(staleAfter time.Duration) (CleanupReport, error) {
+	report := CleanupReport{
+		UntrackedFiles:       []OrphanFile{},
+		MissingFiles:         []Video{},
+		StaleFragments:       []OrphanFile{},
+		UnreferencedSidecars: []OrphanFile{},
+	}
+
+	tracked := make(map[string]bool)
+	stems := make(map[string]bool)
+	for _, v := range s.List() {
+		// Only the videos directory is swept; pools hold finished videos
+		if v.Pool == "" {
+			tracked[v.FilePath] = true
+			stems[strings.TrimSuffix(v.FilePath, filepath.Ext(v.FilePath))] = true
+		}
+		if !v.IsRemote() {
+			if _, err := os.Stat(s.AbsPath(v)); os.IsNotExist(err) {
+				report.MissingFiles = append(report.MissingFiles, v)
+			}
+		}
+	}
+
+	var sidecars []OrphanFile
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != s.dir && s.isPoolDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		file := OrphanFile{Path: rel, Size: info.Size(), ModTime: info.ModTime()}
+
+		switch {
+		case fragmentPattern.MatchString(d.Name()):
+			if time.Since(info.ModTime()) > staleAfter {
+				report.StaleFragments = append(report.StaleFragments, file)
+				report.Reclaimable += file.Size
+			}
+		case strings.HasPrefix(rel, galleryDir+"/"):
+			// gallery-dl output isn't indexed
+		case IsVideoFile(d.Name()):
+			// Sidecars of untracked videos are reported with the video
+			stems[strings.TrimSuffix(rel, filepath.Ext(rel))] = true
+			if !tracked[rel] {
+				report.UntrackedFiles = append(report.UntrackedFiles, file)
+				report.Reclaimable += file.Size
+			}
+		case strings.HasPrefix(d.Name(), "."):
+			// Hidden files such as .write_test belong to the server
+		default:
+			sidecars = append(sidecars, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, file := range sidecars {
+		if !hasStem(file.Path, stems) {
+			report.UnreferencedSidecars = append(report.UnreferencedSidecars, file)
+			report.Reclaimable += file.Size
+		}
+	}
+	return report, nil
+}
+
+// DeleteOrphans removes the files and records in the given categories of
+// report, returning the bytes freed
+func (s *VideoService) DeleteOrphans(report CleanupReport, categories []string) int64 {
+	var freed int64
+	remove := func(files []OrphanFile) {
+		for _, f := range files {
+			if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(f.Path))); err != nil {
+				log.Printf("Failed to remove %s: %v", f.Path, err)
+				continue
+			}
+			log.Printf("Removed orphaned file %s", f.Path)
+			freed += f.Size
+		}
+	}
+
+	for _, category := range categories {
+		switch category {
+		case CleanupUntracked:
+			remove(report.UntrackedFiles)
+		case CleanupFragments:
+			remove(report.StaleFragments)
+		case CleanupUnreferenced:
+			remove(report.UnreferencedSidecars)
+		case CleanupMissing:
+			for _, v := range report.MissingFiles {
+				if _, err := s.Remove(v.ID); err != nil {
+					log.Printf("Failed to remove record %s: %v", v.ID, err)
+				}
+			}
+		}
+	}
+	return freed
+}
+
+// hasStem reports whether name starts with one of stems followed by a dot
+func hasStem(name string, stems map[string]bool) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' && stems[name[:i]] {
+			return true
+		}
+	}
+	return false
+}
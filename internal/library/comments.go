@@ -0,0 +1,37 @@
+package library
+
+import "noahjalex.ute/internal/jsonnum"
+
+// maxComments caps the comments kept with a video, whatever yt-dlp wrote
+const maxComments = 500
+
+// Comment is a top-level comment on a video, as yt-dlp's --write-comments
+// records it
+type Comment struct {
+	ID        string        `json:"id"`
+	Author    string        `json:"author"`
+	Text      string        `json:"text"`
+	Timestamp jsonnum.Int64 `json:"timestamp,omitempty"`
+	LikeCount jsonnum.Int   `json:"like_count,omitempty"`
+	IsPinned  bool          `json:"is_pinned,omitempty"`
+	// Parent is "root" for top-level comments and otherwise the ID of
+	// the comment replied to
+	Parent string `json:"parent,omitempty"`
+}
+
+// topLevelComments keeps the comments that aren't replies, in yt-dlp's
+// order, up to maxComments
+func topLevelComments(comments []Comment) []Comment {
+	var kept []Comment
+	for _, c := range comments {
+		if c.Parent != "" && c.Parent != "root" {
+			continue
+		}
+		c.Parent = ""
+		kept = append(kept, c)
+		if len(kept) == maxComments {
+			break
+		}
+	}
+	return kept
+}
@@ -0,0 +1,107 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+)
+
+// Duplicate reasons
+const (
+	DuplicateExtractorID = "extractor_id"
+	DuplicateHash        = "hash"
+)
+
+// DuplicateGroup is a set of videos that hold the same content
+type DuplicateGroup struct {
+	Reason string  `json:"reason"`
+	Key    string  `json:"key"`
+	Videos []Video `json:"videos"`
+	// Reclaimable is the space freed by keeping only the largest copy
+	Reclaimable int64 `json:"reclaimable_bytes"`
+}
+
+// HashFile returns the hex SHA-256 of the file at path
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Duplicates groups videos downloaded from the same source video or with
+// identical content. A pair matching on both counts is reported once, under
+// the extractor ID.
+func (s *VideoService) Duplicates() []DuplicateGroup {
+	videos := s.List()
+
+	byExtractor := make(map[string][]Video)
+	for _, v := range videos {
+		if v.ExtractorID != "" {
+			key := v.Extractor + ":" + v.ExtractorID
+			byExtractor[key] = append(byExtractor[key], v)
+		}
+	}
+
+	var groups []DuplicateGroup
+	grouped := make(map[string]string)
+	for key, members := range byExtractor {
+		if len(members) < 2 {
+			continue
+		}
+		for _, v := range members {
+			grouped[v.ID] = key
+		}
+		groups = append(groups, newDuplicateGroup(DuplicateExtractorID, key, members))
+	}
+
+	byHash := make(map[string][]Video)
+	for _, v := range videos {
+		if v.SHA256 != "" {
+			byHash[v.SHA256] = append(byHash[v.SHA256], v)
+		}
+	}
+	for hash, members := range byHash {
+		if len(members) < 2 || sameGroup(members, grouped) {
+			continue
+		}
+		groups = append(groups, newDuplicateGroup(DuplicateHash, hash, members))
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Reclaimable > groups[j].Reclaimable
+	})
+	return groups
+}
+
+func newDuplicateGroup(reason, key string, videos []Video) DuplicateGroup {
+	var total, largest int64
+	for _, v := range videos {
+		total += v.Size
+		largest = max(largest, v.Size)
+	}
+	return DuplicateGroup{Reason: reason, Key: key, Videos: videos, Reclaimable: total - largest}
+}
+
+// sameGroup reports whether every video already shares an extractor group
+func sameGroup(videos []Video, grouped map[string]string) bool {
+	first, ok := grouped[videos[0].ID]
+	if !ok {
+		return false
+	}
+	for _, v := range videos[1:] {
+		if grouped[v.ID] != first {
+			return false
+		}
+	}
+	return true
+}
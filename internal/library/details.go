@@ -0,0 +1,106 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Details are the bulky fields of a video. The in-memory index and
+// metadata.json only hold what listings and filters need; each video's
+// details are kept in a file of their own under the data directory and
+// read when a single video is looked at.
+type Details struct {
+	Description string    `json:"description,omitempty"`
+	Chapters    []Chapter `json:"chapters,omitempty"`
+	Transcript  string    `json:"transcript,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Comments    []Comment `json:"comments,omitempty"`
+}
+
+func (d Details) empty() bool {
+	return d.Description == "" && len(d.Chapters) == 0 && d.Transcript == "" && d.Summary == "" &&
+		len(d.Comments) == 0
+}
+
+func (d Details) equal(o Details) bool {
+	return d.Description == o.Description && slices.Equal(d.Chapters, o.Chapters) &&
+		d.Transcript == o.Transcript && d.Summary == o.Summary && slices.Equal(d.Comments, o.Comments)
+}
+
+// setDetails fills in v's bulky fields
+func (v *Video) setDetails(d Details) {
+	v.Description, v.Chapters, v.Transcript, v.Summary = d.Description, d.Chapters, d.Transcript, d.Summary
+	v.Comments = d.Comments
+}
+
+// takeDetails clears v's bulky fields, returning them
+func (v *Video) takeDetails() Details {
+	d := Details{
+		Description: v.Description,
+		Chapters:    v.Chapters,
+		Transcript:  v.Transcript,
+		Summary:     v.Summary,
+		Comments:    v.Comments,
+	}
+	v.setDetails(Details{})
+	return d
+}
+
+func (s *VideoService) detailsPath(id string) string {
+	return filepath.Join(s.detailsDir, id+".json")
+}
+
+// Details reads the bulky fields of the video with id. Videos without any
+// have empty details.
+func (s *VideoService) Details(id string) (Details, error) {
+	var d Details
+	data, err := os.ReadFile(s.detailsPath(id))
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return d, err
+	}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return d, fmt.Errorf("parsing %s: %w", s.detailsPath(id), err)
+	}
+	return d, nil
+}
+
+// writeDetails persists the details of the video with id, removing the
+// file when they are empty
+func (s *VideoService) writeDetails(id string, d Details) error {
+	if d.empty() {
+		if err := os.Remove(s.detailsPath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.detailsDir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.detailsPath(id), data, 0644)
+}
+
+// GetFull returns the video with id including its details, for showing a
+// single video. Details that can't be read are logged and left empty.
+func (s *VideoService) GetFull(id string) (Video, bool) {
+	v, ok := s.Get(id)
+	if !ok {
+		return v, false
+	}
+	d, err := s.Details(id)
+	if err != nil {
+		log.Printf("Failed to read the details of %s: %v", id, err)
+	}
+	v.setDetails(d)
+	return v, true
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package library
+
+import "errors"
+
+// DiskUsage is not implemented on this platform
+func DiskUsage(path string) (free, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package library
+
+import "syscall"
+
+// DiskUsage returns the free and total bytes of the filesystem holding path
+func DiskUsage(path string) (free, total uint64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	// Field types vary between platforms
+	return uint64(fs.Bavail) * uint64(fs.Bsize), uint64(fs.Blocks) * uint64(fs.Bsize), nil
+}
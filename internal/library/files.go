@@ -0,0 +1,69 @@
+package library
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// MoveFile renames src to dst, copying across filesystems when a rename
+// isn't possible. A copy is written to a hidden temporary file beside dst
+// and renamed into place, so dst never exists half-written. An existing
+// dst is never overwritten.
+func MoveFile(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return &os.PathError{Op: "move", Path: dst, Err: os.ErrExist}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.part")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// ThumbnailPath returns the first image sidecar next to videoPath, or ""
+func ThumbnailPath(videoPath string) string {
+	stem := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	for _, ext := range []string{".jpg", ".jpeg", ".webp", ".png"} {
+		if _, err := os.Stat(stem + ext); err == nil {
+			return stem + ext
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package library
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"noahjalex.ute/internal/pathutil"
+)
+
+// Folder is a subdirectory of the videos directory
+type Folder struct {
+	Name string `json:"name"`
+	// Path is relative to the videos directory, slash-separated
+	Path string `json:"path"`
+	// Videos and Bytes count everything below the folder
+	Videos int   `json:"videos"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// CleanRelPath validates a slash-separated path relative to the videos
+// directory, rejecting absolute paths, drive letters, backslashes, "." or
+// ".." segments and names the platform can't store. Leading and trailing
+// slashes are trimmed; "" is the root.
+func CleanRelPath(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "\\\x00") || filepath.IsAbs(p) || filepath.VolumeName(p) != "" || hasDriveLetter(p) {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "" || seg == "." || seg == ".." || !pathutil.ValidSegment(seg) {
+			return "", fmt.Errorf("invalid path %q", p)
+		}
+	}
+	return p, nil
+}
+
+// hasDriveLetter reports whether p starts like a Windows drive path such
+// as "C:", which filepath only recognises when running on Windows
+func hasDriveLetter(p string) bool {
+	return len(p) >= 2 && p[1] == ':' &&
+		('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z')
+}
+
+// Folder returns the folder holding the video, "" for the root
+func (v Video) Folder() string {
+	dir := path.Dir(v.FilePath)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// InFolder returns the videos directly inside folder
+func (s *VideoService) InFolder(folder string) []Video {
+	return s.Find(Query{Folder: &folder})
+}
+
+// Folders lists the immediate subfolders of parent, including empty ones
+// on disk and ones only known from remote videos
+func (s *VideoService) Folders(parent string) []Folder {
+	folders := make(map[string]*Folder)
+	get := func(name string) *Folder {
+		f, ok := folders[name]
+		if !ok {
+			f = &Folder{Name: name, Path: path.Join(parent, name)}
+			folders[name] = f
+		}
+		return f
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(s.dir, filepath.FromSlash(parent)))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			get(entry.Name())
+		}
+	}
+
+	prefix := ""
+	if parent != "" {
+		prefix = parent + "/"
+	}
+	for _, v := range s.List() {
+		rest, ok := strings.CutPrefix(v.FilePath, prefix)
+		if !ok {
+			continue
+		}
+		name, _, nested := strings.Cut(rest, "/")
+		if !nested {
+			continue
+		}
+		f := get(name)
+		f.Videos++
+		f.Bytes += v.Size
+	}
+
+	list := make([]Folder, 0, len(folders))
+	for _, f := range folders {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
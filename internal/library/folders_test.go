@@ -0,0 +1,38 @@
+package library
+
+import "testing"
+
+func TestCleanRelPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+		invalid  bool
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "music", want: "music"},
+		{in: "/music/live/", want: "music/live"},
+		{in: "music/Live 2024", want: "music/Live 2024"},
+		{in: "music//live", invalid: true},
+		{in: "..", invalid: true},
+		{in: "music/../..", invalid: true},
+		{in: "./music", invalid: true},
+		{in: `music\live`, invalid: true},
+		{in: `..\..\secret`, invalid: true},
+		{in: "C:", invalid: true},
+		{in: "c:/Windows", invalid: true},
+		{in: "C:video.mp4", invalid: true},
+		{in: "music\x00", invalid: true},
+	}
+	for _, tt := range tests {
+		got, err := CleanRelPath(tt.in)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("CleanRelPath(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanRelPath(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
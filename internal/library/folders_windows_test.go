@@ -0,0 +1,13 @@
+//go:build windows
+
+package library
+
+import "testing"
+
+func TestCleanRelPathWindows(t *testing.T) {
+	for _, in := range []string{"CON", "music/nul.mp4", "music/aux", "a:b", "trailing.", "music/trailing "} {
+		if got, err := CleanRelPath(in); err == nil {
+			t.Errorf("CleanRelPath(%q) = %q, want an error", in, got)
+		}
+	}
+}
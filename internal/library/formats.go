@@ -0,0 +1,191 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UpgradeBelow is the height under which a video with a source page is
+// suggested for an upgrade
+const UpgradeBelow = 720
+
+// Candidate actions in a format report
+const (
+	ActionTranscode = "transcode"
+	ActionUpgrade   = "upgrade"
+)
+
+// browserContainers and browserCodecs play in current browsers without
+// transcoding
+var (
+	browserContainers = map[string]bool{"mp4": true, "m4v": true, "webm": true}
+	browserCodecs     = map[string]bool{"h264": true, "vp8": true, "vp9": true, "av1": true}
+)
+
+// resolutions are the report's height buckets, tallest first
+var resolutions = []struct {
+	label  string
+	height int
+}{
+	{"2160p", 2160}, {"1440p", 1440}, {"1080p", 1080}, {"720p", 720},
+	{"480p", 480}, {"360p", 360}, {"240p", 240}, {"below 240p", 1},
+}
+
+// FormatReport summarises the containers, codecs and resolutions across
+// the library and points out videos worth transcoding or upgrading
+type FormatReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Videos      int       `json:"videos"`
+	// Unprobed counts videos without stream details, left out of the
+	// codec and resolution breakdowns
+	Unprobed    int         `json:"unprobed"`
+	Containers  []Breakdown `json:"containers"`
+	Codecs      []Breakdown `json:"codecs"`
+	Resolutions []Breakdown `json:"resolutions"`
+	// Summary states the findings in a sentence each, e.g. "37 files are
+	// 360p"
+	Summary    []string          `json:"summary"`
+	Candidates []FormatCandidate `json:"candidates"`
+}
+
+// FormatCandidate is a video that would benefit from an action
+type FormatCandidate struct {
+	VideoID  string `json:"video_id"`
+	FilePath string `json:"file_path"`
+	// Action is ActionTranscode or ActionUpgrade
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+// container is the file's extension without the dot, in lowercase
+func container(v Video) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(v.FilePath)), ".")
+}
+
+// resolution names the height bucket v falls in, "" when unprobed
+func resolution(v Video) string {
+	for _, r := range resolutions {
+		if v.Height >= r.height {
+			return r.label
+		}
+	}
+	return ""
+}
+
+// FormatReport reports on the stream details recorded for every video, as
+// filled in by ffprobe when videos are added or probed
+func (s *VideoService) FormatReport() FormatReport {
+	report := FormatReport{GeneratedAt: time.Now(), Summary: []string{}, Candidates: []FormatCandidate{}}
+	containers := make(map[string]*Breakdown)
+	codecs := make(map[string]*Breakdown)
+	heights := make(map[string]*Breakdown)
+	// transcodeReasons counts videos by issue, which reads after "N file(s)"
+	transcodeReasons := make(map[issue]int)
+
+	for _, v := range s.List() {
+		report.Videos++
+		add(containers, orUnknown(container(v)), v)
+		if v.Codec == "" || v.Height == 0 {
+			report.Unprobed++
+		} else {
+			add(codecs, v.Codec, v)
+			add(heights, resolution(v), v)
+		}
+
+		if reason, ok := transcodeReason(v); ok && !v.IsRemote() {
+			transcodeReasons[reason]++
+			report.Candidates = append(report.Candidates, FormatCandidate{
+				VideoID: v.ID, FilePath: v.FilePath, Action: ActionTranscode, Reason: reason.one, Size: v.Size,
+			})
+		}
+		if v.Height > 0 && v.Height < UpgradeBelow && v.WebpageURL != "" && !v.IsRemote() {
+			report.Candidates = append(report.Candidates, FormatCandidate{
+				VideoID: v.ID, FilePath: v.FilePath, Action: ActionUpgrade, Reason: "only " + resolution(v), Size: v.Size,
+			})
+		}
+	}
+
+	report.Containers = sorted(containers, false)
+	report.Codecs = sorted(codecs, false)
+	for _, r := range resolutions {
+		if b, ok := heights[r.label]; ok {
+			report.Resolutions = append(report.Resolutions, *b)
+			if r.height < UpgradeBelow {
+				report.Summary = append(report.Summary, files(b.Videos)+" "+plural(b.Videos, "is ", "are ")+r.label)
+			}
+		}
+	}
+	if report.Resolutions == nil {
+		report.Resolutions = []Breakdown{}
+	}
+
+	reasons := make([]issue, 0, len(transcodeReasons))
+	for reason := range transcodeReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if transcodeReasons[reasons[i]] != transcodeReasons[reasons[j]] {
+			return transcodeReasons[reasons[i]] > transcodeReasons[reasons[j]]
+		}
+		return reasons[i].one < reasons[j].one
+	})
+	for _, reason := range reasons {
+		n := transcodeReasons[reason]
+		report.Summary = append(report.Summary, files(n)+" "+plural(n, reason.one, reason.many))
+	}
+	if report.Unprobed > 0 {
+		report.Summary = append(report.Summary, files(report.Unprobed)+" "+plural(report.Unprobed, "has", "have")+" no stream details")
+	}
+
+	sort.SliceStable(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].Size > report.Candidates[j].Size
+	})
+	return report
+}
+
+// issue describes a problem shared by some files, for one file and many
+type issue struct {
+	one, many string
+}
+
+// NeedsTranscode reports whether v's codec or container won't play in a
+// browser. Videos that haven't been probed don't.
+func NeedsTranscode(v Video) bool {
+	_, ok := transcodeReason(v)
+	return ok
+}
+
+// transcodeReason explains why v won't play in a browser. It reports false
+// if v will, or its codec is unknown.
+func transcodeReason(v Video) (issue, bool) {
+	switch {
+	case v.Codec == "":
+		return issue{}, false
+	case !browserCodecs[v.Codec]:
+		return issue{
+			one:  "uses " + v.Codec + ", which browsers can't play",
+			many: "use " + v.Codec + ", which browsers can't play",
+		}, true
+	case !browserContainers[container(v)]:
+		return issue{
+			one:  "is ." + container(v) + ", which browsers can't play",
+			many: "are ." + container(v) + ", which browsers can't play",
+		}, true
+	}
+	return issue{}, false
+}
+
+func files(n int) string {
+	return fmt.Sprintf("%d %s", n, plural(n, "file", "files"))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
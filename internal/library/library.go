@@ -0,0 +1,852 @@
+// Package library indexes downloaded videos and persists their metadata.
+package library
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/jsonnum"
+	"noahjalex.ute/internal/language"
+)
+
+// VideoExtensions are the file types indexed as videos
+var VideoExtensions = map[string]bool{
+	".mp4":  true,
+	".mkv":  true,
+	".webm": true,
+	".mov":  true,
+	".flv":  true,
+	".avi":  true,
+}
+
+// IsVideoFile reports whether name has a video extension
+func IsVideoFile(name string) bool {
+	return VideoExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// VideoMetadata is the subset of yt-dlp's .info.json we keep
+type VideoMetadata struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Uploader    string        `json:"uploader"`
+	UploadDate  string        `json:"upload_date"`
+	Description string        `json:"description"`
+	ViewCount   jsonnum.Int   `json:"view_count"`
+	WebpageURL  string        `json:"webpage_url"`
+	ChannelURL  string        `json:"channel_url"`
+	UploaderURL string        `json:"uploader_url"`
+	Extractor   string        `json:"extractor_key"`
+	Duration    jsonnum.Float `json:"duration"`
+	Tags        []string      `json:"tags"`
+	Chapters    []Chapter     `json:"chapters"`
+	LikeCount   jsonnum.Int   `json:"like_count"`
+	Categories  []string      `json:"categories"`
+	ChannelID   string        `json:"channel_id"`
+	// Width, Height, FPS, VCodec and ACodec describe the format yt-dlp
+	// chose, in its terms (e.g. "avc1.64001F", "none" for no video)
+	Width  jsonnum.Int   `json:"width"`
+	Height jsonnum.Int   `json:"height"`
+	FPS    jsonnum.Float `json:"fps"`
+	VCodec string        `json:"vcodec"`
+	ACodec string        `json:"acodec"`
+	// Timestamp and ReleaseTimestamp are Unix times some sites give
+	// beside UploadDate, which is only a day
+	Timestamp        jsonnum.Int64 `json:"timestamp"`
+	ReleaseTimestamp jsonnum.Int64 `json:"release_timestamp"`
+	// Epoch is the Unix time yt-dlp extracted the video
+	Epoch jsonnum.Int64 `json:"epoch"`
+	// Language is the site's language tag for the video, when it has one
+	Language string `json:"language"`
+	// Comments are written when yt-dlp runs with --write-comments
+	Comments []Comment `json:"comments"`
+}
+
+// channelURL prefers the channel page over the uploader's profile, which
+// some sites report instead
+func (m *VideoMetadata) channelURL() string {
+	if m.ChannelURL != "" {
+		return m.ChannelURL
+	}
+	return m.UploaderURL
+}
+
+// uploadedAt is when the video was published: the site's exact time when
+// it has one, otherwise midnight UTC on the upload date. It is nil when
+// neither is known.
+func (m *VideoMetadata) uploadedAt() *time.Time {
+	for _, ts := range []int64{int64(m.Timestamp), int64(m.ReleaseTimestamp)} {
+		if ts > 0 {
+			t := time.Unix(ts, 0).UTC()
+			return &t
+		}
+	}
+	return parseUploadDate(m.UploadDate)
+}
+
+// parseUploadDate reads yt-dlp's YYYYMMDD upload date as midnight UTC
+func parseUploadDate(date string) *time.Time {
+	t, err := time.Parse("20060102", date)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// Chapter is a titled section of a video, in seconds from the start
+type Chapter struct {
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	Title     string  `json:"title"`
+}
+
+// Video is a library entry
+type Video struct {
+	ID string `json:"id"`
+	// FilePath is relative to the video's pool, slash-separated
+	FilePath string `json:"file_path"`
+	// Pool names the storage pool holding the file, empty for the videos
+	// directory
+	Pool       string `json:"pool,omitempty"`
+	Title      string `json:"title"`
+	Uploader   string `json:"uploader"`
+	UploadDate string `json:"upload_date"`
+	// UploadedAt is UploadDate as a time, or the site's exact publishing
+	// time when it gives one; nil when unknown
+	UploadedAt *time.Time `json:"uploaded_at,omitempty"`
+	// Description, Chapters, Transcript, Summary and Comments are the
+	// video's Details: empty in the index, and only filled in by GetFull
+	Description string `json:"description,omitempty"`
+	ViewCount   int    `json:"view_count"`
+	WebpageURL  string `json:"webpage_url"`
+	// ChannelURL is the uploader's channel page, when the site has one
+	ChannelURL string `json:"channel_url,omitempty"`
+	// ChannelID is the site's ID for the uploader's channel
+	ChannelID string `json:"channel_id,omitempty"`
+	LikeCount int    `json:"like_count,omitempty"`
+	// Categories are the site's, such as YouTube's "Music" or "Gaming"
+	Categories []string `json:"categories,omitempty"`
+	// Duration is the running time in seconds, 0 when unknown
+	Duration float64   `json:"duration,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Chapters []Chapter `json:"chapters,omitempty"`
+	// Width, Height and Codec describe the video stream, when probed.
+	// Until then the width and height are the ones yt-dlp reported.
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Codec  string `json:"codec,omitempty"`
+	// FPS, VCodec and ACodec are the frame rate and codecs yt-dlp
+	// reported for the format it downloaded
+	FPS     float64   `json:"fps,omitempty"`
+	VCodec  string    `json:"vcodec,omitempty"`
+	ACodec  string    `json:"acodec,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	AddedAt time.Time `json:"added_at"`
+	// DownloadedAt is when the video was fetched from the site. AddedAt
+	// is when it entered the index, which for files found by a rescan
+	// can be much later.
+	DownloadedAt time.Time `json:"downloaded_at"`
+	// UpdatedAt is when the entry last changed in the index
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	// Extractor and ExtractorID identify the source video across downloads
+	Extractor   string `json:"extractor,omitempty"`
+	ExtractorID string `json:"extractor_id,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	// Storage names the remote backend holding the file, empty when local
+	Storage   string `json:"storage,omitempty"`
+	RemoteKey string `json:"remote_key,omitempty"`
+	// RemoteFiles are the keys of the video and its sidecars on the backend
+	RemoteFiles []string `json:"remote_files,omitempty"`
+	// State is empty for healthy videos or StateNeedsRepair
+	State      string     `json:"state,omitempty"`
+	Problem    string     `json:"problem,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	// WatchedAt is when the video was marked as watched
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+	// AddedBy is the user whose download added the video
+	AddedBy string `json:"added_by,omitempty"`
+	// Subscription is the subscription whose download added the video
+	Subscription string `json:"subscription,omitempty"`
+	// Transcript is the speech in the video as plain text, one subtitle cue
+	// per line, when it has been transcribed
+	Transcript string `json:"transcript,omitempty"`
+	// Comments are the top-level comments saved with the video
+	Comments []Comment `json:"comments,omitempty"`
+	// Summary and SuggestedTags come from the enrichment model, when one
+	// is configured
+	Summary       string   `json:"summary,omitempty"`
+	SuggestedTags []string `json:"suggested_tags,omitempty"`
+	// Language is the spoken language's ISO 639-1 code, from the site's
+	// metadata or detected in the transcript
+	Language string `json:"language,omitempty"`
+	// FormatHistory lists the files the video had before being transcoded
+	// or upgraded, oldest first
+	FormatHistory []FormatChange `json:"format_history,omitempty"`
+}
+
+// Reasons a video's file was replaced
+const (
+	ReplacedByTranscode = "transcode"
+	ReplacedByUpgrade   = "upgrade"
+)
+
+// FormatChange records a file a video used to have
+type FormatChange struct {
+	ReplacedAt time.Time `json:"replaced_at"`
+	// Reason is ReplacedByTranscode or ReplacedByUpgrade
+	Reason   string `json:"reason"`
+	FilePath string `json:"file_path"`
+	Size     int64  `json:"size"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Codec    string `json:"codec,omitempty"`
+}
+
+// clone returns a copy of v that shares no memory with it, so callers can
+// hold on to it while the index keeps changing
+func (v *Video) clone() Video {
+	c := *v
+	c.RemoteFiles = append([]string(nil), v.RemoteFiles...)
+	c.Tags = append([]string(nil), v.Tags...)
+	c.Categories = append([]string(nil), v.Categories...)
+	c.SuggestedTags = append([]string(nil), v.SuggestedTags...)
+	c.FormatHistory = append([]FormatChange(nil), v.FormatHistory...)
+	c.Chapters = append([]Chapter(nil), v.Chapters...)
+	c.Comments = append([]Comment(nil), v.Comments...)
+	if v.UploadedAt != nil {
+		t := *v.UploadedAt
+		c.UploadedAt = &t
+	}
+	if v.VerifiedAt != nil {
+		t := *v.VerifiedAt
+		c.VerifiedAt = &t
+	}
+	if v.WatchedAt != nil {
+		t := *v.WatchedAt
+		c.WatchedAt = &t
+	}
+	return c
+}
+
+// Filename returns the base name of the video file
+func (v Video) Filename() string {
+	return filepath.Base(filepath.FromSlash(v.FilePath))
+}
+
+// IsRemote reports whether the file lives on a remote storage backend
+func (v Video) IsRemote() bool {
+	return v.Storage != ""
+}
+
+// LoadVideoMetadata reads the .info.json sidecar next to videoPath
+func LoadVideoMetadata(videoPath string) (*VideoMetadata, error) {
+	data, err := os.ReadFile(SidecarPath(videoPath))
+	if err != nil {
+		return nil, err
+	}
+
+	info, _, err := DecodeVideoMetadata(data, false)
+	return info, err
+}
+
+// loadMetadata reads a video's .info.json the way the service is set to,
+// logging what lenient decoding had to leave out
+func (s *VideoService) loadMetadata(videoPath string) (*VideoMetadata, error) {
+	if !s.lenient {
+		return LoadVideoMetadata(videoPath)
+	}
+	data, err := os.ReadFile(SidecarPath(videoPath))
+	if err != nil {
+		return nil, err
+	}
+	info, problems, err := DecodeVideoMetadata(data, true)
+	if len(problems) > 0 {
+		log.Printf("Metadata of %s: %s", filepath.Base(videoPath), strings.Join(problems, "; "))
+	}
+	return info, err
+}
+
+// SidecarPath returns the .info.json path for a video file
+func SidecarPath(videoPath string) string {
+	return strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ".info.json"
+}
+
+// sidecarSuffixes follow a video's name stem in the files yt-dlp and the
+// server write beside it
+var sidecarSuffixes = map[string]bool{
+	".info.json":       true,
+	".description":     true,
+	".nfo":             true,
+	".annotations.xml": true,
+	".live_chat.json":  true,
+	".transcript.vtt":  true,
+	".jpg":             true,
+	".jpeg":            true,
+	".webp":            true,
+	".png":             true,
+}
+
+// subtitlePattern matches the rest of a subtitle's name after the video's
+// stem: a language code such as "en", "pt-BR" or "zh-Hans" and the format
+var subtitlePattern = regexp.MustCompile(`^\.[A-Za-z]{2,3}(-[A-Za-z0-9]+)*\.(vtt|srt|ass|ttml)$`)
+
+// IsSidecar reports whether the file name is a sidecar of the video whose
+// file name without extension is stem. Only exact suffixes count, so
+// "Vol. 1.mp4" and "Vol.2.info.json" are not sidecars of "Vol.mp4".
+func IsSidecar(name, stem string) bool {
+	rest, ok := strings.CutPrefix(name, stem)
+	if !ok || IsVideoFile(name) {
+		return false
+	}
+	return sidecarSuffixes[strings.ToLower(rest)] || subtitlePattern.MatchString(rest)
+}
+
+// RelatedFiles returns the video file and its sidecars (.info.json,
+// thumbnails, subtitles). Other videos in the directory are never
+// included, even when their names start with this one's, and neither are
+// their sidecars.
+func RelatedFiles(videoPath string) ([]string, error) {
+	dir := filepath.Dir(videoPath)
+	base := filepath.Base(videoPath)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Videos whose names extend this one's, whose sidecars could otherwise
+	// pass for its own
+	var longer []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !IsVideoFile(name) {
+			continue
+		}
+		if other := strings.TrimSuffix(name, filepath.Ext(name)); len(other) > len(stem) && strings.HasPrefix(other, stem) {
+			longer = append(longer, other)
+		}
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if name == base {
+			files = append(files, videoPath)
+			continue
+		}
+		if !IsSidecar(name, stem) || slices.ContainsFunc(longer, func(other string) bool { return IsSidecar(name, other) }) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// VideoService owns the in-memory library index and its metadata.json. It
+// is safe for concurrent use: the index is guarded by mu, and every Video
+// handed out is a copy. Each video's details live in detailsDir.
+type VideoService struct {
+	dir          string
+	metadataPath string
+	detailsDir   string
+	// pools maps extra storage pool names to their directories
+	pools map[string]string
+	// lenient decodes .info.json sidecars field by field
+	lenient bool
+	// albums are image galleries, kept in their own albums.json
+	albums albumIndex
+
+	mu      sync.RWMutex
+	videos  map[string]*Video
+	changes changeLog
+	// saveMu orders writes of metadata.json so an older snapshot can't
+	// overwrite a newer one
+	saveMu sync.Mutex
+	// nextBackup is when a save next backs up metadata.json, guarded by
+	// saveMu; zero until the first save looks at the backups on disk
+	nextBackup time.Time
+}
+
+// NewVideoService creates a service for videosDir, persisting the index
+// in dataDir
+func NewVideoService(videosDir, dataDir string) *VideoService {
+	now := time.Now().Round(0)
+	return &VideoService{
+		dir:          videosDir,
+		metadataPath: filepath.Join(dataDir, "metadata.json"),
+		detailsDir:   filepath.Join(dataDir, "details"),
+		videos:       make(map[string]*Video),
+		changes:      changeLog{last: now, floor: now},
+		pools:        make(map[string]string),
+		albums: albumIndex{
+			path:   filepath.Join(dataDir, "albums.json"),
+			albums: make(map[string]*Album),
+		},
+	}
+}
+
+// AddPool registers an extra directory videos can be stored in. Pools must
+// be added before the library is used.
+func (s *VideoService) AddPool(name, dir string) {
+	s.pools[name] = dir
+}
+
+// SetLenientMetadata decodes .info.json sidecars field by field, logging
+// fields of an unexpected type instead of dropping the whole sidecar. It
+// must be set before the library is used.
+func (s *VideoService) SetLenientMetadata(on bool) {
+	s.lenient = on
+}
+
+// Pools returns the names of the extra storage pools, sorted
+func (s *VideoService) Pools() []string {
+	names := make([]string, 0, len(s.pools))
+	for name := range s.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PoolDir returns the directory of the named pool, the videos directory
+// for "". Unknown pools return false.
+func (s *VideoService) PoolDir(pool string) (string, bool) {
+	if pool == "" {
+		return s.dir, true
+	}
+	dir, ok := s.pools[pool]
+	return dir, ok
+}
+
+// Dir returns the videos directory
+func (s *VideoService) Dir() string {
+	return s.dir
+}
+
+// Root returns the directory v's path is relative to. Videos in a pool
+// that is no longer configured resolve against the videos directory.
+func (s *VideoService) Root(v Video) string {
+	if dir, ok := s.PoolDir(v.Pool); ok {
+		return dir
+	}
+	return s.dir
+}
+
+// AbsPath returns the on-disk path of a video
+func (s *VideoService) AbsPath(v Video) string {
+	return filepath.Join(s.Root(v), filepath.FromSlash(v.FilePath))
+}
+
+// LoadMetadata reads the persisted index, if any, recovering from a
+// rolling backup when it is corrupt. Indexes from before details were kept
+// apart have theirs moved out.
+func (s *VideoService) LoadMetadata() error {
+	videos, err := readIndex(s.metadataPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	moved, dated := 0, 0
+	s.mu.Lock()
+	for _, v := range videos {
+		// Entries from before dates were parsed get theirs filled in
+		if v.UploadedAt == nil && v.UploadDate != "" {
+			v.UploadedAt = parseUploadDate(v.UploadDate)
+			dated++
+		}
+		if v.DownloadedAt.IsZero() {
+			v.DownloadedAt = v.AddedAt
+			dated++
+		}
+		if d := v.takeDetails(); !d.empty() {
+			if err := s.writeDetails(v.ID, d); err != nil {
+				s.mu.Unlock()
+				return fmt.Errorf("moving the details of %s out of the index: %w", v.ID, err)
+			}
+			moved++
+		}
+		s.videos[v.ID] = v
+		if v.UpdatedAt.After(s.changes.last) {
+			s.changes.last = v.UpdatedAt
+		}
+	}
+	s.mu.Unlock()
+
+	if moved == 0 && dated == 0 {
+		return nil
+	}
+	if moved > 0 {
+		log.Printf("Moved the details of %d videos out of %s", moved, s.metadataPath)
+	}
+	return s.SaveMetadata()
+}
+
+// SaveMetadata writes the index to metadata.json, replacing it atomically.
+// The version it replaces is copied to a rolling backup first when the
+// newest backup is backupInterval old, so the metadataBackups kept reach
+// back at least that many intervals. Only the first save of a run, and
+// then the first once the interval is up, look at the backups at all.
+func (s *VideoService) SaveMetadata() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	videos := make([]*Video, 0, len(s.videos))
+	for _, v := range s.videos {
+		videos = append(videos, v)
+	}
+	sort.Slice(videos, func(i, j int) bool { return videos[i].ID < videos[j].ID })
+	data, err := json.MarshalIndent(videos, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.metadataPath), 0755); err != nil {
+		return err
+	}
+	if !time.Now().Before(s.nextBackup) {
+		next, err := rotateBackups(s.metadataPath)
+		if err != nil {
+			// A missed backup shouldn't stop the index being saved
+			log.Printf("Failed to back up %s: %v", s.metadataPath, err)
+			next = time.Now().Add(backupInterval)
+		}
+		s.nextBackup = next
+	}
+	return writeFileAtomic(s.metadataPath, data, 0644)
+}
+
+// AddFile indexes the video at path, which must be inside the videos
+// directory or a pool, and returns the new or refreshed entry
+func (s *VideoService) AddFile(path string) (Video, error) {
+	pool, rel, err := s.locate(path)
+	if err != nil {
+		return Video{}, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Video{}, err
+	}
+
+	metadata, err := s.loadMetadata(path)
+	if err != nil {
+		// Fallback if .info.json is missing
+		metadata = &VideoMetadata{Title: fi.Name()}
+	}
+
+	hash, err := HashFile(path)
+	if err != nil {
+		return Video{}, fmt.Errorf("hashing %s: %w", path, err)
+	}
+
+	v := &Video{
+		FilePath:    rel,
+		Pool:        pool,
+		Title:       metadata.Title,
+		Uploader:    metadata.Uploader,
+		UploadDate:  metadata.UploadDate,
+		UploadedAt:  metadata.uploadedAt(),
+		Description: metadata.Description,
+		ViewCount:   int(metadata.ViewCount),
+		WebpageURL:  metadata.WebpageURL,
+		ChannelURL:  metadata.channelURL(),
+		ChannelID:   metadata.ChannelID,
+		LikeCount:   int(metadata.LikeCount),
+		Categories:  metadata.Categories,
+		Duration:    float64(metadata.Duration),
+		Tags:        metadata.Tags,
+		Chapters:    metadata.Chapters,
+		Comments:    topLevelComments(metadata.Comments),
+		Width:       int(metadata.Width),
+		Height:      int(metadata.Height),
+		FPS:         float64(metadata.FPS),
+		VCodec:      metadata.VCodec,
+		ACodec:      metadata.ACodec,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		AddedAt:     time.Now(),
+		// Downloads keep the time they finished as their modification
+		// time (--no-mtime)
+		DownloadedAt: fi.ModTime(),
+		Extractor:    metadata.Extractor,
+		ExtractorID:  metadata.ID,
+		SHA256:       hash,
+		Language:     language.Normalize(metadata.Language),
+	}
+	if metadata.Epoch > 0 {
+		v.DownloadedAt = time.Unix(int64(metadata.Epoch), 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-adding a known file refreshes it in place
+	for _, existing := range s.videos {
+		if existing.FilePath == rel && existing.Pool == pool {
+			v.ID = existing.ID
+			v.AddedAt = existing.AddedAt
+			if metadata.Epoch == 0 && !existing.DownloadedAt.IsZero() {
+				v.DownloadedAt = existing.DownloadedAt
+			}
+			// Probed stream details beat what yt-dlp reported
+			if existing.Codec != "" || v.Height == 0 {
+				v.Width, v.Height, v.Codec = existing.Width, existing.Height, existing.Codec
+			}
+			v.Storage, v.RemoteKey, v.RemoteFiles = existing.Storage, existing.RemoteKey, existing.RemoteFiles
+			v.WatchedAt = existing.WatchedAt
+			v.AddedBy = existing.AddedBy
+			v.Subscription = existing.Subscription
+			// The transcript survives a re-encode, and what was learned from it
+			old, err := s.Details(existing.ID)
+			if err != nil {
+				return Video{}, err
+			}
+			v.Transcript, v.Summary, v.SuggestedTags = old.Transcript, old.Summary, existing.SuggestedTags
+			if len(v.Comments) == 0 {
+				v.Comments = old.Comments
+			}
+			v.FormatHistory = existing.FormatHistory
+			if v.Language == "" {
+				v.Language = existing.Language
+			}
+			return s.put(v)
+		}
+	}
+
+	v.ID = metadata.ID
+	if _, taken := s.videos[v.ID]; v.ID == "" || taken || strings.ContainsAny(v.ID, "/\\") {
+		key := rel
+		if pool != "" {
+			key = pool + ":" + rel
+		}
+		v.ID = pathID(key)
+	}
+	return s.put(v)
+}
+
+// put stores v in the index and its details beside it, returning it with
+// its details. The caller holds s.mu.
+func (s *VideoService) put(v *Video) (Video, error) {
+	full := v.clone()
+	if err := s.writeDetails(v.ID, v.takeDetails()); err != nil {
+		return Video{}, err
+	}
+	s.stamp(v)
+	full.UpdatedAt = v.UpdatedAt
+	s.videos[v.ID] = v
+	return full, nil
+}
+
+// List returns all videos, most recently modified first
+func (s *VideoService) List() []Video {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Video, 0, len(s.videos))
+	for _, v := range s.videos {
+		list = append(list, v.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ModTime.After(list[j].ModTime)
+	})
+	return list
+}
+
+// Get returns the video with id
+func (s *VideoService) Get(id string) (Video, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.videos[id]
+	if !ok {
+		return Video{}, false
+	}
+	return v.clone(), true
+}
+
+// FindByPath returns the video stored at the relative path rel, in the
+// videos directory if one is there and otherwise in any pool
+func (s *VideoService) FindByPath(rel string) (Video, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var match *Video
+	for _, v := range s.videos {
+		if v.FilePath == rel && (match == nil || v.Pool == "") {
+			match = v
+		}
+	}
+	if match == nil {
+		return Video{}, false
+	}
+	return match.clone(), true
+}
+
+// findFile returns the video stored at rel within pool
+func (s *VideoService) findFile(pool, rel string) (Video, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.videos {
+		if v.FilePath == rel && v.Pool == pool {
+			return v.clone(), true
+		}
+	}
+	return Video{}, false
+}
+
+// Remove drops the video with id from the index and persists it. Files are
+// left for the caller to delete.
+func (s *VideoService) Remove(id string) (Video, error) {
+	s.mu.Lock()
+	v, ok := s.videos[id]
+	if ok {
+		delete(s.videos, id)
+		s.forget(id)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return Video{}, fmt.Errorf("video %s not found", id)
+	}
+	if err := s.writeDetails(id, Details{}); err != nil {
+		log.Printf("Failed to remove the details of %s: %v", id, err)
+	}
+	return v.clone(), s.SaveMetadata()
+}
+
+// Update applies fn to the video with id, details included, and persists
+// the index and any change to the details
+func (s *VideoService) Update(id string, fn func(*Video)) error {
+	s.mu.Lock()
+	v, ok := s.videos[id]
+	var err error
+	if ok {
+		var d Details
+		if d, err = s.Details(id); err == nil {
+			v.setDetails(d)
+			fn(v)
+			s.stamp(v)
+			if changed := v.takeDetails(); !changed.equal(d) {
+				err = s.writeDetails(id, changed)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("video %s not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	return s.SaveMetadata()
+}
+
+// ReplaceFile points the video with id at the file at path, such as a
+// transcoded copy, refreshing its size, modification time and hash. The
+// old file's details are added to the format history under reason, and
+// stream details are cleared for the caller to probe again. The old file
+// is left for the caller to remove.
+func (s *VideoService) ReplaceFile(id, path, reason string) (Video, error) {
+	pool, rel, err := s.locate(path)
+	if err != nil {
+		return Video{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Video{}, err
+	}
+	hash, err := HashFile(path)
+	if err != nil {
+		return Video{}, fmt.Errorf("hashing %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	v, ok := s.videos[id]
+	if ok {
+		v.FormatHistory = append(v.FormatHistory, FormatChange{
+			ReplacedAt: time.Now(),
+			Reason:     reason,
+			FilePath:   v.FilePath,
+			Size:       v.Size,
+			Width:      v.Width,
+			Height:     v.Height,
+			Codec:      v.Codec,
+		})
+		v.FilePath, v.Pool = rel, pool
+		v.Size, v.ModTime, v.SHA256 = fi.Size(), fi.ModTime(), hash
+		v.Width, v.Height, v.Codec = 0, 0, ""
+		v.State, v.Problem = "", ""
+		s.stamp(v)
+	}
+	var updated Video
+	if ok {
+		updated = v.clone()
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return Video{}, fmt.Errorf("video %s not found", id)
+	}
+	return updated, s.SaveMetadata()
+}
+
+// locate finds the pool holding path and the slash-separated path within
+// it, rejecting paths outside every pool. Pools are checked before the
+// videos directory so one nested inside it still claims its files.
+func (s *VideoService) locate(path string) (pool, rel string, err error) {
+	for _, name := range s.Pools() {
+		if rel, err := relPath(s.pools[name], path); err == nil {
+			return name, rel, nil
+		}
+	}
+	if rel, err := relPath(s.dir, path); err == nil {
+		return "", rel, nil
+	}
+	return "", "", fmt.Errorf("%s is outside the videos directory", path)
+}
+
+// relPath converts path to a slash-separated path relative to dir,
+// rejecting paths outside it
+func relPath(dir, path string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside %s", path, dir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// pathID derives a stable ID for files without extractor metadata
+func pathID(rel string) string {
+	sum := sha1.Sum([]byte(rel))
+	return hex.EncodeToString(sum[:6])
+}
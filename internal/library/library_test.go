@@ -0,0 +1,247 @@
+package library
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestService is a library in a temporary directory
+func newTestService(t testing.TB) *VideoService {
+	t.Helper()
+	dir := t.TempDir()
+	videosDir := filepath.Join(dir, "videos")
+	if err := os.MkdirAll(videosDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewVideoService(videosDir, filepath.Join(dir, "data"))
+}
+
+// writeVideo creates a video file and its .info.json in the library
+func writeVideo(t testing.TB, s *VideoService, name, title string) string {
+	t.Helper()
+	path := filepath.Join(s.Dir(), name+".mp4")
+	if err := os.WriteFile(path, []byte("video "+name), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := fmt.Sprintf(`{"id": %q, "title": %q, "uploader": "Tester", "webpage_url": "https://example.com/%s", "extractor_key": "Generic"}`, name, title, name)
+	if err := os.WriteFile(SidecarPath(path), []byte(info), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestConcurrentAccess runs downloads, deletes, listings and updates at
+// once; run it with -race
+func TestConcurrentAccess(t *testing.T) {
+	s := newTestService(t)
+	const writers, perWriter = 4, 25
+
+	// Videos present from the start, to be deleted and updated
+	var existing []string
+	for i := 0; i < writers*perWriter; i++ {
+		v, err := s.AddFile(writeVideo(t, s, fmt.Sprintf("old-%d", i), "Old"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing = append(existing, v.ID)
+	}
+
+	paths := make([][]string, writers)
+	for w := range paths {
+		for i := 0; i < perWriter; i++ {
+			paths[w] = append(paths[w], writeVideo(t, s, fmt.Sprintf("new-%d-%d", w, i), "New"))
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	stop := make(chan struct{})
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Downloads
+	var mutators sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		mutators.Add(1)
+		run(func() {
+			defer mutators.Done()
+			for _, path := range paths[w] {
+				if _, err := s.AddFile(path); err != nil {
+					errs <- fmt.Errorf("AddFile: %w", err)
+				}
+			}
+		})
+	}
+	// Deletes and updates of the videos there from the start
+	for w := 0; w < writers; w++ {
+		mutators.Add(1)
+		run(func() {
+			defer mutators.Done()
+			for i := w; i < len(existing); i += writers {
+				id := existing[i]
+				if i%2 == 0 {
+					if _, err := s.Remove(id); err != nil {
+						errs <- fmt.Errorf("Remove: %w", err)
+					}
+				} else if err := s.Update(id, func(v *Video) { v.Title = "Updated" }); err != nil {
+					errs <- fmt.Errorf("Update: %w", err)
+				}
+			}
+		})
+	}
+	// Listings and saves until the rest are done
+	for r := 0; r < 4; r++ {
+		run(func() {
+			for !stopped() {
+				for _, v := range s.List() {
+					// Copies are the caller's to change
+					v.Title = "Changed by the caller"
+					v.Tags = append(v.Tags, "mine")
+				}
+				s.Find(Query{Text: "new"})
+				if _, ok := s.Get(existing[0]); ok && r == 0 {
+					s.FindByPath("old-1.mp4")
+				}
+				if err := s.SaveMetadata(); err != nil {
+					errs <- fmt.Errorf("SaveMetadata: %w", err)
+				}
+			}
+		})
+	}
+
+	mutators.Wait()
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	want := writers*perWriter + len(existing)/2
+	if got := len(s.List()); got != want {
+		t.Fatalf("library has %d videos, expected %d", got, want)
+	}
+	for _, v := range s.List() {
+		switch {
+		case v.Title == "Changed by the caller":
+			t.Fatalf("video %s has a title set on a copy", v.ID)
+		case v.Title == "Old":
+			t.Fatalf("video %s wasn't updated", v.ID)
+		}
+	}
+
+	// The saved index has what's in memory
+	if err := s.SaveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewVideoService(s.dir, filepath.Dir(s.metadataPath))
+	if err := reloaded.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reloaded.List()); got != want {
+		t.Fatalf("saved index has %d videos, expected %d", got, want)
+	}
+}
+
+// touch creates empty files named names in dir
+func touch(t testing.TB, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRelatedFiles keeps videos whose titles share a dotted prefix, and
+// their sidecars, out of each other's files
+func TestRelatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, dir,
+		"Vol.mp4", "Vol.info.json", "Vol.jpg", "Vol.webp", "Vol.en.vtt", "Vol.pt-BR.srt", "Vol.transcript.vtt", "Vol.description",
+		"Vol. 1.mp4", "Vol. 1.info.json", "Vol. 1.jpg",
+		"Vol.2.webm", "Vol.2.info.json", "Vol.2.en.vtt",
+		"Vol.en.mkv", "Vol.en.jpg", "Vol.en.en.vtt",
+		"Vol.mp4.part", "Vol.notes.txt",
+	)
+
+	tests := []struct {
+		video string
+		want  []string
+	}{
+		{"Vol.mp4", []string{"Vol.description", "Vol.en.vtt", "Vol.info.json", "Vol.jpg", "Vol.mp4", "Vol.pt-BR.srt", "Vol.transcript.vtt", "Vol.webp"}},
+		{"Vol. 1.mp4", []string{"Vol. 1.info.json", "Vol. 1.jpg", "Vol. 1.mp4"}},
+		{"Vol.2.webm", []string{"Vol.2.en.vtt", "Vol.2.info.json", "Vol.2.webm"}},
+		{"Vol.en.mkv", []string{"Vol.en.en.vtt", "Vol.en.jpg", "Vol.en.mkv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.video, func(t *testing.T) {
+			files, err := RelatedFiles(filepath.Join(dir, tt.video))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, filepath.Base(f))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("RelatedFiles(%s) = %q, want %q", tt.video, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBackupInterval checks that saves back up the index at most once per
+// backupInterval, keeping metadataBackups copies
+func TestBackupInterval(t *testing.T) {
+	s := newTestService(t)
+	exists := func(n int) bool {
+		_, err := os.Stat(backupPath(s.metadataPath, n))
+		return err == nil
+	}
+	age := func() {
+		// As if the newest backup was taken an interval ago
+		old := time.Now().Add(-backupInterval)
+		if err := os.Chtimes(backupPath(s.metadataPath, 1), old, old); err != nil {
+			t.Fatal(err)
+		}
+		s.nextBackup = old
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.SaveMetadata(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !exists(1) || exists(2) {
+		t.Fatalf("after saves within an interval: backup 1 %v, backup 2 %v; want only backup 1", exists(1), exists(2))
+	}
+
+	for n := 2; n <= metadataBackups+1; n++ {
+		age()
+		if err := s.SaveMetadata(); err != nil {
+			t.Fatal(err)
+		}
+		if want := min(n, metadataBackups); !exists(want) || exists(want+1) {
+			t.Errorf("after %d intervals: want backups 1 to %d", n-1, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// expectedMetadata are the fields every yt-dlp .info.json should have;
+// lenient decoding reports them when they're missing
+var expectedMetadata = []string{"id", "title", "webpage_url", "extractor_key"}
+
+// unknownFields are the .info.json fields outside VideoMetadata that
+// lenient decoding has logged, so each is logged once per run
+var unknownFields = struct {
+	sync.Mutex
+	logged map[string]bool
+}{logged: make(map[string]bool)}
+
+// DecodeVideoMetadata reads a yt-dlp .info.json. Strictly, any field of
+// the wrong type fails the whole sidecar. Leniently, each field is decoded
+// on its own: the ones that fail are left empty and, with expected fields
+// that are missing, described in problems, so schema drift shows in the
+// logs rather than as silently blank metadata. Either way, data that isn't
+// a JSON object is an error. Lenient decoding also logs the fields
+// VideoMetadata doesn't hold, the first time each is seen.
+func DecodeVideoMetadata(data []byte, lenient bool) (info *VideoMetadata, problems []string, err error) {
+	info = &VideoMetadata{}
+	if !lenient {
+		if err := json.Unmarshal(data, info); err != nil {
+			return nil, nil, err
+		}
+		return info, nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range expectedMetadata {
+		if _, ok := fields[name]; !ok {
+			problems = append(problems, "missing "+name)
+		}
+	}
+	v := reflect.ValueOf(info).Elem()
+	known := make(map[string]bool, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		known[name] = true
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, v.Field(i).Addr().Interface()); err != nil {
+			v.Field(i).SetZero()
+			problems = append(problems, fmt.Sprintf("%s: can't read %s as %s", name, abbreviate(raw), v.Field(i).Type()))
+		}
+	}
+	logUnknownFields(fields, known)
+	return info, problems, nil
+}
+
+// logUnknownFields logs the fields not in known that haven't been logged
+// before, so a field a new yt-dlp release adds or renames shows up once
+func logUnknownFields(fields map[string]json.RawMessage, known map[string]bool) {
+	unknownFields.Lock()
+	var fresh []string
+	for name := range fields {
+		if !known[name] && !unknownFields.logged[name] {
+			unknownFields.logged[name] = true
+			fresh = append(fresh, name)
+		}
+	}
+	unknownFields.Unlock()
+
+	if len(fresh) > 0 {
+		slices.Sort(fresh)
+		log.Printf("Metadata fields the library doesn't read: %s", strings.Join(fresh, ", "))
+	}
+}
+
+// abbreviate shortens a JSON value for a log line
+func abbreviate(raw json.RawMessage) string {
+	const limit = 40
+	s := string(raw)
+	if len(s) > limit {
+		return s[:limit] + "..."
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package library
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"noahjalex.ute/internal/pathutil"
+)
+
+// templateField matches placeholders such as {uploader} in move templates
+var templateField = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// ExpandTemplate fills a move template such as "{uploader}/{title} [{id}]"
+// from v. The result is a path relative to the videos directory without the
+// file extension, which is kept from the current file.
+func ExpandTemplate(tmpl string, v Video) (string, error) {
+	fields := map[string]string{
+		"id":          v.ExtractorID,
+		"title":       v.Title,
+		"uploader":    v.Uploader,
+		"upload_date": v.UploadDate,
+		"year":        "",
+		"extractor":   v.Extractor,
+		"name":        strings.TrimSuffix(v.Filename(), path.Ext(v.Filename())),
+	}
+	if fields["id"] == "" {
+		fields["id"] = v.ID
+	}
+	if len(v.UploadDate) >= 4 {
+		fields["year"] = v.UploadDate[:4]
+	}
+
+	var unknown string
+	out := templateField.ReplaceAllStringFunc(tmpl, func(m string) string {
+		key := m[1 : len(m)-1]
+		value, ok := fields[key]
+		if !ok {
+			unknown = key
+			return m
+		}
+		// Fields can't introduce folders or unportable names
+		value = strings.Trim(value, " .")
+		if value == "" {
+			value = "unknown"
+		}
+		value = pathutil.SanitizeFilename(value)
+		return value
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown template field {%s}", unknown)
+	}
+	return CleanRelPath(out)
+}
+
+// Move renames a local video and its sidecar files to newPath, a path
+// relative to the video's pool without extension. Either every file is
+// moved and the record updated, or nothing changes.
+func (s *VideoService) Move(id, newPath string) (Video, error) {
+	v, ok := s.Get(id)
+	if !ok {
+		return Video{}, fmt.Errorf("video %s not found", id)
+	}
+	if v.IsRemote() {
+		return Video{}, fmt.Errorf("video %s is on %s storage and can't be moved", id, v.Storage)
+	}
+
+	newRel := newPath + path.Ext(v.FilePath)
+	if newRel == v.FilePath {
+		return v, nil
+	}
+	if _, taken := s.findFile(v.Pool, newRel); taken {
+		return Video{}, fmt.Errorf("%s is already in the library", newRel)
+	}
+
+	dir, _ := s.PoolDir(v.Pool)
+	if err := moveVideoFiles(s.AbsPath(v), filepath.Join(dir, filepath.FromSlash(newPath))); err != nil {
+		return Video{}, err
+	}
+
+	oldRel := v.FilePath
+	s.mu.Lock()
+	if current, ok := s.videos[id]; ok {
+		current.FilePath = newRel
+		s.stamp(current)
+		v = current.clone()
+	}
+	s.mu.Unlock()
+
+	log.Printf("Moved %s to %s", oldRel, newRel)
+	return v, s.SaveMetadata()
+}
+
+// moveVideoFiles moves the video at src and its sidecars so the video's
+// path without extension becomes newStem. On failure the files already
+// moved are put back.
+func moveVideoFiles(src, newStem string) error {
+	files, err := RelatedFiles(src)
+	if err != nil {
+		return err
+	}
+
+	oldStem := strings.TrimSuffix(src, filepath.Ext(src))
+	var moved [][2]string
+	for _, file := range files {
+		dst := newStem + strings.TrimPrefix(file, oldStem)
+		if err := MoveFile(file, dst); err != nil {
+			// Put back what was already moved
+			for i := len(moved) - 1; i >= 0; i-- {
+				if rerr := MoveFile(moved[i][1], moved[i][0]); rerr != nil {
+					log.Printf("Failed to roll back move of %s: %v", moved[i][0], rerr)
+				}
+			}
+			return fmt.Errorf("moving %s: %w", filepath.Base(file), err)
+		}
+		moved = append(moved, [2]string{file, dst})
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMoveDottedTitle moves a video whose name starts other videos' names,
+// which must stay where they are
+func TestMoveDottedTitle(t *testing.T) {
+	s := newTestService(t)
+	var ids []string
+	for _, name := range []string{"Vol", "Vol. 1", "Vol.2"} {
+		v, err := s.AddFile(writeVideo(t, s, name, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, v.ID)
+	}
+	touch(t, s.Dir(), "Vol.jpg", "Vol.en.vtt", "Vol. 1.jpg", "Vol.2.en.vtt")
+
+	moved, err := s.Move(ids[0], "archive/Volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.FilePath != "archive/Volume.mp4" {
+		t.Errorf("moved to %s, want archive/Volume.mp4", moved.FilePath)
+	}
+	for _, name := range []string{"archive/Volume.mp4", "archive/Volume.info.json", "archive/Volume.jpg", "archive/Volume.en.vtt"} {
+		if _, err := os.Stat(filepath.Join(s.Dir(), name)); err != nil {
+			t.Errorf("%s wasn't moved: %v", name, err)
+		}
+	}
+	for _, name := range []string{"Vol. 1.mp4", "Vol. 1.info.json", "Vol. 1.jpg", "Vol.2.mp4", "Vol.2.info.json", "Vol.2.en.vtt"} {
+		if _, err := os.Stat(filepath.Join(s.Dir(), name)); err != nil {
+			t.Errorf("%s was moved with Vol.mp4: %v", name, err)
+		}
+	}
+	for _, id := range ids[1:] {
+		v, _ := s.Get(id)
+		if _, err := os.Stat(s.AbsPath(v)); err != nil {
+			t.Errorf("video %s points at a missing file: %v", v.FilePath, err)
+		}
+	}
+}
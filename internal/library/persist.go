@@ -0,0 +1,145 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// metadataBackups is how many rolling copies of metadata.json are kept
+	// as metadata.json.1 (newest) to metadata.json.N
+	metadataBackups = 3
+	// backupInterval spaces backups out so they cover more than the last
+	// few seconds of edits
+	backupInterval = time.Hour
+)
+
+// writeFileAtomic replaces path with data so readers, and a crash, see
+// either the old or the new contents but never a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself; not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backupPath returns the path of rolling backup n (1 is the newest)
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateBackups shifts the rolling backups along and copies the current
+// file to backup 1, unless the newest backup is younger than
+// backupInterval. It returns when the next backup is due.
+func rotateBackups(path string) (time.Time, error) {
+	if fi, err := os.Stat(backupPath(path, 1)); err == nil && time.Since(fi.ModTime()) < backupInterval {
+		return fi.ModTime().Add(backupInterval), nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		// Nothing to back up yet
+		return time.Time{}, nil
+	}
+
+	for n := metadataBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return time.Time{}, err
+		}
+	}
+	if err := copyFile(path, backupPath(path, 1)); err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(backupInterval), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// readIndex parses the index at path, falling back to the newest backup
+// that parses when it is corrupt. A corrupt file is kept beside the index
+// for inspection and replaced by the recovered copy.
+func readIndex(path string) ([]*Video, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var videos []*Video
+	parseErr := json.Unmarshal(data, &videos)
+	if parseErr == nil {
+		return videos, nil
+	}
+
+	for n := 1; n <= metadataBackups; n++ {
+		backup := backupPath(path, n)
+		data, err := os.ReadFile(backup)
+		if err != nil {
+			continue
+		}
+		var recovered []*Video
+		if err := json.Unmarshal(data, &recovered); err != nil {
+			log.Printf("Backup %s is also unreadable: %v", backup, err)
+			continue
+		}
+
+		corrupt := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+		log.Printf("Warning: %s is corrupt (%v); recovered %d videos from %s, corrupt file kept as %s",
+			path, parseErr, len(recovered), backup, corrupt)
+		if err := os.Rename(path, corrupt); err != nil {
+			log.Printf("Failed to set aside corrupt index: %v", err)
+		}
+		if err := writeFileAtomic(path, data, 0644); err != nil {
+			log.Printf("Failed to restore %s from backup: %v", path, err)
+		}
+		return recovered, nil
+	}
+
+	return nil, fmt.Errorf("parsing %s: %w", path, parseErr)
+}
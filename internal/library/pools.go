@@ -0,0 +1,71 @@
+package library
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// MoveToPool moves a local video and its sidecar files to the same
+// relative path in another pool, "" being the videos directory. Either
+// every file is moved and the record updated, or nothing changes.
+func (s *VideoService) MoveToPool(id, pool string) (Video, error) {
+	v, ok := s.Get(id)
+	if !ok {
+		return Video{}, fmt.Errorf("video %s not found", id)
+	}
+	if v.IsRemote() {
+		return Video{}, fmt.Errorf("video %s is on %s storage and can't be moved", id, v.Storage)
+	}
+	if v.Pool == pool {
+		return v, nil
+	}
+	dir, ok := s.PoolDir(pool)
+	if !ok {
+		return Video{}, fmt.Errorf("unknown pool %q", pool)
+	}
+	if _, taken := s.findFile(pool, v.FilePath); taken {
+		return Video{}, fmt.Errorf("%s is already in pool %s", v.FilePath, poolName(pool))
+	}
+
+	stem := strings.TrimSuffix(v.FilePath, filepath.Ext(v.FilePath))
+	if err := moveVideoFiles(s.AbsPath(v), filepath.Join(dir, filepath.FromSlash(stem))); err != nil {
+		return Video{}, err
+	}
+
+	from := v.Pool
+	s.mu.Lock()
+	if current, ok := s.videos[id]; ok {
+		current.Pool = pool
+		s.stamp(current)
+		v = current.clone()
+	}
+	s.mu.Unlock()
+
+	log.Printf("Moved %s from pool %s to %s", v.FilePath, poolName(from), poolName(pool))
+	return v, s.SaveMetadata()
+}
+
+// isPoolDir reports whether dir is the root of a pool, so walks of the
+// videos directory can leave a nested pool alone
+func (s *VideoService) isPoolDir(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for _, pool := range s.pools {
+		if poolAbs, err := filepath.Abs(pool); err == nil && poolAbs == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// poolName is pool as shown in logs and errors
+func poolName(pool string) string {
+	if pool == "" {
+		return "main"
+	}
+	return pool
+}
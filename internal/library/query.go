@@ -0,0 +1,419 @@
+package library
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/language"
+)
+
+// Query filters and orders library listings. Zero values don't filter.
+type Query struct {
+	// Text matches videos whose title, uploader, description, tags or
+	// transcript contain every word of it, case-insensitively
+	Text string
+	// Folder, when set, limits results to videos directly inside it
+	Folder *string
+	// Uploader and Site match case-insensitively; Site is the extractor
+	Uploader string
+	Site     string
+	Tag      string
+	// Category and ChannelID match case-insensitively
+	Category  string
+	ChannelID string
+	// VCodec and ACodec match codecs starting with them, so "avc1"
+	// matches "avc1.64001F"
+	VCodec string
+	ACodec string
+	// Languages matches videos in any of these ISO 639-1 codes
+	Languages []string
+	// From and To bound when the video was uploaded, and DownloadedFrom
+	// and DownloadedTo when it was downloaded. Each range includes From
+	// and excludes To.
+	From           time.Time
+	To             time.Time
+	DownloadedFrom time.Time
+	DownloadedTo   time.Time
+	// MinDuration and MaxDuration are in seconds
+	MinDuration float64
+	MaxDuration float64
+	MinSize     int64
+	MaxSize     int64
+	MinLikes    int
+	// MinHeight and MaxHeight bound the vertical resolution in pixels
+	MinHeight int
+	MaxHeight int
+	MinFPS    float64
+	Watched   *bool
+	// Favorite, WatchLater and InProgress filter on the caller's lists and
+	// playback positions, which Marked looks up. Without Marked no video is
+	// marked.
+	Favorite   *bool
+	WatchLater *bool
+	InProgress *bool
+	Marked     func(id string) Marks
+	// Sort is applied key by key; the default is newest first
+	Sort []SortKey
+	// details looks up what Text searches beyond the index. Find sets it.
+	details func(id string) Details
+}
+
+// Marks are what a user has noted about a video
+type Marks struct {
+	Favorite   bool
+	WatchLater bool
+	// InProgress is set when the user stopped partway through
+	InProgress bool
+}
+
+// SortKey orders by one field. It is written as "field" for ascending
+// order or "-field" for descending.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return "-" + k.Field
+	}
+	return k.Field
+}
+
+// sortFields compare two videos by one field, ascending
+var sortFields = map[string]func(a, b Video) int{
+	"title":       func(a, b Video) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+	"uploader":    func(a, b Video) int { return strings.Compare(strings.ToLower(a.Uploader), strings.ToLower(b.Uploader)) },
+	"upload_date": func(a, b Video) int { return compareTimes(a.UploadedAt, b.UploadedAt) },
+	"downloaded":  func(a, b Video) int { return a.DownloadedAt.Compare(b.DownloadedAt) },
+	"modified":    func(a, b Video) int { return a.ModTime.Compare(b.ModTime) },
+	"added":       func(a, b Video) int { return a.AddedAt.Compare(b.AddedAt) },
+	"size":        func(a, b Video) int { return compare(a.Size, b.Size) },
+	"views":       func(a, b Video) int { return compare(a.ViewCount, b.ViewCount) },
+	"likes":       func(a, b Video) int { return compare(a.LikeCount, b.LikeCount) },
+	"height":      func(a, b Video) int { return compare(a.Height, b.Height) },
+	"duration":    func(a, b Video) int { return compare(a.Duration, b.Duration) },
+}
+
+// sortAliases are shorthand sort values
+var sortAliases = map[string]string{
+	"newest": "-modified",
+	"oldest": "modified",
+}
+
+// SortFields lists the fields a query can sort by
+func SortFields() []string {
+	fields := make([]string, 0, len(sortFields))
+	for f := range sortFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// compareTimes orders unknown times before known ones
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+func compare[T int | int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ParseQuery reads a Query from URL parameters: q, folder, uploader, site,
+// tag, category, channel_id, vcodec, acodec, language (comma-separated
+// codes such as "en,de"), from, to, downloaded_from, downloaded_to
+// (YYYYMMDD or YYYY-MM-DD, inclusive, in UTC), min_duration,
+// max_duration (seconds), min_size, max_size (bytes), min_likes,
+// min_height, max_height, min_fps, watched, favorite, watch_later and
+// in_progress (true or false) and sort, a comma-separated list such as
+// "uploader,-added". The caller sets Marked for the per-user filters.
+func ParseQuery(params url.Values) (Query, error) {
+	q := Query{
+		Text:      strings.TrimSpace(params.Get("q")),
+		Uploader:  params.Get("uploader"),
+		Site:      params.Get("site"),
+		Tag:       params.Get("tag"),
+		Category:  params.Get("category"),
+		ChannelID: params.Get("channel_id"),
+		VCodec:    params.Get("vcodec"),
+		ACodec:    params.Get("acodec"),
+	}
+
+	for _, code := range strings.Split(params.Get("language"), ",") {
+		if code = language.Normalize(code); code != "" {
+			q.Languages = append(q.Languages, code)
+		}
+	}
+
+	if params.Has("folder") {
+		folder, err := CleanRelPath(params.Get("folder"))
+		if err != nil {
+			return q, err
+		}
+		q.Folder = &folder
+	}
+
+	var err error
+	for name, bound := range map[string]struct {
+		dst *time.Time
+		end bool
+	}{
+		"from":            {&q.From, false},
+		"to":              {&q.To, true},
+		"downloaded_from": {&q.DownloadedFrom, false},
+		"downloaded_to":   {&q.DownloadedTo, true},
+	} {
+		if *bound.dst, err = parseDate(name, params.Get(name)); err != nil {
+			return q, err
+		}
+		// The day given is included
+		if bound.end && !bound.dst.IsZero() {
+			*bound.dst = bound.dst.AddDate(0, 0, 1)
+		}
+	}
+
+	for name, dst := range map[string]*float64{"min_duration": &q.MinDuration, "max_duration": &q.MaxDuration} {
+		if v := params.Get(name); v != "" {
+			if *dst, err = strconv.ParseFloat(v, 64); err != nil || *dst < 0 {
+				return q, fmt.Errorf("%s must be a number of seconds", name)
+			}
+		}
+	}
+	if v := params.Get("min_fps"); v != "" {
+		if q.MinFPS, err = strconv.ParseFloat(v, 64); err != nil || q.MinFPS < 0 {
+			return q, fmt.Errorf("min_fps must be a number of frames per second")
+		}
+	}
+	for name, dst := range map[string]*int{"min_likes": &q.MinLikes, "min_height": &q.MinHeight, "max_height": &q.MaxHeight} {
+		if v := params.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 0 {
+				return q, fmt.Errorf("%s must be a whole number", name)
+			}
+		}
+	}
+	for name, dst := range map[string]*int64{"min_size": &q.MinSize, "max_size": &q.MaxSize} {
+		if v := params.Get(name); v != "" {
+			if *dst, err = strconv.ParseInt(v, 10, 64); err != nil || *dst < 0 {
+				return q, fmt.Errorf("%s must be a number of bytes", name)
+			}
+		}
+	}
+
+	for name, dst := range map[string]**bool{"watched": &q.Watched, "favorite": &q.Favorite, "watch_later": &q.WatchLater, "in_progress": &q.InProgress} {
+		if v := params.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be true or false", name)
+			}
+			*dst = &b
+		}
+	}
+
+	q.Sort, err = ParseSort(params.Get("sort"))
+	return q, err
+}
+
+// ParseSort reads a comma-separated sort list such as "uploader,-added"
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if alias, ok := sortAliases[part]; ok {
+			part = alias
+		}
+		key := SortKey{Field: strings.TrimLeft(part, "+-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q, expected one of %s", key.Field, strings.Join(SortFields(), ", "))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseDate accepts YYYYMMDD, as yt-dlp stores it, or YYYY-MM-DD, as
+// midnight UTC
+func parseDate(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102", strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return t, fmt.Errorf("%s must be a date like 2024-01-31", name)
+	}
+	return t, nil
+}
+
+// Match reports whether v passes every filter in q
+func (q Query) Match(v Video) bool {
+	switch {
+	case q.Folder != nil && v.Folder() != *q.Folder:
+		return false
+	case q.Uploader != "" && !strings.EqualFold(v.Uploader, q.Uploader):
+		return false
+	case q.Site != "" && !strings.EqualFold(v.Extractor, q.Site):
+		return false
+	case q.ChannelID != "" && !strings.EqualFold(v.ChannelID, q.ChannelID):
+		return false
+	case q.Category != "" && !slices.ContainsFunc(v.Categories, func(c string) bool { return strings.EqualFold(c, q.Category) }):
+		return false
+	case q.VCodec != "" && !hasPrefixFold(v.VCodec, q.VCodec):
+		return false
+	case q.ACodec != "" && !hasPrefixFold(v.ACodec, q.ACodec):
+		return false
+	case q.MinLikes > 0 && v.LikeCount < q.MinLikes:
+		return false
+	case q.MinHeight > 0 && v.Height < q.MinHeight:
+		return false
+	case q.MaxHeight > 0 && (v.Height == 0 || v.Height > q.MaxHeight):
+		return false
+	case q.MinFPS > 0 && v.FPS < q.MinFPS:
+		return false
+	case !q.From.IsZero() && (v.UploadedAt == nil || v.UploadedAt.Before(q.From)):
+		return false
+	case !q.To.IsZero() && (v.UploadedAt == nil || !v.UploadedAt.Before(q.To)):
+		return false
+	case !q.DownloadedFrom.IsZero() && v.DownloadedAt.Before(q.DownloadedFrom):
+		return false
+	case !q.DownloadedTo.IsZero() && !v.DownloadedAt.Before(q.DownloadedTo):
+		return false
+	case q.MinDuration > 0 && v.Duration < q.MinDuration:
+		return false
+	case q.MaxDuration > 0 && v.Duration > q.MaxDuration:
+		return false
+	case q.MinSize > 0 && v.Size < q.MinSize:
+		return false
+	case q.MaxSize > 0 && v.Size > q.MaxSize:
+		return false
+	case q.Watched != nil && *q.Watched != (v.WatchedAt != nil):
+		return false
+	case len(q.Languages) > 0 && !slices.Contains(q.Languages, v.Language):
+		return false
+	}
+	if q.Text != "" && !q.matchText(v) {
+		return false
+	}
+	if q.Favorite != nil || q.WatchLater != nil || q.InProgress != nil {
+		var m Marks
+		if q.Marked != nil {
+			m = q.Marked(v.ID)
+		}
+		if (q.Favorite != nil && *q.Favorite != m.Favorite) ||
+			(q.WatchLater != nil && *q.WatchLater != m.WatchLater) ||
+			(q.InProgress != nil && *q.InProgress != m.InProgress) {
+			return false
+		}
+	}
+	if q.Tag != "" {
+		// Suggested tags count as tags
+		for _, tags := range [][]string{v.Tags, v.SuggestedTags} {
+			for _, tag := range tags {
+				if strings.EqualFold(tag, q.Tag) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchText reports whether every word of q.Text appears somewhere in v's
+// searchable fields. The indexed fields are tried first so a video's
+// details are only read when they have to be.
+func (q Query) matchText(v Video) bool {
+	words := strings.Fields(strings.ToLower(q.Text))
+	words = missingWords(words, v.Title, v.Uploader, strings.Join(v.Tags, " "), strings.Join(v.SuggestedTags, " "))
+	if len(words) == 0 {
+		return true
+	}
+	if q.details == nil {
+		return false
+	}
+	d := q.details(v.ID)
+	return len(missingWords(words, d.Description, d.Transcript, d.Summary)) == 0
+}
+
+// hasPrefixFold reports whether s starts with prefix, ignoring case
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// missingWords returns the lowercase words that appear in none of fields
+func missingWords(words []string, fields ...string) []string {
+	haystack := strings.ToLower(strings.Join(fields, "\n"))
+	var missing []string
+	for _, word := range words {
+		if !strings.Contains(haystack, word) {
+			missing = append(missing, word)
+		}
+	}
+	return missing
+}
+
+// SortVideos orders list in place by keys, falling back to newest first
+// for ties and when keys is empty
+func SortVideos(list []Video, keys []SortKey) {
+	keys = append(keys, SortKey{Field: "modified", Desc: true})
+	sort.SliceStable(list, func(i, j int) bool {
+		for _, key := range keys {
+			c := sortFields[key.Field](list[i], list[j])
+			if key.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// searching returns q set up to search the details of s's videos
+func (s *VideoService) searching(q Query) Query {
+	q.details = func(id string) Details {
+		d, err := s.Details(id)
+		if err != nil {
+			log.Printf("Failed to read the details of %s: %v", id, err)
+		}
+		return d
+	}
+	return q
+}
+
+// Find returns the videos matching q in its sort order
+func (s *VideoService) Find(q Query) []Video {
+	q = s.searching(q)
+
+	var list []Video
+	for _, v := range s.List() {
+		if q.Match(v) {
+			list = append(list, v)
+		}
+	}
+	SortVideos(list, q.Sort)
+	return list
+}
@@ -0,0 +1,56 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ByUploader returns up to limit videos by uploader, newest first, leaving
+// out the video with id exclude. A negative limit returns them all.
+// Uploader names match case-insensitively.
+func (s *VideoService) ByUploader(uploader, exclude string, limit int) []Video {
+	if uploader == "" {
+		return nil
+	}
+
+	var list []Video
+	for _, v := range s.List() {
+		if v.ID != exclude && strings.EqualFold(v.Uploader, uploader) {
+			list = append(list, v)
+			if len(list) == limit {
+				break
+			}
+		}
+	}
+	return list
+}
+
+// SidecarFile is a file stored next to a video, such as its thumbnail
+type SidecarFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Sidecars lists the local files sharing the video's name stem, other than
+// the video itself
+func (s *VideoService) Sidecars(v Video) []SidecarFile {
+	path := s.AbsPath(v)
+	files, err := RelatedFiles(path)
+	if err != nil {
+		return nil
+	}
+
+	var sidecars []SidecarFile
+	for _, file := range files {
+		if file == path {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		sidecars = append(sidecars, SidecarFile{Name: filepath.Base(file), Size: fi.Size()})
+	}
+	return sidecars
+}
@@ -0,0 +1,117 @@
+package library
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RescanResult counts what a rescan changed
+type RescanResult struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Missing   int `json:"missing"`
+	Failed    int `json:"failed"`
+}
+
+// Rescan brings the index in line with the videos directory, every pool
+// and their subfolders, skipping hidden ones. Files whose size and
+// modification time match their record are skipped, so only new or changed
+// files are re-read and hashed. Local records whose file is gone are
+// flagged for repair. progress is called after each file.
+func (s *VideoService) Rescan(progress func(done, total int)) (RescanResult, error) {
+	var result RescanResult
+
+	roots := map[string]string{"": s.dir}
+	for name, dir := range s.pools {
+		roots[name] = dir
+	}
+
+	type found struct {
+		pool string
+		path string
+		rel  string
+		info fs.FileInfo
+	}
+	var files []found
+	for pool, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root && os.IsNotExist(err) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() {
+				if path == root {
+					return nil
+				}
+				if strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				// A pool nested inside another is walked on its own
+				if s.isPoolDir(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !IsVideoFile(d.Name()) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return nil
+			}
+			files = append(files, found{pool: pool, path: path, rel: filepath.ToSlash(rel), info: info})
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	onDisk := make(map[string]bool)
+	for i, f := range files {
+		onDisk[f.pool+":"+f.rel] = true
+
+		existing, known := s.findFile(f.pool, f.rel)
+		if known && existing.SHA256 != "" &&
+			existing.Size == f.info.Size() && existing.ModTime.Equal(f.info.ModTime()) {
+			result.Unchanged++
+		} else if _, err := s.AddFile(f.path); err != nil {
+			log.Printf("Failed to index %s: %v", f.rel, err)
+			result.Failed++
+		} else if known {
+			result.Updated++
+		} else {
+			result.Added++
+		}
+
+		if progress != nil {
+			progress(i+1, len(files))
+		}
+	}
+
+	s.mu.Lock()
+	for _, v := range s.videos {
+		if v.IsRemote() || onDisk[v.Pool+":"+v.FilePath] {
+			continue
+		}
+		result.Missing++
+		if v.State != StateNeedsRepair {
+			log.Printf("Flagging %s for repair, file is missing", v.FilePath)
+			v.State, v.Problem = StateNeedsRepair, ProblemMissing
+			s.stamp(v)
+		}
+	}
+	s.mu.Unlock()
+
+	return result, s.SaveMetadata()
+}
@@ -0,0 +1,139 @@
+package library
+
+import (
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Breakdown aggregates the videos sharing a key
+type Breakdown struct {
+	Key      string  `json:"key"`
+	Videos   int     `json:"videos"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration"`
+}
+
+// Stats summarises the library
+type Stats struct {
+	Videos int   `json:"videos"`
+	Bytes  int64 `json:"bytes"`
+	// Duration is the total running time in seconds
+	Duration    float64     `json:"duration"`
+	NeedsRepair int         `json:"needs_repair"`
+	Remote      int         `json:"remote"`
+	ByUploader  []Breakdown `json:"by_uploader"`
+	BySite      []Breakdown `json:"by_site"`
+	ByFormat    []Breakdown `json:"by_format"`
+	ByLanguage  []Breakdown `json:"by_language"`
+	// ByMonth groups videos by when they were added, as YYYY-MM
+	ByMonth []Breakdown `json:"by_month"`
+}
+
+// Stats computes totals and breakdowns over the whole library
+func (s *VideoService) Stats() Stats {
+	var stats Stats
+	uploaders := make(map[string]*Breakdown)
+	sites := make(map[string]*Breakdown)
+	formats := make(map[string]*Breakdown)
+	months := make(map[string]*Breakdown)
+	languages := make(map[string]*Breakdown)
+
+	for _, v := range s.List() {
+		stats.Videos++
+		stats.Bytes += v.Size
+		stats.Duration += v.Duration
+		if v.State == StateNeedsRepair {
+			stats.NeedsRepair++
+		}
+		if v.IsRemote() {
+			stats.Remote++
+		}
+
+		add(uploaders, orUnknown(v.Uploader), v)
+		add(sites, site(v), v)
+		add(formats, strings.TrimPrefix(strings.ToLower(filepath.Ext(v.FilePath)), "."), v)
+		add(months, v.AddedAt.Format("2006-01"), v)
+		add(languages, orUnknown(v.Language), v)
+	}
+
+	stats.ByUploader = sorted(uploaders, false)
+	stats.BySite = sorted(sites, false)
+	stats.ByFormat = sorted(formats, false)
+	stats.ByMonth = sorted(months, true)
+	stats.ByLanguage = sorted(languages, false)
+	return stats
+}
+
+func add(buckets map[string]*Breakdown, key string, v Video) {
+	b, ok := buckets[key]
+	if !ok {
+		b = &Breakdown{Key: key}
+		buckets[key] = b
+	}
+	b.Videos++
+	b.Bytes += v.Size
+	b.Duration += v.Duration
+}
+
+// sorted orders breakdowns by key when byKey is set, otherwise largest first
+func sorted(buckets map[string]*Breakdown, byKey bool) []Breakdown {
+	list := make([]Breakdown, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if byKey || list[i].Bytes == list[j].Bytes {
+			return list[i].Key < list[j].Key
+		}
+		return list[i].Bytes > list[j].Bytes
+	})
+	return list
+}
+
+// site names the source of a video, preferring the extractor
+func site(v Video) string {
+	if v.Extractor != "" {
+		return v.Extractor
+	}
+	if u, err := url.Parse(v.WebpageURL); err == nil && u.Host != "" {
+		return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	}
+	return "unknown"
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// UsedBy sums the size of the videos and album images that user's downloads
+// added and that are still in the library. Clips in albums are counted once,
+// as videos.
+func (s *VideoService) UsedBy(user string) int64 {
+	var used int64
+	s.mu.RLock()
+	for _, v := range s.videos {
+		if v.AddedBy == user {
+			used += v.Size
+		}
+	}
+	s.mu.RUnlock()
+
+	s.albums.mu.Lock()
+	defer s.albums.mu.Unlock()
+	for _, a := range s.albums.albums {
+		if a.AddedBy != user {
+			continue
+		}
+		for _, item := range a.Items {
+			if !item.Video {
+				used += item.Size
+			}
+		}
+	}
+	return used
+}
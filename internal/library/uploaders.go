@@ -0,0 +1,86 @@
+package library
+
+import (
+	"sort"
+	"strings"
+)
+
+// Uploader aggregates the videos in the library credited to one uploader
+type Uploader struct {
+	Name string `json:"name"`
+	// ChannelURL is taken from the most recent video that has one
+	ChannelURL string `json:"channel_url,omitempty"`
+	Site       string `json:"site,omitempty"`
+	VideoCount int    `json:"video_count"`
+	// TotalDuration is the combined running time in seconds
+	TotalDuration float64 `json:"total_duration"`
+	// WatchedDuration is the running time of the videos marked as watched
+	WatchedDuration float64 `json:"watched_duration"`
+	TotalSize       int64   `json:"total_size"`
+	// LatestUpload is the newest upload date, YYYYMMDD
+	LatestUpload string `json:"latest_upload,omitempty"`
+}
+
+// Uploaders lists everyone with a video in the library, by name. Names
+// that differ only in case are counted as one uploader.
+func (s *VideoService) Uploaders() []Uploader {
+	byName := make(map[string]*Uploader)
+	var order []string
+	for _, v := range s.List() {
+		if v.Uploader == "" {
+			continue
+		}
+		key := strings.ToLower(v.Uploader)
+		u, ok := byName[key]
+		if !ok {
+			u = &Uploader{Name: v.Uploader}
+			byName[key] = u
+			order = append(order, key)
+		}
+		u.add(v)
+	}
+
+	list := make([]Uploader, 0, len(order))
+	for _, key := range order {
+		list = append(list, *byName[key])
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// Uploader returns the uploader called name, matched case-insensitively,
+// along with their videos, newest first
+func (s *VideoService) Uploader(name string) (Uploader, []Video, bool) {
+	videos := s.ByUploader(name, "", -1)
+	if len(videos) == 0 {
+		return Uploader{}, nil, false
+	}
+
+	u := Uploader{Name: videos[0].Uploader}
+	for _, v := range videos {
+		u.add(v)
+	}
+	return u, videos, true
+}
+
+// add counts v towards u. Videos are expected newest first so the channel
+// URL comes from the latest one.
+func (u *Uploader) add(v Video) {
+	u.VideoCount++
+	u.TotalDuration += v.Duration
+	u.TotalSize += v.Size
+	if v.WatchedAt != nil {
+		u.WatchedDuration += v.Duration
+	}
+	if u.ChannelURL == "" {
+		u.ChannelURL = v.ChannelURL
+	}
+	if u.Site == "" {
+		u.Site = v.Extractor
+	}
+	if v.UploadDate > u.LatestUpload {
+		u.LatestUpload = v.UploadDate
+	}
+}
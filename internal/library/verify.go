@@ -0,0 +1,68 @@
+package library
+
+import (
+	"os"
+	"time"
+)
+
+// StateNeedsRepair marks a video whose file is missing or corrupted
+const StateNeedsRepair = "needs_repair"
+
+// Problems found by Verify
+const (
+	ProblemMissing      = "missing"
+	ProblemSizeMismatch = "size_mismatch"
+	ProblemHashMismatch = "hash_mismatch"
+)
+
+// Verify checks a local video's file against its recorded size and hash,
+// returning the problem found or "" when the file is intact. Remote videos
+// are not checked here.
+func (s *VideoService) Verify(v Video) string {
+	if v.IsRemote() {
+		return ""
+	}
+
+	fi, err := os.Stat(s.AbsPath(v))
+	if err != nil {
+		return ProblemMissing
+	}
+	if fi.Size() != v.Size {
+		return ProblemSizeMismatch
+	}
+	if v.SHA256 == "" {
+		return ""
+	}
+
+	hash, err := HashFile(s.AbsPath(v))
+	if err != nil {
+		return ProblemMissing
+	}
+	if hash != v.SHA256 {
+		return ProblemHashMismatch
+	}
+	return ""
+}
+
+// MarkVerified records the outcome of a check, flagging the video for
+// repair when problem is set and clearing the flag otherwise. The index is
+// not saved so a full pass writes it once; call SaveMetadata afterwards.
+func (s *VideoService) MarkVerified(id, problem string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.videos[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	v.VerifiedAt = &now
+	state := ""
+	if problem != "" {
+		state = StateNeedsRepair
+	}
+	if v.State != state || v.Problem != problem {
+		v.State, v.Problem = state, problem
+		s.stamp(v)
+	}
+}
@@ -0,0 +1,181 @@
+// Package lists keeps each user's favorites and watch-later queue,
+// persisted in the data directory.
+package lists
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// List names one of a user's video lists
+type List string
+
+const (
+	Favorites  List = "favorites"
+	WatchLater List = "watch_later"
+)
+
+// Entry is a video on a list
+type Entry struct {
+	VideoID string    `json:"video_id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Store holds every user's lists in memory and in lists.json. Servers
+// without users keep their lists under the empty user name.
+type Store struct {
+	path string
+
+	mu sync.Mutex
+	// lists maps user to list to video ID to when it was added
+	lists map[string]map[List]map[string]time.Time
+}
+
+// NewStore creates a store persisting to dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{
+		path:  filepath.Join(dataDir, "lists.json"),
+		lists: make(map[string]map[List]map[string]time.Time),
+	}
+}
+
+// Load reads the persisted lists, if any
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved map[string]map[List][]Entry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, lists := range saved {
+		for list, entries := range lists {
+			for _, e := range entries {
+				s.entries(user, list)[e.VideoID] = e.AddedAt
+			}
+		}
+	}
+	return nil
+}
+
+// entries returns user's list, creating it. The caller holds s.mu.
+func (s *Store) entries(user string, list List) map[string]time.Time {
+	if s.lists[user] == nil {
+		s.lists[user] = make(map[List]map[string]time.Time)
+	}
+	if s.lists[user][list] == nil {
+		s.lists[user][list] = make(map[string]time.Time)
+	}
+	return s.lists[user][list]
+}
+
+// Set adds the video with id to user's list, or takes it off. Adding a
+// video already on the list keeps its place.
+func (s *Store) Set(user string, list List, id string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.entries(user, list)
+	_, had := entries[id]
+	if on == had {
+		return nil
+	}
+	if on {
+		entries[id] = time.Now()
+	} else {
+		delete(entries, id)
+	}
+	return s.save()
+}
+
+// Has reports whether the video with id is on user's list
+func (s *Store) Has(user string, list List, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.lists[user][list][id]
+	return ok
+}
+
+// Entries returns user's list in the order videos were added
+func (s *Store) Entries(user string, list List) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, 0, len(s.lists[user][list]))
+	for id, added := range s.lists[user][list] {
+		entries = append(entries, Entry{VideoID: id, AddedAt: added})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].AddedAt.Equal(entries[j].AddedAt) {
+			return entries[i].AddedAt.Before(entries[j].AddedAt)
+		}
+		return entries[i].VideoID < entries[j].VideoID
+	})
+	return entries
+}
+
+// Forget takes a deleted video off every user's lists
+func (s *Store) Forget(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, lists := range s.lists {
+		for _, entries := range lists {
+			if _, ok := entries[id]; ok {
+				delete(entries, id)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes every list. The caller holds s.mu.
+func (s *Store) save() error {
+	saved := make(map[string]map[List][]Entry, len(s.lists))
+	for user, lists := range s.lists {
+		for list, entries := range lists {
+			if len(entries) == 0 {
+				continue
+			}
+			if saved[user] == nil {
+				saved[user] = make(map[List][]Entry)
+			}
+			for id, added := range entries {
+				saved[user][list] = append(saved[user][list], Entry{VideoID: id, AddedAt: added})
+			}
+			sort.Slice(saved[user][list], func(i, j int) bool {
+				return saved[user][list][i].VideoID < saved[user][list][j].VideoID
+			})
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,34 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractAudio writes the first audio track of in to out as 16 kHz mono,
+// which is what speech recognition expects. out's extension picks the
+// format: .wav for uncompressed PCM, or .mp3 for a small upload.
+func ExtractAudio(ctx context.Context, in, out string) error {
+	args := []string{"-y", "-v", "error", "-i", in, "-map", "0:a:0", "-vn", "-ac", "1", "-ar", "16000"}
+	switch strings.ToLower(filepath.Ext(out)) {
+	case ".wav":
+		args = append(args, "-c:a", "pcm_s16le")
+	case ".mp3":
+		args = append(args, "-c:a", "libmp3lame", "-b:a", "32k")
+	default:
+		return fmt.Errorf("unsupported audio format %s", filepath.Ext(out))
+	}
+	args = append(args, out)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg audio %s: %v: %s", in, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
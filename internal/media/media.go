@@ -0,0 +1,80 @@
+// Package media inspects video files and renders thumbnails with ffmpeg.
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Info is what ffprobe reports about a video file
+type Info struct {
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Codec    string  `json:"codec"`
+}
+
+// probeOutput is the subset of ffprobe's JSON we read
+type probeOutput struct {
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+}
+
+// Probe runs ffprobe on path
+func Probe(ctx context.Context, path string) (*Info, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"--", path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %s: %w", path, err)
+	}
+
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+
+	info := &Info{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" {
+			info.Width, info.Height, info.Codec = stream.Width, stream.Height, stream.CodecName
+			break
+		}
+	}
+	return info, nil
+}
+
+// Thumbnail writes a JPEG frame from at seconds into the video to out,
+// scaled to width pixels wide
+func Thumbnail(ctx context.Context, path, out string, at float64, width int) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-v", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 2, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", width),
+		out,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg thumbnail %s: %v: %s", path, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// muxers are ffmpeg's output formats for the containers metadata can be
+// written to, by extension
+var muxers = map[string]string{
+	".mp4":  "mp4",
+	".m4v":  "mp4",
+	".mov":  "mov",
+	".mkv":  "matroska",
+	".webm": "webm",
+}
+
+// EmbedMetadata copies in to out with tags such as "title" and "artist"
+// written into the container, without re-encoding. out may have any name;
+// the container is chosen from in's extension.
+func EmbedMetadata(ctx context.Context, in, out string, tags map[string]string) error {
+	muxer, ok := muxers[strings.ToLower(filepath.Ext(in))]
+	if !ok {
+		return fmt.Errorf("can't write metadata to %s files", filepath.Ext(in))
+	}
+
+	args := []string{"-y", "-v", "error", "-i", in, "-map", "0", "-c", "copy"}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+tags[key])
+	}
+	if muxer == "mp4" || muxer == "mov" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, "-f", muxer, out)
+
+	if output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg metadata %s: %v: %s", in, err, output)
+	}
+	return nil
+}
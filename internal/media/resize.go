@@ -0,0 +1,95 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Letterbox scales src to fit inside w x h, keeping its aspect ratio, and
+// centres it on a black background
+func Letterbox(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	sb := src.Bounds()
+	if sb.Dx() == 0 || sb.Dy() == 0 {
+		return dst
+	}
+	scale := min(float64(w)/float64(sb.Dx()), float64(h)/float64(sb.Dy()))
+	fw := max(1, int(float64(sb.Dx())*scale))
+	fh := max(1, int(float64(sb.Dy())*scale))
+	offset := image.Pt((w-fw)/2, (h-fh)/2)
+
+	// Average the source pixels covering each destination pixel, which
+	// avoids the aliasing of nearest-neighbour sampling when shrinking
+	for y := 0; y < fh; y++ {
+		y0 := sb.Min.Y + y*sb.Dy()/fh
+		y1 := max(y0+1, sb.Min.Y+(y+1)*sb.Dy()/fh)
+		for x := 0; x < fw; x++ {
+			x0 := sb.Min.X + x*sb.Dx()/fw
+			x1 := max(x0+1, sb.Min.X+(x+1)*sb.Dx()/fw)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, _ := src.At(sx, sy).RGBA()
+					r, g, b, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), n+1
+				}
+			}
+			dst.Set(offset.X+x, offset.Y+y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
+
+// ResizeImage letterboxes the image at in to w x h and writes it to out as
+// JPEG. JPEG and PNG are handled natively; other formats such as WebP are
+// converted with ffmpeg.
+func ResizeImage(ctx context.Context, in, out string, w, h int) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return resizeWithFFmpeg(ctx, in, out, w, h)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, Letterbox(src, w, h), &jpeg.Options{Quality: 85}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), out)
+}
+
+func resizeWithFFmpeg(ctx context.Context, in, out string, w, h int) error {
+	tmp := out + ".tmp.jpg"
+	defer os.Remove(tmp)
+
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:black", w, h, w, h)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-v", "error", "-i", in, "-frames:v", "1", "-vf", filter, tmp)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg resize %s: %v: %s", in, err, output)
+	}
+	return os.Rename(tmp, out)
+}
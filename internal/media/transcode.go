@@ -0,0 +1,61 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Transcode re-encodes in to an H.264/AAC MP4 at out that plays in every
+// browser, with the index moved to the front for streaming. progress, if
+// set, is called with the seconds encoded so far.
+func Transcode(ctx context.Context, in, out string, progress func(seconds float64)) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-v", "error",
+		"-nostats",
+		"-progress", "pipe:1",
+		"-i", in,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "160k",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		out,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg transcode %s: %w", in, err)
+	}
+
+	// -progress writes key=value lines; out_time_us is the position reached
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || key != "out_time_us" || progress == nil {
+			continue
+		}
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			progress(float64(us) / 1e6)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg transcode %s: %v: %s", in, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
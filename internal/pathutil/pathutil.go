@@ -0,0 +1,45 @@
+// Package pathutil keeps file names and library paths valid on every
+// platform the server runs on, Windows included.
+package pathutil
+
+import (
+	"regexp"
+	"strings"
+)
+
+// unsafeChars can't appear in a file name on at least one platform
+var unsafeChars = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]+`)
+
+// reservedNames are Windows device names, which can't be used as a file
+// name with any extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// IsReserved reports whether name is a Windows device name such as "CON"
+// or "nul.txt", ignoring case and anything after the first dot
+func IsReserved(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	return reservedNames[strings.ToUpper(strings.TrimRight(base, " "))]
+}
+
+// SanitizeFilename makes name safe to use as a single path segment on any
+// platform. Separators and characters Windows rejects become "_", trailing
+// dots and spaces (which Windows drops) are trimmed, and device names get
+// a "_" prefix. An empty result is returned as "_".
+func SanitizeFilename(name string) string {
+	name = unsafeChars.ReplaceAllString(name, "_")
+	name = strings.TrimLeft(name, " ")
+	name = strings.TrimRight(name, " .")
+	if IsReserved(name) {
+		name = "_" + name
+	}
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
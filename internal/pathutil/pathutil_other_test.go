@@ -0,0 +1,14 @@
+//go:build !windows
+
+package pathutil
+
+import "testing"
+
+// Names only Windows rejects are valid elsewhere
+func TestValidSegmentOther(t *testing.T) {
+	for _, in := range []string{"video.mp4", "CON", "nul.txt", "a:b", "what?", "trailing.", "trailing "} {
+		if !ValidSegment(in) {
+			t.Errorf("ValidSegment(%q) = false, want true", in)
+		}
+	}
+}
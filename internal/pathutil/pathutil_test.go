@@ -0,0 +1,69 @@
+package pathutil
+
+import "testing"
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "My Video.mp4", "My Video.mp4"},
+		{"slash", "a/b", "a_b"},
+		{"backslash", `a\b`, "a_b"},
+		{"drive letter", `C:\Windows`, "C_Windows"},
+		{"windows characters", `what? "yes" <no> a|b *`, "what_ _yes_ _no_ a_b _"},
+		{"control characters", "a\x00b\nc", "a_b_c"},
+		{"runs become one", "a/\\:b", "a_b"},
+		{"trailing dots and spaces", "name. . ", "name"},
+		{"leading spaces", "  name", "name"},
+		{"device name", "CON", "_CON"},
+		{"device name lowercase", "nul", "_nul"},
+		{"device name with extension", "com1.txt", "_com1.txt"},
+		{"device name with trailing dot", "AUX.", "_AUX"},
+		{"device name as prefix only", "CONSOLE", "CONSOLE"},
+		{"empty", "", "_"},
+		{"only dots", "..", "_"},
+		{"only spaces", "   ", "_"},
+		{"unicode", "日本語 ビデオ", "日本語 ビデオ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFilename(tt.in); got != tt.want {
+				t.Errorf("SanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"CON", true},
+		{"con", true},
+		{"Nul.txt", true},
+		{"PRN.tar.gz", true},
+		{"COM9", true},
+		{"LPT0.log", true},
+		{"AUX ", true},
+		{"COM10", false},
+		{"CONSOLE", false},
+		{"NULL", false},
+		{"video.CON", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsReserved(tt.in); got != tt.want {
+			t.Errorf("IsReserved(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Sanitized names are valid segments on every platform
+func TestSanitizedNamesAreValid(t *testing.T) {
+	for _, in := range []string{"CON", "a:b", "end.", "end ", `x*y?z"<>|`, "", "nul.mp4"} {
+		if out := SanitizeFilename(in); !ValidSegment(out) {
+			t.Errorf("SanitizeFilename(%q) = %q, which isn't a valid segment", in, out)
+		}
+	}
+}
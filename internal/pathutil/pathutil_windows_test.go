@@ -0,0 +1,33 @@
+//go:build windows
+
+package pathutil
+
+import "testing"
+
+func TestValidSegmentWindows(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"video.mp4", true},
+		{"My Folder", true},
+		{".hidden", true},
+		{"CON", false},
+		{"nul.txt", false},
+		{"com1.mp4", false},
+		{"C:", false},
+		{"file:stream", false},
+		{"what?", false},
+		{"a*b", false},
+		{`"quoted"`, false},
+		{"a<b>", false},
+		{"a|b", false},
+		{"trailing.", false},
+		{"trailing ", false},
+	}
+	for _, tt := range tests {
+		if got := ValidSegment(tt.in); got != tt.want {
+			t.Errorf("ValidSegment(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package pathutil
+
+import "strings"
+
+// ValidSegment reports whether seg can name a file or folder. Windows
+// rejects device names, names ending in a dot or space, and ':', which
+// would otherwise select a drive or an alternate data stream.
+func ValidSegment(seg string) bool {
+	return !strings.ContainsAny(seg, `:*?"<>|`) &&
+		!strings.HasSuffix(seg, ".") && !strings.HasSuffix(seg, " ") &&
+		!IsReserved(seg)
+}
@@ -0,0 +1,162 @@
+// Package playback remembers how far each user got through each video, so
+// playback can resume where it stopped. Positions are persisted in the data
+// directory.
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// finishedShare is how much of a video has to be played for it to count as
+// finished rather than in progress
+const finishedShare = 0.95
+
+// Position is where a user stopped in a video
+type Position struct {
+	VideoID string `json:"video_id"`
+	// Seconds is the playback position
+	Seconds   float64   `json:"seconds"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store holds every user's positions in memory and in playback.json.
+// Servers without users keep theirs under the empty user name.
+type Store struct {
+	path string
+
+	mu sync.Mutex
+	// positions maps user to video ID to position
+	positions map[string]map[string]Position
+}
+
+// NewStore creates a store persisting to dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{
+		path:      filepath.Join(dataDir, "playback.json"),
+		positions: make(map[string]map[string]Position),
+	}
+}
+
+// Load reads the persisted positions, if any
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved map[string][]Position
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, positions := range saved {
+		s.positions[user] = make(map[string]Position, len(positions))
+		for _, p := range positions {
+			s.positions[user][p.VideoID] = p
+		}
+	}
+	return nil
+}
+
+// Finished reports whether seconds is close enough to the end of a video
+// lasting duration seconds to count as watched. Unknown durations never are.
+func Finished(seconds, duration float64) bool {
+	return duration > 0 && seconds >= duration*finishedShare
+}
+
+// Set records user's position in the video with id. A position of 0, or
+// one that finishes the video, clears it.
+func (s *Store) Set(user, id string, seconds, duration float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seconds <= 0 || Finished(seconds, duration) {
+		if _, ok := s.positions[user][id]; !ok {
+			return nil
+		}
+		delete(s.positions[user], id)
+		return s.save()
+	}
+
+	if s.positions[user] == nil {
+		s.positions[user] = make(map[string]Position)
+	}
+	s.positions[user][id] = Position{VideoID: id, Seconds: seconds, UpdatedAt: time.Now()}
+	return s.save()
+}
+
+// Get returns user's position in the video with id
+func (s *Store) Get(user, id string) (Position, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.positions[user][id]
+	return p, ok
+}
+
+// InProgress returns user's positions, most recently played first
+func (s *Store) InProgress(user string) []Position {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Position, 0, len(s.positions[user]))
+	for _, p := range s.positions[user] {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	return list
+}
+
+// Forget drops every user's position in a deleted video
+func (s *Store) Forget(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, positions := range s.positions {
+		if _, ok := positions[id]; ok {
+			delete(positions, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes every position. The caller holds s.mu.
+func (s *Store) save() error {
+	saved := make(map[string][]Position, len(s.positions))
+	for user, positions := range s.positions {
+		if len(positions) == 0 {
+			continue
+		}
+		for _, p := range positions {
+			saved[user] = append(saved[user], p)
+		}
+		sort.Slice(saved[user], func(i, j int) bool { return saved[user][i].VideoID < saved[user][j].VideoID })
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,159 @@
+// Package postprocess runs the steps that follow a download, such as
+// generating thumbnails or uploading to remote storage, as plugins. Each
+// post-processor is registered with an order and whether it runs by
+// default, and profiles switch individual ones on or off per download.
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/jobs"
+	"noahjalex.ute/internal/library"
+	"noahjalex.ute/internal/tracing"
+)
+
+// Item is what a download produced. Processors that replace a file update
+// Files and Videos so later ones see the new one.
+type Item struct {
+	Job jobs.Job
+	// Files are every file the download put in the library, as absolute
+	// paths
+	Files []string
+	// Videos are the library entries indexed from Files
+	Videos []library.Video
+}
+
+// PostProcessor is one step run after a download
+type PostProcessor interface {
+	// Name identifies the processor in profiles and job steps
+	Name() string
+	Process(ctx context.Context, item *Item) error
+}
+
+// funcProcessor adapts a function to PostProcessor
+type funcProcessor struct {
+	name string
+	fn   func(ctx context.Context, item *Item) error
+}
+
+func (f funcProcessor) Name() string { return f.name }
+
+func (f funcProcessor) Process(ctx context.Context, item *Item) error { return f.fn(ctx, item) }
+
+// Func makes a post-processor called name from fn
+func Func(name string, fn func(ctx context.Context, item *Item) error) PostProcessor {
+	return funcProcessor{name: name, fn: fn}
+}
+
+// Info describes a registered post-processor
+type Info struct {
+	Name  string `json:"name"`
+	Order int    `json:"order"`
+	// Default is whether it runs for downloads whose profile doesn't
+	// mention it
+	Default bool `json:"default"`
+}
+
+type entry struct {
+	Info
+	p PostProcessor
+}
+
+// Registry holds the post-processors
+type Registry struct {
+	mu      sync.RWMutex
+	entries []entry
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds p, to run after those with a lower order. on says whether
+// it runs by default.
+func (r *Registry) Register(p PostProcessor, order int, on bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if slices.ContainsFunc(r.entries, func(e entry) bool { return e.Name == p.Name() }) {
+		panic("postprocess: " + p.Name() + " registered twice")
+	}
+	r.entries = append(r.entries, entry{Info: Info{Name: p.Name(), Order: order, Default: on}, p: p})
+	sort.SliceStable(r.entries, func(i, j int) bool { return r.entries[i].Order < r.entries[j].Order })
+}
+
+// List describes the post-processors in the order they run
+func (r *Registry) List() []Info {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Info, len(r.entries))
+	for i, e := range r.entries {
+		list[i] = e.Info
+	}
+	return list
+}
+
+// Check reports a profile naming a post-processor that isn't registered
+func (r *Registry) Check(profile config.Profile) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, name := range slices.Concat(profile.Enable, profile.Disable) {
+		if !slices.ContainsFunc(r.entries, func(e entry) bool { return e.Name == name }) {
+			return fmt.Errorf("unknown post-processor %q", name)
+		}
+	}
+	return nil
+}
+
+// Run applies the post-processors profile enables to item in order. A
+// failing processor is recorded and the rest still run.
+func (r *Registry) Run(ctx context.Context, item *Item, profile config.Profile) []jobs.Step {
+	r.mu.RLock()
+	entries := slices.Clone(r.entries)
+	r.mu.RUnlock()
+
+	var steps []jobs.Step
+	for _, e := range entries {
+		if !enabled(e.Info, profile) {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		started := time.Now()
+		step := jobs.Step{Name: e.Name}
+		stepCtx, span := tracing.Start(ctx, "postprocess "+e.Name)
+		if err := e.p.Process(stepCtx, item); err != nil {
+			log.Printf("Post-processor %s failed for job %s: %v", e.Name, item.Job.ID, err)
+			step.Error = err.Error()
+			span.SetError(err)
+		}
+		span.End()
+		step.Seconds = time.Since(started).Seconds()
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// enabled applies profile to a processor's default. Disable wins over
+// Enable.
+func enabled(info Info, profile config.Profile) bool {
+	switch {
+	case slices.Contains(profile.Disable, info.Name):
+		return false
+	case slices.Contains(profile.Enable, info.Name):
+		return true
+	}
+	return info.Default
+}
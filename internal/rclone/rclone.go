@@ -0,0 +1,96 @@
+// Package rclone pushes files to rclone remotes by running the rclone CLI.
+package rclone
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Progress reports bytes transferred so far out of total
+type Progress func(bytes, total int64)
+
+// Client runs rclone commands
+type Client struct {
+	// Binary is the rclone executable, "rclone" when empty
+	Binary string
+	// ConfigFile overrides rclone's default config location when set
+	ConfigFile string
+}
+
+// CopyTo uploads the local file src to dst (remote:path/name), calling
+// progress as rclone reports transfer stats
+func (c *Client) CopyTo(ctx context.Context, src, dst string, progress Progress) error {
+	args := []string{
+		"copyto", src, dst,
+		"--use-json-log",
+		"--stats", "1s",
+		// Stats are logged at INFO by default, which is hidden without -v
+		"--stats-log-level", "NOTICE",
+	}
+	if c.ConfigFile != "" {
+		args = append(args, "--config", c.ConfigFile)
+	}
+
+	cmd := exec.CommandContext(ctx, c.binary(), args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Keep the last few log messages for the error report
+	var errLog bytes.Buffer
+	scanStats(stderr, progress, &errLog)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("rclone copyto %s: %v: %s", dst, err, strings.TrimSpace(errLog.String()))
+	}
+	return nil
+}
+
+// logLine is the subset of rclone's --use-json-log output we read
+type logLine struct {
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	Stats *struct {
+		Bytes      int64 `json:"bytes"`
+		TotalBytes int64 `json:"totalBytes"`
+	} `json:"stats"`
+}
+
+func scanStats(r io.Reader, progress Progress, errLog *bytes.Buffer) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var line logLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		if line.Stats != nil {
+			if progress != nil {
+				progress(line.Stats.Bytes, line.Stats.TotalBytes)
+			}
+			continue
+		}
+		if line.Level == "error" || line.Level == "critical" {
+			if errLog.Len() < 4096 {
+				errLog.WriteString(line.Msg + "\n")
+			}
+		}
+	}
+}
+
+func (c *Client) binary() string {
+	if c.Binary == "" {
+		return "rclone"
+	}
+	return c.Binary
+}
@@ -0,0 +1,332 @@
+// Package scheduler runs the server's recurring background tasks, such as
+// checking subscriptions and verifying the library, and lets them be
+// listed, run on demand and paused. Which tasks are paused is persisted in
+// the data directory.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("no such task")
+	ErrRunning  = errors.New("task is already running")
+)
+
+// Func is the work a task does
+type Func func(ctx context.Context) error
+
+// Status is a task's schedule and how its runs have gone
+type Status struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Schedule is empty for tasks that only run on demand
+	Schedule string `json:"schedule,omitempty"`
+	Paused   bool   `json:"paused"`
+	Running  bool   `json:"running"`
+	// NextRun is unset while the task is paused or has no schedule
+	NextRun *time.Time `json:"next_run,omitempty"`
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// LastDuration is in seconds
+	LastDuration float64 `json:"last_duration,omitempty"`
+	LastError    string  `json:"last_error,omitempty"`
+	Runs         int     `json:"runs"`
+}
+
+type task struct {
+	name, description string
+	schedule          Schedule
+	run               Func
+
+	paused   bool
+	running  bool
+	next     time.Time
+	lastRun  time.Time
+	lastTook time.Duration
+	lastErr  string
+	runs     int
+	// wake interrupts the task's wait when it's paused or resumed
+	wake chan struct{}
+}
+
+// Scheduler holds the registered tasks
+type Scheduler struct {
+	path string
+
+	mu    sync.Mutex
+	tasks map[string]*task
+	// paused holds the persisted pauses, including of tasks not yet added
+	paused map[string]bool
+	ctx    context.Context
+}
+
+// New creates a scheduler persisting pauses to dataDir
+func New(dataDir string) *Scheduler {
+	return &Scheduler{
+		path:   filepath.Join(dataDir, "scheduler.json"),
+		tasks:  make(map[string]*task),
+		paused: make(map[string]bool),
+	}
+}
+
+// Load reads which tasks were paused, if any
+func (s *Scheduler) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved struct {
+		Paused []string `json:"paused"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range saved.Paused {
+		s.paused[name] = true
+		if t, ok := s.tasks[name]; ok {
+			t.paused = true
+		}
+	}
+	return nil
+}
+
+// Add registers a task. A nil schedule registers a task that only runs
+// when triggered. Tasks added after Start are scheduled straight away.
+func (s *Scheduler) Add(name, description string, schedule Schedule, run Func) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tasks[name]; ok {
+		panic("scheduler: task " + name + " added twice")
+	}
+	t := &task{
+		name:        name,
+		description: description,
+		schedule:    schedule,
+		run:         run,
+		paused:      s.paused[name],
+		wake:        make(chan struct{}, 1),
+	}
+	s.tasks[name] = t
+	if s.ctx != nil {
+		go s.loop(s.ctx, t)
+	}
+}
+
+// Start runs each task on its schedule until ctx is done. Runs in progress
+// see ctx cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ctx = ctx
+	for _, t := range s.tasks {
+		go s.loop(ctx, t)
+	}
+}
+
+// loop waits for each of t's scheduled times and runs it, skipping a time
+// when t is paused or still running
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	if t.schedule == nil {
+		return
+	}
+
+	for {
+		s.mu.Lock()
+		t.next = time.Time{}
+		if !t.paused {
+			t.next = t.schedule.Next(time.Now())
+		}
+		next := t.next
+		s.mu.Unlock()
+
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-t.wake:
+		case <-fire:
+			if err := s.start(ctx, t, false); err != nil && !errors.Is(err, ErrRunning) {
+				log.Printf("Skipping scheduled %s task: %v", t.name, err)
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// start runs t in the background. Scheduled runs skip paused tasks;
+// manual ones don't.
+func (s *Scheduler) start(ctx context.Context, t *task, manual bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.running {
+		return ErrRunning
+	}
+	if t.paused && !manual {
+		return errors.New("task is paused")
+	}
+	t.running = true
+
+	go func() {
+		started := time.Now()
+		err := t.run(ctx)
+		if err != nil {
+			log.Printf("Task %s failed: %v", t.name, err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		t.running = false
+		t.runs++
+		t.lastRun, t.lastTook = started, time.Since(started)
+		t.lastErr = ""
+		if err != nil {
+			t.lastErr = err.Error()
+		}
+	}()
+	return nil
+}
+
+// Trigger runs the task called name now, whether or not it's paused
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	t, ok := s.tasks[name]
+	ctx := s.ctx
+	s.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return s.start(ctx, t, true)
+}
+
+// Pause stops or resumes the scheduled runs of the task called name. A run
+// in progress finishes.
+func (s *Scheduler) Pause(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if t.paused == paused {
+		return nil
+	}
+	t.paused = paused
+	if paused {
+		t.next = time.Time{}
+		s.paused[name] = true
+	} else {
+		delete(s.paused, name)
+	}
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return s.save()
+}
+
+// Get returns the status of the task called name
+func (s *Scheduler) Get(name string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[name]
+	if !ok {
+		return Status{}, false
+	}
+	return t.status(), true
+}
+
+// List returns every task's status by name
+func (s *Scheduler) List() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Status, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		list = append(list, t.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// status describes t. The caller holds s.mu.
+func (t *task) status() Status {
+	st := Status{
+		Name:        t.name,
+		Description: t.description,
+		Paused:      t.paused,
+		Running:     t.running,
+		LastError:   t.lastErr,
+		Runs:        t.runs,
+	}
+	if t.schedule != nil {
+		st.Schedule = t.schedule.String()
+	}
+	if !t.next.IsZero() {
+		next := t.next
+		st.NextRun = &next
+	}
+	if !t.lastRun.IsZero() {
+		last := t.lastRun
+		st.LastRun = &last
+		st.LastDuration = t.lastTook.Seconds()
+	}
+	return st
+}
+
+// save writes which tasks are paused. The caller holds s.mu.
+func (s *Scheduler) save() error {
+	var saved struct {
+		Paused []string `json:"paused"`
+	}
+	saved.Paused = []string{}
+	for name := range s.paused {
+		saved.Paused = append(saved.Paused, name)
+	}
+	sort.Strings(saved.Paused)
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
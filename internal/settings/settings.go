@@ -0,0 +1,165 @@
+// Package settings holds the options that can be changed while the server
+// runs. They start from the config file; changes are saved to
+// settings.json in the data directory and take precedence from then on.
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"noahjalex.ute/internal/config"
+)
+
+// MaxWorkers caps the number of concurrent downloads
+const MaxWorkers = 16
+
+// Settings are the runtime-mutable options
+type Settings struct {
+	// Workers is the number of downloads run at once
+	Workers int `json:"workers"`
+	// DefaultFormat is the yt-dlp format selector for downloads that don't
+	// pick one
+	DefaultFormat string           `json:"default_format"`
+	Retention     config.Retention `json:"retention"`
+	// Webhooks receive finished jobs as JSON
+	Webhooks []string `json:"webhooks"`
+}
+
+// FromConfig takes the initial settings from the config file
+func FromConfig(cfg config.Config) Settings {
+	return Settings{
+		Workers:       cfg.Queue.Workers,
+		DefaultFormat: cfg.YtDlp.DefaultFormat,
+		Retention:     cfg.Retention,
+		Webhooks:      cfg.Notifications.Webhooks,
+	}
+}
+
+func (s Settings) clone() Settings {
+	s.Webhooks = append([]string{}, s.Webhooks...)
+	return s
+}
+
+// Validate rejects settings the server can't apply
+func (s Settings) Validate() error {
+	if s.Workers < 1 || s.Workers > MaxWorkers {
+		return fmt.Errorf("workers must be between 1 and %d", MaxWorkers)
+	}
+	if strings.HasPrefix(s.DefaultFormat, "-") {
+		return fmt.Errorf("default_format must be a format selector, not a flag")
+	}
+	if s.Retention.MaxAge < 0 {
+		return fmt.Errorf("retention max_age can't be negative")
+	}
+	for _, hook := range s.Webhooks {
+		u, err := url.Parse(hook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q must be an http or https URL", hook)
+		}
+	}
+	return nil
+}
+
+// Store holds the current settings
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	current Settings
+}
+
+// NewStore creates a store starting from defaults, persisting to dataDir
+func NewStore(dataDir string, defaults Settings) *Store {
+	return &Store{
+		path:    filepath.Join(dataDir, "settings.json"),
+		current: defaults.clone(),
+	}
+}
+
+// Load applies the saved settings, if any, over the defaults. Invalid
+// saved settings are rejected and the defaults kept.
+func (st *Store) Load() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	next, err := st.read(st.current)
+	if err != nil {
+		return err
+	}
+	st.current = next
+	return nil
+}
+
+// Reload starts over from new defaults, such as after the config file
+// changed, applying the saved settings over them as Load does
+func (st *Store) Reload(defaults Settings) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	next, err := st.read(defaults)
+	if err != nil {
+		return err
+	}
+	st.current = next
+	return nil
+}
+
+// read returns the saved settings decoded over base, or base when none
+// are saved. The caller holds st.mu.
+func (st *Store) read(base Settings) (Settings, error) {
+	next := base.clone()
+	data, err := os.ReadFile(st.path)
+	if os.IsNotExist(err) {
+		return next, nil
+	} else if err != nil {
+		return Settings{}, err
+	}
+
+	// Decoding over the defaults keeps any setting the file doesn't name
+	next.Webhooks = nil
+	if err := json.Unmarshal(data, &next); err != nil {
+		return Settings{}, fmt.Errorf("parsing %s: %w", st.path, err)
+	}
+	if err := next.Validate(); err != nil {
+		return Settings{}, fmt.Errorf("%s: %w", st.path, err)
+	}
+	return next, nil
+}
+
+// Get returns the current settings
+func (st *Store) Get() Settings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.current.clone()
+}
+
+// Set validates and saves next, replacing the current settings
+func (st *Store) Set(next Settings) error {
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	next = next.clone()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0755); err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		return err
+	}
+	st.current = next
+	return nil
+}
@@ -0,0 +1,726 @@
+// Package sftp is a small SFTP version 3 client, enough for the storage
+// backend: it creates, reads and removes files and makes directories.
+// Dial runs the ssh client with the sftp subsystem and speaks the protocol
+// over its pipes, so keys, agents and host key checks are left to ssh.
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// Packet types from draft-ietf-secsh-filexfer-02
+const (
+	typeInit    = 1
+	typeVersion = 2
+	typeOpen    = 3
+	typeClose   = 4
+	typeRead    = 5
+	typeWrite   = 6
+	typeFstat   = 8
+	typeRemove  = 13
+	typeMkdir   = 14
+	typeStat    = 17
+	typeStatus  = 101
+	typeHandle  = 102
+	typeData    = 103
+	typeAttrs   = 105
+)
+
+// Flags of SSH_FXP_OPEN
+const (
+	openRead   = 0x01
+	openWrite  = 0x02
+	openCreate = 0x08
+	openTrunc  = 0x10
+)
+
+// Attribute flags
+const (
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrTimes       = 0x08
+	attrExtended    = 0x80000000
+)
+
+// Status codes
+const (
+	StatusOK               = 0
+	StatusEOF              = 1
+	StatusNoSuchFile       = 2
+	StatusPermissionDenied = 3
+	StatusFailure          = 4
+)
+
+const (
+	// chunkSize is the most data asked for or sent in one packet; every
+	// server must accept 32 KiB
+	chunkSize = 32 * 1024
+	// window is how many reads or writes are in flight at once, so a
+	// transfer isn't held up by a round trip per chunk
+	window = 32
+	// maxPacket bounds the packets read from the server
+	maxPacket = 1 << 20
+	// waitDelay is how long Close waits for ssh to exit once its input
+	// is closed
+	waitDelay = 5 * time.Second
+)
+
+// StatusError is a failure reported by the server
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return "sftp: " + e.Message
+	}
+	return fmt.Sprintf("sftp: status %d", e.Code)
+}
+
+// Is lets errors.Is match fs.ErrNotExist and fs.ErrPermission
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Code == StatusNoSuchFile
+	case fs.ErrPermission:
+		return e.Code == StatusPermissionDenied
+	}
+	return false
+}
+
+// FileInfo is what the server reports about a file
+type FileInfo struct {
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// IsDir reports whether the file is a directory
+func (fi FileInfo) IsDir() bool {
+	return fi.Mode.IsDir()
+}
+
+// Options say how Dial reaches the server
+type Options struct {
+	// Binary is the ssh executable, "ssh" when empty
+	Binary string
+	// Host is the server, with ":port" when it isn't 22
+	Host           string
+	User           string
+	IdentityFile   string
+	KnownHostsFile string
+}
+
+// Client is an SFTP session. It is not safe for concurrent use; open one
+// per transfer.
+type Client struct {
+	rw     io.ReadWriteCloser
+	nextID uint32
+	// early holds replies read while waiting for another request's
+	early map[uint32]packet
+	// err is set once the connection can't be used any more
+	err error
+}
+
+// packet is a reply from the server, without its request ID
+type packet struct {
+	typ  byte
+	data []byte
+}
+
+// Dial starts ssh with the sftp subsystem and opens a session over it.
+// ssh runs in batch mode, so it fails rather than asking for a password
+// or about an unknown host key.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	binary := opts.Binary
+	if binary == "" {
+		binary = "ssh"
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	host := opts.Host
+	if h, port, err := net.SplitHostPort(opts.Host); err == nil {
+		host = h
+		args = append(args, "-p", port)
+	}
+	if opts.User != "" {
+		args = append(args, "-l", opts.User)
+	}
+	if opts.IdentityFile != "" {
+		args = append(args, "-i", opts.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	if opts.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+opts.KnownHostsFile)
+	}
+	args = append(args, "-s", "--", host, "sftp")
+
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.WaitDelay = waitDelay
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &process{Reader: stdout, stdin: stdin, cmd: cmd}
+	cmd.Stderr = &p.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(p)
+	if err != nil {
+		p.Close()
+		if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// process is a running ssh client, read from and written to through its
+// standard output and input
+type process struct {
+	io.Reader
+	stdin  io.WriteCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func (p *process) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close ends the session by closing ssh's input and waits for it to exit
+func (p *process) Close() error {
+	p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+			return fmt.Errorf("ssh: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ssh: %w", err)
+	}
+	return nil
+}
+
+// NewClient opens a session over rw, a connection to an SFTP server such
+// as ssh's pipes
+func NewClient(rw io.ReadWriteCloser) (*Client, error) {
+	c := &Client{rw: rw, early: make(map[uint32]packet)}
+
+	var init []byte
+	init = binary.BigEndian.AppendUint32(init, 3)
+	if err := c.writePacket(typeInit, init); err != nil {
+		return nil, err
+	}
+	typ, data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeVersion || len(data) < 4 {
+		return nil, fmt.Errorf("sftp: expected the server's version, got packet type %d", typ)
+	}
+	if v := binary.BigEndian.Uint32(data); v < 3 {
+		return nil, fmt.Errorf("sftp: server speaks version %d, need 3", v)
+	}
+	return c, nil
+}
+
+// Close ends the session
+func (c *Client) Close() error {
+	return c.rw.Close()
+}
+
+// Stat returns what the server knows about the file at name, following
+// symbolic links
+func (c *Client) Stat(name string) (FileInfo, error) {
+	p, err := c.call(typeStat, appendString(nil, name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return attrsReply(p)
+}
+
+// Mkdir creates the directory name
+func (c *Client) Mkdir(name string) error {
+	payload := appendString(nil, name)
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	p, err := c.call(typeMkdir, payload)
+	if err != nil {
+		return err
+	}
+	return statusReply(p)
+}
+
+// MkdirAll creates the directory name and any parents it needs
+func (c *Client) MkdirAll(name string) error {
+	name = path.Clean(name)
+	if name == "." || name == "/" {
+		return nil
+	}
+	fi, err := c.Stat(name)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("sftp: %s is not a directory", name)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := c.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	return c.Mkdir(name)
+}
+
+// Remove deletes the file name
+func (c *Client) Remove(name string) error {
+	p, err := c.call(typeRemove, appendString(nil, name))
+	if err != nil {
+		return err
+	}
+	return statusReply(p)
+}
+
+// Open opens the file name for reading
+func (c *Client) Open(name string) (*File, error) {
+	return c.open(name, openRead)
+}
+
+// Create opens the file name for writing, emptying it if it exists
+func (c *Client) Create(name string) (*File, error) {
+	return c.open(name, openWrite|openCreate|openTrunc)
+}
+
+func (c *Client) open(name string, flags uint32) (*File, error) {
+	payload := appendString(nil, name)
+	payload = binary.BigEndian.AppendUint32(payload, flags)
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	p, err := c.call(typeOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	switch p.typ {
+	case typeHandle:
+		handle, _, ok := readString(p.data)
+		if !ok {
+			return nil, errMalformed
+		}
+		return &File{c: c, handle: handle}, nil
+	case typeStatus:
+		return nil, statusReply(p)
+	}
+	return nil, unexpected(p)
+}
+
+// File is an open file on the server
+type File struct {
+	c      *Client
+	handle string
+	// offset is where the next write goes
+	offset int64
+}
+
+// Stat returns what the server knows about the open file
+func (f *File) Stat() (FileInfo, error) {
+	p, err := f.c.call(typeFstat, appendString(nil, f.handle))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return attrsReply(p)
+}
+
+// Write writes b at the file's offset
+func (f *File) Write(b []byte) (int, error) {
+	n, err := f.ReadFrom(bytes.NewReader(b))
+	return int(n), err
+}
+
+// ReadFrom writes everything read from r at the file's offset, keeping
+// several writes in flight
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	type write struct {
+		id uint32
+		n  int
+	}
+	var inflight []write
+	var written int64
+	// settle waits for the oldest write to be acknowledged
+	settle := func() error {
+		w := inflight[0]
+		inflight = inflight[1:]
+		if err := f.c.waitStatus(w.id); err != nil {
+			for _, rest := range inflight {
+				f.c.drain([]uint32{rest.id})
+			}
+			return err
+		}
+		written += int64(w.n)
+		return nil
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			payload := appendString(nil, f.handle)
+			payload = binary.BigEndian.AppendUint64(payload, uint64(f.offset))
+			payload = appendString(payload, string(buf[:n]))
+			id, err := f.c.send(typeWrite, payload)
+			if err != nil {
+				return written, err
+			}
+			inflight = append(inflight, write{id, n})
+			f.offset += int64(n)
+		}
+		done := rerr == io.EOF || rerr == io.ErrUnexpectedEOF
+		for len(inflight) > 0 && (len(inflight) >= window || rerr != nil) {
+			if err := settle(); err != nil {
+				return written, err
+			}
+		}
+		if done {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// Reader returns a reader of the n bytes from off, which keeps several
+// reads in flight. It fails with io.ErrUnexpectedEOF if the file ends
+// first.
+func (f *File) Reader(off, n int64) io.Reader {
+	return &reader{f: f, next: off, end: off + n}
+}
+
+// Close closes the file on the server
+func (f *File) Close() error {
+	p, err := f.c.call(typeClose, appendString(nil, f.handle))
+	if err != nil {
+		return err
+	}
+	return statusReply(p)
+}
+
+// reader reads a range of a file, asking for the next chunks before they
+// are needed
+type reader struct {
+	f *File
+	// next is the offset to ask for next, end where the range stops
+	next, end int64
+	// queue holds the reads asked for, in file order
+	queue []readRequest
+	buf   []byte
+	err   error
+}
+
+// readRequest is an SSH_FXP_READ waiting for its reply
+type readRequest struct {
+	id  uint32
+	off int64
+	n   int
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		for len(r.queue) < window && r.next < r.end {
+			n := int(min(chunkSize, r.end-r.next))
+			req, err := r.request(r.next, n)
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.queue = append(r.queue, req)
+			r.next += int64(n)
+		}
+		if r.err != nil {
+			continue
+		}
+		if len(r.queue) == 0 {
+			r.err = io.EOF
+			continue
+		}
+
+		req := r.queue[0]
+		r.queue = r.queue[1:]
+		p, err := r.f.c.wait(req.id)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		switch p.typ {
+		case typeData:
+			data, _, ok := readString(p.data)
+			if !ok || len(data) == 0 || len(data) > req.n {
+				r.err = errMalformed
+				continue
+			}
+			if len(data) < req.n {
+				// Servers may answer with less than asked for; ask for
+				// the rest before the reads queued after it
+				rest, err := r.request(req.off+int64(len(data)), req.n-len(data))
+				if err != nil {
+					r.err = err
+					continue
+				}
+				r.queue = append([]readRequest{rest}, r.queue...)
+			}
+			r.buf = []byte(data)
+		case typeStatus:
+			if err := statusReply(p); err != nil {
+				var serr *StatusError
+				if errors.As(err, &serr) && serr.Code == StatusEOF {
+					err = io.ErrUnexpectedEOF
+				}
+				r.err = err
+			} else {
+				r.err = errMalformed
+			}
+		default:
+			r.err = unexpected(p)
+		}
+		if r.err != nil {
+			r.f.c.drain(idsOf(r.queue))
+			r.queue = nil
+		}
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) request(off int64, n int) (readRequest, error) {
+	payload := appendString(nil, r.f.handle)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(off))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(n))
+	id, err := r.f.c.send(typeRead, payload)
+	return readRequest{id: id, off: off, n: n}, err
+}
+
+func idsOf(queue []readRequest) []uint32 {
+	ids := make([]uint32, len(queue))
+	for i, req := range queue {
+		ids[i] = req.id
+	}
+	return ids
+}
+
+var errMalformed = errors.New("sftp: malformed reply")
+
+func unexpected(p packet) error {
+	return fmt.Errorf("sftp: unexpected reply of type %d", p.typ)
+}
+
+// call sends a request and waits for its reply
+func (c *Client) call(typ byte, payload []byte) (packet, error) {
+	id, err := c.send(typ, payload)
+	if err != nil {
+		return packet{}, err
+	}
+	return c.wait(id)
+}
+
+// send sends a request, returning its ID
+func (c *Client) send(typ byte, payload []byte) (uint32, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	data := binary.BigEndian.AppendUint32(nil, id)
+	if err := c.writePacket(typ, append(data, payload...)); err != nil {
+		c.err = err
+		return 0, err
+	}
+	return id, nil
+}
+
+// wait returns the reply to the request id, keeping the replies to other
+// requests that arrive first
+func (c *Client) wait(id uint32) (packet, error) {
+	if p, ok := c.early[id]; ok {
+		delete(c.early, id)
+		return p, nil
+	}
+	for {
+		if c.err != nil {
+			return packet{}, c.err
+		}
+		typ, data, err := c.readPacket()
+		if err == nil && len(data) < 4 {
+			err = errMalformed
+		}
+		if err != nil {
+			c.err = err
+			return packet{}, err
+		}
+		p := packet{typ: typ, data: data[4:]}
+		if got := binary.BigEndian.Uint32(data); got != id {
+			c.early[got] = p
+			continue
+		}
+		return p, nil
+	}
+}
+
+// waitStatus waits for the status reply to the request id
+func (c *Client) waitStatus(id uint32) error {
+	p, err := c.wait(id)
+	if err != nil {
+		return err
+	}
+	return statusReply(p)
+}
+
+// drain reads and drops the replies to requests given up on
+func (c *Client) drain(ids []uint32) {
+	for _, id := range ids {
+		if _, err := c.wait(id); err != nil {
+			return
+		}
+	}
+}
+
+func (c *Client) writePacket(typ byte, payload []byte) error {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 5+len(payload)), uint32(1+len(payload)))
+	buf = append(buf, typ)
+	buf = append(buf, payload...)
+	_, err := c.rw.Write(buf)
+	return err
+}
+
+func (c *Client) readPacket() (byte, []byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		if err == io.EOF {
+			err = errors.New("sftp: connection closed")
+		}
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(head[:4])
+	if length < 1 || length > maxPacket {
+		return 0, nil, fmt.Errorf("sftp: packet of %d bytes", length)
+	}
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(c.rw, data); err != nil {
+		return 0, nil, err
+	}
+	return head[4], data, nil
+}
+
+// statusReply turns an SSH_FXP_STATUS reply into nil or a *StatusError
+func statusReply(p packet) error {
+	if p.typ != typeStatus {
+		return unexpected(p)
+	}
+	if len(p.data) < 4 {
+		return errMalformed
+	}
+	code := binary.BigEndian.Uint32(p.data)
+	if code == StatusOK {
+		return nil
+	}
+	msg, _, _ := readString(p.data[4:])
+	return &StatusError{Code: code, Message: msg}
+}
+
+// attrsReply decodes an SSH_FXP_ATTRS reply
+func attrsReply(p packet) (FileInfo, error) {
+	if p.typ == typeStatus {
+		if err := statusReply(p); err != nil {
+			return FileInfo{}, err
+		}
+		return FileInfo{}, errMalformed
+	}
+	if p.typ != typeAttrs {
+		return FileInfo{}, unexpected(p)
+	}
+
+	var fi FileInfo
+	b := p.data
+	u32 := func() uint32 {
+		if len(b) < 4 {
+			b = nil
+			return 0
+		}
+		v := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		return v
+	}
+	if len(b) < 4 {
+		return fi, errMalformed
+	}
+	flags := u32()
+	if flags&attrSize != 0 {
+		if len(b) < 8 {
+			return fi, errMalformed
+		}
+		fi.Size = int64(binary.BigEndian.Uint64(b))
+		b = b[8:]
+	}
+	if flags&attrUIDGID != 0 {
+		u32()
+		u32()
+	}
+	if flags&attrPermissions != 0 {
+		fi.Mode = fileMode(u32())
+	}
+	if flags&attrTimes != 0 {
+		u32()
+		fi.ModTime = time.Unix(int64(u32()), 0)
+	}
+	if b == nil {
+		return fi, errMalformed
+	}
+	return fi, nil
+}
+
+// fileMode converts POSIX mode bits to an fs.FileMode
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		m |= fs.ModeDir
+	case 0120000:
+		m |= fs.ModeSymlink
+	}
+	return m
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a length-prefixed string from b, returning the rest
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return "", nil, false
+	}
+	return string(b[4 : 4+n]), b[4+n:], true
+}
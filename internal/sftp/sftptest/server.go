@@ -0,0 +1,246 @@
+// Package sftptest is an SFTP version 3 server for tests, serving a local
+// directory over any pipe, such as the standard input and output of a
+// program run in place of ssh
+package sftptest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Server answers requests for the files under Root. Paths are resolved
+// inside Root whether or not they start with a slash.
+type Server struct {
+	Root string
+	// MaxRead caps the data returned by each read, to test clients
+	// against servers that answer with less than asked for
+	MaxRead int
+
+	mu      sync.Mutex
+	handles map[string]*os.File
+	next    int
+}
+
+// Serve answers the requests read from rw until it is closed
+func (s *Server) Serve(rw io.ReadWriter) error {
+	s.handles = make(map[string]*os.File)
+	defer func() {
+		for _, f := range s.handles {
+			f.Close()
+		}
+	}()
+	for {
+		var head [4]byte
+		if _, err := io.ReadFull(rw, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		packet := make([]byte, binary.BigEndian.Uint32(head[:]))
+		if _, err := io.ReadFull(rw, packet); err != nil {
+			return err
+		}
+		if len(packet) == 0 {
+			return errors.New("empty packet")
+		}
+
+		var reply []byte
+		if packet[0] == 1 {
+			reply = binary.BigEndian.AppendUint32([]byte{2}, 3)
+		} else {
+			reply = s.handle(packet)
+		}
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(reply)))
+		if _, err := rw.Write(append(out, reply...)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers one request, a packet type followed by its ID and fields
+func (s *Server) handle(packet []byte) []byte {
+	typ := packet[0]
+	r := &fields{b: packet[1:]}
+	id := r.u32()
+	status := func(code uint32, msg string) []byte {
+		b := binary.BigEndian.AppendUint32([]byte{101}, id)
+		b = binary.BigEndian.AppendUint32(b, code)
+		b = appendString(b, msg)
+		return appendString(b, "")
+	}
+	fail := func(err error) []byte {
+		switch {
+		case err == nil:
+			return status(0, "Success")
+		case errors.Is(err, fs.ErrNotExist):
+			return status(2, "No such file")
+		case errors.Is(err, fs.ErrPermission):
+			return status(3, "Permission denied")
+		}
+		return status(4, err.Error())
+	}
+	attrs := func(fi fs.FileInfo) []byte {
+		b := binary.BigEndian.AppendUint32([]byte{105}, id)
+		b = binary.BigEndian.AppendUint32(b, 0x01|0x04|0x08)
+		b = binary.BigEndian.AppendUint64(b, uint64(fi.Size()))
+		mode := uint32(fi.Mode().Perm())
+		if fi.IsDir() {
+			mode |= 0040000
+		} else {
+			mode |= 0100000
+		}
+		b = binary.BigEndian.AppendUint32(b, mode)
+		b = binary.BigEndian.AppendUint32(b, uint32(fi.ModTime().Unix()))
+		return binary.BigEndian.AppendUint32(b, uint32(fi.ModTime().Unix()))
+	}
+
+	switch typ {
+	case 3: // open
+		name, pflags := s.path(r.str()), r.u32()
+		flags := 0
+		switch {
+		case pflags&0x03 == 0x03:
+			flags = os.O_RDWR
+		case pflags&0x02 != 0:
+			flags = os.O_WRONLY
+		}
+		if pflags&0x08 != 0 {
+			flags |= os.O_CREATE
+		}
+		if pflags&0x10 != 0 {
+			flags |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(name, flags, 0644)
+		if err != nil {
+			return fail(err)
+		}
+		s.mu.Lock()
+		s.next++
+		handle := fmt.Sprint(s.next)
+		s.handles[handle] = f
+		s.mu.Unlock()
+		b := binary.BigEndian.AppendUint32([]byte{102}, id)
+		return appendString(b, handle)
+	case 4: // close
+		handle := r.str()
+		f, ok := s.file(handle)
+		if !ok {
+			return status(4, "bad handle")
+		}
+		s.mu.Lock()
+		delete(s.handles, handle)
+		s.mu.Unlock()
+		return fail(f.Close())
+	case 5: // read
+		f, ok := s.file(r.str())
+		off, n := r.u64(), r.u32()
+		if !ok {
+			return status(4, "bad handle")
+		}
+		if s.MaxRead > 0 {
+			n = min(n, uint32(s.MaxRead))
+		}
+		buf := make([]byte, n)
+		got, err := f.ReadAt(buf, int64(off))
+		if got == 0 && err == io.EOF {
+			return status(1, "EOF")
+		}
+		if got == 0 && err != nil {
+			return fail(err)
+		}
+		b := binary.BigEndian.AppendUint32([]byte{103}, id)
+		return appendString(b, string(buf[:got]))
+	case 6: // write
+		f, ok := s.file(r.str())
+		off, data := r.u64(), r.str()
+		if !ok {
+			return status(4, "bad handle")
+		}
+		_, err := f.WriteAt([]byte(data), int64(off))
+		return fail(err)
+	case 8: // fstat
+		f, ok := s.file(r.str())
+		if !ok {
+			return status(4, "bad handle")
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			return fail(err)
+		}
+		return attrs(fi)
+	case 7, 17: // lstat, stat
+		fi, err := os.Stat(s.path(r.str()))
+		if err != nil {
+			return fail(err)
+		}
+		return attrs(fi)
+	case 13: // remove
+		return fail(os.Remove(s.path(r.str())))
+	case 14: // mkdir
+		return fail(os.Mkdir(s.path(r.str()), 0755))
+	case 16: // realpath
+		name := "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+r.str())), "/")
+		b := binary.BigEndian.AppendUint32([]byte{104}, id)
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = appendString(b, name)
+		b = appendString(b, name)
+		return binary.BigEndian.AppendUint32(b, 0)
+	}
+	return status(8, "unsupported")
+}
+
+// path resolves name inside the root
+func (s *Server) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(filepath.Clean("/"+name)))
+}
+
+func (s *Server) file(handle string) (*os.File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.handles[handle]
+	return f, ok
+}
+
+// fields reads the fields of a request, returning zeros once it runs out
+type fields struct {
+	b []byte
+}
+
+func (r *fields) u32() uint32 {
+	if len(r.b) < 4 {
+		r.b = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *fields) u64() uint64 {
+	hi := r.u32()
+	return uint64(hi)<<32 | uint64(r.u32())
+}
+
+func (r *fields) str() string {
+	n := r.u32()
+	if uint64(len(r.b)) < uint64(n) {
+		r.b = nil
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/sftp"
+)
+
+// SFTP stores files on an SSH server, connecting with the ssh client for
+// each transfer. Get answers range and conditional requests itself, as
+// there is no HTTP server in front of the files.
+type SFTP struct {
+	cfg config.SFTP
+}
+
+// NewSFTP creates an SFTP backend
+func NewSFTP(cfg config.SFTP) (*SFTP, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("sftp host is required")
+	}
+	return &SFTP{cfg: cfg}, nil
+}
+
+func (s *SFTP) Name() string { return "sftp" }
+
+func (s *SFTP) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	name := s.path(key)
+	if err := c.MkdirAll(path.Dir(name)); err != nil {
+		return fmt.Errorf("PUT %s: %w", key, err)
+	}
+	f, err := c.Create(name)
+	if err != nil {
+		return fmt.Errorf("PUT %s: %w", key, err)
+	}
+	n, err := f.ReadFrom(r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("PUT %s: %w", key, err)
+	}
+	if n != size {
+		return fmt.Errorf("PUT %s: wrote %d of %d bytes", key, n, size)
+	}
+	return nil
+}
+
+func (s *SFTP) Get(ctx context.Context, key string, header http.Header) (*http.Response, error) {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Open(s.path(key))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("GET %s: %w", key, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		c.Close()
+		return nil, fmt.Errorf("GET %s: %w", key, err)
+	}
+
+	etag := fmt.Sprintf(`"%x-%x"`, fi.ModTime.Unix(), fi.Size)
+	h := http.Header{}
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", fi.ModTime.UTC().Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+
+	if notModified(header, etag, fi.ModTime) {
+		f.Close()
+		c.Close()
+		return response(http.StatusNotModified, h, 0, http.NoBody), nil
+	}
+
+	status, start, length := http.StatusOK, int64(0), fi.Size
+	if spec := header.Get("Range"); spec != "" && ifRange(header, etag, fi.ModTime) {
+		first, n, err := parseRange(spec, fi.Size)
+		switch {
+		case errors.Is(err, errUnsatisfiable):
+			f.Close()
+			c.Close()
+			return nil, fmt.Errorf("GET %s: range %s is past the end of the %d byte file", key, spec, fi.Size)
+		case err == nil:
+			status, start, length = http.StatusPartialContent, first, n
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, first+n-1, fi.Size))
+		}
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	body := &sftpBody{Reader: f.Reader(start, length), f: f, c: c}
+	return response(status, h, length, body), nil
+}
+
+func (s *SFTP) Delete(ctx context.Context, key string) error {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("DELETE %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *SFTP) PresignGet(key string, ttl time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+func (s *SFTP) dial(ctx context.Context) (*sftp.Client, error) {
+	c, err := sftp.Dial(ctx, sftp.Options{
+		Binary:         s.cfg.Binary,
+		Host:           s.cfg.Host,
+		User:           s.cfg.User,
+		IdentityFile:   s.cfg.IdentityFile,
+		KnownHostsFile: s.cfg.KnownHostsFile,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", s.cfg.Host, err)
+	}
+	return c, nil
+}
+
+// path is where key is kept on the server
+func (s *SFTP) path(key string) string {
+	return path.Join(s.cfg.Dir, key)
+}
+
+// sftpBody is the body of a Get, which ends the session when closed
+type sftpBody struct {
+	io.Reader
+	f *sftp.File
+	c *sftp.Client
+}
+
+func (b *sftpBody) Close() error {
+	b.f.Close()
+	return b.c.Close()
+}
+
+func response(status int, h http.Header, length int64, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		ContentLength: length,
+		Body:          body,
+	}
+}
+
+// notModified reports whether the conditional headers in header ask for
+// a 304 for a file with etag last modified at modTime
+func notModified(header http.Header, etag string, modTime time.Time) bool {
+	if match := header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(header.Get("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// ifRange reports whether a Range in header applies: without If-Range it
+// always does, otherwise only while the file is the version named
+func ifRange(header http.Header, etag string, modTime time.Time) bool {
+	cond := header.Get("If-Range")
+	if cond == "" || cond == etag {
+		return true
+	}
+	t, err := http.ParseTime(cond)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
+
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// parseRange reads a single "bytes=" range of a size byte file, returning
+// its start and length. Ranges it can't serve, such as several at once,
+// return an error other than errUnsatisfiable and the whole file is sent.
+func parseRange(spec string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(spec, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", spec)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", spec)
+	}
+
+	if first == "" {
+		// The last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", spec)
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errUnsatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", spec)
+	}
+	if start >= size {
+		return 0, 0, errUnsatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, fmt.Errorf("invalid range %q", spec)
+		}
+		end = min(e, end)
+	}
+	return start, end - start + 1, nil
+}
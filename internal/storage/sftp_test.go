@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/sftp/sftptest"
+)
+
+// TestMain runs the test binary as ssh when the SFTP tests start it: it
+// serves UTE_SFTP_ROOT on its standard input and output and records its
+// arguments in UTE_SFTP_ARGS
+func TestMain(m *testing.M) {
+	if root := os.Getenv("UTE_SFTP_ROOT"); root != "" {
+		os.WriteFile(os.Getenv("UTE_SFTP_ARGS"), []byte(strings.Join(os.Args[1:], " ")), 0644)
+		s := &sftptest.Server{Root: root, MaxRead: 10000}
+		if err := s.Serve(stdio{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type stdio struct{}
+
+func (stdio) Read(b []byte) (int, error)  { return os.Stdin.Read(b) }
+func (stdio) Write(b []byte) (int, error) { return os.Stdout.Write(b) }
+
+func TestSFTP(t *testing.T) {
+	root := t.TempDir()
+	args := filepath.Join(t.TempDir(), "args")
+	t.Setenv("UTE_SFTP_ROOT", root)
+	t.Setenv("UTE_SFTP_ARGS", args)
+	ctx := context.Background()
+
+	backend, err := NewSFTP(config.SFTP{
+		Host:   "files.example:2222",
+		User:   "ute",
+		Dir:    "/srv/videos",
+		Binary: os.Args[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Big enough to keep the whole window of writes and reads in flight
+	data := make([]byte, 1500000)
+	rand.Read(data)
+	if err := backend.Put(ctx, "clips/a b.mp4", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(root, "srv", "videos", "clips", "a b.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes that differ from the %d put", len(stored), len(data))
+	}
+	got, _ := os.ReadFile(args)
+	if want := "-o BatchMode=yes -p 2222 -l ute -s -- files.example sftp"; string(got) != want {
+		t.Errorf("ssh ran with %q, want %q", got, want)
+	}
+
+	get := func(header http.Header) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := backend.Get(ctx, "clips/a b.mp4", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	resp, body := get(nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("GET: %s with %d bytes, want 200 with the %d put", resp.Status, len(body), len(data))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Content-Type = %q, want video/mp4", ct)
+	}
+	if cl := resp.Header.Get("Content-Length"); cl != "1500000" {
+		t.Errorf("Content-Length = %q, want 1500000", cl)
+	}
+	etag := resp.Header.Get("ETag")
+
+	tests := []struct {
+		header       http.Header
+		status       int
+		first, last  int
+		contentRange string
+	}{
+		{http.Header{"Range": {"bytes=100-199"}}, 206, 100, 199, "bytes 100-199/1500000"},
+		{http.Header{"Range": {"bytes=1499990-"}}, 206, 1499990, 1499999, "bytes 1499990-1499999/1500000"},
+		{http.Header{"Range": {"bytes=-10"}}, 206, 1499990, 1499999, "bytes 1499990-1499999/1500000"},
+		{http.Header{"Range": {"bytes=0-0"}}, 206, 0, 0, "bytes 0-0/1500000"},
+		// Several ranges, or one for another version, get the whole file
+		{http.Header{"Range": {"bytes=0-1,5-6"}}, 200, 0, 1499999, ""},
+		{http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"other"`}}, 200, 0, 1499999, ""},
+		{http.Header{"Range": {"bytes=0-1"}, "If-Range": {etag}}, 206, 0, 1, "bytes 0-1/1500000"},
+	}
+	for _, tt := range tests {
+		resp, body := get(tt.header)
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET with %v: %s, want %d", tt.header, resp.Status, tt.status)
+			continue
+		}
+		if !bytes.Equal(body, data[tt.first:tt.last+1]) {
+			t.Errorf("GET with %v: got %d bytes, want bytes %d-%d", tt.header, len(body), tt.first, tt.last)
+		}
+		if cr := resp.Header.Get("Content-Range"); cr != tt.contentRange {
+			t.Errorf("GET with %v: Content-Range = %q, want %q", tt.header, cr, tt.contentRange)
+		}
+	}
+
+	if resp, _ := get(http.Header{"If-None-Match": {etag}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET with a matching If-None-Match: %s, want 304", resp.Status)
+	}
+	if _, err := backend.Get(ctx, "clips/a b.mp4", http.Header{"Range": {"bytes=1500000-"}}); err == nil {
+		t.Error("GET past the end succeeded")
+	}
+
+	if err := backend.Delete(ctx, "clips/a b.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "srv", "videos", "clips", "a b.mp4")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file still there after DELETE: %v", err)
+	}
+	if _, err := backend.Get(ctx, "clips/a b.mp4", nil); err == nil {
+		t.Error("GET of a deleted file succeeded")
+	}
+	if err := backend.Delete(ctx, "clips/a b.mp4"); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package storage moves completed downloads to remote backends such as
+// S3-compatible object stores, WebDAV servers and SSH servers over SFTP.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+// ErrPresignUnsupported is returned by backends that can't hand out
+// direct download URLs; callers should proxy through Get instead
+var ErrPresignUnsupported = errors.New("backend does not support presigned URLs")
+
+// Backend is a remote file store
+type Backend interface {
+	// Name identifies the backend in library records
+	Name() string
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get fetches key, forwarding the ForwardHeaders present in header.
+	// A 304 Not Modified response is returned rather than treated as an
+	// error.
+	Get(ctx context.Context, key string, header http.Header) (*http.Response, error)
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a time-limited URL clients can download from
+	PresignGet(key string, ttl time.Duration) (string, error)
+}
+
+// ForwardHeaders are the client request headers passed on by Get so range
+// and conditional requests are answered by the backend
+var ForwardHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// New creates the configured backend, or nil when files stay local
+func New(cfg config.Storage) (Backend, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return nil, nil
+	case "s3":
+		return NewS3(cfg.S3)
+	case "webdav":
+		return NewWebDAV(cfg.WebDAV)
+	case "sftp":
+		return NewSFTP(cfg.SFTP)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q, expected local, s3, webdav or sftp", cfg.Backend)
+}
+
+// statusError turns a non-2xx response into an error, closing its body
+func statusError(op, key string, resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s: %s", op, key, resp.Status, body)
+}
+
+func ok(resp *http.Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// forward copies the ForwardHeaders set in from onto req
+func forward(req *http.Request, from http.Header) {
+	for _, h := range ForwardHeaders {
+		if value := from.Get(h); value != "" {
+			req.Header.Set(h, value)
+		}
+	}
+}
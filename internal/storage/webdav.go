@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+// WebDAV stores files on a WebDAV server (Nextcloud, Apache mod_dav, rclone serve)
+type WebDAV struct {
+	base   *url.URL
+	cfg    config.WebDAV
+	client *http.Client
+}
+
+// NewWebDAV creates a WebDAV backend rooted at cfg.URL
+func NewWebDAV(cfg config.WebDAV) (*WebDAV, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid webdav url %q", cfg.URL)
+	}
+	return &WebDAV{base: base, cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (d *WebDAV) Name() string { return "webdav" }
+
+func (d *WebDAV) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := d.mkdirs(ctx, key); err != nil {
+		return err
+	}
+
+	req, err := d.request(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if !ok(resp) {
+		return statusError("PUT", key, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (d *WebDAV) Get(ctx context.Context, key string, header http.Header) (*http.Response, error) {
+	req, err := d.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	forward(req, header)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !ok(resp) && resp.StatusCode != http.StatusNotModified {
+		return nil, statusError("GET", key, resp)
+	}
+	return resp, nil
+}
+
+func (d *WebDAV) Delete(ctx context.Context, key string) error {
+	req, err := d.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if !ok(resp) && resp.StatusCode != http.StatusNotFound {
+		return statusError("DELETE", key, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (d *WebDAV) PresignGet(key string, ttl time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// mkdirs creates the collections above key; existing ones answer 405
+func (d *WebDAV) mkdirs(ctx context.Context, key string) error {
+	parts := strings.Split(key, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/") + "/"
+		req, err := d.request(ctx, "MKCOL", dir, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if !ok(resp) && resp.StatusCode != http.StatusMethodNotAllowed {
+			return statusError("MKCOL", dir, resp)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (d *WebDAV) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u := *d.base
+	u.Path = d.base.Path + "/" + key
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if d.cfg.Username != "" {
+		req.SetBasicAuth(d.cfg.Username, d.cfg.Password)
+	}
+	return req, nil
+}
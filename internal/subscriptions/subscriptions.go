@@ -0,0 +1,221 @@
+// Package subscriptions keeps the channels and playlists that are polled
+// for new uploads, persisted in the data directory.
+package subscriptions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/downloader"
+)
+
+// Subscription is a channel or playlist URL whose new videos are downloaded
+type Subscription struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Folder is the subfolder of the videos directory to download into
+	Folder      string     `json:"folder,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	// LastJob is the download job queued by the latest check
+	LastJob string `json:"last_job,omitempty"`
+	// BackfillJob is the latest job fetching the existing uploads
+	BackfillJob string `json:"backfill_job,omitempty"`
+	// Languages limits downloads to uploads in these ISO 639-1 languages;
+	// empty downloads every upload
+	Languages []string `json:"languages,omitempty"`
+	// Filter skips uploads it doesn't match
+	Filter *downloader.Filter `json:"filter,omitempty"`
+	// OutputTemplate names its downloads inside Folder, in place of
+	// ytdlp.output_template
+	OutputTemplate string `json:"output_template,omitempty"`
+	// Format is the yt-dlp format selector its downloads use, in place of
+	// the default format
+	Format string `json:"format,omitempty"`
+	// Profile is the post-processing profile its downloads use
+	Profile string `json:"profile,omitempty"`
+	// KeepLatest deletes its oldest videos beyond this many; 0 keeps them
+	// all
+	KeepLatest int `json:"keep_latest,omitempty"`
+}
+
+func (s *Subscription) clone() Subscription {
+	c := *s
+	c.Languages = append([]string(nil), s.Languages...)
+	if s.Filter != nil {
+		f := *s.Filter
+		c.Filter = &f
+	}
+	if s.LastChecked != nil {
+		t := *s.LastChecked
+		c.LastChecked = &t
+	}
+	return c
+}
+
+// Store holds subscriptions in memory and in subscriptions.json
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+}
+
+// NewStore creates a store persisting to dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{
+		path: filepath.Join(dataDir, "subscriptions.json"),
+		subs: make(map[string]*Subscription),
+	}
+}
+
+// Load reads the persisted subscriptions, if any
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var list []*Subscription
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range list {
+		s.subs[sub.ID] = sub
+	}
+	return nil
+}
+
+// List returns every subscription, by name
+func (s *Store) List() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		list = append(list, sub.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns the subscription with id
+func (s *Store) Get(id string) (Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.subs[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return sub.clone(), true
+}
+
+// FindByURL returns the subscription to url
+func (s *Store) FindByURL(url string) (Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.URL == url {
+			return sub.clone(), true
+		}
+	}
+	return Subscription{}, false
+}
+
+// Add subscribes to url. Subscribing to a URL twice returns the existing
+// subscription with created set to false.
+func (s *Store) Add(name, url, folder string) (sub Subscription, created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.subs {
+		if existing.URL == url {
+			return existing.clone(), false, nil
+		}
+	}
+
+	added := &Subscription{
+		ID:        newID(),
+		Name:      name,
+		URL:       url,
+		Folder:    folder,
+		CreatedAt: time.Now(),
+	}
+	s.subs[added.ID] = added
+	if err := s.save(); err != nil {
+		delete(s.subs, added.ID)
+		return Subscription{}, false, err
+	}
+	return added.clone(), true, nil
+}
+
+// Remove deletes the subscription with id. Videos already downloaded are
+// kept.
+func (s *Store) Remove(id string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.subs[id]
+	if !ok {
+		return Subscription{}, fmt.Errorf("subscription %s not found", id)
+	}
+	delete(s.subs, id)
+	return sub.clone(), s.save()
+}
+
+// Update applies fn to the subscription with id and persists the store
+func (s *Store) Update(id string, fn func(*Subscription)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.subs[id]
+	if !ok {
+		return fmt.Errorf("subscription %s not found", id)
+	}
+	fn(sub)
+	return s.save()
+}
+
+// save writes every subscription to disk, replacing the file atomically.
+// The caller holds mu.
+func (s *Store) save() error {
+	list := make([]*Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		list = append(list, sub)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
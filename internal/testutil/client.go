@@ -0,0 +1,143 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"noahjalex.ute/internal/jobs"
+)
+
+// Response is a finished request to the server
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// JSON decodes the body into v, failing the test when it can't
+func (r *Response) JSON(t testing.TB, v any) {
+	t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("decoding %q: %v", r.Body, err)
+	}
+}
+
+// Video is an entry of GET /api/videos
+type Video struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+}
+
+// Do sends a request to path, failing the test when it can't be sent.
+// body is sent as is when it's a string or []byte, and as JSON otherwise.
+func (s *Server) Do(method, path string, body any, header http.Header) *Response {
+	s.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("encoding request body: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.URL+path, r)
+	if err != nil {
+		s.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.Token != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("%s %s: reading response: %v", method, path, err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}
+}
+
+// Get sends a GET request to path
+func (s *Server) Get(path string) *Response {
+	s.t.Helper()
+	return s.Do("GET", path, nil, nil)
+}
+
+// Submit queues a download of link, failing the test unless the server
+// accepts it, and returns the job's ID
+func (s *Server) Submit(link string) string {
+	s.t.Helper()
+	resp := s.Do("POST", "/", map[string]string{"link": link}, nil)
+	if resp.StatusCode >= 300 {
+		s.t.Fatalf("submitting %s: %d %s", link, resp.StatusCode, resp.Body)
+	}
+	var out struct {
+		JobID string `json:"job_id"`
+	}
+	resp.JSON(s.t, &out)
+	return out.JobID
+}
+
+// Job fetches a job, failing the test when it can't
+func (s *Server) Job(id string) jobs.Job {
+	s.t.Helper()
+	resp := s.Get("/api/jobs/" + id)
+	if resp.StatusCode != http.StatusOK {
+		s.t.Fatalf("fetching job %s: %d %s", id, resp.StatusCode, resp.Body)
+	}
+	var job jobs.Job
+	resp.JSON(s.t, &job)
+	return job
+}
+
+// WaitJob polls a job until it reaches one of states, failing the test
+// if it doesn't within timeout
+func (s *Server) WaitJob(id string, timeout time.Duration, states ...jobs.State) jobs.Job {
+	s.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		job := s.Job(id)
+		if slices.Contains(states, job.State) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("job %s is %s after %v, expected one of %v", id, job.State, timeout, states)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Videos lists the library, failing the test when it can't
+func (s *Server) Videos() []Video {
+	s.t.Helper()
+	resp := s.Get("/api/videos")
+	if resp.StatusCode != http.StatusOK {
+		s.t.Fatalf("listing videos: %d %s", resp.StatusCode, resp.Body)
+	}
+	var videos []Video
+	resp.JSON(s.t, &videos)
+	return videos
+}
@@ -0,0 +1,93 @@
+// Package testutil serves the server for integration tests: over a real
+// HTTP listener from httptest, with its library and state in a temporary
+// directory and the fake downloader in place of the real backends, so
+// tests need neither network access nor yt-dlp.
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+// Options adjust the server a test starts
+type Options struct {
+	// Config is merged over the harness's config by top-level key, e.g.
+	// {"queue": {"workers": 1}}
+	Config map[string]any
+	// Token is sent with every request, for servers configured with users
+	Token string
+}
+
+// Server is a running server and the directories it owns
+type Server struct {
+	// URL is the server's base address, without a trailing slash
+	URL       string
+	Dir       string
+	VideosDir string
+	DataDir   string
+	Token     string
+	Client    *http.Client
+
+	t testing.TB
+}
+
+// Start writes and loads the harness's config, then serves the handler
+// newHandler builds from it until the test ends. Cleanups newHandler
+// registers run after the listener has closed.
+func Start(t testing.TB, opts Options, newHandler func(cfg config.Config, configPath string) http.Handler) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	s := &Server{
+		Dir:       dir,
+		VideosDir: filepath.Join(dir, "videos"),
+		DataDir:   filepath.Join(dir, "data"),
+		Token:     opts.Token,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		t:         t,
+	}
+	cfg := map[string]any{
+		"videos_dir": s.VideosDir,
+		"data_dir":   s.DataDir,
+		"fake_downloader": map[string]any{
+			"enabled":  true,
+			"duration": "200ms",
+			"size":     64 << 10,
+		},
+		// Retries come quickly, so tests of them don't wait
+		"retry": map[string]any{
+			"max_attempts": 3,
+			"base_delay":   "100ms",
+			"max_delay":    "500ms",
+		},
+	}
+	for k, v := range opts.Config {
+		cfg[k] = v
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		t.Fatalf("encoding config: %v", err)
+	}
+	if err := os.MkdirAll(s.VideosDir, 0755); err != nil {
+		t.Fatalf("creating the videos directory: %v", err)
+	}
+	cfgPath := filepath.Join(dir, "ute.json")
+	if err := os.WriteFile(cfgPath, data, 0644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	loaded, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+
+	ts := httptest.NewServer(newHandler(loaded, cfgPath))
+	t.Cleanup(ts.Close)
+	s.URL = ts.URL
+	return s
+}
@@ -0,0 +1,248 @@
+// Package throughput measures download speeds. Backends report each
+// job's current speed as it downloads; the tracker turns those reports
+// into bytes moved per second, per job and for the whole server, and
+// keeps a history of the server's total for graphs.
+package throughput
+
+import (
+	"sync"
+	"time"
+)
+
+// maxGap bounds how long one report's speed is assumed to have lasted, so
+// a stalled download that reports again doesn't claim the whole pause
+const maxGap = 5 * time.Second
+
+// jobWindow is how many seconds each job keeps, enough for its longest
+// window
+const jobWindow = 300
+
+// Rate describes a download speed in bytes per second
+type Rate struct {
+	// Current is the latest reported speed, summed over running jobs for
+	// the server
+	Current int64 `json:"current"`
+	// Average is over the time spent downloading: since a job started, or
+	// across the seconds the server had anything running
+	Average int64 `json:"average"`
+	// Last10s, Last1m and Last5m average over sliding windows
+	Last10s int64 `json:"last_10s"`
+	Last1m  int64 `json:"last_1m"`
+	Last5m  int64 `json:"last_5m"`
+	// Bytes is the total moved
+	Bytes int64 `json:"bytes"`
+}
+
+// Sample is the average speed over one step of a history
+type Sample struct {
+	Time time.Time `json:"time"`
+	// Speed is in bytes per second
+	Speed int64 `json:"speed"`
+}
+
+// ring counts bytes in one-second buckets, the newest at head
+type ring struct {
+	buckets []int64
+	// head is the Unix second of the newest bucket
+	head int64
+}
+
+func newRing(seconds int) ring {
+	return ring{buckets: make([]int64, seconds)}
+}
+
+// advance moves the ring forward to second now, zeroing skipped buckets
+func (r *ring) advance(now int64) {
+	if r.head == 0 || now-r.head >= int64(len(r.buckets)) {
+		clear(r.buckets)
+		r.head = now
+		return
+	}
+	for ; r.head < now; r.head++ {
+		r.buckets[(r.head+1)%int64(len(r.buckets))] = 0
+	}
+}
+
+// add counts n bytes in second now
+func (r *ring) add(now, n int64) {
+	r.advance(now)
+	r.buckets[now%int64(len(r.buckets))] += n
+}
+
+// sum is the bytes in the last seconds buckets up to now
+func (r *ring) sum(now int64, seconds int) int64 {
+	r.advance(now)
+	if seconds > len(r.buckets) {
+		seconds = len(r.buckets)
+	}
+	var total int64
+	for i := 0; i < seconds; i++ {
+		total += r.buckets[(now-int64(i))%int64(len(r.buckets))]
+	}
+	return total
+}
+
+// rate averages the last seconds buckets up to now
+func (r *ring) rate(now int64, seconds int) int64 {
+	return r.sum(now, seconds) / int64(seconds)
+}
+
+type job struct {
+	started time.Time
+	last    time.Time
+	speed   int64
+	bytes   int64
+	window  ring
+}
+
+// Tracker follows running downloads. It is safe for concurrent use.
+type Tracker struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+
+	history ring
+	bytes   int64
+	// busy is how long anything has been downloading, as of lastReport
+	busy       time.Duration
+	lastReport time.Time
+}
+
+// New creates a tracker keeping the server's total for history
+func New(history time.Duration) *Tracker {
+	seconds := int(history / time.Second)
+	if seconds < jobWindow {
+		seconds = jobWindow
+	}
+	return &Tracker{
+		jobs:    make(map[string]*job),
+		history: newRing(seconds),
+	}
+}
+
+// Report records that the job with id is downloading at speed bytes per
+// second, returning its rate. The previous speed is taken to have held
+// since the previous report.
+func (t *Tracker) Report(id string, speed int64) Rate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if len(t.jobs) > 0 {
+		t.busy += min(now.Sub(t.lastReport), maxGap)
+	}
+	t.lastReport = now
+
+	j, ok := t.jobs[id]
+	if !ok {
+		j = &job{started: now, last: now, window: newRing(jobWindow)}
+		t.jobs[id] = j
+	}
+
+	gap := min(now.Sub(j.last), maxGap)
+	if n := int64(float64(j.speed) * gap.Seconds()); n > 0 {
+		sec := now.Unix()
+		j.bytes += n
+		j.window.add(sec, n)
+		t.bytes += n
+		t.history.add(sec, n)
+	}
+	j.last, j.speed = now, speed
+	return j.rate(now)
+}
+
+// Finish stops following the job with id, returning its final rate
+func (t *Tracker) Finish(id string) (Rate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	j, ok := t.jobs[id]
+	if !ok {
+		return Rate{}, false
+	}
+	delete(t.jobs, id)
+	r := j.rate(time.Now())
+	r.Current = 0
+	return r, true
+}
+
+// Job returns the rate of the running job with id
+func (t *Tracker) Job(id string) (Rate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	j, ok := t.jobs[id]
+	if !ok {
+		return Rate{}, false
+	}
+	return j.rate(time.Now()), true
+}
+
+// Total returns the server's rate across every download
+func (t *Tracker) Total() Rate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sec := time.Now().Unix()
+	r := Rate{
+		Last10s: t.history.rate(sec, 10),
+		Last1m:  t.history.rate(sec, 60),
+		Last5m:  t.history.rate(sec, 300),
+		Bytes:   t.bytes,
+	}
+	for _, j := range t.jobs {
+		r.Current += j.speed
+	}
+	if t.busy >= time.Second {
+		r.Average = int64(float64(t.bytes) / t.busy.Seconds())
+	}
+	return r
+}
+
+// History returns the server's average speed over each step of the last
+// window, oldest first. The window is cut to the history kept.
+func (t *Tracker) History(window, step time.Duration) []Sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stepSecs := int64(step / time.Second)
+	if stepSecs < 1 {
+		stepSecs = 1
+	}
+	secs := int64(window / time.Second)
+	if max := int64(len(t.history.buckets)); secs > max {
+		secs = max
+	}
+	steps := secs / stepSecs
+	now := time.Now().Unix()
+	t.history.advance(now)
+
+	samples := make([]Sample, 0, steps)
+	for i := steps - 1; i >= 0; i-- {
+		end := now - i*stepSecs
+		var total int64
+		for sec := end - stepSecs + 1; sec <= end; sec++ {
+			total += t.history.buckets[sec%int64(len(t.history.buckets))]
+		}
+		samples = append(samples, Sample{
+			Time:  time.Unix(end-stepSecs+1, 0),
+			Speed: total / stepSecs,
+		})
+	}
+	return samples
+}
+
+// rate describes j at now
+func (j *job) rate(now time.Time) Rate {
+	sec := now.Unix()
+	r := Rate{
+		Current: j.speed,
+		Last10s: j.window.rate(sec, 10),
+		Last1m:  j.window.rate(sec, 60),
+		Last5m:  j.window.rate(sec, 300),
+		Bytes:   j.bytes,
+	}
+	if elapsed := now.Sub(j.started).Seconds(); elapsed >= 1 {
+		r.Average = int64(float64(j.bytes) / elapsed)
+	}
+	return r
+}
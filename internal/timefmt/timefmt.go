@@ -0,0 +1,142 @@
+// Package timefmt formats times for people and for the API in the time
+// zone and date layout the server is configured with.
+package timefmt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	// Zones load without the system's database, as in slim containers
+	_ "time/tzdata"
+)
+
+// layout is how a locale writes dates and times of day
+type layout struct {
+	date string
+	time string
+	// short is a day without the year
+	short string
+}
+
+// iso is ISO 8601, used when no locale is configured
+var iso = layout{date: "2006-01-02", time: "15:04", short: "01-02"}
+
+// layouts are the locales dates can be shown in, by BCP 47 tag. A tag
+// that isn't listed falls back to its language, so "de-AT" uses "de".
+var layouts = map[string]layout{
+	"en-us": {date: "Jan 2, 2006", time: "3:04 PM", short: "Jan 2"},
+	"en":    {date: "2 Jan 2006", time: "15:04", short: "2 Jan"},
+	"de":    {date: "02.01.2006", time: "15:04", short: "02.01."},
+	"fr":    {date: "02/01/2006", time: "15:04", short: "02/01"},
+	"es":    {date: "02/01/2006", time: "15:04", short: "02/01"},
+	"it":    {date: "02/01/2006", time: "15:04", short: "02/01"},
+	"pt":    {date: "02/01/2006", time: "15:04", short: "02/01"},
+	"nl":    {date: "02-01-2006", time: "15:04", short: "02-01"},
+	"pl":    {date: "02.01.2006", time: "15:04", short: "02.01"},
+	"ru":    {date: "02.01.2006", time: "15:04", short: "02.01"},
+	"sv":    {date: "2006-01-02", time: "15:04", short: "01-02"},
+	"ja":    {date: "2006/01/02", time: "15:04", short: "01/02"},
+	"zh":    {date: "2006/01/02", time: "15:04", short: "01/02"},
+	"ko":    {date: "2006. 01. 02.", time: "15:04", short: "01. 02."},
+}
+
+// Locales lists the locale tags Formatter accepts, sorted
+func Locales() []string {
+	tags := make([]string, 0, len(layouts))
+	for tag := range layouts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// findLayout returns the layout for a locale tag, "" for ISO 8601
+func findLayout(locale string) (layout, bool) {
+	tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if tag == "" {
+		return iso, true
+	}
+	if l, ok := layouts[tag]; ok {
+		return l, true
+	}
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		l, ok := layouts[tag[:i]]
+		return l, ok
+	}
+	return layout{}, false
+}
+
+// Formatter writes times in one zone and locale
+type Formatter struct {
+	loc    *time.Location
+	layout layout
+}
+
+// New returns a formatter for the IANA zone timezone, such as
+// "Europe/Berlin" (the server's own when empty), and locale, a tag such as
+// "en-US" or "de" (ISO 8601 when empty)
+func New(timezone, locale string) (*Formatter, error) {
+	loc := time.Local
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q: %w", timezone, err)
+		}
+	}
+	l, ok := findLayout(locale)
+	if !ok {
+		return nil, fmt.Errorf("unknown locale %q, expected one of %s", locale, strings.Join(Locales(), ", "))
+	}
+	return &Formatter{loc: loc, layout: l}, nil
+}
+
+// Default formats in the server's zone as ISO 8601
+func Default() *Formatter {
+	return &Formatter{loc: time.Local, layout: iso}
+}
+
+// Location is the zone times are shown in
+func (f *Formatter) Location() *time.Location {
+	return f.loc
+}
+
+// Date writes the day of t, e.g. "2024-01-31" or "31.01.2024"
+func (f *Formatter) Date(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(f.loc).Format(f.layout.date)
+}
+
+// ShortDate writes the day of t without the year
+func (f *Formatter) ShortDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(f.loc).Format(f.layout.short)
+}
+
+// Clock writes the time of day of t
+func (f *Formatter) Clock(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(f.loc).Format(f.layout.time)
+}
+
+// DateTime writes the day and time of day of t
+func (f *Formatter) DateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return f.Date(t) + " " + f.Clock(t)
+}
+
+// API writes t as ISO 8601 (RFC 3339) with the zone's offset, for JSON
+func (f *Formatter) API(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(f.loc).Format(time.RFC3339)
+}
@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// exportInterval is how often finished spans are sent, sooner once
+	// batchSize are waiting
+	exportInterval = 5 * time.Second
+	batchSize      = 512
+	// maxPending bounds the spans held while the collector is unreachable;
+	// the oldest are dropped beyond it
+	maxPending = 4096
+	// exportTimeout bounds each export
+	exportTimeout = 10 * time.Second
+)
+
+// exporter batches finished spans and posts them to an OTLP/HTTP
+// collector
+type exporter struct {
+	url     string
+	headers map[string]string
+	service string
+	client  *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+	dropped int
+	wake    chan struct{}
+}
+
+func newExporter(url string, headers map[string]string, service string) *exporter {
+	return &exporter{
+		url:     url,
+		headers: headers,
+		service: service,
+		client:  &http.Client{Timeout: exportTimeout},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (e *exporter) add(s *Span) {
+	e.mu.Lock()
+	if len(e.pending) >= maxPending {
+		e.pending = e.pending[1:]
+		e.dropped++
+	}
+	e.pending = append(e.pending, s)
+	full := len(e.pending) >= batchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run(ctx context.Context) {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+			e.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-e.wake:
+		}
+		e.flush(ctx)
+	}
+}
+
+// flush exports the waiting spans a batch at a time. A batch the
+// collector refuses is dropped rather than retried.
+func (e *exporter) flush(ctx context.Context) {
+	for {
+		e.mu.Lock()
+		n := min(len(e.pending), batchSize)
+		batch := e.pending[:n:n]
+		e.pending = e.pending[n:]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+
+		if dropped > 0 {
+			log.Printf("Dropped %d trace spans while the collector was unreachable", dropped)
+		}
+		if n == 0 {
+			return
+		}
+		if err := e.export(ctx, batch); err != nil {
+			log.Printf("Failed to export %d trace spans: %v", n, err)
+			return
+		}
+	}
+}
+
+func (e *exporter) export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.payload(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+// payload is an OTLP ExportTraceServiceRequest in its JSON encoding
+func (e *exporter) payload(spans []*Span) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              int(s.kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.failed {
+			span["status"] = map[string]any{"code": 2, "message": s.message}
+		}
+		s.mu.Unlock()
+		out = append(out, span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": attributes([]attribute{{"service.name", e.service}}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "noahjalex.ute"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+// attributes encodes key-values as OTLP AnyValues
+func attributes(attrs []attribute) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": a.key, "value": value})
+	}
+	return out
+}
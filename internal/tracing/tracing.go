@@ -0,0 +1,234 @@
+// Package tracing records OpenTelemetry spans and exports them to an OTLP
+// collector as JSON over HTTP. The current span travels in the context, so
+// code anywhere below a traced request or job adds spans of its own with
+// Start without being handed the tracer.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+// Kind says what a span stands for, as OTLP numbers them
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// Tracer starts spans and exports the finished ones. A nil Tracer records
+// nothing.
+type Tracer struct {
+	exporter *exporter
+	ratio    float64
+}
+
+// New creates a tracer exporting to cfg.Endpoint, or returns nil when no
+// endpoint is configured
+func New(cfg config.Tracing) *Tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	return &Tracer{
+		exporter: newExporter(cfg.Endpoint+"/v1/traces", cfg.Headers, cfg.ServiceName),
+		ratio:    cfg.SampleRatio,
+	}
+}
+
+// Run exports finished spans in batches until ctx is done
+func (t *Tracer) Run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.exporter.run(ctx)
+}
+
+// Span is an operation being timed. A nil Span ignores every call, so
+// callers needn't check whether tracing is on.
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	sampled bool
+	name    string
+	kind    Kind
+
+	mu      sync.Mutex
+	start   time.Time
+	end     time.Time
+	attrs   []attribute
+	failed  bool
+	message string
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// remoteParent is a span context received from a caller
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start begins a span as a child of the span in ctx, or of the caller's
+// span attached with WithTraceparent, or else as the root of a new trace,
+// returning a context carrying it
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	rand.Read(s.spanID[:])
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID, s.parent, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		s.traceID, s.parent, s.sampled = remote.traceID, remote.spanID, remote.sampled
+	} else {
+		rand.Read(s.traceID[:])
+		s.sampled = t.ratio >= 1 || mathrand.Float64() < t.ratio
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start begins a child of the span in ctx with the same tracer, doing
+// nothing when ctx has no span
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent, _ := ctx.Value(spanKey{}).(*Span)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, KindInternal)
+}
+
+// SetStart moves the span's start back to when the operation really began,
+// such as when a job was queued
+func (s *Span) SetStart(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.start = t
+	s.mu.Unlock()
+}
+
+// SetAttr records a string, bool, integer or float value on the span
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute{key, value})
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed with err's message. A nil err does
+// nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Fail(err.Error())
+}
+
+// Fail marks the span failed with message
+func (s *Span) Fail(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.message = true, message
+	s.mu.Unlock()
+}
+
+// End finishes the span, queueing it for export if its trace is sampled.
+// Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sampled {
+		s.tracer.exporter.add(s)
+	}
+}
+
+// Traceparent is the W3C traceparent header value of the span in ctx, or
+// of the caller's span attached with WithTraceparent, empty when there's
+// neither
+func Traceparent(ctx context.Context) string {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok && s != nil {
+		return formatTraceparent(s.traceID, s.spanID, s.sampled)
+	}
+	if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		return formatTraceparent(remote.traceID, remote.spanID, remote.sampled)
+	}
+	return ""
+}
+
+// WithTraceparent attaches the span a W3C traceparent header value names,
+// so spans started from the returned context continue its trace. Invalid
+// values are ignored.
+func WithTraceparent(ctx context.Context, header string) context.Context {
+	remote, err := parseTraceparent(header)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, remote)
+}
+
+func formatTraceparent(traceID [16]byte, spanID [8]byte, sampled bool) string {
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(traceID[:]) + "-" + hex.EncodeToString(spanID[:]) + "-" + flags
+}
+
+// parseTraceparent reads "version-traceid-spanid-flags"
+func parseTraceparent(header string) (remoteParent, error) {
+	var remote remoteParent
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return remote, fmt.Errorf("malformed traceparent %q", header)
+	}
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil {
+		return remote, err
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil {
+		return remote, err
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return remote, err
+	}
+	if remote.traceID == [16]byte{} || remote.spanID == [8]byte{} {
+		return remote, fmt.Errorf("traceparent %q has a zero ID", header)
+	}
+	remote.sampled = flags[0]&1 == 1
+	return remote, nil
+}
@@ -0,0 +1,93 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// API calls an OpenAI-compatible /audio/transcriptions endpoint, such as
+// OpenAI itself or a local faster-whisper or LocalAI server
+type API struct {
+	// URL is the base URL up to and including /v1
+	URL    string
+	APIKey string
+	Model  string
+	// Language is the spoken language's code; empty detects it
+	Language string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+func (a *API) Name() string { return "openai" }
+
+// AudioExt is MP3, which keeps uploads under the API's size limit
+func (a *API) AudioExt() string { return ".mp3" }
+
+func (a *API) Transcribe(ctx context.Context, audio string) ([]byte, error) {
+	f, err := os.Open(audio)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Build the form in memory; 16 kHz mono MP3 is about 14 MB an hour
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{"model": a.Model, "response_format": "vtt"}
+	if a.Language != "" {
+		fields["language"] = a.Language
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	part, err := form.CreateFormFile("file", filepath.Base(audio))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(a.URL, "/") + "/audio/transcriptions"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if a.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.APIKey)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 1024 {
+			data = data[:1024]
+		}
+		return nil, fmt.Errorf("transcription API: %s: %s", resp.Status, data)
+	}
+	return data, nil
+}
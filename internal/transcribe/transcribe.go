@@ -0,0 +1,101 @@
+// Package transcribe turns the speech in a video into WebVTT subtitles with
+// Whisper, either a local whisper.cpp build or an OpenAI-compatible API.
+package transcribe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"noahjalex.ute/internal/config"
+	"noahjalex.ute/internal/media"
+)
+
+// Suffix is appended to a video's name stem for its transcript sidecar, so
+// the subtitles move and are deleted together with the video
+const Suffix = ".transcript.vtt"
+
+// Backend recognises speech in an audio file
+type Backend interface {
+	// Name identifies the backend in logs
+	Name() string
+	// AudioExt is the audio format the backend wants, ".wav" or ".mp3"
+	AudioExt() string
+	// Transcribe returns the speech in audio as a WebVTT document
+	Transcribe(ctx context.Context, audio string) ([]byte, error)
+}
+
+// New creates the configured backend, or nil when transcription is off
+func New(cfg config.Transcription) (Backend, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "whisper.cpp":
+		return &WhisperCpp{Binary: cfg.Binary, Model: cfg.Model, Language: cfg.Language}, nil
+	case "openai":
+		return &API{URL: cfg.URL, APIKey: cfg.APIKey, Model: cfg.Model, Language: cfg.Language}, nil
+	}
+	return nil, fmt.Errorf("unknown transcription backend %q", cfg.Backend)
+}
+
+// Video transcribes the video at path, returning its subtitles. The audio
+// is extracted into a temporary folder under tmpDir first.
+func Video(ctx context.Context, b Backend, path, tmpDir string) ([]byte, error) {
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(tmpDir, "transcribe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	audio := filepath.Join(dir, "audio"+b.AudioExt())
+	if err := media.ExtractAudio(ctx, path, audio); err != nil {
+		return nil, err
+	}
+	return b.Transcribe(ctx, audio)
+}
+
+// Text strips the header, cue timings and markup from a WebVTT document,
+// leaving the spoken words one cue per line
+func Text(vtt []byte) string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(vtt))
+	inCue := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			inCue = false
+		case strings.Contains(line, "-->"):
+			inCue = true
+		case inCue:
+			if text := stripTags(line); text != "" {
+				lines = append(lines, text)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stripTags removes <v Speaker>, <i> and similar cue markup
+func stripTags(s string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch {
+		case r == '<':
+			depth++
+		case r == '>' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,48 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// WhisperCpp runs a local whisper.cpp build
+type WhisperCpp struct {
+	// Binary is the whisper.cpp command line program, e.g. whisper-cli
+	Binary string
+	// Model is the ggml model file
+	Model string
+	// Language is the spoken language's code; empty detects it
+	Language string
+}
+
+func (w *WhisperCpp) Name() string { return "whisper.cpp" }
+
+// AudioExt is WAV, the only input whisper.cpp reads without extra builds
+func (w *WhisperCpp) AudioExt() string { return ".wav" }
+
+func (w *WhisperCpp) Transcribe(ctx context.Context, audio string) ([]byte, error) {
+	lang := w.Language
+	if lang == "" {
+		lang = "auto"
+	}
+	// -of names the output without its extension; -ovtt adds .vtt
+	out := strings.TrimSuffix(audio, w.AudioExt())
+	cmd := exec.CommandContext(ctx, w.Binary,
+		"-m", w.Model,
+		"-f", audio,
+		"-l", lang,
+		"-ovtt",
+		"-of", out,
+		"-np",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", w.Binary, err, strings.TrimSpace(stderr.String()))
+	}
+	return os.ReadFile(out + ".vtt")
+}
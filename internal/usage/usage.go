@@ -0,0 +1,143 @@
+// Package usage counts the bytes each user downloads per day, persisted in
+// the data directory so daily quotas survive restarts.
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keepDays is how many days of history are kept per user
+const keepDays = 31
+
+// dayFormat keys the per-day counts
+const dayFormat = "2006-01-02"
+
+// Day is the bytes a user downloaded on one UTC day
+type Day struct {
+	Date  string `json:"date"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Store holds per-user daily byte counts in memory and in usage.json
+type Store struct {
+	path string
+
+	mu sync.Mutex
+	// days maps user to date to bytes
+	days map[string]map[string]int64
+}
+
+// NewStore creates a store persisting to dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{
+		path: filepath.Join(dataDir, "usage.json"),
+		days: make(map[string]map[string]int64),
+	}
+}
+
+// Load reads the persisted counts, if any
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved map[string][]Day
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, days := range saved {
+		s.days[user] = make(map[string]int64, len(days))
+		for _, d := range days {
+			s.days[user][d.Date] = d.Bytes
+		}
+	}
+	return nil
+}
+
+// Add counts bytes downloaded by user now
+func (s *Store) Add(user string, bytes int64) error {
+	if user == "" || bytes <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.days[user] == nil {
+		s.days[user] = make(map[string]int64)
+	}
+	s.days[user][today()] += bytes
+	s.prune()
+	return s.save()
+}
+
+// Today returns the bytes user has downloaded so far today
+func (s *Store) Today(user string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.days[user][today()]
+}
+
+// History returns user's daily counts, oldest first
+func (s *Store) History(user string) []Day {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days := make([]Day, 0, len(s.days[user]))
+	for date, bytes := range s.days[user] {
+		days = append(days, Day{Date: date, Bytes: bytes})
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+func today() string {
+	return time.Now().UTC().Format(dayFormat)
+}
+
+// prune drops days older than keepDays. The caller holds s.mu.
+func (s *Store) prune() {
+	cutoff := time.Now().UTC().AddDate(0, 0, -keepDays).Format(dayFormat)
+	for _, days := range s.days {
+		for date := range days {
+			if date < cutoff {
+				delete(days, date)
+			}
+		}
+	}
+}
+
+// save writes the counts. The caller holds s.mu.
+func (s *Store) save() error {
+	saved := make(map[string][]Day, len(s.days))
+	for user, days := range s.days {
+		for date, bytes := range days {
+			saved[user] = append(saved[user], Day{Date: date, Bytes: bytes})
+		}
+		sort.Slice(saved[user], func(i, j int) bool { return saved[user][i].Date < saved[user][j].Date })
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,95 @@
+// Package watch polls directories for files that have finished being
+// written.
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Handler is called once for each new file after its size and modification
+// time stop changing
+type Handler func(path string)
+
+type snapshot struct {
+	size    int64
+	modTime time.Time
+	// handled is set once the file has been passed to the handler
+	handled bool
+}
+
+// Watcher polls directories recursively. Polling is used instead of
+// filesystem events so it also works on network mounts.
+type Watcher struct {
+	dirs     []string
+	interval time.Duration
+	handle   Handler
+	files    map[string]snapshot
+}
+
+// New creates a watcher over dirs
+func New(dirs []string, interval time.Duration, handle Handler) *Watcher {
+	return &Watcher{dirs: dirs, interval: interval, handle: handle, files: make(map[string]snapshot)}
+}
+
+// Run polls until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll compares the directories against the previous pass. A file is
+// handed over once it looks the same on two consecutive passes, so files
+// still being copied in are left alone.
+func (w *Watcher) poll() {
+	seen := make(map[string]bool)
+	for _, dir := range w.dirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// A missing folder may be an unmounted drive, so keep polling
+				if path == dir && !os.IsNotExist(err) {
+					log.Printf("Failed to watch %s: %v", dir, err)
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			seen[path] = true
+			prev, known := w.files[path]
+			current := snapshot{size: info.Size(), modTime: info.ModTime()}
+			if known && prev.size == current.size && prev.modTime.Equal(current.modTime) {
+				if !prev.handled {
+					w.handle(path)
+				}
+				current.handled = true
+			}
+			w.files[path] = current
+			return nil
+		})
+	}
+
+	for path := range w.files {
+		if !seen[path] {
+			delete(w.files, path)
+		}
+	}
+}
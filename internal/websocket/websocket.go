@@ -0,0 +1,313 @@
+// Package websocket is the server side of the WebSocket protocol (RFC
+// 6455), enough for pushing messages to browsers: it upgrades a request,
+// sends text messages and answers the client's pings and close. Messages
+// the client sends are read and discarded.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to prove the server speaks
+// WebSocket
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxControlPayload is the protocol's limit on ping, pong and close frames
+const maxControlPayload = 125
+
+// maxMessage bounds what a client may send, as nothing it sends is used
+const maxMessage = 64 << 10
+
+// writeTimeout is how long a write may wait on a client before the
+// connection is given up on
+const writeTimeout = 10 * time.Second
+
+// Opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes
+const (
+	CloseNormal    = 1000
+	CloseGoingAway = 1001
+	CloseProtocol  = 1002
+	ClosePolicy    = 1008
+	CloseTooBig    = 1009
+)
+
+// ErrClosed is returned by writes after the connection has closed
+var ErrClosed = errors.New("websocket: connection closed")
+
+// HandshakeError is a request that can't be upgraded, with the status to
+// answer it with
+type HandshakeError struct {
+	Status  int
+	Message string
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Message
+}
+
+// Conn is an upgraded connection. Writes are safe for concurrent use.
+type Conn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	writeMu sync.Mutex
+	closed  bool
+	done    chan struct{}
+}
+
+// IsUpgrade reports whether r asks to switch to WebSocket
+func IsUpgrade(r *http.Request) bool {
+	return headerHas(r.Header, "Connection", "upgrade") && headerHas(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade switches r's connection to WebSocket. Requests from another
+// site's pages are refused, as browsers send cookies and credentials with
+// them. Errors before the switch are *HandshakeError and nothing has been
+// written, so the caller can still answer with an HTTP error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != "GET" {
+		return nil, &HandshakeError{http.StatusMethodNotAllowed, "WebSocket connections must use GET"}
+	}
+	if !IsUpgrade(r) {
+		return nil, &HandshakeError{http.StatusBadRequest, "Expected a WebSocket upgrade request"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "Unsupported WebSocket version"}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{http.StatusBadRequest, "Invalid Sec-WebSocket-Key"}
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return nil, &HandshakeError{http.StatusForbidden, "Cross-origin WebSocket connections are not allowed"}
+		}
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: taking over the connection: %w", err)
+	}
+	// The server's deadlines no longer apply
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Conn{conn: conn, rw: rw, done: make(chan struct{})}
+	go c.readLoop()
+	return c, nil
+}
+
+// Done is closed once the connection is, whether by the client or Close
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// WriteText sends data as a text message
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Close sends a close frame with code and reason and closes the
+// connection
+func (c *Conn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	err := c.writeFrame(opClose, payload)
+	c.shutdown()
+	return err
+}
+
+// shutdown closes the underlying connection once
+func (c *Conn) shutdown() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.closeLocked()
+}
+
+// closeLocked closes the connection. The caller holds c.writeMu.
+func (c *Conn) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.conn.Close()
+	close(c.done)
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	// Server frames are sent whole and unmasked
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.rw.Write(header)
+	c.rw.Write(payload)
+	if err := c.rw.Flush(); err != nil {
+		c.closeLocked()
+		return err
+	}
+	return nil
+}
+
+// readLoop reads the client's frames until the connection closes,
+// answering pings and close frames
+func (c *Conn) readLoop() {
+	defer c.shutdown()
+
+	for {
+		op, payload, err := c.readFrame()
+		if err != nil {
+			var pe protocolError
+			if errors.As(err, &pe) {
+				c.Close(pe.code, pe.msg)
+			}
+			return
+		}
+		switch op {
+		case opPing:
+			if c.writeFrame(opPong, payload) != nil {
+				return
+			}
+		case opClose:
+			// Echo the client's status back, as the protocol asks
+			if len(payload) >= 2 {
+				payload = payload[:2]
+			}
+			c.writeFrame(opClose, payload)
+			return
+		}
+	}
+}
+
+type protocolError struct {
+	code int
+	msg  string
+}
+
+func (e protocolError) Error() string {
+	return e.msg
+}
+
+// readFrame reads one frame, unmasking its payload. Data frames are read
+// but not kept.
+func (c *Conn) readFrame() (op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return 0, nil, protocolError{CloseProtocol, "unexpected reserved bits"}
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, protocolError{CloseProtocol, "client frames must be masked"}
+	}
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	control := op >= opClose
+	switch {
+	case control && (n > maxControlPayload || head[0]&0x80 == 0):
+		return 0, nil, protocolError{CloseProtocol, "invalid control frame"}
+	case !control && op != opContinuation && op != opText && op != opBinary:
+		return 0, nil, protocolError{CloseProtocol, "unknown opcode"}
+	case n > maxMessage:
+		return 0, nil, protocolError{CloseTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	if !control {
+		_, err := io.CopyN(io.Discard, c.rw, int64(n))
+		return op, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
+
+// headerHas reports whether the comma-separated header name contains
+// token, case-insensitively
+func headerHas(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,390 @@
+// Package websub is a WebSub hub (https://www.w3.org/TR/websub/) for a
+// single topic. Subscribers ask for a callback URL to be sent the topic's
+// updates, the hub confirms the request by having the callback echo a
+// challenge, and each update is then POSTed to the callback until the
+// subscription's lease runs out. Subscriptions are persisted in the data
+// directory.
+package websub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLease is how long a subscription lasts when the subscriber
+	// doesn't ask, and MaxLease the longest it may ask for
+	DefaultLease = 7 * 24 * time.Hour
+	MaxLease     = 30 * 24 * time.Hour
+	// maxSecret is the longest hub.secret the spec allows, in bytes
+	maxSecret = 199
+	// requestTimeout bounds each verification and delivery request
+	requestTimeout = 10 * time.Second
+)
+
+// retryDelays are the waits before delivering an update again after a
+// failed attempt
+var retryDelays = []time.Duration{30 * time.Second, 5 * time.Minute, 30 * time.Minute}
+
+// Modes of a subscription request
+const (
+	ModeSubscribe   = "subscribe"
+	ModeUnsubscribe = "unsubscribe"
+)
+
+// Request is a subscriber asking to subscribe or unsubscribe
+type Request struct {
+	Mode     string
+	Callback string
+	// Topic is the URL the subscriber found the hub at
+	Topic string
+	// Secret, when set, signs each delivery with HMAC-SHA256
+	Secret string
+	// Lease is how long the subscription should last, 0 for DefaultLease
+	Lease time.Duration
+}
+
+// ParseRequest reads a request from the hub.* form values of a POST to
+// the hub, checking them. topicOK reports whether the hub serves a topic.
+func ParseRequest(form url.Values, topicOK func(topic string) bool) (Request, error) {
+	req := Request{
+		Mode:     form.Get("hub.mode"),
+		Callback: form.Get("hub.callback"),
+		Topic:    form.Get("hub.topic"),
+		Secret:   form.Get("hub.secret"),
+	}
+	if req.Mode != ModeSubscribe && req.Mode != ModeUnsubscribe {
+		return req, fmt.Errorf("hub.mode must be %s or %s", ModeSubscribe, ModeUnsubscribe)
+	}
+	callback, err := url.Parse(req.Callback)
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		return req, errors.New("hub.callback must be an http or https URL")
+	}
+	if !topicOK(req.Topic) {
+		return req, fmt.Errorf("this hub doesn't serve the topic %q", req.Topic)
+	}
+	if len(req.Secret) > maxSecret {
+		return req, fmt.Errorf("hub.secret must be shorter than %d bytes", maxSecret+1)
+	}
+	if raw := form.Get("hub.lease_seconds"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			return req, errors.New("hub.lease_seconds must be a positive whole number")
+		}
+		req.Lease = time.Duration(seconds) * time.Second
+	}
+	return req, nil
+}
+
+// Subscription is a callback the hub sends updates to
+type Subscription struct {
+	Callback string `json:"callback"`
+	Topic    string `json:"topic"`
+	// Secret signs deliveries; it is never shown back
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// LastDelivery is when an update last reached the callback
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+	// LastError is why the latest delivery failed, cleared by the next
+	// one that succeeds
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Hub keeps the subscriptions and delivers updates to them
+type Hub struct {
+	path string
+	// hubURL turns a topic into the URL of the hub serving it, for the
+	// Link header deliveries carry
+	hubURL func(topic string) string
+	client *http.Client
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+}
+
+// NewHub creates a hub persisting its subscriptions to dataDir. hubURL
+// returns the hub's own URL for a topic.
+func NewHub(dataDir string, hubURL func(topic string) string) *Hub {
+	return &Hub{
+		path:   filepath.Join(dataDir, "websub.json"),
+		hubURL: hubURL,
+		client: &http.Client{Timeout: requestTimeout},
+		subs:   make(map[string]*Subscription),
+	}
+}
+
+// key identifies a subscription: a callback may subscribe to each topic
+// once
+func key(topic, callback string) string {
+	return topic + " " + callback
+}
+
+// Load reads the persisted subscriptions, if any, dropping expired ones
+func (h *Hub) Load() error {
+	data, err := os.ReadFile(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var list []*Subscription
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parsing %s: %w", h.path, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for _, sub := range list {
+		if sub.ExpiresAt.After(now) {
+			h.subs[key(sub.Topic, sub.Callback)] = sub
+		}
+	}
+	return nil
+}
+
+// List returns the live subscriptions, soonest to expire first, with
+// their secrets left out
+func (h *Hub) List() []Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	list := make([]Subscription, 0, len(h.subs))
+	for _, sub := range h.subs {
+		if sub.ExpiresAt.After(now) {
+			c := *sub
+			c.Secret = ""
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ExpiresAt.Before(list[j].ExpiresAt) })
+	return list
+}
+
+// Verify confirms req with its callback, as the spec requires before
+// acting on it, then subscribes or unsubscribes. The callback is sent the
+// request's details and a random challenge, and must answer 2xx with the
+// challenge as the body.
+func (h *Hub) Verify(ctx context.Context, req Request) error {
+	lease := req.Lease
+	if lease == 0 {
+		lease = DefaultLease
+	}
+	lease = min(lease, MaxLease)
+
+	challenge, err := newChallenge()
+	if err != nil {
+		return err
+	}
+	callback, err := url.Parse(req.Callback)
+	if err != nil {
+		return err
+	}
+	q := callback.Query()
+	q.Set("hub.mode", req.Mode)
+	q.Set("hub.topic", req.Topic)
+	q.Set("hub.challenge", challenge)
+	if req.Mode == ModeSubscribe {
+		q.Set("hub.lease_seconds", strconv.Itoa(int(lease.Seconds())))
+	}
+	callback.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", callback.String(), nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("User-Agent", "ute")
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", req.Callback, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, int64(len(challenge))+1))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("verifying %s: %s", req.Callback, resp.Status)
+	}
+	// Some frameworks end every response with a newline
+	if strings.TrimSpace(string(body)) != challenge {
+		return fmt.Errorf("verifying %s: the callback didn't echo the challenge", req.Callback)
+	}
+
+	h.mu.Lock()
+	k := key(req.Topic, req.Callback)
+	if req.Mode == ModeUnsubscribe {
+		delete(h.subs, k)
+	} else {
+		now := time.Now()
+		sub := &Subscription{Callback: req.Callback, Topic: req.Topic, CreatedAt: now}
+		if old, ok := h.subs[k]; ok {
+			sub.CreatedAt = old.CreatedAt
+		}
+		sub.Secret = req.Secret
+		sub.ExpiresAt = now.Add(lease)
+		h.subs[k] = sub
+	}
+	err = h.save()
+	h.mu.Unlock()
+	return err
+}
+
+// Publish sends body, an update of contentType, to every live
+// subscription. Each delivery runs in the background and is retried
+// after a failure, a few times over the next half hour. Expired
+// subscriptions are dropped.
+func (h *Hub) Publish(ctx context.Context, contentType string, body []byte) {
+	h.mu.Lock()
+	now := time.Now()
+	var targets []Subscription
+	expired := false
+	for k, sub := range h.subs {
+		if !sub.ExpiresAt.After(now) {
+			delete(h.subs, k)
+			expired = true
+			continue
+		}
+		targets = append(targets, *sub)
+	}
+	if expired {
+		if err := h.save(); err != nil {
+			log.Printf("Failed to save WebSub subscriptions: %v", err)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range targets {
+		go h.deliver(ctx, sub, contentType, body)
+	}
+}
+
+// deliver POSTs body to sub's callback, retrying after failures
+func (h *Hub) deliver(ctx context.Context, sub Subscription, contentType string, body []byte) {
+	for attempt := 0; ; attempt++ {
+		err := h.post(ctx, sub, contentType, body)
+		if live := h.noteDelivery(sub, err); !live || err == nil {
+			return
+		}
+		if attempt == len(retryDelays) {
+			log.Printf("Giving up delivering a WebSub update to %s: %v", sub.Callback, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelays[attempt]):
+		}
+	}
+}
+
+// errGone is returned for a callback answering 410 Gone, which ends its
+// subscription
+var errGone = errors.New("the callback is gone")
+
+func (h *Hub) post(ctx context.Context, sub Subscription, contentType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "ute")
+	req.Header.Set("Link", fmt.Sprintf(`<%s>; rel="hub", <%s>; rel="self"`, h.hubURL(sub.Topic), sub.Topic))
+	if sub.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(sub.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return errGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// noteDelivery records the outcome of a delivery to sub, unsubscribing
+// callbacks that are gone. It reports whether sub is still subscribed.
+func (h *Hub) noteDelivery(sub Subscription, err error) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := key(sub.Topic, sub.Callback)
+	current, ok := h.subs[k]
+	if !ok {
+		return false
+	}
+	live := true
+	switch {
+	case errors.Is(err, errGone):
+		log.Printf("WebSub callback %s is gone, unsubscribing it", sub.Callback)
+		delete(h.subs, k)
+		live = false
+	case err != nil:
+		current.LastError = err.Error()
+	default:
+		now := time.Now()
+		current.LastDelivery, current.LastError = &now, ""
+	}
+	if err := h.save(); err != nil {
+		log.Printf("Failed to save WebSub subscriptions: %v", err)
+	}
+	return live
+}
+
+// save writes every subscription. The caller holds h.mu.
+func (h *Hub) save() error {
+	list := make([]*Subscription, 0, len(h.subs))
+	for _, sub := range h.subs {
+		list = append(list, sub)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return key(list[i].Topic, list[i].Callback) < key(list[j].Topic, list[j].Callback)
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	// The file holds the secrets
+	return os.WriteFile(h.path, data, 0600)
+}
+
+func newChallenge() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
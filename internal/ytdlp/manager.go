@@ -0,0 +1,336 @@
+// Package ytdlp locates, installs and updates the yt-dlp binary.
+package ytdlp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"noahjalex.ute/internal/config"
+)
+
+const (
+	releasesURL  = "https://github.com/yt-dlp/yt-dlp/releases/download"
+	latestAPIURL = "https://api.github.com/repos/yt-dlp/yt-dlp/releases/latest"
+	checksumFile = "SHA2-256SUMS"
+)
+
+// Status is a snapshot of the binary in use, reported by /api/system
+type Status struct {
+	Path          string     `json:"path"`
+	Version       string     `json:"version"`
+	Managed       bool       `json:"managed"`
+	Pinned        string     `json:"pinned_version,omitempty"`
+	LatestVersion string     `json:"latest_version,omitempty"`
+	LastCheck     *time.Time `json:"last_check,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// Manager resolves which yt-dlp binary to run. When managed it downloads a
+// release from GitHub into the data directory and keeps it updated.
+type Manager struct {
+	cfg    config.YtDlp
+	binDir string
+	client *http.Client
+
+	mu        sync.RWMutex
+	path      string
+	version   string
+	latest    string
+	lastCheck time.Time
+	lastErr   string
+}
+
+// NewManager creates a manager storing binaries under dataDir/bin
+func NewManager(dataDir string, cfg config.YtDlp) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		binDir: filepath.Join(dataDir, "bin"),
+		client: &http.Client{Timeout: 5 * time.Minute},
+		path:   "yt-dlp",
+	}
+}
+
+// Path returns the binary to execute
+func (m *Manager) Path() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.path
+}
+
+// Status returns the current binary state
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := Status{
+		Path:          m.path,
+		Version:       m.version,
+		Managed:       m.cfg.Managed,
+		LatestVersion: m.latest,
+		LastError:     m.lastErr,
+	}
+	if m.cfg.Version != "latest" {
+		s.Pinned = m.cfg.Version
+	}
+	if !m.lastCheck.IsZero() {
+		t := m.lastCheck
+		s.LastCheck = &t
+	}
+	return s
+}
+
+// Ensure resolves the binary to use, installing the configured release
+// first when the binary is managed and missing or at the wrong version.
+func (m *Manager) Ensure(ctx context.Context) error {
+	err := m.ensure(ctx)
+	m.recordError(err)
+	return err
+}
+
+func (m *Manager) ensure(ctx context.Context) error {
+	if !m.cfg.Managed {
+		path, err := exec.LookPath("yt-dlp")
+		if err != nil {
+			return fmt.Errorf("yt-dlp not found in PATH: %w", err)
+		}
+		version, err := probeVersion(ctx, path)
+		if err != nil {
+			return err
+		}
+		m.setBinary(path, version)
+		return nil
+	}
+
+	target := filepath.Join(m.binDir, assetName())
+	if version, err := probeVersion(ctx, target); err == nil {
+		if m.cfg.Version == "latest" || version == m.cfg.Version {
+			m.setBinary(target, version)
+			return nil
+		}
+		log.Printf("Managed yt-dlp is %s but %s is pinned, reinstalling", version, m.cfg.Version)
+	}
+
+	tag := m.cfg.Version
+	if tag == "latest" {
+		latest, err := m.latestTag(ctx)
+		if err != nil {
+			return err
+		}
+		tag = latest
+	}
+
+	return m.install(ctx, tag)
+}
+
+// CheckForUpdate looks up the newest release and installs it when the
+// binary is managed and not pinned to a specific version.
+func (m *Manager) CheckForUpdate(ctx context.Context) error {
+	latest, err := m.latestTag(ctx)
+
+	m.mu.Lock()
+	m.lastCheck = time.Now()
+	if err == nil {
+		m.latest = latest
+	}
+	current := m.version
+	m.mu.Unlock()
+
+	if err != nil {
+		m.recordError(err)
+		return err
+	}
+
+	if !m.cfg.Managed || m.cfg.Version != "latest" || latest == current {
+		m.recordError(nil)
+		return nil
+	}
+
+	log.Printf("Updating yt-dlp from %s to %s", current, latest)
+	err = m.install(ctx, latest)
+	m.recordError(err)
+	return err
+}
+
+// install downloads the release asset for tag, verifies it against the
+// published checksums and atomically replaces the managed binary.
+func (m *Manager) install(ctx context.Context, tag string) error {
+	asset := assetName()
+	log.Printf("Installing yt-dlp %s (%s)", tag, asset)
+
+	sums, err := m.fetchChecksums(ctx, tag)
+	if err != nil {
+		return err
+	}
+	want, ok := sums[asset]
+	if !ok {
+		return fmt.Errorf("no checksum published for %s in release %s", asset, tag)
+	}
+
+	if err := os.MkdirAll(m.binDir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", m.binDir, err)
+	}
+
+	tmp, err := os.CreateTemp(m.binDir, asset+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	body, err := m.get(ctx, fmt.Sprintf("%s/%s/%s", releasesURL, tag, asset))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("downloading %s: %w", asset, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", asset, got, want)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	target := filepath.Join(m.binDir, asset)
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("installing %s: %w", target, err)
+	}
+
+	version, err := probeVersion(ctx, target)
+	if err != nil {
+		return err
+	}
+	m.setBinary(target, version)
+	log.Printf("Installed yt-dlp %s at %s", version, target)
+	return nil
+}
+
+func (m *Manager) fetchChecksums(ctx context.Context, tag string) (map[string]string, error) {
+	body, err := m.get(ctx, fmt.Sprintf("%s/%s/%s", releasesURL, tag, checksumFile))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[fields[1]] = strings.ToLower(fields[0])
+		}
+	}
+	return sums, scanner.Err()
+}
+
+func (m *Manager) latestTag(ctx context.Context) (string, error) {
+	body, err := m.get(ctx, latestAPIURL)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(body).Decode(&release); err != nil {
+		return "", fmt.Errorf("decoding latest release: %w", err)
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("latest release has no tag")
+	}
+	return release.TagName, nil
+}
+
+func (m *Manager) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (m *Manager) setBinary(path, version string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.path = path
+	m.version = version
+}
+
+func (m *Manager) recordError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.lastErr = err.Error()
+	} else {
+		m.lastErr = ""
+	}
+}
+
+// probeVersion runs `<path> --version`
+func probeVersion(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %s --version: %v: %s", path, err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// assetName returns the standalone release asset for this platform
+func assetName() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "yt-dlp.exe"
+	case "darwin":
+		return "yt-dlp_macos"
+	case "linux":
+		switch runtime.GOARCH {
+		case "arm64":
+			return "yt-dlp_linux_aarch64"
+		case "arm":
+			return "yt-dlp_linux_armv7l"
+		case "amd64":
+			return "yt-dlp_linux"
+		}
+	}
+	// Platform-independent zipapp, needs python3 on PATH
+	return "yt-dlp"
+}
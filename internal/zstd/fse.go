@@ -0,0 +1,254 @@
+package zstd
+
+import "math/bits"
+
+// fseTable encodes symbols with a finite state entropy distribution,
+// one of the format's predefined ones or one described in a block
+type fseTable struct {
+	accuracyLog uint8
+	norm        []int16
+	// states lists the next state for each symbol, in the order the
+	// decoding table holds them
+	states  []uint16
+	symbols []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+// The predefined distributions, a -1 marking a symbol too rare for a full
+// share of the table
+var (
+	litLenTable = newFSETable(6, []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	})
+	matchLenTable = newFSETable(6, []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	})
+	offsetTable = newFSETable(5, []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	})
+)
+
+// newFSETable builds the encoding table for a distribution, spreading the
+// symbols over the states as RFC 8878 section 4.1.1 lays out
+func newFSETable(accuracyLog uint8, norm []int16) fseTable {
+	size := 1 << accuracyLog
+	mask := size - 1
+	spread := make([]uint8, size)
+
+	// Less than one share go at the end of the table
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			spread[high] = uint8(s)
+			high--
+		}
+	}
+	pos, step := 0, size>>1+size>>3+3
+	for s, n := range norm {
+		for range max(n, 0) {
+			spread[pos] = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+
+	// Where each symbol's states start in states
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		cumul[s+1] = cumul[s] + max(int(n), -int(n))
+	}
+	t := fseTable{
+		accuracyLog: accuracyLog,
+		norm:        norm,
+		states:      make([]uint16, size),
+		symbols:     make([]fseSymbol, len(norm)),
+	}
+	next := append([]int(nil), cumul...)
+	for u, s := range spread {
+		t.states[next[s]] = uint16(size + u)
+		next[s]++
+	}
+
+	for s, n := range norm {
+		sym := &t.symbols[s]
+		switch n {
+		case 0:
+			continue
+		case -1, 1:
+			sym.deltaNbBits = uint32(accuracyLog)<<16 - uint32(size)
+			sym.deltaFindState = int32(cumul[s] - 1)
+			continue
+		}
+		maxBitsOut := uint32(accuracyLog) - uint32(highBit(int(n-1)))
+		minStatePlus := uint32(n) << maxBitsOut
+		sym.deltaNbBits = maxBitsOut<<16 - minStatePlus
+		sym.deltaFindState = int32(cumul[s] - int(n))
+	}
+	return t
+}
+
+// fseState is where an encoder is in a table. Without a table it codes
+// the one symbol of an RLE stream, which takes no bits.
+type fseState struct {
+	t     *fseTable
+	state uint32
+}
+
+// init starts in the state a decoder ends in after reading sym, which
+// needs no bits
+func (f *fseState) init(sym uint8) {
+	if f.t == nil {
+		return
+	}
+	s := f.t.symbols[sym]
+	nb := (s.deltaNbBits + 1<<15) >> 16
+	value := nb<<16 - s.deltaNbBits
+	f.state = uint32(f.t.states[int32(value>>nb)+s.deltaFindState])
+}
+
+// encode writes the bits that take a decoder from sym's state to the
+// current one
+func (f *fseState) encode(b *bitWriter, sym uint8) {
+	if f.t == nil {
+		return
+	}
+	s := f.t.symbols[sym]
+	nb := (f.state + s.deltaNbBits) >> 16
+	b.addBits(uint64(f.state), uint8(nb))
+	f.state = uint32(f.t.states[int32(f.state>>nb)+s.deltaFindState])
+}
+
+// flush writes the state the decoder starts in
+func (f *fseState) flush(b *bitWriter) {
+	if f.t == nil {
+		return
+	}
+	b.addBits(uint64(f.state), f.t.accuracyLog)
+}
+
+// normalize scales counts to shares of a 1<<accuracyLog table, giving
+// every symbol present at least one
+func normalize(counts []int, total int, accuracyLog uint8) []int16 {
+	size := 1 << accuracyLog
+	norm := make([]int16, len(counts))
+	sum := 0
+	for s, n := range counts {
+		if n > 0 {
+			norm[s] = int16(max((2*n*size/total+1)/2, 1))
+			sum += int(norm[s])
+		}
+	}
+	// Settle the difference on the biggest shares
+	for sum != size {
+		s := 0
+		for i, n := range norm {
+			if n > norm[s] {
+				s = i
+			}
+		}
+		if sum > size {
+			norm[s]--
+			sum--
+		} else {
+			norm[s]++
+			sum++
+		}
+	}
+	return norm
+}
+
+// appendNormalized appends the description of an FSE distribution, as
+// RFC 8878 section 4.1.1 lays out
+func appendNormalized(out []byte, norm []int16, accuracyLog uint8) []byte {
+	last := len(norm) - 1
+	for norm[last] == 0 {
+		last--
+	}
+	bw := bitWriter{out: out}
+	bw.addBits(uint64(accuracyLog-5), 4)
+	remaining := 1<<accuracyLog + 1
+	threshold := 1 << accuracyLog
+	nbBits := accuracyLog + 1
+	previous0 := false
+	for s := 0; s <= last && remaining > 1; {
+		if previous0 {
+			// A run of zero shares, counted in 2 bit repeat flags
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for ; s >= start+24; start += 24 {
+				bw.addBits(0xFFFF, 16)
+			}
+			for ; s >= start+3; start += 3 {
+				bw.addBits(3, 2)
+			}
+			bw.addBits(uint64(s-start), 2)
+		}
+
+		n := int(norm[s])
+		s++
+		most := 2*threshold - 1 - remaining
+		remaining -= max(n, -n)
+		value := n + 1
+		if value >= threshold {
+			value += most
+		}
+		nb := nbBits
+		if value < most {
+			nb--
+		}
+		bw.addBits(uint64(value), nb)
+		previous0 = value == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if bw.n > 0 {
+		bw.out = append(bw.out, byte(bw.acc))
+	}
+	return bw.out
+}
+
+// highBit is the position of the highest bit set in n
+func highBit(n int) int {
+	return bits.Len(uint(n)) - 1
+}
+
+// bitWriter collects a bitstream from its low bits up
+type bitWriter struct {
+	out []byte
+	acc uint64
+	n   uint8
+}
+
+func (b *bitWriter) addBits(v uint64, n uint8) {
+	b.acc |= (v & (1<<n - 1)) << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc >>= 8
+		b.n -= 8
+	}
+}
+
+// close ends the stream with a 1 bit, which the reader looks for to find
+// where the bits start, and pads it to a byte
+func (b *bitWriter) close() []byte {
+	b.addBits(1, 1)
+	if b.n > 0 {
+		b.out = append(b.out, byte(b.acc))
+	}
+	return b.out
+}
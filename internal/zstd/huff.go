@@ -0,0 +1,270 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+const (
+	// maxHuffBits is the longest code the format allows
+	maxHuffBits = 11
+	// minHuffLiterals is the fewest literals worth a code table
+	minHuffLiterals = 64
+	// weightsLog is the accuracy of the table coding Huffman weights
+	weightsLog = 6
+)
+
+// Literals section types
+const (
+	litsRaw        = 0
+	litsRLE        = 1
+	litsCompressed = 2
+)
+
+// huffCode is a canonical prefix code for the literals of a block
+type huffCode struct {
+	length [256]uint8
+	code   [256]uint16
+	// last is the highest symbol in the code, whose weight isn't written
+	last int
+}
+
+// appendLiterals appends the literals section for lits, Huffman coded
+// when that comes out smaller
+func appendLiterals(out, lits []byte) []byte {
+	n := len(lits)
+	if n >= minHuffLiterals {
+		var counts [256]int
+		for _, b := range lits {
+			counts[b]++
+		}
+		if counts[lits[0]] == n {
+			return append(appendLitsHeader(out, litsRLE, n), lits[0])
+		}
+		var h huffCode
+		h.build(&counts)
+		start := len(out)
+		if out, ok := h.appendLiterals(out, lits); ok {
+			return out
+		}
+		out = out[:start]
+	}
+	return append(appendLitsHeader(out, litsRaw, n), lits...)
+}
+
+// appendLitsHeader appends the header of a raw or RLE literals section
+func appendLitsHeader(out []byte, typ byte, n int) []byte {
+	switch {
+	case n < 32:
+		return append(out, byte(n<<3)|typ)
+	case n < 4096:
+		return append(out, byte(n<<4)|1<<2|typ, byte(n>>4))
+	}
+	return append(out, byte(n<<4)|3<<2|typ, byte(n>>4), byte(n>>12))
+}
+
+// appendLiterals appends lits coded with h, with the code's description,
+// reporting false if that's no smaller than the literals themselves
+func (h *huffCode) appendLiterals(out, lits []byte) ([]byte, bool) {
+	n := len(lits)
+	// A single stream below 256 literals, else four decoded in parallel,
+	// with sizes of 10, 14 or 18 bits
+	format, headerSize, sizeBits := 0, 3, 10
+	switch {
+	case n < 256:
+	case n < 1024:
+		format = 1
+	case n < 16384:
+		format, headerSize, sizeBits = 2, 4, 14
+	default:
+		format, headerSize, sizeBits = 3, 5, 18
+	}
+	start := len(out)
+	out = append(out, make([]byte, headerSize)...)
+	out, ok := h.appendTable(out)
+	if !ok {
+		return out, false
+	}
+	if format == 0 {
+		out = h.appendStream(out, lits)
+	} else {
+		jump := len(out)
+		out = append(out, make([]byte, 6)...)
+		seg := (n + 3) / 4
+		for i := range 4 {
+			from := len(out)
+			out = h.appendStream(out, lits[min(i*seg, n):min((i+1)*seg, n)])
+			if i < 3 {
+				binary.LittleEndian.PutUint16(out[jump+2*i:], uint16(len(out)-from))
+			}
+		}
+	}
+
+	size := len(out) - start - headerSize
+	if size+headerSize >= n {
+		return out, false
+	}
+	header := uint64(litsCompressed) | uint64(format)<<2 | uint64(n)<<4 | uint64(size)<<(4+sizeBits)
+	for i := range headerSize {
+		out[start+i] = byte(header >> (8 * i))
+	}
+	return out, true
+}
+
+// appendStream appends lits as one stream, which is read from its end:
+// the first literal is written last
+func (h *huffCode) appendStream(out, lits []byte) []byte {
+	bw := bitWriter{out: out}
+	for i := len(lits) - 1; i >= 0; i-- {
+		b := lits[i]
+		bw.addBits(uint64(h.code[b]), h.length[b])
+	}
+	return bw.close()
+}
+
+// build makes the code for counts, which hold at least two symbols
+func (h *huffCode) build(counts *[256]int) {
+	c := *counts
+	maxBits := h.lengths(&c)
+	// Flatten the counts until no code is too long
+	for maxBits > maxHuffBits {
+		for i, n := range c {
+			c[i] = (n + 1) / 2
+		}
+		maxBits = h.lengths(&c)
+	}
+
+	// Symbols are given codes by weight and then value, the longest codes
+	// first, each taking its share of the 1<<maxBits values
+	pos := 0
+	for l := maxBits; l > 0; l-- {
+		for s := range 256 {
+			if h.length[s] == l {
+				h.code[s] = uint16(pos >> (maxBits - l))
+				pos += 1 << (maxBits - l)
+				h.last = max(h.last, s)
+			}
+		}
+	}
+}
+
+// lengths sets the Huffman code lengths for counts, returning the longest
+func (h *huffCode) lengths(counts *[256]int) uint8 {
+	var leaves []int
+	for s, n := range counts {
+		if n > 0 {
+			leaves = append(leaves, s)
+		}
+	}
+	slices.SortStableFunc(leaves, func(a, b int) int { return counts[a] - counts[b] })
+
+	// Leaves and then the nodes joining them, which come out in order of
+	// weight, so the two lightest are always at the front of one or other
+	n := len(leaves)
+	weight := make([]int, 2*n-1)
+	parent := make([]int, 2*n-1)
+	for i, s := range leaves {
+		weight[i] = counts[s]
+	}
+	leaf, node := 0, n
+	lightest := func(made int) int {
+		if leaf < n && (node == made || weight[leaf] <= weight[node]) {
+			leaf++
+			return leaf - 1
+		}
+		node++
+		return node - 1
+	}
+	for next := n; next < len(weight); next++ {
+		a, b := lightest(next), lightest(next)
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+	}
+
+	depth := make([]uint8, len(weight))
+	for i := len(weight) - 2; i >= 0; i-- {
+		depth[i] = depth[parent[i]] + 1
+	}
+	h.length = [256]uint8{}
+	var longest uint8
+	for i, s := range leaves {
+		h.length[s] = depth[i]
+		longest = max(longest, depth[i])
+	}
+	return longest
+}
+
+// appendTable appends the description of the code: the weights of every
+// symbol below the last, FSE coded or else four bits each
+func (h *huffCode) appendTable(out []byte) ([]byte, bool) {
+	maxBits := uint8(0)
+	for _, l := range h.length {
+		maxBits = max(maxBits, l)
+	}
+	weights := make([]uint8, h.last)
+	for s := range weights {
+		if h.length[s] > 0 {
+			weights[s] = maxBits + 1 - h.length[s]
+		}
+	}
+
+	// The FSE coded table is sized in a byte below 128, and the weights
+	// of only 128 symbols fit the other way
+	start := len(out)
+	out, ok := appendFSEWeights(append(out, 0), weights)
+	size := len(out) - start - 1
+	if ok && size < 128 && (len(weights) > 128 || size < (len(weights)+1)/2) {
+		out[start] = byte(size)
+		return out, true
+	}
+	out = out[:start]
+	if len(weights) > 128 {
+		return out, false
+	}
+	out = append(out, byte(127+len(weights)))
+	for i := 0; i < len(weights); i += 2 {
+		b := weights[i] << 4
+		if i+1 < len(weights) {
+			b |= weights[i+1]
+		}
+		out = append(out, b)
+	}
+	return out, true
+}
+
+// appendFSEWeights appends weights FSE coded, with two states taking turns
+// over one bitstream. It reports false when there's only one weight, which
+// the format can't code this way.
+func appendFSEWeights(out []byte, weights []uint8) ([]byte, bool) {
+	counts := make([]int, maxHuffBits+1)
+	for _, w := range weights {
+		counts[w]++
+	}
+	if slices.Max(counts) == len(weights) {
+		return out, false
+	}
+	norm := normalize(counts, len(weights), weightsLog)
+	out = appendNormalized(out, norm, weightsLog)
+	t := newFSETable(weightsLog, norm)
+
+	bw := bitWriter{out: out}
+	s1, s2 := fseState{t: &t}, fseState{t: &t}
+	i := len(weights)
+	if i%2 == 1 {
+		s1.init(weights[i-1])
+		s2.init(weights[i-2])
+		s1.encode(&bw, weights[i-3])
+		i -= 3
+	} else {
+		s2.init(weights[i-1])
+		s1.init(weights[i-2])
+		i -= 2
+	}
+	for ; i > 0; i -= 2 {
+		s2.encode(&bw, weights[i-1])
+		s1.encode(&bw, weights[i-2])
+	}
+	s2.flush(&bw)
+	s1.flush(&bw)
+	return bw.close(), true
+}
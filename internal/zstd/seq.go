@@ -0,0 +1,216 @@
+package zstd
+
+import "math"
+
+// Ways a sequences section codes each of its three symbol streams
+const (
+	modePredefined = 0
+	modeRLE        = 1
+	modeFSE        = 2
+)
+
+// The most accurate tables the format allows for each stream
+const (
+	maxLitLenLog   = 9
+	maxMatchLenLog = 9
+	maxOffsetLog   = 8
+)
+
+// sequence copies litLen literals and then matchLen bytes from earlier in
+// the output. offBase is where from: 1 to 3 for one of the repeat offsets,
+// else the offset plus 3.
+type sequence struct {
+	litLen, matchLen, offBase uint32
+}
+
+// repeats are the last three offsets used, which a sequence can name for
+// less than a new offset
+type repeats [3]uint32
+
+// offBase codes an offset following litLen literals, naming one of r
+// when it can, and updates r as a decoder will
+func (r *repeats) offBase(offset, litLen uint32) uint32 {
+	if litLen > 0 {
+		switch offset {
+		case r[0]:
+			return 1
+		case r[1]:
+			r[0], r[1] = r[1], r[0]
+			return 2
+		case r[2]:
+			r[0], r[1], r[2] = r[2], r[0], r[1]
+			return 3
+		}
+	} else {
+		// Without literals the codes shift along, as the first offset
+		// would have carried on the last match
+		switch offset {
+		case r[1]:
+			r[0], r[1] = r[1], r[0]
+			return 1
+		case r[2]:
+			r[0], r[1], r[2] = r[2], r[0], r[1]
+			return 2
+		case r[0] - 1:
+			r[0], r[1], r[2] = offset, r[0], r[1]
+			return 3
+		}
+	}
+	r[0], r[1], r[2] = offset, r[0], r[1]
+	return offset + 3
+}
+
+// appendSequences appends the sequences section for seqs
+func appendSequences(out []byte, seqs []sequence) []byte {
+	nb := len(seqs)
+	switch {
+	case nb < 128:
+		out = append(out, byte(nb))
+	case nb < 0x7F00:
+		out = append(out, byte(nb>>8)+128, byte(nb))
+	default:
+		out = append(out, 255, byte(nb-0x7F00), byte((nb-0x7F00)>>8))
+	}
+	if nb == 0 {
+		return out
+	}
+
+	var llCounts [36]int
+	var mlCounts [53]int
+	var ofCounts [29]int
+	for _, s := range seqs {
+		llCounts[litLenCode(s.litLen)]++
+		mlCounts[matchLenCode(s.matchLen)]++
+		ofCounts[offsetCode(s.offBase)]++
+	}
+	modes := len(out)
+	out = append(out, 0)
+	llMode, llTable, out := chooseTable(out, llCounts[:], nb, &litLenTable, maxLitLenLog)
+	ofMode, ofTable, out := chooseTable(out, ofCounts[:], nb, &offsetTable, maxOffsetLog)
+	mlMode, mlTable, out := chooseTable(out, mlCounts[:], nb, &matchLenTable, maxMatchLenLog)
+	out[modes] = llMode<<6 | ofMode<<4 | mlMode<<2
+
+	// The bitstream is read backwards, so the last sequence goes first
+	bw := bitWriter{out: out}
+	ll, of, ml := fseState{t: llTable}, fseState{t: ofTable}, fseState{t: mlTable}
+	for i := nb - 1; i >= 0; i-- {
+		s := seqs[i]
+		llc, mlc, ofc := litLenCode(s.litLen), matchLenCode(s.matchLen), offsetCode(s.offBase)
+		if i == nb-1 {
+			ml.init(mlc)
+			of.init(ofc)
+			ll.init(llc)
+		} else {
+			of.encode(&bw, ofc)
+			ml.encode(&bw, mlc)
+			ll.encode(&bw, llc)
+		}
+		bw.addBits(uint64(s.litLen-litLenBase[llc]), litLenBits[llc])
+		bw.addBits(uint64(s.matchLen-matchLenBase[mlc]), matchLenBits[mlc])
+		bw.addBits(uint64(s.offBase), ofc)
+	}
+	ml.flush(&bw)
+	of.flush(&bw)
+	ll.flush(&bw)
+	return bw.close()
+}
+
+// chooseTable picks the cheapest way to code the symbols counted: as the
+// one symbol used, with the predefined table or with a table of their own,
+// which it appends the description of. A nil table means the one symbol.
+func chooseTable(out []byte, counts []int, total int, predefined *fseTable, maxLog uint8) (byte, *fseTable, []byte) {
+	last := len(counts) - 1
+	for counts[last] == 0 {
+		last--
+	}
+	if counts[last] == total {
+		return modeRLE, nil, append(out, byte(last))
+	}
+
+	accuracyLog := tableLog(total, last, maxLog)
+	norm := normalize(counts[:last+1], total, accuracyLog)
+	start := len(out)
+	out = appendNormalized(out, norm, accuracyLog)
+	if cost(counts, norm, accuracyLog)+float64(8*(len(out)-start)) < cost(counts, predefined.norm, predefined.accuracyLog) {
+		t := newFSETable(accuracyLog, norm)
+		return modeFSE, &t, out
+	}
+	return modePredefined, predefined, out[:start]
+}
+
+// tableLog picks the accuracy of a table for total symbols up to last:
+// enough to tell them apart, but not more than so few symbols need
+func tableLog(total, last int, maxLog uint8) uint8 {
+	log := min(maxLog, uint8(max(highBit(total-1)-2, 0)))
+	log = max(log, min(uint8(highBit(total)+1), uint8(highBit(last)+2)))
+	return max(log, 5)
+}
+
+// cost estimates the bits coding counts with the distribution norm takes
+func cost(counts []int, norm []int16, accuracyLog uint8) float64 {
+	bits := 0.0
+	for s, n := range counts {
+		if n == 0 {
+			continue
+		}
+		// Shares of -1 are less than one, but take a state of their own
+		share := max(float64(norm[s]), 1)
+		bits += float64(n) * (float64(accuracyLog) - math.Log2(share))
+	}
+	return bits
+}
+
+// offsetCode is the code of an offset coded as offBase, which is followed
+// by as many bits as its value
+func offsetCode(offBase uint32) uint8 {
+	return uint8(highBit(int(offBase)))
+}
+
+func litLenCode(n uint32) uint8 {
+	if n < 16 {
+		return uint8(n)
+	}
+	return codeFor(litLenBase[16:], n) + 16
+}
+
+func matchLenCode(n uint32) uint8 {
+	if n < 35 {
+		return uint8(n - 3)
+	}
+	return codeFor(matchLenBase[32:], n) + 32
+}
+
+// codeFor returns the index of the last of bases that n reaches
+func codeFor(bases []uint32, n uint32) uint8 {
+	i := len(bases) - 1
+	for bases[i] > n {
+		i--
+	}
+	return uint8(i)
+}
+
+// Baselines and extra bits of the literal and match length codes
+var (
+	litLenBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	litLenBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	matchLenBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLenBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
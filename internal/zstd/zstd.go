@@ -0,0 +1,291 @@
+// Package zstd writes Zstandard frames (RFC 8878), enough to compress HTTP
+// responses: a lazy LZ77 match finder over a 128 KiB window, Huffman coded
+// literals and FSE coded sequences. Frames decode with any zstd decoder.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	magic = 0xFD2FB528
+	// windowLog sizes the window matches may reach back into
+	windowLog  = 17
+	windowSize = 1 << windowLog
+	// blockSize is the most input a block holds
+	blockSize = 128 << 10
+
+	hashLog = 15
+	// minMatch is the shortest match looked for; the format allows 3
+	minMatch = 4
+	// searchDepth is how many earlier positions with the same hash are
+	// tried for a longer match
+	searchDepth = 8
+	// niceMatch is long enough to stop looking for a longer match
+	niceMatch = 32
+)
+
+// Block types
+const (
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+var errClosed = errors.New("zstd: writer is closed")
+
+// Writer compresses what is written to it into a single frame
+type Writer struct {
+	w   io.Writer
+	err error
+	// wroteHeader is set once the frame header is out
+	wroteHeader bool
+	closed      bool
+
+	// buf holds up to windowSize bytes already compressed, followed by the
+	// input waiting for the next block at buf[pending:]
+	buf     []byte
+	pending int
+	// head maps hashes of 4 bytes to where in buf they were last seen, and
+	// chain each position to the one before with the same hash, -1 for none
+	head  [1 << hashLog]int32
+	chain []int32
+	// rep are the repeat offsets the decoder has after the blocks written
+	rep repeats
+
+	lits []byte
+	seqs []sequence
+	out  []byte
+}
+
+// NewWriter returns a writer compressing into w. Close must be called to
+// finish the frame.
+func NewWriter(w io.Writer) *Writer {
+	z := &Writer{chain: make([]int32, windowSize+blockSize)}
+	z.Reset(w)
+	return z
+}
+
+// Reset discards the writer's state and makes it write a new frame to w
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.err = nil
+	z.wroteHeader = false
+	z.closed = false
+	z.buf = z.buf[:0]
+	z.pending = 0
+	for i := range z.head {
+		z.head[i] = -1
+	}
+	z.rep = repeats{1, 4, 8}
+}
+
+// Write compresses p, sending out a block each time 128 KiB are waiting
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := blockSize - (len(z.buf) - z.pending)
+		if room == 0 {
+			if err := z.writeBlock(false); err != nil {
+				return n - len(p), err
+			}
+			continue
+		}
+		take := min(room, len(p))
+		z.buf = append(z.buf, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// Flush sends the waiting input as a block, so everything written so far
+// can be decoded
+func (z *Writer) Flush() error {
+	if z.closed {
+		return errClosed
+	}
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) == z.pending && z.wroteHeader {
+		return nil
+	}
+	return z.writeBlock(false)
+}
+
+// Close sends the waiting input as the frame's last block. It doesn't
+// close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if z.err == nil {
+		z.err = z.writeBlock(true)
+	}
+	z.closed = true
+	return z.err
+}
+
+// writeBlock compresses the waiting input into a block, raw when that
+// comes out smaller
+func (z *Writer) writeBlock(last bool) error {
+	z.out = z.out[:0]
+	if !z.wroteHeader {
+		z.out = binary.LittleEndian.AppendUint32(z.out, magic)
+		// No content size, checksum or dictionary; a window descriptor
+		z.out = append(z.out, 0, (windowLog-10)<<3)
+		z.wroteHeader = true
+	}
+
+	src := z.buf[z.pending:]
+	head := len(z.out)
+	z.out = append(z.out, 0, 0, 0)
+	blockType := blockRaw
+	if len(src) > 0 {
+		rep := z.findMatches()
+		z.out = appendLiterals(z.out, z.lits)
+		z.out = appendSequences(z.out, z.seqs)
+		// A raw block leaves the decoder's repeat offsets as they were
+		if len(z.out)-head-3 < len(src) {
+			blockType = blockCompressed
+			z.rep = rep
+		}
+	}
+	if blockType == blockRaw {
+		z.out = append(z.out[:head+3], src...)
+	}
+	header := uint32(len(z.out)-head-3)<<3 | uint32(blockType)<<1
+	if last {
+		header |= 1
+	}
+	z.out[head], z.out[head+1], z.out[head+2] = byte(header), byte(header>>8), byte(header>>16)
+
+	if _, err := z.w.Write(z.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.slide()
+	return nil
+}
+
+// slide marks the waiting input compressed, keeping the last windowSize
+// bytes for later matches
+func (z *Writer) slide() {
+	if drop := len(z.buf) - windowSize; drop > 0 {
+		z.buf = z.buf[:copy(z.buf, z.buf[drop:])]
+		for i, pos := range z.head {
+			z.head[i] = max(pos-int32(drop), -1)
+		}
+		n := copy(z.chain, z.chain[drop:drop+len(z.buf)])
+		for i, pos := range z.chain[:n] {
+			z.chain[i] = max(pos-int32(drop), -1)
+		}
+	}
+	z.pending = len(z.buf)
+}
+
+func hash(u uint32) uint32 {
+	return u * 2654435761 >> (32 - hashLog)
+}
+
+// longest finds the longest match for the input at i, which must be in
+// the hash chains, trying the last offset used first. It returns the
+// match's offset and length, or a length of 0 for none.
+func (z *Writer) longest(i int, last uint32) (offset, length int) {
+	buf := z.buf
+	end := len(buf)
+	matchLen := func(cand int) int {
+		n := 0
+		for ; i+n+8 <= end; n += 8 {
+			if x := binary.LittleEndian.Uint64(buf[i+n:]) ^ binary.LittleEndian.Uint64(buf[cand+n:]); x != 0 {
+				return n + bits.TrailingZeros64(x)/8
+			}
+		}
+		for i+n < end && buf[cand+n] == buf[i+n] {
+			n++
+		}
+		return n
+	}
+
+	if cand := i - int(last); cand >= 0 {
+		if n := matchLen(cand); n >= minMatch {
+			offset, length = int(last), n
+		}
+	}
+	cand := int(z.chain[i])
+	for range searchDepth {
+		if cand < 0 || i-cand >= windowSize || length >= niceMatch {
+			break
+		}
+		// A longer match has to get past where the best so far ends
+		if i+length < end && buf[cand+length] == buf[i+length] {
+			if n := matchLen(cand); n > length && n >= minMatch {
+				offset, length = i-cand, n
+			}
+		}
+		cand = int(z.chain[cand])
+	}
+	return offset, length
+}
+
+// findMatches splits the waiting input into literals and sequences,
+// returning the repeat offsets the decoder will have after them
+func (z *Writer) findMatches() repeats {
+	z.lits = z.lits[:0]
+	z.seqs = z.seqs[:0]
+	rep := z.rep
+	buf := z.buf
+	end := len(buf)
+	litStart := z.pending
+
+	// Positions before next are in the hash chains
+	next := z.pending
+	insertTo := func(k int) {
+		for ; next < k && next+minMatch <= end; next++ {
+			h := hash(binary.LittleEndian.Uint32(buf[next:]))
+			z.chain[next] = z.head[h]
+			z.head[h] = int32(next)
+		}
+	}
+
+	for i := z.pending; i+minMatch <= end; {
+		insertTo(i + 1)
+		offset, n := z.longest(i, rep[0])
+		if n == 0 {
+			// Step further the longer nothing matches, so incompressible
+			// input goes quickly
+			i += 1 + (i-litStart)>>6
+			next = max(next, i)
+			continue
+		}
+		// Put the match off while the next position has a longer one
+		for i+1+minMatch <= end {
+			insertTo(i + 2)
+			offset2, n2 := z.longest(i+1, rep[0])
+			if n2 <= n {
+				break
+			}
+			i, offset, n = i+1, offset2, n2
+		}
+
+		for i > litStart && i-offset > 0 && buf[i-1] == buf[i-offset-1] {
+			i, n = i-1, n+1
+		}
+		litLen := uint32(i - litStart)
+		z.lits = append(z.lits, buf[litStart:i]...)
+		z.seqs = append(z.seqs, sequence{litLen: litLen, matchLen: uint32(n), offBase: rep.offBase(uint32(offset), litLen)})
+		i += n
+		insertTo(i)
+		litStart = i
+	}
+	z.lits = append(z.lits, buf[litStart:]...)
+	return rep
+}
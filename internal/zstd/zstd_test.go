@@ -0,0 +1,106 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestWriter compresses inputs of several kinds with one writer and checks
+// the zstd command decodes each back
+func TestWriter(t *testing.T) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("no zstd command to decode with")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	r.Read(random)
+	var text strings.Builder
+	for i := 0; text.Len() < 600<<10; i++ {
+		fmt.Fprintf(&text, `{"id": %d, "title": "Vidéo nº %d ✓", "duration": %.3f, "tags": ["%x"]},`+"\n", i, r.Intn(1000), r.Float64()*600, r.Int63())
+	}
+	// Text broken up by random runs, so blocks mix matches, Huffman coded
+	// literals and literals left raw
+	var mixed []byte
+	for len(mixed) < 400<<10 {
+		n := r.Intn(5000)
+		mixed = append(mixed, text.String()[len(mixed):len(mixed)+n]...)
+		mixed = append(mixed, random[:r.Intn(3000)]...)
+	}
+
+	// Bytes below 16, a few of them common, for a code table short enough
+	// to write without FSE coding it
+	low := make([]byte, 50000)
+	for i := range low {
+		low[i] = byte(r.Intn(4) * r.Intn(5))
+	}
+	// Four byte words from a list, for blocks of tens of thousands of
+	// short matches
+	var words []byte
+	for len(words) < 400<<10 {
+		w := r.Intn(4000) * 4
+		words = append(words, random[w:w+4]...)
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+		// chunk is how much is written between flushes, 0 for no flushes
+		chunk int
+	}{
+		{"empty", nil, 0},
+		{"short", []byte("hello, world"), 0},
+		{"one byte repeated", bytes.Repeat([]byte{'a'}, 100000), 0},
+		{"text", []byte(text.String()), 0},
+		{"text flushed", []byte(text.String()), 70000},
+		{"random", random, 0},
+		{"low bytes", low, 0},
+		{"short words", words, 0},
+		{"mixed", mixed, 0},
+		{"mixed flushed", mixed, 5000},
+	}
+	var out bytes.Buffer
+	z := NewWriter(&out)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			z.Reset(&out)
+			for p := tt.input; len(p) > 0; {
+				n := len(p)
+				if tt.chunk > 0 {
+					n = min(n, tt.chunk)
+				}
+				if _, err := z.Write(p[:n]); err != nil {
+					t.Fatal(err)
+				}
+				if tt.chunk > 0 {
+					if err := z.Flush(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				p = p[n:]
+			}
+			if err := z.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(zstd, "-d", "-c", "-q")
+			cmd.Stdin = bytes.NewReader(out.Bytes())
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			got, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("decoding %d bytes: %v: %s", out.Len(), err, stderr.String())
+			}
+			if !bytes.Equal(got, tt.input) {
+				t.Fatalf("decoded %d bytes that differ from the %d written", len(got), len(tt.input))
+			}
+			t.Logf("%d bytes compressed to %d", len(tt.input), out.Len())
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Resumes video players where the user stopped and reports their position
+// back as they play. Players are <video data-progress="/api/videos/{id}/progress">
+// elements; data-position is the saved position in seconds.
+(function () {
+	const reportEvery = 10000; // ms
+
+	function report(video, position) {
+		const body = { position: position };
+		if (isFinite(video.duration)) {
+			body.duration = video.duration;
+		}
+		// keepalive lets the last report through as the page unloads
+		fetch(video.dataset.progress, {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify(body),
+			keepalive: true
+		}).catch(err => console.log('Failed to save playback position:', err));
+	}
+
+	function setup(video) {
+		if (video.dataset.playerReady) {
+			return;
+		}
+		video.dataset.playerReady = 'true';
+
+		const saved = parseFloat(video.dataset.position) || 0;
+		if (saved > 0) {
+			video.addEventListener('loadedmetadata', () => { video.currentTime = saved; }, { once: true });
+		}
+
+		let last = 0;
+		video.addEventListener('timeupdate', () => {
+			if (Date.now() - last >= reportEvery) {
+				last = Date.now();
+				report(video, video.currentTime);
+			}
+		});
+		video.addEventListener('pause', () => report(video, video.currentTime));
+		video.addEventListener('ended', () => report(video, video.duration));
+	}
+
+	document.addEventListener('htmx:load', event => {
+		event.detail.elt.querySelectorAll('video[data-progress]').forEach(setup);
+	});
+})();
@@ -1,13 +1,19 @@
 const api = {
-	async sendLink(link, onProgress = null) {
+	async sendLink(link, idempotencyKey = null, onProgress = null, limits = null) {
 		try {
 			const controller = new AbortController();
 			const timeoutId = setTimeout(() => controller.abort(), 300000); // 5 minute timeout
 			
+			// Retries of one submission share its key, so the server queues
+			// the download once however many attempts reach it
+			const headers = { 'Content-Type': 'application/json' };
+			if (idempotencyKey) {
+				headers['Idempotency-Key'] = idempotencyKey;
+			}
 			const resp = await fetch('/', {
 				method: 'POST',
-				headers: { 'Content-Type': 'application/json' },
-				body: JSON.stringify({ "link": link }),
+				headers,
+				body: JSON.stringify(limits ? { "link": link, "limits": limits } : { "link": link }),
 				signal: controller.signal
 			});
 			
@@ -32,9 +38,34 @@ const api = {
 		}
 	},
 	
-	async getVideos() {
+	async getJob(id) {
+		const resp = await fetch(`/api/jobs/${encodeURIComponent(id)}`);
+		const responseData = await this.parseResponse(resp);
+
+		return {
+			ok: resp.ok,
+			status: resp.status,
+			data: responseData
+		};
+	},
+
+	async getVideo(id) {
+		const resp = await fetch(`/api/videos/${encodeURIComponent(id)}`);
+		const responseData = await this.parseResponse(resp);
+
+		return {
+			ok: resp.ok,
+			status: resp.status,
+			data: responseData
+		};
+	},
+
+	// getVideos lists the library, or with a cursor from an earlier
+	// listing only what changed since
+	async getVideos(since = '') {
 		try {
-			const resp = await fetch('/api/videos');
+			const url = since ? `/api/videos?updated_since=${encodeURIComponent(since)}` : '/api/videos';
+			const resp = await fetch(url);
 			const responseData = await this.parseResponse(resp);
 			
 			return {
@@ -98,13 +129,21 @@ function renderVideoCard(video) {
 	const videoItem = document.createElement('div');
 	videoItem.className = 'video-item';
 
+	// Resized server-side so the list doesn't load full-size cover art
+	const thumb = document.createElement('img');
+	thumb.className = 'video-thumb';
+	thumb.loading = 'lazy';
+	thumb.alt = '';
+	thumb.src = `/api/videos/${encodeURIComponent(video.id)}/thumb?w=320`;
+	thumb.addEventListener('error', () => thumb.remove());
+
 	const videoName = document.createElement('div');
 	videoName.className = 'video-name';
 	videoName.textContent = video.title;
 
 	const videoInfo = document.createElement('div');
 	videoInfo.className = 'video-info';
-	videoInfo.innerHTML = `Size: ${formatFileSize(video.size)} | Modified: ${video.modified} | Views: ${formatViewCount(video.views)} | Uploader: ${video.uploader} | <a href="${video.url}" id="video-url"></a>`;
+	videoInfo.innerHTML = `Size: ${formatFileSize(video.size)} | Modified: ${new Date(video.modified).toLocaleString()} | Views: ${formatViewCount(video.views)} | Uploader: ${video.uploader} | <a href="${video.url}" id="video-url"></a>`;
 	videoInfo.querySelector("#video-url").appendChild(newMaterialIcon('link'));
 
 	// Extra info section (visible depending on screen size)
@@ -115,8 +154,9 @@ function renderVideoCard(video) {
 	const vidDescHead = document.createElement('span');
 	vidDescHead.innerHTML = 'Description:<br>';
 
+	// Listings leave descriptions out; it's fetched the first time it's shown
 	const vidDesc = document.createElement('p');
-	vidDesc.innerHTML = `${formatDescription(video.description)}`;
+	let descLoaded = false;
 
 	videoExtraInfo.appendChild(vidDescHead);
 	videoExtraInfo.appendChild(vidDesc);
@@ -128,8 +168,19 @@ function renderVideoCard(video) {
 	toggleButton.className = 'toggle-description-button';
 	toggleButton.appendChild(showIcon);
 
-	toggleButton.addEventListener('click', () => {
+	toggleButton.addEventListener('click', async () => {
 		const isVisible = videoExtraInfo.style.display === 'block';
+		if (!isVisible && !descLoaded) {
+			descLoaded = true;
+			vidDesc.textContent = 'Loading...';
+			try {
+				const result = await api.getVideo(video.id);
+				vidDesc.innerHTML = result.ok ? formatDescription(result.data.description || '') : 'Failed to load the description.';
+			} catch {
+				vidDesc.textContent = 'Failed to load the description.';
+				descLoaded = false;
+			}
+		}
 		videoExtraInfo.style.display = isVisible ? 'none' : 'block';
 
 		toggleButton.innerHTML = ''
@@ -137,10 +188,11 @@ function renderVideoCard(video) {
 	});
 
 	const downloadLink = document.createElement('a');
-	downloadLink.href = `/videos/${encodeURIComponent(video.filename)}`;
+	downloadLink.href = `/videos/${video.filename.split('/').map(encodeURIComponent).join('/')}`;
 	downloadLink.textContent = 'Download';
 	downloadLink.className = 'download-link';
 
+	videoItem.appendChild(thumb);
 	videoItem.appendChild(videoName);
 	videoItem.appendChild(videoInfo);
 	videoItem.appendChild(toggleButton);
@@ -287,6 +339,14 @@ class RetryManager {
 
 const retryManager = new RetryManager();
 
+// newIdempotencyKey returns a random key for one download submission
+function newIdempotencyKey() {
+	if (window.crypto && crypto.randomUUID) {
+		return crypto.randomUUID();
+	}
+	return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+}
+
 document.addEventListener("DOMContentLoaded", () => {
 	console.log("Script loaded");
 
@@ -299,10 +359,32 @@ document.addEventListener("DOMContentLoaded", () => {
 		await handleVideoSubmission();
 	});
 
+	// Describe the link once typing pauses
+	let probeTimer;
+	linkInput.addEventListener('input', () => {
+		clearTimeout(probeTimer);
+		probeTimer = setTimeout(() => probeLink(linkInput.value.trim()), 600);
+	});
+
 	// Load videos on page load
 	loadVideos();
 });
 
+// playlistLimits reads the playlist fields shown for playlist links, or
+// returns null when they're hidden or empty
+function playlistLimits() {
+	const fields = document.getElementById('playlist-limits');
+	if (fields.hidden) return null;
+	const limits = {};
+	const maxItems = parseInt(fields.querySelector('[name="max_items"]').value, 10);
+	if (maxItems > 0) limits.max_items = maxItems;
+	for (const name of ['after', 'before']) {
+		const value = fields.querySelector(`[name="${name}"]`).value;
+		if (value) limits[name] = value;
+	}
+	return Object.keys(limits).length ? limits : null;
+}
+
 async function handleVideoSubmission() {
 	const linkInput = document.getElementById('link');
 	const link = linkInput.value.trim();
@@ -338,10 +420,12 @@ async function handleVideoSubmission() {
 		updateMessageProgress(progressMessage, progress);
 	}, 500);
 	
+	const idempotencyKey = newIdempotencyKey();
+	const limits = playlistLimits();
 	try {
 		const response = await retryManager.execute(
 			`submit-${link}`,
-			() => api.sendLink(link),
+			() => api.sendLink(link, idempotencyKey, null, limits),
 			(attempt, maxAttempts, delay) => {
 				removeMessage(progressMessage);
 				displayMessage(
@@ -371,13 +455,8 @@ async function handleVideoSubmission() {
 						}
 					});
 				} else {
-					displayMessage('Video download started successfully!', 'success');
 					linkInput.value = ''; // Clear the input
-					
-					// Refresh video list after a delay to show new video
-					setTimeout(() => {
-						loadVideos();
-					}, 2000);
+					trackJob(response.data.job_id, link);
 				}
 			} else {
 				const errorMsg = api.getErrorMessage(response.status, response.data);
@@ -424,11 +503,245 @@ async function handleVideoSubmission() {
 	}
 }
 
+// probeLink asks the server what a link points at and adapts the form:
+// playlists and live streams get their own button label, and links no
+// backend recognises are flagged before they're submitted
+let latestProbe = '';
+async function probeLink(link) {
+	const hint = document.getElementById('link-probe');
+	const preview = document.getElementById('link-preview');
+	const submit = document.querySelector('#video-form input[type="submit"]');
+	latestProbe = link;
+	hint.hidden = true;
+	preview.hidden = true;
+	hint.classList.remove('unsupported');
+	submit.value = 'Download';
+	document.getElementById('playlist-limits').hidden = true;
+
+	try {
+		new URL(link);
+	} catch {
+		return;
+	}
+
+	hint.textContent = 'Checking link...';
+	hint.hidden = false;
+	let probe;
+	try {
+		const resp = await fetch(`/api/probe?url=${encodeURIComponent(link)}`);
+		probe = await resp.json();
+		if (!resp.ok) {
+			probe = null;
+		}
+	} catch {
+		probe = null;
+	}
+	// A newer link was typed while this one was checked
+	if (link !== latestProbe) return;
+
+	if (!probe) {
+		hint.hidden = true;
+		return;
+	}
+	if (!probe.supported) {
+		hint.textContent = probe.error || 'This link is not supported';
+		hint.classList.add('unsupported');
+		return;
+	}
+
+	const parts = [probe.extractor || probe.backend];
+	switch (probe.kind) {
+		case 'playlist':
+			parts.push(probe.entries ? `playlist of ${probe.entries} videos` : 'playlist');
+			submit.value = 'Download playlist';
+			document.getElementById('playlist-limits').hidden = false;
+			break;
+		case 'live':
+			parts.push('live stream');
+			submit.value = 'Record live stream';
+			break;
+		case 'file':
+			parts.push('direct file');
+			break;
+		default:
+			parts.push('video');
+	}
+	if (probe.starts_at) parts.push(`starts ${new Date(probe.starts_at).toLocaleString()}`);
+	if (probe.clip_of) parts.push(`clip of ${probe.clip_of}`);
+	if (probe.title) parts.push(probe.title);
+	if (probe.estimated_size) parts.push(`about ${formatFileSize(probe.estimated_size)}`);
+	hint.textContent = parts.join(' · ');
+
+	if (probe.kind !== 'file') {
+		showPreview(link);
+	}
+}
+
+// showPreview renders a card for the link from /api/preview, which
+// answers from the probe just made
+async function showPreview(link) {
+	const preview = document.getElementById('link-preview');
+	let card;
+	try {
+		const resp = await fetch(`/api/preview?url=${encodeURIComponent(link)}`);
+		if (!resp.ok) return;
+		card = await resp.json();
+	} catch {
+		return;
+	}
+	if (link !== latestProbe) return;
+
+	preview.replaceChildren();
+	if (card.thumbnail) {
+		const thumb = document.createElement('img');
+		thumb.src = card.thumbnail;
+		thumb.alt = '';
+		thumb.loading = 'lazy';
+		thumb.referrerPolicy = 'no-referrer';
+		thumb.addEventListener('error', () => thumb.remove());
+		preview.appendChild(thumb);
+	}
+	const info = document.createElement('div');
+	const title = document.createElement('strong');
+	title.textContent = card.title;
+	info.appendChild(title);
+	const details = [card.uploader, card.duration ? formatSeconds(card.duration) : ''].filter(Boolean);
+	if (card.estimate) details.push(estimateText(card.estimate));
+	if (details.length) {
+		const meta = document.createElement('p');
+		meta.textContent = details.join(' · ');
+		info.appendChild(meta);
+	}
+	preview.appendChild(info);
+	preview.hidden = false;
+}
+
+// formatSeconds writes a duration as h:mm:ss or m:ss
+function formatSeconds(total) {
+	total = Math.round(total);
+	const h = Math.floor(total / 3600);
+	const m = Math.floor((total % 3600) / 60);
+	const s = String(total % 60).padStart(2, '0');
+	return h ? `${h}:${String(m).padStart(2, '0')}:${s}` : `${m}:${s}`;
+}
+
+// estimateText describes a download's expected size and transfer time
+function estimateText(estimate) {
+	let text = `about ${formatFileSize(estimate.size)}`;
+	if (estimate.seconds) text += `, ${formatSeconds(estimate.seconds)} to download`;
+	return text;
+}
+
+// trackJob polls a queued download until it completes or fails
+function trackJob(jobId, link) {
+	const statusMessage = displayMessage('Download queued...', 'loading', {
+		showProgress: true,
+		persistent: true
+	});
+	const statusText = statusMessage.querySelector('.message-text');
+
+	let progress = 0;
+	let finished = false;
+
+	const stageText = {
+		fetching_metadata: 'Fetching video info...',
+		downloading: 'Downloading...',
+		postprocessing: 'Processing...'
+	};
+
+	// update shows the job's state, returning true once it has finished
+	const update = (job) => {
+		if (finished) return true;
+		switch (job.state) {
+			case 'queued':
+				if (job.scheduled_for && Date.parse(job.scheduled_for) > Date.now()) {
+					statusText.textContent = `Waiting for the premiere, until ${new Date(job.scheduled_for).toLocaleString()}...`;
+					return false;
+				}
+				statusText.textContent = job.estimate
+					? `Download queued (${estimateText(job.estimate)})...`
+					: 'Download queued...';
+				return false;
+			case 'running':
+			case 'retrying':
+				statusText.textContent = job.state === 'retrying'
+					? `Retrying download (attempt ${job.attempts.length + 1})...`
+					: stageText[job.stage] || 'Downloading...';
+				progress = job.transfer
+					? Math.min(99, job.transfer.percent)
+					: Math.min(90, progress + Math.random() * 10);
+				updateMessageProgress(statusMessage, progress);
+				return false;
+		}
+
+		finished = true;
+		updateMessageProgress(statusMessage, 100);
+		removeMessage(statusMessage);
+
+		if (job.state === 'completed') {
+			displayMessage('Video downloaded successfully!', 'success');
+			loadVideos();
+		} else if (job.state === 'needs_auth') {
+			displayMessage('This video needs a signed-in account. Store cookies for the site, then retry the job from the queue.', 'error', {
+				persistent: true
+			});
+		} else {
+			const last = job.attempts[job.attempts.length - 1];
+			displayMessage(`Download failed: ${last ? last.error : 'unknown error'}`, 'error', {
+				persistent: true,
+				onRetry: () => {
+					document.getElementById('link').value = link;
+					handleVideoSubmission();
+				}
+			});
+		}
+		return true;
+	};
+
+	const poll = () => {
+		const timer = setInterval(async () => {
+			let response;
+			try {
+				response = await api.getJob(jobId);
+			} catch (error) {
+				console.error('Error polling job:', error);
+				return; // Try again on the next tick
+			}
+			if (response.ok && update(response.data)) {
+				clearInterval(timer);
+			}
+		}, 2000);
+	};
+
+	// The server pushes the job's changes; polling takes over if the
+	// socket can't be opened or drops before the job is done
+	const scheme = location.protocol === 'https:' ? 'wss' : 'ws';
+	let socket;
+	try {
+		socket = new WebSocket(`${scheme}://${location.host}/api/jobs/ws?ids=${encodeURIComponent(jobId)}`);
+	} catch (error) {
+		poll();
+		return;
+	}
+	socket.addEventListener('message', (event) => {
+		if (update(JSON.parse(event.data))) socket.close();
+	});
+	socket.addEventListener('close', () => {
+		if (!finished) poll();
+	});
+}
+
+// library is the listing as last synced, so reloads only fetch changes
+const library = {
+	cursor: '',
+	videos: new Map()
+};
+
 async function loadVideos() {
 	try {
 		const response = await retryManager.execute(
 			'load-videos',
-			() => api.getVideos(),
+			() => api.getVideos(library.cursor),
 			(attempt, maxAttempts) => {
 				displayMessage(
 					`Failed to load videos. Retrying... (${attempt}/${maxAttempts})`, 
@@ -439,7 +752,21 @@ async function loadVideos() {
 		);
 		
 		if (response.ok) {
-			displayVideos(response.data);
+			// A full listing replaces what we had; changes are merged in
+			if (!library.cursor || response.response.headers.get('X-Library-Full')) {
+				library.videos.clear();
+			}
+			response.data.forEach(video => {
+				if (video.removed) {
+					library.videos.delete(video.id);
+				} else {
+					library.videos.set(video.id, video);
+				}
+			});
+			library.cursor = response.response.headers.get('X-Library-Cursor') || '';
+			const videos = [...library.videos.values()]
+				.sort((a, b) => Date.parse(b.modified) - Date.parse(a.modified));
+			displayVideos(videos);
 		} else {
 			const errorMsg = api.getErrorMessage(response.status, response.data);
 			displayMessage(`Failed to load videos: ${errorMsg}`, 'error', {
@@ -466,7 +793,7 @@ function displayVideos(videos) {
 	console.log(videos);
 
 	// Clear existing videos (but keep messages)
-	const existingVideos = container.querySelectorAll('.video-item');
+	const existingVideos = container.querySelectorAll('.videos-list, .no-videos');
 	existingVideos.forEach(item => item.remove());
 
 	if (videos.length === 0) {